@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
@@ -78,10 +79,50 @@ func ensureDatabaseExists(ctx context.Context, cfg config.Config) error {
 	return err
 }
 
+// Exit codes of a failed start, so a supervisor can tell a bad configuration
+// from an unreachable database without reading the log.
+const (
+	exitFailure      = 1
+	exitConfig       = 2
+	exitDatabase     = 3
+	exitSchema       = 4
+	exitStartupCheck = 5
+)
+
+// startupError is an error from run with the exit code it maps to.
+type startupError struct {
+	code int
+	err  error
+}
+
+func (e *startupError) Error() string { return e.err.Error() }
+func (e *startupError) Unwrap() error { return e.err }
+
+func startupFailed(code int, format string, args ...any) error {
+	return &startupError{code: code, err: fmt.Errorf(format, args...)}
+}
+
 func main() {
+	log.SetOutput(os.Stdout)
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	if err := run(); err != nil {
+		code := exitFailure
+		var se *startupError
+		if errors.As(err, &se) {
+			code = se.code
+		}
+		log.Printf("fatal: %v", err)
+		os.Exit(code)
+	}
+}
+
+// run starts the service and serves until the listener fails. Startup
+// failures are returned rather than panicking, so deferred cleanup runs and
+// main can exit with a code that says what failed.
+func run() error {
 	cfg, err := config.Load()
 	if err != nil {
-		panic(err)
+		return startupFailed(exitConfig, "config: %w", err)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -91,26 +132,64 @@ func main() {
 	if err != nil {
 		if cfg.AutoCreateDB && databaseDoesNotExist(err) {
 			if err2 := ensureDatabaseExists(ctx, cfg); err2 != nil {
-				panic(err2)
+				return startupFailed(exitDatabase, "database: create: %w", err2)
 			}
 			db, err = connectDB(ctx, cfg.DatabaseURL)
 		}
 	}
 	if err != nil {
-		panic(err)
+		return startupFailed(exitDatabase, "database: %w", err)
 	}
 	defer db.Close()
+	db.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	db.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
 
 	pg := store.NewPostgresStore(db)
-	if err := pg.EnsureSchema(ctx); err != nil {
-		panic(err)
+	pg.QueryTimeout = cfg.DBQueryTimeout
+	pg.SlowQuery = cfg.DBSlowQuery
+	if cfg.ManualMigrations {
+		pending, err := pg.PendingMigrations(ctx)
+		if err != nil {
+			return startupFailed(exitSchema, "schema: %w", err)
+		}
+		if len(pending) > 0 {
+			return startupFailed(exitSchema, "startup: pending schema migrations %v and DB_MANUAL_MIGRATIONS is set; apply them and restart", pending)
+		}
+	} else if err := pg.EnsureSchema(ctx); err != nil {
+		return startupFailed(exitSchema, "schema: %w", err)
 	}
 
 	hc := &http.Client{Timeout: 30 * time.Second}
 
-	gateway := &services.GatewayClient{BaseURL: cfg.ToolGatewayURL, APIKey: cfg.ToolGatewayAPIKey, HTTP: hc}
+	breaker := &services.CircuitBreaker{Threshold: cfg.BreakerThreshold, Window: cfg.BreakerWindow, Cooldown: cfg.BreakerCooldown}
+	creds := services.NewGatewayCredentials(cfg.ToolGatewayAPIKey, cfg.ToolGatewayAPIKeySecondary)
+	creds.File = cfg.GatewayAPIKeyFile
+	creds.Load = func() (string, string, error) { return config.LoadGatewayKeys(cfg.GatewayAPIKeyFile) }
+	gateway := &services.GatewayClient{BaseURL: cfg.ToolGatewayURL, Credentials: creds, HTTP: hc, Breaker: breaker}
+	if cfg.ETagCache {
+		gateway.ETags = services.NewETagCache(cfg.ETagCacheMaxBytes, cfg.ETagCacheMaxAge, cfg.ETagCacheExclude)
+	}
 	openai := &services.OpenAIClient{APIKey: cfg.OpenAIAPIKey, Model: cfg.OpenAIModel, HTTP: hc}
-	catalog := services.NewToolCatalogWithKey(cfg.ToolGatewayURL, cfg.ToolGatewayAPIKey, hc, 2*time.Minute)
+	catalog := services.NewToolCatalog(cfg.ToolGatewayURL, hc, 2*time.Minute)
+	catalog.Credentials = creds
+
+	limits := services.Limits{
+		PopPageSize:       cfg.PopPageSize,
+		PopMaxPages:       cfg.PopMaxPages,
+		ListPageSize:      cfg.ListPageSize,
+		MetricsMaxPages:   cfg.MetricsMaxPages,
+		DisplayTopN:       cfg.DisplayTopN,
+		FullListMax:       cfg.FullListMax,
+		StepBodyClip:      cfg.StepBodyClip,
+		ToolBodyClip:      cfg.ToolBodyClip,
+		HistoryLimit:      cfg.HistoryLimit,
+		PopRawRangeDays:   cfg.PopRawRangeDays,
+		PopRangeMaxChunks: cfg.PopRangeMaxChunks,
+	}
+	if n := limits.Normalized(); n != limits {
+		log.Printf("limits: using %+v (configured %+v, maxima %+v)", n, limits, services.MaxLimits)
+	}
 
 	chatSvc := &services.ChatService{
 		MockMode:     cfg.MockMode,
@@ -118,22 +197,157 @@ func main() {
 		OpenAI:       openai,
 		Store:        pg,
 		Catalog:      catalog,
+		Alerts:       pg,
+		Targets:      pg,
+		Queries:      pg,
+		Notes:        pg,
+		Aliases:      pg,
+		Extracts:     pg,
+		Imports:      pg,
+		Thresholds:   pg,
+		Outbox:       pg,
+		Commands:     pg,
+		Debug:        pg,
 		MaxToolCalls: 6,
 		MaxToolBytes: 1_000_000,
+
+		MaxUploadFileBytes:      cfg.CreativeUploadMaxFileBytes,
+		MaxUploadTotalBytes:     cfg.CreativeUploadMaxTotalBytes,
+		MaxPatternHosts:         cfg.HostPatternMaxHosts,
+		Limits:                  limits,
+		HandlerTimeout:          cfg.HandlerTimeout,
+		ToolLoopTimeout:         cfg.ToolLoopTimeout,
+		DryRunMutations:         cfg.MutationsDryRun,
+		ExportInlineMaxRows:     cfg.ExportInlineMaxRows,
+		ExtractTTL:              cfg.ExtractTTL,
+		ChurnWindowDays:         cfg.ChurnWindowDays,
+		ChurnThresholdPercent:   cfg.ChurnThresholdPercent,
+		ChurnMaxPosters:         cfg.ChurnMaxPosters,
+		DedupWait:               cfg.DedupWait,
+		DebugMaxBytes:           int(cfg.DebugMaxBytes),
+		DebugRetention:          cfg.DebugRetention,
+		StepBodyRetention:       cfg.StepBodyRetention,
+		VenueLeaderboardTimeout: cfg.VenueLeaderboardTimeout,
+		SizeUnits:               cfg.SizeUnits,
+		DeviceCommands:          cfg.DeviceCommands,
+		DeviceCommandHosts:      cfg.DeviceCommandHosts,
+		CreativeReuseMaxPages:   cfg.CreativeReuseMaxPages,
+		Usage:                   pg,
+		Feedback:                pg,
+		Snapshots:               pg,
+		DeviceSnapshots:         pg,
+		AnswerDiffPercent:       cfg.AnswerDiffPercent,
+		TelemetryStaleAfter:     cfg.TelemetryStaleAfter,
+		TelemetryThresholds:     cfg.TelemetryThresholds,
+		BaselineAnnotations:     cfg.BaselineAnnotations,
+		TrendHints:              cfg.TrendHints,
+		Dayparts:                cfg.Dayparts,
+		HandlerFlags:            cfg.HandlerFlags,
+		ToolLoopTrace:           cfg.ToolLoopTrace,
+		ToolLoopSensitiveParams: cfg.ToolLoopSensitiveParams,
+		Idempotency:             pg,
+		IdempotencyRetention:    cfg.IdempotencyRetention,
+		LanguageAliases:         cfg.LanguageAliases,
+	}
+
+	for name := range cfg.HandlerFlags {
+		if !services.IsHandlerName(name) {
+			log.Printf("handler flags: unknown handler %q ignored", name)
+		}
+	}
+
+	check := &services.StartupCheck{
+		Environment:    cfg.Environment,
+		Gateway:        gateway,
+		GatewayURL:     cfg.ToolGatewayURL,
+		OpenAIKey:      cfg.OpenAIAPIKey,
+		Model:          cfg.OpenAIModel,
+		MockMode:       cfg.MockMode,
+		DatabaseURL:    cfg.DatabaseURL,
+		ExpectedDBHost: cfg.ExpectedDatabaseHost,
+		HandlerFlags:   cfg.HandlerFlags,
+		Timeout:        cfg.StartupCheckTimeout,
+	}
+	log.Print(check.Summary())
+	if problems := check.Run(context.Background()); len(problems) > 0 {
+		for _, p := range problems {
+			log.Printf("STARTUP CHECK FAILED %s", p)
+		}
+		if cfg.StrictStartup {
+			return startupFailed(exitStartupCheck, "startup: %d check(s) failed and STRICT_STARTUP is set; exiting", len(problems))
+		}
+		log.Printf("startup: %d check(s) failed; serving anyway (set STRICT_STARTUP=true to exit instead)", len(problems))
 	}
 
 	chatHandlers := &handlers.ChatHandlers{Chat: chatSvc}
-	streamHandlers := &handlers.StreamHandlers{Chat: chatSvc}
-	convHandlers := &handlers.ConversationHandlers{Store: pg}
+	streamHandlers := &handlers.StreamHandlers{Chat: chatSvc, Heartbeat: cfg.SSEHeartbeatInterval}
+	convHandlers := &handlers.ConversationHandlers{Store: pg, Chat: chatSvc}
+	adminHandlers := &handlers.AdminHandlers{Chat: chatSvc, Debug: pg, Credentials: creds, Usage: pg, Feedback: pg, Outbox: pg}
+	if cfg.StepSpillover {
+		chatSvc.StepBodies = pg
+		adminHandlers.StepBodies = pg
+		stepJanitor := &services.StepBodyJanitor{Store: pg, Interval: 10 * time.Minute}
+		go stepJanitor.Run(context.Background())
+	}
+	webhooks := &services.WebhookGuard{AllowedHosts: cfg.WebhookAllowedHosts}
+	alertHandlers := &handlers.AlertHandlers{Store: pg, Webhooks: webhooks}
+	drift := &services.SchemaDriftDetector{Catalog: catalog, Interval: cfg.SchemaDriftInterval}
+	healthHandlers := &handlers.HealthHandlers{Breaker: breaker, Chat: chatSvc, Drift: drift}
+	targetHandlers := &handlers.TargetHandlers{Store: pg}
+	docsHandlers := &handlers.DocsHandlers{}
+	queryHandlers := &handlers.SavedQueryHandlers{Store: pg, Chat: chatSvc}
+	numberHandlers := &handlers.QueryHandlers{Chat: chatSvc}
 
-	h := routes.NewRouter(cfg, chatHandlers, streamHandlers, convHandlers)
+	h := routes.NewRouter(cfg, chatHandlers, streamHandlers, convHandlers, adminHandlers, alertHandlers, healthHandlers, targetHandlers, docsHandlers, queryHandlers, numberHandlers)
 
-	log.SetOutput(os.Stdout)
-	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	evaluator := &services.AlertEvaluator{
+		Gateway:    gateway,
+		Rules:      pg,
+		Store:      pg,
+		Interval:   cfg.AlertEvalInterval,
+		WebhookURL: cfg.AlertWebhookURL,
+		Webhooks:   webhooks,
+		Limits:     limits,
+		Outbox:     pg,
+	}
+	go evaluator.Run(context.Background())
+
+	dispatcher := &services.OutboxDispatcher{
+		Store:       pg,
+		Usage:       pg,
+		Audits:      pg,
+		Webhooks:    webhooks,
+		MaxAttempts: cfg.OutboxMaxAttempts,
+		Backoff:     cfg.OutboxBackoff,
+	}
+	go dispatcher.Run(context.Background())
+
+	if cfg.AsyncWorkers > 0 {
+		chatSvc.Jobs = pg
+		chatSvc.MaxQueuedJobs = cfg.AsyncMaxQueued
+		chatSvc.Webhooks = webhooks
+		jobWorker := &services.ChatJobWorker{Chat: chatSvc, Store: pg, Workers: cfg.AsyncWorkers}
+		go jobWorker.Run(context.Background())
+		jobJanitor := &services.ChatJobJanitor{Store: pg, Retention: cfg.AsyncJobRetention, Interval: time.Hour}
+		go jobJanitor.Run(context.Background())
+	}
+
+	go drift.Run(context.Background())
+
+	janitor := &services.DebugJanitor{Store: pg, Interval: time.Hour}
+	go janitor.Run(context.Background())
+	idempotencyJanitor := &services.IdempotencyJanitor{Store: pg, Interval: time.Hour}
+	go idempotencyJanitor.Run(context.Background())
+	usageJanitor := &services.UsageJanitor{Store: pg, Retention: cfg.UsageRetention, Interval: time.Hour}
+	go usageJanitor.Run(context.Background())
+	extractJanitor := &services.ExtractJanitor{Store: pg, Interval: time.Hour}
+	go extractJanitor.Run(context.Background())
+	go creds.Watch(context.Background(), cfg.GatewayKeyReloadInterval)
 
 	addr := ":" + cfg.Port
 	log.Printf("openai-agent-service listening on %s (tool_gateway=%s)", addr, cfg.ToolGatewayURL)
 	if err := http.ListenAndServe(addr, h); err != nil {
-		panic(err)
+		return fmt.Errorf("listen on %s: %w", addr, err)
 	}
+	return nil
 }
@@ -1,14 +1,23 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
 )
 
 type Config struct {
 	Port              string
-	AgentAPIKeys      map[string]struct{}
+	// AgentAPIKeys maps each accepted X-API-Key to its role
+	// (models.KeyRoleReadOnly or models.KeyRoleAdmin).
+	AgentAPIKeys      map[string]string
 	OpenAIAPIKey      string
 	OpenAIModel       string
 	ToolGatewayURL    string
@@ -16,8 +25,113 @@ type Config struct {
 	MockMode          bool
 	DatabaseURL       string
 	AutoCreateDB      bool
+	// ManualMigrations refuses startup while schema migrations are pending
+	// instead of applying them.
+	ManualMigrations  bool
 	MaintenanceDB     string
 	CORSAllowedOrigins string
+
+	CreativeUploadMaxFileBytes  int64
+	CreativeUploadMaxTotalBytes int64
+	CompressionLevel            int
+	HostPatternMaxHosts         int
+	SSEHeartbeatInterval        time.Duration
+	MutationsDryRun             bool
+	AlertEvalInterval           time.Duration
+	AlertWebhookURL             string
+	// WebhookAllowedHosts, when set, are the only hosts alert and async job
+	// webhooks may be posted to; otherwise any public address is allowed.
+	WebhookAllowedHosts         []string
+	OutboxMaxAttempts           int
+	OutboxBackoff               time.Duration
+	// AsyncWorkers is the number of async chat jobs run at once per replica;
+	// 0 turns async mode off.
+	AsyncWorkers                int
+	AsyncMaxQueued              int
+	AsyncJobRetention           time.Duration
+	// ExportInlineMaxRows is the largest POP export attached to a chat
+	// answer; larger ones get an extract link valid for ExtractTTL.
+	ExportInlineMaxRows         int64
+	ExtractTTL                  time.Duration
+	PopPageSize                 int
+	PopMaxPages                 int
+	ListPageSize                int
+	MetricsMaxPages             int
+	DisplayTopN                 int
+	FullListMax                 int
+	StepBodyClip                int
+	ToolBodyClip                int
+	HistoryLimit                int
+	PopRawRangeDays             int
+	PopRangeMaxChunks           int
+	HandlerTimeout              time.Duration
+	ToolLoopTimeout             time.Duration
+	BreakerThreshold            int
+	BreakerWindow               time.Duration
+	BreakerCooldown             time.Duration
+	ChurnWindowDays             int
+	ChurnThresholdPercent       int
+	AnswerDiffPercent           int
+	ChurnMaxPosters             int
+	DedupWait                   time.Duration
+	DebugMaxBytes               int64
+	DebugRetention              time.Duration
+	StepSpillover               bool
+	StepBodyRetention           time.Duration
+	DocsEnabled                 bool
+	VenueLeaderboardTimeout     time.Duration
+	SizeUnits                   string
+	// GatewayAPIKeyFile, when set, holds the tool gateway keys (primary on the
+	// first line, optional secondary on the second) and is watched for changes.
+	GatewayAPIKeyFile          string
+	ToolGatewayAPIKeySecondary string
+	GatewayKeyReloadInterval   time.Duration
+	DBMaxOpenConns             int
+	DBMaxIdleConns             int
+	DBConnMaxLifetime          time.Duration
+	DBQueryTimeout             time.Duration
+	DBSlowQuery                time.Duration
+	DeviceCommands             []string
+	DeviceCommandHosts         []string
+	CreativeReuseMaxPages      int
+	UsageRetention             time.Duration
+	TelemetryStaleAfter        time.Duration
+	// TelemetryThresholds are the service-wide warning and critical tiers
+	// telemetry answers are annotated against; API keys may override them.
+	TelemetryThresholds        []models.TelemetryThreshold
+	// BaselineAnnotations adds each host's usual range to telemetry answers.
+	BaselineAnnotations        bool
+	// TrendHints adds a change-since-yesterday hint to city and region
+	// summaries; on by default, off with TREND_HINTS=false.
+	TrendHints                 bool
+	// Dayparts override or add to the built-in time-of-day windows POP
+	// questions can name (POP_DAYPARTS).
+	Dayparts                   []models.Daypart
+	HandlerFlags               map[string]bool
+	ToolLoopTrace              bool
+	ToolLoopSensitiveParams    []string
+	IdempotencyRetention       time.Duration
+	// Environment names the deployment (APP_ENV) in the startup summary.
+	Environment          string
+	StrictStartup        bool
+	ExpectedDatabaseHost *regexp.Regexp
+	StartupCheckTimeout  time.Duration
+	LanguageAliases      map[string]map[string]string
+	SchemaDriftInterval  time.Duration
+	// JWKSURL enables bearer JWT callers alongside the API keys. Tokens must
+	// be signed by a key it serves and carry JWTIssuer and JWTAudience.
+	JWKSURL       string
+	JWTIssuer     string
+	JWTAudience   string
+	JWTOwnerClaim string
+	JWTRole       string
+	JWKSRefresh   time.Duration
+	// ETagCache revalidates repeat gateway GETs with If-None-Match; on by
+	// default, off with GATEWAY_ETAG_CACHE=false.
+	ETagCache         bool
+	ETagCacheMaxBytes int64
+	ETagCacheMaxAge   time.Duration
+	ETagCacheExclude  []string
 }
 
 func getenv(key, def string) string {
@@ -28,34 +142,308 @@ func getenv(key, def string) string {
 	return v
 }
 
-func parseCSVSet(v string) map[string]struct{} {
-	out := make(map[string]struct{})
+func getenvInt64(key string, def int64) int64 {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+// LoadGatewayKeys reads the tool gateway keys from file when it is set, and
+// from TOOL_GATEWAY_API_KEY and TOOL_GATEWAY_API_KEY_SECONDARY otherwise. Key
+// files hold the primary key on the first non-empty line and the secondary on
+// the next; lines starting with # are ignored.
+func LoadGatewayKeys(file string) (primary, secondary string, err error) {
+	if strings.TrimSpace(file) == "" {
+		return strings.TrimSpace(os.Getenv("TOOL_GATEWAY_API_KEY")), strings.TrimSpace(os.Getenv("TOOL_GATEWAY_API_KEY_SECONDARY")), nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", "", fmt.Errorf("read GATEWAY_API_KEY_FILE: %w", err)
+	}
+	var keys []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if len(keys) > 0 {
+		primary = keys[0]
+	}
+	if len(keys) > 1 {
+		secondary = keys[1]
+	}
+	return primary, secondary, nil
+}
+
+// parseAPIKeys parses AGENT_API_KEYS: comma-separated keys, each optionally
+// followed by ":readonly" or ":admin". Keys without a role are admin, as all
+// keys were before roles existed. A key that itself contains ':' must be
+// given a role ("a:b:admin").
+func parseAPIKeys(v string) (map[string]string, error) {
+	out := make(map[string]string)
 	for _, part := range strings.Split(v, ",") {
 		s := strings.TrimSpace(part)
 		if s == "" {
 			continue
 		}
-		out[s] = struct{}{}
+		key, role := s, models.KeyRoleAdmin
+		if i := strings.LastIndex(s, ":"); i >= 0 {
+			key, role = strings.TrimSpace(s[:i]), strings.ToLower(strings.TrimSpace(s[i+1:]))
+			if role != models.KeyRoleReadOnly && role != models.KeyRoleAdmin {
+				return nil, fmt.Errorf("AGENT_API_KEYS: unknown role %q (use readonly or admin)", role)
+			}
+			if key == "" {
+				return nil, errors.New("AGENT_API_KEYS: empty key before a role")
+			}
+		}
+		out[key] = role
+	}
+	return out, nil
+}
+
+// parseCSVList splits a comma-separated list, dropping empty entries and
+// lower-casing the rest.
+func parseCSVList(v string) []string {
+	var out []string
+	for _, part := range strings.Split(v, ",") {
+		if s := strings.ToLower(strings.TrimSpace(part)); s != "" {
+			out = append(out, s)
+		}
 	}
 	return out
 }
 
+// LoadHandlerFlags reads per-handler defaults: a JSON object of handler name
+// to enabled from file, then "name=true|false" pairs from env, which win.
+func LoadHandlerFlags(env, file string) (map[string]bool, error) {
+	flags := map[string]bool{}
+	if strings.TrimSpace(file) != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read HANDLER_FLAGS_FILE: %w", err)
+		}
+		if err := json.Unmarshal(b, &flags); err != nil {
+			return nil, fmt.Errorf("parse HANDLER_FLAGS_FILE: %w", err)
+		}
+	}
+	for _, part := range strings.Split(env, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, v, ok := strings.Cut(part, "=")
+		on, err := strconv.ParseBool(strings.TrimSpace(v))
+		if !ok || err != nil {
+			return nil, fmt.Errorf("HANDLER_FLAGS: %q is not name=true|false", part)
+		}
+		flags[strings.TrimSpace(name)] = on
+	}
+	return flags, nil
+}
+
+// ParseDayparts parses POP_DAYPARTS: comma-separated "name=HH:MM-HH:MM"
+// windows such as "morning=06:30-09:30,lunch=11:30-13:30". Names are
+// lower-cased; an end of 24:00 means midnight, and an end before the start
+// wraps past midnight.
+func ParseDayparts(v string) ([]models.Daypart, error) {
+	var out []models.Daypart
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, window, ok := strings.Cut(part, "=")
+		name = strings.Join(strings.Fields(strings.ToLower(name)), " ")
+		from, to, ok2 := strings.Cut(window, "-")
+		start, errS := parseClock(from)
+		end, errE := parseClock(to)
+		if !ok || !ok2 || name == "" || errS != nil || errE != nil || start == end || start == 24*60 {
+			return nil, fmt.Errorf("POP_DAYPARTS: %q is not name=HH:MM-HH:MM", part)
+		}
+		out = append(out, models.Daypart{Name: name, Start: start, End: end})
+	}
+	return out, nil
+}
+
+// parseClock reads "HH:MM" (or "HH") as minutes after midnight, up to 24:00.
+func parseClock(s string) (int, error) {
+	h, m, _ := strings.Cut(strings.TrimSpace(s), ":")
+	hh, err := strconv.Atoi(h)
+	if err != nil {
+		return 0, err
+	}
+	mm := 0
+	if m != "" {
+		if mm, err = strconv.Atoi(m); err != nil {
+			return 0, err
+		}
+	}
+	if hh < 0 || mm < 0 || mm > 59 || hh*60+mm > 24*60 {
+		return 0, fmt.Errorf("%q is out of range", s)
+	}
+	return hh*60 + mm, nil
+}
+
+// LoadLanguageAliases reads extra non-English trigger words from file: a JSON
+// object of language code to an object of word (or phrase) to its English
+// equivalent, e.g. {"es": {"pantallitas": "screens"}}.
+func LoadLanguageAliases(file string) (map[string]map[string]string, error) {
+	if strings.TrimSpace(file) == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read LANGUAGE_ALIASES_FILE: %w", err)
+	}
+	var raw map[string]map[string]string
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("parse LANGUAGE_ALIASES_FILE: %w", err)
+	}
+	out := make(map[string]map[string]string, len(raw))
+	for lang, words := range raw {
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if out[lang] == nil {
+			out[lang] = map[string]string{}
+		}
+		for word, en := range words {
+			if w := strings.Join(strings.Fields(strings.ToLower(word)), " "); w != "" {
+				out[lang][w] = strings.TrimSpace(en)
+			}
+		}
+	}
+	return out, nil
+}
+
 func Load() (Config, error) {
 	cfg := Config{
 		Port:              strings.TrimSpace(getenv("PORT", "8091")),
 		OpenAIModel:       strings.TrimSpace(getenv("OPENAI_MODEL", "gpt-4o-mini")),
 		ToolGatewayURL:    strings.TrimSpace(getenv("TOOL_GATEWAY_BASE_URL", "https://tool-gateway.citypost.us")),
 		OpenAIAPIKey:      strings.TrimSpace(os.Getenv("OPENAI_API_KEY")),
-		ToolGatewayAPIKey: strings.TrimSpace(os.Getenv("TOOL_GATEWAY_API_KEY")),
 		MockMode:          strings.EqualFold(strings.TrimSpace(os.Getenv("MOCK_MODE")), "true") || strings.TrimSpace(os.Getenv("MOCK_MODE")) == "1",
 		DatabaseURL:       strings.TrimSpace(os.Getenv("DATABASE_URL")),
 		AutoCreateDB:      strings.EqualFold(strings.TrimSpace(os.Getenv("AUTO_CREATE_DB")), "true") || strings.TrimSpace(os.Getenv("AUTO_CREATE_DB")) == "1",
+		ManualMigrations:  strings.EqualFold(strings.TrimSpace(os.Getenv("DB_MANUAL_MIGRATIONS")), "true") || strings.TrimSpace(os.Getenv("DB_MANUAL_MIGRATIONS")) == "1",
 		MaintenanceDB:     strings.TrimSpace(getenv("MAINTENANCE_DB", "postgres")),
 		CORSAllowedOrigins: strings.TrimSpace(os.Getenv("CORS_ALLOWED_ORIGINS")),
+
+		CreativeUploadMaxFileBytes:  getenvInt64("CREATIVE_UPLOAD_MAX_FILE_BYTES", 50<<20),
+		CreativeUploadMaxTotalBytes: getenvInt64("CREATIVE_UPLOAD_MAX_TOTAL_BYTES", 200<<20),
+		CompressionLevel:            int(getenvInt64("COMPRESSION_LEVEL", -1)),
+		HostPatternMaxHosts:         int(getenvInt64("HOST_PATTERN_MAX_HOSTS", 30)),
+		SSEHeartbeatInterval:        time.Duration(getenvInt64("SSE_HEARTBEAT_SECONDS", 15)) * time.Second,
+		MutationsDryRun:             strings.EqualFold(strings.TrimSpace(os.Getenv("MUTATIONS_DRY_RUN")), "true") || strings.TrimSpace(os.Getenv("MUTATIONS_DRY_RUN")) == "1",
+		AlertEvalInterval:           time.Duration(getenvInt64("ALERT_EVAL_INTERVAL_SECONDS", 60)) * time.Second,
+		AlertWebhookURL:             strings.TrimSpace(os.Getenv("ALERT_WEBHOOK_URL")),
+		WebhookAllowedHosts:         parseCSVList(os.Getenv("WEBHOOK_ALLOWED_HOSTS")),
+		OutboxMaxAttempts:           int(getenvInt64("OUTBOX_MAX_ATTEMPTS", 8)),
+		OutboxBackoff:               time.Duration(getenvInt64("OUTBOX_BACKOFF_SECONDS", 30)) * time.Second,
+		AsyncWorkers:                int(getenvInt64("ASYNC_WORKERS", 4)),
+		AsyncMaxQueued:              int(getenvInt64("ASYNC_MAX_QUEUED_PER_KEY", 20)),
+		AsyncJobRetention:           time.Duration(getenvInt64("ASYNC_JOB_RETENTION_HOURS", 24)) * time.Hour,
+		ExportInlineMaxRows:         getenvInt64("EXPORT_INLINE_MAX_ROWS", 1000),
+		ExtractTTL:                  time.Duration(getenvInt64("EXTRACT_LINK_TTL_HOURS", 24)) * time.Hour,
+		PopPageSize:                 int(getenvInt64("POP_PAGE_SIZE", 200)),
+		PopMaxPages:                 int(getenvInt64("POP_MAX_PAGES", 10)),
+		ListPageSize:                int(getenvInt64("LIST_PAGE_SIZE", 200)),
+		MetricsMaxPages:             int(getenvInt64("METRICS_MAX_PAGES", 10)),
+		DisplayTopN:                 int(getenvInt64("DISPLAY_TOP_N", 10)),
+		FullListMax:                 int(getenvInt64("FULL_LIST_MAX", 100)),
+		StepBodyClip:                int(getenvInt64("STEP_BODY_CLIP", 2000)),
+		ToolBodyClip:                int(getenvInt64("TOOL_BODY_CLIP", 8000)),
+		HistoryLimit:                int(getenvInt64("HISTORY_LIMIT", 50)),
+		PopRawRangeDays:             int(getenvInt64("POP_RAW_RANGE_DAYS", 62)),
+		PopRangeMaxChunks:           int(getenvInt64("POP_RANGE_MAX_CHUNKS", 6)),
+		HandlerTimeout:              time.Duration(getenvInt64("HANDLER_TIMEOUT_SECONDS", 20)) * time.Second,
+		ToolLoopTimeout:             time.Duration(getenvInt64("TOOL_LOOP_TIMEOUT_SECONDS", 60)) * time.Second,
+		BreakerThreshold:            int(getenvInt64("GATEWAY_BREAKER_THRESHOLD", 5)),
+		BreakerWindow:               time.Duration(getenvInt64("GATEWAY_BREAKER_WINDOW_SECONDS", 60)) * time.Second,
+		BreakerCooldown:             time.Duration(getenvInt64("GATEWAY_BREAKER_COOLDOWN_SECONDS", 30)) * time.Second,
+		ETagCache:                   !(strings.EqualFold(strings.TrimSpace(os.Getenv("GATEWAY_ETAG_CACHE")), "false") || strings.TrimSpace(os.Getenv("GATEWAY_ETAG_CACHE")) == "0"),
+		ETagCacheMaxBytes:           getenvInt64("GATEWAY_ETAG_CACHE_MAX_BYTES", 64<<20),
+		ETagCacheMaxAge:             time.Duration(getenvInt64("GATEWAY_ETAG_CACHE_MAX_AGE_SECONDS", 3600)) * time.Second,
+		ETagCacheExclude:            parseCSVList(getenv("GATEWAY_ETAG_CACHE_EXCLUDE", "/pop,/metrics")),
+		ChurnWindowDays:             int(getenvInt64("CHURN_WINDOW_DAYS", 7)),
+		ChurnThresholdPercent:       int(getenvInt64("CHURN_THRESHOLD_PERCENT", 10)),
+		AnswerDiffPercent:           int(getenvInt64("ANSWER_DIFF_PERCENT", 10)),
+		ChurnMaxPosters:             int(getenvInt64("CHURN_MAX_POSTERS", 200)),
+		DedupWait:                   time.Duration(getenvInt64("DEDUP_WAIT_SECONDS", 90)) * time.Second,
+		DebugMaxBytes:               getenvInt64("DEBUG_BUNDLE_MAX_BYTES", 5<<20),
+		DebugRetention:              time.Duration(getenvInt64("DEBUG_BUNDLE_RETENTION_HOURS", 24)) * time.Hour,
+		StepSpillover:               strings.EqualFold(strings.TrimSpace(os.Getenv("STEP_SPILLOVER")), "true") || strings.TrimSpace(os.Getenv("STEP_SPILLOVER")) == "1",
+		StepBodyRetention:           time.Duration(getenvInt64("STEP_BODY_RETENTION_MINUTES", 60)) * time.Minute,
+		VenueLeaderboardTimeout:     time.Duration(getenvInt64("VENUE_LEADERBOARD_TIMEOUT_SECONDS", 60)) * time.Second,
+		SizeUnits:                   strings.ToLower(strings.TrimSpace(getenv("SIZE_UNITS", "binary"))),
+		DocsEnabled:                 strings.EqualFold(strings.TrimSpace(os.Getenv("DOCS_ENABLED")), "true") || strings.TrimSpace(os.Getenv("DOCS_ENABLED")) == "1",
+		GatewayAPIKeyFile:           strings.TrimSpace(os.Getenv("GATEWAY_API_KEY_FILE")),
+		GatewayKeyReloadInterval:    time.Duration(getenvInt64("GATEWAY_KEY_RELOAD_SECONDS", 30)) * time.Second,
+		DBMaxOpenConns:              int(getenvInt64("DB_MAX_OPEN_CONNS", 10)),
+		DBMaxIdleConns:              int(getenvInt64("DB_MAX_IDLE_CONNS", 5)),
+		DBConnMaxLifetime:           time.Duration(getenvInt64("DB_CONN_MAX_LIFETIME_SECONDS", 1800)) * time.Second,
+		DBQueryTimeout:              time.Duration(getenvInt64("DB_QUERY_TIMEOUT_MS", 3000)) * time.Millisecond,
+		DBSlowQuery:                 time.Duration(getenvInt64("DB_SLOW_QUERY_MS", 500)) * time.Millisecond,
+		DeviceCommands:              parseCSVList(getenv("DEVICE_COMMANDS_ALLOWED", "reboot,restart-kiosk-app,screenshot")),
+		DeviceCommandHosts:          parseCSVList(os.Getenv("DEVICE_COMMAND_HOSTS")),
+		CreativeReuseMaxPages:       int(getenvInt64("CREATIVE_REUSE_MAX_PAGES", 10)),
+		UsageRetention:              time.Duration(getenvInt64("USAGE_RETENTION_DAYS", 30)) * 24 * time.Hour,
+		TelemetryStaleAfter:         time.Duration(getenvInt64("TELEMETRY_STALE_MINUTES", 60)) * time.Minute,
+		TelemetryThresholds: []models.TelemetryThreshold{
+			{Metric: models.ThresholdTemperature, Warning: float64(getenvInt64("THRESHOLD_TEMP_WARN_C", 70)), Critical: float64(getenvInt64("THRESHOLD_TEMP_CRIT_C", 85))},
+			{Metric: models.ThresholdDisk, Warning: float64(getenvInt64("THRESHOLD_DISK_WARN_PERCENT", 85)), Critical: float64(getenvInt64("THRESHOLD_DISK_CRIT_PERCENT", 95))},
+			{Metric: models.ThresholdMemory, Warning: float64(getenvInt64("THRESHOLD_MEMORY_WARN_PERCENT", 85)), Critical: float64(getenvInt64("THRESHOLD_MEMORY_CRIT_PERCENT", 95))},
+			{Metric: models.ThresholdBattery, Warning: float64(getenvInt64("THRESHOLD_BATTERY_WARN_PERCENT", 20)), Critical: float64(getenvInt64("THRESHOLD_BATTERY_CRIT_PERCENT", 10))},
+			{Metric: models.ThresholdInputMissing, Warning: float64(getenvInt64("THRESHOLD_INPUT_MISSING_WARN", 1)), Critical: float64(getenvInt64("THRESHOLD_INPUT_MISSING_CRIT", 2))},
+		},
+		BaselineAnnotations:         strings.EqualFold(strings.TrimSpace(os.Getenv("TELEMETRY_BASELINE_ANNOTATIONS")), "true") || strings.TrimSpace(os.Getenv("TELEMETRY_BASELINE_ANNOTATIONS")) == "1",
+		TrendHints:                  !(strings.EqualFold(strings.TrimSpace(os.Getenv("TREND_HINTS")), "false") || strings.TrimSpace(os.Getenv("TREND_HINTS")) == "0"),
+		JWKSURL:                     strings.TrimSpace(os.Getenv("JWKS_URL")),
+		JWTIssuer:                   strings.TrimSpace(os.Getenv("JWT_ISSUER")),
+		JWTAudience:                 strings.TrimSpace(os.Getenv("JWT_AUDIENCE")),
+		JWTOwnerClaim:               strings.TrimSpace(getenv("JWT_OWNER_CLAIM", "sub")),
+		JWTRole:                     strings.ToLower(strings.TrimSpace(getenv("JWT_ROLE", models.KeyRoleReadOnly))),
+		JWKSRefresh:                 time.Duration(getenvInt64("JWKS_REFRESH_SECONDS", 600)) * time.Second,
+		ToolLoopTrace:               strings.EqualFold(strings.TrimSpace(os.Getenv("TOOL_LOOP_TRACE")), "true") || strings.TrimSpace(os.Getenv("TOOL_LOOP_TRACE")) == "1",
+		ToolLoopSensitiveParams:     parseCSVList(getenv("TOOL_LOOP_SENSITIVE_PARAMS", "key,token,secret,password,email,phone")),
+		IdempotencyRetention:        time.Duration(getenvInt64("IDEMPOTENCY_RETENTION_HOURS", 24)) * time.Hour,
+		Environment:                 strings.TrimSpace(os.Getenv("APP_ENV")),
+		StrictStartup:               strings.EqualFold(strings.TrimSpace(os.Getenv("STRICT_STARTUP")), "true") || strings.TrimSpace(os.Getenv("STRICT_STARTUP")) == "1",
+		StartupCheckTimeout:         time.Duration(getenvInt64("STARTUP_CHECK_TIMEOUT_SECONDS", 5)) * time.Second,
+		SchemaDriftInterval:         time.Duration(getenvInt64("SCHEMA_DRIFT_INTERVAL_MINUTES", 60)) * time.Minute,
+	}
+	if pattern := strings.TrimSpace(os.Getenv("EXPECTED_DATABASE_HOST")); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return Config{}, fmt.Errorf("EXPECTED_DATABASE_HOST: %w", err)
+		}
+		cfg.ExpectedDatabaseHost = re
+	}
+	primary, secondary, err := LoadGatewayKeys(cfg.GatewayAPIKeyFile)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.ToolGatewayAPIKey, cfg.ToolGatewayAPIKeySecondary = primary, secondary
+	if cfg.HandlerFlags, err = LoadHandlerFlags(os.Getenv("HANDLER_FLAGS"), os.Getenv("HANDLER_FLAGS_FILE")); err != nil {
+		return Config{}, err
+	}
+	if cfg.LanguageAliases, err = LoadLanguageAliases(os.Getenv("LANGUAGE_ALIASES_FILE")); err != nil {
+		return Config{}, err
+	}
+	if cfg.Dayparts, err = ParseDayparts(os.Getenv("POP_DAYPARTS")); err != nil {
+		return Config{}, err
 	}
 
 	keysRaw := strings.TrimSpace(getenv("AGENT_API_KEYS", getenv("AGENT_API_KEY", "")))
-	cfg.AgentAPIKeys = parseCSVSet(keysRaw)
+	if cfg.AgentAPIKeys, err = parseAPIKeys(keysRaw); err != nil {
+		return Config{}, err
+	}
 
 	if !cfg.MockMode {
 		if cfg.OpenAIAPIKey == "" {
@@ -63,10 +451,18 @@ func Load() (Config, error) {
 		}
 	}
 	if cfg.ToolGatewayAPIKey == "" {
-		return Config{}, errors.New("missing TOOL_GATEWAY_API_KEY")
+		return Config{}, errors.New("missing TOOL_GATEWAY_API_KEY (or a key in GATEWAY_API_KEY_FILE)")
+	}
+	if len(cfg.AgentAPIKeys) == 0 && cfg.JWKSURL == "" {
+		return Config{}, errors.New("missing AGENT_API_KEY (or AGENT_API_KEYS, or JWKS_URL)")
 	}
-	if len(cfg.AgentAPIKeys) == 0 {
-		return Config{}, errors.New("missing AGENT_API_KEY (or AGENT_API_KEYS)")
+	if cfg.JWKSURL != "" {
+		if cfg.JWTIssuer == "" || cfg.JWTAudience == "" {
+			return Config{}, errors.New("JWKS_URL needs JWT_ISSUER and JWT_AUDIENCE")
+		}
+		if cfg.JWTRole != models.KeyRoleReadOnly && cfg.JWTRole != models.KeyRoleAdmin {
+			return Config{}, fmt.Errorf("JWT_ROLE: unknown role %q (use readonly or admin)", cfg.JWTRole)
+		}
 	}
 	if cfg.Port == "" {
 		return Config{}, errors.New("missing PORT")
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{in: "keyA", want: map[string]string{"keyA": "admin"}},
+		{in: "keyA:readonly, keyB:admin", want: map[string]string{"keyA": "readonly", "keyB": "admin"}},
+		{in: " keyA : ReadOnly ,, keyB ", want: map[string]string{"keyA": "readonly", "keyB": "admin"}},
+		{in: "a:b:admin", want: map[string]string{"a:b": "admin"}},
+		{in: "", want: map[string]string{}},
+		{in: "keyA:owner", wantErr: true},
+		{in: ":readonly", wantErr: true},
+		{in: "a:b", wantErr: true},
+	}
+	for _, tc := range cases {
+		got, err := parseAPIKeys(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parseAPIKeys(%q) = %v, want an error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseAPIKeys(%q) = %v, %v; want %v", tc.in, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"openai-agent-service/internal/models"
+	"openai-agent-service/internal/services"
+)
+
+type AdminHandlers struct {
+	Chat        *services.ChatService
+	Debug       services.DebugStore
+	Credentials *services.GatewayCredentials
+	Usage       services.UsageStore
+	Feedback    services.FeedbackStore
+	// StepBodies serves GET /steps/{bodyId}; nil when spillover is off.
+	StepBodies services.StepBodyStore
+	// Outbox serves the dead-letter endpoints.
+	Outbox services.OutboxStore
+}
+
+func (h *AdminHandlers) GetCaches(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"data": h.Chat.CacheSnapshot()})
+}
+
+func (h *AdminHandlers) FlushCaches(w http.ResponseWriter, r *http.Request) {
+	var req models.CacheFlushRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_json"})
+		return
+	}
+	flushed, err := h.Chat.FlushCaches(req.Caches...)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "unknown_cache", "message": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"flushed": flushed}})
+}
+
+// GetDebugBundle returns a captured debug bundle by id until it expires.
+// Bundles hold un-clipped gateway payloads of any key, so the route is
+// mounted for admin keys only.
+func (h *AdminHandlers) GetDebugBundle(w http.ResponseWriter, r *http.Request) {
+	if h.Debug == nil {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+		return
+	}
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	if id == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "debug_id_required"})
+		return
+	}
+	b, err := h.Debug.GetDebugBundle(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "get_debug_bundle_failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": b})
+}
+
+// GetStepBody returns the full body behind a clipped step while it is
+// retained. Only the key that asked the question, or an admin key, may read
+// it; anyone else gets not_found.
+func (h *AdminHandlers) GetStepBody(w http.ResponseWriter, r *http.Request) {
+	if h.StepBodies == nil {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+		return
+	}
+	id := strings.TrimSpace(chi.URLParam(r, "bodyId"))
+	if id == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "body_id_required"})
+		return
+	}
+	b, err := h.StepBodies.GetStepBody(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "get_step_body_failed"})
+		return
+	}
+	if b.OwnerKey != CallerKey(r) && CallerRole(r) != models.KeyRoleAdmin {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": b})
+}
+
+// SetConversationDebug flags a conversation so each of its requests captures
+// a debug bundle, as if it had sent "debug": true.
+func (h *AdminHandlers) SetConversationDebug(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	if id == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "conversation_id_required"})
+		return
+	}
+	var req models.DebugFlagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_json"})
+		return
+	}
+	h.Chat.SetConversationDebug(id, req.Enabled)
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"conversation_id": id, "enabled": req.Enabled}})
+}
+
+// ReloadCredentials re-reads the tool gateway keys from GATEWAY_API_KEY_FILE
+// (or the environment) and swaps them in for new gateway requests.
+func (h *AdminHandlers) ReloadCredentials(w http.ResponseWriter, r *http.Request) {
+	if h.Credentials == nil {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+		return
+	}
+	if err := h.Credentials.Reload(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "reload_credentials_failed", "message": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"reloaded": true, "keys": len(h.Credentials.Keys())}})
+}
+
+// usageTime parses a from/to query value given as RFC 3339 or YYYY-MM-DD.
+func usageTime(v string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), true
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// analyticsRange reads the from/to query of the analytics endpoints: the 7
+// days before to, which defaults to now. On a bad value it writes the 400 and
+// returns false.
+func analyticsRange(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	to := time.Now().UTC()
+	if v := strings.TrimSpace(r.URL.Query().Get("to")); v != "" {
+		t, ok := usageTime(v)
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_to", "message": "to must be RFC 3339 or YYYY-MM-DD."})
+			return time.Time{}, time.Time{}, false
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -7)
+	if v := strings.TrimSpace(r.URL.Query().Get("from")); v != "" {
+		t, ok := usageTime(v)
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_from", "message": "from must be RFC 3339 or YYYY-MM-DD."})
+			return time.Time{}, time.Time{}, false
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_range", "message": "from must be before to."})
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+// GetUsage aggregates recorded chat usage over [from, to), the last 7 days
+// by default. The summary spans every owner and quotes their questions, so
+// the route is mounted for admin keys only.
+func (h *AdminHandlers) GetUsage(w http.ResponseWriter, r *http.Request) {
+	if h.Usage == nil {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+		return
+	}
+	from, to, ok := analyticsRange(w, r)
+	if !ok {
+		return
+	}
+	summary, err := h.Usage.UsageSummary(r.Context(), from, to, 20)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "usage_summary_failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": summary})
+}
+
+// GetFeedback summarizes answer ratings per handler over [from, to), the last
+// 7 days by default, with the newest down-votes and their comments. Those
+// come from every owner, so the route is mounted for admin keys only.
+func (h *AdminHandlers) GetFeedback(w http.ResponseWriter, r *http.Request) {
+	if h.Feedback == nil {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+		return
+	}
+	from, to, ok := analyticsRange(w, r)
+	if !ok {
+		return
+	}
+	summary, err := h.Feedback.FeedbackSummary(r.Context(), from, to, 20)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "feedback_summary_failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": summary})
+}
+
+// ListHandlers lists the deterministic handlers with their flags and hits.
+func (h *AdminHandlers) ListHandlers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"data": h.Chat.HandlerStates()})
+}
+
+// SetHandlerFlag enables or disables a deterministic handler on this
+// instance; disabled handlers let their questions fall through to the model.
+func (h *AdminHandlers) SetHandlerFlag(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(chi.URLParam(r, "name"))
+	var req models.HandlerFlagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_json"})
+		return
+	}
+	if req.Enabled == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "enabled_required"})
+		return
+	}
+	if err := h.Chat.SetHandlerEnabled(name, *req.Enabled); err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "unknown_handler", "message": "No handler is registered as " + name + "."})
+		return
+	}
+	log.Printf("handler flag name=%s enabled=%t", name, *req.Enabled)
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"name": name, "enabled": *req.Enabled}})
+}
+
+// maxDeadLetters caps the limit of GET /admin/dead-letters.
+const maxDeadLetters = 500
+
+// ListDeadLetters lists outbox entries that used up their attempts, newest
+// first (limit, default 50, at most 500).
+func (h *AdminHandlers) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if h.Outbox == nil {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+		return
+	}
+	limit := 50
+	if v := strings.TrimSpace(r.URL.Query().Get("limit")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_limit", "message": "limit must be a positive integer."})
+			return
+		}
+		limit = min(n, maxDeadLetters)
+	}
+	items, err := h.Outbox.ListDeadLetters(r.Context(), limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "list_dead_letters_failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": items})
+}
+
+// RetryDeadLetter moves a dead letter back to the outbox with a fresh
+// attempt count.
+func (h *AdminHandlers) RetryDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if h.Outbox == nil {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(chi.URLParam(r, "id")), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "dead_letter_id_required"})
+		return
+	}
+	entry, err := h.Outbox.RequeueDeadLetter(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "retry_dead_letter_failed"})
+		return
+	}
+	log.Printf("outbox: dead letter %d re-queued as entry %d (%s)", id, entry.ID, entry.Kind)
+	writeJSON(w, http.StatusOK, map[string]any{"data": entry})
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"openai-agent-service/internal/models"
+)
+
+// outboxStore records the limit dead letters are listed with and holds one
+// dead letter, id 7.
+type outboxStore struct {
+	limit    int
+	requeued []int64
+}
+
+func (s *outboxStore) EnqueueOutbox(context.Context, string, []byte) error { return nil }
+
+func (s *outboxStore) MarkAlertRuleFiredAndEnqueue(context.Context, int64, time.Time, string, []byte) error {
+	return nil
+}
+
+func (s *outboxStore) ClaimOutbox(context.Context, int, time.Duration) ([]models.OutboxEntry, error) {
+	return nil, nil
+}
+
+func (s *outboxStore) CompleteOutbox(context.Context, int64) error { return nil }
+
+func (s *outboxStore) RetryOutbox(context.Context, int64, time.Time, string) error { return nil }
+
+func (s *outboxStore) DeadLetterOutbox(context.Context, int64, string) error { return nil }
+
+func (s *outboxStore) ListDeadLetters(_ context.Context, limit int) ([]models.DeadLetter, error) {
+	s.limit = limit
+	return []models.DeadLetter{}, nil
+}
+
+func (s *outboxStore) RequeueDeadLetter(_ context.Context, id int64) (models.OutboxEntry, error) {
+	s.requeued = append(s.requeued, id)
+	if id != 7 {
+		return models.OutboxEntry{}, sql.ErrNoRows
+	}
+	return models.OutboxEntry{ID: 12, Kind: "webhook", Payload: []byte(`{"url":"https://hooks.example.com"}`)}, nil
+}
+
+func TestListDeadLettersLimit(t *testing.T) {
+	cases := []struct {
+		query  string
+		status int
+		limit  int
+	}{
+		{query: "", status: http.StatusOK, limit: 50},
+		{query: "?limit=10", status: http.StatusOK, limit: 10},
+		{query: "?limit=500", status: http.StatusOK, limit: 500},
+		{query: "?limit=1000", status: http.StatusOK, limit: 500},
+		{query: "?limit=ten", status: http.StatusBadRequest},
+		{query: "?limit=0", status: http.StatusBadRequest},
+		{query: "?limit=-5", status: http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.query, func(t *testing.T) {
+			outbox := &outboxStore{}
+			h := &AdminHandlers{Outbox: outbox}
+			rec := httptest.NewRecorder()
+			h.ListDeadLetters(rec, httptest.NewRequest(http.MethodGet, "/admin/dead-letters"+tc.query, nil))
+			if rec.Code != tc.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.status, rec.Body)
+			}
+			if tc.status != http.StatusOK {
+				if !strings.Contains(rec.Body.String(), `"error":"invalid_limit"`) {
+					t.Errorf("body %s lacks invalid_limit", rec.Body)
+				}
+				if outbox.limit != 0 {
+					t.Errorf("store queried with limit %d after a bad limit", outbox.limit)
+				}
+				return
+			}
+			if outbox.limit != tc.limit {
+				t.Errorf("store queried with limit %d, want %d", outbox.limit, tc.limit)
+			}
+		})
+	}
+}
+
+func TestRetryDeadLetter(t *testing.T) {
+	cases := []struct {
+		name     string
+		disabled bool
+		id       string
+		status   int
+		code     string
+	}{
+		{name: "re-queued", id: "7", status: http.StatusOK},
+		{name: "unknown", id: "8", status: http.StatusNotFound, code: "not_found"},
+		{name: "bad id", id: "seven", status: http.StatusBadRequest, code: "dead_letter_id_required"},
+		{name: "outbox off", disabled: true, id: "7", status: http.StatusNotFound, code: "not_found"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			outbox := &outboxStore{}
+			h := &AdminHandlers{}
+			if !tc.disabled {
+				h.Outbox = outbox
+			}
+			r := chi.NewRouter()
+			r.Post("/admin/dead-letters/{id}/retry", h.RetryDeadLetter)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/dead-letters/"+tc.id+"/retry", nil))
+			if rec.Code != tc.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.status, rec.Body)
+			}
+			if tc.code != "" {
+				if !strings.Contains(rec.Body.String(), `"error":"`+tc.code+`"`) {
+					t.Errorf("body %s lacks %s", rec.Body, tc.code)
+				}
+				return
+			}
+			if len(outbox.requeued) != 1 || outbox.requeued[0] != 7 {
+				t.Errorf("re-queued %v, want [7]", outbox.requeued)
+			}
+			if !strings.Contains(rec.Body.String(), `"id":12`) || !strings.Contains(rec.Body.String(), `"kind":"webhook"`) {
+				t.Errorf("body %s lacks the new outbox entry", rec.Body)
+			}
+		})
+	}
+}
+
+// stepBodies holds one retained body, owned by owner-a.
+type stepBodies struct{}
+
+func (stepBodies) SaveStepBody(context.Context, models.StepBody) error { return nil }
+
+func (stepBodies) GetStepBody(_ context.Context, id string) (models.StepBody, error) {
+	if id != "body-1" {
+		return models.StepBody{}, sql.ErrNoRows
+	}
+	return models.StepBody{ID: id, OwnerKey: "owner-a", Tool: "GET /pop", Body: `{"items":[]}`}, nil
+}
+
+func (stepBodies) DeleteExpiredStepBodies(context.Context, time.Time) (int64, error) { return 0, nil }
+
+func TestGetStepBody(t *testing.T) {
+	cases := []struct {
+		name     string
+		disabled bool
+		id       string
+		caller   string
+		role     string
+		status   int
+	}{
+		{name: "owner", id: "body-1", caller: "owner-a", role: models.KeyRoleReadOnly, status: http.StatusOK},
+		{name: "admin", id: "body-1", caller: "owner-b", role: models.KeyRoleAdmin, status: http.StatusOK},
+		{name: "another owner", id: "body-1", caller: "owner-b", role: models.KeyRoleReadOnly, status: http.StatusNotFound},
+		{name: "expired or unknown", id: "body-2", caller: "owner-a", role: models.KeyRoleAdmin, status: http.StatusNotFound},
+		{name: "spillover off", disabled: true, id: "body-1", caller: "owner-a", role: models.KeyRoleAdmin, status: http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &AdminHandlers{}
+			if !tc.disabled {
+				h.StepBodies = stepBodies{}
+			}
+			r := chi.NewRouter()
+			r.With(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					ctx := context.WithValue(r.Context(), ctxCallerKey, tc.caller)
+					next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, ctxCallerRole, tc.role)))
+				})
+			}).Get("/steps/{bodyId}", h.GetStepBody)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/steps/"+tc.id, nil))
+			if rec.Code != tc.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.status, rec.Body)
+			}
+			if tc.status == http.StatusOK && !strings.Contains(rec.Body.String(), `"body":"{\"items\":[]}"`) {
+				t.Errorf("body %s lacks the stored body", rec.Body)
+			}
+			if strings.Contains(rec.Body.String(), "owner-a") {
+				t.Errorf("body %s leaks the owner key", rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"openai-agent-service/internal/models"
+	"openai-agent-service/internal/services"
+	"openai-agent-service/internal/store"
+)
+
+type AlertHandlers struct {
+	Store *store.PostgresStore
+	// Webhooks limits the hosts a rule's webhook_url may name.
+	Webhooks *services.WebhookGuard
+}
+
+func (h *AlertHandlers) ListAlertRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.Store.ListAlertRules(r.Context(), CallerKey(r))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "list_alert_rules_failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": rules})
+}
+
+func (h *AlertHandlers) CreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	var rule models.AlertRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_json"})
+		return
+	}
+	rule.OwnerKey = CallerKey(r)
+	if id := strings.TrimSpace(rule.ConversationID); id != "" {
+		owner, err := h.Store.ConversationOwner(r.Context(), id)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "create_alert_rule_failed"})
+			return
+		}
+		if owner != "" && owner != rule.OwnerKey {
+			writeJSON(w, http.StatusForbidden, map[string]any{"error": "conversation_forbidden"})
+			return
+		}
+	}
+	if err := services.ValidateAlertRule(&rule); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_alert_rule", "message": err.Error()})
+		return
+	}
+	// A blocked webhook is refused now rather than dead-lettered when the
+	// rule first fires.
+	rule.WebhookURL = strings.TrimSpace(rule.WebhookURL)
+	if rule.WebhookURL != "" {
+		if err := h.Webhooks.Check(r.Context(), rule.WebhookURL); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_webhook_url", "message": err.Error()})
+			return
+		}
+	}
+	created, err := h.Store.CreateAlertRule(r.Context(), rule)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "create_alert_rule_failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": created})
+}
+
+func (h *AlertHandlers) DeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(strings.TrimSpace(chi.URLParam(r, "id")), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "alert_id_required"})
+		return
+	}
+	if err := h.Store.DeleteAlertRule(r.Context(), CallerKey(r), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "delete_alert_rule_failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"deleted": id}})
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"openai-agent-service/internal/services"
+)
+
+// Rules whose webhook the guard refuses are rejected before they are saved.
+func TestCreateAlertRuleChecksWebhook(t *testing.T) {
+	h := &AlertHandlers{Webhooks: &services.WebhookGuard{}}
+	cases := []struct {
+		name string
+		url  string
+	}{
+		{"loopback", "http://127.0.0.1:8080/hook"},
+		{"localhost name", "http://localhost/hook"},
+		{"link-local metadata", "http://169.254.169.254/latest/meta-data/"},
+		{"rfc 1918", "https://10.1.2.3/hook"},
+		{"rfc 1918 192.168", "https://192.168.0.10/hook"},
+		{"not http", "ftp://93.184.216.34/hook"},
+		{"relative", "/hook"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := `{"condition":"offline_duration","threshold":15,"webhook_url":"` + tc.url + `"}`
+			rec := httptest.NewRecorder()
+			h.CreateAlertRule(rec, httptest.NewRequest(http.MethodPost, "/alerts", strings.NewReader(body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, want 400: %s", rec.Code, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), `"error":"invalid_webhook_url"`) {
+				t.Errorf("body %s lacks invalid_webhook_url", rec.Body)
+			}
+		})
+	}
+}
@@ -1,8 +1,18 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
 
 	"openai-agent-service/internal/models"
 	"openai-agent-service/internal/services"
@@ -22,11 +32,162 @@ func (h *ChatHandlers) HandleChat(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "message_required"})
 		return
 	}
+	if !idempotencyKeyFromHeader(r, &req) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_idempotency_key", "message": services.ErrInvalidIdempotencyKey.Error()})
+		return
+	}
+	if async, _ := strconv.ParseBool(r.URL.Query().Get("async")); async {
+		h.enqueueChat(w, r, req)
+		return
+	}
 
 	resp, err := h.Chat.Chat(r.Context(), CallerKey(r), req)
+	writeChatResult(w, resp, err)
+}
+
+// enqueueChat answers POST /chat?async=true: 202 with the queued job, which
+// GET /jobs/{id} reports on.
+func (h *ChatHandlers) enqueueChat(w http.ResponseWriter, r *http.Request, req models.ChatRequest) {
+	job, err := h.Chat.EnqueueChat(r.Context(), CallerKey(r), req)
+	switch {
+	case err == nil:
+		w.Header().Set("Location", "/jobs/"+job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+	case errors.Is(err, services.ErrChatJobQueueFull):
+		writeJSON(w, http.StatusTooManyRequests, map[string]any{"error": "too_many_queued_jobs", "retryable": true, "message": err.Error()})
+	case errors.Is(err, services.ErrInvalidWebhookURL), errors.Is(err, services.ErrWebhookBlocked):
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_webhook_url", "message": err.Error()})
+	case errors.Is(err, services.ErrConversationForbidden):
+		writeJSON(w, http.StatusForbidden, map[string]any{"error": "conversation_forbidden", "message": err.Error()})
+	case errors.Is(err, services.ErrAsyncDisabled):
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"error": "async_disabled", "retryable": false, "message": err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "enqueue_failed", "message": err.Error()})
+	}
+}
+
+// GetJob answers GET /jobs/{id} with the caller's async job: its status and,
+// once done, the full ChatResponse.
+func (h *ChatHandlers) GetJob(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	if id == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "job_id_required"})
+		return
+	}
+	job, err := h.Chat.ChatJob(r.Context(), CallerKey(r), id)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, job)
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, services.ErrAsyncDisabled):
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "get_job_failed"})
+	}
+}
+
+// GetExtract answers GET /extracts/{id}.csv by streaming the extract's POP
+// rows as CSV, re-read from the gateway page by page. Extracts belong to the
+// key that asked for them; admins may read any.
+func (h *ChatHandlers) GetExtract(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(chi.URLParam(r, "id"))
+	if id == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "extract_id_required"})
+		return
+	}
+	ex, err := h.Chat.Extract(r.Context(), id)
+	if err == nil || errors.Is(err, services.ErrExtractExpired) {
+		if ex.OwnerKey != CallerKey(r) && CallerRole(r) != models.KeyRoleAdmin {
+			err = sql.ErrNoRows
+		}
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+		return
+	case errors.Is(err, services.ErrExtractExpired):
+		writeJSON(w, http.StatusGone, map[string]any{"error": "extract_expired", "message": err.Error()})
+		return
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "get_extract_failed"})
+		return
+	}
+
+	sw := &startedWriter{w: w}
+	flush := func() {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="pop-extract-`+ex.ID+`.csv"`)
+	n, err := h.Chat.StreamExtract(r.Context(), ex, sw, flush)
+	if err == nil {
+		return
+	}
+	log.Printf("extract %s: stopped after %d rows: %v", ex.ID, n, err)
+	if !sw.started {
+		w.Header().Del("Content-Disposition")
+		writeJSON(w, http.StatusBadGateway, map[string]any{"error": "extract_failed", "retryable": true, "message": err.Error()})
+		return
+	}
+	// The status is already sent; abort the connection so the client sees
+	// a failed download rather than a short file.
+	panic(http.ErrAbortHandler)
+}
+
+// startedWriter records whether anything was written through it.
+type startedWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (s *startedWriter) Write(p []byte) (int, error) {
+	s.started = true
+	return s.w.Write(p)
+}
+
+// idempotencyKeyFromHeader copies the Idempotency-Key header into req unless
+// the body already carries a key, and reports whether the key is valid.
+func idempotencyKeyFromHeader(r *http.Request, req *models.ChatRequest) bool {
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	}
+	req.IdempotencyKey = strings.TrimSpace(req.IdempotencyKey)
+	return req.IdempotencyKey == "" || services.ValidateIdempotencyKey(req.IdempotencyKey) == nil
+}
+
+// writeChatResult writes a chat answer, or the status and error code for err.
+func writeChatResult(w http.ResponseWriter, resp models.ChatResponse, err error) {
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "chat_failed", "message": err.Error()})
+		status, body := chatErrorBody(resp, err)
+		writeJSON(w, status, body)
 		return
 	}
 	writeJSON(w, http.StatusOK, resp)
 }
+
+// chatErrorBody is the status and error body of a failed chat request, for
+// POST /chat and the error event of /chat/stream alike. A refused
+// conversation or a panic is explained by the answer itself.
+func chatErrorBody(resp models.ChatResponse, err error) (int, map[string]any) {
+	ce := services.ClassifyChatError(err)
+	body := map[string]any{"error": ce.Code, "message": err.Error()}
+	switch ce.Code {
+	case "conversation_forbidden":
+		body["message"] = resp.Answer
+	case "internal_error":
+		body["message"] = resp.Answer
+		body["instance"] = services.IncidentID(err)
+	}
+	if ce.Retryable {
+		body["retryable"] = true
+	}
+	return ce.Status, body
+}
+
+// isTimeout reports whether err is a deadline or network timeout.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout())
+}
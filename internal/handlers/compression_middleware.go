@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"openai-agent-service/internal/config"
+)
+
+// minCompressBytes is the smallest response body worth compressing.
+const minCompressBytes = 1024
+
+// MaxJSONBody bounds the decompressed body of routes that take a small JSON
+// document.
+const MaxJSONBody = 1 << 20
+
+// ChatBodyLimit bounds a decompressed /chat body: the largest upload the
+// service accepts (attachments are base64 in JSON, 4/3 larger) plus room for
+// the rest of the request.
+func ChatBodyLimit(cfg config.Config) int64 {
+	limit := cfg.CreativeUploadMaxTotalBytes
+	if limit <= 0 {
+		limit = 200 << 20
+	}
+	return limit/3*4 + 1<<20
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := strings.TrimSpace(params)
+		if v, ok := strings.CutPrefix(q, "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil && f == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// decompressBody replaces a gzip or deflate request body with its decoded
+// bytes. It fails with a status and error code when the encoding is unknown,
+// the body is corrupt or it expands beyond limit.
+func decompressBody(r *http.Request, limit int64) (int, string, error) {
+	var zr io.Reader
+	switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+		return 0, "", nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return http.StatusBadRequest, "invalid_content_encoding", err
+		}
+		defer gz.Close()
+		zr = gz
+	case "deflate":
+		fr := flate.NewReader(r.Body)
+		defer fr.Close()
+		zr = fr
+	default:
+		return http.StatusUnsupportedMediaType, "unsupported_content_encoding", fmt.Errorf("content encoding %q is not supported; use gzip or deflate", enc)
+	}
+	body, err := io.ReadAll(io.LimitReader(zr, limit+1))
+	if err != nil {
+		return http.StatusBadRequest, "invalid_content_encoding", err
+	}
+	if int64(len(body)) > limit {
+		return http.StatusRequestEntityTooLarge, "request_too_large", fmt.Errorf("decompressed body exceeds %d bytes", limit)
+	}
+	_ = r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Del("Content-Encoding")
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return 0, "", nil
+}
+
+// gzipWriter holds back the first minCompressBytes of a response, then
+// gzips it if it grew past that. Event streams, already encoded bodies and
+// bodiless statuses pass through untouched.
+type gzipWriter struct {
+	http.ResponseWriter
+	level   int
+	status  int
+	buf     []byte
+	gz      *gzip.Writer
+	through bool
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if w.status != 0 || w.through || w.gz != nil {
+		return
+	}
+	w.status = code
+	h := w.Header()
+	if strings.HasPrefix(h.Get("Content-Type"), "text/event-stream") || h.Get("Content-Encoding") != "" ||
+		code < 200 || code == http.StatusNoContent || code == http.StatusNotModified {
+		w.passThrough()
+	}
+}
+
+// passThrough sends the held-back status and bytes uncompressed and stops
+// buffering.
+func (w *gzipWriter) passThrough() {
+	w.through = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) > 0 {
+		_, _ = w.ResponseWriter.Write(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *gzipWriter) startGzip() error {
+	h := w.Header()
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	gz, err := gzip.NewWriterLevel(w.ResponseWriter, w.level)
+	if err != nil {
+		gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.gz = gz
+	_, err = w.gz.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case w.through:
+		return w.ResponseWriter.Write(p)
+	case w.gz != nil:
+		return w.gz.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= minCompressBytes {
+		if err := w.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *gzipWriter) Flush() {
+	switch {
+	case w.gz != nil:
+		_ = w.gz.Flush()
+	case !w.through:
+		w.passThrough()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish completes the response: the gzip trailer, or the held-back small
+// body as is.
+func (w *gzipWriter) finish() {
+	switch {
+	case w.gz != nil:
+		_ = w.gz.Close()
+	case !w.through && (w.status != 0 || len(w.buf) > 0):
+		w.passThrough()
+	}
+}
+
+// WithCompression gzips responses of 1KB or more for clients that accept
+// it. Server-sent event streams are never compressed.
+func WithCompression(cfg config.Config) func(http.Handler) http.Handler {
+	level := cfg.CompressionLevel
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		level = gzip.DefaultCompression
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if !acceptsGzip(r) || level == gzip.NoCompression {
+				next.ServeHTTP(w, r)
+				return
+			}
+			gw := &gzipWriter{ResponseWriter: w, level: level}
+			defer gw.finish()
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
+
+// WithDecompression accepts gzip and deflate request bodies that expand to
+// at most limit bytes (413 beyond). It runs after WithAPIKey, so an
+// unauthenticated caller never gets a body inflated, and each route passes
+// the limit its own requests need.
+func WithDecompression(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if status, code, err := decompressBody(r, limit); err != nil {
+				writeJSON(w, status, map[string]any{"error": code, "message": err.Error()})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"openai-agent-service/internal/config"
+)
+
+func gzipped(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func deflated(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// echoHandler answers with the request body, as an event stream when the
+// request asks for one.
+func echoHandler(calls *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.Header.Get("Accept") == "text/event-stream" {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		_, _ = w.Write(body)
+	})
+}
+
+func TestCompression(t *testing.T) {
+	large := []byte(`{"message":"` + strings.Repeat("plays per kiosk ", 200) + `"}`)
+	small := []byte(`{"message":"hi"}`)
+	cases := []struct {
+		name       string
+		body       []byte
+		encoding   string
+		sent       []byte
+		accept     string
+		status     int
+		code       string
+		gzipped    bool
+		reachesApp bool
+	}{
+		{name: "gzip round trip", body: large, encoding: "gzip", sent: gzipped(t, large), status: http.StatusOK, gzipped: true, reachesApp: true},
+		{name: "deflate request", body: large, encoding: "deflate", sent: deflated(t, large), status: http.StatusOK, gzipped: true, reachesApp: true},
+		{name: "identity request", body: large, sent: large, status: http.StatusOK, gzipped: true, reachesApp: true},
+		{name: "small response stays plain", body: small, encoding: "gzip", sent: gzipped(t, small), status: http.StatusOK, reachesApp: true},
+		{name: "event stream stays plain", body: large, encoding: "gzip", sent: gzipped(t, large), accept: "text/event-stream", status: http.StatusOK, reachesApp: true},
+		// 16 MiB of zeros packs into a few KB.
+		{name: "zip bomb", encoding: "gzip", sent: gzipped(t, make([]byte, 16<<20)), status: http.StatusRequestEntityTooLarge, code: "request_too_large"},
+		{name: "corrupt gzip", encoding: "gzip", sent: []byte("not gzip"), status: http.StatusBadRequest, code: "invalid_content_encoding"},
+		{name: "unknown encoding", encoding: "br", sent: large, status: http.StatusUnsupportedMediaType, code: "unsupported_content_encoding"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			h := WithCompression(config.Config{CompressionLevel: -1})(WithDecompression(MaxJSONBody)(echoHandler(&calls)))
+			req := httptest.NewRequest(http.MethodPost, "/chat", bytes.NewReader(tc.sent))
+			req.Header.Set("Accept-Encoding", "gzip")
+			if tc.encoding != "" {
+				req.Header.Set("Content-Encoding", tc.encoding)
+			}
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tc.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.status, rec.Body)
+			}
+			if (calls > 0) != tc.reachesApp {
+				t.Errorf("handler called %d times, want called=%v", calls, tc.reachesApp)
+			}
+			if tc.code != "" {
+				if !strings.Contains(rec.Body.String(), `"error":"`+tc.code+`"`) {
+					t.Errorf("body %s lacks error %s", rec.Body, tc.code)
+				}
+				return
+			}
+			got := rec.Body.Bytes()
+			if enc := rec.Header().Get("Content-Encoding"); (enc == "gzip") != tc.gzipped {
+				t.Fatalf("Content-Encoding %q, want gzipped=%v", enc, tc.gzipped)
+			}
+			if tc.gzipped {
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, err = io.ReadAll(zr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !bytes.Equal(got, tc.body) {
+				t.Errorf("response body differs from the request body: got %d bytes, want %d", len(got), len(tc.body))
+			}
+		})
+	}
+}
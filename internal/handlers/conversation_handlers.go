@@ -7,16 +7,24 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	"openai-agent-service/internal/models"
+	"openai-agent-service/internal/services"
 	"openai-agent-service/internal/store"
 )
 
 type ConversationHandlers struct {
 	Store *store.PostgresStore
+	// Chat stores message feedback in the background.
+	Chat *services.ChatService
 }
 
+// maxFeedbackComment is the longest feedback comment accepted, in bytes.
+const maxFeedbackComment = 2000
+
 func (h *ConversationHandlers) CreateConversation(w http.ResponseWriter, r *http.Request) {
 	c, err := h.Store.CreateConversation(r.Context(), CallerKey(r))
 	if err != nil {
@@ -26,6 +34,65 @@ func (h *ConversationHandlers) CreateConversation(w http.ResponseWriter, r *http
 	writeJSON(w, http.StatusOK, map[string]any{"data": c})
 }
 
+// MaxImportBody bounds the body of POST /conversations/import: a full
+// transcript of maximum-size messages plus JSON overhead.
+const MaxImportBody = services.MaxImportMessages*services.MaxImportContentBytes + 1<<20
+
+// ImportConversation creates a conversation from a transcript exported by
+// another chat system and answers with the context read from it. The body
+// is a models.ConversationImport or just its messages array.
+func (h *ConversationHandlers) ImportConversation(w http.ResponseWriter, r *http.Request) {
+	if h.Chat == nil || h.Chat.Imports == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"error": "import_disabled", "message": services.ErrImportDisabled.Error()})
+		return
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxImportBody)).Decode(&raw); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]any{"error": "transcript_too_large"})
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_json"})
+		return
+	}
+	var body models.ConversationImport
+	if trimmed := strings.TrimSpace(string(raw)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(raw, &body.Messages); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_json"})
+			return
+		}
+	} else if err := json.Unmarshal(raw, &body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_json"})
+		return
+	}
+	if err := services.ValidateTranscript(body.Messages, time.Now()); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_transcript", "message": err.Error()})
+		return
+	}
+	res, err := h.Chat.ImportConversation(r.Context(), CallerKey(r), body)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "import_conversation_failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": res})
+}
+
+func (h *ConversationHandlers) ListConversations(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if v := strings.TrimSpace(r.URL.Query().Get("limit")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			limit = n
+		}
+	}
+	convs, err := h.Store.ListConversations(r.Context(), CallerKey(r), limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "list_conversations_failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": convs})
+}
+
 func (h *ConversationHandlers) GetConversation(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if strings.TrimSpace(id) == "" {
@@ -63,3 +130,88 @@ func (h *ConversationHandlers) ListMessages(w http.ResponseWriter, r *http.Reque
 	}
 	_ = json.NewEncoder(w).Encode(map[string]any{"data": msgs})
 }
+
+// RenameConversation sets an explicit title. Automatic titles never replace
+// a title set here.
+func (h *ConversationHandlers) RenameConversation(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if strings.TrimSpace(id) == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "conversation_id_required"})
+		return
+	}
+	var body struct {
+		Title string `json:"title"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_json"})
+		return
+	}
+	if strings.TrimSpace(body.Title) == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "title_required"})
+		return
+	}
+	if err := h.Store.UpdateConversationTitle(r.Context(), CallerKey(r), id, body.Title); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "rename_conversation_failed"})
+		return
+	}
+	c, err := h.Store.GetConversation(r.Context(), CallerKey(r), id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "get_conversation_failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": c})
+}
+
+// PostFeedback rates an assistant message of the caller's conversation. The
+// rating is written in the background, so the answer is 202 Accepted; a
+// message id that is not an assistant message of the conversation is
+// ignored when the write happens.
+func (h *ConversationHandlers) PostFeedback(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if strings.TrimSpace(id) == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "conversation_id_required"})
+		return
+	}
+	messageID, err := strconv.ParseInt(chi.URLParam(r, "messageId"), 10, 64)
+	if err != nil || messageID <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_message_id"})
+		return
+	}
+	var body models.FeedbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_json"})
+		return
+	}
+	rating := strings.ToLower(strings.TrimSpace(body.Rating))
+	if rating != models.FeedbackUp && rating != models.FeedbackDown {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_rating", "message": "rating must be \"up\" or \"down\"."})
+		return
+	}
+	comment := strings.TrimSpace(body.Comment)
+	if len(comment) > maxFeedbackComment {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "comment_too_long", "message": "comment must be at most 2000 bytes."})
+		return
+	}
+	if h.Chat == nil || h.Chat.Feedback == nil {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+		return
+	}
+	if _, err := h.Store.GetConversation(r.Context(), CallerKey(r), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "get_conversation_failed"})
+		return
+	}
+	f := models.Feedback{OwnerKey: CallerKey(r), ConversationID: id, MessageID: messageID, Rating: rating, Comment: comment}
+	if err := h.Chat.SubmitFeedback(r.Context(), f); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"error": "feedback_busy", "message": "Too many feedback writes are pending; retry shortly."})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{"data": map[string]any{"status": "accepted", "message_id": messageID, "rating": rating}})
+}
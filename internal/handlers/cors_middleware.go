@@ -47,7 +47,7 @@ func WithCORS(cfg config.Config) func(http.Handler) http.Handler {
 				} else {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
 				}
-				w.Header().Set("Access-Control-Allow-Methods", "GET,POST,OPTIONS")
+				w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PATCH,DELETE,OPTIONS")
 				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-API-Key, Accept")
 				w.Header().Set("Access-Control-Max-Age", "600")
 			}
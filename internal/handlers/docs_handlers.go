@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+)
+
+// DocsHandlers serves the generated OpenAPI document and a Redoc page for it.
+type DocsHandlers struct {
+	once sync.Once
+	spec map[string]any
+}
+
+func (h *DocsHandlers) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	h.once.Do(func() { h.spec = OpenAPISpec() })
+	writeJSON(w, http.StatusOK, h.spec)
+}
+
+const redocPage = `<!DOCTYPE html>
+<html>
+<head>
+<title>openai-agent-service API</title>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body>
+<redoc spec-url="/openapi.json"></redoc>
+<script src="https://cdn.redoc.ly/redoc/latest/bundles/redoc.standalone.js"></script>
+</body>
+</html>
+`
+
+// Docs renders /openapi.json with Redoc. The page loads Redoc from its CDN.
+func (h *DocsHandlers) Docs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(redocPage))
+}
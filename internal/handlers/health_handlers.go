@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"openai-agent-service/internal/services"
+)
+
+type HealthHandlers struct {
+	Breaker *services.CircuitBreaker
+	// Chat, when set, adds the tool loop counters to /metrics.
+	Chat *services.ChatService
+	// Drift, when set, adds the gateway schema drift check to /readyz and
+	// /metrics.
+	Drift *services.SchemaDriftDetector
+}
+
+// Readyz reports 503 while the gateway circuit is open so load balancers can
+// route around an instance that would only fail fast. Schema drift is
+// reported in the body but does not fail the probe.
+func (h *HealthHandlers) Readyz(w http.ResponseWriter, r *http.Request) {
+	st := h.Breaker.Status()
+	status, label := http.StatusOK, "ok"
+	if st.State == services.BreakerOpen {
+		status, label = http.StatusServiceUnavailable, "gateway_unavailable"
+	}
+	body := map[string]any{"status": label, "gateway_breaker": st}
+	if h.Drift != nil {
+		if rep := h.Drift.Report(); !rep.CheckedAt.IsZero() {
+			body["schema_drift"] = rep
+		}
+	}
+	writeJSON(w, status, body)
+}
+
+// Metrics exposes the gateway breaker and the tool loop counters in the
+// Prometheus text format.
+func (h *HealthHandlers) Metrics(w http.ResponseWriter, r *http.Request) {
+	st := h.Breaker.Status()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "# HELP gateway_breaker_state Tool gateway circuit breaker state (1 for the current state).")
+	fmt.Fprintln(w, "# TYPE gateway_breaker_state gauge")
+	for _, s := range []string{services.BreakerClosed, services.BreakerOpen, services.BreakerHalfOpen} {
+		v := 0
+		if st.State == s {
+			v = 1
+		}
+		fmt.Fprintf(w, "gateway_breaker_state{state=%q} %d\n", s, v)
+	}
+	fmt.Fprintln(w, "# HELP gateway_breaker_consecutive_failures Consecutive tool gateway failures counted toward opening.")
+	fmt.Fprintln(w, "# TYPE gateway_breaker_consecutive_failures gauge")
+	fmt.Fprintf(w, "gateway_breaker_consecutive_failures %d\n", st.ConsecutiveFailures)
+	fmt.Fprintln(w, "# HELP gateway_breaker_opens_total Times the tool gateway breaker has opened.")
+	fmt.Fprintln(w, "# TYPE gateway_breaker_opens_total counter")
+	fmt.Fprintf(w, "gateway_breaker_opens_total %d\n", st.Opens)
+	fmt.Fprintln(w, "# HELP gateway_breaker_rejected_total Gateway calls failed fast by the open breaker.")
+	fmt.Fprintln(w, "# TYPE gateway_breaker_rejected_total counter")
+	fmt.Fprintf(w, "gateway_breaker_rejected_total %d\n", st.Rejected)
+	if h.Drift != nil {
+		if rep := h.Drift.Report(); !rep.CheckedAt.IsZero() {
+			counts := rep.Counts()
+			fmt.Fprintln(w, "# HELP gateway_schema_drift_endpoints Gateway endpoints used by the handlers, by schema check status.")
+			fmt.Fprintln(w, "# TYPE gateway_schema_drift_endpoints gauge")
+			for _, s := range []string{services.DriftOK, services.DriftMissingFields, services.DriftMissingEndpoint, services.DriftUnverifiable} {
+				fmt.Fprintf(w, "gateway_schema_drift_endpoints{status=%q} %d\n", s, counts[s])
+			}
+		}
+	}
+	if h.Chat == nil {
+		return
+	}
+	loop := h.Chat.ToolLoopStats()
+	reasons := make([]string, 0, len(loop.Runs))
+	for reason := range loop.Runs {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	fmt.Fprintln(w, "# HELP tool_loop_runs_total OpenAI tool loop runs by termination reason.")
+	fmt.Fprintln(w, "# TYPE tool_loop_runs_total counter")
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "tool_loop_runs_total{reason=%q} %d\n", reason, loop.Runs[reason])
+	}
+	fmt.Fprintln(w, "# HELP tool_loop_turns_total Model turns taken inside the tool loop.")
+	fmt.Fprintln(w, "# TYPE tool_loop_turns_total counter")
+	fmt.Fprintf(w, "tool_loop_turns_total %d\n", loop.Turns)
+	fmt.Fprintln(w, "# HELP tool_loop_forced_answers_total Tool loop runs that ended by asking the model to answer with what it had.")
+	fmt.Fprintln(w, "# TYPE tool_loop_forced_answers_total counter")
+	fmt.Fprintf(w, "tool_loop_forced_answers_total %d\n", loop.ForcedAnswers)
+}
@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"openai-agent-service/internal/config"
+)
+
+// jwtLeeway is the clock skew allowed on exp and nbf.
+const jwtLeeway = time.Minute
+
+// jwksMinRefresh bounds how often a token with an unknown kid can make the
+// key set be fetched again, so junk tokens cannot hammer the identity
+// provider.
+const jwksMinRefresh = 30 * time.Second
+
+// jwtOwnerPrefix starts every JWT caller's owner key, keeping token
+// subjects apart from the static API keys, which are owner keys themselves.
+const jwtOwnerPrefix = "jwt:"
+
+// errJWKSUnavailable means the signing keys could not be fetched; the token
+// may well be fine.
+var errJWKSUnavailable = errors.New("signing keys unavailable")
+
+// JWKSCache holds the identity provider's signing keys by kid, fetched from
+// URL and refetched after TTL or when a token names a kid it does not know.
+type JWKSCache struct {
+	URL  string
+	HTTP *http.Client
+	TTL  time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	tried     time.Time
+}
+
+// key returns the public key for kid. An empty kid matches the only key of
+// a single-key set.
+func (c *JWKSCache) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k, found := c.lookup(kid)
+	if found && time.Since(c.fetchedAt) < c.TTL {
+		return k, nil
+	}
+	if time.Since(c.tried) >= jwksMinRefresh {
+		c.tried = time.Now()
+		keys, err := c.fetch(ctx)
+		if err != nil {
+			log.Printf("jwks: fetch %s failed: %v", c.URL, err)
+			// A stale key beats refusing every caller while the
+			// provider is down.
+			if found {
+				return k, nil
+			}
+			return nil, errJWKSUnavailable
+		}
+		c.keys, c.fetchedAt = keys, time.Now()
+		k, found = c.lookup(kid)
+	}
+	switch {
+	case found:
+		return k, nil
+	case c.keys == nil:
+		return nil, errJWKSUnavailable
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (c *JWKSCache) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, k := range c.keys {
+			return k, true
+		}
+	}
+	k, ok := c.keys[kid]
+	return k, ok
+}
+
+// jwk is one key of a JWKS document. Only signing keys of type RSA and EC
+// are used.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (c *JWKSCache) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			log.Printf("jwks: key %q skipped: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("bad RSA modulus or exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+		y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+		if err1 != nil || err2 != nil {
+			return nil, errors.New("bad EC point")
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("EC point not on curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// JWTResolver resolves callers presenting "Authorization: Bearer <jwt>". The
+// token must be signed with RS256/384/512 or ES256/384/512 by a key in Keys,
+// be unexpired, and name Issuer and Audience. The owner key is the
+// OwnerClaim claim ("sub" by default) after jwtOwnerPrefix; every JWT caller
+// gets Role.
+type JWTResolver struct {
+	Issuer     string
+	Audience   string
+	OwnerClaim string
+	Role       string
+	Keys       *JWKSCache
+}
+
+// NewJWTResolver builds the JWT resolver configured by JWKS_URL and the
+// JWT_* variables.
+func NewJWTResolver(cfg config.Config) *JWTResolver {
+	return &JWTResolver{
+		Issuer:     cfg.JWTIssuer,
+		Audience:   cfg.JWTAudience,
+		OwnerClaim: cfg.JWTOwnerClaim,
+		Role:       cfg.JWTRole,
+		Keys:       &JWKSCache{URL: cfg.JWKSURL, HTTP: &http.Client{Timeout: 10 * time.Second}, TTL: cfg.JWKSRefresh},
+	}
+}
+
+// ResolveOwner verifies the bearer token. Requests without one are left to
+// the other resolvers.
+func (j *JWTResolver) ResolveOwner(r *http.Request) (string, string, bool, error) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(r.Header.Get("Authorization")), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", "", false, nil
+	}
+	claims, err := j.verify(r.Context(), strings.TrimSpace(token))
+	if errors.Is(err, errJWKSUnavailable) {
+		return "", "", true, &authError{status: http.StatusServiceUnavailable, code: "auth_unavailable", message: "Token signing keys could not be fetched; try again shortly."}
+	}
+	if err != nil {
+		return "", "", true, &authError{status: http.StatusUnauthorized, code: "invalid_token", message: err.Error()}
+	}
+	claim := j.OwnerClaim
+	if claim == "" {
+		claim = "sub"
+	}
+	owner, _ := claims[claim].(string)
+	if strings.TrimSpace(owner) == "" {
+		return "", "", true, &authError{status: http.StatusUnauthorized, code: "invalid_token", message: fmt.Sprintf("token has no %s claim", claim)}
+	}
+	return jwtOwnerPrefix + strings.TrimSpace(owner), j.Role, true, nil
+}
+
+// verify checks token's signature and registered claims and returns its
+// claims.
+func (j *JWTResolver) verify(ctx context.Context, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.New("malformed token header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	var h hash.Hash
+	var ch crypto.Hash
+	switch header.Alg {
+	case "RS256", "ES256":
+		h, ch = sha256.New(), crypto.SHA256
+	case "RS384", "ES384":
+		h, ch = sha512.New384(), crypto.SHA384
+	case "RS512", "ES512":
+		h, ch = sha512.New(), crypto.SHA512
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", header.Alg)
+	}
+	key, err := j.Keys.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg[0] != 'R' || rsa.VerifyPKCS1v15(pub, ch, digest, sig) != nil {
+			return nil, errors.New("bad signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if header.Alg[0] != 'E' || len(sig) != 2*size {
+			return nil, errors.New("bad signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return nil, errors.New("bad signature")
+		}
+	default:
+		return nil, errors.New("bad signature")
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token not valid yet")
+	}
+	if iss, _ := claims["iss"].(string); iss != j.Issuer {
+		return nil, errors.New("wrong token issuer")
+	}
+	if !audienceContains(claims["aud"], j.Audience) {
+		return nil, errors.New("wrong token audience")
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// audienceContains reports whether aud, a string or a list of strings,
+// names want.
+func audienceContains(aud any, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []any:
+		for _, a := range v {
+			if s, _ := a.(string); s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"openai-agent-service/internal/config"
+	"openai-agent-service/internal/models"
+)
+
+const (
+	testIssuer   = "https://id.example.test/"
+	testAudience = "agent-service"
+)
+
+// jwksServer serves key's public half as the only key of a JWKS document,
+// under kid, and counts the fetches.
+func jwksServer(t *testing.T, key *rsa.PrivateKey, kid string, fetches *int) *httptest.Server {
+	t.Helper()
+	doc := map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*fetches++
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(doc)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// signRS256 builds a compact RS256 token with the given kid and claims.
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	seg := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signing := seg(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid}) + "." + seg(claims)
+	digest := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func validClaims(sub string) map[string]any {
+	return map[string]any{
+		"iss": testIssuer,
+		"aud": []string{"other", testAudience},
+		"sub": sub,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestJWTOwnerResolution(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetches := 0
+	srv := jwksServer(t, key, "k1", &fetches)
+	cfg := config.Config{
+		AgentAPIKeys:  map[string]string{"machine-key": models.KeyRoleAdmin, "user-1": models.KeyRoleAdmin},
+		JWKSURL:       srv.URL,
+		JWTIssuer:     testIssuer,
+		JWTAudience:   testAudience,
+		JWTOwnerClaim: "sub",
+		JWTRole:       models.KeyRoleReadOnly,
+		JWKSRefresh:   time.Hour,
+	}
+	h := WithAPIKey(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"owner": CallerKey(r), "role": CallerRole(r)})
+	}))
+
+	expired := validClaims("user-1")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	wrongAud := validClaims("user-1")
+	wrongAud["aud"] = "someone-else"
+
+	cases := []struct {
+		name      string
+		apiKey    string
+		token     string
+		status    int
+		owner     string
+		role      string
+		errorCode string
+	}{
+		{name: "valid", token: signRS256(t, key, "k1", validClaims("user-1")), status: http.StatusOK, owner: "jwt:user-1", role: models.KeyRoleReadOnly},
+		{name: "expired", token: signRS256(t, key, "k1", expired), status: http.StatusUnauthorized, errorCode: "invalid_token"},
+		{name: "wrong audience", token: signRS256(t, key, "k1", wrongAud), status: http.StatusUnauthorized, errorCode: "invalid_token"},
+		{name: "unknown kid", token: signRS256(t, key, "k2", validClaims("user-1")), status: http.StatusUnauthorized, errorCode: "invalid_token"},
+		{name: "api key wins", apiKey: "machine-key", token: signRS256(t, key, "k1", validClaims("user-1")), status: http.StatusOK, owner: "machine-key", role: models.KeyRoleAdmin},
+		{name: "no credentials", status: http.StatusUnauthorized, errorCode: "missing_credentials"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/conversations", nil)
+			if tc.apiKey != "" {
+				req.Header.Set("X-API-Key", tc.apiKey)
+			}
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.status, rec.Body)
+			}
+			var body map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if tc.errorCode != "" {
+				if body["error"] != tc.errorCode {
+					t.Fatalf("error = %v, want %s", body["error"], tc.errorCode)
+				}
+				if body["status"] != float64(tc.status) {
+					t.Fatalf("problem status = %v, want %d", body["status"], tc.status)
+				}
+				return
+			}
+			if body["owner"] != tc.owner || body["role"] != tc.role {
+				t.Fatalf("caller = %v/%v, want %s/%s", body["owner"], body["role"], tc.owner, tc.role)
+			}
+		})
+	}
+	// The unknown kid must not refetch the key set within jwksMinRefresh.
+	if fetches != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", fetches)
+	}
+}
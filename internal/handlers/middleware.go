@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
@@ -12,11 +13,16 @@ import (
 	"time"
 
 	"openai-agent-service/internal/config"
+	"openai-agent-service/internal/models"
+	"openai-agent-service/internal/services"
 )
 
 type ctxKey string
 
-const ctxCallerKey ctxKey = "caller_api_key"
+const (
+	ctxCallerKey  ctxKey = "caller_api_key"
+	ctxCallerRole ctxKey = "caller_role"
+)
 
 var reqIDSeq uint64
 
@@ -80,20 +86,108 @@ func WithRequestLogging() func(http.Handler) http.Handler {
 	}
 }
 
+// OwnerResolver identifies a request's caller: the owner key conversations
+// and other records are stored under, and the caller's role. handled is
+// false when the request carries no credential the resolver reads; a
+// non-nil err (an *authError) refuses the request.
+type OwnerResolver interface {
+	ResolveOwner(r *http.Request) (owner, role string, handled bool, err error)
+}
+
+// authError is a refused credential, written as an error body.
+type authError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *authError) Error() string { return e.code }
+
+// APIKeyResolver resolves the X-API-Key header against AGENT_API_KEYS; the
+// key itself is the owner key.
+type APIKeyResolver map[string]string
+
+func (k APIKeyResolver) ResolveOwner(r *http.Request) (string, string, bool, error) {
+	key := strings.TrimSpace(r.Header.Get("X-API-Key"))
+	if key == "" {
+		return "", "", false, nil
+	}
+	role, ok := k[key]
+	if !ok {
+		return "", "", true, &authError{status: http.StatusForbidden, code: "invalid_x_api_key"}
+	}
+	return key, role, true, nil
+}
+
+// WithAPIKey authenticates with the API keys, and with bearer JWTs when
+// JWKS_URL is set. An X-API-Key header wins over a bearer token, so machine
+// callers behind a JWT-issuing proxy keep their key's identity.
 func WithAPIKey(cfg config.Config) func(http.Handler) http.Handler {
+	resolvers := []OwnerResolver{APIKeyResolver(cfg.AgentAPIKeys)}
+	if cfg.JWKSURL != "" {
+		resolvers = append(resolvers, NewJWTResolver(cfg))
+	}
+	return WithOwner(resolvers...)
+}
+
+// WithOwner stores the caller resolved by the first resolver that handles
+// the request in its context. A request no resolver handles gets 401.
+func WithOwner(resolvers ...OwnerResolver) func(http.Handler) http.Handler {
+	missing := "missing_x_api_key"
+	for _, res := range resolvers {
+		if _, ok := res.(*JWTResolver); ok {
+			missing = "missing_credentials"
+		}
+	}
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			key := strings.TrimSpace(r.Header.Get("X-API-Key"))
-			if key == "" {
-				writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "missing_x_api_key"})
+			for _, res := range resolvers {
+				owner, role, handled, err := res.ResolveOwner(r)
+				if !handled {
+					continue
+				}
+				var ae *authError
+				if errors.As(err, &ae) {
+					body := map[string]any{"error": ae.code}
+					if ae.message != "" {
+						body["message"] = ae.message
+					}
+					if ae.code == "invalid_token" {
+						w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					}
+					writeJSON(w, ae.status, body)
+					return
+				}
+				if err != nil {
+					writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "unauthorized"})
+					return
+				}
+				ctx := context.WithValue(r.Context(), ctxCallerKey, owner)
+				ctx = context.WithValue(ctx, ctxCallerRole, role)
+				ctx = services.WithKeyRole(ctx, role)
+				next.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
-			if _, ok := cfg.AgentAPIKeys[key]; !ok {
-				writeJSON(w, http.StatusForbidden, map[string]any{"error": "invalid_x_api_key"})
+			if missing == "missing_credentials" {
+				writeJSON(w, http.StatusUnauthorized, map[string]any{"error": missing, "message": "Send an X-API-Key header or an Authorization: Bearer token."})
 				return
 			}
-			ctx := context.WithValue(r.Context(), ctxCallerKey, key)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			writeJSON(w, http.StatusUnauthorized, map[string]any{"error": missing})
+		})
+	}
+}
+
+// WithAdminRole refuses read-only callers with 403 forbidden. It runs
+// after WithAPIKey.
+func WithAdminRole() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if CallerRole(r) != models.KeyRoleAdmin {
+				log.Printf("refused %s %s: role=%s", r.Method, r.URL.Path, CallerRole(r))
+				writeJSON(w, http.StatusForbidden, map[string]any{"error": "forbidden", "message": "This API key is read-only."})
+				return
+			}
+			next.ServeHTTP(w, r)
 		})
 	}
 }
@@ -103,7 +197,20 @@ func CallerKey(r *http.Request) string {
 	return strings.TrimSpace(v)
 }
 
+// CallerRole returns the role of the request's API key.
+func CallerRole(r *http.Request) string {
+	v, _ := r.Context().Value(ctxCallerRole).(string)
+	return v
+}
+
+// writeJSON writes v as JSON. Error bodies ({"error": code, ...} with a 4xx or
+// 5xx status) also carry the RFC 7807 problem fields.
 func writeJSON(w http.ResponseWriter, status int, v any) {
+	if m, ok := v.(map[string]any); ok && status >= 400 {
+		if _, isErr := m["error"].(string); isErr {
+			v = problemFields(status, m)
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(v)
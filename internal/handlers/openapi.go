@@ -0,0 +1,442 @@
+package handlers
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+	"openai-agent-service/internal/services"
+)
+
+// schemaBuilder derives OpenAPI schemas from the Go types the handlers encode
+// and decode, so the published contract cannot drift from the structs. Named
+// struct types become components; everything else is inlined.
+type schemaBuilder struct {
+	components map[string]any
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+)
+
+// override covers types whose MarshalJSON changes their wire shape.
+func (b *schemaBuilder) override(t reflect.Type) map[string]any {
+	switch t {
+	case reflect.TypeOf(models.GeoFeatureCollection{}):
+		return b.component("GeoFeatureCollection", map[string]any{
+			"type":     "object",
+			"required": []string{"type", "features"},
+			"properties": map[string]any{
+				"type":     map[string]any{"type": "string", "enum": []string{"FeatureCollection"}},
+				"features": map[string]any{"type": "array", "items": b.schema(reflect.TypeOf(models.GeoFeature{}))},
+			},
+		})
+	case reflect.TypeOf(models.GeoFeature{}):
+		return b.component("GeoFeature", map[string]any{
+			"type":     "object",
+			"required": []string{"type", "geometry", "properties"},
+			"properties": map[string]any{
+				"type": map[string]any{"type": "string", "enum": []string{"Feature"}},
+				"geometry": map[string]any{
+					"type":     "object",
+					"required": []string{"type", "coordinates"},
+					"properties": map[string]any{
+						"type":        map[string]any{"type": "string", "enum": []string{"Point"}},
+						"coordinates": map[string]any{"type": "array", "items": map[string]any{"type": "number"}, "minItems": 2, "maxItems": 2, "description": "Longitude, latitude."},
+					},
+				},
+				"properties": map[string]any{"type": "object", "additionalProperties": true},
+			},
+		})
+	}
+	return nil
+}
+
+func (b *schemaBuilder) component(name string, s map[string]any) map[string]any {
+	if _, ok := b.components[name]; !ok {
+		b.components[name] = s
+	}
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+	if s := b.override(t); s != nil {
+		return s
+	}
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]any{"description": "Arbitrary JSON."}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Array:
+		return map[string]any{"type": "array", "items": b.schema(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		if _, ok := b.components[t.Name()]; !ok {
+			// Reserve the name first so recursive types terminate.
+			b.components[t.Name()] = nil
+			b.components[t.Name()] = b.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	// Interfaces (any) accept any JSON value.
+	return map[string]any{}
+}
+
+// object maps exported fields by their json tags. Nothing is marked required:
+// the same structs are request bodies, where most fields are optional, and
+// responses.
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = b.schema(f.Type)
+	}
+	return map[string]any{"type": "object", "properties": props}
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// OpenAPISpec builds the OpenAPI 3 document for this service's HTTP API.
+func OpenAPISpec() map[string]any {
+	b := &schemaBuilder{components: map[string]any{}}
+	ref := func(t reflect.Type) map[string]any { return b.schema(t) }
+	data := func(s map[string]any) map[string]any {
+		return map[string]any{"type": "object", "required": []string{"data"}, "properties": map[string]any{"data": s}}
+	}
+	list := func(t reflect.Type) map[string]any {
+		return data(map[string]any{"type": "array", "items": ref(t)})
+	}
+	errSchema := b.component("Error", map[string]any{
+		"type":     "object",
+		"required": []string{"error"},
+		"properties": map[string]any{
+			"error":     map[string]any{"type": "string", "description": "Machine-readable code, e.g. invalid_json or not_found."},
+			"message":   map[string]any{"type": "string"},
+			"retryable": map[string]any{"type": "boolean"},
+			"status":    map[string]any{"type": "integer", "description": "HTTP status; also set on stream error events."},
+			"type":      map[string]any{"type": "string", "description": "RFC 7807 problem type, urn:problem-type:<error>."},
+			"title":     map[string]any{"type": "string", "description": "RFC 7807 title: the HTTP status text."},
+			"detail":    map[string]any{"type": "string", "description": "RFC 7807 detail: the message, or the error code in words."},
+			"instance":  map[string]any{"type": "string", "description": "Set on unexpected server errors (internal_error); the id is also logged."},
+			"fields":    map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "string"}, "description": "Set on invalid_query: what is wrong with each field, e.g. params.city."},
+		},
+	})
+	jsonBody := func(s map[string]any) map[string]any {
+		return map[string]any{"application/json": map[string]any{"schema": s}}
+	}
+	resp := func(desc string, s map[string]any) map[string]any {
+		return map[string]any{"description": desc, "content": jsonBody(s)}
+	}
+	// Error bodies are problem details; clients sending Accept:
+	// application/problem+json get that content type.
+	errResp := func(desc string) map[string]any {
+		return map[string]any{"description": desc, "content": map[string]any{
+			"application/json":         map[string]any{"schema": errSchema},
+			"application/problem+json": map[string]any{"schema": errSchema},
+		}}
+	}
+	op := func(summary string, tag string, body map[string]any, ok map[string]any, errs map[string]string) map[string]any {
+		o := map[string]any{"summary": summary, "tags": []string{tag}}
+		responses := map[string]any{"200": resp("OK", ok)}
+		for code, desc := range errs {
+			responses[code] = errResp(desc)
+		}
+		o["responses"] = responses
+		if body != nil {
+			o["requestBody"] = map[string]any{"required": true, "content": jsonBody(body)}
+		}
+		return o
+	}
+	secured := func(o map[string]any) map[string]any {
+		o["security"] = []map[string][]string{{"ApiKey": {}}, {"BearerAuth": {}}}
+		r := o["responses"].(map[string]any)
+		r["401"] = errResp("missing_x_api_key, or with JWTs enabled missing_credentials or invalid_token.")
+		if prev, ok := r["403"].(map[string]any); ok {
+			r["403"] = errResp("invalid_x_api_key, or " + prev["description"].(string))
+		} else {
+			r["403"] = errResp("invalid_x_api_key.")
+		}
+		return o
+	}
+	// adminOnly marks an operation that read-only API keys may not call.
+	adminOnly := func(o map[string]any) map[string]any {
+		o = secured(o)
+		o["responses"].(map[string]any)["403"] = errResp("invalid_x_api_key, or forbidden: the API key is read-only.")
+		return o
+	}
+	idParam := func(desc string) []map[string]any {
+		return []map[string]any{{"name": "id", "in": "path", "required": true, "description": desc, "schema": map[string]any{"type": "string"}}}
+	}
+	limitParam := []map[string]any{{"name": "limit", "in": "query", "schema": map[string]any{"type": "integer", "default": 20}}}
+	withParams := func(o map[string]any, params []map[string]any) map[string]any {
+		o["parameters"] = params
+		return o
+	}
+	deleted := data(map[string]any{"type": "object", "required": []string{"deleted"}, "properties": map[string]any{"deleted": map[string]any{"type": "string"}}})
+
+	chatErrs := map[string]string{
+		"400": "invalid_json, message_required or invalid_idempotency_key.",
+		"403": "conversation_forbidden: the conversation belongs to another API key.",
+		"409": "duplicate_in_flight: an identical request in this conversation, or one with the same idempotency key, is still running; or idempotency_key_lost: the request lost its idempotency key before a gateway mutation, which was skipped.",
+		"422": "idempotency_key_reused: the idempotency key was first used with a different request.",
+		"500": "chat_failed, or internal_error for an unexpected server error.",
+		"502": "gateway_auth_failed: the tool gateway rejected both configured API keys; or openai_failed: the OpenAI API returned an error.",
+		"503": "gateway_unavailable: the tool gateway circuit breaker is open.",
+		"504": "timeout: an upstream call did not answer in time.",
+	}
+	streamOp := secured(op("Ask a question and stream the answer", "chat", ref(typeOf[models.ChatRequest]()), nil, nil))
+	streamOp["responses"].(map[string]any)["200"] = map[string]any{
+		"description": "Server-Sent Events. `event: token` carries a StreamToken (a chunk of the answer text), " +
+			"`event: final` carries the full ChatResponse (same shape as POST /chat), and `event: error` carries an Error " +
+			"(invalid_json, message_required, invalid_idempotency_key, idempotency_key_reused, idempotency_key_lost, conversation_forbidden, gateway_unavailable, gateway_auth_failed, duplicate_in_flight, timeout, openai_failed, chat_failed or internal_error, whose instance is logged with the stack). " +
+			"Comment lines (`: heartbeat`) keep idle proxies from closing the stream.",
+		"content": map[string]any{"text/event-stream": map[string]any{"schema": map[string]any{"type": "string"}}},
+	}
+	extractOp := withParams(secured(op("Download a POP extract as CSV", "chat", nil, nil, map[string]string{
+		"404": "not_found (unknown, deleted after expiry, or owned by another key).",
+		"410": "extract_expired: the link's validity has passed; ask for the export again.",
+		"502": "extract_failed: the first /pop page could not be read. A failure after rows were sent aborts the connection instead.",
+	})), idParam("Extract id from the link in a chat answer."))
+	extractOp["responses"].(map[string]any)["200"] = map[string]any{
+		"description": "CSV with a header row (pop_datetime, poster_id, poster_name, poster_type, host_name, kiosk_name, city, region, play_count, kiosk_lat, kiosk_long), streamed as the rows are read from the gateway.",
+		"content":     map[string]any{"text/csv": map[string]any{"schema": map[string]any{"type": "string"}}},
+	}
+	b.component("StreamToken", map[string]any{
+		"type":       "object",
+		"required":   []string{"text"},
+		"properties": map[string]any{"text": map[string]any{"type": "string"}},
+	})
+
+	// Feedback is stored in the background: 202 instead of 200.
+	feedbackOp := withParams(secured(op("Rate an assistant message", "conversations", ref(typeOf[models.FeedbackRequest]()), nil, map[string]string{
+		"400": "conversation_id_required, invalid_message_id, invalid_json, invalid_rating or comment_too_long.",
+		"404": "not_found: no such conversation, or feedback is not configured.",
+		"500": "get_conversation_failed.",
+		"503": "feedback_busy: too many feedback writes are pending.",
+	})), append(idParam("Conversation id."), map[string]any{"name": "messageId", "in": "path", "required": true, "description": "Id of an assistant message, as listed by GET /conversations/{id}/messages.", "schema": map[string]any{"type": "integer"}}))
+	feedbackResponses := feedbackOp["responses"].(map[string]any)
+	delete(feedbackResponses, "200")
+	feedbackResponses["202"] = resp("Accepted; the rating is written in the background. A message id that is not an assistant message of the conversation is ignored.", data(map[string]any{
+		"type":     "object",
+		"required": []string{"status", "message_id", "rating"},
+		"properties": map[string]any{
+			"status":     map[string]any{"type": "string"},
+			"message_id": map[string]any{"type": "integer"},
+			"rating":     map[string]any{"type": "string", "enum": []string{"up", "down"}},
+		},
+	}))
+
+	chatOp := withParams(secured(op("Ask a question", "chat", ref(typeOf[models.ChatRequest]()), ref(typeOf[models.ChatResponse]()), chatErrs)),
+		[]map[string]any{{"name": "async", "in": "query", "description": "Queue the request and answer 202 with a ChatJob instead of waiting; poll GET /jobs/{id} for the result.", "schema": map[string]any{"type": "boolean", "default": false}}})
+	chatResponses := chatOp["responses"].(map[string]any)
+	chatResponses["202"] = resp("Accepted (async=true): the queued job. Its Location header is the job's URL.", ref(typeOf[models.ChatJob]()))
+	chatResponses["400"] = errResp("invalid_json, message_required, invalid_idempotency_key, or with async=true invalid_webhook_url.")
+	chatResponses["429"] = errResp("too_many_queued_jobs (async=true): the API key has ASYNC_MAX_QUEUED_PER_KEY jobs queued or running.")
+	chatResponses["503"] = errResp("gateway_unavailable: the tool gateway circuit breaker is open; or async_disabled (async=true).")
+
+	runErrs := map[string]string{"404": "not_found: no saved query has this name."}
+	for code, desc := range chatErrs {
+		runErrs[code] = desc
+	}
+	runSavedOp := withParams(secured(op("Run a saved query", "saved-queries", ref(typeOf[models.ChatRequest]()), ref(typeOf[models.ChatResponse]()), runErrs)),
+		[]map[string]any{{"name": "name", "in": "path", "required": true, "description": "Saved query name; case and spacing are ignored.", "schema": map[string]any{"type": "string"}}})
+	// The body is optional: its conversation_id and options apply, its message is ignored.
+	runSavedOp["requestBody"].(map[string]any)["required"] = false
+
+	paths := map[string]any{
+		"/health": map[string]any{"get": op("Liveness probe", "health", nil, map[string]any{"type": "object", "properties": map[string]any{"status": map[string]any{"type": "string"}}}, nil)},
+		"/readyz": map[string]any{"get": op("Readiness probe", "health", nil, map[string]any{
+			"type":     "object",
+			"required": []string{"status", "gateway_breaker"},
+			"properties": map[string]any{
+				"status":          map[string]any{"type": "string", "enum": []string{"ok", "gateway_unavailable"}},
+				"gateway_breaker": ref(typeOf[services.BreakerStatus]()),
+				"schema_drift":    ref(typeOf[services.DriftReport]()),
+			},
+		}, map[string]string{"503": "The gateway circuit breaker is open."})},
+		"/conversations": map[string]any{
+			"get":  withParams(secured(op("List the caller's conversations", "conversations", nil, list(typeOf[models.Conversation]()), map[string]string{"500": "list_conversations_failed."})), limitParam),
+			"post": secured(op("Create a conversation", "conversations", nil, data(ref(typeOf[models.Conversation]())), map[string]string{"500": "create_conversation_failed."})),
+		},
+		"/conversations/import": map[string]any{
+			"post": secured(op("Import a transcript from another chat system", "conversations", ref(typeOf[models.ConversationImport]()), data(ref(typeOf[models.ConversationImportResult]())), map[string]string{
+				"400": "invalid_json, or invalid_transcript: no messages, more than 500, an unknown role, empty or oversized content (32 KiB), or a timestamp out of order or in the future.",
+				"413": "transcript_too_large.",
+				"500": "import_conversation_failed.",
+				"503": "import_disabled.",
+			})),
+		},
+		"/conversations/{id}": map[string]any{
+			"get": withParams(secured(op("Get a conversation", "conversations", nil, data(ref(typeOf[models.Conversation]())), map[string]string{"404": "not_found."})), idParam("Conversation id.")),
+			"patch": withParams(secured(op("Rename a conversation", "conversations", map[string]any{
+				"type":       "object",
+				"required":   []string{"title"},
+				"properties": map[string]any{"title": map[string]any{"type": "string"}},
+			}, data(ref(typeOf[models.Conversation]())), map[string]string{"400": "invalid_json or title_required.", "404": "not_found."})), idParam("Conversation id.")),
+		},
+		"/conversations/{id}/messages": map[string]any{
+			"get": withParams(secured(op("List recent messages", "conversations", nil, list(typeOf[models.Message]()), map[string]string{"500": "list_messages_failed."})), append(idParam("Conversation id."), limitParam...)),
+		},
+		"/conversations/{id}/messages/{messageId}/feedback": map[string]any{"post": feedbackOp},
+		"/chat": map[string]any{"post": chatOp},
+		"/jobs/{id}": map[string]any{
+			"get": withParams(secured(op("Get an async chat job", "chat", nil, ref(typeOf[models.ChatJob]()), map[string]string{"404": "not_found (unknown, expired, owned by another key, or async mode off)."})), idParam("Job id from POST /chat?async=true.")),
+		},
+		"/extracts/{id}.csv": map[string]any{"get": extractOp},
+		"/chat/stream":       map[string]any{"post": streamOp},
+		"/query": map[string]any{
+			"post": secured(op("Compute the numbers behind an answer, without prose", "chat", ref(typeOf[models.QueryRequest]()), ref(typeOf[models.QueryResult]()), map[string]string{
+				"400": "invalid_json, or invalid_query: an unknown intent or missing or malformed parameters, listed in fields.",
+				"500": "query_failed: the tool gateway is not configured.",
+				"502": "query_failed: the tool gateway answered with an error; or gateway_auth_failed.",
+				"503": "gateway_unavailable: the tool gateway circuit breaker is open.",
+				"504": "timeout: an upstream call did not answer in time.",
+			})),
+		},
+		"/admin/caches": map[string]any{
+			"get": adminOnly(op("Inspect scope-detection caches", "admin", nil, list(typeOf[models.CacheInfo]()), nil)),
+		},
+		"/admin/caches/flush": map[string]any{
+			"post": adminOnly(op("Flush caches", "admin", ref(typeOf[models.CacheFlushRequest]()), data(map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"flushed": map[string]any{"type": "array", "items": map[string]any{"type": "string"}}},
+			}), map[string]string{"400": "invalid_json or unknown_cache."})),
+		},
+		"/admin/reload-credentials": map[string]any{
+			"post": adminOnly(op("Reload the tool gateway API keys", "admin", nil, data(map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"reloaded": map[string]any{"type": "boolean"},
+					"keys":     map[string]any{"type": "integer", "description": "Number of keys now in use (2 when a secondary is set)."},
+				},
+			}), map[string]string{"500": "reload_credentials_failed: the key file could not be read or has no primary key."})),
+		},
+		"/admin/debug/conversations/{id}": map[string]any{
+			"put": withParams(adminOnly(op("Flag a conversation for debug capture", "admin", ref(typeOf[models.DebugFlagRequest]()), data(map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"conversation_id": map[string]any{"type": "string"},
+					"enabled":         map[string]any{"type": "boolean"},
+				},
+			}), map[string]string{"400": "invalid_json."})), idParam("Conversation id.")),
+		},
+		"/debug/{id}": map[string]any{
+			"get": withParams(adminOnly(op("Fetch a debug bundle", "admin", nil, data(ref(typeOf[models.DebugBundle]())), map[string]string{"404": "not_found (unknown or expired)."})), idParam("Debug bundle id from meta.debug_id.")),
+		},
+		"/steps/{bodyId}": map[string]any{
+			"get": withParams(secured(op("Fetch the full body of a clipped step", "chat", nil, data(ref(typeOf[models.StepBody]())), map[string]string{"404": "not_found (unknown, expired, spillover off, or asked by another non-admin key)."})), []map[string]any{{"name": "bodyId", "in": "path", "required": true, "description": "Step body id from steps[].body_id.", "schema": map[string]any{"type": "string"}}}),
+		},
+		"/admin/handlers": map[string]any{
+			"get": adminOnly(op("List deterministic handlers with their flags and hit counts", "admin", nil, list(typeOf[models.HandlerState]()), nil)),
+		},
+		"/admin/handlers/{name}": map[string]any{
+			"patch": withParams(adminOnly(op("Enable or disable a deterministic handler", "admin", ref(typeOf[models.HandlerFlagRequest]()), data(map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"name":    map[string]any{"type": "string"},
+					"enabled": map[string]any{"type": "boolean"},
+				},
+			}), map[string]string{"400": "invalid_json or enabled_required.", "404": "unknown_handler."})),
+				[]map[string]any{{"name": "name", "in": "path", "required": true, "description": "Handler name as listed by GET /admin/handlers.", "schema": map[string]any{"type": "string"}}}),
+		},
+		"/admin/dead-letters": map[string]any{
+			"get": withParams(adminOnly(op("List side effects that used up their delivery attempts", "admin", nil, list(typeOf[models.DeadLetter]()), map[string]string{"400": "invalid_limit.", "500": "list_dead_letters_failed."})),
+				[]map[string]any{{"name": "limit", "in": "query", "description": "Larger values are clamped to 500.", "schema": map[string]any{"type": "integer", "default": 50, "minimum": 1, "maximum": 500}}}),
+		},
+		"/admin/dead-letters/{id}/retry": map[string]any{
+			"post": withParams(adminOnly(op("Re-queue a dead letter", "admin", nil, data(ref(typeOf[models.OutboxEntry]())), map[string]string{"400": "dead_letter_id_required.", "404": "not_found."})), idParam("Dead letter id.")),
+		},
+		"/analytics/usage": map[string]any{
+			"get": withParams(adminOnly(op("Summarize chat usage by handler", "admin", nil, data(ref(typeOf[models.UsageSummary]())), map[string]string{
+				"400": "invalid_from, invalid_to or invalid_range.",
+				"500": "usage_summary_failed.",
+			})), []map[string]any{
+				{"name": "from", "in": "query", "description": "Start (inclusive), RFC 3339 or YYYY-MM-DD; default 7 days before to.", "schema": map[string]any{"type": "string"}},
+				{"name": "to", "in": "query", "description": "End (exclusive), RFC 3339 or YYYY-MM-DD; default now.", "schema": map[string]any{"type": "string"}},
+			}),
+		},
+		"/analytics/feedback": map[string]any{
+			"get": withParams(adminOnly(op("Summarize answer ratings by handler", "admin", nil, data(ref(typeOf[models.FeedbackSummary]())), map[string]string{
+				"400": "invalid_from, invalid_to or invalid_range.",
+				"404": "not_found: feedback is not configured.",
+				"500": "feedback_summary_failed.",
+			})), []map[string]any{
+				{"name": "from", "in": "query", "description": "Start (inclusive) of the answers' time, RFC 3339 or YYYY-MM-DD; default 7 days before to.", "schema": map[string]any{"type": "string"}},
+				{"name": "to", "in": "query", "description": "End (exclusive), RFC 3339 or YYYY-MM-DD; default now.", "schema": map[string]any{"type": "string"}},
+			}),
+		},
+		"/alerts": map[string]any{
+			"get":  secured(op("List alert rules", "alerts", nil, list(typeOf[models.AlertRule]()), nil)),
+			"post": secured(op("Create an alert rule", "alerts", ref(typeOf[models.AlertRule]()), data(ref(typeOf[models.AlertRule]())), map[string]string{"400": "invalid_json, invalid_alert_rule or invalid_webhook_url.", "403": "conversation_forbidden."})),
+		},
+		"/alerts/{id}": map[string]any{
+			"delete": withParams(secured(op("Delete an alert rule", "alerts", nil, deleted, map[string]string{"404": "not_found."})), idParam("Alert rule id.")),
+		},
+		"/targets": map[string]any{
+			"get": withParams(secured(op("List play targets", "targets", nil, list(typeOf[models.PlayTarget]()), nil)),
+				[]map[string]any{{"name": "period", "in": "query", "description": "Month as YYYY-MM.", "schema": map[string]any{"type": "string"}}}),
+			"post": secured(op("Create a play target", "targets", ref(typeOf[models.PlayTarget]()), data(ref(typeOf[models.PlayTarget]())), map[string]string{"400": "invalid_json or invalid_play_target."})),
+		},
+		"/saved-queries": map[string]any{
+			"get": secured(op("List saved queries", "saved-queries", nil, list(typeOf[models.SavedQuery]()), map[string]string{"500": "list_saved_queries_failed."})),
+		},
+		"/saved-queries/{name}/run": map[string]any{
+			"post": runSavedOp,
+		},
+		"/targets/{id}": map[string]any{
+			"delete": withParams(secured(op("Delete a play target", "targets", nil, deleted, map[string]string{"404": "not_found."})), idParam("Play target id.")),
+		},
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "openai-agent-service",
+			"version":     "1",
+			"description": "Chat agent over the SCM tool gateway. Generated from the service's Go types.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": b.components,
+			"securitySchemes": map[string]any{
+				"ApiKey":     map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"BearerAuth": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT", "description": "Accepted when JWKS_URL is set."},
+			},
+		},
+	}
+}
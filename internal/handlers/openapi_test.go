@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"openai-agent-service/internal/models"
+	"openai-agent-service/internal/services"
+)
+
+// Request bodies are decoded back into their type as well, so a field the
+// spec documents but the server would reject or drop is caught.
+var (
+	specRequestTypes = []reflect.Type{
+		typeOf[models.ChatRequest](),
+		typeOf[models.QueryRequest](),
+		typeOf[models.FeedbackRequest](),
+		typeOf[models.ConversationImport](),
+		typeOf[models.CacheFlushRequest](),
+		typeOf[models.DebugFlagRequest](),
+		typeOf[models.HandlerFlagRequest](),
+		typeOf[models.AlertRule](),
+		typeOf[models.PlayTarget](),
+	}
+	specResponseTypes = []reflect.Type{
+		typeOf[models.ChatResponse](),
+		typeOf[models.ChatJob](),
+		typeOf[models.Conversation](),
+		typeOf[models.ConversationImportResult](),
+		typeOf[models.Message](),
+		typeOf[models.QueryResult](),
+		typeOf[models.CacheInfo](),
+		typeOf[models.DebugBundle](),
+		typeOf[models.StepBody](),
+		typeOf[models.HandlerState](),
+		typeOf[models.DeadLetter](),
+		typeOf[models.OutboxEntry](),
+		typeOf[models.UsageSummary](),
+		typeOf[models.FeedbackSummary](),
+		typeOf[models.SavedQuery](),
+		typeOf[models.GeoFeatureCollection](),
+		typeOf[services.BreakerStatus](),
+		typeOf[services.DriftReport](),
+	}
+)
+
+// specComponents returns the spec's schemas as a client sees them, after a
+// JSON round trip.
+func specComponents(t *testing.T) map[string]any {
+	t.Helper()
+	b, err := json.Marshal(OpenAPISpec())
+	if err != nil {
+		t.Fatalf("spec does not encode: %v", err)
+	}
+	var spec map[string]any
+	if err := json.Unmarshal(b, &spec); err != nil {
+		t.Fatal(err)
+	}
+	return spec["components"].(map[string]any)["schemas"].(map[string]any)
+}
+
+// Every API type, filled in completely and encoded, must match its published
+// schema exactly: no field the schema lacks, none it lists that the type
+// does not send, and every value of the documented type.
+func TestOpenAPISpecMatchesTypes(t *testing.T) {
+	components := specComponents(t)
+	for _, typ := range append(append([]reflect.Type(nil), specRequestTypes...), specResponseTypes...) {
+		t.Run(typ.Name(), func(t *testing.T) {
+			if _, ok := components[typ.Name()]; !ok {
+				t.Fatalf("no component schema %s", typ.Name())
+			}
+			sample := reflect.New(typ)
+			fillSample(sample.Elem(), 0)
+			b, err := json.Marshal(sample.Interface())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var payload any
+			if err := json.Unmarshal(b, &payload); err != nil {
+				t.Fatal(err)
+			}
+			v := &specValidator{components: components}
+			v.check(typ.Name(), payload, map[string]any{"$ref": "#/components/schemas/" + typ.Name()})
+			for _, e := range v.errs {
+				t.Error(e)
+			}
+		})
+	}
+}
+
+func TestOpenAPIRequestBodiesRoundTrip(t *testing.T) {
+	for _, typ := range specRequestTypes {
+		t.Run(typ.Name(), func(t *testing.T) {
+			sample := reflect.New(typ)
+			fillSample(sample.Elem(), 0)
+			b, err := json.Marshal(sample.Interface())
+			if err != nil {
+				t.Fatal(err)
+			}
+			back := reflect.New(typ)
+			dec := json.NewDecoder(bytes.NewReader(b))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(back.Interface()); err != nil {
+				t.Fatalf("decoding %s: %v", b, err)
+			}
+			if !reflect.DeepEqual(sample.Interface(), back.Interface()) {
+				t.Errorf("round trip changed the body\n sent %+v\n  got %+v", sample.Elem(), back.Elem())
+			}
+		})
+	}
+}
+
+const sampleDepth = 32
+
+// fillSample sets every encoded field of v to a non-zero value, so
+// omitempty drops nothing. Recursive types are filled to sampleDepth.
+func fillSample(v reflect.Value, depth int) {
+	if depth > sampleDepth {
+		return
+	}
+	switch v.Type() {
+	case timeType:
+		v.Set(reflect.ValueOf(time.Date(2024, 10, 1, 9, 30, 0, 0, time.UTC)))
+		return
+	case rawMessageType:
+		v.Set(reflect.ValueOf(json.RawMessage(`{"k":1}`)))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		fillSample(v.Elem(), depth+1)
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(2)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(2)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.Slice:
+		s := reflect.MakeSlice(v.Type(), 1, 1)
+		fillSample(s.Index(0), depth+1)
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fillSample(v.Index(i), depth+1)
+		}
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		k := reflect.New(v.Type().Key()).Elem()
+		fillSample(k, depth+1)
+		e := reflect.New(v.Type().Elem()).Elem()
+		fillSample(e, depth+1)
+		m.SetMapIndex(k, e)
+		v.Set(m)
+	case reflect.Interface:
+		v.Set(reflect.ValueOf("x"))
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if !f.IsExported() || f.Tag.Get("json") == "-" {
+				continue
+			}
+			fillSample(v.Field(i), depth+1)
+		}
+	}
+}
+
+// specValidator checks a decoded JSON value against the subset of OpenAPI
+// schema keywords OpenAPISpec emits. Objects with properties and no
+// additionalProperties are closed.
+type specValidator struct {
+	components map[string]any
+	errs       []string
+}
+
+func (v *specValidator) fail(path, format string, args ...any) {
+	v.errs = append(v.errs, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *specValidator) check(path string, val any, schema map[string]any) {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		s, ok := v.components[name].(map[string]any)
+		if !ok {
+			v.fail(path, "dangling $ref %s", ref)
+			return
+		}
+		schema = s
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			found = found || e == val
+		}
+		if !found {
+			v.fail(path, "%v is not one of %v", val, enum)
+		}
+	}
+	switch schema["type"] {
+	case nil:
+		// Any JSON value.
+	case "string":
+		if _, ok := val.(string); !ok {
+			v.fail(path, "%T, want a string", val)
+		}
+	case "boolean":
+		if _, ok := val.(bool); !ok {
+			v.fail(path, "%T, want a boolean", val)
+		}
+	case "number":
+		if _, ok := val.(float64); !ok {
+			v.fail(path, "%T, want a number", val)
+		}
+	case "integer":
+		if n, ok := val.(float64); !ok || n != math.Trunc(n) {
+			v.fail(path, "%v, want an integer", val)
+		}
+	case "array":
+		items, ok := val.([]any)
+		if !ok {
+			v.fail(path, "%T, want an array", val)
+			return
+		}
+		if n, ok := schema["minItems"].(float64); ok && len(items) < int(n) {
+			v.fail(path, "%d items, want at least %v", len(items), n)
+		}
+		if n, ok := schema["maxItems"].(float64); ok && len(items) > int(n) {
+			v.fail(path, "%d items, want at most %v", len(items), n)
+		}
+		itemSchema, _ := schema["items"].(map[string]any)
+		for i, it := range items {
+			v.check(fmt.Sprintf("%s[%d]", path, i), it, itemSchema)
+		}
+	case "object":
+		obj, ok := val.(map[string]any)
+		if !ok {
+			v.fail(path, "%T, want an object", val)
+			return
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for _, r := range asStrings(schema["required"]) {
+			if _, ok := obj[r]; !ok {
+				v.fail(path, "required property %q missing", r)
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if ps, ok := props[k].(map[string]any); ok {
+				v.check(path+"."+k, obj[k], ps)
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case map[string]any:
+				v.check(path+"."+k, obj[k], extra)
+			case bool:
+				if !extra {
+					v.fail(path, "property %q is not in the spec", k)
+				}
+			default:
+				if props != nil {
+					v.fail(path, "property %q is not in the spec", k)
+				}
+			}
+		}
+		for k := range props {
+			if _, ok := obj[k]; !ok {
+				v.fail(path, "the spec lists %q, which the type does not send", k)
+			}
+		}
+	default:
+		v.fail(path, "unknown schema type %v", schema["type"])
+	}
+}
+
+func asStrings(v any) []string {
+	list, _ := v.([]any)
+	out := make([]string, 0, len(list))
+	for _, s := range list {
+		if str, ok := s.(string); ok {
+			out = append(out, str)
+		}
+	}
+	return out
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// problemContentType is the RFC 7807 media type for error bodies.
+const problemContentType = "application/problem+json"
+
+// problemTypePrefix prefixes the error code to form the problem type URI.
+const problemTypePrefix = "urn:problem-type:"
+
+// problemFields adds the RFC 7807 members to an error body written by
+// writeJSON. The error code and any other fields are kept as extension
+// members, so clients reading {"error": "..."} keep working.
+func problemFields(status int, body map[string]any) map[string]any {
+	code, _ := body["error"].(string)
+	out := make(map[string]any, len(body)+4)
+	for k, v := range body {
+		out[k] = v
+	}
+	out["type"] = problemTypePrefix + code
+	out["title"] = http.StatusText(status)
+	out["status"] = status
+	if _, ok := out["detail"]; !ok {
+		if msg, _ := body["message"].(string); msg != "" {
+			out["detail"] = msg
+		} else {
+			out["detail"] = strings.ReplaceAll(code, "_", " ")
+		}
+	}
+	return out
+}
+
+// wantsProblem reports whether the Accept header asks for problem+json.
+func wantsProblem(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, _ := strings.Cut(part, ";")
+		if strings.EqualFold(strings.TrimSpace(mt), problemContentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// problemWriter switches JSON error responses to the problem+json content
+// type for clients that asked for it, and remembers whether the header went
+// out so a panic after streaming started is not answered twice.
+type problemWriter struct {
+	http.ResponseWriter
+	problem     bool
+	wroteHeader bool
+}
+
+func (w *problemWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *problemWriter) WriteHeader(code int) {
+	if w.problem && code >= 400 && w.Header().Get("Content-Type") == "application/json" {
+		w.Header().Set("Content-Type", problemContentType)
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *problemWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(p)
+}
+
+// WithProblemDetails renders errors as RFC 7807 problem details: a panic in a
+// handler becomes a 500 with an instance id that is also logged, and error
+// bodies are sent as application/problem+json when the Accept header asks for
+// it (application/json otherwise; the body is the same).
+func WithProblemDetails() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pw := &problemWriter{ResponseWriter: w, problem: wantsProblem(r)}
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				instance := "urn:uuid:" + uuid.NewString()
+				log.Printf("http panic instance=%s method=%s path=%s err=%v\n%s", instance, r.Method, r.URL.Path, v, debug.Stack())
+				if pw.wroteHeader {
+					return
+				}
+				writeJSON(pw, http.StatusInternalServerError, map[string]any{"error": "internal_error", "message": "The server hit an unexpected error; quote the instance id when reporting it.", "instance": instance})
+			}()
+			next.ServeHTTP(pw, r)
+		})
+	}
+}
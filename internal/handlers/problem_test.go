@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"openai-agent-service/internal/services"
+)
+
+func TestProblemDetails(t *testing.T) {
+	gateway := func(status int) string {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"error":"upstream"}`))
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL
+	}
+	query := func(gatewayURL string) http.Handler {
+		h := &QueryHandlers{Chat: &services.ChatService{Gateway: &services.GatewayClient{BaseURL: gatewayURL}}}
+		return http.HandlerFunc(h.HandleQuery)
+	}
+	plays := `{"intent":"kiosk_count","params":{"city":"moco"}}`
+
+	cases := []struct {
+		name    string
+		handler http.Handler
+		body    string
+		accept  string
+		status  int
+		code    string
+		// problem is false for successful responses, which stay untouched.
+		problem bool
+		check   func(t *testing.T, body map[string]any)
+	}{
+		{
+			name:    "panic",
+			handler: http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("boom") }),
+			accept:  problemContentType,
+			status:  http.StatusInternalServerError, code: "internal_error", problem: true,
+			check: func(t *testing.T, body map[string]any) {
+				if id, _ := body["instance"].(string); !strings.HasPrefix(id, "urn:uuid:") {
+					t.Errorf("instance = %v, want a urn:uuid", body["instance"])
+				}
+				if strings.Contains(body["detail"].(string), "boom") {
+					t.Errorf("detail leaks the panic value: %v", body["detail"])
+				}
+			},
+		},
+		{
+			name:    "validation",
+			handler: query("http://127.0.0.1:0"),
+			body:    `{"intent":"poster_plays","params":{}}`,
+			accept:  problemContentType,
+			status:  http.StatusBadRequest, code: "invalid_query", problem: true,
+			check: func(t *testing.T, body map[string]any) {
+				fields, _ := body["fields"].(map[string]any)
+				if fields["params.poster"] == nil {
+					t.Errorf("fields = %v, want params.poster", body["fields"])
+				}
+			},
+		},
+		{
+			name:    "upstream failure",
+			handler: query(gateway(http.StatusInternalServerError)),
+			body:    plays,
+			accept:  problemContentType,
+			status:  http.StatusBadGateway, code: "query_failed", problem: true,
+			check: func(t *testing.T, body map[string]any) {
+				if body["retryable"] != true {
+					t.Errorf("retryable = %v", body["retryable"])
+				}
+			},
+		},
+		{
+			name:    "upstream auth failure",
+			handler: query(gateway(http.StatusUnauthorized)),
+			body:    plays,
+			status:  http.StatusBadGateway, code: "gateway_auth_failed", problem: true,
+		},
+		{
+			name: "success untouched",
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, map[string]any{"answer": "42"})
+			}),
+			accept: problemContentType,
+			status: http.StatusOK,
+			check: func(t *testing.T, body map[string]any) {
+				if len(body) != 1 || body["answer"] != "42" {
+					t.Errorf("body = %v, want it untouched", body)
+				}
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, accept := range []string{tc.accept, "application/json"} {
+				req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(tc.body))
+				if accept != "" {
+					req.Header.Set("Accept", accept)
+				}
+				rec := httptest.NewRecorder()
+				WithProblemDetails()(tc.handler).ServeHTTP(rec, req)
+
+				if rec.Code != tc.status {
+					t.Fatalf("Accept %q: status = %d, want %d: %s", accept, rec.Code, tc.status, rec.Body)
+				}
+				wantType := "application/json"
+				if tc.problem && accept == problemContentType {
+					wantType = problemContentType
+				}
+				if got := rec.Header().Get("Content-Type"); got != wantType {
+					t.Errorf("Accept %q: Content-Type = %q, want %q", accept, got, wantType)
+				}
+				var body map[string]any
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+					t.Fatalf("body %q: %v", rec.Body, err)
+				}
+				if tc.problem {
+					if body["type"] != problemTypePrefix+tc.code || body["error"] != tc.code ||
+						body["title"] != http.StatusText(tc.status) || body["status"] != float64(tc.status) ||
+						body["detail"] == "" || body["detail"] == nil {
+						t.Errorf("Accept %q: body = %v, want problem fields for %s", accept, body, tc.code)
+					}
+				} else if body["type"] != nil || body["status"] != nil {
+					t.Errorf("Accept %q: success body has problem fields: %v", accept, body)
+				}
+				if tc.check != nil {
+					tc.check(t, body)
+				}
+			}
+		})
+	}
+}
+
+// A panic after the response started is logged but not answered twice.
+func TestProblemDetailsPanicAfterWrite(t *testing.T) {
+	h := WithProblemDetails()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("event: token\ndata: hi\n\n"))
+		panic("boom")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/chat/stream", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "event: token\ndata: hi\n\n" {
+		t.Errorf("got %d %q, want the stream as written", rec.Code, rec.Body)
+	}
+}
+
+func TestWantsProblem(t *testing.T) {
+	cases := map[string]bool{
+		"":                         false,
+		"application/json":         false,
+		"application/problem+json": true,
+		"application/json, application/problem+json;q=0.9": true,
+		"APPLICATION/PROBLEM+JSON":                         true,
+		"*/*":                                              false,
+	}
+	for accept, want := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", accept)
+		if got := wantsProblem(r); got != want {
+			t.Errorf("wantsProblem(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"openai-agent-service/internal/models"
+	"openai-agent-service/internal/services"
+)
+
+type QueryHandlers struct {
+	Chat *services.ChatService
+}
+
+// HandleQuery answers POST /query: the numbers behind a chat answer, asked
+// by intent and parameters, as JSON without prose.
+func (h *QueryHandlers) HandleQuery(w http.ResponseWriter, r *http.Request) {
+	var req models.QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_json"})
+		return
+	}
+
+	res, err := h.Chat.Query(r.Context(), req)
+	var qe *services.QueryError
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, res)
+	case errors.As(err, &qe):
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_query", "message": err.Error(), "fields": qe.Fields})
+	case errors.Is(err, services.ErrGatewayUnavailable):
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"error": "gateway_unavailable", "retryable": true, "message": err.Error()})
+	case errors.Is(err, services.ErrGatewayAuth):
+		writeJSON(w, http.StatusBadGateway, map[string]any{"error": "gateway_auth_failed", "retryable": false, "message": err.Error()})
+	case isTimeout(err):
+		writeJSON(w, http.StatusGatewayTimeout, map[string]any{"error": "timeout", "retryable": true, "message": err.Error()})
+	case errors.Is(err, services.ErrQueryFailed):
+		writeJSON(w, http.StatusBadGateway, map[string]any{"error": "query_failed", "retryable": true, "message": err.Error(), "steps": res.Steps})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "query_failed", "message": err.Error()})
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"openai-agent-service/internal/models"
+	"openai-agent-service/internal/services"
+	"openai-agent-service/internal/store"
+)
+
+type SavedQueryHandlers struct {
+	Store *store.PostgresStore
+	Chat  *services.ChatService
+}
+
+func (h *SavedQueryHandlers) ListSavedQueries(w http.ResponseWriter, r *http.Request) {
+	queries, err := h.Store.ListSavedQueries(r.Context(), CallerKey(r))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "list_saved_queries_failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": queries})
+}
+
+// RunSavedQuery answers a saved query like POST /chat. The body is optional;
+// its conversation_id, timezone and other options apply, its message is
+// ignored.
+func (h *SavedQueryHandlers) RunSavedQuery(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(chi.URLParam(r, "name"))
+	if name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "name_required"})
+		return
+	}
+	var req models.ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_json"})
+		return
+	}
+	resp, err := h.Chat.RunSavedQuery(r.Context(), CallerKey(r), name, req)
+	if errors.Is(err, services.ErrSavedQueryNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+		return
+	}
+	writeChatResult(w, resp, err)
+}
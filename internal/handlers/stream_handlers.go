@@ -1,10 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"openai-agent-service/internal/models"
 	"openai-agent-service/internal/services"
@@ -12,6 +17,9 @@ import (
 
 type StreamHandlers struct {
 	Chat *services.ChatService
+	// Heartbeat is the interval between SSE comment lines sent while a chat call
+	// is in flight, keeping idle-timeout proxies from closing the stream.
+	Heartbeat time.Duration
 }
 
 func sseWriteEvent(w io.Writer, event string, payload any) error {
@@ -52,13 +60,61 @@ func (h *StreamHandlers) HandleChatStream(w http.ResponseWriter, r *http.Request
 		flusher.Flush()
 		return
 	}
+	if !idempotencyKeyFromHeader(r, &req) {
+		_ = sseWriteEvent(w, "error", map[string]any{"error": "invalid_idempotency_key", "status": http.StatusBadRequest, "message": services.ErrInvalidIdempotencyKey.Error()})
+		flusher.Flush()
+		return
+	}
+
+	// Token callbacks and heartbeats write from different goroutines.
+	var writeMu sync.Mutex
+	interval := h.Heartbeat
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-r.Context().Done():
+				return
+			case <-t.C:
+				writeMu.Lock()
+				_, _ = io.WriteString(w, ": heartbeat\n\n")
+				flusher.Flush()
+				writeMu.Unlock()
+			}
+		}
+	}()
 
 	resp, err := h.Chat.ChatStream(r.Context(), CallerKey(r), req, func(tok string) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
 		_ = sseWriteEvent(w, "token", map[string]any{"text": tok})
 		flusher.Flush()
 	})
+	close(done)
+	<-stopped
+
+	if ctxErr := r.Context().Err(); ctxErr != nil {
+		// The client went away; there is nobody left to send the final event to.
+		if errors.Is(ctxErr, context.Canceled) {
+			log.Printf("chat stream cancelled: client disconnected")
+		} else {
+			log.Printf("chat stream cancelled: %v", ctxErr)
+		}
+		return
+	}
 	if err != nil {
-		_ = sseWriteEvent(w, "error", map[string]any{"error": "chat_failed", "message": err.Error()})
+		status, body := chatErrorBody(resp, err)
+		body["status"] = status
+		_ = sseWriteEvent(w, "error", body)
 		flusher.Flush()
 		return
 	}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"openai-agent-service/internal/services"
+)
+
+// heartbeatCounter counts the SSE heartbeats written through it.
+type heartbeatCounter struct {
+	http.ResponseWriter
+	n *atomic.Int32
+}
+
+func (w heartbeatCounter) Write(p []byte) (int, error) {
+	if strings.HasPrefix(string(p), ": heartbeat") {
+		w.n.Add(1)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w heartbeatCounter) Flush() { w.ResponseWriter.(http.Flusher).Flush() }
+
+// A client that disconnects mid-answer stops the heartbeats and cancels the
+// gateway call in flight, and no further gateway calls are made.
+func TestChatStreamStopsOnClientDisconnect(t *testing.T) {
+	var calls atomic.Int32
+	arrived := make(chan struct{}, 16)
+	gatewayCancelled := make(chan struct{}, 16)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		arrived <- struct{}{}
+		// Hold every call until the caller gives up on it.
+		select {
+		case <-r.Context().Done():
+			gatewayCancelled <- struct{}{}
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer gateway.Close()
+
+	var heartbeats atomic.Int32
+	var handlerDone sync.WaitGroup
+	h := &StreamHandlers{
+		Chat:      &services.ChatService{Gateway: &services.GatewayClient{BaseURL: gateway.URL}},
+		Heartbeat: 10 * time.Millisecond,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerDone.Add(1)
+		defer handlerDone.Done()
+		h.HandleChatStream(heartbeatCounter{w, &heartbeats}, r)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, strings.NewReader(`{"message":"play count for poster Bet 365 in october 2024"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	select {
+	case <-arrived:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the chat never called the gateway")
+	}
+	lines := bufio.NewScanner(resp.Body)
+	for seen := 0; seen < 2 && lines.Scan(); {
+		if strings.HasPrefix(lines.Text(), ": heartbeat") {
+			seen++
+		}
+	}
+	cancel()
+
+	select {
+	case <-gatewayCancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the gateway call in flight was not cancelled")
+	}
+	finished := make(chan struct{})
+	go func() { handlerDone.Wait(); close(finished) }()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler kept running after the client left")
+	}
+
+	callsAtExit, beatsAtExit := calls.Load(), heartbeats.Load()
+	time.Sleep(10 * h.Heartbeat)
+	if n := calls.Load(); n != callsAtExit {
+		t.Errorf("gateway called %d more times after the client left", n-callsAtExit)
+	}
+	if n := heartbeats.Load(); n != beatsAtExit {
+		t.Errorf("%d heartbeats written after the client left", n-beatsAtExit)
+	}
+	if beatsAtExit < 2 {
+		t.Errorf("%d heartbeats while the gateway was slow, want at least 2", beatsAtExit)
+	}
+}
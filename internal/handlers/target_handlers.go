@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"openai-agent-service/internal/models"
+	"openai-agent-service/internal/services"
+	"openai-agent-service/internal/store"
+)
+
+type TargetHandlers struct {
+	Store *store.PostgresStore
+}
+
+func (h *TargetHandlers) ListPlayTargets(w http.ResponseWriter, r *http.Request) {
+	targets, err := h.Store.ListPlayTargets(r.Context(), CallerKey(r), strings.TrimSpace(r.URL.Query().Get("period")))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "list_play_targets_failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": targets})
+}
+
+func (h *TargetHandlers) CreatePlayTarget(w http.ResponseWriter, r *http.Request) {
+	var target models.PlayTarget
+	if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_json"})
+		return
+	}
+	target.OwnerKey = CallerKey(r)
+	if err := services.ValidatePlayTarget(&target); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_play_target", "message": err.Error()})
+		return
+	}
+	created, err := h.Store.CreatePlayTarget(r.Context(), target)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "create_play_target_failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": created})
+}
+
+func (h *TargetHandlers) DeletePlayTarget(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(strings.TrimSpace(chi.URLParam(r, "id")), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "target_id_required"})
+		return
+	}
+	if err := h.Store.DeletePlayTarget(r.Context(), CallerKey(r), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]any{"error": "not_found"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "delete_play_target_failed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"deleted": id}})
+}
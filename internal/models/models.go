@@ -1,11 +1,44 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type ChatRequest struct {
 	Message        string `json:"message"`
 	ConversationID string `json:"conversation_id"`
 	Attachments    []ChatAttachment `json:"attachments,omitempty"`
+	// DryRun overrides the service default (MUTATIONS_DRY_RUN) for this request.
+	DryRun *bool `json:"dry_run,omitempty"`
+	// Timezone is an IANA zone (e.g. "America/Chicago") used for day/hour
+	// bucketing; UTC when empty or unknown.
+	Timezone string `json:"timezone,omitempty"`
+	// Debug captures a debug bundle for this request (see GET /debug/{id}).
+	Debug bool `json:"debug,omitempty"`
+	// Units overrides SIZE_UNITS for this request: "binary" (KiB, MiB, GiB)
+	// or "decimal" (KB, MB, GB).
+	Units string `json:"units,omitempty"`
+	// TemperatureUnit is "celsius" (default) or "fahrenheit".
+	TemperatureUnit string `json:"temperature_unit,omitempty"`
+	// Verbosity "brief" answers a re-asked list question with only what
+	// changed since it was last asked; "detailed" renders lists as tables.
+	Verbosity string `json:"verbosity,omitempty"`
+	// RenderTables renders ranked lists (top kiosks, top posters, low
+	// uptime, venue devices) as markdown tables instead of numbered lines.
+	RenderTables bool `json:"render_tables,omitempty"`
+	// IdempotencyKey makes retries safe: a repeated key returns the first
+	// answer instead of running the request again. The Idempotency-Key
+	// header sets it too.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// IncludeTimings adds the per-stage latency breakdown to meta.timings.
+	IncludeTimings bool `json:"include_timings,omitempty"`
+	// Language is the answer language ("en", "es"); when empty it is
+	// detected from non-English trigger words in the message.
+	Language string `json:"language,omitempty"`
+	// WebhookURL, on an async request (POST /chat?async=true), receives the
+	// finished ChatJob as a JSON POST.
+	WebhookURL string `json:"webhook_url,omitempty"`
 }
 
 type ChatAttachment struct {
@@ -15,19 +48,222 @@ type ChatAttachment struct {
 }
 
 type ChatResponse struct {
-	Answer string    `json:"answer"`
-	Data   *ChatData `json:"data,omitempty"`
-	Steps  []Step    `json:"steps,omitempty"`
+	Answer string         `json:"answer"`
+	Data   *ChatData      `json:"data,omitempty"`
+	Meta   *ResponseMeta  `json:"meta,omitempty"`
+	Steps  []Step         `json:"steps,omitempty"`
+	Error  *ResponseError `json:"error,omitempty"`
+	// Citations tie figures in Answer to the Steps that produced them.
+	Citations []Citation `json:"citations,omitempty"`
+}
+
+// Citation links one figure in the answer ("12,345 plays") to the steps whose
+// data fed it. Steps are indices into ChatResponse.Steps; Line is the 0-based
+// answer line the figure appears on.
+type Citation struct {
+	Figure string `json:"figure"`
+	Line   int    `json:"line"`
+	Steps  []int  `json:"steps"`
+}
+
+// API key roles. A read-only key can ask questions but cannot upload
+// creatives, create campaigns, send device commands, make mutating tool
+// calls or use the admin endpoints.
+const (
+	KeyRoleReadOnly = "readonly"
+	KeyRoleAdmin    = "admin"
+)
+
+// ResponseError is a machine-readable failure behind an answer. Retryable
+// means the same request is expected to work later without changes.
+type ResponseError struct {
+	Code      string `json:"code"`
+	Retryable bool   `json:"retryable"`
+}
+
+// ResponseMeta describes how complete the data behind an answer is.
+type ResponseMeta struct {
+	Truncated   bool  `json:"truncated"`
+	FetchedRows int64 `json:"fetched_rows"`
+	TotalRows   int64 `json:"total_rows,omitempty"`
+	// TimedOut is set when the handler deadline fired mid-aggregation and
+	// the answer was built from whatever had been fetched by then.
+	TimedOut bool `json:"timed_out,omitempty"`
+	// DebugID names the debug bundle captured for this answer, if any.
+	DebugID string `json:"debug_id,omitempty"`
+	// Replayed is set when the answer is the stored result of an earlier
+	// request with the same idempotency key.
+	Replayed bool `json:"replayed,omitempty"`
+	// Timings breaks the request's latency down by stage in milliseconds
+	// (hydrate, dispatch, gateway, openai, aggregation, store, first_token,
+	// total). Set only with include_timings or debug.
+	Timings map[string]int64 `json:"timings,omitempty"`
+	// PartialFailure is set when some of the gateway calls behind the answer
+	// failed and it was built from the ones that succeeded; FailedSteps
+	// indexes the failed calls in Steps.
+	PartialFailure bool  `json:"partial_failure,omitempty"`
+	FailedSteps    []int `json:"failed_steps,omitempty"`
+	// Scope lists the parameters the request was interpreted with and
+	// where each came from.
+	Scope []ScopeParam `json:"scope,omitempty"`
+	// RangeStrategy says how a date range longer than the raw POP window
+	// was answered: "stats" (from aggregates), "chunked" (read in
+	// RangeChunks month-sized sub-ranges) or "refused". A multi-intent
+	// answer lists each part's choice in RangeStrategies and has "mixed"
+	// when they differ; RangeChunks is then the parts' total.
+	RangeStrategy   string   `json:"range_strategy,omitempty"`
+	RangeChunks     int      `json:"range_chunks,omitempty"`
+	RangeStrategies []string `json:"range_strategies,omitempty"`
+	// Freshness maps each gateway source the answer read ("pop",
+	// "metrics", "devices", ...) to the newest data timestamp it returned
+	// (RFC 3339, UTC), or "unknown" when its responses carried none.
+	Freshness map[string]string `json:"freshness,omitempty"`
+}
+
+// ScopeParam is one parameter (city, region, poster or host) a request was
+// interpreted with.
+type ScopeParam struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	// Source is "message" when the question named it, "conversation" when
+	// it was remembered from an earlier turn, "host" when it was read
+	// from a host's prefix and "params" when a POST /query passed it.
+	Source string `json:"source"`
 }
 
 type ChatData struct {
-	CampaignImpressions *CampaignImpressions `json:"campaign_impressions,omitempty"`
+	CampaignImpressions *CampaignImpressions  `json:"campaign_impressions,omitempty"`
+	CampaignBudget      *CampaignBudget       `json:"campaign_budget,omitempty"`
+	Geo                 *GeoFeatureCollection `json:"geo,omitempty"`
+	TimeSeries          *TimeSeries           `json:"time_series,omitempty"`
+	VenueRanking        []VenueRank           `json:"venue_ranking,omitempty"`
+	// Suggestions are follow-up questions the service can answer next in
+	// this conversation.
+	Suggestions []string `json:"suggestions,omitempty"`
+	// CSV carries a small POP export inline; larger ones get an extract
+	// link in the answer instead.
+	CSV *CSVAttachment `json:"csv,omitempty"`
+	// ActivePosters is the distinct poster count of a "how many posters
+	// played" answer.
+	ActivePosters *ActivePosters `json:"active_posters,omitempty"`
+	// Schedule is the day-by-day schedule of a "what's scheduled to play"
+	// answer.
+	Schedule *DeviceSchedule `json:"schedule,omitempty"`
+}
+
+// DeviceSchedule is what a set of devices is scheduled to play in a future
+// window, from the campaigns and creatives assigned to them. Devices lists
+// the devices looked at and Unscheduled those with nothing in the window.
+type DeviceSchedule struct {
+	Scope       string        `json:"scope"`
+	Window      string        `json:"window"`
+	From        time.Time     `json:"from"`
+	To          time.Time     `json:"to"`
+	Devices     []string      `json:"devices"`
+	Days        []ScheduleDay `json:"days"`
+	Unscheduled []string      `json:"unscheduled,omitempty"`
+}
+
+// ScheduleDay is one calendar day (YYYY-MM-DD) of a DeviceSchedule.
+type ScheduleDay struct {
+	Date  string         `json:"date"`
+	Items []ScheduleItem `json:"items"`
+}
+
+// ScheduleItem is a creative expected to run on some of the devices on a
+// day. Slots are the campaign's time slots; empty means all day.
+type ScheduleItem struct {
+	Creative   string   `json:"creative"`
+	CampaignID string   `json:"campaign_id,omitempty"`
+	Campaign   string   `json:"campaign,omitempty"`
+	Slots      []string `json:"slots,omitempty"`
+	Hosts      []string `json:"hosts"`
+}
+
+// ActivePosters counts the distinct posters with POP rows in a scope and
+// window. Scope is "city 'kcmo'", "region 'brt'" or "the network"; Window is
+// the label the answer uses and From/To its bounds.
+type ActivePosters struct {
+	DistinctPosters int       `json:"distinct_posters"`
+	TotalPlays      int64     `json:"total_plays"`
+	Window          string    `json:"window"`
+	Scope           string    `json:"scope"`
+	From            time.Time `json:"from"`
+	To              time.Time `json:"to"`
+}
+
+// CSVAttachment is a CSV file returned inside a chat response.
+type CSVAttachment struct {
+	Filename string `json:"filename"`
+	Rows     int    `json:"rows"`
+	Content  string `json:"content"`
+}
+
+// VenueRank is one row of a venue leaderboard. Venues whose devices or POP
+// could not be fetched are listed with Unavailable set and no Rank.
+type VenueRank struct {
+	Rank           int     `json:"rank,omitempty"`
+	VenueID        int     `json:"venue_id"`
+	Name           string  `json:"name"`
+	Devices        int     `json:"devices"`
+	Plays          int64   `json:"plays"`
+	PlaysPerDevice float64 `json:"plays_per_device"`
+	Unavailable    bool    `json:"unavailable,omitempty"`
+}
+
+// TimeSeries is a labeled series of buckets (e.g. weekdays or hours) for charting.
+type TimeSeries struct {
+	Name     string            `json:"name"`
+	Bucket   string            `json:"bucket"`
+	Timezone string            `json:"timezone"`
+	Metric   string            `json:"metric"`
+	Points   []TimeSeriesPoint `json:"points"`
+}
+
+type TimeSeriesPoint struct {
+	Label string `json:"label"`
+	Value int64  `json:"value"`
 }
 
 type CampaignImpressions struct {
 	CampaignID  string            `json:"campaign_id"`
 	Impressions int64             `json:"impressions"`
 	Posters     []PosterImpression `json:"posters,omitempty"`
+	Pacing      *CampaignPacing    `json:"pacing,omitempty"`
+}
+
+// CampaignPacing compares delivered impressions with the booked goal,
+// assuming linear delivery across the flight. Status is "not_started",
+// "in_flight" or "ended".
+type CampaignPacing struct {
+	Goal           int64     `json:"goal"`
+	FlightStart    time.Time `json:"flight_start"`
+	FlightEnd      time.Time `json:"flight_end"`
+	Status         string    `json:"status"`
+	ExpectedToDate int64     `json:"expected_to_date"`
+	PacingPercent  float64   `json:"pacing_percent"`
+	ProjectedTotal int64     `json:"projected_total"`
+	DaysRemaining  int       `json:"days_remaining"`
+}
+
+// CampaignBudget is a campaign's budget and spend as the gateway reports
+// them. Figures the campaign record lacks are nil rather than zero; the
+// pacing fields are set only when the campaign has flight dates.
+type CampaignBudget struct {
+	CampaignID      string   `json:"campaign_id"`
+	Name            string   `json:"name,omitempty"`
+	Currency        string   `json:"currency,omitempty"`
+	Budget          *float64 `json:"budget,omitempty"`
+	Spent           *float64 `json:"spent,omitempty"`
+	Remaining       *float64 `json:"remaining,omitempty"`
+	ConsumedPercent *float64 `json:"consumed_percent,omitempty"`
+	// Pacing compares spend with linear spend of the budget over the
+	// flight: "ahead", "on_track", "behind", "not_started" or "ended".
+	Pacing        string     `json:"pacing,omitempty"`
+	ExpectedSpend *float64   `json:"expected_spend,omitempty"`
+	PacingPercent *float64   `json:"pacing_percent,omitempty"`
+	FlightStart   *time.Time `json:"flight_start,omitempty"`
+	FlightEnd     *time.Time `json:"flight_end,omitempty"`
 }
 
 type PosterImpression struct {
@@ -43,20 +279,67 @@ type Step struct {
 	Status     int    `json:"status"`
 	Error      string `json:"error,omitempty"`
 	Body       string `json:"body,omitempty"`
+	// BodyID names the full response body when Body was clipped and step
+	// spillover is enabled; GET /steps/{bodyId} returns it while retained.
+	BodyID string `json:"body_id,omitempty"`
+	DryRun bool   `json:"dry_run,omitempty"`
+	// Cached is set when the gateway answered 304 and Body is the copy
+	// kept from an earlier 200; Status still reads 200.
+	Cached bool `json:"cached,omitempty"`
+}
+
+// StepBody is the full response body behind a clipped Step.
+type StepBody struct {
+	ID        string    `json:"id"`
+	OwnerKey  string    `json:"-"`
+	Tool      string    `json:"tool"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 type Conversation struct {
 	ConversationID string    `json:"conversation_id"`
+	Title          string    `json:"title"`
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
 }
 
 type Message struct {
-	ID             int64     `json:"id"`
-	ConversationID string    `json:"conversation_id"`
-	Role           string    `json:"role"`
-	Content        string    `json:"content"`
-	CreatedAt      time.Time `json:"created_at"`
+	ID             int64  `json:"id"`
+	ConversationID string `json:"conversation_id"`
+	Role           string `json:"role"`
+	Content        string `json:"content"`
+	// Handler is the deterministic handler that wrote an assistant message,
+	// or "llm"; empty for user messages and messages stored before it was
+	// recorded.
+	Handler   string    `json:"handler,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ImportedMessage is one message of a transcript from another chat system.
+type ImportedMessage struct {
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ConversationImport is the body of POST /conversations/import; a bare array
+// of messages is accepted too.
+type ConversationImport struct {
+	Title    string            `json:"title,omitempty"`
+	Messages []ImportedMessage `json:"messages"`
+}
+
+// ConversationImportResult is the imported conversation with the context
+// read from its messages (city, region, host, poster_name, poster_id,
+// poster_city, poster_region, campaign_id, venue_id), which follow-up
+// questions reuse as if they had been asked here.
+type ConversationImportResult struct {
+	Conversation Conversation      `json:"conversation"`
+	Messages     int               `json:"messages"`
+	Inferred     map[string]string `json:"inferred"`
+	Summary      string            `json:"summary"`
 }
 
 type ConversationsResponse struct {
@@ -66,3 +349,497 @@ type ConversationsResponse struct {
 type MessagesResponse struct {
 	Data []Message `json:"data"`
 }
+
+type CacheInfo struct {
+	Name      string    `json:"name"`
+	Entries   int       `json:"entries"`
+	Keys      []string  `json:"keys,omitempty"`
+	CachedAt  time.Time `json:"cached_at,omitempty"`
+	AgeSecond int64     `json:"age_seconds"`
+	TTLSecond int64     `json:"ttl_seconds,omitempty"`
+}
+
+type CacheFlushRequest struct {
+	Caches []string `json:"caches,omitempty"`
+}
+
+// DebugFlagRequest turns debug capture on or off for a conversation.
+type DebugFlagRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// HandlerState is a deterministic handler's flag and how many requests it
+// answered on this instance since start.
+type HandlerState struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Hits    int64  `json:"hits"`
+}
+
+// HandlerFlagRequest turns a deterministic handler on or off.
+type HandlerFlagRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// GeoFeatureCollection is a GeoJSON FeatureCollection of kiosk points.
+type GeoFeatureCollection struct {
+	Features []GeoFeature
+}
+
+type GeoFeature struct {
+	// Coordinates are GeoJSON order: longitude, latitude.
+	Coordinates [2]float64
+	Properties  map[string]any
+}
+
+// AddPoint appends a kiosk point. Points at 0,0 (missing coordinates) are
+// skipped; the return value reports whether the point was added.
+func (fc *GeoFeatureCollection) AddPoint(lat, lon float64, props map[string]any) bool {
+	if lat == 0 && lon == 0 {
+		return false
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return false
+	}
+	fc.Features = append(fc.Features, GeoFeature{Coordinates: [2]float64{lon, lat}, Properties: props})
+	return true
+}
+
+func (fc GeoFeatureCollection) MarshalJSON() ([]byte, error) {
+	features := fc.Features
+	if features == nil {
+		features = []GeoFeature{}
+	}
+	return json.Marshal(struct {
+		Type     string       `json:"type"`
+		Features []GeoFeature `json:"features"`
+	}{Type: "FeatureCollection", Features: features})
+}
+
+func (f GeoFeature) MarshalJSON() ([]byte, error) {
+	props := f.Properties
+	if props == nil {
+		props = map[string]any{}
+	}
+	type geometry struct {
+		Type        string     `json:"type"`
+		Coordinates [2]float64 `json:"coordinates"`
+	}
+	return json.Marshal(struct {
+		Type       string         `json:"type"`
+		Geometry   geometry       `json:"geometry"`
+		Properties map[string]any `json:"properties"`
+	}{Type: "Feature", Geometry: geometry{Type: "Point", Coordinates: f.Coordinates}, Properties: props})
+}
+
+// PlayTarget is a contracted minimum of plays per kiosk for one month, for a
+// campaign's posters or a single poster. The kiosks are either a city/region
+// scope or an explicit host list (ScopeType "hosts").
+type PlayTarget struct {
+	ID          int64     `json:"id"`
+	OwnerKey    string    `json:"-"`
+	CampaignID  string    `json:"campaign_id,omitempty"`
+	PosterID    string    `json:"poster_id,omitempty"`
+	ScopeType   string    `json:"scope_type"`
+	ScopeValue  string    `json:"scope_value,omitempty"`
+	Hosts       []string  `json:"hosts,omitempty"`
+	TargetPlays int64     `json:"target_plays"`
+	Period      string    `json:"period"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// SavedQuery is a question stored under a name and re-run with "run <name>".
+// Handler and Slots record how it was interpreted when saved: the handler
+// that answered it and the poster, scope and device context it resolved.
+type SavedQuery struct {
+	Name      string            `json:"name"`
+	OwnerKey  string            `json:"-"`
+	Message   string            `json:"message"`
+	Handler   string            `json:"handler,omitempty"`
+	Slots     map[string]string `json:"slots,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// AnswerSnapshot is the structured result of a list-style answer, kept so
+// the next time the owner asks the same (normalized) question the answer can
+// say what changed.
+type AnswerSnapshot struct {
+	OwnerKey  string      `json:"-"`
+	Question  string      `json:"question"`
+	Kind      string      `json:"kind"`
+	Rows      []AnswerRow `json:"rows"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// CampaignDeviceSnapshot is the device assignment of a campaign as last
+// seen, kept so the next "which devices were added or removed" question can
+// report the difference.
+type CampaignDeviceSnapshot struct {
+	CampaignID string    `json:"campaign_id"`
+	Hosts      []string  `json:"hosts"`
+	TakenAt    time.Time `json:"taken_at"`
+}
+
+// IdempotencyRecord is a chat request's idempotency key. Response is nil
+// while the request holding Token is still running.
+type IdempotencyRecord struct {
+	OwnerKey    string
+	Key         string
+	RequestHash string
+	Token       string
+	Response    *ChatResponse
+	ExpiresAt   time.Time
+}
+
+// AnswerRow is one listed item: a device, poster or host and its metric.
+type AnswerRow struct {
+	Key   string  `json:"key"`
+	Label string  `json:"label,omitempty"`
+	Value float64 `json:"value"`
+}
+
+// OutboxEntry is a side effect waiting for the outbox dispatcher: a webhook
+// delivery, a device command audit row or a usage event. Attempts counts the
+// deliveries started so far.
+type OutboxEntry struct {
+	ID            int64           `json:"id"`
+	Kind          string          `json:"kind"`
+	Payload       json.RawMessage `json:"payload"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	LastError     string          `json:"last_error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+// DeadLetter is an outbox entry that used up its attempts. CreatedAt is when
+// the side effect was first queued.
+type DeadLetter struct {
+	ID        int64           `json:"id"`
+	Kind      string          `json:"kind"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error"`
+	CreatedAt time.Time       `json:"created_at"`
+	FailedAt  time.Time       `json:"failed_at"`
+}
+
+// Chat job statuses.
+const (
+	ChatJobQueued  = "queued"
+	ChatJobRunning = "running"
+	ChatJobDone    = "done"
+	ChatJobFailed  = "failed"
+)
+
+// ChatJob is a chat request accepted by POST /chat?async=true. Response is
+// set once the job is done, and on a failure that still produced an answer;
+// Error is a failed job's error code, the same one POST /chat would return.
+type ChatJob struct {
+	ID             string        `json:"id"`
+	Status         string        `json:"status"`
+	ConversationID string        `json:"conversation_id,omitempty"`
+	Response       *ChatResponse `json:"response,omitempty"`
+	Error          string        `json:"error,omitempty"`
+	Message        string        `json:"message,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+	StartedAt      *time.Time    `json:"started_at,omitempty"`
+	FinishedAt     *time.Time    `json:"finished_at,omitempty"`
+	OwnerKey       string        `json:"-"`
+	// Role is the caller's key role, which the job runs with.
+	Role    string      `json:"-"`
+	Request ChatRequest `json:"-"`
+	// Attempts counts the runs started; more than one means a worker died
+	// mid-run and the job was claimed again.
+	Attempts int `json:"-"`
+}
+
+// Extract is a POP export too large to attach to a chat answer. Nothing is
+// stored but the query: GET /extracts/{id}.csv re-reads /pop with Filter and
+// streams the rows until ExpiresAt.
+type Extract struct {
+	ID          string    `json:"id"`
+	OwnerKey    string    `json:"-"`
+	Subject     string    `json:"subject"`
+	Filter      string    `json:"-"`
+	RowEstimate int64     `json:"row_estimate"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// EntityAlias is an API key's nickname for a device or poster ("stadium
+// screen" for moco-kc-stadium-003). Alias is stored normalized (lower case,
+// single spaces); Target is a host for devices and a poster id or name for
+// posters.
+type EntityAlias struct {
+	OwnerKey  string    `json:"-"`
+	Alias     string    `json:"alias"`
+	Kind      string    `json:"kind"`
+	Target    string    `json:"target"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Telemetry threshold metrics.
+const (
+	ThresholdTemperature  = "temperature"
+	ThresholdDisk         = "disk"
+	ThresholdMemory       = "memory"
+	ThresholdBattery      = "battery"
+	ThresholdInputMissing = "input_devices_missing"
+)
+
+// TelemetryThreshold is the warning and critical tier of one telemetry
+// metric; a tier of 0 is off. Temperatures are in °C, disk, memory and
+// battery in percent, and input devices as a count. OwnerKey is empty for
+// the service-wide defaults and set for an API key's override.
+type TelemetryThreshold struct {
+	OwnerKey  string    `json:"-"`
+	Metric    string    `json:"metric"`
+	Warning   float64   `json:"warning"`
+	Critical  float64   `json:"critical"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Daypart is a named time-of-day window POP answers can be filtered to
+// ("evening rush"), in minutes after local midnight. End is exclusive and
+// may be 1440; an End not after Start wraps past midnight.
+type Daypart struct {
+	Name  string `json:"name"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// DeviceNote is an operator's note on a host ("replaced modem 10/12").
+// Notes are shared by every API key; Author is the OwnerHash of the key that
+// wrote it.
+type DeviceNote struct {
+	ID        int64     `json:"id"`
+	Host      string    `json:"host"`
+	Author    string    `json:"author"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DeviceCommandAudit records one confirmed device command: executed, or
+// described only when DryRun is set. Outcome is "executed", "rejected" (the
+// gateway answered non-2xx), "failed" (no answer) or "dry_run".
+type DeviceCommandAudit struct {
+	ID             int64     `json:"id"`
+	OwnerKey       string    `json:"-"`
+	Role           string    `json:"role"`
+	ConversationID string    `json:"conversation_id"`
+	Host           string    `json:"host"`
+	Action         string    `json:"action"`
+	Path           string    `json:"path"`
+	DryRun         bool      `json:"dry_run"`
+	Status         int       `json:"status"`
+	Outcome        string    `json:"outcome"`
+	Error          string    `json:"error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// UsageEvent is one answered chat request, recorded for usage analytics.
+// Handler is the deterministic handler that answered, "llm" for the model
+// tool loop or "clarification" for a please-specify prompt outside a handler.
+type UsageEvent struct {
+	OwnerHash       string
+	Handler         string
+	DurationMs      int64
+	IsError         bool
+	IsClarification bool
+	// Message is kept, clipped to 200 characters, only for LLM fall-throughs.
+	Message   string
+	CreatedAt time.Time
+}
+
+// HandlerUsage aggregates the usage events of one handler.
+type HandlerUsage struct {
+	Handler        string  `json:"handler"`
+	Requests       int64   `json:"requests"`
+	Clarifications int64   `json:"clarifications"`
+	Errors         int64   `json:"errors"`
+	P50Ms          float64 `json:"p50_ms"`
+	P95Ms          float64 `json:"p95_ms"`
+}
+
+// UnansweredQuestion is a message no deterministic handler answered.
+type UnansweredQuestion struct {
+	Message   string    `json:"message"`
+	OwnerHash string    `json:"owner_hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UsageSummary is the response of GET /analytics/usage.
+type UsageSummary struct {
+	From              time.Time            `json:"from"`
+	To                time.Time            `json:"to"`
+	Requests          int64                `json:"requests"`
+	ClarificationRate float64              `json:"clarification_rate"`
+	ErrorRate         float64              `json:"error_rate"`
+	Handlers          []HandlerUsage       `json:"handlers"`
+	RecentLLM         []UnansweredQuestion `json:"recent_llm"`
+}
+
+// Feedback ratings.
+const (
+	FeedbackUp   = "up"
+	FeedbackDown = "down"
+)
+
+// Feedback is a rating of one assistant message. Handler is copied from the
+// message when the feedback is stored.
+type Feedback struct {
+	OwnerKey       string    `json:"-"`
+	ConversationID string    `json:"conversation_id"`
+	MessageID      int64     `json:"message_id"`
+	Handler        string    `json:"handler"`
+	Rating         string    `json:"rating"`
+	Comment        string    `json:"comment,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// FeedbackRequest is the body of POST
+// /conversations/{id}/messages/{messageId}/feedback.
+type FeedbackRequest struct {
+	Rating  string `json:"rating"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// HandlerFeedback aggregates the answers of one handler and their ratings.
+// DownRate is down-votes per answer.
+type HandlerFeedback struct {
+	Handler  string  `json:"handler"`
+	Answers  int64   `json:"answers"`
+	Up       int64   `json:"up"`
+	Down     int64   `json:"down"`
+	DownRate float64 `json:"down_rate"`
+}
+
+// FeedbackComment is a down-vote with the answer it was given on.
+type FeedbackComment struct {
+	MessageID int64     `json:"message_id"`
+	Handler   string    `json:"handler"`
+	Answer    string    `json:"answer"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FeedbackSummary is the response of GET /analytics/feedback.
+type FeedbackSummary struct {
+	From       time.Time         `json:"from"`
+	To         time.Time         `json:"to"`
+	Answers    int64             `json:"answers"`
+	Up         int64             `json:"up"`
+	Down       int64             `json:"down"`
+	DownRate   float64           `json:"down_rate"`
+	Handlers   []HandlerFeedback `json:"handlers"`
+	RecentDown []FeedbackComment `json:"recent_down"`
+}
+
+type AlertRule struct {
+	ID              int64      `json:"id"`
+	OwnerKey        string     `json:"-"`
+	ScopeType       string     `json:"scope_type"`
+	ScopeValue      string     `json:"scope_value,omitempty"`
+	Condition       string     `json:"condition"`
+	Threshold       float64    `json:"threshold"`
+	CooldownSeconds int64      `json:"cooldown_seconds"`
+	WebhookURL      string     `json:"webhook_url,omitempty"`
+	ConversationID  string     `json:"conversation_id,omitempty"`
+	LastFiredAt     *time.Time `json:"last_fired_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// DebugBundle is everything the service saw while answering one request:
+// the full gateway calls, the OpenAI messages when the tool loop ran, and
+// per-stage timings. Headers and API keys are never captured.
+type DebugBundle struct {
+	ID             string          `json:"id"`
+	OwnerKey       string          `json:"-"`
+	ConversationID string          `json:"conversation_id,omitempty"`
+	Message        string          `json:"message"`
+	Handler        string          `json:"handler,omitempty"`
+	Answer         string          `json:"answer"`
+	Error          string          `json:"error,omitempty"`
+	Calls          []DebugCall     `json:"calls"`
+	OpenAIMessages json.RawMessage `json:"openai_messages,omitempty"`
+	Stages         []DebugStage    `json:"stages"`
+	// Truncated is set when payloads exceeded the capture budget and later
+	// bodies were clipped or dropped.
+	Truncated bool      `json:"truncated,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// DebugCall is one gateway request with its un-clipped response body.
+type DebugCall struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	RequestBody any    `json:"request_body,omitempty"`
+	Status      int    `json:"status"`
+	Error       string `json:"error,omitempty"`
+	Body        string `json:"body,omitempty"`
+	Truncated   bool   `json:"truncated,omitempty"`
+	DurationMs  int64  `json:"duration_ms"`
+}
+
+// DebugStage is the wall time spent in one stage of answering a request.
+type DebugStage struct {
+	Name       string `json:"name"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// QueryRequest is a POST /query body: a numeric question asked by intent and
+// parameters instead of in words.
+type QueryRequest struct {
+	// Intent is poster_plays, kiosk_count, top_posters, device_status or
+	// campaign_impressions.
+	Intent string      `json:"intent"`
+	Params QueryParams `json:"params"`
+}
+
+// QueryParams are the parameters of every query intent; each intent reads
+// the ones it needs.
+type QueryParams struct {
+	// Poster is a poster name or id (poster_plays).
+	Poster     string `json:"poster,omitempty"`
+	City       string `json:"city,omitempty"`
+	Region     string `json:"region,omitempty"`
+	CampaignID string `json:"campaign_id,omitempty"`
+	// From and To bound the window as RFC 3339 times or YYYY-MM-DD dates,
+	// To's date included (poster_plays, top_posters).
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Metric ranks top_posters by plays (the default) or clicks.
+	Metric string `json:"metric,omitempty"`
+	// Limit caps the breakdown rows.
+	Limit int `json:"limit,omitempty"`
+}
+
+// QueryResult is the numbers a POST /query computed, with no prose.
+type QueryResult struct {
+	Intent string             `json:"intent"`
+	Totals map[string]float64 `json:"totals"`
+	// Rows break the totals down (kiosks, posters), largest first.
+	Rows   []QueryRow   `json:"rows,omitempty"`
+	Window *QueryWindow `json:"window,omitempty"`
+	Scope  []ScopeParam `json:"scope,omitempty"`
+	// Truncated is set when Rows were cut at the limit or the gateway
+	// reads stopped at POP_MAX_PAGES; Meta has the details.
+	Truncated bool          `json:"truncated"`
+	Meta      *ResponseMeta `json:"meta,omitempty"`
+	Steps     []Step        `json:"steps,omitempty"`
+}
+
+// QueryRow is one breakdown row of a QueryResult.
+type QueryRow struct {
+	Key    string             `json:"key"`
+	Label  string             `json:"label,omitempty"`
+	Values map[string]float64 `json:"values"`
+}
+
+// QueryWindow is the time window a QueryResult covers, end exclusive.
+type QueryWindow struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
@@ -9,10 +9,12 @@ import (
 	"openai-agent-service/internal/handlers"
 )
 
-func NewRouter(cfg config.Config, chat *handlers.ChatHandlers, stream *handlers.StreamHandlers, conv *handlers.ConversationHandlers) http.Handler {
+func NewRouter(cfg config.Config, chat *handlers.ChatHandlers, stream *handlers.StreamHandlers, conv *handlers.ConversationHandlers, admin *handlers.AdminHandlers, alerts *handlers.AlertHandlers, health *handlers.HealthHandlers, targets *handlers.TargetHandlers, docs *handlers.DocsHandlers, queries *handlers.SavedQueryHandlers, numbers *handlers.QueryHandlers) http.Handler {
 	r := chi.NewRouter()
 
 	r.Use(handlers.WithRequestLogging())
+	r.Use(handlers.WithCompression(cfg))
+	r.Use(handlers.WithProblemDetails())
 	r.Use(handlers.WithCORS(cfg))
 
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -20,15 +22,58 @@ func NewRouter(cfg config.Config, chat *handlers.ChatHandlers, stream *handlers.
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte(`{"status":"ok"}`))
 	})
+	r.Get("/readyz", health.Readyz)
+	r.Get("/metrics", health.Metrics)
+	r.Get("/openapi.json", docs.OpenAPI)
+	if cfg.DocsEnabled {
+		r.Get("/docs", docs.Docs)
+	}
 
 	auth := handlers.WithAPIKey(cfg)
+	adminOnly := handlers.WithAdminRole()
+	// Request bodies are inflated only for authenticated callers, each route
+	// up to what its own requests need.
+	body := handlers.WithDecompression(handlers.MaxJSONBody)
+	chatBody := handlers.WithDecompression(handlers.ChatBodyLimit(cfg))
+	importBody := handlers.WithDecompression(handlers.MaxImportBody)
 
-	r.With(auth).Post("/conversations", conv.CreateConversation)
+	r.With(auth).Get("/conversations", conv.ListConversations)
+	r.With(auth, body).Post("/conversations", conv.CreateConversation)
+	r.With(auth, importBody).Post("/conversations/import", conv.ImportConversation)
 	r.With(auth).Get("/conversations/{id}", conv.GetConversation)
+	r.With(auth, body).Patch("/conversations/{id}", conv.RenameConversation)
 	r.With(auth).Get("/conversations/{id}/messages", conv.ListMessages)
+	r.With(auth, body).Post("/conversations/{id}/messages/{messageId}/feedback", conv.PostFeedback)
 
-	r.With(auth).Post("/chat", chat.HandleChat)
-	r.With(auth).Post("/chat/stream", stream.HandleChatStream)
+	r.With(auth, chatBody).Post("/chat", chat.HandleChat)
+	r.With(auth).Get("/jobs/{id}", chat.GetJob)
+	r.With(auth).Get("/extracts/{id}.csv", chat.GetExtract)
+	r.With(auth, chatBody).Post("/chat/stream", stream.HandleChatStream)
+	r.With(auth, body).Post("/query", numbers.HandleQuery)
+
+	r.With(auth, adminOnly).Get("/admin/caches", admin.GetCaches)
+	r.With(auth, adminOnly, body).Post("/admin/caches/flush", admin.FlushCaches)
+	r.With(auth, adminOnly, body).Post("/admin/reload-credentials", admin.ReloadCredentials)
+	r.With(auth, adminOnly, body).Put("/admin/debug/conversations/{id}", admin.SetConversationDebug)
+	r.With(auth, adminOnly).Get("/debug/{id}", admin.GetDebugBundle)
+	r.With(auth).Get("/steps/{bodyId}", admin.GetStepBody)
+	r.With(auth, adminOnly).Get("/analytics/usage", admin.GetUsage)
+	r.With(auth, adminOnly).Get("/analytics/feedback", admin.GetFeedback)
+	r.With(auth, adminOnly).Get("/admin/handlers", admin.ListHandlers)
+	r.With(auth, adminOnly, body).Patch("/admin/handlers/{name}", admin.SetHandlerFlag)
+	r.With(auth, adminOnly).Get("/admin/dead-letters", admin.ListDeadLetters)
+	r.With(auth, adminOnly, body).Post("/admin/dead-letters/{id}/retry", admin.RetryDeadLetter)
+
+	r.With(auth).Get("/alerts", alerts.ListAlertRules)
+	r.With(auth, body).Post("/alerts", alerts.CreateAlertRule)
+	r.With(auth).Delete("/alerts/{id}", alerts.DeleteAlertRule)
+
+	r.With(auth).Get("/targets", targets.ListPlayTargets)
+	r.With(auth, body).Post("/targets", targets.CreatePlayTarget)
+	r.With(auth).Delete("/targets/{id}", targets.DeletePlayTarget)
+
+	r.With(auth).Get("/saved-queries", queries.ListSavedQueries)
+	r.With(auth, body).Post("/saved-queries/{name}/run", queries.RunSavedQuery)
 
 	return r
 }
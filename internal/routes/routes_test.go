@@ -0,0 +1,149 @@
+package routes
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"openai-agent-service/internal/config"
+	"openai-agent-service/internal/handlers"
+	"openai-agent-service/internal/models"
+)
+
+const (
+	adminKey    = "admin-key"
+	readOnlyKey = "vendor-key"
+)
+
+// usageStore answers every summary, counting the calls.
+type usageStore struct{ calls int }
+
+func (s *usageStore) RecordUsage(context.Context, models.UsageEvent) error { return nil }
+
+func (s *usageStore) UsageSummary(_ context.Context, from, to time.Time, _ int) (models.UsageSummary, error) {
+	s.calls++
+	return models.UsageSummary{From: from, To: to}, nil
+}
+
+func (s *usageStore) DeleteUsageBefore(context.Context, time.Time) (int64, error) { return 0, nil }
+
+// feedbackStore answers every summary, counting the calls.
+type feedbackStore struct{ calls int }
+
+func (s *feedbackStore) RecordFeedback(context.Context, models.Feedback) error { return nil }
+
+func (s *feedbackStore) FeedbackSummary(_ context.Context, from, to time.Time, _ int) (models.FeedbackSummary, error) {
+	s.calls++
+	return models.FeedbackSummary{From: from, To: to}, nil
+}
+
+// newTestRouter mounts admin on the real router; the other handler groups
+// are empty, so only routes that reach admin may be exercised.
+func newTestRouter(admin *handlers.AdminHandlers) http.Handler {
+	cfg := config.Config{AgentAPIKeys: map[string]string{
+		adminKey:    models.KeyRoleAdmin,
+		readOnlyKey: models.KeyRoleReadOnly,
+	}}
+	return NewRouter(cfg, &handlers.ChatHandlers{}, &handlers.StreamHandlers{}, &handlers.ConversationHandlers{}, admin,
+		&handlers.AlertHandlers{}, &handlers.HealthHandlers{}, &handlers.TargetHandlers{}, &handlers.DocsHandlers{},
+		&handlers.SavedQueryHandlers{}, &handlers.QueryHandlers{})
+}
+
+// Analytics summaries span every owner, so read-only keys are refused before
+// the store is queried.
+func TestAnalyticsRoutesAreAdminOnly(t *testing.T) {
+	cases := []struct {
+		name   string
+		key    string
+		status int
+	}{
+		{name: "no key", key: "", status: http.StatusUnauthorized},
+		{name: "read-only key", key: readOnlyKey, status: http.StatusForbidden},
+		{name: "admin key", key: adminKey, status: http.StatusOK},
+	}
+	for _, path := range []string{"/analytics/usage", "/analytics/feedback"} {
+		for _, tc := range cases {
+			t.Run(path+"/"+tc.name, func(t *testing.T) {
+				usage, feedback := &usageStore{}, &feedbackStore{}
+				h := newTestRouter(&handlers.AdminHandlers{Usage: usage, Feedback: feedback})
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				if tc.key != "" {
+					req.Header.Set("X-API-Key", tc.key)
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				if rec.Code != tc.status {
+					t.Fatalf("status %d, want %d: %s", rec.Code, tc.status, rec.Body)
+				}
+				queried := usage.calls + feedback.calls
+				if want := tc.status == http.StatusOK; (queried > 0) != want {
+					t.Errorf("summary queried %d times, want queried=%v", queried, want)
+				}
+			})
+		}
+	}
+}
+
+// countingBody counts the bytes read from a request body.
+type countingBody struct {
+	r    io.Reader
+	read int
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.read += n
+	return n, err
+}
+
+func (b *countingBody) Close() error { return nil }
+
+// A compressed body from a caller without a valid key is refused before any
+// of it is inflated.
+func TestCompressedBodyNeedsAuth(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write(make([]byte, 4<<20))
+	_ = zw.Close()
+
+	for _, key := range []string{"", "unknown-key"} {
+		body := &countingBody{r: bytes.NewReader(buf.Bytes())}
+		req := httptest.NewRequest(http.MethodPost, "/chat", nil)
+		req.Body = body
+		req.Header.Set("Content-Encoding", "gzip")
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		newTestRouter(&handlers.AdminHandlers{}).ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized && rec.Code != http.StatusForbidden {
+			t.Errorf("key %q: status %d, want 401 or 403", key, rec.Code)
+		}
+		if body.read != 0 {
+			t.Errorf("key %q: %d body bytes read before auth", key, body.read)
+		}
+	}
+}
+
+// Routes that take a small JSON document refuse a body that inflates past
+// MaxJSONBody, before the handler runs.
+func TestCompressedBodyLimitPerRoute(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write(make([]byte, handlers.MaxJSONBody+1))
+	_ = zw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/alerts", bytes.NewReader(buf.Bytes()))
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("X-API-Key", adminKey)
+	rec := httptest.NewRecorder()
+	newTestRouter(&handlers.AdminHandlers{}).ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want 413: %s", rec.Code, rec.Body)
+	}
+}
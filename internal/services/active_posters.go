@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+var (
+	// activePostersRe matches a distinct poster count: "how many posters",
+	// "number of distinct creatives", "count of active ads".
+	activePostersRe = regexp.MustCompile(`\b(?:how\s+many|number\s+of|count\s+of)\s+(?:(?:distinct|different|unique|active|live)\s+)*(?:posters?|creatives?|ads?)\b`)
+	// activePostersPopRe is what makes it a question about plays rather
+	// than about creative records ("how many approved creatives").
+	activePostersPopRe = regexp.MustCompile(`\b(?:played|playing|plays?|ran|running|live|on\s+(?:the\s+)?(?:network|screens?|air)|right\s+now|currently|now|today|yesterday|this\s+week|last\s+week|(?:last|past)\s+\d+\s+days?)\b`)
+	// activePostersNowRe asks about the present, which POP answers with
+	// today's plays.
+	activePostersNowRe = regexp.MustCompile(`\b(?:right\s+now|currently|now|at\s+the\s+moment)\b`)
+	// activePostersNetworkRe counts across every city.
+	activePostersNetworkRe = regexp.MustCompile(`\b(?:network|overall|everywhere|all\s+(?:cities|regions|kiosks|screens|devices))\b`)
+)
+
+// activePostersTop is how many posters the answer names as context.
+const activePostersTop = 3
+
+// isActivePostersIntent reports whether msgLower asks how many distinct
+// posters played. Campaign, comparison and per-poster questions are left to
+// their handlers.
+func isActivePostersIntent(msgLower string) bool {
+	if !activePostersRe.MatchString(msgLower) || !activePostersPopRe.MatchString(msgLower) {
+		return false
+	}
+	for _, k := range []string{"campaign", " vs ", "versus", "compare"} {
+		if strings.Contains(msgLower, k) {
+			return false
+		}
+	}
+	return true
+}
+
+// posterCount is one distinct poster's plays in the window.
+type posterCount struct {
+	Key   string
+	Name  string
+	Plays int64
+}
+
+// countDistinctPosters groups rows by poster_id. A row without an id is
+// grouped by its normalized poster_name, with the id'd poster of that name
+// when there is one, so the same poster is not counted twice. Rows without
+// either are skipped. The result is highest plays first.
+func countDistinctPosters(rows []popItem) []posterCount {
+	byName := map[string]string{}
+	for _, r := range rows {
+		if id := strings.TrimSpace(r.PosterID); id != "" {
+			if name := normalizeLooseText(r.PosterName); name != "" {
+				if _, ok := byName[name]; !ok {
+					byName[name] = id
+				}
+			}
+		}
+	}
+	counts := map[string]*posterCount{}
+	for _, r := range rows {
+		key := strings.TrimSpace(r.PosterID)
+		if key == "" {
+			name := normalizeLooseText(r.PosterName)
+			if name == "" {
+				continue
+			}
+			key = "name:" + name
+			if id, ok := byName[name]; ok {
+				key = id
+			}
+		}
+		pc := counts[key]
+		if pc == nil {
+			pc = &posterCount{Key: key}
+			counts[key] = pc
+		}
+		if pc.Name == "" {
+			pc.Name = strings.TrimSpace(r.PosterName)
+		}
+		pc.Plays += r.PlayCount
+	}
+	out := make([]posterCount, 0, len(counts))
+	for _, pc := range counts {
+		if pc.Name == "" {
+			pc.Name = pc.Key
+		}
+		out = append(out, *pc)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Plays != out[j].Plays {
+			return out[i].Plays > out[j].Plays
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// handleActivePosters answers "how many distinct posters played in kcmo
+// today" and "how many active creatives are on the network right now" from
+// the window's POP rows for the scope.
+func (c *ChatService) handleActivePosters(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !isActivePostersIntent(msgLower) || len(detectHostTokens(req.Message)) > 0 {
+		return models.ChatResponse{}, false, nil
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	network := activePostersNetworkRe.MatchString(msgLower)
+	city, region := "", ""
+	if !network {
+		city = c.detectCityCode(ctx, msgLower)
+		region = c.detectRegionCode(ctx, msgLower)
+		city, _ = normalizeCitySelection(city, region, msgLower)
+		if city == "" && region == "" {
+			if st := c.getConversationState(conversationID); st != nil {
+				city = strings.ToLower(strings.TrimSpace(st.City))
+				region = strings.ToLower(strings.TrimSpace(st.Region))
+			}
+		}
+		if city == "" && region == "" {
+			return reply(models.ChatResponse{Answer: "Which city or region should I count posters in? Or say 'on the network' to count across every city."})
+		}
+		if conversationID != "" {
+			c.updateConversationLocation(conversationID, city, region)
+			c.clearPending(conversationID)
+		}
+	}
+	if c.Gateway == nil {
+		return reply(models.ChatResponse{Answer: "Tool gateway is not configured."})
+	}
+
+	scope, where := "the network", "across the network"
+	filter := ""
+	switch {
+	case region != "" && city == "":
+		scope = fmt.Sprintf("region '%s'", region)
+		where = "in " + scope
+		filter = "region=" + urlEscape(region) + "&"
+	case city != "":
+		scope = fmt.Sprintf("city '%s'", city)
+		where = "in " + scope
+		filter = "city=" + urlEscape(city) + "&"
+		if region != "" {
+			filter += "region=" + urlEscape(region) + "&"
+		}
+	}
+
+	// POP records plays, not what is scheduled: "right now" is what has
+	// played since midnight, and so is a question without a window.
+	loc := requestLocation(req)
+	now := time.Now()
+	from, to, window, ok := exportWindow(req.Message, now, loc)
+	windowNote := ""
+	switch {
+	case activePostersNowRe.MatchString(msgLower) && !strings.Contains(msgLower, "yesterday"):
+		local := now.In(loc)
+		from, to, window = time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc), now, "today"
+		windowNote = fmt.Sprintf("\"Right now\" is counted as the posters played today so far (since midnight %s).", loc.String())
+	case !ok:
+		local := now.In(loc)
+		from, to, window = time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc), now, "today"
+		windowNote = "No window was given, so this counts today so far."
+	}
+	filter += "from=" + urlEscape(from.UTC().Format(time.RFC3339)) + "&to=" + urlEscape(to.UTC().Format(time.RFC3339))
+
+	rows, steps, pager, err := c.fetchPopRows(ctx, filter)
+	var rangeErr *popRangeError
+	if errors.As(err, &rangeErr) {
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("Can't count posters %s: %s", where, rangeErr.Error()), Steps: steps, Meta: pager.meta()})
+	}
+	if err != nil {
+		return reply(models.ChatResponse{Answer: "Failed to fetch POP data: " + err.Error(), Steps: steps})
+	}
+	rows = popRange{From: from, To: to}.keep(rows)
+
+	posters := countDistinctPosters(rows)
+	totalPlays := int64(0)
+	for _, p := range posters {
+		totalPlays += p.Plays
+	}
+	data := &models.ChatData{ActivePosters: &models.ActivePosters{
+		DistinctPosters: len(posters),
+		TotalPlays:      totalPlays,
+		Window:          window,
+		Scope:           scope,
+		From:            from,
+		To:              to,
+	}}
+
+	lines := make([]string, 0, 8)
+	if len(posters) == 0 {
+		lines = append(lines, fmt.Sprintf("No posters played %s %s.", where, windowPhrase(window)))
+	} else {
+		// A listing cut short by the page cap may have missed posters.
+		atLeast := ""
+		if pager.Truncated {
+			atLeast = "at least "
+		}
+		noun := "posters"
+		if len(posters) == 1 {
+			noun = "poster"
+		}
+		lines = append(lines, fmt.Sprintf("%s%s distinct %s played %s %s, with %s plays in total.", capitalize(atLeast), formatThousands(int64(len(posters))), noun, where, windowPhrase(window), formatThousands(totalPlays)))
+		if len(posters) > 1 {
+			top := posters
+			if len(top) > activePostersTop {
+				top = top[:activePostersTop]
+			}
+			lines = append(lines, "Top by plays:")
+			for i, p := range top {
+				lines = append(lines, fmt.Sprintf("%d. %s — %s plays", i+1, p.Name, formatThousands(p.Plays)))
+			}
+		}
+	}
+	if windowNote != "" {
+		lines = append(lines, windowNote)
+	}
+	answer := pager.note(strings.Join(lines, "\n"))
+	return reply(models.ChatResponse{Answer: answer, Steps: steps, Meta: pager.meta(), Data: data})
+}
+
+// windowPhrase makes an exportWindow or futureWindow label read after a
+// verb: "today", but "over the last 7 days", "over March 2026" and, for a
+// single day, "on Sat Oct 17".
+func windowPhrase(label string) string {
+	switch label {
+	case "today", "yesterday", "tomorrow", "this week", "last week", "next week", "this weekend", "next weekend", "next month":
+		return label
+	}
+	if _, err := time.Parse("Mon Jan 2", label); err == nil {
+		return "on " + label
+	}
+	return "over " + label
+}
@@ -0,0 +1,482 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+const (
+	AlertOfflineDuration = "offline_duration"
+	AlertLowUptime       = "low_uptime"
+	AlertTempAbove       = "temp_above"
+	AlertDiskAbove       = "disk_above"
+
+	defaultAlertCooldown = time.Hour
+)
+
+// AlertStore persists alert rules; implemented by store.PostgresStore.
+type AlertStore interface {
+	CreateAlertRule(ctx context.Context, rule models.AlertRule) (models.AlertRule, error)
+	ListAlertRules(ctx context.Context, ownerKey string) ([]models.AlertRule, error)
+	DeleteAlertRule(ctx context.Context, ownerKey string, id int64) error
+	MarkAlertRuleFired(ctx context.Context, id int64, at time.Time) error
+}
+
+// ValidateAlertRule normalizes a rule and checks it is evaluable.
+func ValidateAlertRule(rule *models.AlertRule) error {
+	rule.ScopeType = strings.ToLower(strings.TrimSpace(rule.ScopeType))
+	rule.ScopeValue = strings.ToLower(strings.TrimSpace(rule.ScopeValue))
+	rule.Condition = strings.ToLower(strings.TrimSpace(rule.Condition))
+	if rule.ScopeType == "" {
+		rule.ScopeType = "all"
+	}
+	switch rule.ScopeType {
+	case "all":
+		rule.ScopeValue = ""
+	case "city", "region", "host":
+		if rule.ScopeValue == "" {
+			return fmt.Errorf("scope_value is required for scope_type %q", rule.ScopeType)
+		}
+	default:
+		return fmt.Errorf("unknown scope_type %q (use all, city, region or host)", rule.ScopeType)
+	}
+	switch rule.Condition {
+	case AlertOfflineDuration, AlertLowUptime, AlertTempAbove, AlertDiskAbove:
+	default:
+		return fmt.Errorf("unknown condition %q", rule.Condition)
+	}
+	if rule.Threshold <= 0 {
+		return errors.New("threshold must be positive")
+	}
+	if rule.CooldownSeconds <= 0 {
+		rule.CooldownSeconds = int64(defaultAlertCooldown / time.Second)
+	}
+	return nil
+}
+
+// describeAlertRule renders a rule the way users phrase them.
+func describeAlertRule(r models.AlertRule) string {
+	scope := "any kiosk"
+	switch r.ScopeType {
+	case "city":
+		scope = "any kiosk in city '" + r.ScopeValue + "'"
+	case "region":
+		scope = "any kiosk in region '" + r.ScopeValue + "'"
+	case "host":
+		scope = r.ScopeValue
+	}
+	th := strconv.FormatFloat(r.Threshold, 'f', -1, 64)
+	var cond string
+	switch r.Condition {
+	case AlertOfflineDuration:
+		cond = "offline for more than " + th + " min"
+	case AlertLowUptime:
+		cond = "uptime below " + th + " min"
+	case AlertTempAbove:
+		cond = "temperature above " + th + "°C"
+	case AlertDiskAbove:
+		cond = "disk usage above " + th + "%"
+	default:
+		cond = r.Condition + " " + th
+	}
+	cooldown := time.Duration(r.CooldownSeconds) * time.Second
+	return fmt.Sprintf("#%d: %s %s (cooldown %s)", r.ID, scope, cond, cooldown)
+}
+
+// alertMetricRow is the subset of /metrics/latest the evaluator needs.
+type alertMetricRow struct {
+	ServerID    string    `json:"server_id"`
+	Time        time.Time `json:"time"`
+	Uptime      int64     `json:"uptime"`
+	Temperature float64   `json:"temperature"`
+	Disk        float64   `json:"disk"`
+	City        string    `json:"city"`
+	Region      string    `json:"region"`
+}
+
+type alertBreach struct {
+	Host   string
+	Detail string
+}
+
+func alertRuleInScope(r models.AlertRule, row alertMetricRow) bool {
+	switch r.ScopeType {
+	case "city":
+		return strings.EqualFold(strings.TrimSpace(row.City), r.ScopeValue)
+	case "region":
+		return strings.EqualFold(strings.TrimSpace(row.Region), r.ScopeValue)
+	case "host":
+		return strings.EqualFold(strings.TrimSpace(row.ServerID), r.ScopeValue)
+	}
+	return true
+}
+
+// evaluateAlertRule returns the kiosks breaching the rule in the snapshot.
+func evaluateAlertRule(r models.AlertRule, rows []alertMetricRow, now time.Time) []alertBreach {
+	out := make([]alertBreach, 0)
+	for _, row := range rows {
+		host := strings.ToLower(strings.TrimSpace(row.ServerID))
+		if host == "" || !alertRuleInScope(r, row) {
+			continue
+		}
+		switch r.Condition {
+		case AlertOfflineDuration:
+			if row.Time.IsZero() {
+				continue
+			}
+			silent := now.Sub(row.Time)
+			if silent > time.Duration(r.Threshold*float64(time.Minute)) {
+				out = append(out, alertBreach{Host: host, Detail: "offline " + silent.Round(time.Minute).String()})
+			}
+		case AlertLowUptime:
+			if row.Uptime <= 0 {
+				continue
+			}
+			if float64(row.Uptime) < r.Threshold*60 {
+				out = append(out, alertBreach{Host: host, Detail: "uptime " + (time.Duration(row.Uptime) * time.Second).String()})
+			}
+		case AlertTempAbove:
+			if row.Temperature > r.Threshold {
+				out = append(out, alertBreach{Host: host, Detail: formatDecimal(row.Temperature, 1) + "°C"})
+			}
+		case AlertDiskAbove:
+			if row.Disk > r.Threshold {
+				out = append(out, alertBreach{Host: host, Detail: fmt.Sprintf("disk %s", formatPercent(row.Disk))})
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
+
+func alertCooldownElapsed(r models.AlertRule, now time.Time) bool {
+	if r.LastFiredAt == nil {
+		return true
+	}
+	return now.Sub(*r.LastFiredAt) >= time.Duration(r.CooldownSeconds)*time.Second
+}
+
+func formatAlertNotification(r models.AlertRule, breaches []alertBreach) string {
+	lines := []string{fmt.Sprintf("Alert %s — %d kiosk(s) breached:", describeAlertRule(r), len(breaches))}
+	for i, b := range breaches {
+		if i >= 20 {
+			lines = append(lines, fmt.Sprintf("…and %d more.", len(breaches)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("- %s (%s)", b.Host, b.Detail))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// AlertEvaluator periodically checks alert rules against /metrics/latest.
+type AlertEvaluator struct {
+	Gateway  *GatewayClient
+	Rules    AlertStore
+	Store    Store
+	Interval time.Duration
+	// WebhookURL receives notifications for rules without their own webhook.
+	WebhookURL string
+	// Webhooks limits the hosts notifications are posted to.
+	Webhooks *WebhookGuard
+	// Limits pages /metrics/latest; see DefaultLimits.
+	Limits Limits
+	// Outbox, when set, queues webhook notifications in the transaction that
+	// marks the rule fired instead of posting them inline.
+	Outbox OutboxStore
+}
+
+func (e *AlertEvaluator) Run(ctx context.Context) {
+	interval := e.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			guardTick("alert evaluator", func() {
+				if err := e.evaluateOnce(ctx, time.Now()); err != nil {
+					log.Printf("alert evaluator: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func (e *AlertEvaluator) evaluateOnce(ctx context.Context, now time.Time) error {
+	rules, err := e.Rules.ListAlertRules(ctx, "")
+	if err != nil {
+		return err
+	}
+	due := make([]models.AlertRule, 0, len(rules))
+	for _, r := range rules {
+		if alertCooldownElapsed(r, now) {
+			due = append(due, r)
+		}
+	}
+	if len(due) == 0 {
+		return nil
+	}
+	rows, err := e.fetchMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	for _, r := range due {
+		breaches := evaluateAlertRule(r, rows, now)
+		if len(breaches) == 0 {
+			continue
+		}
+		queued, err := e.deliver(ctx, r, formatAlertNotification(r, breaches), now)
+		if err != nil {
+			log.Printf("alert evaluator: rule %d delivery failed: %v", r.ID, err)
+			continue
+		}
+		if queued {
+			continue
+		}
+		if err := e.Rules.MarkAlertRuleFired(ctx, r.ID, now); err != nil {
+			log.Printf("alert evaluator: rule %d mark fired: %v", r.ID, err)
+		}
+	}
+	return nil
+}
+
+func (e *AlertEvaluator) fetchMetrics(ctx context.Context) ([]alertMetricRow, error) {
+	if e.Gateway == nil {
+		return nil, errors.New("tool gateway is not configured")
+	}
+	rows := make([]alertMetricRow, 0, 256)
+	page := 1
+	pageSize := e.Limits.Normalized().ListPageSize
+	maxPages := e.Limits.Normalized().MetricsMaxPages
+	var fetched int64
+	for {
+		path := fmt.Sprintf("/metrics/latest?page=%d&page_size=%d&include_totals=false", page, pageSize)
+		status, body, err := e.Gateway.Get(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		if status < 200 || status >= 300 {
+			return nil, fmt.Errorf("metrics/latest status %d", status)
+		}
+		var payload struct {
+			Data       []alertMetricRow  `json:"data"`
+			Pagination gatewayPagination `json:"pagination"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+		rows = append(rows, payload.Data...)
+		fetched += int64(len(payload.Data))
+		if payload.Pagination.done(len(payload.Data), pageSize, fetched) {
+			break
+		}
+		page++
+		if page > maxPages {
+			break
+		}
+	}
+	return rows, nil
+}
+
+// deliver posts the notification to the rule's conversation and webhook.
+// With an Outbox the webhook is queued and the rule marked fired in one
+// transaction, which deliver reports as queued.
+func (e *AlertEvaluator) deliver(ctx context.Context, r models.AlertRule, text string, now time.Time) (bool, error) {
+	delivered := false
+	if strings.TrimSpace(r.ConversationID) != "" && e.Store != nil {
+		if err := e.Store.AppendMessage(ctx, r.OwnerKey, r.ConversationID, "assistant", text); err != nil {
+			return false, err
+		}
+		delivered = true
+	}
+	hook := strings.TrimSpace(r.WebhookURL)
+	if hook == "" {
+		hook = strings.TrimSpace(e.WebhookURL)
+	}
+	if hook != "" {
+		payload, _ := json.Marshal(map[string]any{"rule": r, "text": text})
+		if e.Outbox != nil {
+			entry, _ := json.Marshal(outboxWebhook{URL: hook, Body: payload})
+			if err := e.Outbox.MarkAlertRuleFiredAndEnqueue(ctx, r.ID, now, OutboxWebhook, entry); err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+		if err := e.Webhooks.Post(ctx, hook, payload); err != nil {
+			return false, err
+		}
+		delivered = true
+	}
+	if !delivered {
+		return false, errors.New("rule has no conversation or webhook to notify")
+	}
+	return false, nil
+}
+
+var (
+	alertDeleteRe   = regexp.MustCompile(`\b(?:delete|remove|cancel)\s+alert\s+#?(\d+)\b`)
+	alertNumberRe   = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*(%|percent|°c|c\b|degrees?|minutes?|mins?|m\b|hours?|hrs?|h\b)?`)
+	alertCooldownRe = regexp.MustCompile(`cooldown\s+(?:of\s+)?(\d+)\s*(minutes?|mins?|m\b|hours?|hrs?|h\b)?`)
+)
+
+func isAlertRuleIntent(msgLower string) bool {
+	if alertDeleteRe.MatchString(msgLower) {
+		return true
+	}
+	if (strings.Contains(msgLower, "list") || strings.Contains(msgLower, "show")) && (strings.Contains(msgLower, "my alerts") || strings.Contains(msgLower, "alert rules")) {
+		return true
+	}
+	return strings.Contains(msgLower, "alert me") || strings.Contains(msgLower, "notify me")
+}
+
+// parseAlertCondition extracts the condition type and threshold from phrases
+// like "goes offline for more than 15 minutes" or "disk above 90%".
+func parseAlertCondition(msgLower string) (string, float64, bool) {
+	msg := alertCooldownRe.ReplaceAllString(msgLower, "")
+	var cond string
+	switch {
+	case strings.Contains(msg, "offline") || strings.Contains(msg, "goes down") || strings.Contains(msg, "stops reporting"):
+		cond = AlertOfflineDuration
+	case strings.Contains(msg, "uptime"):
+		cond = AlertLowUptime
+	case strings.Contains(msg, "temp"):
+		cond = AlertTempAbove
+	case strings.Contains(msg, "disk"):
+		cond = AlertDiskAbove
+	default:
+		return "", 0, false
+	}
+	// Skip numbers that are part of host ids.
+	if h := alertHostToken(msg); h != "" {
+		msg = strings.ReplaceAll(msg, h, " ")
+	}
+	m := alertNumberRe.FindStringSubmatch(msg)
+	if m == nil {
+		return cond, 0, false
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil || v <= 0 {
+		return cond, 0, false
+	}
+	if cond == AlertOfflineDuration || cond == AlertLowUptime {
+		if strings.HasPrefix(m[2], "h") {
+			v *= 60
+		}
+	}
+	return cond, v, true
+}
+
+// alertHostToken returns the first host-like token; detectHostTokens alone
+// also matches thresholds such as "70c", so require a dashed host id.
+func alertHostToken(msg string) string {
+	for _, h := range detectHostTokens(msg) {
+		if strings.Contains(h, "-") {
+			return strings.ToLower(h)
+		}
+	}
+	return ""
+}
+
+func parseAlertCooldown(msgLower string) int64 {
+	m := alertCooldownRe.FindStringSubmatch(msgLower)
+	if m == nil {
+		return 0
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	if strings.HasPrefix(m[2], "h") {
+		return n * 3600
+	}
+	return n * 60
+}
+
+func (c *ChatService) handleAlertRules(ctx context.Context, ownerKey string, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(strings.TrimSpace(req.Message))
+	if !isAlertRuleIntent(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	reply := func(answer string) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(answer)
+		}
+		return models.ChatResponse{Answer: answer}, true, nil
+	}
+	if c.Alerts == nil {
+		return reply("Alert rules are not configured.")
+	}
+
+	if m := alertDeleteRe.FindStringSubmatch(msgLower); m != nil {
+		id, _ := strconv.ParseInt(m[1], 10, 64)
+		if err := c.Alerts.DeleteAlertRule(ctx, ownerKey, id); err != nil {
+			return reply(fmt.Sprintf("Alert #%d was not found.", id))
+		}
+		return reply(fmt.Sprintf("Deleted alert #%d.", id))
+	}
+
+	if !strings.Contains(msgLower, "alert me") && !strings.Contains(msgLower, "notify me") {
+		rules, err := c.Alerts.ListAlertRules(ctx, ownerKey)
+		if err != nil {
+			return reply("Failed to load alert rules: " + err.Error())
+		}
+		if len(rules) == 0 {
+			return reply("You have no alert rules.")
+		}
+		lines := []string{"Your alerts:"}
+		for _, r := range rules {
+			lines = append(lines, "- "+describeAlertRule(r))
+		}
+		return reply(strings.Join(lines, "\n"))
+	}
+
+	cond, threshold, ok := parseAlertCondition(msgLower)
+	if cond == "" {
+		return reply("I can alert on: offline for more than N minutes, uptime below N minutes, temperature above N°C, or disk above N%.")
+	}
+	if !ok {
+		return reply("Please include an explicit number for the threshold (for example: \"offline for more than 15 minutes\").")
+	}
+
+	rule := models.AlertRule{
+		OwnerKey:        ownerKey,
+		ScopeType:       "all",
+		Condition:       cond,
+		Threshold:       threshold,
+		CooldownSeconds: parseAlertCooldown(msgLower),
+		ConversationID:  strings.TrimSpace(req.ConversationID),
+	}
+	if host := alertHostToken(req.Message); host != "" {
+		rule.ScopeType, rule.ScopeValue = "host", host
+	} else if region := c.detectRegionCode(ctx, msgLower); region != "" {
+		rule.ScopeType, rule.ScopeValue = "region", region
+	} else if city := c.detectCityCode(ctx, msgLower); city != "" {
+		rule.ScopeType, rule.ScopeValue = "city", city
+	}
+	if err := ValidateAlertRule(&rule); err != nil {
+		return reply("Could not create alert: " + err.Error())
+	}
+	created, err := c.Alerts.CreateAlertRule(ctx, rule)
+	if err != nil {
+		return reply("Failed to save alert rule: " + err.Error())
+	}
+	answer := "Created alert " + describeAlertRule(created) + "."
+	if created.ConversationID == "" {
+		answer += " Notifications go to the configured webhook since this chat has no conversation_id."
+	}
+	return reply(answer)
+}
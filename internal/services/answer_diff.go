@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// AnswerSnapshotStore keeps the last structured result of each list-style
+// question per owner; implemented by store.PostgresStore.
+type AnswerSnapshotStore interface {
+	LatestAnswerSnapshot(ctx context.Context, ownerKey, question string) (models.AnswerSnapshot, bool, error)
+	PutAnswerSnapshot(ctx context.Context, s models.AnswerSnapshot) error
+}
+
+// maxDiffLines caps the lines listed per kind of change.
+const maxDiffLines = 15
+
+// rowChange is a row whose value moved past the change threshold.
+type rowChange struct {
+	Row    models.AnswerRow
+	Before float64
+}
+
+// rowDiff is what changed between two results of the same question.
+type rowDiff struct {
+	Added   []models.AnswerRow
+	Removed []models.AnswerRow
+	Changed []rowChange
+}
+
+func (d rowDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// diffRows compares rows by key. A row counts as changed when its value
+// moved by more than pct percent of the previous value (any move from zero).
+func diffRows(prev, cur []models.AnswerRow, pct float64) rowDiff {
+	before := make(map[string]models.AnswerRow, len(prev))
+	for _, r := range prev {
+		before[r.Key] = r
+	}
+	var d rowDiff
+	seen := make(map[string]bool, len(cur))
+	for _, r := range cur {
+		seen[r.Key] = true
+		p, ok := before[r.Key]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, r)
+		case p.Value == r.Value:
+		case p.Value == 0 || math.Abs(r.Value-p.Value)/math.Abs(p.Value)*100 > pct:
+			d.Changed = append(d.Changed, rowChange{Row: r, Before: p.Value})
+		}
+	}
+	for _, r := range prev {
+		if !seen[r.Key] {
+			d.Removed = append(d.Removed, r)
+		}
+	}
+	sort.SliceStable(d.Changed, func(i, j int) bool {
+		return math.Abs(d.Changed[i].Row.Value-d.Changed[i].Before) > math.Abs(d.Changed[j].Row.Value-d.Changed[j].Before)
+	})
+	return d
+}
+
+// formatChanges renders a diff as the "Changes since ..." section. format
+// renders a row value; with nil, new rows are listed without one.
+func formatChanges(since time.Time, d rowDiff, format func(float64) string) string {
+	stamp := since.UTC().Format("2006-01-02 15:04 UTC")
+	if d.empty() {
+		return fmt.Sprintf("No changes since %s.", stamp)
+	}
+	label := func(r models.AnswerRow) string {
+		if r.Label != "" {
+			return r.Label
+		}
+		return r.Key
+	}
+	showAdded := format != nil
+	if format == nil {
+		format = func(v float64) string { return formatThousands(int64(math.Round(v))) }
+	}
+	lines := []string{fmt.Sprintf("Changes since %s:", stamp)}
+	section := func(title string, n int, line func(i int) string) {
+		if n == 0 {
+			return
+		}
+		lines = append(lines, fmt.Sprintf("%s (%d):", title, n))
+		for i := 0; i < n && i < maxDiffLines; i++ {
+			lines = append(lines, "- "+line(i))
+		}
+		if n > maxDiffLines {
+			lines = append(lines, fmt.Sprintf("- …and %d more", n-maxDiffLines))
+		}
+	}
+	section("New", len(d.Added), func(i int) string {
+		r := d.Added[i]
+		if !showAdded {
+			return label(r)
+		}
+		return label(r) + " — " + format(r.Value)
+	})
+	section("Gone", len(d.Removed), func(i int) string { return label(d.Removed[i]) })
+	section("Changed", len(d.Changed), func(i int) string {
+		ch := d.Changed[i]
+		s := fmt.Sprintf("%s — %s → %s", label(ch.Row), format(ch.Before), format(ch.Row.Value))
+		if ch.Before != 0 {
+			s += fmt.Sprintf(" (%s)", formatSignedPercent((ch.Row.Value-ch.Before)/math.Abs(ch.Before)*100))
+		}
+		return s
+	})
+	return strings.Join(lines, "\n")
+}
+
+// answerChanges stores rows as the latest result of the request's question
+// and returns the changes since the previous result of the same owner, or ""
+// when there is none. kind names the handler's result shape; a previous
+// result of another kind is not compared.
+func (c *ChatService) answerChanges(ctx context.Context, req models.ChatRequest, kind string, rows []models.AnswerRow, format func(float64) string) string {
+	route := answerRouteFrom(ctx)
+	if c.Snapshots == nil || route == nil || route.owner == "" {
+		return ""
+	}
+	question := NormalizeQueryName(req.Message)
+	if question == "" {
+		return ""
+	}
+	prev, found, err := c.Snapshots.LatestAnswerSnapshot(ctx, route.owner, question)
+	if err != nil {
+		log.Printf("answer diff: load failed kind=%s: %v", kind, err)
+	}
+	cur := models.AnswerSnapshot{OwnerKey: route.owner, Question: question, Kind: kind, Rows: rows}
+	if err := c.Snapshots.PutAnswerSnapshot(context.WithoutCancel(ctx), cur); err != nil {
+		log.Printf("answer diff: save failed kind=%s: %v", kind, err)
+	}
+	if !found || prev.Kind != kind {
+		return ""
+	}
+	pct := float64(c.AnswerDiffPercent)
+	if pct <= 0 {
+		pct = 10
+	}
+	return formatChanges(prev.CreatedAt, diffRows(prev.Rows, rows, pct), format)
+}
+
+// withChanges puts a changes section above the full answer, or answers with
+// the changes alone for brief requests.
+func withChanges(req models.ChatRequest, changes, answer string) string {
+	if changes == "" {
+		return answer
+	}
+	if strings.EqualFold(strings.TrimSpace(req.Verbosity), "brief") {
+		return changes
+	}
+	return changes + "\n\n" + answer
+}
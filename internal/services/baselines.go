@@ -0,0 +1,442 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+const (
+	// baselineWindow is how much of a host's history a baseline is read from.
+	baselineWindow = 7 * 24 * time.Hour
+	// baselineMinSamples is the fewest samples a baseline is computed from.
+	baselineMinSamples = 20
+	// baselineTTL is how long a computed baseline is reused.
+	baselineTTL = time.Hour
+)
+
+// baselineSample is one /metrics/history row, reduced to the fields a
+// baseline reads.
+type baselineSample struct {
+	Time            time.Time `json:"time"`
+	CPU             float64   `json:"cpu"`
+	Memory          float64   `json:"memory"`
+	Temperature     float64   `json:"temperature"`
+	NetDailyRxBytes int64     `json:"net_daily_rx_bytes"`
+	NetDailyTxBytes int64     `json:"net_daily_tx_bytes"`
+}
+
+// baselineMetric is a metric a baseline can be computed for. values reads its
+// series from history sorted oldest first.
+type baselineMetric struct {
+	Name   string
+	Label  string
+	words  []string
+	values func(samples []baselineSample) []float64
+	format func(u unitPrefs, v float64) string
+}
+
+var baselineMetrics = []baselineMetric{
+	{Name: "cpu", Label: "CPU", words: []string{"cpu", "processor"},
+		values: func(s []baselineSample) []float64 {
+			return sampleValues(s, func(x baselineSample) float64 { return x.CPU })
+		},
+		format: func(_ unitPrefs, v float64) string { return formatPercent(v) }},
+	{Name: "memory", Label: "memory", words: []string{"memory", "ram"},
+		values: func(s []baselineSample) []float64 {
+			return sampleValues(s, func(x baselineSample) float64 { return x.Memory })
+		},
+		format: func(_ unitPrefs, v float64) string { return formatPercent(v) }},
+	{Name: "temperature", Label: "temperature", words: []string{"temperature", "temp", "heat"},
+		values: func(s []baselineSample) []float64 {
+			return sampleValues(s, func(x baselineSample) float64 { return x.Temperature })
+		},
+		format: func(u unitPrefs, v float64) string { return u.temp(v) }},
+	{Name: "network", Label: "network traffic", words: []string{"network", "bandwidth", "traffic", "data usage"},
+		values: networkRates,
+		format: func(u unitPrefs, v float64) string { return u.bytes(int64(v)) + "/h" }},
+}
+
+func sampleValues(samples []baselineSample, f func(baselineSample) float64) []float64 {
+	out := make([]float64, 0, len(samples))
+	for _, s := range samples {
+		out = append(out, f(s))
+	}
+	return out
+}
+
+// networkRates turns the daily traffic counters into bytes per hour between
+// consecutive samples of the same day; the counters reset at midnight, so a
+// pair that goes down is skipped.
+func networkRates(samples []baselineSample) []float64 {
+	out := make([]float64, 0, len(samples))
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1], samples[i]
+		gap := cur.Time.Sub(prev.Time)
+		delta := (cur.NetDailyRxBytes + cur.NetDailyTxBytes) - (prev.NetDailyRxBytes + prev.NetDailyTxBytes)
+		if gap <= 0 || delta < 0 {
+			continue
+		}
+		out = append(out, float64(delta)/gap.Hours())
+	}
+	return out
+}
+
+// metricBaseline is the typical range of one metric on one host: the median
+// and the interquartile range of its last baselineWindow of history, and the
+// latest value. Samples below baselineMinSamples leave the range unset.
+type metricBaseline struct {
+	Host    string
+	Metric  string
+	Samples int
+	Median  float64
+	Q1      float64
+	Q3      float64
+	Current float64
+	at      time.Time
+}
+
+// established reports whether there was enough history for a range.
+func (b metricBaseline) established() bool {
+	return b.Samples >= baselineMinSamples
+}
+
+// verdict places v against the typical range.
+func (b metricBaseline) verdict(v float64) string {
+	switch {
+	case v > b.Q3:
+		return "higher than normal"
+	case v < b.Q1:
+		return "lower than normal"
+	}
+	return "within the normal range"
+}
+
+// typicalRange renders the interquartile range ("35.0%–48.0%"), or the one
+// value when the quartiles agree.
+func (b metricBaseline) typicalRange(m baselineMetric, units unitPrefs) string {
+	lo, hi := m.format(units, b.Q1), m.format(units, b.Q3)
+	if lo == hi {
+		return lo
+	}
+	return lo + "–" + hi
+}
+
+// quantile is the q-quantile of sorted values, interpolating linearly
+// between the two nearest ranks.
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+// computeBaseline summarizes values (oldest first) as a baseline.
+func computeBaseline(host, metric string, values []float64, at time.Time) metricBaseline {
+	b := metricBaseline{Host: host, Metric: metric, Samples: len(values), at: at}
+	if len(values) == 0 {
+		return b
+	}
+	b.Current = values[len(values)-1]
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	b.Median = quantile(sorted, 0.5)
+	b.Q1 = quantile(sorted, 0.25)
+	b.Q3 = quantile(sorted, 0.75)
+	return b
+}
+
+// hostBaselines returns the baselines of metrics on host, from the cache when
+// they were computed within baselineTTL and otherwise from a fresh read of
+// the host's history, which also refreshes the other metrics.
+func (c *ChatService) hostBaselines(ctx context.Context, host string, metrics []baselineMetric) (map[string]metricBaseline, []models.Step, error) {
+	now := time.Now()
+	out := make(map[string]metricBaseline, len(metrics))
+	c.baselineMu.Lock()
+	for _, m := range metrics {
+		if b, ok := c.baselineCache[host+"|"+m.Name]; ok && now.Sub(b.at) < baselineTTL {
+			out[m.Name] = b
+		}
+	}
+	c.baselineMu.Unlock()
+	if len(out) == len(metrics) {
+		return out, nil, nil
+	}
+
+	samples, steps, err := c.fetchBaselineHistory(ctx, host, now.Add(-baselineWindow))
+	if err != nil {
+		return nil, steps, err
+	}
+	c.baselineMu.Lock()
+	defer c.baselineMu.Unlock()
+	if c.baselineCache == nil {
+		c.baselineCache = map[string]metricBaseline{}
+	}
+	for _, m := range baselineMetrics {
+		b := computeBaseline(host, m.Name, m.values(samples), now)
+		c.baselineCache[host+"|"+m.Name] = b
+		out[m.Name] = b
+	}
+	for name := range out {
+		if !baselineRequested(metrics, name) {
+			delete(out, name)
+		}
+	}
+	return out, steps, nil
+}
+
+func baselineRequested(metrics []baselineMetric, name string) bool {
+	for _, m := range metrics {
+		if m.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchBaselineHistory pages the host's /metrics/history, newest first, until
+// a sample older than since or MetricsMaxPages, and returns the samples from
+// since on, oldest first.
+func (c *ChatService) fetchBaselineHistory(ctx context.Context, host string, since time.Time) ([]baselineSample, []models.Step, error) {
+	pageSize := c.limits().ListPageSize
+	var samples []baselineSample
+	var steps []models.Step
+	var fetched int64
+	for page := 1; page <= c.limits().MetricsMaxPages; page++ {
+		path := fmt.Sprintf("/metrics/history?page=%d&page_size=%d&include_totals=false&server_id=%s", page, pageSize, urlEscape(host))
+		status, body, err := c.Gateway.Get(ctx, path)
+		step := models.Step{Tool: "metricsHistory", Status: status}
+		if err != nil {
+			step.Error = err.Error()
+			return nil, append(steps, step), err
+		}
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		steps = append(steps, step)
+		if status < 200 || status >= 300 {
+			return nil, steps, fmt.Errorf("status %d", status)
+		}
+		var payload struct {
+			Data       []baselineSample  `json:"data"`
+			Pagination gatewayPagination `json:"pagination"`
+		}
+		if json.Unmarshal(body, &payload) != nil {
+			return nil, steps, fmt.Errorf("telemetry history response could not be parsed")
+		}
+		older := false
+		for _, s := range payload.Data {
+			if s.Time.Before(since) {
+				older = true
+				continue
+			}
+			samples = append(samples, s)
+		}
+		fetched += int64(len(payload.Data))
+		if older || payload.Pagination.done(len(payload.Data), pageSize, fetched) {
+			break
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples, steps, nil
+}
+
+var (
+	baselineIntentRe = regexp.MustCompile(`\b(?:normal|typical|typically|usual|usually|baseline)\b`)
+	baselineValueRe  = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*(%|percent|°\s*[cf]\b|degrees?(?:\s+[cf]\b)?|[cf]\b)?`)
+	// baselineReadingRe matches a token that is a reading ("100f"), not a host.
+	baselineReadingRe = regexp.MustCompile(`^\d+(?:\.\d+)?[cf]?$`)
+)
+
+// baselineHosts returns the host tokens of a baseline question, leaving out
+// readings such as "100f" and the question mark after a host.
+func baselineHosts(msg string) []string {
+	var out []string
+	for _, h := range detectHostTokens(strings.NewReplacer("?", " ", "!", " ").Replace(msg)) {
+		if !baselineReadingRe.MatchString(strings.ToLower(h)) {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// baselineMetricsIn returns the metrics a message names.
+func baselineMetricsIn(msgLower string) []baselineMetric {
+	var out []baselineMetric
+	for _, m := range baselineMetrics {
+		for _, w := range m.words {
+			if containsWord(msgLower, w) || (strings.Contains(w, " ") && strings.Contains(msgLower, w)) {
+				out = append(out, m)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// statedBaselineValue reads the value a question asks about ("is 62% CPU
+// normal"), in the metric's own unit. Host names are removed first so their
+// digits are not read as the value.
+func statedBaselineValue(msgLower string, hosts []string, m baselineMetric) (float64, bool) {
+	if m.Name == "network" {
+		return 0, false
+	}
+	for _, h := range hosts {
+		msgLower = strings.ReplaceAll(msgLower, strings.ToLower(h), " ")
+	}
+	match := baselineValueRe.FindStringSubmatch(msgLower)
+	if match == nil {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	unit := strings.ReplaceAll(match[2], " ", "")
+	if m.Name == "temperature" && (strings.HasSuffix(unit, "f") || (unit == "" && strings.Contains(msgLower, "fahrenheit"))) {
+		v = (v - 32) * 5 / 9
+	}
+	return v, true
+}
+
+// handleTelemetryBaseline answers "is 62% CPU normal for briggs-001?" and
+// "what's the typical temperature of it?" against the host's baseline: the
+// median and interquartile range of the last seven days of history. A
+// question without a metric ("is this normal?") checks all of them.
+func (c *ChatService) handleTelemetryBaseline(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(strings.TrimSpace(req.Message))
+	if !baselineIntentRe.MatchString(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	metrics := baselineMetricsIn(msgLower)
+	hosts := baselineHosts(req.Message)
+	host := ""
+	if len(hosts) > 0 {
+		host = strings.ToLower(strings.TrimSpace(hosts[0]))
+	} else if st := c.getConversationState(req.ConversationID); st != nil {
+		host = st.Host
+	}
+	if len(metrics) == 0 {
+		// "is this normal?" only reads as a baseline question about a device
+		// the conversation is already on.
+		if host == "" || !(containsWord(msgLower, "this") || containsWord(msgLower, "that") || containsWord(msgLower, "it")) {
+			return models.ChatResponse{}, false, nil
+		}
+		metrics = baselineMetrics
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	if host == "" {
+		return reply(models.ChatResponse{Answer: "Which device should I compare against its usual range? Ask again with its host, for example: is 62% CPU normal for moco-brt-briggs-001?"})
+	}
+	if c.Gateway == nil {
+		return reply(models.ChatResponse{Answer: "Tool gateway is not configured."})
+	}
+	c.updateConversationHost(req.ConversationID, host)
+
+	baselines, steps, err := c.hostBaselines(ctx, host, metrics)
+	if err != nil {
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("Failed to read the telemetry history of '%s': %s", host, err.Error()), Steps: steps})
+	}
+	latest, latestStep := c.fetchHostLatestTelemetry(ctx, host)
+	steps = append(steps, latestStep)
+	units := c.unitPrefs(req)
+	lines := make([]string, 0, len(metrics)+1)
+	if len(metrics) > 1 {
+		lines = append(lines, fmt.Sprintf("'%s' against its last 7 days:", host))
+	}
+	for _, m := range metrics {
+		b := baselines[m.Name]
+		value, stated := statedBaselineValue(msgLower, hosts, m)
+		if len(metrics) > 1 || !stated {
+			value, stated = latestBaselineValue(b, m, latest), false
+		}
+		lines = append(lines, describeBaseline(b, m, units, value, stated, len(metrics) > 1))
+	}
+	return reply(models.ChatResponse{Answer: strings.Join(lines, "\n"), Steps: steps})
+}
+
+// latestBaselineValue is the metric's current value: the latest sample when
+// one was read, else the newest value of the baseline's history. Network
+// traffic is a rate between samples, so it always comes from the history.
+func latestBaselineValue(b metricBaseline, m baselineMetric, latest hostTelemetrySample) float64 {
+	if !latest.Found {
+		return b.Current
+	}
+	switch m.Name {
+	case "cpu":
+		return latest.CPU
+	case "memory":
+		return latest.Memory
+	case "temperature":
+		return latest.Temperature
+	}
+	return b.Current
+}
+
+// describeBaseline renders one metric against its baseline: value is the one
+// the question stated, or else the current one. listed renders it as a row of
+// a multi-metric answer.
+func describeBaseline(b metricBaseline, m baselineMetric, units unitPrefs, value float64, stated, listed bool) string {
+	subject := fmt.Sprintf("%s on '%s'", capitalize(m.Label), b.Host)
+	if listed {
+		subject = "- " + capitalize(m.Label)
+	}
+	if !b.established() {
+		return fmt.Sprintf("%s: not enough history to establish a baseline (%d samples in the last 7 days; at least %d are needed).", subject, b.Samples, baselineMinSamples)
+	}
+	typical := fmt.Sprintf("usually %s (median %s)", b.typicalRange(m, units), m.format(units, b.Median))
+	if stated {
+		return fmt.Sprintf("%s is %s; %s is %s.", subject, typical, m.format(units, value), b.verdict(value))
+	}
+	return fmt.Sprintf("%s: %s, currently %s — %s.", subject, typical, m.format(units, value), b.verdict(value))
+}
+
+// baselineNotes is the section telemetry answers add when BaselineAnnotations
+// is on: the usual range of each current reading, keyed by metric name, with
+// a verdict for those outside it. It is empty when no baseline is
+// established or the history cannot be read.
+func (c *ChatService) baselineNotes(ctx context.Context, host string, units unitPrefs, current map[string]float64) (string, []models.Step) {
+	var metrics []baselineMetric
+	for _, m := range baselineMetrics {
+		if _, ok := current[m.Name]; ok {
+			metrics = append(metrics, m)
+		}
+	}
+	if len(metrics) == 0 {
+		return "", nil
+	}
+	baselines, steps, err := c.hostBaselines(ctx, host, metrics)
+	if err != nil {
+		return "", steps
+	}
+	var notes []string
+	for _, m := range metrics {
+		b := baselines[m.Name]
+		if !b.established() {
+			continue
+		}
+		note := fmt.Sprintf("%s usually %s", m.Label, b.typicalRange(m, units))
+		if v := b.verdict(current[m.Name]); v != "within the normal range" {
+			note += ", now " + v
+		}
+		notes = append(notes, note)
+	}
+	if len(notes) == 0 {
+		return "", steps
+	}
+	return "Last 7 days: " + strings.Join(notes, "; ") + ".", steps
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// Cache names accepted by FlushCaches and reported by CacheSnapshot.
+const (
+	CacheCity        = "city"
+	CacheRegion      = "region"
+	CacheProjects    = "projects"
+	CacheDeviceHosts = "device_hosts"
+	CacheDeviceMeta  = "device_meta"
+	CacheGateway     = "gateway"
+	CacheBaselines   = "baselines"
+)
+
+var knownCaches = []string{CacheCity, CacheRegion, CacheProjects, CacheDeviceHosts, CacheDeviceMeta, CacheGateway, CacheBaselines}
+
+func sortedKeys(m map[string]struct{}) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func cacheInfo(name string, keys []string, at time.Time, ttl time.Duration) models.CacheInfo {
+	info := models.CacheInfo{Name: name, Entries: len(keys), Keys: keys, TTLSecond: int64(ttl / time.Second)}
+	if !at.IsZero() {
+		info.CachedAt = at.UTC()
+		info.AgeSecond = int64(time.Since(at) / time.Second)
+	}
+	return info
+}
+
+// CacheSnapshot returns the contents and ages of the scope-detection caches.
+// Each cache is copied under its own lock so request traffic is not held up.
+func (c *ChatService) CacheSnapshot() []models.CacheInfo {
+	out := make([]models.CacheInfo, 0, len(knownCaches))
+
+	c.cityMu.Lock()
+	out = append(out, cacheInfo(CacheCity, sortedKeys(c.cityCache), c.cityCacheAt, c.cityCacheTTL))
+	out = append(out, cacheInfo(CacheRegion, sortedKeys(c.regionCache), c.regionCacheAt, c.cityCacheTTL))
+	c.cityMu.Unlock()
+
+	c.projectMu.Lock()
+	projects := make([]string, 0, len(c.projectLookups))
+	for _, p := range c.projectLookups {
+		projects = append(projects, p.name)
+	}
+	out = append(out, cacheInfo(CacheProjects, projects, c.projectCityCacheAt, c.projectCacheTTL))
+	c.projectMu.Unlock()
+
+	c.deviceHostMu.Lock()
+	scopes := make([]string, 0, len(c.deviceHostCache))
+	oldest := time.Time{}
+	for k, e := range c.deviceHostCache {
+		scopes = append(scopes, fmt.Sprintf("%s (%d hosts)", strings.Trim(k, "|"), len(e.hosts)))
+		if oldest.IsZero() || e.at.Before(oldest) {
+			oldest = e.at
+		}
+	}
+	c.deviceHostMu.Unlock()
+	sort.Strings(scopes)
+	out = append(out, cacheInfo(CacheDeviceHosts, scopes, oldest, 5*time.Minute))
+
+	metaHosts, metaAt := c.deviceMetaSnapshot()
+	out = append(out, cacheInfo(CacheDeviceMeta, metaHosts, metaAt, c.deviceMetaTTL()))
+
+	if c.Gateway != nil && c.Gateway.ETags != nil {
+		paths, pathsAt := c.Gateway.ETags.snapshot()
+		out = append(out, cacheInfo(CacheGateway, paths, pathsAt, c.Gateway.ETags.MaxAge))
+	}
+
+	c.baselineMu.Lock()
+	baselineKeys := make([]string, 0, len(c.baselineCache))
+	baselineAt := time.Time{}
+	for k, b := range c.baselineCache {
+		baselineKeys = append(baselineKeys, k)
+		if baselineAt.IsZero() || b.at.Before(baselineAt) {
+			baselineAt = b.at
+		}
+	}
+	c.baselineMu.Unlock()
+	sort.Strings(baselineKeys)
+	out = append(out, cacheInfo(CacheBaselines, baselineKeys, baselineAt, baselineTTL))
+
+	return out
+}
+
+// FlushCaches clears the named caches (all of them when names is empty) so
+// they are re-populated from the gateway on next use.
+func (c *ChatService) FlushCaches(names ...string) ([]string, error) {
+	if len(names) == 0 {
+		names = knownCaches
+	}
+	want := map[string]bool{}
+	for _, n := range names {
+		n = strings.ToLower(strings.TrimSpace(n))
+		known := false
+		for _, k := range knownCaches {
+			if n == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown cache %q", n)
+		}
+		want[n] = true
+	}
+
+	if want[CacheCity] || want[CacheRegion] {
+		c.cityMu.Lock()
+		if want[CacheCity] {
+			c.cityCache = nil
+			c.cityCacheAt = time.Time{}
+		}
+		if want[CacheRegion] {
+			c.regionCache = nil
+			c.regionCacheAt = time.Time{}
+			c.regionToCity = nil
+		}
+		c.cityMu.Unlock()
+	}
+	if want[CacheProjects] {
+		c.projectMu.Lock()
+		c.projectLookups = nil
+		c.projectCityCache = nil
+		c.projectCityCacheAt = time.Time{}
+		c.projectMu.Unlock()
+	}
+	if want[CacheDeviceHosts] {
+		c.deviceHostMu.Lock()
+		c.deviceHostCache = nil
+		c.deviceHostMu.Unlock()
+	}
+	if want[CacheDeviceMeta] {
+		c.deviceMetaMu.Lock()
+		c.deviceMetaCache = nil
+		c.deviceMetaScopes = nil
+		c.deviceMetaMu.Unlock()
+	}
+	if want[CacheBaselines] {
+		c.baselineMu.Lock()
+		c.baselineCache = nil
+		c.baselineMu.Unlock()
+	}
+	if want[CacheGateway] && c.Gateway != nil {
+		c.Gateway.ETags.Flush()
+	}
+
+	flushed := make([]string, 0, len(want))
+	for _, k := range knownCaches {
+		if want[k] {
+			flushed = append(flushed, k)
+		}
+	}
+	return flushed, nil
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+var (
+	campaignBudgetRe = regexp.MustCompile(`\b(?:budgets?|spend|spent|spending)\b`)
+	// Budget wording removed before the campaign name is read ("campaign
+	// winter promo budget left" -> "campaign winter promo").
+	campaignBudgetWordsRe = regexp.MustCompile(`(?:'s)?\s*\b(?:budgets?|spend|spent|spending|remaining|left|consumed|pacing)\b`)
+)
+
+func isCampaignBudgetIntent(msgLower string) bool {
+	return campaignBudgetRe.MatchString(msgLower)
+}
+
+// Keys the campaign detail may carry its financial fields under, at the top
+// level or inside a "budget" object.
+var (
+	budgetAmountKeys       = []string{"budget", "total_budget", "budget_amount", "budget_total"}
+	budgetNestedAmountKeys = []string{"amount", "total", "budget", "total_budget"}
+	budgetSpentKeys        = []string{"spent", "spend", "amount_spent", "budget_spent", "spent_amount", "total_spend"}
+	budgetCurrencyKeys     = []string{"currency", "budget_currency", "currency_code"}
+)
+
+// numberField is floatField that also reports whether a key was present
+// with a number, so absent figures are not mistaken for zero.
+func numberField(m map[string]any, keys ...string) (float64, bool) {
+	for _, k := range keys {
+		switch v := m[k].(type) {
+		case float64:
+			return v, true
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// campaignFinancials reads budget, spend and currency from a campaign
+// record.
+func campaignFinancials(campaign map[string]any) (budget float64, hasBudget bool, spent float64, hasSpent bool, currency string) {
+	budget, hasBudget = numberField(campaign, budgetAmountKeys...)
+	spent, hasSpent = numberField(campaign, budgetSpentKeys...)
+	currency = anyString(campaign, budgetCurrencyKeys...)
+	if nested, ok := campaign["budget"].(map[string]any); ok {
+		if !hasBudget {
+			budget, hasBudget = numberField(nested, budgetNestedAmountKeys...)
+		}
+		if !hasSpent {
+			spent, hasSpent = numberField(nested, budgetSpentKeys...)
+		}
+		if currency == "" {
+			currency = anyString(nested, budgetCurrencyKeys...)
+		}
+	}
+	return budget, hasBudget, spent, hasSpent, strings.ToUpper(currency)
+}
+
+// fetchCampaign reads one campaign from the detail endpoint, unwrapping a
+// "data" envelope. On failure the string is the answer to give instead.
+func (c *ChatService) fetchCampaign(ctx context.Context, campaignID string) (map[string]any, models.Step, string) {
+	status, body, err := c.Gateway.Get(ctx, "/ads/campaigns/"+urlEscape(campaignID))
+	step := models.Step{Tool: "adsCampaignGet", CampaignID: campaignID, Status: status}
+	if err != nil {
+		step.Error = err.Error()
+		return nil, step, "Failed to fetch the campaign: " + err.Error()
+	}
+	step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	if status < 200 || status >= 300 {
+		return nil, step, fmt.Sprintf("Failed to fetch the campaign (status %d).", status)
+	}
+	var root map[string]any
+	if json.Unmarshal(body, &root) != nil {
+		return nil, step, "Campaign response could not be parsed."
+	}
+	if d, ok := root["data"].(map[string]any); ok {
+		return d, step, ""
+	}
+	return root, step, ""
+}
+
+// handleCampaignBudget answers "how much budget is left on campaign X" from
+// the budget, spend and currency on the campaign record, with spend pacing
+// when the campaign has flight dates. Deployments whose campaigns carry no
+// financial fields get a plain "not available" answer, never zeros.
+func (c *ChatService) handleCampaignBudget(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !isCampaignBudgetIntent(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	if c.Gateway == nil {
+		return reply(models.ChatResponse{Answer: "Tool gateway is not configured."})
+	}
+
+	campaign, query, ok := campaignCandidate{}, "", false
+	if q := campaignNameQuery(campaignBudgetWordsRe.ReplaceAllString(msgLower, " ")); q != "" {
+		campaign, query, ok = c.resolveCampaign(ctx, q)
+	}
+	if !ok {
+		if query != "" {
+			return reply(models.ChatResponse{Answer: fmt.Sprintf("I couldn't find a single campaign matching '%s'. Please give the campaign name or id.", query)})
+		}
+		if st := c.getConversationState(req.ConversationID); st != nil && looksLikeUUID(st.CampaignID) {
+			campaign = campaignCandidate{ID: st.CampaignID}
+		} else {
+			return reply(models.ChatResponse{Answer: "Which campaign? Ask like: how much budget is left on the Winter Promo campaign, or give the campaign id."})
+		}
+	}
+	c.updateConversationCampaignID(req.ConversationID, campaign.ID)
+
+	record, step, failure := c.fetchCampaign(ctx, campaign.ID)
+	steps := []models.Step{step}
+	if failure != "" {
+		return reply(models.ChatResponse{Answer: failure, Steps: steps})
+	}
+	name := anyString(record, "name", "campaign_name")
+	if name == "" {
+		name = campaign.Name
+	}
+	if name == "" {
+		name = campaign.ID
+	}
+
+	budget, hasBudget, spent, hasSpent, currency := campaignFinancials(record)
+	if !hasBudget && !hasSpent {
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("Budget data is not available on this system: campaign %s's record has no budget or spend fields.", name), Steps: steps})
+	}
+	out := &models.CampaignBudget{CampaignID: campaign.ID, Name: name, Currency: currency}
+	if hasBudget {
+		out.Budget = &budget
+	}
+	if hasSpent {
+		out.Spent = &spent
+	}
+	data := &models.ChatData{CampaignBudget: out}
+	switch {
+	case !hasSpent:
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("Campaign %s has a budget of %s, but its spend is not reported on this system, so the remaining budget can't be computed.", name, formatMoney(budget, currency)), Data: data, Steps: steps})
+	case !hasBudget:
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("Campaign %s has spent %s. No budget is on record, so the remaining budget can't be computed.", name, formatMoney(spent, currency)), Data: data, Steps: steps})
+	}
+
+	remaining := budget - spent
+	out.Remaining = &remaining
+	lines := make([]string, 0, 3)
+	if budget > 0 {
+		consumed := math.Round(spent/budget*1000) / 10
+		out.ConsumedPercent = &consumed
+		lines = append(lines, fmt.Sprintf("Campaign %s has %s of its %s budget left: %s spent (%s consumed).", name, formatMoney(remaining, currency), formatMoney(budget, currency), formatMoney(spent, currency), formatPercent(consumed)))
+	} else {
+		lines = append(lines, fmt.Sprintf("Campaign %s has a budget of %s and has spent %s.", name, formatMoney(budget, currency), formatMoney(spent, currency)))
+	}
+	if remaining < 0 {
+		lines = append(lines, fmt.Sprintf("Spend is over budget by %s.", formatMoney(-remaining, currency)))
+	}
+
+	start, hasStart := flightDate(record, false, "start_date", "flight_start", "starts_at", "start_at")
+	end, hasEnd := flightDate(record, true, "end_date", "flight_end", "ends_at", "end_at")
+	if budget > 0 && hasStart && hasEnd && end.After(start) {
+		// Pacing in cents reuses the impressions pacing arithmetic.
+		p := computePacing(int64(math.Round(budget*100)), int64(math.Round(spent*100)), start, end, time.Now().UTC())
+		out.FlightStart, out.FlightEnd = &start, &end
+		flight := fmt.Sprintf("%s to %s", start.Format("2006-01-02"), end.Add(-time.Second).Format("2006-01-02"))
+		switch p.Status {
+		case "not_started":
+			out.Pacing = "not_started"
+			lines = append(lines, fmt.Sprintf("The flight (%s) has not started yet.", flight))
+		case "ended":
+			out.Pacing = "ended"
+			lines = append(lines, fmt.Sprintf("The flight (%s) has ended.", flight))
+		default:
+			expected := float64(p.ExpectedToDate) / 100
+			pct := p.PacingPercent
+			out.ExpectedSpend, out.PacingPercent = &expected, &pct
+			out.Pacing = "on_track"
+			verdict := "on pace"
+			if pct < 95 {
+				out.Pacing, verdict = "behind", "behind pace"
+			} else if pct > 110 {
+				out.Pacing, verdict = "ahead", "ahead of pace"
+			}
+			lines = append(lines, fmt.Sprintf("Spend is %s: %s spent vs %s expected by now on a linear plan (%s); flight %s, %d days remaining.", verdict, formatMoney(spent, currency), formatMoney(expected, currency), formatPercent(pct), flight, p.DaysRemaining))
+		}
+	}
+	return reply(models.ChatResponse{Answer: strings.Join(lines, "\n"), Data: data, Steps: steps})
+}
@@ -0,0 +1,426 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// campaignDraft is the pending spec of a campaign being created through chat.
+// Awaiting names the slot the last question asked for, or "confirm" once the
+// summary has been shown.
+type campaignDraft struct {
+	Name           string
+	AdvertiserID   string
+	AdvertiserName string
+	StartDate      time.Time
+	EndDate        time.Time
+	Awaiting       string
+}
+
+func (d *campaignDraft) nextSlot() string {
+	switch {
+	case d.Name == "":
+		return "name"
+	case d.AdvertiserID == "":
+		return "advertiser"
+	case d.StartDate.IsZero():
+		return "start"
+	case d.EndDate.IsZero():
+		return "end"
+	}
+	return "confirm"
+}
+
+func isCampaignCreateIntent(msgLower string) bool {
+	if !strings.Contains(msgLower, "campaign") || strings.Contains(msgLower, "creative") {
+		return false
+	}
+	for _, k := range []string{"create a campaign", "create campaign", "create new campaign", "create a new campaign", "new campaign", "set up a campaign", "setup a campaign", "add a campaign"} {
+		if strings.Contains(msgLower, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func isCancelReply(msgLower string) bool {
+	switch strings.Trim(strings.TrimSpace(msgLower), ".!") {
+	case "cancel", "abort", "stop", "never mind", "nevermind", "forget it", "cancel it", "cancel that":
+		return true
+	}
+	return false
+}
+
+func isConfirmReply(msgLower string) bool {
+	switch strings.Trim(strings.TrimSpace(msgLower), ".!") {
+	case "confirm", "confirmed", "yes", "y", "yes, create it", "create it", "go ahead", "do it":
+		return true
+	}
+	return false
+}
+
+var flightDateRe = regexp.MustCompile(`\b(\d{4}-\d{2}-\d{2}|(?:jan|feb|mar|apr|may|jun|jul|aug|sep|sept|oct|nov|dec)[a-z]*\.?\s+\d{1,2}(?:st|nd|rd|th)?(?:,?\s+\d{4})?|\d{1,2}(?:st|nd|rd|th)?\s+(?:jan|feb|mar|apr|may|jun|jul|aug|sep|sept|oct|nov|dec)[a-z]*\.?(?:,?\s+\d{4})?)\b`)
+
+var ordinalSuffixRe = regexp.MustCompile(`(\d)(?:st|nd|rd|th)\b`)
+
+// parseFlightDate parses "2026-12-01", "Dec 1", "December 1st, 2026" or
+// "1 Dec". Dates without a year take the next occurrence on or after now.
+func parseFlightDate(s string, now time.Time) (time.Time, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = ordinalSuffixRe.ReplaceAllString(s, "$1")
+	s = strings.Join(strings.Fields(strings.NewReplacer(",", " ", ".", " ").Replace(s)), " ")
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, true
+	}
+	parts := strings.Fields(s)
+	if len(parts) < 2 {
+		return time.Time{}, false
+	}
+	mon, day := parts[0], parts[1]
+	if _, err := strconv.Atoi(parts[0]); err == nil {
+		mon, day = parts[1], parts[0]
+	}
+	month := time.Month(0)
+	for m := time.January; m <= time.December; m++ {
+		if strings.HasPrefix(strings.ToLower(m.String()), mon[:min(3, len(mon))]) {
+			month = m
+			break
+		}
+	}
+	d, err := strconv.Atoi(day)
+	if month == 0 || err != nil || d < 1 || d > 31 {
+		return time.Time{}, false
+	}
+	if len(parts) >= 3 {
+		y, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Date(y, month, d, 0, 0, 0, 0, time.UTC), true
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	t := time.Date(now.Year(), month, d, 0, 0, 0, 0, time.UTC)
+	if t.Before(today) {
+		t = t.AddDate(1, 0, 0)
+	}
+	return t, true
+}
+
+// extractFlightDates returns the dates mentioned in msg in order of
+// appearance.
+func extractFlightDates(msg string, now time.Time) []time.Time {
+	out := make([]time.Time, 0, 2)
+	for _, m := range flightDateRe.FindAllString(strings.ToLower(msg), -1) {
+		if t, ok := parseFlightDate(m, now); ok {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// cutAtAny trims v at the first of the given lower-case separators.
+func cutAtAny(v string, seps ...string) string {
+	lower := strings.ToLower(v)
+	cut := len(v)
+	for _, sep := range seps {
+		if i := strings.Index(lower, sep); i >= 0 && i < cut {
+			cut = i
+		}
+	}
+	return strings.Trim(strings.TrimSpace(v[:cut]), "'\"“”.,!?")
+}
+
+var campaignSpecStops = []string{" for advertiser", " for ", " advertiser ", " running", " runs ", " from ", " starting", " between ", " with ", " ending", " until ", ","}
+
+// parseCampaignSpec fills the draft slots named in msg ("create a campaign
+// called Winter Promo for advertiser Pepsi running Dec 1 to Dec 31"). The
+// advertiser is returned as typed; the caller resolves it.
+func parseCampaignSpec(msg string, d *campaignDraft, now time.Time) (advertiser string) {
+	for _, k := range []string{"called ", "named ", "name it ", "name: ", "name is "} {
+		if v := extractAfterKeywordOriginal(msg, k); v != "" {
+			if name := cutAtAny(v, campaignSpecStops...); name != "" {
+				d.Name = name
+				break
+			}
+		}
+	}
+	for _, k := range []string{"for advertiser ", "advertiser: ", "advertiser is ", "advertiser "} {
+		if v := extractAfterKeywordOriginal(msg, k); v != "" {
+			if a := cutAtAny(v, " running", " runs ", " from ", " starting", " between ", " with ", " ending", " until ", ","); a != "" {
+				advertiser = a
+				break
+			}
+		}
+	}
+	dates := extractFlightDates(msg, now)
+	lower := strings.ToLower(msg)
+	switch {
+	case len(dates) >= 2:
+		d.StartDate, d.EndDate = dates[0], dates[1]
+	case len(dates) == 1 && (strings.Contains(lower, "end") || strings.Contains(lower, "until") || strings.Contains(lower, "through")):
+		d.EndDate = dates[0]
+	case len(dates) == 1:
+		d.StartDate = dates[0]
+	}
+	// An end date given without a year that falls before the start means the
+	// flight crosses into the next year.
+	if !d.StartDate.IsZero() && !d.EndDate.IsZero() && d.EndDate.Before(d.StartDate) && d.EndDate.AddDate(1, 0, 0).After(d.StartDate) && !strings.Contains(lower, strconv.Itoa(d.EndDate.Year())) {
+		d.EndDate = d.EndDate.AddDate(1, 0, 0)
+	}
+	return advertiser
+}
+
+// resolveAdvertiser fuzzy-matches an advertiser name against /ads/advertisers.
+// When the match is not decisive it returns the top candidates instead.
+func (c *ChatService) resolveAdvertiser(ctx context.Context, name string) (campaignCandidate, []campaignCandidate, *models.Step) {
+	status, body, err := c.Gateway.Get(ctx, "/ads/advertisers")
+	step := &models.Step{Tool: "adsAdvertisers", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+		return campaignCandidate{}, nil, step
+	}
+	step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	if status < 200 || status >= 300 {
+		return campaignCandidate{}, nil, step
+	}
+	var parsed map[string]any
+	if json.Unmarshal(body, &parsed) != nil {
+		return campaignCandidate{}, nil, step
+	}
+	rows, _ := parsed["data"].([]any)
+	ranked := rankCampaignCandidates(rows, name)
+	if campaignMatchDecisive(ranked) {
+		return ranked[0], nil, step
+	}
+	if len(ranked) > 5 {
+		ranked = ranked[:5]
+	}
+	return campaignCandidate{}, ranked, step
+}
+
+func campaignSlotQuestion(slot string) string {
+	switch slot {
+	case "name":
+		return "What should the campaign be called?"
+	case "advertiser":
+		return "Which advertiser is it for?"
+	case "start":
+		return "When should it start? (for example: Dec 1 or 2026-12-01)"
+	case "end":
+		return "When should it end? (for example: Dec 31 or 2026-12-31)"
+	}
+	return ""
+}
+
+func (d *campaignDraft) summary() string {
+	return strings.Join([]string{
+		"Ready to create this campaign:",
+		"- Name: " + d.Name,
+		fmt.Sprintf("- Advertiser: %s (%s)", d.AdvertiserName, d.AdvertiserID),
+		"- Start: " + d.StartDate.Format("2006-01-02"),
+		"- End: " + d.EndDate.Format("2006-01-02"),
+		"Reply 'confirm' to create it, or 'cancel' to abort.",
+	}, "\n")
+}
+
+// gatewayErrorMessage pulls a human-readable validation message out of a
+// gateway error body, falling back to the clipped body.
+func gatewayErrorMessage(body []byte) string {
+	var parsed map[string]any
+	if json.Unmarshal(body, &parsed) == nil {
+		parts := make([]string, 0, 2)
+		for _, k := range []string{"message", "error", "detail"} {
+			if s, ok := parsed[k].(string); ok && strings.TrimSpace(s) != "" {
+				parts = append(parts, strings.TrimSpace(s))
+			}
+		}
+		switch v := parsed["errors"].(type) {
+		case []any:
+			for _, e := range v {
+				switch e := e.(type) {
+				case string:
+					parts = append(parts, e)
+				case map[string]any:
+					if s := anyString(e, "message", "error", "detail"); s != "" {
+						if f := anyString(e, "field", "path"); f != "" {
+							s = f + ": " + s
+						}
+						parts = append(parts, s)
+					}
+				}
+			}
+		case map[string]any:
+			for field, e := range v {
+				parts = append(parts, fmt.Sprintf("%s: %v", field, e))
+			}
+		}
+		if len(parts) > 0 {
+			return strings.Join(parts, "; ")
+		}
+	}
+	return clipString(strings.TrimSpace(string(body)), 500)
+}
+
+// handleCampaignCreate runs the guided campaign creation flow: it collects
+// name, advertiser and flight dates one question at a time, shows a summary,
+// and only POSTs /ads/campaigns after an explicit "confirm".
+func (c *ChatService) handleCampaignCreate(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msg := strings.TrimSpace(req.Message)
+	msgLower := strings.ToLower(msg)
+	conversationID := strings.TrimSpace(req.ConversationID)
+	st := c.getConversationState(conversationID)
+	pending := st != nil && st.PendingHandler == "campaignCreate" && st.CampaignDraft != nil
+	if !pending && !isCampaignCreateIntent(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	if readOnlyKey(ctx) {
+		if pending {
+			c.clearPending(conversationID)
+		}
+		return reply(readOnlyRefusal(ctx, "create campaigns"))
+	}
+	if c.Gateway == nil {
+		return reply(models.ChatResponse{Answer: "Tool gateway is not configured."})
+	}
+	if st == nil {
+		return reply(models.ChatResponse{Answer: "Creating a campaign takes a few questions and a confirmation, so it needs a conversation. Start a conversation and ask again."})
+	}
+	if pending && isCancelReply(msgLower) {
+		c.clearPending(conversationID)
+		return reply(models.ChatResponse{Answer: "Campaign creation cancelled; nothing was created."})
+	}
+
+	d := st.CampaignDraft
+	if !pending {
+		d = &campaignDraft{}
+	}
+	steps := make([]models.Step, 0, 2)
+	now := time.Now().UTC()
+
+	if pending && d.Awaiting == "confirm" && isConfirmReply(msgLower) {
+		return reply(c.createCampaign(ctx, req, conversationID, d))
+	}
+
+	before := *d
+	advertiser := parseCampaignSpec(msg, d, now)
+	if pending && advertiser == "" && *d == before {
+		// A bare reply answers the question that was asked.
+		switch d.Awaiting {
+		case "name":
+			d.Name = strings.Trim(msg, "'\"“”.!?")
+		case "advertiser":
+			advertiser = strings.Trim(msg, "'\"“”.!?")
+		case "start", "end":
+			if dates := extractFlightDates(msg, now); len(dates) > 0 {
+				if d.Awaiting == "start" {
+					d.StartDate = dates[0]
+				} else {
+					d.EndDate = dates[0]
+				}
+			} else {
+				return reply(models.ChatResponse{Answer: "I couldn't read that as a date. " + campaignSlotQuestion(d.Awaiting) + " (or reply 'cancel')"})
+			}
+		case "confirm":
+			return reply(models.ChatResponse{Answer: "Reply 'confirm' to create the campaign, 'cancel' to abort, or tell me what to change (for example: end Jan 15)."})
+		}
+	}
+	if advertiser != "" {
+		best, options, step := c.resolveAdvertiser(ctx, advertiser)
+		if step != nil {
+			steps = append(steps, *step)
+		}
+		if best.ID == "" {
+			d.Awaiting = "advertiser"
+			c.setPendingCampaignDraft(conversationID, msg, d)
+			lines := []string{fmt.Sprintf("I couldn't pick a single advertiser for '%s'.", advertiser)}
+			if len(options) > 0 {
+				lines = append(lines, "Did you mean one of these?")
+				for i, o := range options {
+					lines = append(lines, fmt.Sprintf("%d. %s", i+1, o.Name))
+				}
+			}
+			lines = append(lines, "Which advertiser is it for?")
+			return reply(models.ChatResponse{Answer: strings.Join(lines, "\n"), Steps: steps})
+		}
+		d.AdvertiserID, d.AdvertiserName = best.ID, best.Name
+	}
+	if !d.StartDate.IsZero() && !d.EndDate.IsZero() && !d.EndDate.After(d.StartDate) {
+		d.EndDate = time.Time{}
+		d.Awaiting = "end"
+		c.setPendingCampaignDraft(conversationID, msg, d)
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("The end date must be after the start date (%s). When should it end?", d.StartDate.Format("2006-01-02")), Steps: steps})
+	}
+
+	d.Awaiting = d.nextSlot()
+	c.setPendingCampaignDraft(conversationID, msg, d)
+	if d.Awaiting == "confirm" {
+		return reply(models.ChatResponse{Answer: d.summary(), Steps: steps})
+	}
+	return reply(models.ChatResponse{Answer: campaignSlotQuestion(d.Awaiting), Steps: steps})
+}
+
+// createCampaign POSTs a confirmed draft. On success the new id becomes the
+// conversation's current campaign so follow-ups like "upload creatives to it"
+// target it.
+func (c *ChatService) createCampaign(ctx context.Context, req models.ChatRequest, conversationID string, d *campaignDraft) models.ChatResponse {
+	body := map[string]any{
+		"name":          d.Name,
+		"advertiser_id": d.AdvertiserID,
+		"start_date":    d.StartDate.Format("2006-01-02"),
+		"end_date":      d.EndDate.Format("2006-01-02"),
+	}
+	if c.Catalog != nil && !c.Catalog.IsAllowed(ctx, "POST", "/ads/campaigns") {
+		c.clearPending(conversationID)
+		return models.ChatResponse{Answer: "Creating campaigns is not allowed by the tool gateway's catalog; nothing was created."}
+	}
+	if c.isDryRun(req) {
+		c.clearPending(conversationID)
+		step := dryRunStep("adsCampaignsCreate", "POST", "/ads/campaigns", nil, body, nil)
+		return models.ChatResponse{Answer: fmt.Sprintf("Dry run: no changes were made. Would create campaign '%s' for %s (%s to %s) via POST /ads/campaigns.", d.Name, d.AdvertiserName, body["start_date"], body["end_date"]), Steps: []models.Step{step}}
+	}
+	if err := c.checkIdempotencyClaim(ctx); err != nil {
+		// The draft stays pending so a retry with a fresh key can confirm it.
+		return models.ChatResponse{Answer: "Campaign not created: " + err.Error() + "."}
+	}
+	status, respBody, err := c.Gateway.DoJSON(ctx, "POST", "/ads/campaigns", nil, body)
+	step := models.Step{Tool: "adsCampaignsCreate", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+	} else {
+		step.Body = c.clipStep(strings.TrimSpace(string(respBody)))
+	}
+	steps := []models.Step{step}
+	if err != nil {
+		// Keep the draft so "confirm" can be retried.
+		return models.ChatResponse{Answer: "Campaign creation failed: " + err.Error() + ". Reply 'confirm' to retry or 'cancel' to abort.", Steps: steps}
+	}
+	if status < 200 || status >= 300 {
+		return models.ChatResponse{Answer: fmt.Sprintf("The gateway rejected the campaign (status %d): %s\nTell me what to change, reply 'confirm' to retry, or 'cancel' to abort.", status, gatewayErrorMessage(respBody)), Steps: steps}
+	}
+	c.clearPending(conversationID)
+	var parsed map[string]any
+	_ = json.Unmarshal(respBody, &parsed)
+	created := parsed
+	if data, ok := parsed["data"].(map[string]any); ok {
+		created = data
+	}
+	id := anyString(created, "id", "campaign_id")
+	if id == "" {
+		return models.ChatResponse{Answer: fmt.Sprintf("Created campaign '%s', but the gateway did not return its id.", d.Name), Steps: steps}
+	}
+	c.updateConversationCampaignID(conversationID, id)
+	return models.ChatResponse{Answer: fmt.Sprintf("Created campaign '%s' (%s) for %s, running %s to %s. You can now upload creatives to it.", d.Name, id, d.AdvertiserName, body["start_date"], body["end_date"]), Steps: steps}
+}
@@ -0,0 +1,297 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// CampaignDeviceSnapshotStore keeps the last seen device assignment of each
+// campaign; implemented by store.PostgresStore.
+type CampaignDeviceSnapshotStore interface {
+	LatestCampaignDeviceSnapshot(ctx context.Context, campaignID string) (models.CampaignDeviceSnapshot, bool, error)
+	PutCampaignDeviceSnapshot(ctx context.Context, snap models.CampaignDeviceSnapshot) error
+}
+
+// maxDiffPosters caps the posters whose plays the POP-based comparison
+// fetches.
+const maxDiffPosters = 20
+
+var (
+	campaignDiffChangeRe = regexp.MustCompile(`\b(?:added|removed|dropped|taken off|targeting changes?|assignment changes?)\b`)
+	campaignDiffDeviceRe = regexp.MustCompile(`\b(?:devices?|kiosks?|hosts?|screens?|targeting)\b`)
+)
+
+func isCampaignDeviceDiffIntent(msgLower string) bool {
+	return strings.Contains(msgLower, "campaign") && campaignDiffChangeRe.MatchString(msgLower) && campaignDiffDeviceRe.MatchString(msgLower)
+}
+
+// diffHosts returns the hosts in cur but not prev and those in prev but not
+// cur, each sorted.
+func diffHosts(prev, cur []string) (added, removed []string) {
+	before := make(map[string]bool, len(prev))
+	for _, h := range prev {
+		before[h] = true
+	}
+	now := make(map[string]bool, len(cur))
+	for _, h := range cur {
+		now[h] = true
+		if !before[h] {
+			added = append(added, h)
+		}
+	}
+	for _, h := range prev {
+		if !now[h] {
+			removed = append(removed, h)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// campaignDeviceHosts reads the hosts of one page of a campaign's device
+// listing; rows are device objects or plain host names.
+func campaignDeviceHosts(root map[string]any) []string {
+	rows, _ := root["data"].([]any)
+	if rows == nil {
+		rows, _ = root["devices"].([]any)
+	}
+	out := make([]string, 0, len(rows))
+	for _, it := range rows {
+		var h string
+		switch v := it.(type) {
+		case string:
+			h = v
+		case map[string]any:
+			for _, k := range []string{"host_name", "host", "hostname"} {
+				if s, ok := v[k].(string); ok && strings.TrimSpace(s) != "" {
+					h = s
+					break
+				}
+			}
+		}
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// fetchCampaignDevices pages /ads/campaigns/{id}/devices. found is false when
+// the gateway does not know the endpoint (404), so callers can fall back.
+func (c *ChatService) fetchCampaignDevices(ctx context.Context, campaignID string) (hosts []string, steps []models.Step, found bool, err error) {
+	pageSize := c.limits().ListPageSize
+	seen := map[string]bool{}
+	var fetched int64
+	for page := 1; page <= c.limits().MetricsMaxPages; page++ {
+		path := fmt.Sprintf("/ads/campaigns/%s/devices?page=%d&page_size=%d", urlEscape(campaignID), page, pageSize)
+		status, body, gerr := c.Gateway.Get(ctx, path)
+		step := models.Step{Tool: "adsCampaignDevices", CampaignID: campaignID, Status: status}
+		if gerr != nil {
+			step.Error = gerr.Error()
+			return nil, append(steps, step), true, gerr
+		}
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		steps = append(steps, step)
+		if status == 404 && page == 1 {
+			return nil, steps, false, nil
+		}
+		if status < 200 || status >= 300 {
+			return nil, steps, true, fmt.Errorf("status %d", status)
+		}
+		var root map[string]any
+		if json.Unmarshal(body, &root) != nil {
+			return nil, steps, true, fmt.Errorf("campaign devices response could not be parsed")
+		}
+		rows := campaignDeviceHosts(root)
+		fetched += int64(len(rows))
+		for _, h := range rows {
+			if !seen[h] {
+				seen[h] = true
+				hosts = append(hosts, h)
+			}
+		}
+		if paginationFrom(root).done(len(rows), pageSize, fetched) {
+			break
+		}
+	}
+	sort.Strings(hosts)
+	return hosts, steps, true, nil
+}
+
+// handleCampaignDeviceDiff answers "which devices were added or removed from
+// campaign X": it compares the campaign's device assignment with the snapshot
+// stored by the previous run and then replaces the snapshot. When the
+// gateway has no assignment endpoint (or snapshots are not configured) it
+// compares the hosts that played the campaign's posters in the recent window
+// with the window before.
+func (c *ChatService) handleCampaignDeviceDiff(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(strings.TrimSpace(req.Message))
+	if !isCampaignDeviceDiffIntent(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	if c.Gateway == nil {
+		return reply(models.ChatResponse{Answer: "Tool gateway is not configured."})
+	}
+
+	campaign, query, ok := campaignCandidate{}, "", false
+	if q := campaignNameQuery(msgLower); q != "" {
+		campaign, query, ok = c.resolveCampaign(ctx, q)
+	}
+	if !ok {
+		if query != "" {
+			return reply(models.ChatResponse{Answer: fmt.Sprintf("I couldn't find a single campaign matching '%s'. Please give the campaign name or id.", query)})
+		}
+		if st := c.getConversationState(req.ConversationID); st != nil && st.CampaignID != "" {
+			campaign = campaignCandidate{ID: st.CampaignID}
+		} else {
+			return reply(models.ChatResponse{Answer: "Which campaign? For example: \"which devices were added to campaign Spring Sale this week\"."})
+		}
+	}
+	c.updateConversationCampaignID(req.ConversationID, campaign.ID)
+	label := campaign.ID
+	if campaign.Name != "" {
+		label = campaign.Name
+	}
+
+	endpoint := "/ads/campaigns/" + campaign.ID + "/devices"
+	if c.DeviceSnapshots == nil || (c.Catalog != nil && !c.Catalog.IsAllowed(ctx, "GET", endpoint)) {
+		return reply(c.campaignPlayDiff(ctx, req, campaign.ID, label, nil))
+	}
+	hosts, steps, found, err := c.fetchCampaignDevices(ctx, campaign.ID)
+	if !found {
+		return reply(c.campaignPlayDiff(ctx, req, campaign.ID, label, steps))
+	}
+	if err != nil {
+		return reply(models.ChatResponse{Answer: "Failed to fetch the campaign's devices: " + err.Error(), Steps: steps})
+	}
+
+	prev, had, err := c.DeviceSnapshots.LatestCampaignDeviceSnapshot(ctx, campaign.ID)
+	if err != nil {
+		return reply(models.ChatResponse{Answer: "Failed to load the previous device snapshot: " + err.Error(), Steps: steps})
+	}
+	now := time.Now()
+	if err := c.DeviceSnapshots.PutCampaignDeviceSnapshot(ctx, models.CampaignDeviceSnapshot{CampaignID: campaign.ID, Hosts: hosts, TakenAt: now}); err != nil {
+		log.Printf("campaign device snapshot: save failed campaign_id=%s: %v", campaign.ID, err)
+	}
+	stamp := func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 UTC") }
+	if !had {
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("Baseline recorded for campaign %s: %d devices assigned as of %s. Ask again later to see which devices were added or removed.", label, len(hosts), stamp(now)), Steps: steps})
+	}
+
+	added, removed := diffHosts(prev.Hosts, hosts)
+	if len(added) == 0 && len(removed) == 0 {
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("No devices were added to or removed from campaign %s between %s and %s; %d devices are assigned.", label, stamp(prev.TakenAt), stamp(now), len(hosts)), Steps: steps})
+	}
+	names, metaSteps := c.deviceLabels(ctx, "", "", append(append([]string{}, added...), removed...))
+	steps = append(steps, metaSteps...)
+	lines := []string{fmt.Sprintf("Device targeting changes for campaign %s between %s and %s (%d devices before, %d now):", label, stamp(prev.TakenAt), stamp(now), len(prev.Hosts), len(hosts))}
+	lines = append(lines, c.hostChangeLines("Added", added, names)...)
+	lines = append(lines, c.hostChangeLines("Removed", removed, names)...)
+	return reply(models.ChatResponse{Answer: strings.Join(lines, "\n"), Steps: steps})
+}
+
+// hostChangeLines lists hosts under a "<title> (n):" heading, capped at
+// DisplayTopN. It returns nothing for an empty list.
+func (c *ChatService) hostChangeLines(title string, hosts []string, names map[string]string) []string {
+	if len(hosts) == 0 {
+		return nil
+	}
+	limit := c.limits().DisplayTopN
+	lines := []string{fmt.Sprintf("%s (%d):", title, len(hosts))}
+	for i, h := range hosts {
+		if i >= limit {
+			lines = append(lines, fmt.Sprintf("…and %d more.", len(hosts)-limit))
+			break
+		}
+		l := names[h]
+		if l == "" {
+			l = h
+		}
+		lines = append(lines, "- "+l)
+	}
+	return lines
+}
+
+// campaignPlayDiff is the fallback of handleCampaignDeviceDiff: kiosks that
+// started or stopped playing the campaign's posters, recent window vs the
+// one before (the last 7 days unless the message gives a range).
+func (c *ChatService) campaignPlayDiff(ctx context.Context, req models.ChatRequest, campaignID, label string, steps []models.Step) models.ChatResponse {
+	posterIDs, step := c.campaignPosterIDs(ctx, campaignID)
+	if step != nil {
+		steps = append(steps, *step)
+	}
+	if len(posterIDs) == 0 {
+		return models.ChatResponse{Answer: fmt.Sprintf("The gateway does not expose campaign device assignments, and no posters were found for campaign %s to compare plays.", label), Steps: steps}
+	}
+	capped := len(posterIDs) > maxDiffPosters
+	if capped {
+		posterIDs = posterIDs[:maxDiffPosters]
+	}
+
+	earlierFrom, earlierTo, recentFrom, recentTo := churnWindows(req.Message, 7, time.Now())
+	var pager *popPager
+	windowHosts := func(from, to time.Time) ([]string, error) {
+		window := "&from=" + urlEscape(from.Format(time.RFC3339)) + "&to=" + urlEscape(to.Format(time.RFC3339))
+		seen := map[string]bool{}
+		var hosts []string
+		for _, id := range posterIDs {
+			rows, popSteps, p, err := c.fetchPopRows(ctx, "poster_id="+urlEscape(id)+window)
+			steps = append(steps, popSteps...)
+			if pager == nil || (p != nil && p.Truncated) {
+				pager = p
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range rows {
+				if h := strings.ToLower(strings.TrimSpace(r.HostName)); h != "" && r.PlayCount > 0 && !seen[h] {
+					seen[h] = true
+					hosts = append(hosts, h)
+				}
+			}
+		}
+		return hosts, nil
+	}
+	before, err := windowHosts(earlierFrom, earlierTo)
+	if err != nil {
+		return models.ChatResponse{Answer: "Failed to fetch POP data for the earlier window: " + err.Error(), Steps: steps}
+	}
+	after, err := windowHosts(recentFrom, recentTo)
+	if err != nil {
+		return models.ChatResponse{Answer: "Failed to fetch POP data for the recent window: " + err.Error(), Steps: steps}
+	}
+
+	dayLabel := func(from, to time.Time) string {
+		return from.Format("Jan 2") + "–" + to.Add(-time.Second).Format("Jan 2")
+	}
+	lines := []string{fmt.Sprintf("The gateway does not expose campaign device assignments, so this comparison is play-based: kiosks that played campaign %s's posters in %s vs %s (%d kiosks before, %d now).",
+		label, dayLabel(recentFrom, recentTo), dayLabel(earlierFrom, earlierTo), len(before), len(after))}
+	added, removed := diffHosts(before, after)
+	if len(added) == 0 && len(removed) == 0 {
+		lines = append(lines, "No kiosks started or stopped playing the campaign.")
+	} else {
+		names, metaSteps := c.deviceLabels(ctx, "", "", append(append([]string{}, added...), removed...))
+		steps = append(steps, metaSteps...)
+		lines = append(lines, c.hostChangeLines("Started playing", added, names)...)
+		lines = append(lines, c.hostChangeLines("Stopped playing", removed, names)...)
+	}
+	if capped {
+		lines = append(lines, fmt.Sprintf("(Compared the plays of the campaign's first %d posters.)", maxDiffPosters))
+	}
+	return models.ChatResponse{Answer: pager.note(strings.Join(lines, "\n")), Steps: steps, Meta: pager.meta()}
+}
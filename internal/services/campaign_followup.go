@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// campaignFollowUpMaxWords bounds the follow-ups answered for the remembered
+// campaign; longer messages are full questions and go through the handlers.
+const campaignFollowUpMaxWords = 8
+
+var (
+	// A follow-up must be about a campaign; the keyword decides which
+	// handler answers it.
+	campaignFollowUpCreativeRe   = regexp.MustCompile(`\bcreatives?\b`)
+	campaignFollowUpImpressionRe = regexp.MustCompile(`\bimpressions?\b`)
+	campaignFollowUpPacingRe     = regexp.MustCompile(`\b(?:pacing|on[\s-]track)\b`)
+	campaignFollowUpStatusRe     = regexp.MustCompile(`\b(?:status|paused?|active|running|live|ended)\b`)
+	// "this campaign's creatives" refers to the remembered campaign; any
+	// other "campaign" names one.
+	campaignFollowUpRefRe = regexp.MustCompile(`\b(?:this|that|the|same|our)\s+campaign(?:'s|s)?\b`)
+	// Words that name another kind of entity, which the regular handlers
+	// resolve instead.
+	campaignFollowUpOtherRe = regexp.MustCompile(`\b(?:posters?|kiosks?|devices?|hosts?|screens?|venues?|city|region|campaigns?)\b`)
+)
+
+// campaignFollowUpKind is the handler a follow-up goes to: "creatives",
+// "impressions", "budget", "pacing" or "status"; empty when the message has
+// no campaign keyword.
+func campaignFollowUpKind(msgLower string) string {
+	switch {
+	case campaignFollowUpCreativeRe.MatchString(msgLower):
+		return "creatives"
+	case campaignFollowUpImpressionRe.MatchString(msgLower):
+		return "impressions"
+	case isCampaignBudgetIntent(msgLower):
+		return "budget"
+	case campaignFollowUpPacingRe.MatchString(msgLower):
+		return "pacing"
+	case campaignFollowUpStatusRe.MatchString(msgLower):
+		return "status"
+	}
+	return ""
+}
+
+// campaignFollowUp reports whether msg is a short follow-up about the
+// remembered campaign ("its creatives", "impressions last week", "pause
+// status?") and which kind. Messages naming a campaign, poster, device,
+// venue, city or region are left to the handlers that resolve them.
+func (c *ChatService) campaignFollowUp(ctx context.Context, msg string) (string, bool) {
+	msgLower := strings.ToLower(strings.TrimSpace(msg))
+	if len(strings.Fields(msgLower)) > campaignFollowUpMaxWords {
+		return "", false
+	}
+	kind := campaignFollowUpKind(msgLower)
+	if kind == "" {
+		return "", false
+	}
+	rest := campaignFollowUpRefRe.ReplaceAllString(msgLower, " ")
+	if campaignFollowUpOtherRe.MatchString(rest) || looksLikeUUID(extractCampaignID(rest)) || len(detectHostTokens(msg)) > 0 {
+		return "", false
+	}
+	if c.detectCityCode(ctx, msgLower) != "" || c.detectRegionCode(ctx, msgLower) != "" {
+		return "", false
+	}
+	return kind, true
+}
+
+// handleCampaignFollowUp answers short campaign follow-ups for the campaign
+// the conversation remembers, without the message naming it again, and says
+// which campaign was assumed.
+func (c *ChatService) handleCampaignFollowUp(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	conversationID := strings.TrimSpace(req.ConversationID)
+	if conversationID == "" {
+		return models.ChatResponse{}, false, nil
+	}
+	st := c.getConversationState(conversationID)
+	if st == nil || !looksLikeUUID(st.CampaignID) {
+		return models.ChatResponse{}, false, nil
+	}
+	kind, ok := c.campaignFollowUp(ctx, req.Message)
+	if !ok {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+	campaignID := st.CampaignID
+	record, step, failure := c.fetchCampaign(ctx, campaignID)
+	name := anyString(record, "name", "campaign_name")
+	if name == "" {
+		name = campaignID
+	}
+
+	var (
+		resp    models.ChatResponse
+		handled bool
+		err     error
+	)
+	req2 := req
+	switch kind {
+	case "creatives":
+		req2.Message = "show " + strings.TrimSpace(req.Message) + " for campaign " + campaignID
+		resp, handled, err = c.handleCampaignCreatives(ctx, req2, nil)
+	case "impressions":
+		req2.Message = "impressions for campaign " + campaignID
+		resp, handled, err = c.handleCampaignImpressions(ctx, req2, nil)
+		// Campaign impressions are lifetime totals; the gateway has no
+		// per-period figure to narrow them to.
+		if _, _, label, ok := exportWindow(req.Message, time.Now(), requestLocation(req)); ok && handled {
+			resp.Answer += fmt.Sprintf("\nThese are lifetime impressions; campaign impressions are not available for %s.", label)
+		}
+	case "budget":
+		req2.Message = "how much budget is left on campaign " + campaignID
+		resp, handled, err = c.handleCampaignBudget(ctx, req2, nil)
+	case "pacing":
+		req2.Message = "is campaign " + campaignID + " on track"
+		resp, handled, err = c.handleCampaignPacing(ctx, req2, nil)
+	default:
+		resp, handled = campaignStatusAnswer(record, name, failure, time.Now(), requestLocation(req)), true
+	}
+	if !handled {
+		return models.ChatResponse{}, false, nil
+	}
+	resp.Steps = append([]models.Step{step}, resp.Steps...)
+	resp.Answer = fmt.Sprintf("Assuming campaign %s from earlier in this conversation; name another campaign to ask about it instead.\n", name) + resp.Answer
+	if onToken != nil {
+		onToken(resp.Answer)
+	}
+	return resp, true, err
+}
+
+// campaignStatusAnswer states a campaign's status and flight from its record.
+func campaignStatusAnswer(record map[string]any, name, failure string, now time.Time, loc *time.Location) models.ChatResponse {
+	if failure != "" {
+		return models.ChatResponse{Answer: failure}
+	}
+	status, fromDates := effectiveCampaignStatus(record, "", now, loc)
+	if status == "unknown" {
+		return models.ChatResponse{Answer: fmt.Sprintf("Campaign %s has no status or flight dates on record.", name)}
+	}
+	answer := fmt.Sprintf("Campaign %s is %s.", name, campaignStatusLabel(status, fromDates))
+	start, hasStart := campaignDay(record, loc, "start_date", "flight_start", "starts_at", "start_at")
+	end, hasEnd := campaignDay(record, loc, "end_date", "flight_end", "ends_at", "end_at")
+	if hasStart && hasEnd {
+		answer += fmt.Sprintf(" Flight: %s to %s.", start.Format("2006-01-02"), end.Format("2006-01-02"))
+	}
+	return models.ChatResponse{Answer: answer}
+}
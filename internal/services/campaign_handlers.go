@@ -0,0 +1,2934 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"openai-agent-service/internal/models"
+)
+
+func (c *ChatService) handleCampaignCreatives(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msg := strings.TrimSpace(req.Message)
+	msgLower := strings.ToLower(msg)
+	if msg == "" {
+		return models.ChatResponse{}, false, nil
+	}
+	if !(strings.Contains(msgLower, "creative") || strings.Contains(msgLower, "creatives")) {
+		return models.ChatResponse{}, false, nil
+	}
+	if !(strings.Contains(msgLower, "campaign") || strings.Contains(msgLower, "campaigns")) {
+		return models.ChatResponse{}, false, nil
+	}
+	if strings.Contains(msgLower, "upload") {
+		return models.ChatResponse{}, false, nil
+	}
+	if !(strings.Contains(msgLower, "show") || strings.Contains(msgLower, "list") || strings.Contains(msgLower, "get")) && creativeStatusFilter(msgLower) == "" {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+
+	campaignID := ""
+	if strings.Contains(msgLower, "campaign") {
+		campaignID = extractCampaignID(msg)
+	}
+
+	// Parse a campaign name from phrases like:
+	// "show Bet 365 campaign creatives"
+	// "list creatives for Bet 365 campaign"
+	campaignName := ""
+	if campaignID == "" {
+		if strings.Contains(msgLower, "campaign") {
+			beforeCampaign := strings.TrimSpace(strings.SplitN(msg, "campaign", 2)[0])
+			beforeCampaignLower := creativeStatusWordsRe.ReplaceAllString(strings.ToLower(beforeCampaign), " ")
+			for _, w := range []string{"show", "list", "get", "me", "the", "all", "for", "of", "creatives", "creative"} {
+				beforeCampaignLower = strings.ReplaceAll(beforeCampaignLower, w, " ")
+			}
+			campaignName = strings.TrimSpace(strings.Join(strings.Fields(beforeCampaignLower), " "))
+		}
+		if campaignName == "" {
+			// Fallback: try to extract text between "for" and "campaign".
+			if strings.Contains(msgLower, " for ") && strings.Contains(msgLower, "campaign") {
+				parts := strings.SplitN(msgLower, " for ", 2)
+				if len(parts) == 2 {
+					candidate := strings.TrimSpace(strings.SplitN(parts[1], "campaign", 2)[0])
+					campaignName = strings.TrimSpace(candidate)
+				}
+			}
+		}
+	}
+
+	steps := make([]models.Step, 0, 2)
+	fuzzyNote := ""
+	if campaignID == "" {
+		if strings.TrimSpace(campaignName) == "" {
+			return models.ChatResponse{Answer: "Please specify a campaign name (for example: show Bet 365 campaign creatives) or provide a campaign id."}, true, nil
+		}
+		status, body, err := c.Gateway.Get(ctx, "/ads/campaigns/search?query="+urlEscape(campaignName)+"&page=1&page_size=20")
+		step := models.Step{Tool: "adsCampaignsSearch", Status: status}
+		if err != nil {
+			step.Error = err.Error()
+		} else {
+			step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		}
+		steps = append(steps, step)
+		if err != nil {
+			return models.ChatResponse{Answer: "Failed to search campaigns: " + err.Error(), Steps: steps}, true, nil
+		}
+		if status < 200 || status >= 300 {
+			return models.ChatResponse{Answer: fmt.Sprintf("Failed to search campaigns (status %d).", status), Steps: steps}, true, nil
+		}
+		var parsed map[string]any
+		if json.Unmarshal(body, &parsed) != nil {
+			return models.ChatResponse{Answer: "Campaign search response could not be parsed.", Steps: steps}, true, nil
+		}
+		rows := extractCampaignRows(parsed)
+		ranked := rankCampaignCandidates(rows, campaignName)
+		if len(ranked) == 0 {
+			return models.ChatResponse{Answer: fmt.Sprintf("No campaigns found matching '%s'.", campaignName), Steps: steps}, true, nil
+		}
+		if !campaignMatchDecisive(ranked) {
+			answer := c.campaignClarification(conversationID, req.Message, campaignName, ranked)
+			if onToken != nil {
+				onToken(answer)
+			}
+			return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+		}
+		campaignID = ranked[0].ID
+		if ranked[0].Score < campaignScoreExact {
+			fuzzyNote = fmt.Sprintf("Interpreted '%s' as '%s'.", campaignName, ranked[0].Name)
+		}
+		if conversationID != "" {
+			c.updateConversationCampaignID(conversationID, campaignID)
+		}
+	}
+
+	path := "/ads/creatives/campaign/" + urlEscape(campaignID) + fmt.Sprintf("?page=1&page_size=%d", c.limits().ListPageSize)
+	statusC, bodyC, errC := c.Gateway.Get(ctx, path)
+	stepC := models.Step{Tool: "adsCreativesByCampaign", Status: statusC}
+	if errC != nil {
+		stepC.Error = errC.Error()
+	} else {
+		stepC.Body = c.clipStep(strings.TrimSpace(string(bodyC)))
+	}
+	steps = append(steps, stepC)
+	if errC != nil {
+		return models.ChatResponse{Answer: "Failed to fetch campaign creatives: " + errC.Error(), Steps: steps}, true, nil
+	}
+	if statusC < 200 || statusC >= 300 {
+		return models.ChatResponse{Answer: fmt.Sprintf("Failed to fetch campaign creatives (status %d).", statusC), Steps: steps}, true, nil
+	}
+	rows := parseRows(bodyC)
+	if len(rows) == 0 {
+		return models.ChatResponse{Answer: fmt.Sprintf("No creatives found for campaign %s.", campaignID), Steps: steps}, true, nil
+	}
+
+	limit := c.limits().DisplayTopN
+	if n := extractListLimit(msgLower); n > 0 {
+		limit = n
+	}
+	sortMode := parseListSort(msgLower)
+	sorted := sortListRows(rows, sortMode)
+	creatives := make([]map[string]any, 0, len(rows))
+	for _, it := range rows {
+		if m, ok := it.(map[string]any); ok {
+			creatives = append(creatives, m)
+		}
+	}
+	withStatus := hasCreativeStatus(creatives)
+	summary := creativeStatusSummary(creatives)
+	statusFilter := creativeStatusFilter(msgLower)
+	statusNote := ""
+	switch {
+	case !withStatus && statusFilter != "":
+		statusNote = strings.TrimSuffix(creativeStatusNote, ".") + ", so the list is not filtered by status."
+	case !withStatus:
+		statusNote = creativeStatusNote
+	case statusFilter != "":
+		creatives = filterCreativesByStatus(creatives, statusFilter)
+		if len(creatives) == 0 {
+			answer := fmt.Sprintf("No %s creatives for campaign %s. %s", statusFilter, campaignID, summary)
+			if onToken != nil {
+				onToken(answer)
+			}
+			return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+		}
+	}
+	suffix := ""
+	if limit != c.limits().DisplayTopN || sortMode != "" {
+		suffix = listHeaderSuffix(limit, sortMode, sorted)
+	}
+	header := fmt.Sprintf("Creatives for campaign %s%s:", campaignID, suffix)
+	if withStatus && statusFilter != "" {
+		header = fmt.Sprintf("%s creatives for campaign %s%s:", strings.ToUpper(statusFilter[:1])+statusFilter[1:], campaignID, suffix)
+	}
+	lines := make([]string, 0, limit+3)
+	if summary != "" {
+		lines = append(lines, summary)
+	}
+	lines = append(lines, header)
+	shown := 0
+	for _, m := range creatives {
+		if shown >= limit {
+			break
+		}
+		label := creativeLabel(m, withStatus)
+		if label == "" {
+			continue
+		}
+		shown++
+		lines = append(lines, fmt.Sprintf("%d. %s", shown, label))
+	}
+	if statusNote != "" {
+		lines = append(lines, statusNote)
+	}
+	answer := strings.Join(lines, "\n")
+	if fuzzyNote != "" {
+		answer = fuzzyNote + "\n" + answer
+	}
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+}
+
+func isListCampaignsIntent(msgLower string) bool {
+	s := strings.ToLower(strings.TrimSpace(msgLower))
+	if s == "" {
+		return false
+	}
+	if strings.Contains(s, "list") && strings.Contains(s, "campaign") {
+		return true
+	}
+	if strings.Contains(s, "show") && strings.Contains(s, "campaign") {
+		return true
+	}
+	return false
+}
+
+func (c *ChatService) handleAdvertiserSearchList(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !(strings.Contains(msgLower, "advertiser") || strings.Contains(msgLower, "advertisers")) {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+
+	isSearch := strings.Contains(msgLower, "search") || strings.Contains(msgLower, "find")
+	isList := strings.Contains(msgLower, "list") || strings.Contains(msgLower, "show") || strings.Contains(msgLower, "all")
+	if !(isSearch || isList) {
+		return models.ChatResponse{}, false, nil
+	}
+
+	query := ""
+	if isSearch {
+		query = extractAfterKeyword(msgLower, "advertisers")
+		if query == "" {
+			query = extractAfterKeyword(msgLower, "advertiser")
+		}
+		query = strings.TrimSpace(query)
+		if query == "" {
+			return models.ChatResponse{Answer: "Please provide an advertiser search query (for example: search advertisers Pepsi)."}, true, nil
+		}
+	}
+
+	status, body, err := c.Gateway.Get(ctx, "/ads/advertisers")
+	step := models.Step{Tool: "adsAdvertisers", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+	} else {
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	}
+	steps := []models.Step{step}
+	if err != nil {
+		return models.ChatResponse{Answer: "Failed to fetch advertisers: " + err.Error(), Steps: steps}, true, nil
+	}
+	if status < 200 || status >= 300 {
+		return models.ChatResponse{Answer: fmt.Sprintf("Failed to fetch advertisers (status %d).", status), Steps: steps}, true, nil
+	}
+	var parsed map[string]any
+	if json.Unmarshal(body, &parsed) != nil {
+		return models.ChatResponse{Answer: "Advertisers response could not be parsed.", Steps: steps}, true, nil
+	}
+	rowsAny, _ := parsed["data"].([]any)
+	if len(rowsAny) == 0 {
+		return models.ChatResponse{Answer: "No advertisers found.", Steps: steps}, true, nil
+	}
+
+	// Filter for search.
+	rows := make([]map[string]any, 0, len(rowsAny))
+	qLower := strings.ToLower(query)
+	for _, it := range rowsAny {
+		m, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		name, _ := m["name"].(string)
+		id, _ := m["id"].(string)
+		name = strings.TrimSpace(name)
+		id = strings.TrimSpace(id)
+		if name == "" || id == "" {
+			continue
+		}
+		if isSearch {
+			if !containsFolded(name, qLower) {
+				continue
+			}
+		}
+		rows = append(rows, m)
+	}
+
+	if len(rows) == 0 {
+		return models.ChatResponse{Answer: fmt.Sprintf("No advertisers found for query '%s'.", query), Steps: steps}, true, nil
+	}
+
+	limit := c.limits().DisplayTopN
+	lines := make([]string, 0, limit+1)
+	if isSearch {
+		lines = append(lines, fmt.Sprintf("Advertiser search results for '%s':", query))
+	} else {
+		lines = append(lines, "Advertisers:")
+	}
+	for _, m := range rows {
+		if len(lines)-1 >= limit {
+			break
+		}
+		name, _ := m["name"].(string)
+		id, _ := m["id"].(string)
+		name = strings.TrimSpace(name)
+		id = strings.TrimSpace(id)
+		if name == "" || id == "" {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("- %s (%s)", name, id))
+	}
+	answer := strings.Join(lines, "\n")
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+}
+
+func (c *ChatService) handleCampaignImpressions(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !strings.Contains(msgLower, "impression") {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	campaignID := ""
+	if id := extractCampaignID(req.Message); looksLikeUUID(id) {
+		campaignID = id
+	} else if conversationID != "" {
+		if st := c.getConversationState(conversationID); st != nil {
+			if looksLikeUUID(st.CampaignID) {
+				campaignID = st.CampaignID
+			}
+		}
+	}
+
+	// If no UUID is present, try resolving by campaign name.
+	if !looksLikeUUID(campaignID) {
+		// Prefer extracting the name from "campaign <name>".
+		campaignName := extractAfterKeyword(msgLower, "campaign")
+		if campaignName == "" {
+			campaignName = extractAfterKeyword(msgLower, "campaign:")
+		}
+		campaignName = strings.TrimSpace(campaignName)
+		if campaignName != "" {
+			// First try the search endpoint.
+			statusS, bodyS, errS := c.Gateway.Get(ctx, "/ads/campaigns/search?query="+urlEscape(campaignName)+"&page=1&page_size=10")
+			stepSearch := models.Step{Tool: "adsCampaignsSearch", Status: statusS}
+			if errS != nil {
+				stepSearch.Error = errS.Error()
+			} else {
+				stepSearch.Body = c.clipStep(strings.TrimSpace(string(bodyS)))
+			}
+			if errS == nil && statusS >= 200 && statusS < 300 {
+				var parsed map[string]any
+				if json.Unmarshal(bodyS, &parsed) == nil {
+					rows := extractCampaignRows(parsed)
+					if len(rows) == 1 {
+						if m, ok := rows[0].(map[string]any); ok {
+							id, _ := m["id"].(string)
+							if looksLikeUUID(id) {
+								campaignID = id
+							}
+						}
+					} else if len(rows) > 1 {
+						// Ambiguous; suggest top candidates.
+						sugs := formatCampaignSuggestions(rows, 5)
+						answer := "Multiple campaigns matched. Please specify the campaign id. Suggestions:\n" + strings.Join(sugs, "\n")
+						if onToken != nil {
+							onToken(answer)
+						}
+						return models.ChatResponse{Answer: answer, Steps: []models.Step{stepSearch}}, true, nil
+					}
+				}
+			}
+
+			// Fallback: reuse existing list-based resolver.
+			if !looksLikeUUID(campaignID) {
+				campaignID = c.resolveCampaignID(ctx, msgLower)
+			}
+		}
+	}
+
+	if !looksLikeUUID(campaignID) {
+		return models.ChatResponse{Answer: "Please provide a campaign id (UUID), or ask like: show impressions for campaign <name>."}, true, nil
+	}
+
+	if conversationID != "" {
+		c.updateConversationCampaignID(conversationID, campaignID)
+		c.rememberUnit(conversationID, "impressions")
+		c.clearPending(conversationID)
+	}
+
+	ci := c.fetchCampaignImpressions(ctx, campaignID)
+	steps := ci.Steps
+
+	answer := ""
+	var citations []models.Citation
+	var failures partialFailures
+	if !ci.AdsOK && !ci.PopOK {
+		msg := "Failed to fetch campaign impressions."
+		if ci.AdsErr != nil {
+			msg += " " + ci.AdsErr.Error()
+		}
+		answer = msg
+	} else {
+		// Either source alone still answers: the ads total without the
+		// POP breakdown, or the POP total and breakdown without ads.
+		if !ci.AdsOK {
+			failures.add("the ads impressions total", steps, ci.AdsIdx, "the total is from POP")
+		}
+		if ci.popFailed() {
+			failures.add("the POP poster breakdown", steps, ci.PopIdx, "")
+		}
+		total, totalIdx := ci.total()
+		if ci.AdsOK {
+			answer = fmt.Sprintf("Campaign %s impressions: %s total.", campaignID, formatThousands(total))
+		} else {
+			answer = fmt.Sprintf("Campaign %s impressions (from POP): %s total.", campaignID, formatThousands(total))
+		}
+		citations = cite(citations, formatThousands(total)+" total", 0, totalIdx)
+		if posters := ci.Pop.Posters; len(posters) > 0 {
+			// Show top 5 posters by impressions.
+			lines := make([]string, 0, 6)
+			lines = append(lines, answer)
+			max := 5
+			if len(posters) < max {
+				max = len(posters)
+			}
+			for i := 0; i < max; i++ {
+				p := posters[i]
+				name := strings.TrimSpace(p.PosterName)
+				if name == "" {
+					name = p.PosterID
+				}
+				lines = append(lines, fmt.Sprintf("%d. %s — %s impressions", i+1, name, formatThousands(p.Impressions)))
+				citations = cite(citations, formatThousands(p.Impressions)+" impressions", len(lines)-1, ci.PopIdx)
+			}
+			answer = strings.Join(lines, "\n")
+		}
+		answer = failures.note(answer)
+	}
+
+	if onToken != nil {
+		onToken(answer)
+	}
+	resp := models.ChatResponse{Answer: answer, Steps: steps, Citations: citations, Meta: failures.meta(nil)}
+	resp.Data = ci.data()
+	return resp, true, nil
+}
+
+// campaignImpressions is what a campaign's impressions are answered from,
+// in chat and by the campaign_impressions query: the ads lifetime total and,
+// when the catalog allows it, the POP breakdown by poster.
+type campaignImpressions struct {
+	Steps  []models.Step
+	AdsIdx int
+	// PopIdx is -1 when POP was not asked.
+	PopIdx int
+	AdsOK  bool
+	// PopOK is set when POP answered for the campaign.
+	PopOK   bool
+	AdsErr  error
+	Ads     gwCampaignImpressionsResponse
+	Pop     popCampaignImpressions
+	popSent bool
+}
+
+type popCampaignImpressions struct {
+	CampaignID  string `json:"campaign_id"`
+	Impressions int64  `json:"impressions"`
+	Posters     []struct {
+		PosterID    string `json:"poster_id"`
+		PosterName  string `json:"poster_name"`
+		Impressions int64  `json:"impressions"`
+		PlayTime    int64  `json:"play_time"`
+	} `json:"posters"`
+}
+
+// fetchCampaignImpressions asks ads for the campaign's total and POP for its
+// poster breakdown, highest impressions first.
+func (c *ChatService) fetchCampaignImpressions(ctx context.Context, campaignID string) campaignImpressions {
+	ci := campaignImpressions{Steps: make([]models.Step, 0, 2), PopIdx: -1}
+
+	// Lifetime impressions (POP-backed) from ADS.
+	adsPath := "/ads/campaigns/" + urlEscape(campaignID) + "/impressions"
+	status, body, err := c.Gateway.Get(ctx, adsPath)
+	stepAds := models.Step{Tool: "adsCampaignImpressions", CampaignID: campaignID, Status: status}
+	if err != nil {
+		stepAds.Error = err.Error()
+	} else {
+		stepAds.Body = c.clipStep(strings.TrimSpace(string(body)))
+	}
+	ci.Steps = append(ci.Steps, stepAds)
+	ci.AdsIdx = len(ci.Steps) - 1
+	ci.AdsErr = err
+	ci.AdsOK = err == nil && status >= 200 && status < 300
+	// ADS response is wrapped in {data:...}
+	_ = json.Unmarshal(body, &ci.Ads)
+
+	// Poster breakdown from POP (optional; may not be allowed by tool catalog).
+	if c.Catalog == nil || c.Catalog.IsAllowed(ctx, "GET", "/pop/impressions") {
+		popPath := "/pop/impressions?campaign_id=" + urlEscape(campaignID)
+		status2, body2, err2 := c.Gateway.Get(ctx, popPath)
+		// Some gateway deployments return 403 {"error":"forbidden_path"} even if the OpenAPI spec lists the path.
+		// Treat this as an optional enrichment and don't surface it to the user.
+		if !(err2 == nil && status2 == 403 && strings.Contains(string(body2), "forbidden_path")) {
+			stepPop := models.Step{Tool: "popImpressions", CampaignID: campaignID, Status: status2}
+			if err2 != nil {
+				stepPop.Error = err2.Error()
+			} else {
+				stepPop.Body = c.clipStep(strings.TrimSpace(string(body2)))
+			}
+			ci.Steps = append(ci.Steps, stepPop)
+			ci.PopIdx = len(ci.Steps) - 1
+			ci.popSent = err2 == nil && status2 >= 200 && status2 < 300
+			if len(body2) > 0 {
+				_ = json.Unmarshal(body2, &ci.Pop)
+			}
+		}
+	}
+	ci.PopOK = ci.popSent && ci.Pop.CampaignID != ""
+	sort.Slice(ci.Pop.Posters, func(i, j int) bool { return ci.Pop.Posters[i].Impressions > ci.Pop.Posters[j].Impressions })
+	return ci
+}
+
+// popFailed reports whether the POP breakdown was asked for and failed.
+func (ci campaignImpressions) popFailed() bool {
+	return ci.PopIdx >= 0 && !ci.popSent
+}
+
+// total is the impressions total and the step it came from; POP's is
+// preferred when present.
+func (ci campaignImpressions) total() (int64, int) {
+	if ci.PopOK {
+		return ci.Pop.Impressions, ci.PopIdx
+	}
+	if ci.Ads.Data != nil {
+		return ci.Ads.Data.Impressions, ci.AdsIdx
+	}
+	return 0, ci.AdsIdx
+}
+
+func (ci campaignImpressions) data() *models.ChatData {
+	if !ci.PopOK {
+		return nil
+	}
+	data := &models.ChatData{CampaignImpressions: &models.CampaignImpressions{CampaignID: ci.Pop.CampaignID, Impressions: ci.Pop.Impressions}}
+	if len(ci.Pop.Posters) > 0 {
+		posters := make([]models.PosterImpression, 0, len(ci.Pop.Posters))
+		for _, p := range ci.Pop.Posters {
+			pt := p.PlayTime
+			posters = append(posters, models.PosterImpression{PosterID: p.PosterID, PosterName: p.PosterName, Impressions: p.Impressions, PlayTime: &pt})
+		}
+		data.CampaignImpressions.Posters = posters
+	}
+	return data
+}
+
+func (c *ChatService) handleCampaignSearchList(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !(strings.Contains(msgLower, "campaign") || strings.Contains(msgLower, "campaigns")) {
+		return models.ChatResponse{}, false, nil
+	}
+	// If the user asked for campaign creatives, a dedicated handler should answer.
+	if strings.Contains(msgLower, "creative") {
+		return models.ChatResponse{}, false, nil
+	}
+	// Avoid colliding with impressions handler which has its own deterministic flow.
+	if strings.Contains(msgLower, "impression") {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	isSearch := strings.Contains(msgLower, "search") || strings.Contains(msgLower, "find")
+	isList := strings.Contains(msgLower, "list") || strings.Contains(msgLower, "show") || strings.Contains(msgLower, "all")
+	if !(isSearch || isList) {
+		return models.ChatResponse{}, false, nil
+	}
+
+	statusFilter := extractStatusFilter(msgLower)
+	query := ""
+	if isSearch {
+		// Prefer the plural keyword first so we don't match "campaign" inside "campaigns".
+		query = extractAfterKeyword(msgLower, "campaigns")
+		if query == "" {
+			query = extractAfterKeyword(msgLower, "campaign")
+		}
+		query = strings.TrimSpace(query)
+		if query == "" {
+			return models.ChatResponse{Answer: "Please provide a campaign search query (for example: search campaigns Pepsi)."}, true, nil
+		}
+	}
+
+	limit := c.limits().DisplayTopN
+	if n := extractListLimit(msgLower); n > 0 {
+		limit = n
+	}
+	sortMode := parseListSort(msgLower)
+
+	steps := make([]models.Step, 0, 1)
+	rows := make([]any, 0)
+
+	if isSearch {
+		path := "/ads/campaigns/search?query=" + urlEscape(query) + fmt.Sprintf("&page=1&page_size=%d", max(20, limit))
+		status, body, err := c.Gateway.Get(ctx, path)
+		step := models.Step{Tool: "adsCampaignsSearch", Status: status}
+		if err != nil {
+			step.Error = err.Error()
+		} else {
+			step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		}
+		steps = append(steps, step)
+		if err != nil {
+			return models.ChatResponse{Answer: "Failed to search campaigns: " + err.Error(), Steps: steps}, true, nil
+		}
+		if status < 200 || status >= 300 {
+			return models.ChatResponse{Answer: fmt.Sprintf("Failed to search campaigns (status %d).", status), Steps: steps}, true, nil
+		}
+		var parsed map[string]any
+		if json.Unmarshal(body, &parsed) != nil {
+			return models.ChatResponse{Answer: "Campaign search response could not be parsed.", Steps: steps}, true, nil
+		}
+		rows = extractCampaignRows(parsed)
+	} else {
+		// Page further when the requested N exceeds one page.
+		pageSize := max(50, limit)
+		for page := 1; page <= 5; page++ {
+			path := fmt.Sprintf("/ads/campaigns?page=%d&page_size=%d", page, pageSize)
+			status, body, err := c.Gateway.Get(ctx, path)
+			step := models.Step{Tool: "adsCampaigns", Status: status}
+			if err != nil {
+				step.Error = err.Error()
+			} else {
+				step.Body = c.clipStep(strings.TrimSpace(string(body)))
+			}
+			steps = append(steps, step)
+			if err != nil {
+				return models.ChatResponse{Answer: "Failed to list campaigns: " + err.Error(), Steps: steps}, true, nil
+			}
+			if status < 200 || status >= 300 {
+				return models.ChatResponse{Answer: fmt.Sprintf("Failed to list campaigns (status %d).", status), Steps: steps}, true, nil
+			}
+			var parsed map[string]any
+			if json.Unmarshal(body, &parsed) != nil {
+				return models.ChatResponse{Answer: "Campaign list response could not be parsed.", Steps: steps}, true, nil
+			}
+			pageRows := extractCampaignRows(parsed)
+			rows = append(rows, pageRows...)
+			if len(pageRows) < pageSize || len(rows) >= limit {
+				break
+			}
+		}
+	}
+
+	if len(rows) == 0 {
+		answer := "No campaigns found."
+		if isSearch {
+			answer = fmt.Sprintf("No campaigns found for query '%s'.", query)
+		}
+		return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+	}
+
+	// Apply optional status filter when present. Campaigns with neither a
+	// status nor flight dates can't be placed and are named separately.
+	now, loc := time.Now(), requestLocation(req)
+	unknown := make([]string, 0)
+	if statusFilter != "" {
+		filtered := make([]any, 0, len(rows))
+		for _, it := range rows {
+			m, ok := it.(map[string]any)
+			if !ok {
+				continue
+			}
+			st, _ := effectiveCampaignStatus(m, statusFilter, now, loc)
+			if campaignStatusMatches(statusFilter, st) {
+				filtered = append(filtered, it)
+			} else if st == "unknown" {
+				unknown = append(unknown, firstNonEmpty(anyString(m, "name"), anyString(m, "id")))
+			}
+		}
+		rows = filtered
+		if len(rows) == 0 {
+			answer := fmt.Sprintf("No %s campaigns found.", statusFilter)
+			if len(unknown) > 0 {
+				answer += " " + unknownCampaignsNote(unknown)
+			}
+			return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+		}
+	}
+
+	// If exactly one match, remember it.
+	if len(rows) == 1 && conversationID != "" {
+		if m, ok := rows[0].(map[string]any); ok {
+			id, _ := m["id"].(string)
+			if looksLikeUUID(id) {
+				c.updateConversationCampaignID(conversationID, id)
+			}
+		}
+	}
+
+	sorted := sortListRows(rows, sortMode)
+	suffix := ""
+	if limit != c.limits().DisplayTopN || sortMode != "" {
+		suffix = listHeaderSuffix(limit, sortMode, sorted)
+	}
+	lines := make([]string, 0, limit+1)
+	if isSearch {
+		lines = append(lines, fmt.Sprintf("Campaign search results for '%s'%s:", query, suffix))
+	} else if statusFilter != "" {
+		lines = append(lines, fmt.Sprintf("Campaigns (%s)%s:", statusFilter, suffix))
+	} else {
+		lines = append(lines, "Campaigns"+suffix+":")
+	}
+	listed := make([]listedEntity, 0, limit)
+	for _, it := range rows {
+		if len(lines)-1 >= limit {
+			break
+		}
+		m, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		id, _ := m["id"].(string)
+		name, _ := m["name"].(string)
+		id = strings.TrimSpace(id)
+		name = strings.TrimSpace(name)
+		if id == "" || name == "" {
+			continue
+		}
+		st, fromDates := effectiveCampaignStatus(m, statusFilter, now, loc)
+		lines = append(lines, fmt.Sprintf("- %s (%s) — %s", name, id, campaignStatusLabel(st, fromDates)))
+		listed = append(listed, listedEntity{ID: id, Name: name})
+	}
+	if len(unknown) > 0 {
+		lines = append(lines, unknownCampaignsNote(unknown))
+	}
+	c.rememberList(conversationID, listKindCampaign, listed)
+	answer := strings.Join(lines, "\n")
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+}
+
+func parseSelectedDays(msgLower string) []string {
+	s := strings.ToLower(msgLower)
+	ordered := []struct {
+		k   string
+		out string
+	}{
+		{"monday", "mon"},
+		{"mon", "mon"},
+		{"tuesday", "tue"},
+		{"tue", "tue"},
+		{"wednesday", "wed"},
+		{"wed", "wed"},
+		{"thursday", "thu"},
+		{"thu", "thu"},
+		{"friday", "fri"},
+		{"fri", "fri"},
+		{"saturday", "sat"},
+		{"sat", "sat"},
+		{"sunday", "sun"},
+		{"sun", "sun"},
+	}
+	seen := map[string]struct{}{}
+	res := make([]string, 0)
+	for _, it := range ordered {
+		if strings.Contains(s, it.k) {
+			if _, ok := seen[it.out]; !ok {
+				seen[it.out] = struct{}{}
+				res = append(res, it.out)
+			}
+		}
+	}
+	return res
+}
+
+func extractCampaignRows(parsed map[string]any) []any {
+	if parsed == nil {
+		return nil
+	}
+	if rows, ok := parsed["data"].([]any); ok {
+		return rows
+	}
+	if rows, ok := parsed["items"].([]any); ok {
+		return rows
+	}
+	if d, ok := parsed["data"].(map[string]any); ok {
+		if rows, ok := d["campaigns"].([]any); ok {
+			return rows
+		}
+		if rows, ok := d["items"].([]any); ok {
+			return rows
+		}
+	}
+	return nil
+}
+
+func formatCampaignSuggestions(rows []any, limit int) []string {
+	if limit <= 0 {
+		limit = 5
+	}
+	suggestions := make([]string, 0, limit)
+	for _, it := range rows {
+		m, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		id, _ := m["id"].(string)
+		nm, _ := m["name"].(string)
+		id = strings.TrimSpace(id)
+		nm = strings.TrimSpace(nm)
+		if id == "" || nm == "" {
+			continue
+		}
+		suggestions = append(suggestions, nm+" ("+id+")")
+		if len(suggestions) >= limit {
+			break
+		}
+	}
+	return suggestions
+}
+
+func parseTimeSlots(msg string) []string {
+	re := regexp.MustCompile(`(?i)\b([01]?[0-9]|2[0-3]):[0-5][0-9]\s*-\s*([01]?[0-9]|2[0-3]):[0-5][0-9]\b`)
+	matches := re.FindAllString(msg, -1)
+	res := make([]string, 0, len(matches))
+	seen := map[string]struct{}{}
+	for _, m := range matches {
+		m = strings.ReplaceAll(m, " ", "")
+		if _, ok := seen[m]; ok {
+			continue
+		}
+		seen[m] = struct{}{}
+		res = append(res, m)
+	}
+	return res
+}
+
+func parseDevicesList(msgLower string) []string {
+	key := "devices"
+	idx := strings.Index(msgLower, key)
+	if idx < 0 {
+		return nil
+	}
+	rest := strings.TrimSpace(msgLower[idx+len(key):])
+	rest = strings.TrimLeft(rest, " :")
+	if rest == "" {
+		return nil
+	}
+	stop := len(rest)
+	for _, sep := range []string{"\n", ";", " with ", " where ", " from ", " campaign", " day", " slot", " time"} {
+		if j := strings.Index(rest, sep); j >= 0 && j < stop {
+			stop = j
+		}
+	}
+	chunk := strings.TrimSpace(rest[:stop])
+	chunk = strings.Trim(chunk, "[](){}")
+	if chunk == "" {
+		return nil
+	}
+	parts := strings.FieldsFunc(chunk, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	res := make([]string, 0, len(parts))
+	seen := map[string]struct{}{}
+	for _, p := range parts {
+		p = strings.TrimSpace(strings.Trim(p, "\"'"))
+		if p == "" {
+			continue
+		}
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		seen[p] = struct{}{}
+		res = append(res, p)
+	}
+	return res
+}
+
+func isCreativeUploadIntent(msgLower string) bool {
+	s := strings.ToLower(strings.TrimSpace(msgLower))
+	if s == "" {
+		return false
+	}
+	if strings.Contains(s, "upload") && (strings.Contains(s, "creative") || strings.Contains(s, "file") || strings.Contains(s, "poster")) {
+		return true
+	}
+	return false
+}
+
+func (c *ChatService) resolveCampaignID(ctx context.Context, msgLower string) string {
+	best, _, ok := c.resolveCampaign(ctx, msgLower)
+	if !ok {
+		return ""
+	}
+	return best.ID
+}
+
+// resolveCampaign resolves a campaign from a UUID or a name in the message. It
+// only succeeds when the best name match is decisive; the returned query is the
+// extracted name so callers can explain fuzzy corrections.
+func (c *ChatService) resolveCampaign(ctx context.Context, msgLower string) (campaignCandidate, string, bool) {
+	if id := extractCampaignID(msgLower); looksLikeUUID(id) {
+		return campaignCandidate{ID: id, Score: campaignScoreExact}, "", true
+	}
+	campaignName := extractAfterKeyword(msgLower, "campaign")
+	if campaignName == "" {
+		campaignName = extractAfterKeyword(msgLower, "campaign:")
+	}
+	if campaignName == "" {
+		campaignName = extractAfterKeyword(msgLower, "to")
+	}
+	campaignName = strings.TrimSpace(campaignName)
+	if campaignName == "" {
+		return campaignCandidate{}, "", false
+	}
+	status, body, err := c.Gateway.Get(ctx, fmt.Sprintf("/ads/campaigns?page=1&page_size=%d", c.limits().ListPageSize))
+	if err != nil || status < 200 || status >= 300 {
+		return campaignCandidate{}, campaignName, false
+	}
+	var parsed map[string]any
+	if json.Unmarshal(body, &parsed) != nil {
+		return campaignCandidate{}, campaignName, false
+	}
+	ranked := rankCampaignCandidates(extractCampaignRows(parsed), campaignName)
+	if !campaignMatchDecisive(ranked) {
+		return campaignCandidate{}, campaignName, false
+	}
+	return ranked[0], campaignName, true
+}
+
+var (
+	// Time words after the campaign name ("campaign spring sale this week").
+	campaignNameTimeRe = regexp.MustCompile(`\s+(?:(?:this|last|past)\s+(?:week|month|\d+\s+days?)|recently|lately|today|yesterday|since\s+.*)$`)
+	// Words that end a name written before "campaign", scanning backwards
+	// ("added to the bet 365 campaign" -> "bet 365").
+	campaignNameStopWords = map[string]bool{"to": true, "from": true, "in": true, "of": true, "for": true, "on": true, "the": true, "a": true, "were": true, "was": true, "added": true, "removed": true, "or": true, "and": true}
+)
+
+// campaignNameQuery rewrites the message as "campaign <name>" for
+// resolveCampaign, taking the name from before the word campaign ("the bet
+// 365 campaign") or after it. It returns "" when no name is given.
+func campaignNameQuery(msgLower string) string {
+	s := strings.TrimRight(strings.TrimSpace(msgLower), "?.!")
+	if id := extractCampaignID(s); looksLikeUUID(id) {
+		return "campaign " + id
+	}
+	idx := strings.Index(s, "campaign")
+	if idx < 0 {
+		return ""
+	}
+	before := strings.Fields(s[:idx])
+	name := make([]string, 0, 4)
+	for i := len(before) - 1; i >= 0 && !campaignNameStopWords[before[i]]; i-- {
+		name = append([]string{before[i]}, name...)
+	}
+	if len(name) > 0 {
+		return "campaign " + strings.Join(name, " ")
+	}
+	after := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s[idx+len("campaign"):]), "s"))
+	for {
+		trimmed := campaignNameTimeRe.ReplaceAllString(after, "")
+		if trimmed == after {
+			break
+		}
+		after = trimmed
+	}
+	after = strings.TrimSpace(after)
+	if after == "" {
+		return ""
+	}
+	return "campaign " + after
+}
+
+const (
+	campaignScoreExact    = 1000
+	campaignScoreDecisive = 550
+)
+
+type campaignCandidate struct {
+	ID    string
+	Name  string
+	Score int
+}
+
+func compactAlnum(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// scoreCampaignName ranks a campaign name against a user query:
+// exact > prefix > contains > token overlap. Space-insensitive variants
+// ("bet365" vs "Bet 365") score just below their spaced counterparts.
+func scoreCampaignName(query, name string) int {
+	q := normalizeLooseText(query)
+	n := normalizeLooseText(name)
+	if q == "" || n == "" {
+		return 0
+	}
+	qc := compactAlnum(q)
+	nc := compactAlnum(n)
+	switch {
+	case n == q:
+		return campaignScoreExact
+	case qc != "" && nc == qc:
+		return 900
+	case strings.HasPrefix(n, q):
+		return 800
+	case len(qc) >= 3 && strings.HasPrefix(nc, qc):
+		return 750
+	case strings.Contains(n, q):
+		return 600
+	case len(qc) >= 3 && strings.Contains(nc, qc):
+		return campaignScoreDecisive
+	}
+	qTokens := strings.Fields(q)
+	matched := 0
+	for _, t := range qTokens {
+		if strings.Contains(n, t) {
+			matched++
+		}
+	}
+	if matched == 0 {
+		return 0
+	}
+	return 400 * matched / len(qTokens)
+}
+
+func rankCampaignCandidates(rows []any, query string) []campaignCandidate {
+	out := make([]campaignCandidate, 0, len(rows))
+	for _, it := range rows {
+		m, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		id, _ := m["id"].(string)
+		name, _ := m["name"].(string)
+		id = strings.TrimSpace(id)
+		name = strings.TrimSpace(name)
+		if id == "" || name == "" {
+			continue
+		}
+		score := scoreCampaignName(query, name)
+		if score <= 0 {
+			continue
+		}
+		out = append(out, campaignCandidate{ID: id, Name: name, Score: score})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	return out
+}
+
+// campaignMatchDecisive reports whether the top candidate is strong enough and
+// clearly ahead of the runner-up to auto-select without asking.
+func campaignMatchDecisive(ranked []campaignCandidate) bool {
+	if len(ranked) == 0 || ranked[0].Score < campaignScoreDecisive {
+		return false
+	}
+	return len(ranked) == 1 || ranked[0].Score-ranked[1].Score >= 100
+}
+
+// campaignClarification lists the top candidates and remembers them so the
+// user's next reply ("2", a name, or an id) can pick one.
+func (c *ChatService) campaignClarification(conversationID, originalMessage, query string, ranked []campaignCandidate) string {
+	if len(ranked) > 5 {
+		ranked = ranked[:5]
+	}
+	lines := make([]string, 0, len(ranked)+2)
+	lines = append(lines, fmt.Sprintf("I'm not sure which campaign you mean by '%s'. Did you mean one of these?", query))
+	for i, cand := range ranked {
+		lines = append(lines, fmt.Sprintf("%d. %s (%s)", i+1, cand.Name, cand.ID))
+	}
+	lines = append(lines, "Reply with the number, name, or id.")
+	c.setPendingCampaigns(conversationID, originalMessage, ranked)
+	return strings.Join(lines, "\n")
+}
+
+// selectPendingCampaign maps a clarification reply onto one of the offered
+// candidates. It returns false when the reply doesn't look like a selection.
+func selectPendingCampaign(reply string, options []campaignCandidate) (campaignCandidate, bool) {
+	r := strings.TrimSpace(reply)
+	if r == "" || len(options) == 0 {
+		return campaignCandidate{}, false
+	}
+	if id := extractCampaignID(r); looksLikeUUID(id) {
+		for _, o := range options {
+			if strings.EqualFold(o.ID, id) {
+				return o, true
+			}
+		}
+		return campaignCandidate{}, false
+	}
+	if len(strings.Fields(r)) <= 3 {
+		if n := extractFirstInt(r); n >= 1 && n <= len(options) {
+			return options[n-1], true
+		}
+	}
+	ranked := make([]campaignCandidate, 0, len(options))
+	for _, o := range options {
+		if score := scoreCampaignName(r, o.Name); score > 0 {
+			ranked = append(ranked, campaignCandidate{ID: o.ID, Name: o.Name, Score: score})
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	if campaignMatchDecisive(ranked) {
+		return ranked[0], true
+	}
+	return campaignCandidate{}, false
+}
+
+// refersToCurrentCampaign reports whether msg points at the conversation's
+// campaign instead of naming one.
+func refersToCurrentCampaign(msgLower string) bool {
+	for _, k := range []string{" to it", " for it", " on it", "this campaign", "that campaign", "the new campaign", "the campaign i just created", "same campaign"} {
+		if strings.Contains(" "+msgLower, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ChatService) handleCreativeUpload(ctx context.Context, ownerKey string, req models.ChatRequest) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if isListCampaignsIntent(msgLower) {
+		if c.Gateway == nil {
+			return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+		}
+		status, body, err := c.Gateway.Get(ctx, "/ads/campaigns?page=1&page_size=50")
+		step := models.Step{Tool: "adsCampaigns", Status: status}
+		if err != nil {
+			step.Error = err.Error()
+			return models.ChatResponse{Answer: "Failed to list campaigns: " + err.Error(), Steps: []models.Step{step}}, true, nil
+		}
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		if status < 200 || status >= 300 {
+			return models.ChatResponse{Answer: fmt.Sprintf("Failed to list campaigns (status %d).", status), Steps: []models.Step{step}}, true, nil
+		}
+		var parsed map[string]any
+		if json.Unmarshal(body, &parsed) != nil {
+			return models.ChatResponse{Answer: "Failed to parse campaign list.", Steps: []models.Step{step}}, true, nil
+		}
+		rows := extractCampaignRows(parsed)
+		suggestions := formatCampaignSuggestions(rows, c.limits().DisplayTopN)
+		if len(suggestions) == 0 {
+			return models.ChatResponse{Answer: "No campaigns found.", Steps: []models.Step{step}}, true, nil
+		}
+		return models.ChatResponse{Answer: "Campaigns: " + strings.Join(suggestions, "; "), Steps: []models.Step{step}}, true, nil
+	}
+
+	if !isCreativeUploadIntent(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	if readOnlyKey(ctx) {
+		return readOnlyRefusal(ctx, "upload creatives"), true, nil
+	}
+	if len(req.Attachments) == 0 {
+		return models.ChatResponse{Answer: "To upload creatives, attach the file(s) and include: campaign (id or name), selected days, time slots, and devices."}, true, nil
+	}
+	devices := parseDevicesList(msgLower)
+	if len(devices) == 0 {
+		return models.ChatResponse{Answer: "Devices are required for creative upload. Please specify devices (e.g. devices: dev1,dev2,dev3)."}, true, nil
+	}
+	days := parseSelectedDays(msgLower)
+	if len(days) == 0 {
+		return models.ChatResponse{Answer: "Please specify selected days for the creative (e.g. mon,tue,wed)."}, true, nil
+	}
+	slots := parseTimeSlots(req.Message)
+	if len(slots) == 0 {
+		return models.ChatResponse{Answer: "Please specify time slots in HH:MM-HH:MM format (e.g. 08:00-12:00, 12:00-16:00)."}, true, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Upload is not available because tool gateway is not configured."}, true, nil
+	}
+	campaign, campaignQuery, _ := c.resolveCampaign(ctx, msgLower)
+	campaignID := campaign.ID
+	if campaignID == "" && refersToCurrentCampaign(msgLower) {
+		// "upload creatives to it" after creating or selecting a campaign.
+		if st := c.getConversationState(req.ConversationID); st != nil {
+			campaignID = st.CampaignID
+		}
+	}
+	if campaignID == "" {
+		status, body, err := c.Gateway.Get(ctx, "/ads/campaigns?page=1&page_size=50")
+		if err != nil {
+			return models.ChatResponse{Answer: "Please specify a valid campaign (campaign_id UUID or campaign name). Also failed to fetch campaign list: " + err.Error()}, true, nil
+		}
+		if status < 200 || status >= 300 {
+			return models.ChatResponse{Answer: fmt.Sprintf("Please specify a valid campaign (campaign_id UUID or campaign name). Also failed to fetch campaign list (status %d): %s", status, clipString(strings.TrimSpace(string(body)), 500))}, true, nil
+		}
+		var parsed map[string]any
+		if json.Unmarshal(body, &parsed) != nil {
+			return models.ChatResponse{Answer: "Please specify a valid campaign (campaign_id UUID or campaign name). Also could not parse campaign list: " + clipString(strings.TrimSpace(string(body)), 500)}, true, nil
+		}
+		rows := extractCampaignRows(parsed)
+		suggestions := formatCampaignSuggestions(rows, c.limits().DisplayTopN)
+		if len(suggestions) == 0 {
+			return models.ChatResponse{Answer: "Please specify a valid campaign (campaign_id UUID or campaign name). Campaign list appears empty or in an unexpected format: " + clipString(strings.TrimSpace(string(body)), 500)}, true, nil
+		}
+		return models.ChatResponse{Answer: "Please specify a valid campaign. Here are campaigns I can see: " + strings.Join(suggestions, "; ")}, true, nil
+	}
+
+	fields := map[string][]string{
+		"campaign_id":   {campaignID},
+		"selected_days": days,
+		"time_slots":    slots,
+		"devices":       devices,
+	}
+	files, problems := c.validateCreativeAttachments(req.Attachments)
+	if len(problems) > 0 {
+		lines := make([]string, 0, len(problems)+1)
+		lines = append(lines, "Upload rejected before sending anything to the gateway:")
+		for _, p := range problems {
+			lines = append(lines, "- "+p)
+		}
+		return models.ChatResponse{Answer: strings.Join(lines, "\n")}, true, nil
+	}
+	if len(files) == 0 {
+		return models.ChatResponse{Answer: "Attachment(s) missing base64 content. Please attach the file again."}, true, nil
+	}
+
+	payload := MultipartPayload{Fields: fields, Files: files}
+	if c.isDryRun(req) {
+		step := dryRunStep("adsCreativesUpload", "POST", "/ads/creatives/upload", nil, nil, &payload)
+		names := make([]string, 0, len(files))
+		for _, f := range files {
+			names = append(names, f.FileName)
+		}
+		answer := fmt.Sprintf("Dry run: no changes were made. Would upload %d file(s) (%s) to campaign %s via POST /ads/creatives/upload for days %s, time slots %s, devices %s.",
+			len(files), strings.Join(names, ", "), campaignID, strings.Join(days, ","), strings.Join(slots, ", "), strings.Join(devices, ","))
+		return models.ChatResponse{Answer: answer, Steps: []models.Step{step}}, true, nil
+	}
+
+	if err := c.checkIdempotencyClaim(ctx); err != nil {
+		return models.ChatResponse{Answer: "Upload not sent: " + err.Error() + "."}, true, nil
+	}
+	status, body, err := c.Gateway.DoMultipart(ctx, "POST", "/ads/creatives/upload", nil, payload)
+	step := models.Step{Tool: "adsCreativesUpload", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+	} else {
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	}
+	answer := ""
+	if err != nil {
+		answer = "Creative upload failed: " + err.Error()
+	} else if status < 200 || status >= 300 {
+		answer = fmt.Sprintf("Creative upload failed with status %d.", status)
+		if results := parseCreativeUploadResults(body); len(results) > 0 {
+			answer = answer + "\n" + formatCreativeUploadResults(results)
+		}
+	} else if results := parseCreativeUploadResults(body); len(results) > 0 {
+		answer = formatCreativeUploadResults(results)
+	} else {
+		answer = fmt.Sprintf("Creative upload successful (%d file(s)).", len(files))
+	}
+	if campaign.Score < campaignScoreExact && campaign.Name != "" {
+		answer = fmt.Sprintf("Interpreted '%s' as '%s'.\n", campaignQuery, campaign.Name) + answer
+	}
+	return models.ChatResponse{Answer: answer, Steps: []models.Step{step}}, true, nil
+}
+
+var allowedCreativeContentTypes = map[string]struct{}{
+	"image/png":  {},
+	"image/jpeg": {},
+	"video/mp4":  {},
+	"video/webm": {},
+}
+
+func normalizeCreativeContentType(ct string) string {
+	ct = strings.ToLower(strings.TrimSpace(ct))
+	if i := strings.Index(ct, ";"); i >= 0 {
+		ct = strings.TrimSpace(ct[:i])
+	}
+	switch ct {
+	case "image/jpg", "image/pjpeg":
+		return "image/jpeg"
+	case "audio/webm":
+		return "video/webm"
+	}
+	return ct
+}
+
+// sniffCreativeContentType inspects the leading bytes of a decoded attachment.
+// http.DetectContentType covers png/jpeg/webm; mp4 is matched on the ftyp box
+// since its signature varies by brand.
+func sniffCreativeContentType(head []byte) string {
+	if len(head) >= 12 && string(head[4:8]) == "ftyp" {
+		return "video/mp4"
+	}
+	return normalizeCreativeContentType(http.DetectContentType(head))
+}
+
+// base64DecodedLen returns the exact decoded size of a padded std-base64 string
+// without decoding it.
+func base64DecodedLen(b64 string) int64 {
+	n := int64(len(b64))
+	if n == 0 {
+		return 0
+	}
+	pad := int64(0)
+	if strings.HasSuffix(b64, "==") {
+		pad = 2
+	} else if strings.HasSuffix(b64, "=") {
+		pad = 1
+	}
+	return n/4*3 + (n%4)*3/4 - pad
+}
+
+func (c *ChatService) validateCreativeAttachments(attachments []models.ChatAttachment) ([]MultipartFile, []string) {
+	maxFile := c.MaxUploadFileBytes
+	if maxFile <= 0 {
+		maxFile = 50 << 20
+	}
+	maxTotal := c.MaxUploadTotalBytes
+	if maxTotal <= 0 {
+		maxTotal = 200 << 20
+	}
+
+	files := make([]MultipartFile, 0, len(attachments))
+	problems := make([]string, 0)
+	total := int64(0)
+	for i, a := range attachments {
+		name := strings.TrimSpace(a.FileName)
+		if name == "" {
+			name = fmt.Sprintf("attachment #%d", i+1)
+		}
+		b64 := strings.Join(strings.Fields(a.Base64), "")
+		if b64 == "" {
+			problems = append(problems, fmt.Sprintf("%s: no content (base64 is empty)", name))
+			continue
+		}
+		size := base64DecodedLen(b64)
+		total += size
+		if size > maxFile {
+			problems = append(problems, fmt.Sprintf("%s: %.1f MiB exceeds the per-file limit of %.1f MiB", name, float64(size)/(1<<20), float64(maxFile)/(1<<20)))
+			continue
+		}
+
+		// 684 base64 chars decode to 513 bytes, enough for http.DetectContentType.
+		prefix := b64
+		if len(prefix) > 684 {
+			prefix = prefix[:684]
+		}
+		head, err := base64.StdEncoding.DecodeString(prefix)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: content is not valid base64", name))
+			continue
+		}
+		sniffed := sniffCreativeContentType(head)
+		if _, ok := allowedCreativeContentTypes[sniffed]; !ok {
+			problems = append(problems, fmt.Sprintf("%s: unsupported media type %s (allowed: png, jpg, mp4, webm)", name, sniffed))
+			continue
+		}
+		declared := normalizeCreativeContentType(a.ContentType)
+		if declared != "" && declared != "application/octet-stream" && declared != sniffed {
+			problems = append(problems, fmt.Sprintf("%s: declared content type %s does not match file contents (%s)", name, declared, sniffed))
+			continue
+		}
+
+		files = append(files, MultipartFile{
+			FieldName:   "files",
+			FileName:    strings.TrimSpace(a.FileName),
+			ContentType: sniffed,
+			Base64:      b64,
+		})
+	}
+	if total > maxTotal {
+		problems = append(problems, fmt.Sprintf("total upload size %.1f MiB exceeds the limit of %.1f MiB", float64(total)/(1<<20), float64(maxTotal)/(1<<20)))
+	}
+	return files, problems
+}
+
+type creativeUploadResult struct {
+	FileName   string
+	CreativeID string
+	Error      string
+}
+
+// parseCreativeUploadResults extracts per-file outcomes from the gateway upload
+// response. The gateway has returned both a flat list and succeeded/failed
+// groups, so both shapes are accepted.
+func parseCreativeUploadResults(body []byte) []creativeUploadResult {
+	var root map[string]any
+	if json.Unmarshal(body, &root) != nil {
+		return nil
+	}
+	if d, ok := root["data"].(map[string]any); ok {
+		root = d
+	}
+
+	str := func(m map[string]any, keys ...string) string {
+		for _, k := range keys {
+			switch v := m[k].(type) {
+			case string:
+				if strings.TrimSpace(v) != "" {
+					return strings.TrimSpace(v)
+				}
+			case float64:
+				return strconv.FormatInt(int64(v), 10)
+			}
+		}
+		return ""
+	}
+	toResult := func(it any, failed bool) (creativeUploadResult, bool) {
+		m, ok := it.(map[string]any)
+		if !ok {
+			return creativeUploadResult{}, false
+		}
+		r := creativeUploadResult{
+			FileName:   str(m, "file_name", "filename", "original_name", "name"),
+			CreativeID: str(m, "creative_id", "id"),
+			Error:      str(m, "error", "reason", "message"),
+		}
+		if ok, has := m["success"].(bool); has && !ok && r.Error == "" {
+			r.Error = "upload failed"
+		}
+		if st := strings.ToLower(str(m, "status")); (st == "failed" || st == "error") && r.Error == "" {
+			r.Error = "upload failed"
+		}
+		if failed && r.Error == "" {
+			r.Error = "upload failed"
+		}
+		if r.Error != "" {
+			r.CreativeID = ""
+		}
+		return r, r.FileName != "" || r.CreativeID != "" || r.Error != ""
+	}
+
+	out := make([]creativeUploadResult, 0)
+	for _, k := range []string{"results", "files", "creatives", "items", "uploaded", "succeeded"} {
+		rows, _ := root[k].([]any)
+		for _, it := range rows {
+			if r, ok := toResult(it, false); ok {
+				out = append(out, r)
+			}
+		}
+	}
+	for _, k := range []string{"failed", "errors"} {
+		rows, _ := root[k].([]any)
+		for _, it := range rows {
+			if r, ok := toResult(it, true); ok {
+				out = append(out, r)
+			}
+		}
+	}
+	return out
+}
+
+func formatCreativeUploadResults(results []creativeUploadResult) string {
+	ok := make([]string, 0, len(results))
+	bad := make([]string, 0)
+	for _, r := range results {
+		name := r.FileName
+		if name == "" {
+			name = "(unnamed file)"
+		}
+		if r.Error != "" {
+			bad = append(bad, fmt.Sprintf("- %s: %s", name, r.Error))
+			continue
+		}
+		if r.CreativeID != "" {
+			ok = append(ok, fmt.Sprintf("- %s → creative %s", name, r.CreativeID))
+		} else {
+			ok = append(ok, "- "+name)
+		}
+	}
+	lines := make([]string, 0, len(results)+2)
+	if len(ok) > 0 {
+		lines = append(lines, fmt.Sprintf("Uploaded %d file(s):", len(ok)))
+		lines = append(lines, ok...)
+	}
+	if len(bad) > 0 {
+		lines = append(lines, fmt.Sprintf("Failed %d file(s):", len(bad)))
+		lines = append(lines, bad...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+type gwCampaignImpressionsResponse struct {
+	Data *models.CampaignImpressions `json:"data"`
+}
+
+func (c *ChatService) prefetchImpressions(ctx context.Context, msg string) (*models.ChatData, []models.Step, map[string]any) {
+	steps := make([]models.Step, 0)
+	data := &models.ChatData{}
+	var toolData map[string]any
+
+	msgLower := strings.ToLower(msg)
+	// City ranking queries like "which city has most clicks" are answered by aggregating device click stats.
+	isCityMostClicks := (strings.Contains(msgLower, "city") && strings.Contains(msgLower, "click") && (strings.Contains(msgLower, "most") || strings.Contains(msgLower, "highest") || strings.Contains(msgLower, "top")))
+
+	rawCityCode := c.detectCityCode(ctx, msgLower)
+	regionCodeForQuery := c.detectRegionCode(ctx, msgLower)
+	cityCodeForQuery, cityExplicit := normalizeCitySelection(rawCityCode, regionCodeForQuery, msgLower)
+	if strings.Contains(msgLower, "impression") {
+		campaignID := extractCampaignID(msg)
+		if campaignID != "" {
+			status, body, err := c.Gateway.Get(ctx, "/ads/campaigns/"+urlEscape(campaignID)+"/impressions")
+			step := models.Step{Tool: "adsCampaignImpressions", CampaignID: campaignID, Status: status}
+			if err != nil {
+				step.Error = err.Error()
+			} else {
+				step.Body = c.clipStep(strings.TrimSpace(string(body)))
+			}
+			steps = append(steps, step)
+			if err == nil && status >= 200 && status < 300 {
+				var parsed any
+				if json.Unmarshal(body, &parsed) == nil {
+					if toolData == nil {
+						toolData = map[string]any{}
+					}
+					toolData["ads_campaign_impressions"] = parsed
+				}
+				var gw gwCampaignImpressionsResponse
+				if json.Unmarshal(body, &gw) == nil {
+					data.CampaignImpressions = gw.Data
+				}
+			}
+		}
+	}
+
+	if strings.Contains(msgLower, "advertiser") {
+		status, body, err := c.Gateway.Get(ctx, "/ads/advertisers")
+		step := models.Step{Tool: "adsAdvertisers", Status: status}
+		if err != nil {
+			step.Error = err.Error()
+		} else {
+			step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		}
+		steps = append(steps, step)
+		if err == nil && status >= 200 && status < 300 {
+			var parsed any
+			if json.Unmarshal(body, &parsed) == nil {
+				if toolData == nil {
+					toolData = map[string]any{}
+				}
+				toolData["ads_advertisers"] = parsed
+			}
+		}
+	}
+
+	if strings.Contains(msgLower, "campaign") && (strings.Contains(msgLower, "list") || strings.Contains(msgLower, "show") || strings.Contains(msgLower, "all")) {
+		advertiserID := ""
+		statusFilter := extractStatusFilter(msgLower)
+		// Try to pick an explicit UUID if user included it.
+		if strings.Contains(msgLower, "advertiser") {
+			advertiserID = extractCampaignID(msg)
+		}
+		// Resolve advertiser name to ID from cached advertisers, or fetch advertisers if needed.
+		advName := ""
+		if advertiserID == "" && strings.Contains(msgLower, "advertiser") {
+			advName = extractAfterKeyword(msgLower, "advertiser")
+			if advName == "" {
+				advName = extractAfterKeyword(msgLower, "advertiser ")
+			}
+		}
+		if advertiserID == "" && advName != "" {
+			// Ensure advertisers are available.
+			if toolData == nil || toolData["ads_advertisers"] == nil {
+				status, body, err := c.Gateway.Get(ctx, "/ads/advertisers")
+				step := models.Step{Tool: "adsAdvertisers", Status: status}
+				if err != nil {
+					step.Error = err.Error()
+				} else {
+					step.Body = c.clipStep(strings.TrimSpace(string(body)))
+				}
+				steps = append(steps, step)
+				if err == nil && status >= 200 && status < 300 {
+					var parsed any
+					if json.Unmarshal(body, &parsed) == nil {
+						if toolData == nil {
+							toolData = map[string]any{}
+						}
+						toolData["ads_advertisers"] = parsed
+					}
+				}
+			}
+			if toolData != nil {
+				if root, ok := toolData["ads_advertisers"].(map[string]any); ok {
+					if rows, ok := root["data"].([]any); ok {
+						for _, it := range rows {
+							m, ok := it.(map[string]any)
+							if !ok {
+								continue
+							}
+							name, _ := m["name"].(string)
+							id, _ := m["id"].(string)
+							if id != "" && containsFolded(name, advName) {
+								advertiserID = id
+								break
+							}
+						}
+					}
+				}
+			}
+		}
+
+		// Fetch campaigns (max page_size) and filter locally when needed.
+		status, body, err := c.Gateway.Get(ctx, fmt.Sprintf("/ads/campaigns?page=1&page_size=%d", c.limits().ListPageSize))
+		step := models.Step{Tool: "adsCampaigns", Status: status}
+		if err != nil {
+			step.Error = err.Error()
+		} else {
+			step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		}
+		steps = append(steps, step)
+		if err == nil && status >= 200 && status < 300 {
+			var parsed map[string]any
+			if json.Unmarshal(body, &parsed) == nil {
+				if toolData == nil {
+					toolData = map[string]any{}
+				}
+				toolData["ads_campaigns"] = parsed
+				// Optional filtered view
+				if advertiserID != "" || statusFilter != "" {
+					out := make([]any, 0)
+					if rows, ok := parsed["data"].([]any); ok {
+						for _, it := range rows {
+							m, ok := it.(map[string]any)
+							if !ok {
+								continue
+							}
+							if advertiserID != "" {
+								advID, _ := m["advertiser_id"].(string)
+								if advID != advertiserID {
+									continue
+								}
+							}
+							if statusFilter != "" {
+								st, _ := effectiveCampaignStatus(m, statusFilter, time.Now(), time.UTC)
+								if !campaignStatusMatches(statusFilter, st) {
+									continue
+								}
+							}
+							out = append(out, m)
+						}
+					}
+					toolData["ads_campaigns_filtered"] = map[string]any{"data": out}
+				}
+			}
+		}
+	}
+
+	if strings.Contains(msgLower, "creative") && (strings.Contains(msgLower, "list") || strings.Contains(msgLower, "show") || strings.Contains(msgLower, "all")) {
+		campaignID := ""
+		if strings.Contains(msgLower, "campaign") {
+			campaignID = extractCampaignID(msg)
+		}
+		campName := ""
+		if campaignID == "" && strings.Contains(msgLower, "campaign") {
+			campName = extractAfterKeyword(msgLower, "campaign")
+			if campName == "" {
+				campName = extractAfterKeyword(msgLower, "campaign ")
+			}
+		}
+		if campaignID == "" && campName != "" {
+			// Ensure campaigns are available.
+			if toolData == nil || toolData["ads_campaigns"] == nil {
+				status, body, err := c.Gateway.Get(ctx, fmt.Sprintf("/ads/campaigns?page=1&page_size=%d", c.limits().ListPageSize))
+				step := models.Step{Tool: "adsCampaigns", Status: status}
+				if err != nil {
+					step.Error = err.Error()
+				} else {
+					step.Body = c.clipStep(strings.TrimSpace(string(body)))
+				}
+				steps = append(steps, step)
+				if err == nil && status >= 200 && status < 300 {
+					var parsed map[string]any
+					if json.Unmarshal(body, &parsed) == nil {
+						if toolData == nil {
+							toolData = map[string]any{}
+						}
+						toolData["ads_campaigns"] = parsed
+					}
+				}
+			}
+			if toolData != nil {
+				if root, ok := toolData["ads_campaigns"].(map[string]any); ok {
+					if rows, ok := root["data"].([]any); ok {
+						for _, it := range rows {
+							m, ok := it.(map[string]any)
+							if !ok {
+								continue
+							}
+							name, _ := m["name"].(string)
+							id, _ := m["id"].(string)
+							if id != "" && containsFolded(name, campName) {
+								campaignID = id
+								break
+							}
+						}
+					}
+				}
+			}
+		}
+
+		path := "/ads/creatives?page=1&page_size=50"
+		if status := creativeStatusFilter(msgLower); status != "" {
+			if param := c.creativeStatusQueryParam(ctx); param != "" {
+				path += "&" + param + "=" + urlEscape(status)
+			}
+		}
+		stepTool := "adsCreatives"
+		if campaignID != "" {
+			path = "/ads/creatives/campaign/" + urlEscape(campaignID) + fmt.Sprintf("?page=1&page_size=%d", c.limits().ListPageSize)
+			stepTool = "adsCreativesByCampaign"
+		}
+		status, body, err := c.Gateway.Get(ctx, path)
+		step := models.Step{Tool: "adsCreatives", Status: status}
+		step.Tool = stepTool
+		if err != nil {
+			step.Error = err.Error()
+		} else {
+			step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		}
+		steps = append(steps, step)
+		if err == nil && status >= 200 && status < 300 {
+			var parsed any
+			if json.Unmarshal(body, &parsed) == nil {
+				if toolData == nil {
+					toolData = map[string]any{}
+				}
+				if campaignID != "" {
+					toolData["ads_creatives_by_campaign"] = parsed
+				} else {
+					toolData["ads_creatives"] = parsed
+				}
+			}
+		}
+	}
+
+	if (strings.Contains(msgLower, "device") || strings.Contains(msgLower, "kiosk")) && (strings.Contains(msgLower, "from") || strings.Contains(msgLower, "in") || strings.Contains(msgLower, "city")) {
+		if cityCodeForQuery != "" {
+			status, body, err := c.Gateway.Get(ctx, "/ads/devices?page=1&page_size=100&city="+cityCodeForQuery)
+			step := models.Step{Tool: "adsDevices", Status: status}
+			if err != nil {
+				step.Error = err.Error()
+			} else {
+				step.Body = c.clipStep(strings.TrimSpace(string(body)))
+			}
+			steps = append(steps, step)
+			if err == nil && status >= 200 && status < 300 {
+				var parsed any
+				if json.Unmarshal(body, &parsed) == nil {
+					if toolData == nil {
+						toolData = map[string]any{}
+					}
+					toolData["ads_devices"] = parsed
+				}
+			}
+		}
+	}
+
+	if (strings.Contains(msgLower, "device") || strings.Contains(msgLower, "kiosk")) && strings.Contains(msgLower, "count") {
+		path := "/ads/devices/counts/regions"
+		if cityCodeForQuery != "" {
+			path += "?city=" + cityCodeForQuery
+		}
+		status, body, err := c.Gateway.Get(ctx, path)
+		step := models.Step{Tool: "adsDevicesCountsRegions", Status: status}
+		if err != nil {
+			step.Error = err.Error()
+		} else {
+			step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		}
+		steps = append(steps, step)
+		if err == nil && status >= 200 && status < 300 {
+			var parsed any
+			if json.Unmarshal(body, &parsed) == nil {
+				if toolData == nil {
+					toolData = map[string]any{}
+				}
+				toolData["ads_devices_counts_regions"] = parsed
+			}
+		}
+	}
+
+	if strings.Contains(msgLower, "metric") || strings.Contains(msgLower, "metrics") {
+		if strings.Contains(msgLower, "history") {
+			status, body, err := c.Gateway.Get(ctx, "/metrics/history?page=1&page_size=50&include_totals=false")
+			step := models.Step{Tool: "metricsHistory", Status: status}
+			if err != nil {
+				step.Error = err.Error()
+			} else {
+				step.Body = c.clipStep(strings.TrimSpace(string(body)))
+			}
+			steps = append(steps, step)
+			if err == nil && status >= 200 && status < 300 {
+				var parsed any
+				if json.Unmarshal(body, &parsed) == nil {
+					if toolData == nil {
+						toolData = map[string]any{}
+					}
+					toolData["metrics_history"] = parsed
+				}
+			}
+		} else {
+			status, body, err := c.Gateway.Get(ctx, "/metrics/latest?page=1&page_size=50&include_totals=false")
+			step := models.Step{Tool: "metricsLatest", Status: status}
+			if err != nil {
+				step.Error = err.Error()
+			} else {
+				step.Body = c.clipStep(strings.TrimSpace(string(body)))
+			}
+			steps = append(steps, step)
+			if err == nil && status >= 200 && status < 300 {
+				var parsed any
+				if json.Unmarshal(body, &parsed) == nil {
+					if toolData == nil {
+						toolData = map[string]any{}
+					}
+					toolData["metrics_latest"] = parsed
+				}
+			}
+		}
+	}
+
+	hostTokens := detectHostTokens(msg)
+	wantsDeviceDetail := strings.Contains(msgLower, "device") || strings.Contains(msgLower, "kiosk") || strings.Contains(msgLower, "host") || strings.Contains(msgLower, "server")
+	if wantsDeviceDetail && len(hostTokens) > 0 && c.Gateway != nil {
+		const maxHosts = 3
+		var hostHistories map[string]any
+		for idx, host := range hostTokens {
+			if idx >= maxHosts {
+				break
+			}
+			if strings.TrimSpace(host) == "" {
+				continue
+			}
+			path := "/metrics/history?page=1&page_size=50&include_totals=false&server_id=" + urlEscape(strings.ToLower(host))
+			status, body, err := c.Gateway.Get(ctx, path)
+			step := models.Step{Tool: "metricsHistory", Status: status}
+			if err != nil {
+				step.Error = err.Error()
+			} else {
+				step.Body = c.clipStep(strings.TrimSpace(string(body)))
+			}
+			steps = append(steps, step)
+			if err != nil || status < 200 || status >= 300 {
+				continue
+			}
+			var parsed any
+			if json.Unmarshal(body, &parsed) != nil {
+				continue
+			}
+			if toolData == nil {
+				toolData = map[string]any{}
+			}
+			if hostHistories == nil {
+				hostHistories = map[string]any{}
+				toolData["metrics_history_hosts"] = hostHistories
+			}
+			hostHistories[strings.ToLower(host)] = parsed
+		}
+	}
+
+	// Add POP data prefetch
+	// Note: some user requests (e.g. "show brt stats") don't mention "pop" but still map to /pop/stats.
+	cityCodeForQuery, cityExplicit = normalizeCitySelection(c.detectCityCode(ctx, msgLower), regionCodeForQuery, msgLower)
+	hasCityCode := cityCodeForQuery != ""
+	hasRegionCode := regionCodeForQuery != ""
+	if strings.Contains(msgLower, "pop") ||
+		(strings.Contains(msgLower, "device") && (strings.Contains(msgLower, "click") || strings.Contains(msgLower, "most") || strings.Contains(msgLower, "more"))) ||
+		(hasCityCode && strings.Contains(msgLower, "stat")) ||
+		(hasRegionCode && (strings.Contains(msgLower, "stat") || strings.Contains(msgLower, "top") || strings.Contains(msgLower, "data"))) ||
+		isCityMostClicks {
+		// First check for stats queries - top posters, devices, etc.
+		var statsQueryPath string
+		var groupBy string
+
+		// Determine group_by parameter
+		if strings.Contains(msgLower, "top poster") || strings.Contains(msgLower, "best poster") {
+			groupBy = "poster"
+		} else if strings.Contains(msgLower, "top device") || strings.Contains(msgLower, "best device") {
+			groupBy = "device"
+		} else if strings.Contains(msgLower, "top kiosk") || strings.Contains(msgLower, "best kiosk") {
+			groupBy = "kiosk"
+		} else if strings.Contains(msgLower, "device") && (strings.Contains(msgLower, "click") || strings.Contains(msgLower, "most") || strings.Contains(msgLower, "more")) {
+			groupBy = "device"
+		} else if isCityMostClicks {
+			// We'll aggregate device click stats into city totals.
+			groupBy = "device"
+		} else if strings.Contains(msgLower, "stat") {
+			// Default for generic "stats" queries.
+			groupBy = "poster"
+		}
+
+		// If we found a valid group_by, proceed with building the query
+		if groupBy != "" {
+			limit := c.limits().DisplayTopN
+			if isCityMostClicks {
+				// Pull more rows so city aggregation is meaningful.
+				limit = 200
+			}
+			statsQueryPath = "/pop/stats?group_by=" + groupBy + "&order=top&limit=" + fmt.Sprintf("%d", limit)
+
+			// Determine metric
+			if strings.Contains(msgLower, "click") {
+				statsQueryPath += "&metric=clicks"
+			} else if strings.Contains(msgLower, "play") {
+				statsQueryPath += "&metric=plays"
+			} else if strings.Contains(msgLower, "count") {
+				statsQueryPath += "&metric=count"
+			} else {
+				statsQueryPath += "&metric=clicks" // Default to clicks
+			}
+
+			// Include an explicit date range when the user provides one.
+			fromRFC, toRFC := extractDateRangeRFC3339(msgLower)
+			if fromRFC != "" && toRFC != "" {
+				statsQueryPath += "&from=" + urlEscape(fromRFC) + "&to=" + urlEscape(toRFC)
+			}
+
+			// City vs region targeting.
+			useRegionForStats := false
+			if regionCodeForQuery != "" {
+				// Prefer region when no city detected, user explicitly says region, or city/region tokens are identical (e.g. "brt").
+				if strings.Contains(msgLower, "region") || !cityExplicit || strings.EqualFold(regionCodeForQuery, cityCodeForQuery) {
+					useRegionForStats = true
+				}
+			}
+			if useRegionForStats {
+				statsQueryPath += "&region=" + regionCodeForQuery
+			} else if cityCodeForQuery != "" {
+				statsQueryPath += "&city=" + cityCodeForQuery
+			}
+
+			debugLogf("gateway GET %s", statsQueryPath)
+			status, body, err := c.Gateway.Get(ctx, statsQueryPath)
+			debugLogf("gateway GET %s -> status=%d err=%v", statsQueryPath, status, err)
+
+			step := models.Step{Tool: "popStats", Status: status}
+			if err != nil {
+				step.Error = err.Error()
+			} else {
+				step.Body = c.clipStep(strings.TrimSpace(string(body)))
+			}
+			steps = append(steps, step)
+			if err == nil && status >= 200 && status < 300 {
+				var parsed map[string]any
+				if json.Unmarshal(body, &parsed) == nil {
+					if toolData == nil {
+						toolData = map[string]any{}
+					}
+
+					// For city stats queries, if poster-grouped stats are empty but device stats exist,
+					// retry with group_by=device for the same city/metric.
+					fbItems, fbHasItems := parsed["items"]
+					fbEmpty := false
+					if !fbHasItems || fbItems == nil {
+						fbEmpty = true
+					} else if slice, ok := fbItems.([]interface{}); ok {
+						if len(slice) == 0 {
+							fbEmpty = true
+						}
+					}
+					if fbEmpty && cityCodeForQuery != "" && strings.Contains(msgLower, "stat") && groupBy == "poster" {
+						fallbackPath := strings.Replace(statsQueryPath, "group_by=poster", "group_by=device", 1)
+						debugLogf("gateway GET %s", fallbackPath)
+						status2, body2, err2 := c.Gateway.Get(ctx, fallbackPath)
+						debugLogf("gateway GET %s -> status=%d err=%v", fallbackPath, status2, err2)
+						step2 := models.Step{Tool: "popStats", Status: status2}
+						if err2 != nil {
+							step2.Error = err2.Error()
+						} else {
+							step2.Body = c.clipStep(strings.TrimSpace(string(body2)))
+						}
+						steps = append(steps, step2)
+						if err2 == nil && status2 >= 200 && status2 < 300 {
+							var parsed2 map[string]any
+							if json.Unmarshal(body2, &parsed2) == nil {
+								parsed = parsed2
+							}
+						}
+					}
+
+					// Region fallback: if stats are empty and we have a region code (e.g. brt), retry with region.
+					// If the user asked for city ranking by clicks, aggregate device stats into city totals.
+					if isCityMostClicks {
+						cityTotals := map[string]float64{}
+						if rawItems, ok := parsed["items"].([]any); ok {
+							for _, it := range rawItems {
+								row, ok := it.(map[string]any)
+								if !ok {
+									continue
+								}
+								key, _ := row["Key"].(string)
+								metric := 0.0
+								switch v := row["Metric"].(type) {
+								case float64:
+									metric = v
+								case int:
+									metric = float64(v)
+								}
+								city := cityFromDeviceKey(key)
+								if city != "" {
+									cityTotals[city] += metric
+								}
+							}
+						}
+
+						// Prepare sorted output.
+						type cityKV struct {
+							City   string
+							Clicks float64
+						}
+						out := make([]cityKV, 0, len(cityTotals))
+						for k, v := range cityTotals {
+							out = append(out, cityKV{City: k, Clicks: v})
+						}
+						sort.Slice(out, func(i, j int) bool { return out[i].Clicks > out[j].Clicks })
+						// Convert to JSON-friendly shape.
+						outJSON := make([]map[string]any, 0, len(out))
+						for _, kv := range out {
+							outJSON = append(outJSON, map[string]any{"city": kv.City, "clicks": kv.Clicks})
+						}
+						toolData["city_click_totals"] = outJSON
+						if len(out) > 0 {
+							toolData["city_click_winner"] = map[string]any{"city": out[0].City, "clicks": out[0].Clicks}
+						}
+					}
+
+					items, hasItems := parsed["items"]
+					emptyItems := false
+					if !hasItems || items == nil {
+						emptyItems = true
+					} else if slice, ok := items.([]interface{}); ok {
+						if len(slice) == 0 {
+							emptyItems = true
+						}
+					}
+
+					// Handle empty data case (no items key or items is nil)
+					if emptyItems {
+						// Store relevant info to be used later when modifying userContent
+						scopeKey := ""
+						if useRegionForStats {
+							if region, ok := parsed["region"]; ok {
+								regStr, _ := region.(string)
+								if regStr != "" {
+									scopeKey = regStr
+								}
+							}
+							if scopeKey == "" {
+								scopeKey = regionCodeForQuery
+							}
+						} else {
+							if city, ok := parsed["city"]; ok {
+								cityStr, _ := city.(string)
+								if cityStr != "" {
+									scopeKey = cityStr
+								}
+							}
+							if scopeKey == "" {
+								scopeKey = cityCodeForQuery
+							}
+						}
+						if scopeKey == "" {
+							scopeKey = c.detectCityCode(ctx, msgLower)
+							if scopeKey == "" {
+								scopeKey = c.detectRegionCode(ctx, msgLower)
+							}
+						}
+
+						// Ensure toolData is initialized
+						if toolData == nil {
+							toolData = map[string]any{}
+						}
+
+						// Create a custom empty data marker
+						emptyMessage := fmt.Sprintf("No statistical data found for %s. The database returned empty results, not an access error.",
+							scopeKey)
+
+						// Store empty response info directly in toolData
+						emptyData := map[string]any{
+							"found":    true,
+							"endpoint": "pop_stats",
+							"message":  emptyMessage,
+						}
+
+						toolData["empty_data"] = emptyData
+
+						// Create a user-friendly empty entry instead of null
+						emptyNote := map[string]any{
+							"note":              "This is an empty data response, not an access restriction",
+							"empty_data_notice": "true",
+							"city":              scopeKey,
+							"message":           "No statistics data found for the specified parameters. The data may not exist yet.",
+							"items":             []any{}, // Empty array instead of null
+						}
+						toolData["pop_stats"] = emptyNote
+					} else {
+						// Clear any stale empty markers once we have data.
+						if toolData != nil {
+							delete(toolData, "empty_data")
+						}
+						toolData["pop_stats"] = parsed
+					}
+				}
+			}
+		} else {
+			// Regular POP data prefetch
+			cityCode := cityCodeForQuery
+			regionCode := regionCodeForQuery
+
+			// If user didn't explicitly say "city" and we have a region match, prefer region.
+			useRegion := false
+			if regionCode != "" {
+				if strings.Contains(msgLower, "region") || !strings.Contains(msgLower, "city") {
+					useRegion = true
+				}
+			}
+
+			// Construct the query path
+			queryPath := "/pop"
+			if useRegion {
+				queryPath += "?region=" + regionCode + "&page=1&page_size=1"
+			} else if cityCode != "" {
+				queryPath += "?city=" + cityCode + "&page=1&page_size=1"
+			} else if regionCode != "" {
+				queryPath += "?region=" + regionCode + "&page=1&page_size=1"
+			}
+
+			debugLogf("gateway GET %s", queryPath)
+			status, body, err := c.Gateway.Get(ctx, queryPath)
+			debugLogf("gateway GET %s -> status=%d err=%v", queryPath, status, err)
+			step := models.Step{Tool: "popData", Status: status}
+			if useRegion && regionCode != "" {
+				step.CampaignID = regionCode // Reuse this field for region/city code
+			} else if cityCode != "" {
+				step.CampaignID = cityCode // Reuse this field for region/city code
+			} else if regionCode != "" {
+				step.CampaignID = regionCode // Reuse this field for region/city code
+			}
+			if err != nil {
+				step.Error = err.Error()
+			} else {
+				step.Body = c.clipStep(strings.TrimSpace(string(body)))
+			}
+			steps = append(steps, step)
+			if err == nil && status >= 200 && status < 300 {
+				var parsed any
+				if json.Unmarshal(body, &parsed) == nil {
+					if toolData == nil {
+						toolData = map[string]any{}
+					}
+					toolData["pop_data"] = parsed
+				}
+			}
+		}
+	}
+
+	if data.CampaignImpressions == nil {
+		data = nil
+	}
+	return data, steps, toolData
+}
+
+func isPosterFootprintIntent(msgLower string) bool {
+	if strings.Contains(msgLower, "played") || strings.Contains(msgLower, "co-play") || strings.Contains(msgLower, "coplay") {
+		return false
+	}
+	if !(strings.Contains(msgLower, "poster") || strings.Contains(msgLower, "creative") || strings.Contains(msgLower, " ad ")) {
+		return false
+	}
+	if strings.Contains(msgLower, "footprint") || strings.Contains(msgLower, "assigned to") || strings.Contains(msgLower, "scheduled on") {
+		return true
+	}
+	if !(strings.Contains(msgLower, "where is") || strings.Contains(msgLower, "where's") || strings.Contains(msgLower, "which kiosks") || strings.Contains(msgLower, "which devices")) {
+		return false
+	}
+	for _, k := range []string{"running", "assigned", "scheduled", "deployed", "live", "targeted", "currently"} {
+		if strings.Contains(msgLower, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractFootprintPosterName pulls the poster out of "where is the Bet 365
+// poster running" or "where is poster Bet 365 assigned".
+func extractFootprintPosterName(msg string) string {
+	name := ""
+	for _, k := range []string{"footprint of", "footprint for", "where is poster", "where's poster", "where is creative", "where is the", "where's the", "where is", "where's", "which kiosks is", "which devices is", "which kiosks are", "which devices are"} {
+		if v := extractAfterKeywordOriginal(msg, k); v != "" {
+			name = v
+			break
+		}
+	}
+	name = strings.TrimSpace(strings.TrimRight(name, "?.!"))
+	lower := strings.ToLower(name)
+	for _, sep := range []string{" currently", " running", " assigned", " scheduled", " deployed", " live", " targeted", " in ", " on "} {
+		if i := strings.Index(lower, sep); i >= 0 {
+			name = strings.TrimSpace(name[:i])
+			lower = strings.ToLower(name)
+		}
+	}
+	for _, w := range []string{" poster", " creative", " ad"} {
+		if strings.HasSuffix(lower, w) {
+			name = strings.TrimSpace(name[:len(name)-len(w)])
+			lower = strings.ToLower(name)
+		}
+	}
+	for _, w := range []string{"poster ", "creative ", "the "} {
+		if strings.HasPrefix(lower, w) {
+			name = strings.TrimSpace(name[len(w):])
+			lower = strings.ToLower(name)
+		}
+	}
+	switch lower {
+	case "it", "this", "that", "poster", "this poster", "that poster":
+		return ""
+	}
+	return strings.Trim(name, "'\" ")
+}
+
+type assignedDevice struct {
+	Host   string
+	Name   string
+	City   string
+	Region string
+}
+
+func anyString(m map[string]any, keys ...string) string {
+	for _, k := range keys {
+		switch v := m[k].(type) {
+		case string:
+			if s := strings.TrimSpace(v); s != "" {
+				return s
+			}
+		case float64:
+			return strconv.FormatInt(int64(v), 10)
+		}
+	}
+	return ""
+}
+
+// stringList accepts either a JSON array or a comma-separated string.
+func stringList(v any) []string {
+	out := make([]string, 0)
+	switch t := v.(type) {
+	case string:
+		for _, p := range strings.Split(t, ",") {
+			if s := strings.TrimSpace(p); s != "" {
+				out = append(out, s)
+			}
+		}
+	case []any:
+		for _, it := range t {
+			if s, ok := it.(string); ok && strings.TrimSpace(s) != "" {
+				out = append(out, strings.TrimSpace(s))
+			}
+		}
+	}
+	return out
+}
+
+// parseAssignedDevices reads a device targeting list from a creative or
+// campaign record. Deployments expose either host strings or device objects,
+// under a few different keys.
+func parseAssignedDevices(m map[string]any) []assignedDevice {
+	if m == nil {
+		return nil
+	}
+	var raw any
+	for _, k := range []string{"devices", "selected_devices", "device_ids", "hosts", "host_names"} {
+		if v, ok := m[k]; ok && v != nil {
+			raw = v
+			break
+		}
+	}
+	if raw == nil {
+		if t, ok := m["targeting"].(map[string]any); ok {
+			return parseAssignedDevices(t)
+		}
+		return nil
+	}
+	out := make([]assignedDevice, 0)
+	seen := map[string]struct{}{}
+	add := func(d assignedDevice) {
+		d.Host = strings.ToLower(strings.TrimSpace(d.Host))
+		if d.Host == "" {
+			return
+		}
+		if _, ok := seen[d.Host]; ok {
+			return
+		}
+		seen[d.Host] = struct{}{}
+		out = append(out, d)
+	}
+	if arr, ok := raw.([]any); ok {
+		for _, it := range arr {
+			switch v := it.(type) {
+			case string:
+				add(assignedDevice{Host: v})
+			case map[string]any:
+				add(assignedDevice{
+					Host:   anyString(v, "host_name", "server_id", "host", "hostName"),
+					Name:   anyString(v, "name", "kiosk_name", "display_name"),
+					City:   strings.ToLower(anyString(v, "city", "city_code")),
+					Region: strings.ToLower(anyString(v, "region", "region_code")),
+				})
+			}
+		}
+		return out
+	}
+	for _, h := range stringList(raw) {
+		add(assignedDevice{Host: h})
+	}
+	return out
+}
+
+func (c *ChatService) handlePosterFootprint(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !isPosterFootprintIntent(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+	reply := func(answer string, steps []models.Step) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(answer)
+		}
+		return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	query := extractFootprintPosterName(req.Message)
+	if id := extractCampaignID(req.Message); looksLikeUUID(id) {
+		query = id
+	}
+	if query == "" && conversationID != "" {
+		if st := c.getConversationState(conversationID); st != nil {
+			if strings.TrimSpace(st.PosterID) != "" {
+				query = strings.TrimSpace(st.PosterID)
+			} else {
+				query = strings.TrimSpace(st.PosterName)
+			}
+		}
+	}
+	if query == "" {
+		return reply("Which poster? For example: \"where is the Bet 365 poster running\".", nil)
+	}
+
+	// 1) Resolve the creative.
+	steps := make([]models.Step, 0, 4)
+	status, body, err := c.Gateway.Get(ctx, "/ads/creatives/search?query="+urlEscape(query))
+	step := models.Step{Tool: "adsCreativesSearch", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+	} else {
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	}
+	steps = append(steps, step)
+	if err != nil {
+		return reply("Failed to search creatives: "+err.Error(), steps)
+	}
+	if status < 200 || status >= 300 {
+		return reply(fmt.Sprintf("Creative search failed with status %d.", status), steps)
+	}
+	var creative map[string]any
+	bestScore := 0
+	for _, it := range parseRows(body) {
+		m, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		id := anyString(m, "id")
+		score := scoreDeviceNameMatch(query, anyString(m, "name"), anyString(m, "file_name"))
+		if strings.EqualFold(id, query) {
+			score = 1000
+		}
+		if creative == nil || score > bestScore {
+			creative, bestScore = m, score
+		}
+	}
+	if creative == nil {
+		return reply(fmt.Sprintf("No poster/creative matched '%s'.", query), steps)
+	}
+	creativeID := anyString(creative, "id")
+	creativeName := anyString(creative, "name")
+	if creativeName == "" {
+		creativeName = creativeID
+	}
+	campaignID := anyString(creative, "campaign_id", "campaignId")
+
+	// 2) Fetch the campaign for its targeting (and name).
+	var campaign map[string]any
+	if campaignID != "" {
+		status, body, err := c.Gateway.Get(ctx, "/ads/campaigns/"+urlEscape(campaignID))
+		step := models.Step{Tool: "adsCampaignGet", CampaignID: campaignID, Status: status}
+		if err != nil {
+			step.Error = err.Error()
+		} else {
+			step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		}
+		steps = append(steps, step)
+		if err == nil && status >= 200 && status < 300 {
+			var root map[string]any
+			if json.Unmarshal(body, &root) == nil {
+				if d, ok := root["data"].(map[string]any); ok {
+					campaign = d
+				} else {
+					campaign = root
+				}
+			}
+		}
+	}
+
+	if conversationID != "" {
+		c.updateConversationPoster(conversationID, creativeName, "", "")
+		c.updateConversationPosterID(conversationID, creativeID)
+		c.updateConversationCampaignID(conversationID, campaignID)
+	}
+
+	devices := parseAssignedDevices(creative)
+	source := "creative"
+	if len(devices) == 0 {
+		devices = parseAssignedDevices(campaign)
+		source = "campaign"
+	}
+	days := stringList(creative["selected_days"])
+	slots := stringList(creative["time_slots"])
+	if len(days) == 0 && campaign != nil {
+		days = stringList(campaign["selected_days"])
+	}
+	if len(slots) == 0 && campaign != nil {
+		slots = stringList(campaign["time_slots"])
+	}
+
+	label := creativeName
+	if campaignID != "" {
+		campaignName := ""
+		if campaign != nil {
+			campaignName = anyString(campaign, "name")
+		}
+		if campaignName != "" {
+			label += fmt.Sprintf(" (campaign %s, %s)", campaignName, campaignID)
+		} else {
+			label += fmt.Sprintf(" (campaign %s)", campaignID)
+		}
+	}
+	if len(devices) == 0 {
+		return reply(fmt.Sprintf("Poster %s has no device assignment exposed on its creative or campaign. To see where it has recently played, ask for its POP analytics instead.", label), steps)
+	}
+
+	// 3) Quick offline cross-check against /metrics/latest.
+	checkHosts := map[string]struct{}{}
+	for i, d := range devices {
+		if i >= 30 {
+			break
+		}
+		checkHosts[d.Host] = struct{}{}
+	}
+	offline, checked, metricSteps := c.offlineAmong(ctx, checkHosts)
+	steps = append(steps, metricSteps...)
+
+	groups := map[string][]assignedDevice{}
+	for _, d := range devices {
+		key := strings.ToUpper(d.Region)
+		if d.City != "" {
+			key = strings.ToUpper(d.City)
+		}
+		if key == "" {
+			key = cityFromDeviceKey(d.Host)
+		}
+		if key == "" {
+			key = "Unknown location"
+		}
+		groups[key] = append(groups[key], d)
+	}
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(groups[keys[i]]) != len(groups[keys[j]]) {
+			return len(groups[keys[i]]) > len(groups[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+
+	lines := []string{fmt.Sprintf("Poster %s is currently assigned to %d device(s) (from the %s's targeting — this is the schedule, not play history):", label, len(devices), source)}
+	for _, k := range keys {
+		hosts := make([]string, 0, len(groups[k]))
+		for i, d := range groups[k] {
+			if i >= c.limits().DisplayTopN {
+				hosts = append(hosts, fmt.Sprintf("+%d more", len(groups[k])-i))
+				break
+			}
+			h := d.Host
+			if d.Name != "" && !strings.EqualFold(d.Name, d.Host) {
+				h = d.Name + " (" + d.Host + ")"
+			}
+			hosts = append(hosts, h)
+		}
+		lines = append(lines, fmt.Sprintf("- %s: %d — %s", k, len(groups[k]), strings.Join(hosts, ", ")))
+	}
+	if len(days) > 0 {
+		lines = append(lines, "Days: "+strings.Join(days, ", "))
+	}
+	if len(slots) > 0 {
+		lines = append(lines, "Time slots: "+strings.Join(slots, ", "))
+	}
+	if checked > 0 {
+		note := fmt.Sprintf("Currently offline: %d of %d checked assigned device(s)", len(offline), checked)
+		if len(offline) > 0 {
+			sort.Strings(offline)
+			// The assignment already carries kiosk names, so no extra lookup.
+			names := map[string]string{}
+			for _, d := range devices {
+				if d.Name != "" && !strings.EqualFold(d.Name, d.Host) {
+					names[d.Host] = d.Name + " (" + d.Host + ")"
+				}
+			}
+			for i, h := range offline {
+				if n, ok := names[h]; ok {
+					offline[i] = n
+				}
+			}
+			if len(offline) > c.limits().DisplayTopN {
+				offline = append(offline[:c.limits().DisplayTopN], "…")
+			}
+			note += " (" + strings.Join(offline, ", ") + ")"
+		}
+		if len(devices) > checked {
+			note += fmt.Sprintf("; only the first %d were checked", checked)
+		}
+		lines = append(lines, note+".")
+	}
+	lines = append(lines, "Ask \"where has it played\" for recent POP history.")
+	return reply(strings.Join(lines, "\n"), steps)
+}
+
+// offlineAmong scans /metrics/latest and reports which of the given hosts
+// look offline (no metrics in 15 minutes, missing, or power offline).
+func (c *ChatService) offlineAmong(ctx context.Context, hosts map[string]struct{}) ([]string, int, []models.Step) {
+	if len(hosts) == 0 {
+		return nil, 0, nil
+	}
+	lastSeen := map[string]time.Time{}
+	powerOff := map[string]bool{}
+	steps := make([]models.Step, 0, 2)
+	page := 1
+	pageSize := c.limits().ListPageSize
+	maxPages := c.limits().MetricsMaxPages
+	var fetched int64
+	for {
+		path := fmt.Sprintf("/metrics/latest?page=%d&page_size=%d&include_totals=false", page, pageSize)
+		status, body, err := c.Gateway.Get(ctx, path)
+		step := models.Step{Tool: "metricsLatest", Status: status}
+		if err != nil {
+			step.Error = err.Error()
+		} else {
+			step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		}
+		steps = append(steps, step)
+		if err != nil || status < 200 || status >= 300 {
+			return nil, 0, steps
+		}
+		var payload struct {
+			Data []struct {
+				ServerID    string    `json:"server_id"`
+				Time        time.Time `json:"time"`
+				PowerOnline *bool     `json:"power_online"`
+			} `json:"data"`
+			Pagination gatewayPagination `json:"pagination"`
+		}
+		if json.Unmarshal(body, &payload) != nil {
+			return nil, 0, steps
+		}
+		for _, r := range payload.Data {
+			h := strings.ToLower(strings.TrimSpace(r.ServerID))
+			if _, ok := hosts[h]; !ok {
+				continue
+			}
+			lastSeen[h] = r.Time
+			powerOff[h] = r.PowerOnline != nil && !*r.PowerOnline
+		}
+		fetched += int64(len(payload.Data))
+		if payload.Pagination.done(len(payload.Data), pageSize, fetched) || len(lastSeen) == len(hosts) {
+			break
+		}
+		page++
+		if page > maxPages {
+			break
+		}
+	}
+	offline := make([]string, 0)
+	for h := range hosts {
+		t, ok := lastSeen[h]
+		if !ok || t.IsZero() || time.Since(t) > 15*time.Minute || powerOff[h] {
+			offline = append(offline, h)
+		}
+	}
+	return offline, len(hosts), steps
+}
+
+const (
+	listSortNewest  = "newest"
+	listSortOldest  = "oldest"
+	listSortName    = "name"
+	listSortUpdated = "updated"
+
+	maxListLimit = 100
+)
+
+var (
+	listLimitBeforeRe = regexp.MustCompile(`\b(\d{1,3})\s+(?:newest|oldest|latest|most recent|recent|recently updated|campaigns?|creatives?)\b`)
+	listLimitAfterRe  = regexp.MustCompile(`\b(?:first|last|latest|newest|oldest|show|list)\s+(?:the\s+)?(\d{1,3})\b`)
+)
+
+// extractListLimit reads the requested row count for campaign/creative
+// listings ("top 25", "the 25 newest campaigns", "first 30"), capped at 100.
+func extractListLimit(msgLower string) int {
+	n := extractTopN(msgLower)
+	if n == 0 {
+		for _, re := range []*regexp.Regexp{listLimitBeforeRe, listLimitAfterRe} {
+			if m := re.FindStringSubmatch(msgLower); m != nil {
+				n, _ = strconv.Atoi(m[1])
+				break
+			}
+		}
+	}
+	if n > maxListLimit {
+		n = maxListLimit
+	}
+	return n
+}
+
+func parseListSort(msgLower string) string {
+	switch {
+	case strings.Contains(msgLower, "recently updated") || strings.Contains(msgLower, "last updated") || strings.Contains(msgLower, "by updated"):
+		return listSortUpdated
+	case strings.Contains(msgLower, "by name") || strings.Contains(msgLower, "alphabetical") || strings.Contains(msgLower, "a-z"):
+		return listSortName
+	case strings.Contains(msgLower, "oldest"):
+		return listSortOldest
+	case strings.Contains(msgLower, "newest") || strings.Contains(msgLower, "latest") || strings.Contains(msgLower, "most recent") || strings.Contains(msgLower, "recent"):
+		return listSortNewest
+	}
+	return ""
+}
+
+func listSortLabel(mode string) string {
+	switch mode {
+	case listSortNewest:
+		return "newest first"
+	case listSortOldest:
+		return "oldest first"
+	case listSortName:
+		return "by name"
+	case listSortUpdated:
+		return "recently updated first"
+	}
+	return "default order"
+}
+
+func rowTime(m map[string]any, keys ...string) (time.Time, bool) {
+	for _, k := range keys {
+		s, _ := m[k].(string)
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// sortListRows orders gateway rows client-side. It reports false (leaving the
+// order untouched) when no row carries the field the sort needs.
+func sortListRows(rows []any, mode string) bool {
+	if mode == "" {
+		return true
+	}
+	type keyed struct {
+		row  any
+		t    time.Time
+		name string
+		ok   bool
+	}
+	items := make([]keyed, len(rows))
+	found := false
+	for i, it := range rows {
+		k := keyed{row: it}
+		if m, ok := it.(map[string]any); ok {
+			switch mode {
+			case listSortName:
+				k.name = foldText(anyString(m, "name"))
+				k.ok = k.name != ""
+			case listSortUpdated:
+				k.t, k.ok = rowTime(m, "updated_at", "updatedAt")
+			default:
+				k.t, k.ok = rowTime(m, "created_at", "createdAt")
+			}
+		}
+		found = found || k.ok
+		items[i] = k
+	}
+	if !found {
+		return false
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.ok != b.ok {
+			return a.ok
+		}
+		switch mode {
+		case listSortName:
+			return a.name < b.name
+		case listSortOldest:
+			return a.t.Before(b.t)
+		default:
+			return a.t.After(b.t)
+		}
+	})
+	for i := range items {
+		rows[i] = items[i].row
+	}
+	return true
+}
+
+func listSortField(mode string) string {
+	switch mode {
+	case listSortName:
+		return "name"
+	case listSortUpdated:
+		return "updated_at"
+	}
+	return "created_at"
+}
+
+// listHeaderSuffix echoes the applied limit and sort, e.g.
+// " (showing up to 25, newest first)".
+func listHeaderSuffix(limit int, mode string, sorted bool) string {
+	if !sorted {
+		return fmt.Sprintf(" (showing up to %d; rows have no %s, so the default order was used)", limit, listSortField(mode))
+	}
+	return fmt.Sprintf(" (showing up to %d, %s)", limit, listSortLabel(mode))
+}
+
+func isCampaignPacingIntent(msgLower string) bool {
+	for _, k := range []string{"pacing", "on track", "on-track", "delivery vs goal", "delivery versus goal", "delivery against goal"} {
+		if strings.Contains(msgLower, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripPacingPhrases leaves just the campaign reference for name resolution
+// ("is campaign spring sale on track?" -> "is campaign spring sale").
+func stripPacingPhrases(msgLower string) string {
+	s := msgLower
+	for _, k := range []string{"delivery vs goal", "delivery versus goal", "delivery against goal", "on-track", "on track", "pacing"} {
+		s = strings.ReplaceAll(s, k, " ")
+	}
+	s = strings.TrimRight(strings.TrimSpace(s), "?.!")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// flightDate reads a campaign flight date. Date-only end dates cover the
+// whole day, so they are moved to the following midnight.
+func flightDate(m map[string]any, end bool, keys ...string) (time.Time, bool) {
+	t, ok := rowTime(m, keys...)
+	if !ok {
+		return time.Time{}, false
+	}
+	if end && t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0 {
+		t = t.Add(24 * time.Hour)
+	}
+	return t, true
+}
+
+// computePacing compares actual impressions with linear delivery of goal over
+// [start, end). Projections use the run rate so far; before the flight starts
+// nothing is expected and nothing is projected.
+func computePacing(goal, actual int64, start, end, now time.Time) models.CampaignPacing {
+	p := models.CampaignPacing{Goal: goal, FlightStart: start, FlightEnd: end}
+	flight := end.Sub(start)
+	switch {
+	case now.Before(start):
+		p.Status = "not_started"
+		p.DaysRemaining = int(math.Ceil(flight.Hours() / 24))
+		return p
+	case !now.Before(end):
+		p.Status = "ended"
+		p.ExpectedToDate = goal
+		p.ProjectedTotal = actual
+	default:
+		p.Status = "in_flight"
+		frac := float64(now.Sub(start)) / float64(flight)
+		p.ExpectedToDate = int64(math.Round(float64(goal) * frac))
+		p.ProjectedTotal = int64(math.Round(float64(actual) / frac))
+		p.DaysRemaining = int(math.Ceil(end.Sub(now).Hours() / 24))
+	}
+	if p.ExpectedToDate > 0 {
+		p.PacingPercent = math.Round(float64(actual)/float64(p.ExpectedToDate)*1000) / 10
+	}
+	return p
+}
+
+// handleCampaignPacing answers "is campaign X on track?" by comparing lifetime
+// impressions with the campaign's booked goal and flight dates.
+func (c *ChatService) handleCampaignPacing(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !isCampaignPacingIntent(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	campaignID := c.resolveCampaignID(ctx, stripPacingPhrases(msgLower))
+	if !looksLikeUUID(campaignID) && conversationID != "" {
+		if st := c.getConversationState(conversationID); st != nil && looksLikeUUID(st.CampaignID) {
+			campaignID = st.CampaignID
+		}
+	}
+	if !looksLikeUUID(campaignID) {
+		return reply(models.ChatResponse{Answer: "Which campaign? Ask like: is campaign <name> on track, or give the campaign id."})
+	}
+	if conversationID != "" {
+		c.updateConversationCampaignID(conversationID, campaignID)
+		c.rememberUnit(conversationID, "impressions")
+	}
+
+	steps := make([]models.Step, 0, 2)
+	campaign, step, failure := c.fetchCampaign(ctx, campaignID)
+	steps = append(steps, step)
+	if failure != "" {
+		return reply(models.ChatResponse{Answer: failure, Steps: steps})
+	}
+	name := anyString(campaign, "name", "campaign_name")
+	if name == "" {
+		name = campaignID
+	}
+
+	goal := int64(floatField(campaign, "impressions_goal", "impression_goal", "goal_impressions", "booked_impressions", "target_impressions", "goal"))
+	start, hasStart := flightDate(campaign, false, "start_date", "flight_start", "starts_at", "start_at")
+	end, hasEnd := flightDate(campaign, true, "end_date", "flight_end", "ends_at", "end_at")
+	missing := make([]string, 0, 3)
+	if goal <= 0 {
+		missing = append(missing, "an impressions goal")
+	}
+	if !hasStart {
+		missing = append(missing, "a start date")
+	}
+	if !hasEnd {
+		missing = append(missing, "an end date")
+	}
+	if len(missing) > 0 {
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("Campaign %s has no %s on record, so pacing can't be computed.", name, strings.Join(missing, " or ")), Steps: steps})
+	}
+	if !end.After(start) {
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("Campaign %s has an end date (%s) that is not after its start date (%s), so pacing can't be computed.", name, end.Format("2006-01-02"), start.Format("2006-01-02")), Steps: steps})
+	}
+
+	status, body, err := c.Gateway.Get(ctx, "/ads/campaigns/"+urlEscape(campaignID)+"/impressions")
+	step = models.Step{Tool: "adsCampaignImpressions", CampaignID: campaignID, Status: status}
+	if err != nil {
+		step.Error = err.Error()
+	} else {
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	}
+	steps = append(steps, step)
+	if err != nil {
+		return reply(models.ChatResponse{Answer: "Failed to fetch campaign impressions: " + err.Error(), Steps: steps})
+	}
+	if status < 200 || status >= 300 {
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("Failed to fetch campaign impressions (status %d).", status), Steps: steps})
+	}
+	var imp gwCampaignImpressionsResponse
+	if json.Unmarshal(body, &imp) != nil || imp.Data == nil {
+		return reply(models.ChatResponse{Answer: "Campaign impressions response could not be parsed.", Steps: steps})
+	}
+	actual := imp.Data.Impressions
+
+	p := computePacing(goal, actual, start, end, time.Now().UTC())
+	flight := fmt.Sprintf("%s to %s", start.Format("2006-01-02"), end.Add(-time.Second).Format("2006-01-02"))
+	lines := make([]string, 0, 4)
+	switch p.Status {
+	case "not_started":
+		lines = append(lines, fmt.Sprintf("Campaign %s has not started yet (flight %s, %d days). Goal: %s impressions; %s delivered so far.", name, flight, p.DaysRemaining, formatThousands(goal), formatThousands(actual)))
+	case "ended":
+		lines = append(lines, fmt.Sprintf("Campaign %s has ended (flight %s). Delivered %s of %s goal impressions (%s).", name, flight, formatThousands(actual), formatThousands(goal), formatPercent(p.PacingPercent)))
+	default:
+		verdict := "on track"
+		if p.PacingPercent < 95 {
+			verdict = "behind pace"
+		} else if p.PacingPercent > 110 {
+			verdict = "ahead of pace"
+		}
+		lines = append(lines, fmt.Sprintf("Campaign %s is %s: %s pacing (%s delivered vs %s expected to date).", name, verdict, formatPercent(p.PacingPercent), formatThousands(actual), formatThousands(p.ExpectedToDate)))
+		lines = append(lines, fmt.Sprintf("Goal: %s impressions over %s; %d days remaining.", formatThousands(goal), flight, p.DaysRemaining))
+		lines = append(lines, fmt.Sprintf("Projected end-of-flight total at the current run rate: %s (%s of goal).", formatThousands(p.ProjectedTotal), formatPercent(float64(p.ProjectedTotal)/float64(goal)*100)))
+	}
+	data := &models.ChatData{CampaignImpressions: &models.CampaignImpressions{CampaignID: campaignID, Impressions: actual, Pacing: &p}}
+	return reply(models.ChatResponse{Answer: strings.Join(lines, "\n"), Data: data, Steps: steps})
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	testFallID   = "a1a1a1a1-0000-4000-8000-000000000001"
+	testSpringID = "a1a1a1a1-0000-4000-8000-000000000002"
+	testLaunchID = "a1a1a1a1-0000-4000-8000-000000000003"
+)
+
+func newCampaignGateway(t *testing.T) *fakeGateway {
+	return newFakeGateway(t, &fakeGateway{Routes: map[string]string{
+		"/ads/campaigns/search": `{"data":[` +
+			`{"id":"` + testFallID + `","name":"Bet 365 Fall"},` +
+			`{"id":"` + testSpringID + `","name":"Bet 365 Spring"},` +
+			`{"id":"` + testLaunchID + `","name":"Lorla Studio Launch"}]}`,
+		"/ads/creatives/campaign/" + testFallID:   `{"data":[{"id":"cr-fall","name":"Fall hero"}]}`,
+		"/ads/creatives/campaign/" + testSpringID: `{"data":[{"id":"cr-spring","name":"Spring hero"}]}`,
+		"/ads/creatives/campaign/" + testLaunchID: `{"data":[{"id":"cr-launch","name":"Launch hero"}]}`,
+	}})
+}
+
+func TestCampaignMatchDecisive(t *testing.T) {
+	g := newCampaignGateway(t)
+	answer := chatOnce(t, newTestChat(g), "show Lorla Studio campaign creatives").Answer
+	for _, want := range []string{"Interpreted 'lorla studio' as 'Lorla Studio Launch'.", "Creatives for campaign " + testLaunchID} {
+		if !strings.Contains(answer, want) {
+			t.Errorf("answer\n%s\nlacks %q", answer, want)
+		}
+	}
+	if calls := g.Calls("/ads/creatives/campaign/"); len(calls) != 1 {
+		t.Errorf("creative calls %v, want one for the matched campaign", calls)
+	}
+}
+
+func TestCampaignMatchWeakAsksThenFollowsUp(t *testing.T) {
+	for _, tc := range []struct {
+		reply  string
+		wantID string
+	}{
+		{"2", testSpringID},
+		{"spring", testSpringID},
+		{testFallID, testFallID},
+	} {
+		t.Run(tc.reply, func(t *testing.T) {
+			g := newCampaignGateway(t)
+			c := newTestChat(g)
+			c.Store = newMemStore()
+
+			answer := chatTurn(t, c, "conv-1", "show bet 365 campaign creatives")
+			for _, want := range []string{
+				"I'm not sure which campaign you mean by 'bet 365'. Did you mean one of these?",
+				"1. Bet 365 Fall (" + testFallID + ")",
+				"2. Bet 365 Spring (" + testSpringID + ")",
+				"Reply with the number, name, or id.",
+			} {
+				if !strings.Contains(answer, want) {
+					t.Errorf("clarification\n%s\nlacks %q", answer, want)
+				}
+			}
+			if strings.Contains(answer, "Lorla") {
+				t.Errorf("clarification offers a non-matching campaign:\n%s", answer)
+			}
+			if calls := g.Calls("/ads/creatives/"); len(calls) != 0 {
+				t.Fatalf("fetched creatives before the user chose: %v", calls)
+			}
+
+			answer = chatTurn(t, c, "conv-1", tc.reply)
+			if !strings.Contains(answer, "Creatives for campaign "+tc.wantID) {
+				t.Errorf("reply %q answered\n%s\nwant creatives for %s", tc.reply, answer, tc.wantID)
+			}
+			if st := c.getConversationState("conv-1"); st.PendingHandler != "" || len(st.PendingCampaigns) != 0 {
+				t.Errorf("selection left pending state %q %v", st.PendingHandler, st.PendingCampaigns)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// normalizeCampaignStatus maps a gateway status onto the filter vocabulary
+// ("running" is active, "completed" is ended); unknown statuses are kept
+// lowercased.
+func normalizeCampaignStatus(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return ""
+	}
+	if st := extractStatusFilter(s); st != "" {
+		return st
+	}
+	return s
+}
+
+// campaignStatusClass folds date-derived statuses into the gateway status
+// they correspond to, so "live" matches "active" and "upcoming" matches
+// "scheduled".
+func campaignStatusClass(status string) string {
+	switch status {
+	case "live":
+		return "active"
+	case "upcoming":
+		return "scheduled"
+	}
+	return status
+}
+
+// campaignDay is the calendar day of a campaign date in loc. Date-only values
+// are already calendar days and are not shifted.
+func campaignDay(m map[string]any, loc *time.Location, keys ...string) (time.Time, bool) {
+	t, ok := rowTime(m, keys...)
+	if !ok {
+		return time.Time{}, false
+	}
+	if t.Location() != time.UTC || t.Hour() != 0 || t.Minute() != 0 || t.Second() != 0 || t.Nanosecond() != 0 {
+		t = t.In(loc)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), true
+}
+
+// datedCampaignStatus derives a status from the flight dates: upcoming before
+// the start day, ended after the end day, live in between (both days
+// included). It is empty when the row has neither date.
+func datedCampaignStatus(m map[string]any, now time.Time, loc *time.Location) string {
+	n := now.In(loc)
+	today := time.Date(n.Year(), n.Month(), n.Day(), 0, 0, 0, 0, time.UTC)
+	start, hasStart := campaignDay(m, loc, "start_date", "flight_start", "starts_at", "start_at")
+	end, hasEnd := campaignDay(m, loc, "end_date", "flight_end", "ends_at", "end_at")
+	switch {
+	case hasStart && start.After(today):
+		return "upcoming"
+	case hasEnd && end.Before(today):
+		return "ended"
+	case hasStart || hasEnd:
+		return "live"
+	}
+	return ""
+}
+
+// effectiveCampaignStatus is the status a campaign row is listed and filtered
+// with, and whether it was derived from the flight dates. The gateway's status
+// wins, except that rows without one are dated, and so is every row when the
+// filter is upcoming or ended, which the gateway does not report. Rows with
+// neither are "unknown".
+func effectiveCampaignStatus(m map[string]any, filter string, now time.Time, loc *time.Location) (string, bool) {
+	reported := normalizeCampaignStatus(anyString(m, "status"))
+	dated := datedCampaignStatus(m, now, loc)
+	dateFilter := filter == "upcoming" || filter == "ended"
+	switch {
+	case dated != "" && reported == "":
+		return dated, true
+	case dated != "" && dateFilter && campaignStatusClass(reported) != campaignStatusClass(dated):
+		return dated, true
+	case reported != "":
+		return reported, false
+	}
+	return "unknown", false
+}
+
+// campaignStatusMatches reports whether status satisfies filter.
+func campaignStatusMatches(filter, status string) bool {
+	return campaignStatusClass(filter) == campaignStatusClass(status)
+}
+
+// campaignStatusLabel renders a status for a listing line, marking statuses
+// derived from dates.
+func campaignStatusLabel(status string, fromDates bool) string {
+	if fromDates {
+		return status + " (from dates)"
+	}
+	return status
+}
+
+// unknownCampaignsNote names the campaigns a status filter could not place
+// because they have neither a status nor flight dates.
+func unknownCampaignsNote(names []string) string {
+	shown := names
+	if len(shown) > 5 {
+		shown = shown[:5]
+	}
+	note := fmt.Sprintf("Unknown status (no status or flight dates): %d campaign(s) — %s", len(names), strings.Join(shown, ", "))
+	if len(names) > len(shown) {
+		note += fmt.Sprintf(" and %d more", len(names)-len(shown))
+	}
+	return note + "."
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// ChatError is how a failed chat request is reported: the HTTP status of
+// POST /chat, the error code that /chat, /chat/stream and async jobs share,
+// and whether the same request may succeed later.
+type ChatError struct {
+	Status    int
+	Code      string
+	Retryable bool
+}
+
+// ClassifyChatError maps an error returned by Chat or ChatStream to its
+// ChatError; anything unrecognized is a 500 chat_failed.
+func ClassifyChatError(err error) ChatError {
+	var ne net.Error
+	switch {
+	case errors.Is(err, ErrConversationForbidden):
+		return ChatError{http.StatusForbidden, "conversation_forbidden", false}
+	case errors.Is(err, ErrGatewayUnavailable):
+		return ChatError{http.StatusServiceUnavailable, "gateway_unavailable", true}
+	case errors.Is(err, ErrGatewayAuth):
+		return ChatError{http.StatusBadGateway, "gateway_auth_failed", false}
+	case errors.Is(err, ErrDuplicateInFlight):
+		return ChatError{http.StatusConflict, "duplicate_in_flight", true}
+	case errors.Is(err, ErrInvalidIdempotencyKey):
+		return ChatError{http.StatusBadRequest, "invalid_idempotency_key", false}
+	case errors.Is(err, ErrIdempotencyKeyReused):
+		return ChatError{http.StatusUnprocessableEntity, "idempotency_key_reused", false}
+	case errors.Is(err, ErrIdempotencyKeyLost):
+		// Nothing was changed; a retry with a fresh key runs again.
+		return ChatError{http.StatusConflict, "idempotency_key_lost", true}
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()):
+		return ChatError{http.StatusGatewayTimeout, "timeout", true}
+	case errors.Is(err, ErrOpenAIFailed):
+		return ChatError{http.StatusBadGateway, "openai_failed", true}
+	case errors.Is(err, ErrInternal):
+		return ChatError{http.StatusInternalServerError, "internal_error", false}
+	}
+	return ChatError{http.StatusInternalServerError, "chat_failed", false}
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// timeoutErr is a net.Error that timed out.
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func TestClassifyChatError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want ChatError
+	}{
+		{ErrConversationForbidden, ChatError{http.StatusForbidden, "conversation_forbidden", false}},
+		{ErrGatewayUnavailable, ChatError{http.StatusServiceUnavailable, "gateway_unavailable", true}},
+		{ErrGatewayAuth, ChatError{http.StatusBadGateway, "gateway_auth_failed", false}},
+		{ErrDuplicateInFlight, ChatError{http.StatusConflict, "duplicate_in_flight", true}},
+		{ErrInvalidIdempotencyKey, ChatError{http.StatusBadRequest, "invalid_idempotency_key", false}},
+		{ErrIdempotencyKeyReused, ChatError{http.StatusUnprocessableEntity, "idempotency_key_reused", false}},
+		{ErrIdempotencyKeyLost, ChatError{http.StatusConflict, "idempotency_key_lost", true}},
+		{fmt.Errorf("upload: %w", ErrIdempotencyKeyLost), ChatError{http.StatusConflict, "idempotency_key_lost", true}},
+		{context.DeadlineExceeded, ChatError{http.StatusGatewayTimeout, "timeout", true}},
+		{fmt.Errorf("pop: %w", timeoutErr{}), ChatError{http.StatusGatewayTimeout, "timeout", true}},
+		{ErrOpenAIFailed, ChatError{http.StatusBadGateway, "openai_failed", true}},
+		{ErrInternal, ChatError{http.StatusInternalServerError, "internal_error", false}},
+		{errors.New("something else"), ChatError{http.StatusInternalServerError, "chat_failed", false}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			if got := ClassifyChatError(tc.err); got != tc.want {
+				t.Errorf("ClassifyChatError(%v) = %+v, want %+v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"openai-agent-service/internal/models"
+)
+
+// ChatJobStore persists async chat jobs; implemented by store.PostgresStore.
+type ChatJobStore interface {
+	EnqueueChatJob(ctx context.Context, job models.ChatJob, maxUnfinished int) (models.ChatJob, bool, error)
+	ClaimChatJob(ctx context.Context, lease time.Duration) (models.ChatJob, error)
+	FinishChatJob(ctx context.Context, job models.ChatJob, kind string, payload []byte) error
+	GetChatJob(ctx context.Context, ownerKey, id string) (models.ChatJob, error)
+	DeleteChatJobsBefore(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+var (
+	// ErrAsyncDisabled is returned for async requests when no job store is
+	// configured (ASYNC_WORKERS=0).
+	ErrAsyncDisabled = errors.New("async chat is not enabled on this service")
+	// ErrChatJobQueueFull is returned when the caller already has the maximum
+	// number of unfinished async jobs.
+	ErrChatJobQueueFull = errors.New("too many async chat jobs are queued for this API key, retry when some have finished")
+	// ErrInvalidWebhookURL is returned for a webhook_url that is not an
+	// absolute http or https URL.
+	ErrInvalidWebhookURL = errors.New("webhook_url must be an absolute http or https URL")
+)
+
+const (
+	defaultChatJobWorkers   = 4
+	defaultChatJobInterval  = time.Second
+	defaultMaxQueuedJobs    = 20
+	defaultChatJobRetention = 24 * time.Hour
+	// chatJobTimeout bounds one run; chatJobLease must outlast it so a live
+	// run is never claimed by another worker.
+	chatJobTimeout = 5 * time.Minute
+	chatJobLease   = 10 * time.Minute
+	// maxChatJobAttempts is how many runs a job gets before one that keeps
+	// dying with its worker is failed instead of run again.
+	maxChatJobAttempts = 3
+)
+
+// EnqueueChat queues req to be answered by a ChatJobWorker and returns the
+// queued job. The conversation is authorized now, and the caller's key role
+// is kept, so the job runs as if it had been answered inline.
+func (c *ChatService) EnqueueChat(ctx context.Context, ownerKey string, req models.ChatRequest) (models.ChatJob, error) {
+	if c.Jobs == nil {
+		return models.ChatJob{}, ErrAsyncDisabled
+	}
+	if err := c.authorizeConversation(ctx, ownerKey, req.ConversationID); err != nil {
+		return models.ChatJob{}, err
+	}
+	req.WebhookURL = strings.TrimSpace(req.WebhookURL)
+	if req.WebhookURL != "" {
+		if err := c.Webhooks.Check(ctx, req.WebhookURL); err != nil {
+			return models.ChatJob{}, err
+		}
+	}
+	max := c.MaxQueuedJobs
+	if max <= 0 {
+		max = defaultMaxQueuedJobs
+	}
+	job := models.ChatJob{
+		ID:             uuid.NewString(),
+		OwnerKey:       ownerKey,
+		Role:           keyRole(ctx),
+		ConversationID: strings.TrimSpace(req.ConversationID),
+		Request:        req,
+	}
+	job, ok, err := c.Jobs.EnqueueChatJob(ctx, job, max)
+	if err != nil {
+		return models.ChatJob{}, err
+	}
+	if !ok {
+		return models.ChatJob{}, ErrChatJobQueueFull
+	}
+	return job, nil
+}
+
+// ChatJob returns one of ownerKey's async jobs; sql.ErrNoRows when there is
+// no such job for this caller.
+func (c *ChatService) ChatJob(ctx context.Context, ownerKey, id string) (models.ChatJob, error) {
+	if c.Jobs == nil {
+		return models.ChatJob{}, ErrAsyncDisabled
+	}
+	return c.Jobs.GetChatJob(ctx, ownerKey, id)
+}
+
+// ChatJobWorker answers queued async chat jobs through ChatService, at most
+// Workers at a time on this replica. Jobs are claimed from the store with a
+// lease, so replicas share the queue, a conversation's jobs run one at a time
+// in the order they were queued, and a job whose worker died is run again
+// once its lease expires (jobs that were still queued simply wait for the
+// next worker after a restart).
+type ChatJobWorker struct {
+	Chat  *ChatService
+	Store ChatJobStore
+	// Workers is the number of jobs run at once (default 4).
+	Workers int
+	// Interval between polls when no job is runnable (default 1s).
+	Interval time.Duration
+}
+
+func (w *ChatJobWorker) Run(ctx context.Context) {
+	n := w.Workers
+	if n <= 0 {
+		n = defaultChatJobWorkers
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (w *ChatJobWorker) loop(ctx context.Context) {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = defaultChatJobInterval
+	}
+	for {
+		var ran bool
+		guardTick("chat jobs", func() {
+			var err error
+			ran, err = w.runOnce(ctx)
+			if err != nil {
+				log.Printf("chat jobs: %v", err)
+			}
+		})
+		if ran && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// runOnce claims and runs one job, reporting whether there was one.
+func (w *ChatJobWorker) runOnce(ctx context.Context) (bool, error) {
+	job, err := w.Store.ClaimChatJob(ctx, chatJobLease)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	job = w.run(ctx, job)
+	if ctx.Err() != nil {
+		// Shutting down: leave the job leased so it is run again.
+		return true, nil
+	}
+	var kind string
+	var payload []byte
+	if job.Request.WebhookURL != "" {
+		body, err := json.Marshal(job)
+		if err == nil {
+			payload, err = json.Marshal(outboxWebhook{URL: job.Request.WebhookURL, Body: body})
+		}
+		if err != nil {
+			log.Printf("chat jobs: job %s webhook not queued: %v", job.ID, err)
+		} else {
+			kind = OutboxWebhook
+		}
+	}
+	if err := w.Store.FinishChatJob(ctx, job, kind, payload); err != nil {
+		return true, fmt.Errorf("job %s: %w", job.ID, err)
+	}
+	return true, nil
+}
+
+// run answers a claimed job and returns it with its outcome.
+func (w *ChatJobWorker) run(ctx context.Context, job models.ChatJob) models.ChatJob {
+	if job.Attempts > maxChatJobAttempts {
+		log.Printf("chat jobs: job %s abandoned after %d interrupted runs", job.ID, job.Attempts-1)
+		job.Status = models.ChatJobFailed
+		job.Error = "job_abandoned"
+		job.Message = fmt.Sprintf("the job was interrupted %d times and was not run again", job.Attempts-1)
+		return job
+	}
+	jctx, cancel := context.WithTimeout(WithKeyRole(ctx, job.Role), chatJobTimeout)
+	defer cancel()
+	resp, err := w.Chat.Chat(jctx, job.OwnerKey, job.Request)
+	job.Status = models.ChatJobDone
+	if err != nil {
+		job.Status = models.ChatJobFailed
+		job.Error = ClassifyChatError(err).Code
+		job.Message = err.Error()
+	}
+	if err == nil || strings.TrimSpace(resp.Answer) != "" {
+		job.Response = &resp
+	}
+	return job
+}
+
+// ChatJobJanitor deletes finished async jobs older than Retention on an
+// interval.
+type ChatJobJanitor struct {
+	Store     ChatJobStore
+	Retention time.Duration
+	Interval  time.Duration
+}
+
+func (j *ChatJobJanitor) Run(ctx context.Context) {
+	interval := j.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	retention := j.Retention
+	if retention <= 0 {
+		retention = defaultChatJobRetention
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if n, err := j.Store.DeleteChatJobsBefore(ctx, time.Now().Add(-retention)); err != nil {
+				log.Printf("chat job janitor: %v", err)
+			} else if n > 0 {
+				log.Printf("chat job janitor: deleted %d finished job(s)", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// memChatJobs is an in-memory ChatJobStore with the store's claim rules: the
+// oldest runnable job first, a running job is runnable again once its lease
+// ends, and a conversation's jobs run one at a time in queue order.
+type memChatJobs struct {
+	mu    sync.Mutex
+	jobs  []models.ChatJob
+	lease map[string]time.Time
+	// outbox holds the kind of each entry queued on finish.
+	outbox []string
+}
+
+func (s *memChatJobs) EnqueueChatJob(_ context.Context, job models.ChatJob, maxUnfinished int) (models.ChatJob, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unfinished := 0
+	for _, j := range s.jobs {
+		if j.OwnerKey == job.OwnerKey && (j.Status == models.ChatJobQueued || j.Status == models.ChatJobRunning) {
+			unfinished++
+		}
+	}
+	if maxUnfinished > 0 && unfinished >= maxUnfinished {
+		return models.ChatJob{}, false, nil
+	}
+	job.Status, job.CreatedAt = models.ChatJobQueued, time.Now()
+	s.jobs = append(s.jobs, job)
+	return job, true, nil
+}
+
+func (s *memChatJobs) ClaimChatJob(_ context.Context, lease time.Duration) (models.ChatJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	live := func(j models.ChatJob) bool { return j.Status == models.ChatJobRunning && !s.lease[j.ID].Before(now) }
+	for i, j := range s.jobs {
+		if j.Status != models.ChatJobQueued && (j.Status != models.ChatJobRunning || live(j)) {
+			continue
+		}
+		blocked := false
+		for k, p := range s.jobs {
+			if k == i || j.ConversationID == "" || p.ConversationID != j.ConversationID {
+				continue
+			}
+			if live(p) || (k < i && (p.Status == models.ChatJobQueued || p.Status == models.ChatJobRunning)) {
+				blocked = true
+				break
+			}
+		}
+		if blocked {
+			continue
+		}
+		if s.lease == nil {
+			s.lease = map[string]time.Time{}
+		}
+		j.Status = models.ChatJobRunning
+		j.Attempts++
+		s.lease[j.ID] = now.Add(lease)
+		s.jobs[i] = j
+		return j, nil
+	}
+	return models.ChatJob{}, sql.ErrNoRows
+}
+
+func (s *memChatJobs) FinishChatJob(_ context.Context, job models.ChatJob, kind string, _ []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, j := range s.jobs {
+		if j.ID == job.ID {
+			s.jobs[i] = job
+		}
+	}
+	delete(s.lease, job.ID)
+	if kind != "" {
+		s.outbox = append(s.outbox, kind)
+	}
+	return nil
+}
+
+func (s *memChatJobs) GetChatJob(_ context.Context, ownerKey, id string) (models.ChatJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.ID == id && j.OwnerKey == ownerKey {
+			return j, nil
+		}
+	}
+	return models.ChatJob{}, sql.ErrNoRows
+}
+
+func (s *memChatJobs) DeleteChatJobsBefore(context.Context, time.Time) (int64, error) { return 0, nil }
+
+// expire ends every lease, as if the workers holding them had died.
+func (s *memChatJobs) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id := range s.lease {
+		s.lease[id] = time.Now().Add(-time.Second)
+	}
+}
+
+func (s *memChatJobs) finished() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, j := range s.jobs {
+		if j.Status == models.ChatJobDone || j.Status == models.ChatJobFailed {
+			n++
+		}
+	}
+	return n
+}
+
+func newJobChat(g *fakeGateway, jobs *memChatJobs) *ChatService {
+	c := newTestChat(g)
+	c.Store = newMemStore()
+	c.Jobs = jobs
+	return c
+}
+
+func TestChatJobEnqueuePoll(t *testing.T) {
+	g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})
+	want := chatOnce(t, newTestChat(newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})), idempotentQuestion)
+	jobs := &memChatJobs{}
+	c := newJobChat(g, jobs)
+	ctx := context.Background()
+
+	job, err := c.EnqueueChat(ctx, "owner-a", models.ChatRequest{Message: idempotentQuestion, WebhookURL: "https://93.184.216.34/done"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != models.ChatJobQueued || job.ID == "" {
+		t.Fatalf("enqueued job %+v, want a queued job with an id", job)
+	}
+	if len(g.Calls("/")) != 0 {
+		t.Error("enqueue called the gateway")
+	}
+	if _, err := c.ChatJob(ctx, "owner-b", job.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("another owner's poll: %v, want sql.ErrNoRows", err)
+	}
+
+	w := &ChatJobWorker{Chat: c, Store: jobs}
+	if ran, err := w.runOnce(ctx); !ran || err != nil {
+		t.Fatalf("runOnce = %v, %v", ran, err)
+	}
+	got, err := c.ChatJob(ctx, "owner-a", job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != models.ChatJobDone || got.Response == nil || got.Response.Answer != want.Answer {
+		t.Errorf("polled job %+v, want done with the inline answer", got)
+	}
+	if len(jobs.outbox) != 1 || jobs.outbox[0] != OutboxWebhook {
+		t.Errorf("outbox %v, want the job's webhook", jobs.outbox)
+	}
+	if ran, _ := w.runOnce(ctx); ran {
+		t.Error("a finished job ran again")
+	}
+}
+
+func TestChatJobEnqueueRefused(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name  string
+		off   bool
+		owner string
+		req   models.ChatRequest
+		want  error
+	}{
+		{name: "queue full", owner: "owner-a", req: models.ChatRequest{Message: idempotentQuestion}, want: ErrChatJobQueueFull},
+		{name: "other owner has room", owner: "owner-b", req: models.ChatRequest{Message: idempotentQuestion}},
+		{name: "internal webhook", owner: "owner-b", req: models.ChatRequest{Message: idempotentQuestion, WebhookURL: "http://10.0.0.1/hook"}, want: ErrWebhookBlocked},
+		{name: "bad webhook", owner: "owner-b", req: models.ChatRequest{Message: idempotentQuestion, WebhookURL: "hooks.example.com"}, want: ErrInvalidWebhookURL},
+		{name: "async off", off: true, owner: "owner-b", req: models.ChatRequest{Message: idempotentQuestion}, want: ErrAsyncDisabled},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			jobs := &memChatJobs{}
+			c := newJobChat(newFakeGateway(t, &fakeGateway{}), jobs)
+			c.MaxQueuedJobs = 2
+			for i := 0; i < 2; i++ {
+				if _, err := c.EnqueueChat(ctx, "owner-a", models.ChatRequest{Message: idempotentQuestion}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.off {
+				c.Jobs = nil
+			}
+			_, err := c.EnqueueChat(ctx, tc.owner, tc.req)
+			if tc.want == nil && err != nil || tc.want != nil && !errors.Is(err, tc.want) {
+				t.Errorf("err = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+// A conversation's jobs run one at a time, in the order they were queued,
+// however many workers there are.
+func TestChatJobConversationOrder(t *testing.T) {
+	g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})
+	jobs := &memChatJobs{}
+	c := newJobChat(g, jobs)
+	ctx := context.Background()
+	questions := []string{
+		"play count for poster Bet 365 in brt",
+		"play count for poster Lorla Studio in brt",
+		"how many kiosks in brt",
+	}
+	for _, q := range questions {
+		if _, err := c.EnqueueChat(ctx, "owner-a", models.ChatRequest{ConversationID: "conv-1", Message: q}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.EnqueueChat(ctx, "owner-a", models.ChatRequest{ConversationID: "conv-2", Message: questions[0]}); err != nil {
+		t.Fatal(err)
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go (&ChatJobWorker{Chat: c, Store: jobs, Workers: 4, Interval: time.Millisecond}).Run(wctx)
+	waitFor(t, "every job to finish", func() bool { return jobs.finished() == len(questions)+1 })
+
+	msgs, _ := c.Store.ListMessages(ctx, "owner-a", "conv-1", 0)
+	var asked []string
+	for _, m := range msgs {
+		if m.Role == "user" {
+			asked = append(asked, m.Content)
+		}
+	}
+	if len(asked) != len(questions) {
+		t.Fatalf("conversation holds questions %q, want %q", asked, questions)
+	}
+	for i := range questions {
+		if asked[i] != questions[i] {
+			t.Errorf("question %d = %q, want %q", i, asked[i], questions[i])
+		}
+	}
+	if n := assistantMessages(t, c, "conv-1"); n != len(questions) {
+		t.Errorf("%d answers in conv-1, want %d", n, len(questions))
+	}
+}
+
+// A job whose worker died is run again once its lease ends, and failed
+// after maxChatJobAttempts interrupted runs.
+func TestChatJobRestart(t *testing.T) {
+	g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})
+	jobs := &memChatJobs{}
+	c := newJobChat(g, jobs)
+	ctx := context.Background()
+	job, err := c.EnqueueChat(ctx, "owner-a", models.ChatRequest{Message: idempotentQuestion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &ChatJobWorker{Chat: c, Store: jobs}
+
+	// A worker claims the job and dies; a live lease keeps it from others.
+	if _, err := jobs.ClaimChatJob(ctx, chatJobLease); err != nil {
+		t.Fatal(err)
+	}
+	if ran, _ := w.runOnce(ctx); ran {
+		t.Fatal("a job with a live lease was claimed again")
+	}
+	jobs.expire()
+	if ran, err := w.runOnce(ctx); !ran || err != nil {
+		t.Fatalf("runOnce after the lease ended = %v, %v", ran, err)
+	}
+	got, _ := c.ChatJob(ctx, "owner-a", job.ID)
+	if got.Status != models.ChatJobDone || got.Attempts != 2 || got.Response == nil {
+		t.Errorf("recovered job %+v, want done on its second attempt", got)
+	}
+
+	// One that keeps dying is given up on.
+	job, _ = c.EnqueueChat(ctx, "owner-a", models.ChatRequest{Message: idempotentQuestion})
+	for i := 0; i < maxChatJobAttempts; i++ {
+		if _, err := jobs.ClaimChatJob(ctx, chatJobLease); err != nil {
+			t.Fatal(err)
+		}
+		jobs.expire()
+	}
+	calls := len(g.Calls("/"))
+	if ran, err := w.runOnce(ctx); !ran || err != nil {
+		t.Fatalf("runOnce = %v, %v", ran, err)
+	}
+	got, _ = c.ChatJob(ctx, "owner-a", job.ID)
+	if got.Status != models.ChatJobFailed || got.Error != "job_abandoned" || len(g.Calls("/")) != calls {
+		t.Errorf("job interrupted %d times: %+v, want it failed without a run", maxChatJobAttempts, got)
+	}
+}
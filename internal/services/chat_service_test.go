@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"openai-agent-service/internal/models"
+)
+
+// Only the owner of a conversation may continue it: anyone else is refused
+// before its state is read or written and before anything is stored.
+func TestConversationOwnership(t *testing.T) {
+	const conv = "conv-owned"
+	cases := []struct {
+		name   string
+		owner  string
+		stream bool
+		denied bool
+	}{
+		{name: "other owner", owner: "owner-b", denied: true},
+		{name: "other owner streaming", owner: "owner-b", stream: true, denied: true},
+		{name: "owner", owner: "owner-a"},
+		{name: "owner streaming", owner: "owner-a", stream: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})
+			c := newTestChat(g)
+			store := newMemStore()
+			c.Store = store
+			ctx := context.Background()
+			if _, err := c.Chat(ctx, "owner-a", models.ChatRequest{ConversationID: conv, Message: "play count for poster Bet 365 in brt"}); err != nil {
+				t.Fatal(err)
+			}
+			stored, _ := store.ListMessages(ctx, "owner-a", conv, 0)
+			calls := len(g.Calls("/"))
+
+			req := models.ChatRequest{ConversationID: conv, Message: "play count for poster Bet 365 in moco"}
+			var resp models.ChatResponse
+			var err error
+			if tc.stream {
+				resp, err = c.ChatStream(ctx, tc.owner, req, func(string) {})
+			} else {
+				resp, err = c.Chat(ctx, tc.owner, req)
+			}
+
+			after, _ := store.ListMessages(ctx, "owner-a", conv, 0)
+			st := c.getConversationState(conv)
+			if !tc.denied {
+				if err != nil {
+					t.Fatalf("owner refused: %v", err)
+				}
+				if len(after) != len(stored)+2 {
+					t.Errorf("%d messages after the owner's turn, want %d", len(after), len(stored)+2)
+				}
+				if st == nil || st.PosterCity != "moco" {
+					t.Errorf("state %+v not updated by the owner's turn", st)
+				}
+				return
+			}
+			if !errors.Is(err, ErrConversationForbidden) {
+				t.Fatalf("err = %v, want ErrConversationForbidden", err)
+			}
+			if resp.Answer == "" {
+				t.Error("refusal has no answer")
+			}
+			if len(after) != len(stored) {
+				t.Errorf("%d messages after the refused turn, want %d", len(after), len(stored))
+			}
+			if n := len(g.Calls("/")); n != calls {
+				t.Errorf("%d gateway calls for the refused turn", n-calls)
+			}
+			if st == nil || st.PosterCity == "moco" || st.MemoryTurn.Seq != 1 {
+				t.Errorf("state %+v changed by the refused turn", st)
+			}
+		})
+	}
+}
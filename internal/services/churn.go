@@ -0,0 +1,288 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+func isChurnIntent(msgLower string) bool {
+	for _, k := range []string{"stopped playing", "no longer playing", "not playing anymore", "dropped off"} {
+		if strings.Contains(msgLower, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// churnPoster is one poster's plays within a window, by host.
+type churnPoster struct {
+	Name  string
+	Plays int64
+	Hosts map[string]int64
+}
+
+// aggregateChurnWindow groups POP rows by poster id (or name when the id is
+// missing).
+func aggregateChurnWindow(rows []popItem) map[string]*churnPoster {
+	out := map[string]*churnPoster{}
+	for _, r := range rows {
+		id := strings.TrimSpace(r.PosterID)
+		name := strings.TrimSpace(r.PosterName)
+		key := id
+		if key == "" {
+			key = strings.ToLower(name)
+		}
+		if key == "" {
+			continue
+		}
+		p := out[key]
+		if p == nil {
+			p = &churnPoster{Name: name, Hosts: map[string]int64{}}
+			out[key] = p
+		}
+		if p.Name == "" {
+			p.Name = name
+		}
+		if p.Name == "" {
+			p.Name = id
+		}
+		p.Plays += r.PlayCount
+		if h := strings.ToLower(strings.TrimSpace(r.HostName)); h != "" {
+			p.Hosts[h] += r.PlayCount
+		}
+	}
+	return out
+}
+
+// churnChange is a poster whose plays fell between the two windows.
+type churnChange struct {
+	Name   string
+	Before int64
+	After  int64
+	Hosts  []string
+}
+
+// churnEntrant is a poster that only played in the recent window.
+type churnEntrant struct {
+	Name  string
+	Plays int64
+}
+
+// compareChurnWindows returns posters whose recent plays fell below threshold
+// (a fraction of their earlier plays; zero recent plays always counts) and
+// posters new in the recent window. Only the maxPosters busiest earlier
+// posters are compared; capped reports whether that cut anything.
+func compareChurnWindows(earlier, recent map[string]*churnPoster, threshold float64, maxPosters int) (dropped []churnChange, entrants []churnEntrant, capped bool) {
+	keys := make([]string, 0, len(earlier))
+	for k, p := range earlier {
+		if p.Plays > 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if earlier[keys[i]].Plays != earlier[keys[j]].Plays {
+			return earlier[keys[i]].Plays > earlier[keys[j]].Plays
+		}
+		return keys[i] < keys[j]
+	})
+	if maxPosters > 0 && len(keys) > maxPosters {
+		keys = keys[:maxPosters]
+		capped = true
+	}
+	for _, k := range keys {
+		p := earlier[k]
+		after := int64(0)
+		if r := recent[k]; r != nil {
+			after = r.Plays
+		}
+		if after > 0 && float64(after) >= threshold*float64(p.Plays) {
+			continue
+		}
+		hosts := make([]string, 0, len(p.Hosts))
+		for h := range p.Hosts {
+			hosts = append(hosts, h)
+		}
+		sort.Slice(hosts, func(i, j int) bool {
+			if p.Hosts[hosts[i]] != p.Hosts[hosts[j]] {
+				return p.Hosts[hosts[i]] > p.Hosts[hosts[j]]
+			}
+			return hosts[i] < hosts[j]
+		})
+		if len(hosts) > 3 {
+			hosts = hosts[:3]
+		}
+		dropped = append(dropped, churnChange{Name: p.Name, Before: p.Plays, After: after, Hosts: hosts})
+	}
+	sort.SliceStable(dropped, func(i, j int) bool {
+		if (dropped[i].After == 0) != (dropped[j].After == 0) {
+			return dropped[i].After == 0
+		}
+		return false
+	})
+
+	for k, p := range recent {
+		if e := earlier[k]; e == nil || e.Plays == 0 {
+			if p.Plays > 0 {
+				entrants = append(entrants, churnEntrant{Name: p.Name, Plays: p.Plays})
+			}
+		}
+	}
+	sort.Slice(entrants, func(i, j int) bool {
+		if entrants[i].Plays != entrants[j].Plays {
+			return entrants[i].Plays > entrants[j].Plays
+		}
+		return entrants[i].Name < entrants[j].Name
+	})
+	return dropped, entrants, capped
+}
+
+// churnWindows returns the recent window and the equally long window before
+// it. An explicit range in the message becomes the recent window; otherwise
+// it is the last `days` full days.
+func churnWindows(msg string, days int, now time.Time) (earlierFrom, earlierTo, recentFrom, recentTo time.Time) {
+	msgLower := strings.ToLower(msg)
+	fromRFC, toRFC := extractDateRangeRFC3339(msgLower)
+	if fromRFC == "" && toRFC == "" {
+		fromRFC, toRFC = extractNaturalDateRangeRFC3339(msg)
+	}
+	from, errF := time.Parse(time.RFC3339, fromRFC)
+	to, errT := time.Parse(time.RFC3339, toRFC)
+	if errF != nil || errT != nil || !to.After(from) {
+		to = now.UTC().Truncate(24 * time.Hour)
+		from = to.AddDate(0, 0, -days)
+	}
+	span := to.Sub(from)
+	return from.Add(-span), from, from, to
+}
+
+func (c *ChatService) handleChurn(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !isChurnIntent(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+	conversationID := strings.TrimSpace(req.ConversationID)
+	scope := c.resolveScope(ctx, conversationID, msgLower)
+	city, region := scope.City.Value, scope.Region.Value
+	if conversationID != "" {
+		c.updateConversationLocation(conversationID, city, region)
+	}
+	scopeFilter := ""
+	scopeLabel := "all locations"
+	if region != "" {
+		scopeFilter = "region=" + urlEscape(region) + "&"
+		scopeLabel = "region '" + region + "'"
+	} else if city != "" {
+		scopeFilter = "city=" + urlEscape(city) + "&"
+		scopeLabel = "city '" + city + "'"
+	}
+
+	days := c.ChurnWindowDays
+	if days <= 0 {
+		days = 7
+	}
+	threshold := float64(c.ChurnThresholdPercent) / 100
+	if c.ChurnThresholdPercent <= 0 {
+		threshold = 0.10
+	}
+	maxPosters := c.ChurnMaxPosters
+	if maxPosters <= 0 {
+		maxPosters = 200
+	}
+
+	earlierFrom, earlierTo, recentFrom, recentTo := churnWindows(req.Message, days, time.Now())
+	window := func(from, to time.Time) string {
+		return scopeFilter + "from=" + urlEscape(from.Format(time.RFC3339)) + "&to=" + urlEscape(to.Format(time.RFC3339))
+	}
+	earlierRows, steps, earlierPager, err := c.fetchPopRows(ctx, window(earlierFrom, earlierTo))
+	if err != nil {
+		return models.ChatResponse{Answer: "Failed to fetch POP data for the earlier window: " + err.Error(), Steps: steps}, true, nil
+	}
+	recentRows, recentSteps, recentPager, err := c.fetchPopRows(ctx, window(recentFrom, recentTo))
+	steps = append(steps, recentSteps...)
+	if err != nil {
+		return models.ChatResponse{Answer: "Failed to fetch POP data for the recent window: " + err.Error(), Steps: steps}, true, nil
+	}
+
+	dropped, entrants, capped := compareChurnWindows(aggregateChurnWindow(earlierRows), aggregateChurnWindow(recentRows), threshold, maxPosters)
+
+	dayLabel := func(from, to time.Time) string {
+		return from.Format("Jan 2") + "–" + to.Add(-time.Second).Format("Jan 2")
+	}
+	lines := make([]string, 0, len(dropped)+12)
+	lines = append(lines, fmt.Sprintf("Posters that stopped playing (or fell below %s of their earlier plays) in %s, %s vs %s:",
+		formatPercent(threshold*100), scopeLabel, dayLabel(recentFrom, recentTo), dayLabel(earlierFrom, earlierTo)))
+	if len(dropped) == 0 {
+		lines = append(lines, "None — every poster from the earlier window is still playing.")
+	}
+	const maxDroppedLines = 15
+	hostSet := map[string]struct{}{}
+	for i, d := range dropped {
+		if i >= maxDroppedLines {
+			break
+		}
+		for _, h := range d.Hosts {
+			hostSet[h] = struct{}{}
+		}
+	}
+	hosts := make([]string, 0, len(hostSet))
+	for h := range hostSet {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	names, metaSteps := c.deviceLabels(ctx, city, region, hosts)
+	steps = append(steps, metaSteps...)
+	for i, d := range dropped {
+		if i >= maxDroppedLines {
+			lines = append(lines, fmt.Sprintf("…and %d more.", len(dropped)-maxDroppedLines))
+			break
+		}
+		change := "stopped"
+		if d.After > 0 {
+			change = fmt.Sprintf("%s plays (-%s)", formatThousands(d.After), formatPercent(100-float64(d.After)*100/float64(d.Before)))
+		}
+		line := fmt.Sprintf("%d. %s — %s plays → %s", i+1, d.Name, formatThousands(d.Before), change)
+		if len(d.Hosts) > 0 {
+			labels := make([]string, 0, len(d.Hosts))
+			for _, h := range d.Hosts {
+				labels = append(labels, names[h])
+			}
+			line += "; used to play on " + strings.Join(labels, ", ")
+		}
+		lines = append(lines, line)
+	}
+	if capped {
+		lines = append(lines, fmt.Sprintf("(Compared the %d busiest posters of the earlier window.)", maxPosters))
+	}
+	if len(entrants) > 0 {
+		top := make([]string, 0, 5)
+		for i, e := range entrants {
+			if i >= 5 {
+				break
+			}
+			top = append(top, fmt.Sprintf("%s (%s plays)", e.Name, formatThousands(e.Plays)))
+		}
+		more := ""
+		if len(entrants) > len(top) {
+			more = fmt.Sprintf(" and %d more", len(entrants)-len(top))
+		}
+		lines = append(lines, fmt.Sprintf("New in the recent window: %s%s.", strings.Join(top, ", "), more))
+	}
+
+	pager := earlierPager
+	if recentPager != nil && recentPager.Truncated {
+		pager = recentPager
+	}
+	answer := pager.note(strings.Join(lines, "\n"))
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps, Meta: pager.meta()}, true, nil
+}
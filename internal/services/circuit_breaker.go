@@ -0,0 +1,174 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrGatewayUnavailable is returned by GatewayClient without making a request
+// while the circuit breaker is open.
+var ErrGatewayUnavailable = errors.New("the data gateway is currently unavailable, please retry in a minute")
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// CircuitBreaker fails gateway calls fast during an outage. After Threshold
+// consecutive failures (network errors or 5xx) within Window it opens and
+// rejects calls for Cooldown, then lets a single probe through: success closes
+// it, failure re-opens it for another Cooldown.
+type CircuitBreaker struct {
+	Threshold int
+	Window    time.Duration
+	Cooldown  time.Duration
+	// Now is the clock; nil means time.Now.
+	Now func() time.Time
+
+	mu           sync.Mutex
+	state        string
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	probing      bool
+	opens        int64
+	rejected     int64
+}
+
+// BreakerStatus is a point-in-time view of a CircuitBreaker for /readyz and
+// /metrics.
+type BreakerStatus struct {
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	OpenedAt            time.Time `json:"opened_at,omitempty"`
+	RetryAfterSeconds   int64     `json:"retry_after_seconds,omitempty"`
+	Opens               int64     `json:"opens_total"`
+	Rejected            int64     `json:"rejected_total"`
+}
+
+func (b *CircuitBreaker) now() time.Time {
+	if b.Now != nil {
+		return b.Now()
+	}
+	return time.Now()
+}
+
+func (b *CircuitBreaker) threshold() int {
+	if b.Threshold > 0 {
+		return b.Threshold
+	}
+	return 5
+}
+
+func (b *CircuitBreaker) cooldown() time.Duration {
+	if b.Cooldown > 0 {
+		return b.Cooldown
+	}
+	return 30 * time.Second
+}
+
+// currentState moves an open breaker to half-open once the cooldown has
+// passed. Callers hold b.mu.
+func (b *CircuitBreaker) currentState() string {
+	if b.state == "" {
+		b.state = BreakerClosed
+	}
+	if b.state == BreakerOpen && !b.now().Before(b.openedAt.Add(b.cooldown())) {
+		b.state = BreakerHalfOpen
+		b.probing = false
+	}
+	return b.state
+}
+
+// Allow reports whether a call may proceed. It returns ErrGatewayUnavailable
+// while open, and while half-open once the probe is already in flight.
+func (b *CircuitBreaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.currentState() {
+	case BreakerOpen:
+		b.rejected++
+		return ErrGatewayUnavailable
+	case BreakerHalfOpen:
+		if b.probing {
+			b.rejected++
+			return ErrGatewayUnavailable
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Record reports the outcome of a call that Allow let through.
+func (b *CircuitBreaker) Record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	state := b.currentState()
+	if !failed {
+		b.state = BreakerClosed
+		b.failures = 0
+		b.probing = false
+		return
+	}
+	if state == BreakerHalfOpen {
+		b.open(now)
+		return
+	}
+	if b.failures == 0 || (b.Window > 0 && now.Sub(b.firstFailure) > b.Window) {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+	if state == BreakerClosed && b.failures >= b.threshold() {
+		b.open(now)
+	}
+}
+
+// Abandon releases a call that ended without a verdict (the caller went
+// away), so a half-open breaker can send another probe.
+func (b *CircuitBreaker) Abandon() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *CircuitBreaker) open(now time.Time) {
+	b.state = BreakerOpen
+	b.openedAt = now
+	b.probing = false
+	b.opens++
+}
+
+// Status returns the breaker's current state and counters.
+func (b *CircuitBreaker) Status() BreakerStatus {
+	if b == nil {
+		return BreakerStatus{State: BreakerClosed}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := BreakerStatus{
+		State:               b.currentState(),
+		ConsecutiveFailures: b.failures,
+		Opens:               b.opens,
+		Rejected:            b.rejected,
+	}
+	if st.State != BreakerClosed {
+		st.OpenedAt = b.openedAt
+	}
+	if st.State == BreakerOpen {
+		st.RetryAfterSeconds = int64(b.openedAt.Add(b.cooldown()).Sub(b.now()).Seconds() + 0.999)
+	}
+	return st
+}
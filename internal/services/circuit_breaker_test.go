@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a CircuitBreaker clock the test moves by hand.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.t }
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// The breaker opens after Threshold failures, rejects without calling the
+// gateway for Cooldown, lets one probe through, re-opens when the probe fails
+// and closes when a later probe succeeds.
+func TestCircuitBreakerLifecycle(t *testing.T) {
+	var status, calls atomic.Int32
+	status.Store(http.StatusBadGateway)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+
+	clock := &fakeClock{t: time.Date(2024, 10, 1, 9, 0, 0, 0, time.UTC)}
+	b := &CircuitBreaker{Threshold: 3, Window: time.Minute, Cooldown: 30 * time.Second, Now: clock.Now}
+	gw := &GatewayClient{BaseURL: srv.URL, Breaker: b}
+	get := func() error {
+		_, _, err := gw.Get(context.Background(), "/ads/devices")
+		return err
+	}
+	expect := func(step, state string, calls0 int32) {
+		t.Helper()
+		if got := b.Status().State; got != state {
+			t.Fatalf("%s: state %s, want %s", step, got, state)
+		}
+		if got := calls.Load(); got != calls0 {
+			t.Fatalf("%s: %d gateway calls, want %d", step, got, calls0)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		_ = get()
+	}
+	expect("below threshold", BreakerClosed, 2)
+	_ = get()
+	expect("at threshold", BreakerOpen, 3)
+
+	if err := get(); !errors.Is(err, ErrGatewayUnavailable) {
+		t.Fatalf("open breaker let a call through: %v", err)
+	}
+	expect("open rejects", BreakerOpen, 3)
+	clock.Advance(29 * time.Second)
+	if st := b.Status(); st.State != BreakerOpen || st.RetryAfterSeconds != 1 {
+		t.Fatalf("before cooldown: %+v", st)
+	}
+
+	clock.Advance(time.Second)
+	expect("after cooldown", BreakerHalfOpen, 3)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("half-open breaker refused the probe: %v", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrGatewayUnavailable) {
+		t.Fatalf("half-open breaker let a second call through while probing: %v", err)
+	}
+	b.Abandon()
+
+	_ = get()
+	expect("failed probe", BreakerOpen, 4)
+	if st := b.Status(); st.Opens != 2 || st.Rejected != 2 {
+		t.Fatalf("after failed probe: %+v", st)
+	}
+
+	clock.Advance(30 * time.Second)
+	status.Store(http.StatusOK)
+	if err := get(); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	expect("successful probe", BreakerClosed, 5)
+	if st := b.Status(); st.ConsecutiveFailures != 0 {
+		t.Fatalf("closed breaker kept failures: %+v", st)
+	}
+	if err := get(); err != nil {
+		t.Fatalf("closed breaker: %v", err)
+	}
+	expect("closed", BreakerClosed, 6)
+}
+
+// Failures further apart than Window do not add up to an open breaker.
+func TestCircuitBreakerWindow(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 10, 1, 9, 0, 0, 0, time.UTC)}
+	b := &CircuitBreaker{Threshold: 3, Window: time.Minute, Now: clock.Now}
+	for i := 0; i < 6; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("failure %d: %v", i+1, err)
+		}
+		b.Record(true)
+		clock.Advance(40 * time.Second)
+	}
+	if st := b.Status(); st.State != BreakerClosed {
+		t.Fatalf("spread-out failures opened the breaker: %+v", st)
+	}
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"sort"
+	"strings"
+
+	"openai-agent-service/internal/models"
+)
+
+// appendStep adds a step index to an ascending list of sources. Rows arrive
+// in page order, so checking the last entry is enough to keep it distinct.
+func appendStep(sources []int, step int) []int {
+	if n := len(sources); n > 0 && sources[n-1] == step {
+		return sources
+	}
+	return append(sources, step)
+}
+
+// rowSteps returns the distinct indices of the steps that fetched rows.
+func rowSteps(rows []popItem) []int {
+	seen := map[int]bool{}
+	out := make([]int, 0, 2)
+	for _, r := range rows {
+		if !seen[r.step] {
+			seen[r.step] = true
+			out = append(out, r.step)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+// tagRows records which step fetched each row.
+func tagRows(rows []popItem, step int) {
+	for i := range rows {
+		rows[i].step = step
+	}
+}
+
+// cite records that figure, as written on answer line `line`, came from the
+// given steps. Negative indices mean "no step" and figures without a source
+// are not cited.
+func cite(cs []models.Citation, figure string, line int, steps ...int) []models.Citation {
+	sources := make([]int, 0, len(steps))
+	for _, s := range steps {
+		if s >= 0 {
+			sources = append(sources, s)
+		}
+	}
+	if figure == "" || len(sources) == 0 {
+		return cs
+	}
+	return append(cs, models.Citation{Figure: figure, Line: line, Steps: sources})
+}
+
+// locateCitations re-points each citation at the answer line that actually
+// holds its figure. Headers prepended after the handler ran only push lines
+// down, so the search starts at the handler's own line number.
+func locateCitations(resp models.ChatResponse) models.ChatResponse {
+	if len(resp.Citations) == 0 {
+		return resp
+	}
+	lines := strings.Split(resp.Answer, "\n")
+	for i, ct := range resp.Citations {
+		for l := max(ct.Line, 0); l < len(lines); l++ {
+			if containsFigure(lines[l], ct.Figure) {
+				resp.Citations[i].Line = l
+				break
+			}
+		}
+	}
+	return resp
+}
+
+// containsFigure reports whether line holds figure as a whole number, so
+// "5 plays" does not match inside "15 plays".
+func containsFigure(line, figure string) bool {
+	for from := 0; ; {
+		i := strings.Index(line[from:], figure)
+		if i < 0 {
+			return false
+		}
+		i += from
+		if i == 0 || !strings.ContainsRune("0123456789,.", rune(line[i-1])) {
+			return true
+		}
+		from = i + 1
+	}
+}
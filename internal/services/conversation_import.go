@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// ConversationImportStore stores transcripts imported from another chat
+// system; implemented by store.PostgresStore.
+type ConversationImportStore interface {
+	ImportConversation(ctx context.Context, ownerKey, title string, msgs []models.ImportedMessage) (models.Conversation, error)
+}
+
+const (
+	// MaxImportMessages caps the messages of one imported transcript.
+	MaxImportMessages = 500
+	// MaxImportContentBytes caps the content of one imported message.
+	MaxImportContentBytes = 32 << 10
+	// importClockSkew is how far a message may be stamped before the one
+	// preceding it, or after now, before the transcript is refused; systems
+	// that stamp the user's and the bot's clocks separately drift a little.
+	importClockSkew = 2 * time.Minute
+)
+
+// ErrImportDisabled is returned when no import store is configured.
+var ErrImportDisabled = errors.New("conversation import is not enabled on this service")
+
+// ValidateTranscript checks a transcript before it is imported: 1 to
+// MaxImportMessages messages, each from "user" or "assistant", with non-empty
+// content of at most MaxImportContentBytes and a timestamp no earlier than the
+// previous message's and not in the future, each within importClockSkew.
+// Roles are normalized to lower case, and a timestamp within the skew before
+// its predecessor is moved up to it so the stored order is the transcript's.
+func ValidateTranscript(msgs []models.ImportedMessage, now time.Time) error {
+	switch {
+	case len(msgs) == 0:
+		return errors.New("messages must not be empty")
+	case len(msgs) > MaxImportMessages:
+		return fmt.Errorf("at most %d messages can be imported, got %d", MaxImportMessages, len(msgs))
+	}
+	for i := range msgs {
+		m := &msgs[i]
+		m.Role = strings.ToLower(strings.TrimSpace(m.Role))
+		switch {
+		case m.Role != "user" && m.Role != "assistant":
+			return fmt.Errorf("messages[%d]: unknown role %q (use user or assistant)", i, m.Role)
+		case strings.TrimSpace(m.Content) == "":
+			return fmt.Errorf("messages[%d]: content must not be empty", i)
+		case len(m.Content) > MaxImportContentBytes:
+			return fmt.Errorf("messages[%d]: content is %d bytes; at most %d are accepted", i, len(m.Content), MaxImportContentBytes)
+		case m.Timestamp.IsZero():
+			return fmt.Errorf("messages[%d]: timestamp is required", i)
+		case m.Timestamp.After(now.Add(importClockSkew)):
+			return fmt.Errorf("messages[%d]: timestamp %s is in the future", i, m.Timestamp.Format(time.RFC3339))
+		}
+		if i == 0 {
+			continue
+		}
+		prev := msgs[i-1].Timestamp
+		if m.Timestamp.Before(prev.Add(-importClockSkew)) {
+			return fmt.Errorf("messages[%d]: timestamp %s is before the previous message's (%s); messages must be in order", i, m.Timestamp.Format(time.RFC3339), prev.Format(time.RFC3339))
+		}
+		if m.Timestamp.Before(prev) {
+			m.Timestamp = prev
+		}
+	}
+	return nil
+}
+
+// ImportConversation stores a transcript from another chat system as a new
+// conversation of ownerKey and seeds its memory from the messages the same
+// way a conversation is re-read after a restart, so a follow-up such as
+// "show it kiosk wise" resolves against the imported poster or location.
+// The transcript must pass ValidateTranscript.
+func (c *ChatService) ImportConversation(ctx context.Context, ownerKey string, imp models.ConversationImport) (models.ConversationImportResult, error) {
+	if c.Imports == nil {
+		return models.ConversationImportResult{}, ErrImportDisabled
+	}
+	if err := ValidateTranscript(imp.Messages, time.Now()); err != nil {
+		return models.ConversationImportResult{}, err
+	}
+	conv, err := c.Imports.ImportConversation(ctx, ownerKey, imp.Title, imp.Messages)
+	if err != nil {
+		return models.ConversationImportResult{}, err
+	}
+	c.ensureConversationStateHydrated(ctx, ownerKey, conv.ConversationID)
+	inferred, summary := importedContext(c.getConversationState(conv.ConversationID))
+	return models.ConversationImportResult{Conversation: conv, Messages: len(imp.Messages), Inferred: inferred, Summary: summary}, nil
+}
+
+// importedContext lists the memory read from an imported transcript, by
+// field and as a sentence.
+func importedContext(st *conversationState) (map[string]string, string) {
+	inferred := map[string]string{}
+	if st == nil {
+		return inferred, "No context was inferred from the transcript."
+	}
+	var parts []string
+	add := func(key, label, value string) {
+		if value = strings.TrimSpace(value); value != "" {
+			inferred[key] = value
+			parts = append(parts, label+" "+value)
+		}
+	}
+	add("poster_name", "poster", st.PosterName)
+	add("poster_id", "poster id", st.PosterID)
+	add("poster_city", "poster city", st.PosterCity)
+	add("poster_region", "poster region", st.PosterRegion)
+	add("host", "device", st.Host)
+	add("city", "city", st.City)
+	add("region", "region", st.Region)
+	add("campaign_id", "campaign", st.CampaignID)
+	if st.VenueID > 0 {
+		add("venue_id", "venue", strconv.Itoa(st.VenueID))
+	}
+	if len(parts) == 0 {
+		return inferred, "No context was inferred from the transcript."
+	}
+	return inferred, "Inferred " + strings.Join(parts, ", ") + "."
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Concurrent turns on one conversation read and write its state at once; run
+// with -race. Each writer stores values that belong together, so a snapshot
+// mixing two writers' halves means a write escaped convMu.
+func TestConversationStateConcurrentTurns(t *testing.T) {
+	c := &ChatService{}
+	const id = "conv-race"
+	const writers, rounds = 8, 200
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			tag := fmt.Sprintf("w%d", w)
+			for i := 0; i < rounds; i++ {
+				switch i % 8 {
+				case 0:
+					candidates := []campaignCandidate{{ID: tag, Name: tag}, {ID: tag, Name: tag}}
+					c.setPendingCampaigns(id, "which campaign, "+tag+"?", candidates)
+					// The state keeps its own copy of the candidates.
+					candidates[0].ID = "mutated"
+				case 1:
+					c.setPendingCampaignDraft(id, "name?", &campaignDraft{Name: tag, AdvertiserName: tag})
+				case 2:
+					c.clearPending(id)
+				case 3:
+					c.updateConversationPoster(id, tag, "moco", "brt")
+					c.updateConversationPosterID(id, testBetID)
+				case 4:
+					c.updateConversationLocation(id, "moco", "brt")
+					c.updateConversationHost(id, "moco-brt-briggs-001")
+				case 5:
+					c.rememberUnit(id, "plays")
+					c.updateConversationCampaignID(id, testCampaignID)
+				case 6:
+					c.setPending(id, "posterPlays", "for which poster?")
+					c.rememberList(id, "posters", []listedEntity{{ID: testBetID, Name: tag}})
+				case 7:
+					c.setPendingDeviceCommand(id, &pendingDeviceCommand{Action: "restart", Host: tag})
+					c.updateConversationVenueID(id, w+1)
+				}
+			}
+		}(w)
+	}
+	errs := make(chan string, writers)
+	for r := 0; r < writers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				st := c.getConversationState(id)
+				if st == nil {
+					continue
+				}
+				for _, cand := range st.PendingCampaigns {
+					if cand.ID != st.PendingCampaigns[0].ID || cand.ID == "mutated" {
+						errs <- fmt.Sprintf("pending campaigns mixed or aliased: %+v", st.PendingCampaigns)
+						return
+					}
+				}
+				if d := st.CampaignDraft; d != nil && d.Name != d.AdvertiserName {
+					errs <- fmt.Sprintf("campaign draft torn: %+v", *d)
+					return
+				}
+				// Writing to the snapshot must not reach the stored state.
+				st.PendingHandler = "snapshot"
+				if len(st.PendingCampaigns) > 0 {
+					st.PendingCampaigns[0].ID = "mutated"
+				}
+				if st.CampaignDraft != nil {
+					st.CampaignDraft.Name = "snapshot"
+				}
+				_ = st.hasMemory()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for e := range errs {
+		t.Error(e)
+	}
+
+	st := c.getConversationState(id)
+	if st == nil {
+		t.Fatal("conversation state missing")
+	}
+	if st.PendingHandler == "snapshot" {
+		t.Error("a snapshot write reached the stored state")
+	}
+	if st.City != "moco" || st.Region != "brt" || st.Host != "moco-brt-briggs-001" || st.PosterID != testBetID || st.Unit != "plays" || st.CampaignID != testCampaignID {
+		t.Errorf("final state lost a write: %+v", st)
+	}
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// maxSummaryFacts caps the key-figure bullets in a recap; the oldest figures
+// are dropped first.
+const maxSummaryFacts = 20
+
+var (
+	summaryRequestRe = regexp.MustCompile(`^(?:please\s+|ok(?:ay)?,?\s+|can\s+you\s+|could\s+you\s+)?(?:summari[sz]e|recap|sum\s+up|(?:give\s+me\s+)?(?:a\s+)?(?:summary|recap)(?:\s+of)?|what\s+(?:have|did)\s+we\s+(?:find|found|learn|learned|cover|covered))\b`)
+	summaryTargetRe  = regexp.MustCompile(`\b(?:conversation|chat|this|that|it|we|so\s+far|findings|everything|above)\b`)
+)
+
+// parseSummaryRequest recognises "summarize this conversation", "recap",
+// "summarize what we found" and "what have we found so far". Questions that
+// name their own subject ("summarize plays for poster X") are left to the
+// data handlers.
+func parseSummaryRequest(msgLower string) bool {
+	msg := forgetTrailing.ReplaceAllString(strings.TrimSpace(msgLower), "")
+	loc := summaryRequestRe.FindStringIndex(msg)
+	if loc == nil || len(strings.Fields(msg)) > 12 {
+		return false
+	}
+	rest := strings.TrimSpace(msg[loc[1]:])
+	return rest == "" || strings.HasPrefix(msg, "what") || summaryTargetRe.MatchString(rest)
+}
+
+// summaryPattern turns one line of a deterministic answer into a fact.
+type summaryPattern struct {
+	group string
+	re    *regexp.Regexp
+	fact  func(m []string) (entity, figure string)
+}
+
+// summaryGroups is the order groups are rendered in.
+var summaryGroups = []string{"Posters", "Campaigns", "Devices"}
+
+// summaryPatterns match the first line of the answers the poster, campaign
+// and device handlers write, so figures can be recapped without a model.
+var summaryPatterns = []summaryPattern{
+	{"Posters", regexp.MustCompile(`^(?:POP|Play count) for poster '([^']+)'(?: (for|in) (.+?))?: ([\d,.]+ (?:plays|minutes))`), func(m []string) (string, string) {
+		entity := "Poster '" + m[1] + "'"
+		if m[3] != "" {
+			entity += " " + m[2] + " " + m[3]
+		}
+		return entity, summaryFigure(m[4])
+	}},
+	{"Posters", regexp.MustCompile(`^POP for poster ([^':]+): ([\d,]+) plays`), func(m []string) (string, string) {
+		return "Poster " + strings.TrimSpace(m[1]), summaryFigure(m[2] + " plays")
+	}},
+	{"Posters", regexp.MustCompile(`^Kiosk '([^']+)' \(([^)]+)\) has played poster '([^']+)': ([\d,]+) plays`), func(m []string) (string, string) {
+		return "Poster '" + m[3] + "' on kiosk '" + m[1] + "'", summaryFigure(m[4] + " plays")
+	}},
+	{"Campaigns", regexp.MustCompile(`^Campaign (\S+) impressions(?: \(from POP\))?: ([\d,]+) total`), func(m []string) (string, string) {
+		return "Campaign " + m[1], summaryFigure(m[2] + " impressions")
+	}},
+	{"Campaigns", regexp.MustCompile(`^Campaign (.+?) is ([a-z ]+): ([\d,.]+%) pacing \(([\d,]+) delivered`), func(m []string) (string, string) {
+		return "Campaign " + m[1], m[3] + " pacing, " + m[2] + " (" + m[4] + " impressions delivered)"
+	}},
+	{"Campaigns", regexp.MustCompile(`^Campaign (.+?) has ended \(flight [^)]*\)\. Delivered ([\d,]+) of ([\d,]+) goal impressions`), func(m []string) (string, string) {
+		return "Campaign " + m[1], "ended, " + m[2] + " of " + m[3] + " goal impressions"
+	}},
+	{"Devices", regexp.MustCompile(`^(City|Region) '([^']+)': (\d+) offline / (\d+) online \(total (\d+) devices`), func(m []string) (string, string) {
+		return "Device status in " + strings.ToLower(m[1]) + " '" + m[2] + "'", summaryFigure(m[3]+" offline") + " / " + summaryFigure(m[4]+" online") + " of " + summaryFigure(m[5])
+	}},
+	{"Devices", regexp.MustCompile(`^There are (\d+) kiosks/devices recorded for (.+?)\.$`), func(m []string) (string, string) {
+		return "Kiosk count for " + m[2], summaryFigure(m[1] + " kiosks")
+	}},
+}
+
+// summaryFigure adds thousands separators to the leading integer of a figure
+// ("12345 plays" -> "12,345 plays").
+func summaryFigure(s string) string {
+	num, rest, _ := strings.Cut(s, " ")
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil {
+		return s
+	}
+	if rest == "" {
+		return formatThousands(n)
+	}
+	return formatThousands(n) + " " + rest
+}
+
+// summaryFact is the latest figure seen for one entity.
+type summaryFact struct {
+	group  string
+	entity string
+	figure string
+	at     time.Time
+	seq    int
+}
+
+// conversationFacts extracts the latest figure per entity from assistant
+// answers, and returns the answers no pattern matched. Replies to meta
+// questions (forget, saved queries, earlier recaps) and failures are skipped.
+func conversationFacts(msgs []models.Message) ([]summaryFact, []string) {
+	latest := map[string]*summaryFact{}
+	var freeform []string
+	skipReply := false
+	seq := 0
+	for _, m := range msgs {
+		content := strings.TrimSpace(m.Content)
+		if m.Role == "user" {
+			skipReply = isMetaQuestion(content)
+			continue
+		}
+		if m.Role != "assistant" || content == "" {
+			continue
+		}
+		if skipReply {
+			skipReply = false
+			continue
+		}
+		if strings.HasPrefix(content, "(mock)") || strings.HasPrefix(content, "Failed to") {
+			continue
+		}
+		matched := false
+		for _, line := range strings.Split(content, "\n") {
+			line = strings.TrimSpace(line)
+			for _, p := range summaryPatterns {
+				mm := p.re.FindStringSubmatch(line)
+				if mm == nil {
+					continue
+				}
+				entity, figure := p.fact(mm)
+				key := p.group + "\x00" + strings.ToLower(entity)
+				seq++
+				latest[key] = &summaryFact{group: p.group, entity: entity, figure: figure, at: m.CreatedAt, seq: seq}
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			freeform = append(freeform, content)
+		}
+	}
+	facts := make([]summaryFact, 0, len(latest))
+	for _, f := range latest {
+		facts = append(facts, *f)
+	}
+	// Newest first, so the cap drops the oldest figures.
+	sort.Slice(facts, func(i, j int) bool { return facts[i].seq > facts[j].seq })
+	return facts, freeform
+}
+
+// renderFacts groups facts by entity type, newest first within a group.
+func renderFacts(facts []summaryFact) []string {
+	var lines []string
+	for _, g := range summaryGroups {
+		header := false
+		for _, f := range facts {
+			if f.group != g {
+				continue
+			}
+			if !header {
+				lines = append(lines, g+":")
+				header = true
+			}
+			lines = append(lines, fmt.Sprintf("- %s: %s (retrieved %s)", f.entity, f.figure, f.at.Format("2006-01-02")))
+		}
+	}
+	return lines
+}
+
+// summarizeFreeform asks the model for a short recap of answers that had no
+// figures to extract. It returns nil when no model is available.
+func (c *ChatService) summarizeFreeform(answers []string) []string {
+	if len(answers) == 0 || c.MockMode || c.OpenAI == nil {
+		return nil
+	}
+	msgs := []OpenAIMessage{
+		{Role: "system", Content: "Summarize the assistant answers below as at most 5 short bullet points starting with '- '. Only restate what they say; do not add numbers or facts that are not in them."},
+		{Role: "user", Content: clipString(strings.Join(answers, "\n---\n"), 8000)},
+	}
+	out, err := c.OpenAI.Chat(msgs)
+	if err != nil {
+		return nil
+	}
+	var lines []string
+	for _, l := range strings.Split(out, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		if !strings.HasPrefix(l, "- ") {
+			l = "- " + strings.TrimLeft(l, "-*• ")
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+func (c *ChatService) handleConversationSummary(ctx context.Context, ownerKey string, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	if !parseSummaryRequest(strings.ToLower(req.Message)) {
+		return models.ChatResponse{}, false, nil
+	}
+	reply := func(answer string) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(answer)
+		}
+		return models.ChatResponse{Answer: answer}, true, nil
+	}
+	conversationID := strings.TrimSpace(req.ConversationID)
+	if conversationID == "" || c.Store == nil {
+		return reply("There is no conversation to summarize; send a conversation_id to get a recap of it.")
+	}
+	msgs, err := c.Store.ListMessages(ctx, ownerKey, conversationID, 100)
+	if err != nil {
+		return reply("Could not load the conversation history to summarize it.")
+	}
+	facts, freeform := conversationFacts(msgs)
+	if len(facts) == 0 && len(freeform) == 0 {
+		return reply("Nothing has been answered in this conversation yet, so there is nothing to summarize.")
+	}
+
+	omitted := 0
+	if len(facts) > maxSummaryFacts {
+		omitted = len(facts) - maxSummaryFacts
+		facts = facts[:maxSummaryFacts]
+	}
+	var lines []string
+	if len(facts) > 0 {
+		lines = append(lines, "Key figures from this conversation (latest value for each):")
+		lines = append(lines, renderFacts(facts)...)
+		if omitted > 0 {
+			lines = append(lines, fmt.Sprintf("%d older figure(s) were left out; ask again about them to refresh.", omitted))
+		}
+	}
+	if len(freeform) > 0 {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		if other := c.summarizeFreeform(freeform); len(other) > 0 {
+			lines = append(lines, "Other findings (summarized by the model, not recomputed):")
+			lines = append(lines, other...)
+		} else {
+			lines = append(lines, fmt.Sprintf("%d other answer(s) had no figures to recap.", len(freeform)))
+		}
+	}
+	// ListMessages returns at most the newest 100 messages.
+	if len(msgs) >= 100 {
+		lines = append(lines, "Only the most recent 100 messages were read; earlier ones are not included.")
+	}
+	return reply(strings.Join(lines, "\n"))
+}
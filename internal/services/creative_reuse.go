@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"openai-agent-service/internal/models"
+)
+
+// creativeReuseMaxGroups caps the groups listed in the reuse report.
+const creativeReuseMaxGroups = 15
+
+var creativeReuseRe = regexp.MustCompile(`\b(?:duplicate(?:d)?\s+creatives?|(?:re-?used|shared)\s+creatives?|creative\s+reuse|creatives?\s+(?:(?:that|which)\s+)?(?:are\s+|were\s+)?(?:used|attached|shared|reused)\s+(?:in|across|by|on)\s+(?:more\s+than\s+one|multiple|several|many|different)\s+campaigns?)\b`)
+
+func isCreativeReuseIntent(msgLower string) bool {
+	return creativeReuseRe.MatchString(msgLower)
+}
+
+// creativeIdentity is the key creatives are grouped by: a checksum when the
+// gateway reports one, else the file name from the URL, else the normalized
+// creative name. The second value says which was used.
+func creativeIdentity(m map[string]any) (key, basis string) {
+	if sum := anyString(m, "checksum", "sha256", "md5", "file_hash", "hash"); sum != "" {
+		return "sum:" + strings.ToLower(sum), "checksum"
+	}
+	if raw := anyString(m, "file_url", "url", "file_path", "fileUrl"); raw != "" {
+		p := raw
+		if u, err := url.Parse(raw); err == nil && u.Path != "" {
+			p = u.Path
+		}
+		if base := strings.ToLower(path.Base(p)); base != "" && base != "." && base != "/" {
+			return "file:" + base, "file"
+		}
+	}
+	if name := compactAlnum(strings.ToLower(anyString(m, "name", "file_name"))); name != "" {
+		return "name:" + name, "name"
+	}
+	return "", ""
+}
+
+// creativeGroup is one file found on creatives of several campaigns.
+type creativeGroup struct {
+	Name      string
+	Basis     string
+	Creatives int
+	Campaigns []string
+}
+
+// groupReusedCreatives groups creative rows by identity and keeps the groups
+// that span more than one distinct campaign, largest first.
+func groupReusedCreatives(rows []map[string]any) []creativeGroup {
+	byKey := map[string]*creativeGroup{}
+	seen := map[string]map[string]bool{}
+	order := []string{}
+	for _, m := range rows {
+		key, basis := creativeIdentity(m)
+		campaignID := anyString(m, "campaign_id", "campaignId")
+		if key == "" || campaignID == "" {
+			continue
+		}
+		g := byKey[key]
+		if g == nil {
+			name := anyString(m, "name", "file_name")
+			if name == "" {
+				_, name, _ = strings.Cut(key, ":")
+			}
+			g = &creativeGroup{Name: name, Basis: basis}
+			byKey[key] = g
+			seen[key] = map[string]bool{}
+			order = append(order, key)
+		}
+		g.Creatives++
+		if !seen[key][campaignID] {
+			seen[key][campaignID] = true
+			g.Campaigns = append(g.Campaigns, campaignID)
+		}
+	}
+	out := make([]creativeGroup, 0)
+	for _, k := range order {
+		if g := byKey[k]; len(g.Campaigns) > 1 {
+			out = append(out, *g)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if len(out[i].Campaigns) != len(out[j].Campaigns) {
+			return len(out[i].Campaigns) > len(out[j].Campaigns)
+		}
+		return out[i].Creatives > out[j].Creatives
+	})
+	return out
+}
+
+// campaignInfo is the name and advertiser of a campaign, for labelling.
+type campaignInfo struct {
+	Name         string
+	AdvertiserID string
+}
+
+func campaignInfoFrom(m map[string]any) campaignInfo {
+	info := campaignInfo{Name: anyString(m, "name", "title"), AdvertiserID: anyString(m, "advertiser_id", "advertiserId")}
+	if info.AdvertiserID == "" {
+		if adv, ok := m["advertiser"].(map[string]any); ok {
+			info.AdvertiserID = anyString(adv, "id")
+		}
+	}
+	return info
+}
+
+// sameAdvertiser reports whether every campaign of g is known to belong to
+// one advertiser.
+func (g creativeGroup) sameAdvertiser(campaigns map[string]campaignInfo) bool {
+	adv := ""
+	for _, id := range g.Campaigns {
+		info, ok := campaigns[id]
+		if !ok || info.AdvertiserID == "" {
+			return false
+		}
+		if adv != "" && info.AdvertiserID != adv {
+			return false
+		}
+		adv = info.AdvertiserID
+	}
+	return adv != ""
+}
+
+// fetchListing pages a gateway listing until it is exhausted or maxPages is
+// reached, returning the rows, one step per page and whether the cap cut it
+// short. base may carry its own query string.
+func (c *ChatService) fetchListing(ctx context.Context, base, tool string, pageSize, maxPages int) ([]map[string]any, []models.Step, bool, error) {
+	rows := make([]map[string]any, 0, pageSize)
+	steps := make([]models.Step, 0, 1)
+	var fetched int64
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+	}
+	for page := 1; ; page++ {
+		status, body, err := c.Gateway.Get(ctx, fmt.Sprintf("%s%spage=%d&page_size=%d", base, sep, page, pageSize))
+		step := models.Step{Tool: tool, Status: status}
+		if err != nil {
+			step.Error = err.Error()
+			steps = append(steps, step)
+			return rows, steps, false, err
+		}
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		steps = append(steps, step)
+		if status < 200 || status >= 300 {
+			return rows, steps, false, fmt.Errorf("status %d", status)
+		}
+		var root map[string]any
+		_ = json.Unmarshal(body, &root)
+		pageRows := parseRows(body)
+		for _, it := range pageRows {
+			if m, ok := it.(map[string]any); ok {
+				rows = append(rows, m)
+			}
+		}
+		fetched += int64(len(pageRows))
+		if paginationFrom(root).done(len(pageRows), pageSize, fetched) {
+			return rows, steps, false, nil
+		}
+		if page >= maxPages {
+			return rows, steps, true, nil
+		}
+	}
+}
+
+// handleCreativeReuse answers "which creatives are used in more than one
+// campaign": the same file attached to several campaigns splits its
+// impressions across them.
+func (c *ChatService) handleCreativeReuse(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	if !isCreativeReuseIntent(strings.ToLower(req.Message)) {
+		return models.ChatResponse{}, false, nil
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	if c.Gateway == nil {
+		return reply(models.ChatResponse{Answer: "Tool gateway is not configured."})
+	}
+	maxPages := c.CreativeReuseMaxPages
+	if maxPages <= 0 {
+		maxPages = 10
+	}
+	creatives, steps, capped, err := c.fetchListing(ctx, "/ads/creatives", "adsCreatives", c.limits().ListPageSize, maxPages)
+	if err != nil {
+		return reply(models.ChatResponse{Answer: "Failed to list creatives: " + err.Error(), Steps: steps})
+	}
+	groups := groupReusedCreatives(creatives)
+	scanned := fmt.Sprintf("%d creatives scanned", len(creatives))
+	if capped {
+		scanned += fmt.Sprintf(", stopped at the %d-page limit", maxPages)
+	}
+	if len(groups) == 0 {
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("No creative is attached to more than one campaign (%s).", scanned), Steps: steps})
+	}
+
+	// One bulk listing resolves campaign names; ids stay visible when a
+	// campaign is missing from it.
+	campaigns := map[string]campaignInfo{}
+	campaignRows, campaignSteps, _, cerr := c.fetchListing(ctx, "/ads/campaigns", "adsCampaigns", 200, 5)
+	steps = append(steps, campaignSteps...)
+	if cerr == nil {
+		for _, m := range campaignRows {
+			if id := anyString(m, "id", "campaign_id"); id != "" {
+				campaigns[id] = campaignInfoFrom(m)
+			}
+		}
+	}
+
+	duplicated := 0
+	for _, g := range groups {
+		duplicated += g.Creatives
+	}
+	lines := []string{fmt.Sprintf("%d creative file(s) are attached to more than one campaign (%d duplicated creatives; %s):", len(groups), duplicated, scanned)}
+	for i, g := range groups {
+		if i >= creativeReuseMaxGroups {
+			break
+		}
+		labels := make([]string, 0, len(g.Campaigns))
+		for _, id := range g.Campaigns {
+			if name := campaigns[id].Name; name != "" {
+				labels = append(labels, fmt.Sprintf("%s (%s)", name, id))
+			} else {
+				labels = append(labels, id)
+			}
+		}
+		line := fmt.Sprintf("%d. %s — %d campaigns: %s", i+1, g.Name, len(g.Campaigns), strings.Join(labels, ", "))
+		if g.Basis == "name" {
+			line += " [matched by name]"
+		}
+		if g.sameAdvertiser(campaigns) {
+			line += " [same advertiser — likely intentional]"
+		}
+		lines = append(lines, line)
+	}
+	if rest := len(groups) - creativeReuseMaxGroups; rest > 0 {
+		lines = append(lines, fmt.Sprintf("…and %d more group(s).", rest))
+	}
+	if cerr != nil {
+		lines = append(lines, "Campaign names could not be loaded ("+cerr.Error()+"), so campaigns are shown by id.")
+	}
+	lines = append(lines, "Impressions of a shared file are split across its campaigns, so per-campaign attribution is unreliable for these.")
+	return reply(models.ChatResponse{Answer: strings.Join(lines, "\n"), Steps: steps})
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"openai-agent-service/internal/models"
+)
+
+// Creative review states, in the order summaries list them.
+var creativeStatuses = []string{"pending", "processing", "approved", "rejected"}
+
+// creativeStatusFilterRe matches a review state named in a question:
+// "pending creatives", "awaiting approval", "declined".
+var creativeStatusFilterRe = regexp.MustCompile(`\b(pending|awaiting|in\s+review|under\s+review|approved|rejected|declined|processing)\b`)
+
+// creativeStatusWordsRe is the review state wording removed before a
+// campaign name is read ("pending creatives awaiting approval for ...").
+var creativeStatusWordsRe = regexp.MustCompile(creativeStatusFilterRe.String() + `|\bapproval\b`)
+
+// creativeStatusFilter returns the review state msgLower filters creatives
+// by, or "".
+func creativeStatusFilter(msgLower string) string {
+	m := creativeStatusFilterRe.FindStringSubmatch(msgLower)
+	if m == nil {
+		return ""
+	}
+	return normalizeCreativeStatus(m[1])
+}
+
+// normalizeCreativeStatus folds the gateway's and users' spellings of a
+// review state onto creativeStatuses. Unknown states are returned lower
+// cased.
+func normalizeCreativeStatus(s string) string {
+	s = strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return r == ' ' || r == '_' || r == '-' }), " ")
+	switch s {
+	case "pending", "awaiting", "pending approval", "pending review", "in review", "under review", "submitted":
+		return "pending"
+	case "approved", "accepted":
+		return "approved"
+	case "rejected", "declined", "denied":
+		return "rejected"
+	case "processing", "transcoding", "uploading":
+		return "processing"
+	}
+	return s
+}
+
+// creativeStatusKeys are the fields a creative may carry its review state
+// under; deployments differ.
+var creativeStatusKeys = []string{"approval_status", "approvalStatus", "review_status", "reviewStatus", "status"}
+
+// creativeStatus returns the creative's normalized review state, or "" when
+// the row carries none.
+func creativeStatus(m map[string]any) string {
+	if s := anyString(m, creativeStatusKeys...); s != "" {
+		return normalizeCreativeStatus(s)
+	}
+	return ""
+}
+
+// creativeStatusSummary counts rows by review state: "3 approved, 1
+// pending". It is "" when no row carries a state.
+func creativeStatusSummary(rows []map[string]any) string {
+	counts := map[string]int{}
+	for _, m := range rows {
+		if s := creativeStatus(m); s != "" {
+			counts[s]++
+		}
+	}
+	if len(counts) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(counts))
+	for _, s := range creativeStatuses {
+		if n := counts[s]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, s))
+			delete(counts, s)
+		}
+	}
+	other := make([]string, 0, len(counts))
+	for s := range counts {
+		other = append(other, s)
+	}
+	sort.Strings(other)
+	for _, s := range other {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[s], s))
+	}
+	return "By status: " + strings.Join(parts, ", ") + "."
+}
+
+// hasCreativeStatus reports whether any row carries a review state.
+func hasCreativeStatus(rows []map[string]any) bool {
+	for _, m := range rows {
+		if creativeStatus(m) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// filterCreativesByStatus keeps the rows in the given review state.
+func filterCreativesByStatus(rows []map[string]any, status string) []map[string]any {
+	out := make([]map[string]any, 0, len(rows))
+	for _, m := range rows {
+		if creativeStatus(m) == status {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// creativeStatusNote is said once, instead of a status per row, when the
+// deployment's creatives carry no review state.
+const creativeStatusNote = "Approval status is not reported for creatives on this system."
+
+// creativeStatusQueryParam returns the query parameter /ads/creatives takes
+// a review state filter under according to the gateway's spec, or "" when
+// it takes none and the rows have to be filtered here.
+func (c *ChatService) creativeStatusQueryParam(ctx context.Context) string {
+	if c.Catalog == nil {
+		return ""
+	}
+	spec, err := c.Catalog.Fetch(ctx)
+	if err != nil {
+		return ""
+	}
+	op := specOperation(spec, "/ads/creatives", "get")
+	params, _ := op["parameters"].([]any)
+	for _, p := range params {
+		pm, ok := p.(map[string]any)
+		if !ok || anyString(pm, "in") != "query" {
+			continue
+		}
+		switch name := anyString(pm, "name"); name {
+		case "approval_status", "status", "review_status":
+			return name
+		}
+	}
+	return ""
+}
+
+// creativeLabel renders a creative as "name — type — file_url", with its
+// review state when withStatus is set.
+func creativeLabel(m map[string]any, withStatus bool) string {
+	name := anyString(m, "name")
+	id := anyString(m, "id")
+	if name == "" && id == "" {
+		return ""
+	}
+	label := name
+	if label == "" {
+		label = id
+	}
+	if typeStr := anyString(m, "type"); typeStr != "" {
+		label += " — " + typeStr
+	}
+	if withStatus {
+		if s := creativeStatus(m); s != "" {
+			label += " — " + s
+		}
+	}
+	if fileURL := anyString(m, "file_url", "fileUrl"); fileURL != "" {
+		label += " — " + fileURL
+	}
+	return label
+}
+
+// allCampaignsRe matches a question scoped to every campaign.
+var allCampaignsRe = regexp.MustCompile(`\b(?:all|every|any)\s+campaigns?\b|\bcampaigns\b`)
+
+// isCreativesByStatusIntent matches creatives filtered by review state
+// across the whole account: "pending creatives across all campaigns",
+// "which creatives were rejected". A question naming one campaign is left to
+// handleCampaignCreatives.
+func isCreativesByStatusIntent(msgLower string) bool {
+	if !strings.Contains(msgLower, "creative") || creativeStatusFilter(msgLower) == "" {
+		return false
+	}
+	if strings.Contains(msgLower, "upload") {
+		return false
+	}
+	if !strings.Contains(msgLower, "campaign") {
+		return true
+	}
+	return allCampaignsRe.MatchString(msgLower)
+}
+
+// handleCreativesByStatus lists the account's creatives in one review
+// state. The filter is passed to /ads/creatives when the gateway spec
+// declares it, and applied here otherwise.
+func (c *ChatService) handleCreativesByStatus(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !isCreativesByStatusIntent(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	if c.Gateway == nil {
+		return reply(models.ChatResponse{Answer: "Tool gateway is not configured."})
+	}
+	status := creativeStatusFilter(msgLower)
+	base := "/ads/creatives"
+	if param := c.creativeStatusQueryParam(ctx); param != "" {
+		base += "?" + param + "=" + urlEscape(status)
+	}
+	maxPages := c.CreativeReuseMaxPages
+	if maxPages <= 0 {
+		maxPages = 10
+	}
+	rows, steps, capped, err := c.fetchListing(ctx, base, "adsCreatives", c.limits().ListPageSize, maxPages)
+	if err != nil {
+		return reply(models.ChatResponse{Answer: "Failed to list creatives: " + err.Error(), Steps: steps})
+	}
+	if len(rows) > 0 && !hasCreativeStatus(rows) {
+		return reply(models.ChatResponse{Answer: creativeStatusNote + " I can't tell which creatives are " + status + ".", Steps: steps})
+	}
+	// Filter here even when the gateway did, in case it ignored the
+	// parameter.
+	matched := filterCreativesByStatus(rows, status)
+	scanned := fmt.Sprintf("%d creatives scanned", len(rows))
+	if capped {
+		scanned += fmt.Sprintf(", stopped at the %d-page limit", maxPages)
+	}
+	if len(matched) == 0 {
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("No %s creatives found (%s).", status, scanned), Steps: steps})
+	}
+	listed := c.capList(msgLower, len(matched), status+" creatives")
+	listed.More = capped && listed.Shown >= listed.Total
+	lines := make([]string, 0, listed.Shown+2)
+	lines = append(lines, fmt.Sprintf("%d %s creatives across all campaigns (%s):", len(matched), status, scanned))
+	for _, m := range matched[:listed.Shown] {
+		label := creativeLabel(m, false)
+		if label == "" {
+			continue
+		}
+		if campaign := anyString(m, "campaign_name", "campaignName", "campaign_id", "campaignId"); campaign != "" {
+			label += " (campaign " + campaign + ")"
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s", len(lines), label))
+	}
+	if note := listed.note(); note != "" {
+		lines = append(lines, note)
+	}
+	return reply(models.ChatResponse{Answer: strings.Join(lines, "\n"), Steps: steps})
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"openai-agent-service/internal/models"
+)
+
+// pixelPNG is a 1x1 transparent PNG.
+const pixelPNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg=="
+
+func TestCreativeUploadValidation(t *testing.T) {
+	// A PNG header padded past the per-file limit set below.
+	oversized := base64.StdEncoding.EncodeToString(append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 200)...))
+	msg := "upload creative to campaign " + testCampaignID + " on mon,tue 08:00-12:00 devices: moco-brt-briggs-001,moco-brt-briggs-002"
+
+	cases := []struct {
+		name       string
+		attachment models.ChatAttachment
+		want       []string
+		uploaded   bool
+	}{
+		{
+			name:       "tiny valid png",
+			attachment: models.ChatAttachment{FileName: "pixel.png", ContentType: "image/png", Base64: pixelPNG},
+			want:       []string{"Uploaded 1 file(s):", "- pixel.png → creative cr-1"},
+			uploaded:   true,
+		},
+		{
+			name:       "declared type differs from contents",
+			attachment: models.ChatAttachment{FileName: "pixel.jpg", ContentType: "image/jpeg", Base64: pixelPNG},
+			want:       []string{"Upload rejected before sending anything to the gateway:", "- pixel.jpg: declared content type image/jpeg does not match file contents (image/png)"},
+		},
+		{
+			name:       "over the per-file limit",
+			attachment: models.ChatAttachment{FileName: "big.png", ContentType: "image/png", Base64: oversized},
+			want:       []string{"Upload rejected before sending anything to the gateway:", "- big.png: ", "exceeds the per-file limit"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newFakeGateway(t, &fakeGateway{Routes: map[string]string{
+				"/ads/creatives/upload": `{"data":{"results":[{"file_name":"pixel.png","creative_id":"cr-1"}]}}`,
+			}})
+			c := newTestChat(g)
+			c.MaxUploadFileBytes = 128
+			resp, handled, err := c.handleCreativeUpload(context.Background(), "test-key", models.ChatRequest{
+				Message:     msg,
+				Attachments: []models.ChatAttachment{tc.attachment},
+			})
+			if err != nil || !handled {
+				t.Fatalf("handled=%v err=%v", handled, err)
+			}
+			for _, w := range tc.want {
+				if !strings.Contains(resp.Answer, w) {
+					t.Errorf("answer\n%s\nlacks %q", resp.Answer, w)
+				}
+			}
+			want := 0
+			if tc.uploaded {
+				want = 1
+			}
+			if got := len(g.Calls("/ads/creatives/upload")); got != want {
+				t.Errorf("%d upload calls, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestValidateCreativeAttachmentsSniffsContentType(t *testing.T) {
+	c := &ChatService{}
+	files, problems := c.validateCreativeAttachments([]models.ChatAttachment{{FileName: "pixel", Base64: pixelPNG}})
+	if len(problems) > 0 || len(files) != 1 {
+		t.Fatalf("files=%v problems=%v", files, problems)
+	}
+	if files[0].ContentType != "image/png" {
+		t.Errorf("content type %q, want the sniffed image/png", files[0].ContentType)
+	}
+}
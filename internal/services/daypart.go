@@ -0,0 +1,266 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// defaultDayparts are the time-of-day windows POP questions can name;
+// ChatService.Dayparts overrides them by name and adds to them.
+var defaultDayparts = []models.Daypart{
+	{Name: "morning", Start: 6 * 60, End: 10 * 60},
+	{Name: "midday", Start: 10 * 60, End: 15 * 60},
+	{Name: "evening rush", Start: 15 * 60, End: 19 * 60},
+	{Name: "night", Start: 19 * 60, End: 24 * 60},
+	{Name: "overnight", Start: 0, End: 6 * 60},
+}
+
+// daypartAliases are other names for the built-in dayparts.
+var daypartAliases = map[string]string{
+	"morning rush": "morning", "morning peak": "morning", "am rush": "morning", "am peak": "morning",
+	"lunchtime": "midday", "lunch time": "midday", "lunch hour": "midday",
+	"evening": "evening rush", "evening peak": "evening rush", "pm rush": "evening rush", "pm peak": "evening rush",
+	"nighttime": "night", "night time": "night", "overnights": "overnight",
+}
+
+// daypartCustomRe matches an inline window: "between 7am and 9am", "from
+// 7:30 to 9", "7-9am". A bound needs am/pm or minutes so dates and counts
+// are not read as hours.
+var daypartCustomRe = regexp.MustCompile(`\b(?:(?:between|from)\s+)?(\d{1,2})(?::(\d{2}))?\s*(am|pm|a\.m\.|p\.m\.)?\s*(?:-|–|to|and|until|till)\s*(\d{1,2})(?::(\d{2}))?\s*(am|pm|a\.m\.|p\.m\.)?(?:\s|$|[?.!,])`)
+
+// relativeWindowRe matches the relative windows a poster play count reads
+// with exportWindow; month names are left out so poster names such as "May
+// Flowers" are not read as dates.
+var relativeWindowRe = regexp.MustCompile(`\b(?:(?:last|this|past|previous)\s+(?:week|month|\d{1,3}\s+days?)|yesterday|today)\b`)
+
+// daypartLeadRe is the wording before a daypart name that goes with it
+// when the phrase is cut from the message.
+var daypartLeadRe = regexp.MustCompile(`(?:\b(?:during|in|at|for|over)\s+)?(?:\b(?:the|each|every)\s+)?$`)
+
+// daypartFilter limits POP rows to a time-of-day window, applied to each
+// day's rows in the request timezone since the gateway filters by absolute
+// from/to only. Name is empty for an inline window.
+type daypartFilter struct {
+	Name       string
+	Start, End int
+}
+
+// dayparts returns the built-in dayparts with c.Dayparts applied.
+func (c *ChatService) dayparts() []models.Daypart {
+	out := append([]models.Daypart(nil), defaultDayparts...)
+	for _, d := range c.Dayparts {
+		replaced := false
+		for i := range out {
+			if out[i].Name == d.Name {
+				out[i], replaced = d, true
+			}
+		}
+		if !replaced {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// parseDaypart finds a named daypart ("during evening rush") or an inline
+// window ("between 7am and 9am") in msg. It returns nil and msg when there
+// is none, and otherwise msg with the phrase cut out so the date and name
+// parsers do not read it.
+func (c *ChatService) parseDaypart(msg string) (*daypartFilter, string) {
+	lower := strings.ToLower(msg)
+	if m := daypartCustomRe.FindStringSubmatchIndex(lower); m != nil {
+		if dp, ok := customDaypart(lower, m); ok {
+			return dp, cutPhrase(msg, m[0], m[1])
+		}
+	}
+	parts := c.dayparts()
+	byName := make(map[string]models.Daypart, len(parts))
+	names := make([]string, 0, len(parts)+len(daypartAliases))
+	for _, d := range parts {
+		byName[d.Name] = d
+		names = append(names, d.Name)
+	}
+	for alias, name := range daypartAliases {
+		if _, ok := byName[alias]; !ok {
+			if d, ok := byName[name]; ok {
+				byName[alias] = d
+				names = append(names, alias)
+			}
+		}
+	}
+	// Longest first, so "evening rush" wins over "evening".
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		at, end := phraseIndex(lower, name)
+		if at < 0 {
+			continue
+		}
+		d := byName[name]
+		dp := &daypartFilter{Name: d.Name, Start: d.Start, End: d.End}
+		// "last night" and "this morning" also name the day.
+		for prefix, day := range map[string]string{"last ": "yesterday", "this ": "today"} {
+			if strings.HasSuffix(lower[:at], prefix) {
+				return dp, strings.Join(strings.Fields(msg[:at-len(prefix)]+day+" "+msg[end:]), " ")
+			}
+		}
+		if lead := daypartLeadRe.FindStringIndex(lower[:at]); lead != nil {
+			at = lead[0]
+		}
+		return dp, cutPhrase(msg, at, end)
+	}
+	return nil, msg
+}
+
+// phraseIndex finds phrase, or its plural, as whole words in lower and
+// returns where it starts and ends; -1 when it is not there.
+func phraseIndex(lower, phrase string) (int, int) {
+	isWord := func(b byte) bool { return b >= 'a' && b <= 'z' || b >= '0' && b <= '9' }
+	for from := 0; ; {
+		i := strings.Index(lower[from:], phrase)
+		if i < 0 {
+			return -1, -1
+		}
+		at := from + i
+		end := at + len(phrase)
+		if end < len(lower) && lower[end] == 's' {
+			end++
+		}
+		if (at == 0 || !isWord(lower[at-1])) && (end == len(lower) || !isWord(lower[end])) {
+			return at, end
+		}
+		from = at + 1
+	}
+}
+
+// customDaypart reads the bounds of a daypartCustomRe match. A bound
+// without am/pm takes the other's ("7 to 9am"); one with neither is a
+// 24-hour clock time.
+func customDaypart(lower string, m []int) (*daypartFilter, bool) {
+	group := func(i int) string {
+		if m[2*i] < 0 {
+			return ""
+		}
+		return lower[m[2*i]:m[2*i+1]]
+	}
+	h1, min1, ap1 := group(1), group(2), strings.ReplaceAll(group(3), ".", "")
+	h2, min2, ap2 := group(4), group(5), strings.ReplaceAll(group(6), ".", "")
+	if ap1 == "" && ap2 == "" && (min1 == "" || min2 == "") {
+		return nil, false
+	}
+	if ap1 == "" && min1 == "" {
+		ap1 = ap2
+	}
+	if ap2 == "" && min2 == "" {
+		ap2 = ap1
+	}
+	start, ok1 := clockMinutes(h1, min1, ap1)
+	end, ok2 := clockMinutes(h2, min2, ap2)
+	if !ok1 || !ok2 || start == end || start == 24*60 {
+		return nil, false
+	}
+	return &daypartFilter{Start: start, End: end}, true
+}
+
+// clockMinutes converts an hour, optional minutes and optional am/pm to
+// minutes after midnight; "24:00" is midnight at the end of the day.
+func clockMinutes(hour, minute, ampm string) (int, bool) {
+	h, err := strconv.Atoi(hour)
+	if err != nil {
+		return 0, false
+	}
+	m := 0
+	if minute != "" {
+		if m, err = strconv.Atoi(minute); err != nil || m > 59 {
+			return 0, false
+		}
+	}
+	switch ampm {
+	case "am", "pm":
+		if h < 1 || h > 12 {
+			return 0, false
+		}
+		h %= 12
+		if ampm == "pm" {
+			h += 12
+		}
+	default:
+		if h > 24 || (h == 24 && m > 0) {
+			return 0, false
+		}
+	}
+	return h*60 + m, true
+}
+
+// cutPhrase removes msg[start:end] and tidies the spaces around it.
+func cutPhrase(msg string, start, end int) string {
+	return strings.Join(strings.Fields(msg[:start]+" "+msg[end:]), " ")
+}
+
+// contains reports whether t falls in the window on its local day in loc.
+func (dp *daypartFilter) contains(t time.Time, loc *time.Location) bool {
+	l := t.In(loc)
+	m := l.Hour()*60 + l.Minute()
+	if dp.Start < dp.End {
+		return m >= dp.Start && m < dp.End
+	}
+	return m >= dp.Start || m < dp.End
+}
+
+// filter keeps the rows played inside the window.
+func (dp *daypartFilter) filter(rows []popItem, loc *time.Location) []popItem {
+	kept := rows[:0:0]
+	for _, it := range rows {
+		if !it.PopDatetime.IsZero() && dp.contains(it.PopDatetime, loc) {
+			kept = append(kept, it)
+		}
+	}
+	return kept
+}
+
+// hours lists the local hours the window touches, in order from its start
+// (22, 23, 0, 1 for a window across midnight).
+func (dp *daypartFilter) hours() []int {
+	end := dp.End
+	if end <= dp.Start {
+		end += 24 * 60
+	}
+	var out []int
+	for h := dp.Start / 60; h*60 < end; h++ {
+		out = append(out, h%24)
+	}
+	return out
+}
+
+func clockLabel(m int) string {
+	return fmt.Sprintf("%02d:%02d", m/60, m%60)
+}
+
+// label names the window in an answer: its name, or its bounds when inline.
+func (dp *daypartFilter) label() string {
+	if dp.Name != "" {
+		return dp.Name
+	}
+	return clockLabel(dp.Start) + "–" + clockLabel(dp.End)
+}
+
+// note states the window the answer was limited to and its timezone.
+func (dp *daypartFilter) note(req models.ChatRequest, loc *time.Location) string {
+	if dp == nil {
+		return ""
+	}
+	if dp.Name == "" {
+		return say(req, "pop_daypart_custom", clockLabel(dp.Start), clockLabel(dp.End), loc.String())
+	}
+	return say(req, "pop_daypart", dp.Name, clockLabel(dp.Start), clockLabel(dp.End), loc.String())
+}
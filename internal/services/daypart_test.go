@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+func TestParseDaypart(t *testing.T) {
+	h := func(hour, minute int) int { return hour*60 + minute }
+	cases := []struct {
+		msg         string
+		name        string
+		start, end  int
+		rest        string // msg with the phrase cut out
+		none        bool
+		overrideTo9 bool // morning configured as 07:00–09:00
+	}{
+		{msg: "plays for poster Bet 365 during morning in brt", name: "morning", start: h(6, 0), end: h(10, 0), rest: "plays for poster Bet 365 in brt"},
+		{msg: "plays for poster Bet 365 at midday", name: "midday", start: h(10, 0), end: h(15, 0), rest: "plays for poster Bet 365"},
+		{msg: "plays during evening rush last week", name: "evening rush", start: h(15, 0), end: h(19, 0), rest: "plays last week"},
+		{msg: "plays in the evening", name: "evening rush", start: h(15, 0), end: h(19, 0), rest: "plays"},
+		{msg: "night plays on briggs-001", name: "night", start: h(19, 0), end: h(24, 0), rest: "plays on briggs-001"},
+		{msg: "overnight plays", name: "overnight", start: 0, end: h(6, 0), rest: "plays"},
+		{msg: "plays during mornings", name: "morning", start: h(6, 0), end: h(10, 0), rest: "plays"},
+		{msg: "plays last night", name: "night", start: h(19, 0), end: h(24, 0), rest: "plays yesterday"},
+		{msg: "plays this morning", name: "morning", start: h(6, 0), end: h(10, 0), rest: "plays today"},
+		{msg: "plays during morning", name: "morning", start: h(7, 0), end: h(9, 0), rest: "plays", overrideTo9: true},
+		// Inline windows.
+		{msg: "plays between 7am and 9am in brt", start: h(7, 0), end: h(9, 0), rest: "plays in brt"},
+		{msg: "plays 7-9am", start: h(7, 0), end: h(9, 0), rest: "plays"},
+		{msg: "plays from 7:30 to 9:15", start: h(7, 30), end: h(9, 15), rest: "plays"},
+		{msg: "plays from 10pm to 2am", start: h(22, 0), end: h(2, 0), rest: "plays"},
+		{msg: "plays between 12pm and 1pm", start: h(12, 0), end: h(13, 0), rest: "plays"},
+		// Not a daypart.
+		{msg: "plays from 2024-10-01 to 2024-10-31", none: true},
+		{msg: "top 5 to 10 posters", none: true},
+		{msg: "plays between 13pm and 2pm", none: true},
+		{msg: "plays for poster Mornington", none: true},
+	}
+	for _, tc := range cases {
+		c := &ChatService{}
+		if tc.overrideTo9 {
+			c.Dayparts = []models.Daypart{{Name: "morning", Start: h(7, 0), End: h(9, 0)}}
+		}
+		dp, rest := c.parseDaypart(tc.msg)
+		if tc.none {
+			if dp != nil || rest != tc.msg {
+				t.Errorf("parseDaypart(%q) = %+v, %q; want none", tc.msg, dp, rest)
+			}
+			continue
+		}
+		if dp == nil || dp.Name != tc.name || dp.Start != tc.start || dp.End != tc.end || rest != tc.rest {
+			t.Errorf("parseDaypart(%q) = %+v, %q; want %s %d–%d, %q", tc.msg, dp, rest, tc.name, tc.start, tc.end, tc.rest)
+		}
+	}
+}
+
+// Windows are local hours, so the same UTC time falls in a different
+// daypart on either side of a DST change.
+func TestDaypartDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	morning := &daypartFilter{Name: "morning", Start: 6 * 60, End: 10 * 60}
+	overnight := &daypartFilter{Name: "overnight", Start: 0, End: 6 * 60}
+	utc := func(m time.Month, d, hour, min int) time.Time {
+		return time.Date(2024, m, d, hour, min, 0, 0, time.UTC)
+	}
+	cases := []struct {
+		at   time.Time
+		dp   *daypartFilter
+		want bool
+	}{
+		// Spring forward on Mar 10: 10:30 UTC is 05:30 EST, then 06:30 EDT.
+		{utc(time.March, 9, 10, 30), morning, false},
+		{utc(time.March, 10, 10, 30), morning, true},
+		{utc(time.March, 9, 14, 30), morning, true},   // 09:30 EST
+		{utc(time.March, 10, 14, 30), morning, false}, // 10:30 EDT
+		// Fall back on Nov 3: 10:30 UTC is 06:30 EDT, then 05:30 EST.
+		{utc(time.November, 2, 10, 30), morning, true},
+		{utc(time.November, 3, 10, 30), morning, false},
+		{utc(time.November, 3, 10, 30), overnight, true},
+		// Both 01:30s of the repeated hour are overnight.
+		{utc(time.November, 3, 5, 30), overnight, true},
+		{utc(time.November, 3, 6, 30), overnight, true},
+	}
+	for _, tc := range cases {
+		if got := tc.dp.contains(tc.at, ny); got != tc.want {
+			t.Errorf("%s contains %v (%v) = %v, want %v", tc.dp.Name, tc.at, tc.at.In(ny).Format("15:04 MST"), got, tc.want)
+		}
+	}
+}
+
+func TestDaypartHours(t *testing.T) {
+	cases := []struct {
+		dp   daypartFilter
+		want []int
+	}{
+		{daypartFilter{Start: 6 * 60, End: 10 * 60}, []int{6, 7, 8, 9}},
+		{daypartFilter{Start: 7*60 + 30, End: 9*60 + 15}, []int{7, 8, 9}},
+		{daypartFilter{Start: 22 * 60, End: 2 * 60}, []int{22, 23, 0, 1}},
+		{daypartFilter{Start: 19 * 60, End: 24 * 60}, []int{19, 20, 21, 22, 23}},
+	}
+	for _, tc := range cases {
+		got := tc.dp.hours()
+		if len(got) != len(tc.want) {
+			t.Errorf("hours(%s) = %v, want %v", tc.dp.label(), got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("hours(%s) = %v, want %v", tc.dp.label(), got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+// A daypart over a multi-day range keeps each day's rows in the window; the
+// fixture's Bet 365 brt rows are 120 at 09:00, 80 at 14:00, 45 at 18:00 and
+// 60 at 11:00 UTC.
+func TestDaypartPosterPlays(t *testing.T) {
+	cases := []struct {
+		msg  string
+		tz   string
+		want []string
+	}{
+		{msg: "play count for poster Bet 365 in brt during morning from 2024-10-01 to 2024-10-31",
+			want: []string{"120 plays", "during morning (06:00–10:00 UTC)"}},
+		{msg: "play count for poster Bet 365 in brt at midday from 2024-10-01 to 2024-10-31",
+			want: []string{"140 plays", "during midday (10:00–15:00 UTC)"}},
+		{msg: "play count for poster Bet 365 in brt during evening rush from 2024-10-01 to 2024-10-31",
+			want: []string{"45 plays", "during evening rush (15:00–19:00 UTC)"}},
+		{msg: "play count for poster Bet 365 in brt between 2pm and 7pm from 2024-10-01 to 2024-10-31",
+			want: []string{"125 plays", "from 14:00 to 19:00 (UTC)"}},
+		// In Chicago (UTC-5 in October) the morning rows are 09:00 and
+		// 06:00 local: 80 + 60.
+		{msg: "play count for poster Bet 365 in brt during morning from 2024-10-01 to 2024-10-31", tz: "America/Chicago",
+			want: []string{"140 plays", "during morning (06:00–10:00 America/Chicago)"}},
+		{msg: "play count for poster Bet 365 in brt overnight from 2024-10-01 to 2024-10-31",
+			want: []string{"No play counts found", "during overnight"}},
+	}
+	for _, tc := range cases {
+		g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})
+		resp, err := newTestChat(g).Chat(context.Background(), "test-key", models.ChatRequest{Message: tc.msg, Timezone: tc.tz})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range tc.want {
+			if !strings.Contains(resp.Answer, w) {
+				t.Errorf("%q (%s): answer %q lacks %q", tc.msg, tc.tz, resp.Answer, w)
+			}
+		}
+		// The whole range is read; only the rows are filtered.
+		for _, call := range g.Calls("/pop?") {
+			if !strings.Contains(call, "from=2024-10-01") || strings.Contains(call, "hour") {
+				t.Errorf("%q: /pop call %s is not the full range", tc.msg, call)
+			}
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"openai-agent-service/internal/models"
+)
+
+// DebugStore persists debug bundles. PostgresStore implements it.
+type DebugStore interface {
+	SaveDebugBundle(ctx context.Context, b models.DebugBundle) error
+	GetDebugBundle(ctx context.Context, id string) (models.DebugBundle, error)
+	DeleteExpiredDebugBundles(ctx context.Context, now time.Time) (int64, error)
+}
+
+// debugCapture collects a debug bundle while one request runs. It travels in
+// the request context so the gateway client can record full bodies before
+// handlers clip them into Steps. All methods are safe on a nil receiver, which
+// is what requests without debug capture see.
+type debugCapture struct {
+	mu        sync.Mutex
+	budget    int
+	truncated bool
+	calls     []models.DebugCall
+	openAI    json.RawMessage
+	stages    []models.DebugStage
+	handler   string
+	start     time.Time
+	mark      time.Time
+}
+
+type debugCaptureKey struct{}
+
+func newDebugCapture(maxBytes int) *debugCapture {
+	now := time.Now()
+	return &debugCapture{budget: maxBytes, start: now, mark: now}
+}
+
+func withDebugCapture(ctx context.Context, d *debugCapture) context.Context {
+	return context.WithValue(ctx, debugCaptureKey{}, d)
+}
+
+func debugFrom(ctx context.Context) *debugCapture {
+	d, _ := ctx.Value(debugCaptureKey{}).(*debugCapture)
+	return d
+}
+
+// take charges n bytes against the budget and returns how many may be kept.
+// Callers hold d.mu.
+func (d *debugCapture) take(n int) int {
+	if n > d.budget {
+		n = max(d.budget, 0)
+		d.truncated = true
+	}
+	d.budget -= n
+	return n
+}
+
+// redactedPath strips the gateway base URL and masks credential-looking query
+// parameters.
+func redactedPath(base, rawURL string) string {
+	p := strings.TrimPrefix(rawURL, strings.TrimRight(strings.TrimSpace(base), "/"))
+	u, err := url.Parse(p)
+	if err != nil {
+		return p
+	}
+	q := u.Query()
+	changed := false
+	for k := range q {
+		kl := strings.ToLower(k)
+		if strings.Contains(kl, "key") || strings.Contains(kl, "token") || strings.Contains(kl, "secret") || strings.Contains(kl, "password") {
+			q.Set(k, "<redacted>")
+			changed = true
+		}
+	}
+	if changed {
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// call records one gateway request. reqBody should already be summarized
+// (summarizeMutationBody) so secrets and file contents stay out of the bundle.
+func (d *debugCapture) call(method, path string, reqBody any, status int, body []byte, err error, start time.Time) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dc := models.DebugCall{Method: method, Path: path, RequestBody: reqBody, Status: status, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		dc.Error = err.Error()
+	}
+	if keep := d.take(len(body)); keep < len(body) {
+		dc.Truncated = true
+		body = body[:keep]
+	}
+	dc.Body = string(body)
+	d.calls = append(d.calls, dc)
+}
+
+// stage records the time since the previous stage ended.
+func (d *debugCapture) stage(name string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	d.stages = append(d.stages, models.DebugStage{Name: name, DurationMs: now.Sub(d.mark).Milliseconds()})
+	d.mark = now
+}
+
+func (d *debugCapture) openAIMessages(msgs []OpenAIMessage) {
+	if d == nil {
+		return
+	}
+	raw, err := json.Marshal(msgs)
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.take(len(raw)) < len(raw) {
+		// A clipped message list is not valid JSON; keep none rather than half.
+		return
+	}
+	d.openAI = raw
+}
+
+// debugHandler notes which deterministic handler answered the request.
+func debugHandler(ctx context.Context, name string) {
+	if r := answerRouteFrom(ctx); r != nil {
+		r.handler = name
+	}
+	stageTimerFrom(ctx).lap(stageDispatch)
+	d := debugFrom(ctx)
+	if d == nil {
+		return
+	}
+	d.stage(name)
+	d.mu.Lock()
+	d.handler = name
+	d.mu.Unlock()
+}
+
+func (d *debugCapture) bundle(ownerKey string, req models.ChatRequest, resp models.ChatResponse, err error, retention time.Duration) models.DebugBundle {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now().UTC()
+	b := models.DebugBundle{
+		ID:             uuid.NewString(),
+		OwnerKey:       ownerKey,
+		ConversationID: strings.TrimSpace(req.ConversationID),
+		Message:        req.Message,
+		Handler:        d.handler,
+		Answer:         resp.Answer,
+		Calls:          d.calls,
+		OpenAIMessages: d.openAI,
+		Stages:         append(d.stages, models.DebugStage{Name: "total", DurationMs: time.Since(d.start).Milliseconds()}),
+		Truncated:      d.truncated,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(retention),
+	}
+	if err != nil {
+		b.Error = err.Error()
+	}
+	if b.Calls == nil {
+		b.Calls = []models.DebugCall{}
+	}
+	return b
+}
+
+// wantsDebug reports whether a request should be captured: it asked for it,
+// or its conversation was flagged through the admin endpoint.
+func (c *ChatService) wantsDebug(req models.ChatRequest) bool {
+	if c.Debug == nil {
+		return false
+	}
+	if req.Debug {
+		return true
+	}
+	id := strings.TrimSpace(req.ConversationID)
+	if id == "" {
+		return false
+	}
+	c.debugMu.Lock()
+	defer c.debugMu.Unlock()
+	return c.debugConversations[id]
+}
+
+// SetConversationDebug flags (or unflags) a conversation so every request in
+// it captures a debug bundle. Flags live in memory on this instance.
+func (c *ChatService) SetConversationDebug(conversationID string, enabled bool) {
+	id := strings.TrimSpace(conversationID)
+	if id == "" {
+		return
+	}
+	c.debugMu.Lock()
+	defer c.debugMu.Unlock()
+	if !enabled {
+		delete(c.debugConversations, id)
+		return
+	}
+	if c.debugConversations == nil {
+		c.debugConversations = map[string]bool{}
+	}
+	c.debugConversations[id] = true
+}
+
+// saveDebugBundle persists the capture and points resp.Meta at it. Failures
+// are logged; debugging must never break the answer itself.
+func (c *ChatService) saveDebugBundle(ctx context.Context, d *debugCapture, ownerKey string, req models.ChatRequest, resp models.ChatResponse, err error) models.ChatResponse {
+	retention := c.DebugRetention
+	if retention <= 0 {
+		retention = 24 * time.Hour
+	}
+	b := d.bundle(ownerKey, req, resp, err, retention)
+	if serr := c.Debug.SaveDebugBundle(context.WithoutCancel(ctx), b); serr != nil {
+		log.Printf("debug bundle: save failed: %v", serr)
+		return resp
+	}
+	if resp.Meta == nil {
+		resp.Meta = &models.ResponseMeta{}
+	} else {
+		m := *resp.Meta
+		resp.Meta = &m
+	}
+	resp.Meta.DebugID = b.ID
+	return resp
+}
+
+// DebugJanitor deletes expired debug bundles on an interval.
+type DebugJanitor struct {
+	Store    DebugStore
+	Interval time.Duration
+}
+
+func (j *DebugJanitor) Run(ctx context.Context) {
+	interval := j.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if n, err := j.Store.DeleteExpiredDebugBundles(ctx, time.Now()); err != nil {
+				log.Printf("debug janitor: %v", err)
+			} else if n > 0 {
+				log.Printf("debug janitor: deleted %d expired bundle(s)", n)
+			}
+		}
+	}
+}
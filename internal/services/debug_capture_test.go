@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// memDebug is an in-memory DebugStore.
+type memDebug struct {
+	mu      sync.Mutex
+	bundles map[string]models.DebugBundle
+}
+
+func (s *memDebug) SaveDebugBundle(_ context.Context, b models.DebugBundle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bundles == nil {
+		s.bundles = map[string]models.DebugBundle{}
+	}
+	s.bundles[b.ID] = b
+	return nil
+}
+
+func (s *memDebug) GetDebugBundle(_ context.Context, id string) (models.DebugBundle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.bundles[id]
+	if !ok || !b.ExpiresAt.After(time.Now()) {
+		return models.DebugBundle{}, sql.ErrNoRows
+	}
+	return b, nil
+}
+
+func (s *memDebug) DeleteExpiredDebugBundles(_ context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for id, b := range s.bundles {
+		if !b.ExpiresAt.After(now) {
+			delete(s.bundles, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memDebug) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.bundles)
+}
+
+const debugQuestion = "play count for poster Bet 365 in brt"
+
+func TestDebugCapture(t *testing.T) {
+	cases := []struct {
+		name     string
+		debug    bool
+		flag     bool
+		maxBytes int
+		want     bool
+	}{
+		{name: "not asked", want: false},
+		{name: "asked", debug: true, want: true},
+		{name: "flagged conversation", flag: true, want: true},
+		{name: "bounded", debug: true, maxBytes: 64, want: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})
+			c := newTestChat(g)
+			c.Store = newMemStore()
+			c.Gateway.APIKey = "gw-secret-key"
+			debug := &memDebug{}
+			c.Debug = debug
+			c.DebugMaxBytes = tc.maxBytes
+			if tc.flag {
+				c.SetConversationDebug("conv-debug", true)
+			}
+			ctx := context.Background()
+			resp, err := c.Chat(ctx, "owner-a", models.ChatRequest{ConversationID: "conv-debug", Message: debugQuestion, Debug: tc.debug})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !tc.want {
+				if resp.Meta != nil && resp.Meta.DebugID != "" || debug.len() != 0 {
+					t.Fatalf("captured a bundle without being asked")
+				}
+				return
+			}
+			if resp.Meta == nil || resp.Meta.DebugID == "" {
+				t.Fatal("no debug_id in meta")
+			}
+			b, err := debug.GetDebugBundle(ctx, resp.Meta.DebugID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.OwnerKey != "owner-a" || b.ConversationID != "conv-debug" || b.Message != debugQuestion || b.Answer != resp.Answer {
+				t.Errorf("bundle %+v does not describe the request", b)
+			}
+			if b.Handler == "" {
+				t.Error("bundle names no handler")
+			}
+			if n := len(b.Stages); n == 0 || b.Stages[n-1].Name != "total" {
+				t.Errorf("stages = %+v, want them to end with the total", b.Stages)
+			}
+			if got := b.ExpiresAt.Sub(b.CreatedAt); got != 24*time.Hour {
+				t.Errorf("retention = %v, want the 24h default", got)
+			}
+			if len(b.Calls) != len(g.Calls("/")) {
+				t.Errorf("%d calls captured, gateway saw %d", len(b.Calls), len(g.Calls("/")))
+			}
+
+			var kept int
+			for _, call := range b.Calls {
+				kept += len(call.Body)
+			}
+			if tc.maxBytes > 0 {
+				if !b.Truncated || kept > tc.maxBytes {
+					t.Errorf("kept %d body bytes (truncated=%v) under a %d byte budget", kept, b.Truncated, tc.maxBytes)
+				}
+			} else {
+				// Bodies are kept whole, not clipped like Steps.
+				var pop string
+				for _, call := range b.Calls {
+					if strings.HasPrefix(call.Path, "/pop") && call.Status == 200 {
+						pop = call.Body
+						break
+					}
+				}
+				if !json.Valid([]byte(pop)) || !strings.Contains(pop, testBetID) || b.Truncated {
+					t.Errorf("/pop body not captured whole: %q", pop)
+				}
+			}
+
+			raw, _ := json.Marshal(b)
+			for _, secret := range []string{"gw-secret-key", "X-API-Key", "owner-a"} {
+				if strings.Contains(string(raw), secret) {
+					t.Errorf("bundle JSON contains %q", secret)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactedPath(t *testing.T) {
+	cases := []struct {
+		raw, want string
+	}{
+		{"http://gw/pop?poster_id=1", "/pop?poster_id=1"},
+		{"http://gw/ads?api_key=abc&city=moco", "/ads?api_key=%3Credacted%3E&city=moco"},
+		{"http://gw/ads?access_token=abc", "/ads?access_token=%3Credacted%3E"},
+		{"http://gw/ads?client_secret=abc&Password=x", "/ads?Password=%3Credacted%3E&client_secret=%3Credacted%3E"},
+	}
+	for _, tc := range cases {
+		if got := redactedPath("http://gw/", tc.raw); got != tc.want {
+			t.Errorf("redactedPath(%q) = %q, want %q", tc.raw, got, tc.want)
+		}
+	}
+}
+
+// Bundles stop being readable when their retention ends, and the janitor
+// deletes them.
+func TestDebugExpiry(t *testing.T) {
+	g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})
+	c := newTestChat(g)
+	debug := &memDebug{}
+	c.Debug = debug
+	c.DebugRetention = 20 * time.Millisecond
+	ctx := context.Background()
+	resp, err := c.Chat(ctx, "owner-a", models.ChatRequest{Message: debugQuestion, Debug: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := debug.GetDebugBundle(ctx, resp.Meta.DebugID); err != nil {
+		t.Fatalf("fresh bundle: %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := debug.GetDebugBundle(ctx, resp.Meta.DebugID); err != sql.ErrNoRows {
+		t.Fatalf("expired bundle: err = %v, want sql.ErrNoRows", err)
+	}
+	jctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go (&DebugJanitor{Store: debug, Interval: time.Millisecond}).Run(jctx)
+	waitFor(t, "the janitor to delete the bundle", func() bool { return debug.len() == 0 })
+}
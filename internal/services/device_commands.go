@@ -0,0 +1,297 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// DeviceCommandAuditStore records confirmed device commands.
+type DeviceCommandAuditStore interface {
+	AppendDeviceCommandAudit(ctx context.Context, a models.DeviceCommandAudit) error
+}
+
+// defaultDeviceCommands are the actions allowed when DeviceCommands is empty.
+var defaultDeviceCommands = []string{"reboot", "restart-kiosk-app", "screenshot"}
+
+// deviceCommandConfirmWindow is how long a "confirm <action> <host>" reply
+// is accepted after the command was proposed.
+const deviceCommandConfirmWindow = 5 * time.Minute
+
+// deviceCommandRoutes are the gateway endpoints that can carry a device
+// command, in order of preference; the first one the catalog allows is used.
+var deviceCommandRoutes = []struct {
+	path  string
+	field string
+}{
+	{"/ads/devices/%s/commands", "command"},
+	{"/metrics/servers/%s/actions", "action"},
+}
+
+// pendingDeviceCommand is a command waiting for its exact confirmation.
+type pendingDeviceCommand struct {
+	Action string
+	Host   string
+	At     time.Time
+}
+
+var (
+	deviceCommandRe        = regexp.MustCompile(`^(?:please\s+)?(reboot|restart(?:-|\s+)(?:the\s+)?(?:kiosk(?:-|\s+))?app|(?:take|capture|grab)\s+(?:a\s+)?screenshot|screenshot)\s+(?:(?:of|on|for)\s+)?(?:the\s+)?(?:kiosk\s+|device\s+|host\s+)?(\S+)$`)
+	deviceCommandConfirmRe = regexp.MustCompile(`^confirm\s+(\S+)\s+(\S+)$`)
+)
+
+// parseDeviceCommand recognises "reboot kiosk briggs-001", "restart kiosk app
+// on moco-brt-briggs-001" and "take a screenshot of briggs-001", returning the
+// action name and the host token.
+func parseDeviceCommand(msgLower string) (action, host string, ok bool) {
+	msg := forgetTrailing.ReplaceAllString(strings.TrimSpace(msgLower), "")
+	m := deviceCommandRe.FindStringSubmatch(msg)
+	if m == nil {
+		return "", "", false
+	}
+	switch {
+	case m[1] == "reboot":
+		action = "reboot"
+	case strings.Contains(m[1], "screenshot"):
+		action = "screenshot"
+	default:
+		action = "restart-kiosk-app"
+	}
+	host = strings.Trim(m[2], "'\"")
+	if !strings.Contains(host, "-") {
+		return "", "", false
+	}
+	return action, host, true
+}
+
+// deviceActionAllowed reports whether action is on the configured allowlist.
+func (c *ChatService) deviceActionAllowed(action string) bool {
+	allowed := c.DeviceCommands
+	if len(allowed) == 0 {
+		allowed = defaultDeviceCommands
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(strings.TrimSpace(a), action) {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceHostAllowed reports whether host matches DeviceCommandHosts (glob
+// patterns such as "moco-*"); an empty list allows every host.
+func (c *ChatService) deviceHostAllowed(host string) bool {
+	if len(c.DeviceCommandHosts) == 0 {
+		return true
+	}
+	for _, p := range c.DeviceCommandHosts {
+		if ok, _ := path.Match(strings.ToLower(strings.TrimSpace(p)), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceCommandRoute picks the command endpoint the catalog allows for host.
+func (c *ChatService) deviceCommandRoute(ctx context.Context, host string) (p, field string, ok bool) {
+	if c.Catalog == nil {
+		return "", "", false
+	}
+	for _, r := range deviceCommandRoutes {
+		p := fmt.Sprintf(r.path, url.PathEscape(host))
+		if c.Catalog.IsAllowed(ctx, "POST", p) {
+			return p, r.field, true
+		}
+	}
+	return "", "", false
+}
+
+// forbiddenCommand is the answer for a command the allowlist or the catalog
+// does not permit; no gateway call is made for it.
+func forbiddenCommand(answer string) models.ChatResponse {
+	return models.ChatResponse{Answer: answer, Error: &models.ResponseError{Code: "forbidden", Retryable: false}}
+}
+
+// checkDeviceCommand applies the action allowlist, the host allowlist and
+// the catalog, in that order, and returns the endpoint to use.
+func (c *ChatService) checkDeviceCommand(ctx context.Context, action, host string) (p, field string, denied *models.ChatResponse) {
+	if !c.deviceActionAllowed(action) {
+		r := forbiddenCommand(fmt.Sprintf("'%s' is not an allowed device command; nothing was sent.", action))
+		return "", "", &r
+	}
+	if !c.deviceHostAllowed(host) {
+		r := forbiddenCommand(fmt.Sprintf("Device commands are not allowed for %s; nothing was sent.", host))
+		return "", "", &r
+	}
+	p, field, ok := c.deviceCommandRoute(ctx, host)
+	if !ok {
+		r := forbiddenCommand("The tool gateway's catalog does not expose a device command endpoint, so device commands are not allowed; nothing was sent.")
+		return "", "", &r
+	}
+	return p, field, nil
+}
+
+func (c *ChatService) setPendingDeviceCommand(conversationID string, cmd *pendingDeviceCommand) {
+	c.updateConversationState(conversationID, func(st *conversationState) {
+		st.PendingCommand = cmd
+	})
+}
+
+// handleDeviceCommand proposes a device command and runs it only after the
+// user replies "confirm <action> <host>" with the exact host echoed back.
+func (c *ChatService) handleDeviceCommand(ctx context.Context, ownerKey string, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(strings.TrimSpace(req.Message))
+	conversationID := strings.TrimSpace(req.ConversationID)
+	st := c.getConversationState(conversationID)
+	var pending *pendingDeviceCommand
+	if st != nil {
+		pending = st.PendingCommand
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+
+	if pending != nil && isCancelReply(msgLower) {
+		c.setPendingDeviceCommand(conversationID, nil)
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("Cancelled the %s of %s; nothing was sent.", pending.Action, pending.Host)})
+	}
+	if m := deviceCommandConfirmRe.FindStringSubmatch(forgetTrailing.ReplaceAllString(msgLower, "")); m != nil {
+		if pending == nil {
+			if !c.deviceActionAllowed(m[1]) {
+				return models.ChatResponse{}, false, nil
+			}
+			return reply(models.ChatResponse{Answer: "There is no device command waiting for confirmation in this conversation."})
+		}
+		if m[1] != pending.Action || m[2] != pending.Host {
+			return reply(models.ChatResponse{Answer: fmt.Sprintf("That does not match the pending command, so nothing was sent. To proceed, reply exactly 'confirm %s %s', or 'cancel'.", pending.Action, pending.Host)})
+		}
+		c.setPendingDeviceCommand(conversationID, nil)
+		if readOnlyKey(ctx) {
+			return reply(readOnlyRefusal(ctx, "send device commands"))
+		}
+		if time.Since(pending.At) > deviceCommandConfirmWindow {
+			return reply(models.ChatResponse{Answer: fmt.Sprintf("The confirmation for %s %s expired; nothing was sent. Ask again to get a new confirmation.", pending.Action, pending.Host)})
+		}
+		return reply(c.runDeviceCommand(ctx, ownerKey, req, pending))
+	}
+
+	action, token, ok := parseDeviceCommand(msgLower)
+	if !ok {
+		return models.ChatResponse{}, false, nil
+	}
+	if !c.deviceActionAllowed(action) {
+		return reply(forbiddenCommand(fmt.Sprintf("'%s' is not an allowed device command; nothing was sent.", action)))
+	}
+	if readOnlyKey(ctx) {
+		return reply(readOnlyRefusal(ctx, "send device commands"))
+	}
+	if c.Gateway == nil {
+		return reply(models.ChatResponse{Answer: "Tool gateway is not configured."})
+	}
+	if st == nil {
+		return reply(models.ChatResponse{Answer: "Device commands need a confirmation, so they need a conversation. Start a conversation and ask again."})
+	}
+	host, candidates, steps := c.resolveShortHost(ctx, token, "", "")
+	if host == "" {
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("'%s' matches several devices (%s); name the full host.", token, strings.Join(candidates, ", ")), Steps: steps})
+	}
+	p, _, denied := c.checkDeviceCommand(ctx, action, host)
+	if denied != nil {
+		denied.Steps = steps
+		return reply(*denied)
+	}
+	c.setPendingDeviceCommand(conversationID, &pendingDeviceCommand{Action: action, Host: host, At: time.Now()})
+	answer := fmt.Sprintf("This will send '%s' to %s via POST %s. Reply 'confirm %s %s' within %d minutes to proceed, or 'cancel'.", action, host, p, action, host, int(deviceCommandConfirmWindow/time.Minute))
+	if c.isDryRun(req) {
+		answer += " (Dry run is on: confirming only describes the call.)"
+	}
+	return reply(models.ChatResponse{Answer: answer, Steps: steps})
+}
+
+// runDeviceCommand sends a confirmed command, or describes it in dry-run
+// mode, and writes the audit record either way. The allowlists and catalog
+// are checked again since they may have changed since the proposal.
+func (c *ChatService) runDeviceCommand(ctx context.Context, ownerKey string, req models.ChatRequest, cmd *pendingDeviceCommand) models.ChatResponse {
+	p, field, denied := c.checkDeviceCommand(ctx, cmd.Action, cmd.Host)
+	if denied != nil {
+		return *denied
+	}
+	body := map[string]any{field: cmd.Action}
+	audit := models.DeviceCommandAudit{OwnerKey: ownerKey, Role: keyRole(ctx), ConversationID: strings.TrimSpace(req.ConversationID), Host: cmd.Host, Action: cmd.Action, Path: p}
+	if c.isDryRun(req) {
+		audit.DryRun, audit.Outcome = true, "dry_run"
+		c.auditDeviceCommand(ctx, audit)
+		step := dryRunStep("deviceCommand", "POST", p, nil, body, nil)
+		return models.ChatResponse{Answer: fmt.Sprintf("Dry run: no changes were made. Would send '%s' to %s via POST %s.", cmd.Action, cmd.Host, p), Steps: []models.Step{step}}
+	}
+	if err := c.checkIdempotencyClaim(ctx); err != nil {
+		return models.ChatResponse{Answer: fmt.Sprintf("'%s' was not sent to %s: %s.", cmd.Action, cmd.Host, err)}
+	}
+	status, respBody, err := c.Gateway.DoJSON(ctx, "POST", p, nil, body)
+	step := models.Step{Tool: "deviceCommand", Status: status}
+	audit.Status = status
+	if err != nil {
+		step.Error = err.Error()
+		audit.Outcome, audit.Error = "failed", err.Error()
+	} else {
+		step.Body = c.clipStep(strings.TrimSpace(string(respBody)))
+		audit.Outcome = "executed"
+		if status < 200 || status >= 300 {
+			audit.Outcome, audit.Error = "rejected", gatewayErrorMessage(respBody)
+		}
+	}
+	c.auditDeviceCommand(ctx, audit)
+	steps := []models.Step{step}
+	if err != nil {
+		return models.ChatResponse{Answer: fmt.Sprintf("Sending '%s' to %s failed: %s. Ask again to retry.", cmd.Action, cmd.Host, err.Error()), Steps: steps}
+	}
+	if status < 200 || status >= 300 {
+		return models.ChatResponse{Answer: fmt.Sprintf("The gateway rejected '%s' for %s (status %d): %s", cmd.Action, cmd.Host, status, gatewayErrorMessage(respBody)), Steps: steps}
+	}
+	var parsed map[string]any
+	_ = json.Unmarshal(respBody, &parsed)
+	ack := parsed
+	if data, ok := parsed["data"].(map[string]any); ok {
+		ack = data
+	}
+	answer := fmt.Sprintf("The gateway accepted '%s' for %s (status %d).", cmd.Action, cmd.Host, status)
+	if s := anyString(ack, "status", "state"); s != "" {
+		answer += " Status: " + s + "."
+	}
+	if s := anyString(ack, "message", "detail"); s != "" {
+		answer += " " + strings.TrimSuffix(s, ".") + "."
+	}
+	if s := anyString(ack, "id", "command_id", "action_id"); s != "" {
+		answer += " Command id: " + s + "."
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps}
+}
+
+// auditDeviceCommand stores the audit record and logs it, so a command is
+// traceable even when the store write fails.
+func (c *ChatService) auditDeviceCommand(ctx context.Context, a models.DeviceCommandAudit) {
+	log.Printf("device command host=%s action=%s path=%s role=%s dry_run=%t status=%d outcome=%s", a.Host, a.Action, a.Path, a.Role, a.DryRun, a.Status, a.Outcome)
+	if c.Commands == nil {
+		return
+	}
+	if c.Outbox != nil {
+		if err := enqueueOutbox(ctx, c.Outbox, OutboxDeviceCommandAudit, outboxAudit{DeviceCommandAudit: a, OwnerKey: a.OwnerKey}); err != nil {
+			log.Printf("device command audit enqueue failed host=%s action=%s: %v", a.Host, a.Action, err)
+		}
+		return
+	}
+	if err := c.Commands.AppendDeviceCommandAudit(ctx, a); err != nil {
+		log.Printf("device command audit write failed host=%s action=%s: %v", a.Host, a.Action, err)
+	}
+}
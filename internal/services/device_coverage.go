@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// maxCoverageOffenders caps the devices listed in a coverage answer.
+const maxCoverageOffenders = 25
+
+var telemetryCoverageRe = regexp.MustCompile(`\b(?:(?:devices?|kiosks?|hosts?|screens?)\s+(?:(?:that|which)\s+)?(?:are\s+|have\s+)?(?:not|never)\s+(?:reporting|reported|sending|sent)|(?:telemetry|metrics)\s+coverage|(?:devices?|kiosks?|hosts?)\s+(?:missing|absent)\s+from\s+(?:metrics|telemetry)|(?:devices?|kiosks?|hosts?)\s+(?:with(?:out)?\s+)?no\s+(?:metrics|telemetry)|offline\s+(?:devices?|kiosks?|hosts?|screens?)|(?:devices?|kiosks?|hosts?|screens?)\s+(?:(?:that|which)\s+)?(?:are\s+)?offline)\b`)
+
+func isTelemetryCoverageIntent(msgLower string) bool {
+	return telemetryCoverageRe.MatchString(msgLower)
+}
+
+// coverageKey normalizes a host or server id so inventory and metrics ids
+// that differ only in case or separators ("MOCO_BRT_001" vs "moco-brt-001")
+// compare equal.
+func coverageKey(id string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(id)), "_", "-")
+}
+
+// silentFor renders how long a device has not reported ("3d 4h", "5h 12m",
+// "42m").
+func silentFor(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	mins := int(d % time.Hour / time.Minute)
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case hours > 0 && mins == 0:
+		return fmt.Sprintf("%dh", hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, mins)
+	}
+	return fmt.Sprintf("%dm", mins)
+}
+
+// coverageGap is an inventory device with no recent telemetry. LastSeen is
+// zero when it never reported.
+type coverageGap struct {
+	Device   deviceHost
+	LastSeen time.Time
+}
+
+// telemetryCoverage diffs an inventory against the latest sample per
+// normalized server id. It returns how many devices reported within stale,
+// and the gaps: never-reported devices first, then the longest silent.
+func telemetryCoverage(inventory []deviceHost, lastSeen map[string]time.Time, now time.Time, stale time.Duration) (int, []coverageGap) {
+	fresh := 0
+	gaps := make([]coverageGap, 0)
+	seen := map[string]bool{}
+	for _, d := range inventory {
+		key := coverageKey(d.Host)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		t, ok := lastSeen[key]
+		if ok && now.Sub(t) <= stale {
+			fresh++
+			continue
+		}
+		gaps = append(gaps, coverageGap{Device: d, LastSeen: t})
+	}
+	sort.SliceStable(gaps, func(i, j int) bool {
+		if gaps[i].LastSeen.IsZero() != gaps[j].LastSeen.IsZero() {
+			return gaps[i].LastSeen.IsZero()
+		}
+		if !gaps[i].LastSeen.Equal(gaps[j].LastSeen) {
+			return gaps[i].LastSeen.Before(gaps[j].LastSeen)
+		}
+		return gaps[i].Device.Host < gaps[j].Device.Host
+	})
+	return fresh, gaps
+}
+
+// latestSampleTimes pages /metrics/latest and returns the newest sample time
+// per normalized server id. capped is true when maxPages ran out before the
+// listing did.
+func (c *ChatService) latestSampleTimes(ctx context.Context, maxPages int) (map[string]time.Time, []models.Step, bool, error) {
+	lastSeen := map[string]time.Time{}
+	steps := make([]models.Step, 0, 2)
+	pageSize := c.limits().ListPageSize
+	var fetched int64
+	for page := 1; ; page++ {
+		path := fmt.Sprintf("/metrics/latest?page=%d&page_size=%d&include_totals=false", page, pageSize)
+		status, body, err := c.Gateway.Get(ctx, path)
+		step := models.Step{Tool: "metricsLatest", Status: status}
+		if err != nil {
+			step.Error = err.Error()
+			steps = append(steps, step)
+			return nil, steps, false, err
+		}
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		steps = append(steps, step)
+		if status < 200 || status >= 300 {
+			return nil, steps, false, fmt.Errorf("status %d", status)
+		}
+		var payload struct {
+			Data []struct {
+				ServerID string    `json:"server_id"`
+				Time     time.Time `json:"time"`
+			} `json:"data"`
+			Pagination gatewayPagination `json:"pagination"`
+		}
+		if json.Unmarshal(body, &payload) != nil {
+			return nil, steps, false, fmt.Errorf("latest metrics response could not be parsed")
+		}
+		for _, r := range payload.Data {
+			key := coverageKey(r.ServerID)
+			if key == "" {
+				continue
+			}
+			if t, ok := lastSeen[key]; !ok || r.Time.After(t) {
+				lastSeen[key] = r.Time
+			}
+		}
+		fetched += int64(len(payload.Data))
+		if payload.Pagination.done(len(payload.Data), pageSize, fetched) {
+			return lastSeen, steps, false, nil
+		}
+		if page >= maxPages {
+			return lastSeen, steps, true, nil
+		}
+	}
+}
+
+// handleTelemetryCoverage answers "devices not reporting metrics" and
+// "telemetry coverage in <scope>": ads inventory devices that never sent
+// telemetry or whose latest sample is older than TelemetryStaleAfter.
+func (c *ChatService) handleTelemetryCoverage(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !isTelemetryCoverageIntent(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	if c.Gateway == nil {
+		return reply(models.ChatResponse{Answer: "Tool gateway is not configured."})
+	}
+	city := c.detectCityCode(ctx, msgLower)
+	region := c.detectRegionCode(ctx, msgLower)
+	scope := "all devices"
+	switch {
+	case region != "":
+		scope = fmt.Sprintf("region '%s'", region)
+	case city != "":
+		scope = fmt.Sprintf("city '%s'", city)
+	}
+	if conversationID := strings.TrimSpace(req.ConversationID); conversationID != "" && (city != "" || region != "") {
+		c.updateConversationLocation(conversationID, city, region)
+	}
+	stale := c.TelemetryStaleAfter
+	if stale <= 0 {
+		stale = time.Hour
+	}
+
+	inventory, steps, ok := c.fetchDeviceInventory(ctx, city, region)
+	if !ok {
+		return reply(models.ChatResponse{Answer: "Failed to fetch the device inventory from /ads/devices.", Steps: steps})
+	}
+	if len(inventory) == 0 {
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("No devices are in the ads inventory for %s.", scope), Steps: steps})
+	}
+	lastSeen, metricSteps, capped, err := c.latestSampleTimes(ctx, c.limits().MetricsMaxPages)
+	steps = append(steps, metricSteps...)
+	if err != nil {
+		return reply(models.ChatResponse{Answer: "Failed to fetch latest metrics: " + err.Error(), Steps: steps})
+	}
+
+	now := time.Now()
+	fresh, gaps := telemetryCoverage(inventory, lastSeen, now, stale)
+	total := fresh + len(gaps)
+	never := 0
+	for _, g := range gaps {
+		if g.LastSeen.IsZero() {
+			never++
+		}
+	}
+	lines := []string{fmt.Sprintf("Telemetry coverage for %s: %s (%d of %d inventory devices reported metrics in the last %s).",
+		scope, formatPercent(100*float64(fresh)/float64(total)), fresh, total, silentFor(stale))}
+	if len(gaps) == 0 {
+		lines = append(lines, "Every device in the inventory is reporting.")
+	} else {
+		lines = append(lines, fmt.Sprintf("%d never reported, %d stale:", never, len(gaps)-never))
+		listed := make([]listedEntity, 0, maxCoverageOffenders)
+		for i, g := range gaps {
+			if i >= maxCoverageOffenders {
+				break
+			}
+			listed = append(listed, listedEntity{ID: strings.ToLower(g.Device.Host), Name: g.Device.Name})
+			label := g.Device.Host
+			if g.Device.Name != "" {
+				label += " (" + g.Device.Name + ")"
+			}
+			if g.LastSeen.IsZero() {
+				lines = append(lines, fmt.Sprintf("%d. %s — no telemetry at all", i+1, label))
+			} else {
+				lines = append(lines, fmt.Sprintf("%d. %s — silent for %s (last sample %s)", i+1, label, silentFor(now.Sub(g.LastSeen)), g.LastSeen.UTC().Format("2006-01-02 15:04 UTC")))
+			}
+		}
+		if rest := len(gaps) - maxCoverageOffenders; rest > 0 {
+			lines = append(lines, fmt.Sprintf("…and %d more.", rest))
+		}
+		c.rememberList(req.ConversationID, listKindDevice, listed)
+	}
+	if capped {
+		lines = append(lines, "Latest metrics were read up to the page limit, so some devices listed as never reporting may have samples beyond it.")
+	}
+	// Silent durations grow on every ask; only devices going silent or
+	// coming back count as changes.
+	snapshot := make([]models.AnswerRow, 0, len(gaps))
+	for _, g := range gaps {
+		snapshot = append(snapshot, models.AnswerRow{Key: coverageKey(g.Device.Host), Label: g.Device.Host})
+	}
+	changes := c.answerChanges(ctx, req, "telemetry_coverage", snapshot, nil)
+	return reply(models.ChatResponse{Answer: withChanges(req, changes, strings.Join(lines, "\n")), Steps: steps})
+}
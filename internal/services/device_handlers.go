@@ -0,0 +1,1541 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+func isTopDevicesFromCityIntent(msgLower string) bool {
+	s := strings.ToLower(strings.TrimSpace(msgLower))
+	if s == "" {
+		return false
+	}
+	if strings.Contains(s, "top") && (strings.Contains(s, "device") || strings.Contains(s, "devices")) {
+		if strings.Contains(s, "from") || strings.Contains(s, "in") || strings.Contains(s, "city") {
+			return true
+		}
+		if strings.Contains(s, "perform") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ChatService) handleDeviceDetails(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !(strings.Contains(msgLower, "device") || strings.Contains(msgLower, "kiosk") || strings.Contains(msgLower, "server")) {
+		return models.ChatResponse{}, false, nil
+	}
+	// If the user is asking for kiosk-wise analytics in a conversation that already has a poster context,
+	// let the poster POP/play-count handlers handle it instead of returning device details.
+	if strings.Contains(msgLower, "kiosk wise") || strings.Contains(msgLower, "kiosk-wise") || strings.Contains(msgLower, "kioskwise") || strings.Contains(msgLower, "kiosks wise") || strings.Contains(msgLower, "kiosks-wise") {
+		conversationID := strings.TrimSpace(req.ConversationID)
+		if conversationID != "" {
+			if st := c.getConversationState(conversationID); st != nil {
+				if strings.TrimSpace(st.PosterID) != "" || strings.TrimSpace(st.PosterName) != "" {
+					return models.ChatResponse{}, false, nil
+				}
+			}
+		}
+	}
+	// POP questions should be handled by POP handlers.
+	if strings.Contains(msgLower, "pop") {
+		return models.ChatResponse{}, false, nil
+	}
+	// Venue-related queries should be handled by venue-specific handlers.
+	if strings.Contains(msgLower, "venue") || strings.Contains(msgLower, "venues") {
+		return models.ChatResponse{}, false, nil
+	}
+	// Avoid colliding with telemetry handler.
+	if strings.Contains(msgLower, "metric") || strings.Contains(msgLower, "telemetry") || strings.Contains(msgLower, "internet") || strings.Contains(msgLower, "usage") {
+		return models.ChatResponse{}, false, nil
+	}
+	if !(strings.Contains(msgLower, "detail") || strings.Contains(msgLower, "info") || strings.Contains(msgLower, "show") || strings.Contains(msgLower, "get")) {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	host := ""
+	if tokens := detectHostTokens(req.Message); len(tokens) > 0 {
+		candidate := strings.ToLower(strings.TrimSpace(tokens[0]))
+		parts := strings.Split(strings.ReplaceAll(candidate, "_", "-"), "-")
+		if len(parts) >= 3 {
+			host = candidate
+		}
+	}
+	var resolveStep *models.Step
+	resolveNameCandidate := func(msg string) string {
+		cleaned := msg
+		// Strip common command words but keep meaningful display-name tokens like "Kiosk-1".
+		for _, w := range []string{"show", "get", "give", "tell", "me", "the", "a", "an", "please", "device", "devices", "kiosk", "kiosks", "server", "info", "detail", "details"} {
+			cleaned = strings.ReplaceAll(cleaned, w, "")
+			cleaned = strings.ReplaceAll(cleaned, strings.Title(w), "")
+		}
+		cleaned = strings.ReplaceAll(cleaned, "?", " ")
+		cleaned = strings.ReplaceAll(cleaned, ":", " ")
+		cleaned = strings.TrimSpace(cleaned)
+		cleaned = strings.Join(strings.Fields(cleaned), " ")
+		return cleaned
+	}
+	if host == "" && conversationID != "" {
+		// Only reuse the remembered host for *generic* follow-ups.
+		// If the user provided a kiosk/display name, resolve fresh instead of inheriting a stale host.
+		candidate := resolveNameCandidate(req.Message)
+		if strings.TrimSpace(candidate) == "" {
+			if st := c.getConversationState(conversationID); st != nil {
+				if strings.TrimSpace(st.Host) != "" {
+					host = strings.ToLower(strings.TrimSpace(st.Host))
+				}
+			}
+		}
+	}
+	if host == "" {
+		candidate := resolveNameCandidate(req.Message)
+		query := req.Message
+		resolveConversationID := conversationID
+		if candidate != "" {
+			query = candidate
+			// For explicit kiosk/display-name queries, don't constrain resolution by remembered city/region.
+			resolveConversationID = ""
+		}
+		if resolved, step := c.resolveHostFromDeviceName(ctx, resolveConversationID, query); strings.TrimSpace(resolved) != "" {
+			host = strings.ToLower(strings.TrimSpace(resolved))
+			resolveStep = step
+		}
+	}
+	if host == "" {
+		// Second pass: if the first pass failed, try resolving with just the name parts.
+		// Sometimes the search query is too specific with "device info" or "show".
+		cleaned := req.Message
+		cleaned = strings.ReplaceAll(cleaned, "show", "")
+		cleaned = strings.ReplaceAll(cleaned, "device", "")
+		cleaned = strings.ReplaceAll(cleaned, "info", "")
+		cleaned = strings.ReplaceAll(cleaned, "detail", "")
+		cleaned = strings.TrimSpace(cleaned)
+		if cleaned != "" && cleaned != req.Message {
+			// Second pass is also name-driven; avoid stale geo constraints.
+			if resolved, step := c.resolveHostFromDeviceName(ctx, "", cleaned); strings.TrimSpace(resolved) != "" {
+				host = strings.ToLower(strings.TrimSpace(resolved))
+				resolveStep = step
+			}
+		}
+	}
+	if host == "" {
+		return models.ChatResponse{Answer: "Please specify a device/kiosk host (for example: moco-brt-briggs-001) or a kiosk display name."}, true, nil
+	}
+	if conversationID != "" {
+		c.updateConversationHost(conversationID, host)
+		c.clearPending(conversationID)
+	}
+
+	path := "/ads/devices/" + url.PathEscape(host)
+	status, body, err := c.Gateway.Get(ctx, path)
+	step := models.Step{Tool: "adsDevice", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+	} else {
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	}
+	steps := []models.Step{step}
+	if resolveStep != nil {
+		steps = append([]models.Step{*resolveStep}, steps...)
+	}
+	if err != nil {
+		return models.ChatResponse{Answer: "Failed to fetch device details: " + err.Error(), Steps: steps}, true, nil
+	}
+	if status < 200 || status >= 300 {
+		return models.ChatResponse{Answer: fmt.Sprintf("Failed to fetch device details (status %d).", status), Steps: steps}, true, nil
+	}
+
+	var parsed map[string]any
+	if json.Unmarshal(body, &parsed) != nil {
+		return models.ChatResponse{Answer: "Device details response could not be parsed.", Steps: steps}, true, nil
+	}
+	// Accept both {data:{...}} and direct object.
+	obj := parsed
+	if d, ok := parsed["data"].(map[string]any); ok {
+		obj = d
+	}
+	name, _ := obj["name"].(string)
+	desc, _ := obj["description"].(string)
+	hostName, _ := obj["host_name"].(string)
+	if strings.TrimSpace(hostName) == "" {
+		hostName = host
+	}
+	deviceID := 0
+	switch v := obj["id"].(type) {
+	case float64:
+		deviceID = int(v)
+	case int:
+		deviceID = v
+	}
+	city := ""
+	region := ""
+	if cfg, ok := obj["device_config"].(map[string]any); ok {
+		if s, ok := cfg["city"].(string); ok {
+			city = s
+		}
+	}
+	if regObj, ok := obj["region"].(map[string]any); ok {
+		if s, ok := regObj["code"].(string); ok {
+			region = s
+		}
+	}
+
+	lines := []string{
+		fmt.Sprintf("Device details for %s:", strings.TrimSpace(hostName)),
+	}
+	lines = append(lines, "Host: "+strings.TrimSpace(hostName))
+	if deviceID > 0 {
+		lines = append(lines, fmt.Sprintf("Device ID: %d", deviceID))
+	}
+	if strings.TrimSpace(name) != "" {
+		lines = append(lines, "Name: "+strings.TrimSpace(name))
+	}
+	if strings.TrimSpace(city) != "" {
+		lines = append(lines, "City: "+strings.TrimSpace(city))
+	}
+	if strings.TrimSpace(region) != "" {
+		lines = append(lines, "Region: "+strings.TrimSpace(region))
+	}
+	if strings.TrimSpace(desc) != "" {
+		lines = append(lines, "Description: "+strings.TrimSpace(desc))
+	}
+	answer := c.withDeviceNotes(ctx, req, host, strings.Join(lines, "\n"))
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+}
+
+func extractExplicitDeviceID(msgLower string) int {
+	s := strings.ToLower(strings.TrimSpace(msgLower))
+	if s == "" {
+		return 0
+	}
+	// Only treat numbers as device IDs when explicitly provided as "device <id>" or "device id <id>".
+	re := regexp.MustCompile(`(?i)\bdevice\s+(?:id\s+)?(\d{1,9})\b`)
+	mm := re.FindStringSubmatch(s)
+	if len(mm) != 2 {
+		return 0
+	}
+	n, err := strconv.Atoi(mm[1])
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n
+}
+
+func scoreDeviceNameMatch(query string, fields ...string) int {
+	q := normalizeLooseText(query)
+	if q == "" {
+		return 0
+	}
+	best := 0
+	for _, f := range fields {
+		ff := normalizeLooseText(f)
+		if ff == "" {
+			continue
+		}
+		s := 0
+		if ff == q {
+			s = 200
+		} else if strings.Contains(ff, q) {
+			s = 120
+		} else if strings.Contains(q, ff) && len(ff) >= 3 {
+			s = 100
+		} else {
+			qParts := strings.Fields(q)
+			match := 0
+			for _, p := range qParts {
+				if len(p) < 3 {
+					continue
+				}
+				if strings.Contains(ff, p) {
+					match++
+				}
+			}
+			if match > 0 {
+				s = 10 * match
+			}
+		}
+		if s > best {
+			best = s
+		}
+	}
+	return best
+}
+
+func (c *ChatService) resolveHostFromDeviceName(ctx context.Context, conversationID string, name string) (string, *models.Step) {
+	if c.Gateway == nil {
+		return "", nil
+	}
+	st := c.getConversationState(conversationID)
+	city := ""
+	region := ""
+	if st != nil {
+		city = strings.ToLower(strings.TrimSpace(st.City))
+		region = strings.ToLower(strings.TrimSpace(st.Region))
+	}
+
+	// Require a reasonably strong match so we don't accidentally resolve to an unrelated device.
+	queryNorm := normalizeLooseText(name)
+	queryTokens := make([]string, 0)
+	stop := map[string]struct{}{
+		"show": {}, "get": {}, "give": {}, "tell": {}, "please": {}, "me": {}, "the": {}, "a": {}, "an": {},
+		"device": {}, "devices": {}, "kiosk": {}, "kiosks": {}, "server": {}, "info": {}, "detail": {}, "details": {},
+		"status": {}, "health": {}, "metrics": {}, "telemetry": {},
+	}
+	for _, t := range strings.Fields(queryNorm) {
+		if _, ok := stop[t]; ok {
+			continue
+		}
+		if len(t) >= 4 {
+			queryTokens = append(queryTokens, t)
+		}
+	}
+	if len(queryTokens) == 0 {
+		// Fall back to old behavior (but still with a higher threshold).
+		for _, t := range strings.Fields(queryNorm) {
+			if _, ok := stop[t]; ok {
+				continue
+			}
+			queryTokens = append(queryTokens, t)
+		}
+	}
+	countTokenMatches := func(candidate string) int {
+		cand := normalizeLooseText(candidate)
+		if cand == "" {
+			return 0
+		}
+		m := 0
+		for _, qt := range queryTokens {
+			if len(qt) < 3 {
+				continue
+			}
+			if strings.Contains(cand, qt) {
+				m++
+			}
+		}
+		return m
+	}
+
+	bestHost := ""
+	bestScore := 0
+	var bestStep *models.Step
+
+	// Prefer the search endpoint when available, but different deployments may use different param names.
+	searchQuery := urlEscape(strings.TrimSpace(name))
+	baseCandidates := []struct {
+		tool string
+		path string
+	}{
+		{tool: "adsDevicesSearch", path: "/ads/devices/search?query=" + searchQuery + "&page=1&page_size=50"},
+		{tool: "adsDevicesSearch", path: "/ads/devices/search?q=" + searchQuery + "&page=1&page_size=50"},
+		{tool: "adsDevicesSearch", path: "/ads/devices/search?search=" + searchQuery + "&page=1&page_size=50"},
+		{tool: "adsDevices", path: "/ads/devices?query=" + searchQuery + fmt.Sprintf("&page=1&page_size=%d", c.limits().ListPageSize)},
+		{tool: "adsDevices", path: "/ads/devices?search=" + searchQuery + fmt.Sprintf("&page=1&page_size=%d", c.limits().ListPageSize)},
+	}
+	makeCandidates := func(withCity bool) []struct{ tool, path string } {
+		out := make([]struct{ tool, path string }, 0, len(baseCandidates))
+		for _, cnd := range baseCandidates {
+			out = append(out, struct{ tool, path string }{tool: cnd.tool, path: cnd.path})
+		}
+		if withCity && city != "" {
+			for i := range out {
+				out[i].path += "&city=" + urlEscape(city)
+			}
+		}
+		return out
+	}
+
+	// First try city-scoped search (more precise). If it yields no strong match, retry without city.
+	runCandidates := func(cands []struct{ tool, path string }) {
+		for _, cand := range cands {
+			status, body, err := c.Gateway.Get(ctx, cand.path)
+			searchStep := &models.Step{Tool: cand.tool, Status: status}
+			if err != nil {
+				searchStep.Error = err.Error()
+				bestStep = searchStep
+				continue
+			}
+			searchStep.Body = c.clipStep(strings.TrimSpace(string(body)))
+			bestStep = searchStep
+			if status == 400 {
+				// Likely wrong parameter name / endpoint shape; try the next candidate.
+				continue
+			}
+			if status < 200 || status >= 300 {
+				continue
+			}
+			rows := parseRows(body)
+			if len(rows) == 0 {
+				continue
+			}
+			for _, it := range rows {
+				m, ok := it.(map[string]any)
+				if !ok {
+					continue
+				}
+				rowCity, _ := m["city"].(string)
+				rowRegion, _ := m["region"].(string)
+				if region != "" && strings.ToLower(strings.TrimSpace(rowRegion)) != region {
+					continue
+				}
+				description, _ := m["description"].(string)
+				displayName, _ := m["display_name"].(string)
+				if strings.TrimSpace(displayName) == "" {
+					displayName, _ = m["displayName"].(string)
+				}
+				kioskName, _ := m["kiosk_name"].(string)
+				if strings.TrimSpace(kioskName) == "" {
+					kioskName, _ = m["kioskName"].(string)
+				}
+				deviceName, _ := m["name"].(string)
+				facing := ""
+				stopName := ""
+				if dc, ok := m["device_config"].(map[string]any); ok {
+					facing, _ = dc["facing"].(string)
+					if stops, ok := dc["stops"].([]any); ok && len(stops) > 0 {
+						if sm, ok := stops[0].(map[string]any); ok {
+							stopName, _ = sm["stop_name"].(string)
+							if strings.TrimSpace(stopName) == "" {
+								stopName, _ = sm["stopName"].(string)
+							}
+						}
+					}
+					if gtfs, ok := dc["gtfs"].(map[string]any); ok {
+						if strings.TrimSpace(stopName) == "" {
+							stopName, _ = gtfs["stopName"].(string)
+						}
+					}
+				}
+				hostName, _ := m["host_name"].(string)
+				if strings.TrimSpace(hostName) == "" {
+					hostName, _ = m["host"].(string)
+				}
+				if strings.TrimSpace(hostName) == "" {
+					hostName, _ = m["hostName"].(string)
+				}
+				serverID, _ := m["server_id"].(string)
+				if strings.TrimSpace(serverID) == "" {
+					serverID, _ = m["serverId"].(string)
+				}
+				if strings.TrimSpace(serverID) == "" {
+					serverID, _ = m["device_key"].(string)
+				}
+				if strings.TrimSpace(serverID) == "" {
+					serverID, _ = m["deviceKey"].(string)
+				}
+				candidateHost := strings.TrimSpace(serverID)
+				if candidateHost == "" {
+					candidateHost = strings.TrimSpace(hostName)
+				}
+				if candidateHost == "" {
+					continue
+				}
+				nameHits := countTokenMatches(kioskName) + countTokenMatches(deviceName) + countTokenMatches(displayName) + countTokenMatches(description) + countTokenMatches(stopName) + countTokenMatches(facing)
+				if nameHits < 2 {
+					continue
+				}
+				score := scoreDeviceNameMatch(name, kioskName, deviceName, displayName, description, stopName, facing, hostName, serverID, rowCity, rowRegion)
+				if score > bestScore {
+					bestScore = score
+					bestHost = candidateHost
+				}
+			}
+		}
+	}
+
+	// First try city-scoped search (more precise). If it yields no strong match, retry without city.
+	runCandidates(makeCandidates(true))
+	if bestScore < 30 {
+		runCandidates(makeCandidates(false))
+	}
+	if bestScore >= 30 {
+		return strings.ToLower(strings.TrimSpace(bestHost)), bestStep
+	}
+
+	page := 1
+	pageSize := c.limits().ListPageSize
+	maxPages := c.limits().MetricsMaxPages
+	var fetched int64
+	for {
+		path := fmt.Sprintf("/ads/devices?page=%d&page_size=%d", page, pageSize)
+		if city != "" {
+			path += "&city=" + urlEscape(city)
+		}
+		status, body, err := c.Gateway.Get(ctx, path)
+		step := &models.Step{Tool: "adsDevices", Status: status}
+		if err != nil {
+			step.Error = err.Error()
+			// Return the last step on error.
+			return "", step
+		}
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		bestStep = step
+		if status < 200 || status >= 300 {
+			return "", step
+		}
+
+		var root map[string]any
+		if json.Unmarshal(body, &root) != nil {
+			return "", step
+		}
+		rows, _ := root["data"].([]any)
+		// Some deployments don't include pagination.has_more; pageDone falls
+		// back to the row count.
+		fetched += int64(len(rows))
+		hasMore := !paginationFrom(root).done(len(rows), pageSize, fetched)
+
+		for _, it := range rows {
+			m, ok := it.(map[string]any)
+			if !ok {
+				continue
+			}
+
+			rowCity, _ := m["city"].(string)
+			rowRegion, _ := m["region"].(string)
+			if region != "" && strings.ToLower(strings.TrimSpace(rowRegion)) != region {
+				continue
+			}
+			description, _ := m["description"].(string)
+			displayName, _ := m["display_name"].(string)
+			if strings.TrimSpace(displayName) == "" {
+				displayName, _ = m["displayName"].(string)
+			}
+
+			kioskName, _ := m["kiosk_name"].(string)
+			if strings.TrimSpace(kioskName) == "" {
+				kioskName, _ = m["kioskName"].(string)
+			}
+			deviceName, _ := m["name"].(string)
+			facing := ""
+			stopName := ""
+			if dc, ok := m["device_config"].(map[string]any); ok {
+				facing, _ = dc["facing"].(string)
+				if stops, ok := dc["stops"].([]any); ok && len(stops) > 0 {
+					if sm, ok := stops[0].(map[string]any); ok {
+						stopName, _ = sm["stop_name"].(string)
+						if strings.TrimSpace(stopName) == "" {
+							stopName, _ = sm["stopName"].(string)
+						}
+					}
+				}
+				if gtfs, ok := dc["gtfs"].(map[string]any); ok {
+					if strings.TrimSpace(stopName) == "" {
+						stopName, _ = gtfs["stopName"].(string)
+					}
+				}
+			}
+			hostName, _ := m["host_name"].(string)
+			if strings.TrimSpace(hostName) == "" {
+				hostName, _ = m["host"].(string)
+			}
+			if strings.TrimSpace(hostName) == "" {
+				hostName, _ = m["hostName"].(string)
+			}
+			serverID, _ := m["server_id"].(string)
+			if strings.TrimSpace(serverID) == "" {
+				serverID, _ = m["serverId"].(string)
+			}
+			if strings.TrimSpace(serverID) == "" {
+				serverID, _ = m["device_key"].(string)
+			}
+			if strings.TrimSpace(serverID) == "" {
+				serverID, _ = m["deviceKey"].(string)
+			}
+
+			// Choose the best candidate identifier to use as server_id for metrics.
+			candidateHost := strings.TrimSpace(serverID)
+			if candidateHost == "" {
+				candidateHost = strings.TrimSpace(hostName)
+			}
+			if candidateHost == "" {
+				continue
+			}
+
+			// Require multiple token hits on the human-readable name to avoid random matches.
+			nameHits := countTokenMatches(kioskName) + countTokenMatches(deviceName) + countTokenMatches(displayName) + countTokenMatches(description) + countTokenMatches(stopName) + countTokenMatches(facing)
+			if nameHits < 2 {
+				continue
+			}
+
+			score := scoreDeviceNameMatch(name, kioskName, deviceName, displayName, description, stopName, facing, hostName, serverID, rowCity, rowRegion)
+			if score > bestScore {
+				bestScore = score
+				bestHost = candidateHost
+			}
+		}
+
+		// If we already have a strong match, stop early.
+		if bestScore >= 80 {
+			break
+		}
+		if !hasMore {
+			break
+		}
+		page++
+		if page > maxPages {
+			break
+		}
+	}
+	if bestScore < 30 {
+		return "", bestStep
+	}
+	return strings.ToLower(strings.TrimSpace(bestHost)), bestStep
+}
+
+func (c *ChatService) handleKioskCountFromCity(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	containsDeviceWord := strings.Contains(msgLower, "kiosk") || strings.Contains(msgLower, "kiosks") || strings.Contains(msgLower, "device") || strings.Contains(msgLower, "devices")
+	containsDataWord := strings.Contains(msgLower, " data") || strings.Contains(msgLower, "data ")
+
+	// If the user gave an explicit host and asked for device telemetry/health/metrics,
+	// let the per-host telemetry handler answer instead of returning city/region summaries.
+	hostTokens := detectHostTokens(req.Message)
+	if len(hostTokens) > 0 {
+		if strings.Contains(msgLower, "telemetry") || strings.Contains(msgLower, "health") || strings.Contains(msgLower, "metrics") || strings.Contains(msgLower, "device status") ||
+			strings.Contains(msgLower, "cpu") || strings.Contains(msgLower, "memory") || strings.Contains(msgLower, "ram") || strings.Contains(msgLower, "disk") || strings.Contains(msgLower, "storage") ||
+			strings.Contains(msgLower, "temperature") || strings.Contains(msgLower, "temp") || strings.Contains(msgLower, "uptime") {
+			return models.ChatResponse{}, false, nil
+		}
+	}
+
+	// Host-specific "data usage" questions (e.g. "how much internet data <host> is using") should be
+	// handled by the per-host telemetry handler, not city/region status.
+	if containsDataWord {
+		if len(hostTokens) > 0 {
+			if strings.Contains(msgLower, "internet") || strings.Contains(msgLower, "bandwidth") || strings.Contains(msgLower, "traffic") ||
+				strings.Contains(msgLower, "throughput") || strings.Contains(msgLower, "usage") || strings.Contains(msgLower, "using") ||
+				strings.Contains(msgLower, "consumed") || strings.Contains(msgLower, "consumption") {
+				return models.ChatResponse{}, false, nil
+			}
+		}
+	}
+
+	cityRaw := c.detectCityCode(ctx, msgLower)
+	region := c.detectRegionCode(ctx, msgLower)
+	city, _ := normalizeCitySelection(cityRaw, region, msgLower)
+	hasLocation := city != "" || region != ""
+
+	if !(containsDeviceWord || (containsDataWord && hasLocation)) {
+		return models.ChatResponse{}, false, nil
+	}
+
+	hasCountKeyword := strings.Contains(msgLower, "how many") || strings.Contains(msgLower, "count") || strings.Contains(msgLower, "number of")
+	// "data" is ambiguous; only treat it as a status request when the user also mentions kiosks/devices.
+	hasStatusKeyword := strings.Contains(msgLower, "offline") || strings.Contains(msgLower, "online") || strings.Contains(msgLower, "status") || strings.Contains(msgLower, "down") || (containsDataWord && hasLocation && containsDeviceWord)
+	if !(hasCountKeyword || hasStatusKeyword) {
+		return models.ChatResponse{}, false, nil
+	}
+
+	if hasStatusKeyword {
+		queryCity, _ := normalizeCitySelection(city, region, msgLower)
+		queryRegion := region
+		if queryCity == "" && queryRegion == "" {
+			return models.ChatResponse{Answer: say(req, "need_city_or_region")}, true, nil
+		}
+
+		if c.Gateway == nil {
+			return models.ChatResponse{Answer: say(req, "gateway_not_configured")}, true, nil
+		}
+		trend := c.startTrend(ctx, queryCity, queryRegion, trendStatusWindow, time.Now())
+		defer trend.stop()
+		ds := c.fetchDeviceStatus(ctx, queryCity, queryRegion)
+
+		answer := ""
+		steps := []models.Step{ds.Step}
+		if ds.Err != nil {
+			answer = say(req, "status_failed", ds.Err.Error())
+		} else if !ds.ok() {
+			answer = say(req, "status_failed_status", ds.Step.Status)
+		} else if ds.Matched {
+			if queryCity != "" {
+				answer = say(req, "status_city", queryCity, ds.Offline, ds.Online, ds.Total)
+			} else {
+				answer = say(req, "status_region", queryRegion, ds.Offline, ds.Online, ds.Total)
+			}
+			if snap := trend.wait(); snap != nil {
+				answer += " " + statusTrendHint(msgLower, ds, snap)
+				steps = append(steps, snap.Step)
+			}
+		} else if queryCity != "" {
+			answer = say(req, "status_none_city", queryCity)
+		} else {
+			answer = say(req, "status_none_region", queryRegion)
+		}
+
+		if onToken != nil {
+			onToken(answer)
+		}
+		return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+	}
+
+	lookupCity := city
+	if lookupCity == "" {
+		return models.ChatResponse{Answer: say(req, "need_city")}, true, nil
+	}
+
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: say(req, "gateway_not_configured")}, true, nil
+	}
+	kc := c.fetchKioskCount(ctx, lookupCity, region)
+
+	answer := ""
+	if kc.Err != nil {
+		answer = say(req, "counts_failed", kc.Err.Error())
+	} else if kc.Step.Status < 200 || kc.Step.Status >= 300 {
+		answer = say(req, "counts_failed_status", kc.Step.Status)
+	} else if region != "" && city == "" {
+		if kc.RegionCount > 0 {
+			answer = say(req, "count_region", formatDecimal(kc.RegionCount, 0), region, lookupCity)
+		} else {
+			answer = say(req, "count_none_region", region, lookupCity)
+		}
+	} else if kc.Total > 0 {
+		answer = say(req, "count_city", formatDecimal(kc.Total, 0), lookupCity)
+	} else {
+		answer = say(req, "count_none_city", lookupCity)
+	}
+
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: []models.Step{kc.Step}}, true, nil
+}
+
+// deviceStatus is the online/offline split of a city or region's kiosks, as
+// answered in chat and by the device_status query.
+type deviceStatus struct {
+	Step models.Step
+	Err  error
+	// Matched is false when the gateway listed no row for the city.
+	Matched                bool
+	Online, Offline, Total int64
+}
+
+func (ds deviceStatus) ok() bool {
+	return ds.Err == nil && ds.Step.Status >= 200 && ds.Step.Status < 300
+}
+
+// fetchDeviceStatus reads the status counts of city, or of every city in
+// region when city is empty.
+func (c *ChatService) fetchDeviceStatus(ctx context.Context, city, region string) deviceStatus {
+	values := url.Values{}
+	if city != "" {
+		values.Set("city", city)
+	}
+	if region != "" && city == "" {
+		values.Set("region", region)
+	}
+	path := "/metrics/servers/status/city"
+	if encoded := values.Encode(); encoded != "" {
+		path += "?" + encoded
+	}
+
+	debugLogf("gateway GET %s", path)
+	status, body, err := c.Gateway.Get(ctx, path)
+	debugLogf("gateway GET %s -> status=%d err=%v", path, status, err)
+	ds := deviceStatus{Step: models.Step{Tool: "metricsServersStatusCity", Status: status}, Err: err}
+	if err != nil {
+		ds.Step.Error = err.Error()
+	} else {
+		ds.Step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	}
+	if !ds.ok() {
+		return ds
+	}
+
+	var root map[string]any
+	_ = json.Unmarshal(body, &root)
+	rows, _ := root["data"].([]any)
+	resolveFloat := func(m map[string]any, key string) float64 {
+		switch v := m[key].(type) {
+		case float64:
+			return v
+		case int:
+			return float64(v)
+		case json.Number:
+			if f, e := v.Float64(); e == nil {
+				return f
+			}
+			return 0
+		default:
+			return 0
+		}
+	}
+
+	var online, offline, total float64
+	lowerCity := strings.ToLower(city)
+	if city != "" {
+		for _, it := range rows {
+			m, ok := it.(map[string]any)
+			if !ok {
+				continue
+			}
+			rowCity, _ := m["city"].(string)
+			if strings.ToLower(strings.TrimSpace(rowCity)) == lowerCity {
+				ds.Matched = true
+				online = resolveFloat(m, "online")
+				offline = resolveFloat(m, "offline")
+				total = resolveFloat(m, "total")
+				break
+			}
+		}
+	} else if len(rows) > 0 {
+		for _, it := range rows {
+			m, ok := it.(map[string]any)
+			if !ok {
+				continue
+			}
+			online += resolveFloat(m, "online")
+			offline += resolveFloat(m, "offline")
+			total += resolveFloat(m, "total")
+		}
+		ds.Matched = true
+	}
+
+	round := func(v float64) int64 {
+		if v >= 0 {
+			return int64(v + 0.5)
+		}
+		return int64(v - 0.5)
+	}
+	ds.Online, ds.Offline, ds.Total = round(online), round(offline), round(total)
+	return ds
+}
+
+// kioskCount is the number of kiosks in a city and, when a region is given,
+// in that region of it, as answered in chat and by the kiosk_count query.
+type kioskCount struct {
+	Step        models.Step
+	Err         error
+	Total       float64
+	RegionCount float64
+}
+
+func (c *ChatService) fetchKioskCount(ctx context.Context, city, region string) kioskCount {
+	path := "/ads/devices/counts/regions?city=" + urlEscape(city)
+	status, body, err := c.Gateway.Get(ctx, path)
+	kc := kioskCount{Step: models.Step{Tool: "adsDevicesCountsRegions", Status: status}, Err: err}
+	if err != nil {
+		kc.Step.Error = err.Error()
+		return kc
+	}
+	kc.Step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	if status < 200 || status >= 300 {
+		return kc
+	}
+	var root map[string]any
+	_ = json.Unmarshal(body, &root)
+	rows, _ := root["data"].([]any)
+	for _, it := range rows {
+		m, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		rowRegion, _ := m["region"].(string)
+		rowRegion = strings.ToLower(strings.TrimSpace(rowRegion))
+		for _, k := range []string{"count", "kiosk_count", "kiosks", "devices"} {
+			switch v := m[k].(type) {
+			case float64:
+				kc.Total += v
+				if region != "" && strings.EqualFold(rowRegion, region) {
+					kc.RegionCount += v
+				}
+			case int:
+				kc.Total += float64(v)
+				if region != "" && strings.EqualFold(rowRegion, region) {
+					kc.RegionCount += float64(v)
+				}
+			}
+		}
+	}
+	return kc
+}
+
+var hostPatternLocationRe = regexp.MustCompile(`\b(?:all|every)\s+(?:the\s+|of\s+the\s+)?([a-z0-9_-]+)\s+(?:kiosks?|devices?|hosts?|screens?)\b`)
+
+// extractHostPattern detects either a wildcard host pattern ("moco-brt-*") or a
+// location slice phrased as "all briggs kiosks". The bool reports whether the
+// pattern is a wildcard (matched with path.Match) rather than a segment token.
+func extractHostPattern(msgLower string) (string, bool) {
+	for _, raw := range strings.Fields(msgLower) {
+		tok := strings.Trim(raw, "\"'.,;:()[]{}")
+		if !strings.Contains(tok, "*") || len(tok) < 2 {
+			continue
+		}
+		valid := true
+		for _, ch := range tok {
+			if !(ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_' || ch == '*') {
+				valid = false
+				break
+			}
+		}
+		if valid && strings.Trim(tok, "*-_") != "" {
+			return tok, true
+		}
+	}
+	if mm := hostPatternLocationRe.FindStringSubmatch(msgLower); len(mm) == 2 {
+		tok := strings.TrimSpace(mm[1])
+		switch tok {
+		case "the", "my", "our", "these", "those", "active", "online", "offline":
+			return "", false
+		}
+		return tok, false
+	}
+	return "", false
+}
+
+func hostMatchesPattern(host, pattern string, wildcard bool) bool {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if host == "" {
+		return false
+	}
+	if wildcard {
+		ok, err := path.Match(pattern, host)
+		return err == nil && ok
+	}
+	for _, seg := range strings.Split(strings.ReplaceAll(host, "_", "-"), "-") {
+		if seg == pattern {
+			return true
+		}
+	}
+	return len(pattern) >= 4 && strings.Contains(host, pattern)
+}
+
+type deviceHost struct {
+	Host   string
+	Name   string
+	City   string
+	Region string
+	// Venue and Facing are best-effort extras for answer labels: the venue
+	// name when the row embeds one, and the facing direction or stop name
+	// from device_config.
+	Venue  string
+	Facing string
+}
+
+type deviceHostCacheEntry struct {
+	hosts []deviceHost
+	at    time.Time
+}
+
+// deviceHosts returns the device inventory for a city/region scope, cached for a
+// few minutes so repeated pattern questions don't re-page /ads/devices.
+func (c *ChatService) deviceHosts(ctx context.Context, city, region string) ([]deviceHost, []models.Step) {
+	key := city + "|" + region
+	c.deviceHostMu.Lock()
+	if e, ok := c.deviceHostCache[key]; ok && time.Since(e.at) < 5*time.Minute {
+		c.deviceHostMu.Unlock()
+		return e.hosts, nil
+	}
+	c.deviceHostMu.Unlock()
+
+	hosts, steps, ok := c.fetchDeviceInventory(ctx, city, region)
+	if !ok {
+		return nil, steps
+	}
+
+	c.deviceHostMu.Lock()
+	if c.deviceHostCache == nil {
+		c.deviceHostCache = map[string]deviceHostCacheEntry{}
+	}
+	c.deviceHostCache[key] = deviceHostCacheEntry{hosts: hosts, at: time.Now()}
+	c.deviceHostMu.Unlock()
+	return hosts, steps
+}
+
+// fetchDeviceInventory pages /ads/devices for a city/region scope. ok is false
+// when a page failed, in which case hosts is nil.
+func (c *ChatService) fetchDeviceInventory(ctx context.Context, city, region string) ([]deviceHost, []models.Step, bool) {
+	steps := make([]models.Step, 0, 2)
+	hosts := make([]deviceHost, 0, 128)
+	page := 1
+	pageSize := c.limits().ListPageSize
+	maxPages := c.limits().MetricsMaxPages
+	var fetched int64
+	for {
+		p := fmt.Sprintf("/ads/devices?page=%d&page_size=%d", page, pageSize)
+		if city != "" {
+			p += "&city=" + urlEscape(city)
+		}
+		status, body, err := c.Gateway.Get(ctx, p)
+		step := models.Step{Tool: "adsDevices", Status: status}
+		if err != nil {
+			step.Error = err.Error()
+			steps = append(steps, step)
+			return nil, steps, false
+		}
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		steps = append(steps, step)
+		if status < 200 || status >= 300 {
+			return nil, steps, false
+		}
+		var root map[string]any
+		if json.Unmarshal(body, &root) != nil {
+			return nil, steps, false
+		}
+		rows := parseRows(body)
+		fetched += int64(len(rows))
+		hasMore := !paginationFrom(root).done(len(rows), pageSize, fetched)
+		for _, it := range rows {
+			m, ok := it.(map[string]any)
+			if !ok {
+				continue
+			}
+			rowCity, _ := m["city"].(string)
+			rowRegion, _ := m["region"].(string)
+			rowCity = strings.ToLower(strings.TrimSpace(rowCity))
+			rowRegion = strings.ToLower(strings.TrimSpace(rowRegion))
+			if region != "" && rowRegion != region {
+				continue
+			}
+			host := ""
+			for _, k := range []string{"server_id", "serverId", "device_key", "deviceKey", "host_name", "host", "hostName"} {
+				if v, _ := m[k].(string); strings.TrimSpace(v) != "" {
+					host = strings.ToLower(strings.TrimSpace(v))
+					break
+				}
+			}
+			if host == "" {
+				continue
+			}
+			name, _ := m["kiosk_name"].(string)
+			if strings.TrimSpace(name) == "" {
+				name, _ = m["display_name"].(string)
+			}
+			if strings.TrimSpace(name) == "" {
+				name, _ = m["name"].(string)
+			}
+			hosts = append(hosts, deviceHost{
+				Host:   host,
+				Name:   strings.TrimSpace(name),
+				City:   rowCity,
+				Region: rowRegion,
+				Venue:  deviceVenueName(m),
+				Facing: deviceFacing(m),
+			})
+		}
+		if !hasMore {
+			break
+		}
+		page++
+		if page > maxPages {
+			break
+		}
+	}
+	return hosts, steps, true
+}
+
+// suggestHosts ranks inventory hosts by how closely a segment shares a prefix
+// with the pattern, for "did you mean" answers when nothing matched.
+func suggestHosts(hosts []deviceHost, pattern string, limit int) []string {
+	needle := strings.Trim(strings.ReplaceAll(pattern, "*", ""), "-_")
+	type scored struct {
+		host  string
+		score int
+	}
+	ranked := make([]scored, 0, len(hosts))
+	for _, h := range hosts {
+		best := 0
+		for _, seg := range strings.Split(strings.ReplaceAll(h.Host, "_", "-"), "-") {
+			n := 0
+			for n < len(seg) && n < len(needle) && seg[n] == needle[n] {
+				n++
+			}
+			if n > best {
+				best = n
+			}
+		}
+		if strings.HasPrefix(h.Host, needle) && len(needle) > best {
+			best = len(needle)
+		}
+		if best > 0 {
+			ranked = append(ranked, scored{host: h.Host, score: best})
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].host < ranked[j].host
+	})
+	out := make([]string, 0, limit)
+	seen := map[string]struct{}{}
+	for _, r := range ranked {
+		if _, ok := seen[r.host]; ok {
+			continue
+		}
+		seen[r.host] = struct{}{}
+		out = append(out, r.host)
+		if len(out) >= limit {
+			break
+		}
+	}
+	return out
+}
+
+// expandHostPattern lists the inventory hosts matching a pattern from
+// extractHostPattern, sorted. ok is false when the device list could not be
+// read.
+func (c *ChatService) expandHostPattern(ctx context.Context, msgLower, pattern string, wildcard bool) (matched []string, inventory []deviceHost, steps []models.Step, ok bool) {
+	// Scope the inventory fetch: fixed leading segments of a wildcard pattern win,
+	// otherwise fall back to a city/region mentioned in the message.
+	city := ""
+	region := ""
+	if wildcard {
+		segs := strings.Split(pattern, "-")
+		if len(segs) >= 2 && !strings.ContainsAny(segs[0], "*?[") {
+			city = segs[0]
+			if len(segs) >= 3 && !strings.ContainsAny(segs[1], "*?[") {
+				region = segs[1]
+			}
+		}
+	} else {
+		city = c.detectCityCode(ctx, msgLower)
+		region = c.detectRegionCode(ctx, msgLower)
+		if region == pattern {
+			region = ""
+		}
+		if city == pattern {
+			city = ""
+		}
+	}
+
+	inventory, steps = c.deviceHosts(ctx, city, region)
+	if len(inventory) == 0 && len(steps) > 0 && (steps[len(steps)-1].Error != "" || steps[len(steps)-1].Status < 200 || steps[len(steps)-1].Status >= 300) {
+		return nil, inventory, steps, false
+	}
+	matched = make([]string, 0)
+	seen := map[string]struct{}{}
+	for _, d := range inventory {
+		if !hostMatchesPattern(d.Host, pattern, wildcard) {
+			continue
+		}
+		if _, ok := seen[d.Host]; ok {
+			continue
+		}
+		seen[d.Host] = struct{}{}
+		matched = append(matched, d.Host)
+	}
+	sort.Strings(matched)
+	return matched, inventory, steps, true
+}
+
+func (c *ChatService) handleHostPatternSummary(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	pattern, wildcard := extractHostPattern(msgLower)
+	if pattern == "" {
+		return models.ChatResponse{}, false, nil
+	}
+	wantsPop := strings.Contains(msgLower, "pop") || strings.Contains(msgLower, "play") || strings.Contains(msgLower, "plays")
+	wantsTelemetry := strings.Contains(msgLower, "telemetry") || strings.Contains(msgLower, "health") || strings.Contains(msgLower, "cpu") || strings.Contains(msgLower, "temp") || strings.Contains(msgLower, "offline") || strings.Contains(msgLower, "status")
+	isSummary := strings.Contains(msgLower, "summar") || strings.Contains(msgLower, "rollup") || strings.Contains(msgLower, "overview")
+	if !wantsPop && !wantsTelemetry {
+		if !isSummary {
+			return models.ChatResponse{}, false, nil
+		}
+		wantsPop = true
+		wantsTelemetry = true
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+
+	matched, inventory, steps, ok := c.expandHostPattern(ctx, msgLower, pattern, wildcard)
+	if !ok {
+		return models.ChatResponse{Answer: "Failed to fetch the device list to expand the host pattern.", Steps: steps}, true, nil
+	}
+	if len(matched) == 0 {
+		answer := fmt.Sprintf("No kiosks matched '%s'.", pattern)
+		if sugg := suggestHosts(inventory, pattern, 5); len(sugg) > 0 {
+			answer += " Nearby host names: " + strings.Join(sugg, ", ") + "."
+		}
+		if onToken != nil {
+			onToken(answer)
+		}
+		return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+	}
+	totalMatched := len(matched)
+	maxHosts := c.MaxPatternHosts
+	if maxHosts <= 0 {
+		maxHosts = 30
+	}
+	if len(matched) > maxHosts {
+		matched = matched[:maxHosts]
+	}
+
+	now := time.Now().UTC()
+	todayStartUTC := now.Truncate(24 * time.Hour)
+	fromRFC := todayStartUTC.Format(time.RFC3339)
+	toRFC := now.Format(time.RFC3339)
+	periodLabel := "today"
+	if strings.Contains(msgLower, "yesterday") {
+		fromRFC = todayStartUTC.Add(-24 * time.Hour).Format(time.RFC3339)
+		toRFC = todayStartUTC.Format(time.RFC3339)
+		periodLabel = "yesterday"
+	} else if strings.Contains(msgLower, "week") || strings.Contains(msgLower, "7 days") {
+		fromRFC = todayStartUTC.Add(-6 * 24 * time.Hour).Format(time.RFC3339)
+		periodLabel = "the last 7 days"
+	}
+
+	type hostResult struct {
+		plays  int64
+		popErr error
+		tel    hostTelemetrySample
+		steps  []models.Step
+	}
+	results := make([]hostResult, len(matched))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 8)
+	for i, h := range matched {
+		wg.Add(1)
+		go func(i int, h string) {
+			defer wg.Done()
+			defer recoverInto("host pattern fetch", func(err error) { results[i] = hostResult{popErr: err} })
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			r := hostResult{}
+			if wantsPop {
+				r.plays, r.steps, r.popErr = c.fetchHostPopPlays(ctx, h, fromRFC, toRFC)
+			}
+			if wantsTelemetry {
+				var step models.Step
+				r.tel, step = c.fetchHostLatestTelemetry(ctx, h)
+				r.steps = append(r.steps, step)
+			}
+			results[i] = r
+		}(i, h)
+	}
+	wg.Wait()
+	for _, r := range results {
+		steps = append(steps, r.steps...)
+	}
+
+	lines := make([]string, 0, len(matched)+6)
+	header := fmt.Sprintf("Pattern '%s' matched %d host(s)", pattern, totalMatched)
+	if totalMatched > len(matched) {
+		header += fmt.Sprintf(" (summarizing the first %d)", len(matched))
+	}
+	lines = append(lines, header+".")
+
+	if wantsPop {
+		total := int64(0)
+		failed := 0
+		for _, r := range results {
+			if r.popErr != nil {
+				failed++
+				continue
+			}
+			total += r.plays
+		}
+		popLine := fmt.Sprintf("POP %s: %s plays across %d host(s).", periodLabel, formatThousands(total), len(matched)-failed)
+		if failed > 0 {
+			popLine += fmt.Sprintf(" (%d host(s) could not be fetched.)", failed)
+		}
+		lines = append(lines, popLine)
+		if len(matched) <= c.limits().DisplayTopN {
+			for i, h := range matched {
+				if results[i].popErr != nil {
+					lines = append(lines, fmt.Sprintf("- %s: unavailable", h))
+					continue
+				}
+				lines = append(lines, fmt.Sprintf("- %s: %s plays", h, formatThousands(results[i].plays)))
+			}
+		}
+	}
+
+	report := newThresholdReport()
+	if wantsTelemetry {
+		var cpuMin, cpuMax, cpuSum, tempMin, tempMax, tempSum float64
+		reporting := 0
+		offline := 0
+		missing := 0
+		for _, r := range results {
+			if !r.tel.Found {
+				missing++
+				continue
+			}
+			if reporting == 0 {
+				cpuMin, cpuMax = r.tel.CPU, r.tel.CPU
+				tempMin, tempMax = r.tel.Temperature, r.tel.Temperature
+			}
+			reporting++
+			cpuSum += r.tel.CPU
+			tempSum += r.tel.Temperature
+			cpuMin = math.Min(cpuMin, r.tel.CPU)
+			cpuMax = math.Max(cpuMax, r.tel.CPU)
+			tempMin = math.Min(tempMin, r.tel.Temperature)
+			tempMax = math.Max(tempMax, r.tel.Temperature)
+			if !r.tel.PowerOnline {
+				offline++
+			}
+		}
+		if reporting == 0 {
+			lines = append(lines, "Telemetry: no matched host reported telemetry.")
+		} else {
+			lines = append(lines, fmt.Sprintf("Telemetry (%d reporting): CPU min/avg/max %s/%s/%s | Temperature min/avg/max %s/%s/%s°C | Offline %d | No telemetry %d.",
+				reporting, formatDecimal(cpuMin, 1), formatDecimal(cpuSum/float64(reporting), 1), formatPercent(cpuMax), formatDecimal(tempMin, 1), formatDecimal(tempSum/float64(reporting), 1), formatDecimal(tempMax, 1), offline, missing))
+		}
+		if len(matched) <= c.limits().DisplayTopN && !wantsPop {
+			lines = append(lines, "Hosts: "+strings.Join(matched, ", "))
+		}
+		units := c.unitPrefs(req)
+		limits := c.thresholds(ctx)
+		var flagged []string
+		for i, h := range matched {
+			if !results[i].tel.Found {
+				continue
+			}
+			if markers := report.add(h, limits.checkAll(units, results[i].tel.thresholdReadings()...)); markers != "" {
+				flagged = append(flagged, fmt.Sprintf("- %s: %s", h, markers))
+			}
+		}
+		if topN := c.limits().DisplayTopN; len(flagged) > topN {
+			flagged = append(flagged[:topN], fmt.Sprintf("… and %d more host(s) outside thresholds.", len(flagged)-topN))
+		}
+		lines = append(lines, flagged...)
+	}
+
+	answer := report.prepend(strings.Join(lines, "\n"), len(matched))
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+}
+
+func (c *ChatService) handleTopDevicesFromCity(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	excl, msgNoExcl := parsePopExclusion(req.Message)
+	req.Message = msgNoExcl
+	msgLower := strings.ToLower(req.Message)
+	if !isTopDevicesFromCityIntent(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	conversationID := strings.TrimSpace(req.ConversationID)
+	city := c.detectCityCode(ctx, msgLower)
+	region := ""
+	if city == "" {
+		region = c.detectRegionCode(ctx, msgLower)
+		// Memory fallback: "show top devices" should reuse last scope.
+		if region == "" && conversationID != "" {
+			if st := c.getConversationState(conversationID); st != nil {
+				if strings.TrimSpace(st.Region) != "" {
+					region = strings.ToLower(strings.TrimSpace(st.Region))
+				} else if strings.TrimSpace(st.City) != "" {
+					city = strings.ToLower(strings.TrimSpace(st.City))
+				}
+			}
+		}
+		if region == "" && city == "" {
+			return models.ChatResponse{Answer: "Please specify a city code (for example: kcmo, kc, brt, dart)."}, true, nil
+		}
+	}
+	if conversationID != "" {
+		c.updateConversationLocation(conversationID, city, region)
+		c.clearPending(conversationID)
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+	metric := "clicks"
+	if strings.Contains(msgLower, "play") {
+		metric = "plays"
+	}
+	limit, full := c.listLimit(msgLower)
+	// Without an explicit N, one row past the limit tells whether the list
+	// was cut.
+	fetch := limit + 1
+	explicitN := false
+	if n := extractTopN(msgLower); n > 0 {
+		limit, fetch, full, explicitN = n, n, false, true
+	}
+	path := "/pop/stats?group_by=device&metric=" + metric + "&order=top&limit=" + fmt.Sprintf("%d", fetch)
+	scopeFilter := ""
+	scopeLabel := ""
+	if region != "" {
+		scopeFilter = "region=" + urlEscape(region)
+		scopeLabel = region
+	} else {
+		scopeFilter = "city=" + urlEscape(city)
+		scopeLabel = city
+	}
+	if excl != nil {
+		// /pop/stats has no NOT filter; aggregate raw rows instead.
+		resp := c.popTopExcluding(ctx, excl, scopeFilter, "device", metric, scopeLabel, limit)
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	path += "&" + scopeFilter
+	status, body, err := c.Gateway.Get(ctx, path)
+	step := models.Step{Tool: "popStats", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+	} else {
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	}
+	steps := []models.Step{step}
+	answer := ""
+	if err != nil {
+		answer = "Failed to fetch POP stats: " + err.Error()
+	} else if status < 200 || status >= 300 {
+		answer = fmt.Sprintf("Failed to fetch POP stats (status %d).", status)
+	} else {
+		var parsed map[string]any
+		_ = json.Unmarshal(body, &parsed)
+		itemsAny, _ := parsed["items"].([]any)
+		if len(itemsAny) == 0 {
+			if region != "" {
+				answer = fmt.Sprintf("No device %s stats found for region '%s'.", metric, scopeLabel)
+			} else {
+				answer = fmt.Sprintf("No device %s stats found for city '%s'.", metric, scopeLabel)
+			}
+		} else {
+			type topRow struct {
+				key string
+				val float64
+			}
+			top := make([]topRow, 0, len(itemsAny))
+			more := false
+			for i, it := range itemsAny {
+				if i >= limit {
+					more = true
+					break
+				}
+				row, ok := it.(map[string]any)
+				if !ok {
+					continue
+				}
+				k, _ := row["Key"].(string)
+				val := 0.0
+				switch v := row["Metric"].(type) {
+				case float64:
+					val = v
+				case int:
+					val = float64(v)
+				}
+				if strings.TrimSpace(k) == "" {
+					continue
+				}
+				top = append(top, topRow{key: k, val: val})
+			}
+			keys := make([]string, 0, len(top))
+			for _, r := range top {
+				keys = append(keys, r.key)
+			}
+			names, metaSteps := c.deviceLabels(ctx, city, region, keys)
+			steps = append(steps, metaSteps...)
+			lines := make([]string, 0, len(top))
+			snapshot := make([]models.AnswerRow, 0, len(top))
+			listed := make([]listedEntity, 0, len(top))
+			table := newTextTable("#", "Device", capitalize(metric)).alignRight(0, 2)
+			for _, r := range top {
+				lines = append(lines, fmt.Sprintf("%d. %s — %s %s", len(lines)+1, names[r.key], formatDecimal(r.val, 0), metric))
+				table.add(fmt.Sprintf("%d", len(lines)), names[r.key], formatDecimal(r.val, 0))
+				snapshot = append(snapshot, models.AnswerRow{Key: r.key, Label: names[r.key], Value: r.val})
+				listed = append(listed, listedEntity{ID: r.key, Name: names[r.key]})
+			}
+			if wantsTables(req) {
+				lines = table.lines()
+			}
+			if !explicitN {
+				if note := (listCap{Shown: len(listed), Total: len(listed), More: more, Full: full, Noun: "devices"}).note(); note != "" {
+					lines = append(lines, note)
+				}
+			}
+			c.rememberRanking(conversationID, listKindDevice, listed, listRanking{Scope: "in " + scopeLabel})
+			changes := c.answerChanges(ctx, req, "top_devices_"+metric, snapshot, func(v float64) string {
+				return formatDecimal(v, 0) + " " + metric
+			})
+			answer = withChanges(req, changes, fmt.Sprintf("Top devices in %s by %s:\n%s", scopeLabel, metric, strings.Join(lines, "\n")))
+		}
+	}
+	if onToken != nil {
+		for i := 0; i < len(answer); i += 20 {
+			end := i + 20
+			if end > len(answer) {
+				end = len(answer)
+			}
+			onToken(answer[i:end])
+		}
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+}
+
+// deviceKeyParts splits a host or device key into its lowercase segments.
+func deviceKeyParts(key string) []string {
+	k := strings.ToLower(strings.TrimSpace(key))
+	if k == "" {
+		return nil
+	}
+	// Normalize separators so keys like "moco_brt_web" are parsed similarly.
+	k = strings.ReplaceAll(k, "_", "-")
+	return strings.Split(k, "-")
+}
+
+// deviceKeyScope reads the city and region a host encodes in its first two
+// segments: moco-brt-briggs-001 is city "moco", region "brt". Keys with
+// fewer than three segments, or with prefixes that are not plain letters,
+// encode no scope.
+func deviceKeyScope(key string) (city, region string) {
+	parts := deviceKeyParts(key)
+	if len(parts) < 3 || !isLetters(parts[0]) || !isLetters(parts[1]) {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+func isLetters(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func cityFromDeviceKey(key string) string {
+	parts := deviceKeyParts(key)
+	if len(parts) == 0 {
+		return ""
+	}
+	known := map[string]string{
+		"brt":  "BRT",
+		"dart": "DART",
+		"kc":   "KC",
+		"kcmo": "KCMO",
+		"jct":  "JCT",
+		"au":   "AU",
+		"da":   "DA",
+	}
+	if v, ok := known[strings.TrimSpace(parts[0])]; ok {
+		return v
+	}
+	if len(parts) > 1 {
+		if v, ok := known[strings.TrimSpace(parts[1])]; ok {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// deviceMeta is what answers show next to a raw host: the kiosk display name,
+// its venue and the facing direction or stop name.
+type deviceMeta struct {
+	Name   string
+	Venue  string
+	Facing string
+	at     time.Time
+}
+
+// deviceVenueName returns the venue name embedded in an /ads/devices row, if
+// any.
+func deviceVenueName(m map[string]any) string {
+	if v, ok := m["venue"].(map[string]any); ok {
+		if name, _ := v["name"].(string); strings.TrimSpace(name) != "" {
+			return strings.TrimSpace(name)
+		}
+	}
+	for _, k := range []string{"venue_name", "venueName"} {
+		if name, _ := m[k].(string); strings.TrimSpace(name) != "" {
+			return strings.TrimSpace(name)
+		}
+	}
+	return ""
+}
+
+// deviceFacing reads the facing direction or stop name from a row's
+// device_config, which the gateway returns either as an object or as a JSON
+// string.
+func deviceFacing(m map[string]any) string {
+	var cfg map[string]any
+	switch v := m["device_config"].(type) {
+	case map[string]any:
+		cfg = v
+	case string:
+		_ = json.Unmarshal([]byte(v), &cfg)
+	}
+	for _, k := range []string{"facing", "facing_direction", "direction", "stop_name", "stop"} {
+		if s, _ := cfg[k].(string); strings.TrimSpace(s) != "" {
+			return strings.TrimSpace(s)
+		}
+	}
+	return ""
+}
+
+// label renders meta for an answer line, e.g. "Briggs & 5th (moco-brt-briggs-001)".
+// It falls back to the bare host when nothing useful is known.
+func (m deviceMeta) label(host string) string {
+	title := m.Name
+	if title == "" || strings.EqualFold(title, host) {
+		title = m.Venue
+	}
+	if title == "" {
+		return host
+	}
+	if m.Venue != "" && !strings.EqualFold(m.Venue, title) {
+		title += " at " + m.Venue
+	}
+	if m.Facing != "" && !strings.Contains(strings.ToLower(title), strings.ToLower(m.Facing)) {
+		title += ", " + m.Facing
+	}
+	return title + " (" + host + ")"
+}
+
+// deviceMetaTTL follows the city cache TTL so device names refresh on the
+// same cadence as scope detection.
+func (c *ChatService) deviceMetaTTL() time.Duration {
+	c.cityMu.Lock()
+	ttl := c.cityCacheTTL
+	c.cityMu.Unlock()
+	if ttl > 0 {
+		return ttl
+	}
+	return 10 * time.Minute
+}
+
+// deviceLabels returns answer labels for hosts. Metadata is cached per host;
+// when any host is missing or stale, one /ads/devices fetch scoped to
+// city/region refreshes the whole scope, and a scope is not re-fetched within
+// the TTL just because some hosts are absent from the inventory. Hosts without
+// metadata keep their raw name, so a failed fetch never blocks an answer.
+func (c *ChatService) deviceLabels(ctx context.Context, city, region string, hosts []string) (map[string]string, []models.Step) {
+	out := make(map[string]string, len(hosts))
+	if len(hosts) == 0 {
+		return out, nil
+	}
+	ttl := c.deviceMetaTTL()
+	scope := city + "|" + region
+
+	c.deviceMetaMu.Lock()
+	stale := false
+	for _, h := range hosts {
+		e, ok := c.deviceMetaCache[strings.ToLower(h)]
+		if !ok || time.Since(e.at) >= ttl {
+			stale = true
+			break
+		}
+	}
+	if at, ok := c.deviceMetaScopes[scope]; ok && time.Since(at) < ttl {
+		stale = false
+	}
+	c.deviceMetaMu.Unlock()
+
+	var steps []models.Step
+	if stale && c.Gateway != nil {
+		var inv []deviceHost
+		var ok bool
+		inv, steps, ok = c.fetchDeviceInventory(ctx, city, region)
+		if ok {
+			now := time.Now()
+			c.deviceMetaMu.Lock()
+			if c.deviceMetaCache == nil {
+				c.deviceMetaCache = map[string]deviceMeta{}
+				c.deviceMetaScopes = map[string]time.Time{}
+			}
+			for _, d := range inv {
+				c.deviceMetaCache[d.Host] = deviceMeta{Name: d.Name, Venue: d.Venue, Facing: d.Facing, at: now}
+			}
+			c.deviceMetaScopes[scope] = now
+			c.deviceMetaMu.Unlock()
+		}
+	}
+
+	c.deviceMetaMu.Lock()
+	for _, h := range hosts {
+		out[h] = c.deviceMetaCache[strings.ToLower(h)].label(h)
+	}
+	c.deviceMetaMu.Unlock()
+	return out, steps
+}
+
+// deviceMetaSnapshot lists cached hosts and the oldest entry time for the
+// admin cache endpoint.
+func (c *ChatService) deviceMetaSnapshot() ([]string, time.Time) {
+	c.deviceMetaMu.Lock()
+	defer c.deviceMetaMu.Unlock()
+	keys := make([]string, 0, len(c.deviceMetaCache))
+	oldest := time.Time{}
+	for k, e := range c.deviceMetaCache {
+		keys = append(keys, k)
+		if oldest.IsZero() || e.at.Before(oldest) {
+			oldest = e.at
+		}
+	}
+	sort.Strings(keys)
+	return keys, oldest
+}
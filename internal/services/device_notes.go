@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"openai-agent-service/internal/models"
+)
+
+// DeviceNoteStore persists operator notes on hosts; implemented by
+// store.PostgresStore. Notes are org-wide: every API key sees the same notes.
+type DeviceNoteStore interface {
+	AddDeviceNote(ctx context.Context, n models.DeviceNote) (models.DeviceNote, error)
+	ListDeviceNotes(ctx context.Context, host string) ([]models.DeviceNote, error)
+	DeleteDeviceNote(ctx context.Context, host string, id int64) error
+}
+
+const (
+	// maxDeviceNoteLen caps a note, in characters.
+	maxDeviceNoteLen = 500
+	// deviceNoteFooterNotes is how many notes device answers quote.
+	deviceNoteFooterNotes = 2
+)
+
+var (
+	deviceNoteAddRe     = regexp.MustCompile(`(?is)^(?:please\s+)?(?:add|leave|attach)\s+(?:a\s+)?note\s+(?:to|on|for)\s+(?:(?:the\s+)?(?:kiosk|device|host)\s+)?([a-z0-9_.-]+)\s*:\s*(.*)$`)
+	deviceNoteListRe    = regexp.MustCompile(`^(?:please\s+)?(?:show|list|get|view)\s+(?:me\s+)?(?:the\s+|all\s+)?(?:device\s+)?notes\s+(?:for|on|of)\s+(?:(?:the\s+)?(?:kiosk|device|host)\s+)?([a-z0-9_.-]+?)[.!?]*$`)
+	deviceNoteDeleteRe  = regexp.MustCompile(`^(?:please\s+)?(?:delete|remove)\s+note\s+#?(\d+)\s+(?:on|from|for)\s+(?:(?:the\s+)?(?:kiosk|device|host)\s+)?([a-z0-9_.-]+?)[.!]*$`)
+	deviceNoteConfirmRe = regexp.MustCompile(`^confirm\s+delete\s+note\s+#?(\d+)\s+(?:on|from|for)\s+([a-z0-9_.-]+?)[.!]*$`)
+)
+
+// handleDeviceNotes adds, lists and deletes notes on a host: "add a note to
+// briggs-001: replaced modem", "show notes for briggs-001" and "delete note 2
+// on briggs-001". Deletes are stateless like saved-query deletes: the reply
+// quotes the note and the user echoes "confirm delete note 2 on <host>".
+func (c *ChatService) handleDeviceNotes(ctx context.Context, ownerKey string, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msg := strings.TrimSpace(req.Message)
+	msgLower := strings.ToLower(msg)
+	add := deviceNoteAddRe.FindStringSubmatch(msg)
+	list := deviceNoteListRe.FindStringSubmatch(msgLower)
+	del := deviceNoteDeleteRe.FindStringSubmatch(msgLower)
+	confirm := deviceNoteConfirmRe.FindStringSubmatch(msgLower)
+	if add == nil && list == nil && del == nil && confirm == nil {
+		return models.ChatResponse{}, false, nil
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	if c.Notes == nil {
+		return reply(models.ChatResponse{Answer: "Device notes are not configured."})
+	}
+	if (add != nil || del != nil || confirm != nil) && readOnlyKey(ctx) {
+		return reply(readOnlyRefusal(ctx, "add or delete device notes"))
+	}
+
+	var token string
+	switch {
+	case add != nil:
+		token = add[1]
+	case list != nil:
+		token = list[1]
+	case del != nil:
+		token = del[2]
+	default:
+		token = confirm[2]
+	}
+	host, steps := strings.ToLower(token), []models.Step(nil)
+	if c.Gateway != nil {
+		var candidates []string
+		host, candidates, steps = c.resolveShortHost(ctx, token, "", "")
+		if host == "" {
+			return reply(models.ChatResponse{Answer: fmt.Sprintf("'%s' matches several devices (%s); name the full host.", token, strings.Join(candidates, ", ")), Steps: steps})
+		}
+	}
+	if conversationID := strings.TrimSpace(req.ConversationID); conversationID != "" {
+		c.updateConversationHost(conversationID, host)
+	}
+	loc := requestLocation(req)
+
+	switch {
+	case add != nil:
+		text := strings.TrimSpace(add[2])
+		if text == "" {
+			return reply(models.ChatResponse{Answer: fmt.Sprintf("What should the note say? For example: \"add a note to %s: replaced modem\".", host), Steps: steps})
+		}
+		if n := utf8.RuneCountInString(text); n > maxDeviceNoteLen {
+			return reply(models.ChatResponse{Answer: fmt.Sprintf("Notes are capped at %d characters and this one has %d; nothing was saved.", maxDeviceNoteLen, n), Steps: steps})
+		}
+		note, err := c.Notes.AddDeviceNote(ctx, models.DeviceNote{Host: host, Author: OwnerHash(ownerKey), Note: text})
+		if err != nil {
+			return reply(models.ChatResponse{Answer: "Failed to save the note: " + err.Error(), Steps: steps})
+		}
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("Added note #%d to %s: %s", note.ID, host, note.Note), Steps: steps})
+
+	case list != nil:
+		notes, err := c.Notes.ListDeviceNotes(ctx, host)
+		if err != nil {
+			return reply(models.ChatResponse{Answer: "Failed to load device notes: " + err.Error(), Steps: steps})
+		}
+		if len(notes) == 0 {
+			return reply(models.ChatResponse{Answer: fmt.Sprintf("There are no notes on %s. Add one with \"add a note to %s: ...\".", host, host), Steps: steps})
+		}
+		lines := []string{fmt.Sprintf("Notes on %s (%d, newest first):", host, len(notes))}
+		for _, n := range notes {
+			lines = append(lines, fmt.Sprintf("- #%d %s by %s: %s", n.ID, n.CreatedAt.In(loc).Format("Jan 2, 2006"), noteAuthor(n, ownerKey), n.Note))
+		}
+		return reply(models.ChatResponse{Answer: strings.Join(lines, "\n"), Steps: steps})
+	}
+
+	var idText string
+	if del != nil {
+		idText = del[1]
+	} else {
+		idText = confirm[1]
+	}
+	id, _ := strconv.ParseInt(idText, 10, 64)
+	if del != nil {
+		notes, err := c.Notes.ListDeviceNotes(ctx, host)
+		if err != nil {
+			return reply(models.ChatResponse{Answer: "Failed to load device notes: " + err.Error(), Steps: steps})
+		}
+		for _, n := range notes {
+			if n.ID == id {
+				return reply(models.ChatResponse{Answer: fmt.Sprintf("Note #%d on %s (%s by %s): %s\nReply 'confirm delete note %d on %s' to delete it.", n.ID, host, n.CreatedAt.In(loc).Format("Jan 2, 2006"), noteAuthor(n, ownerKey), n.Note, n.ID, host), Steps: steps})
+			}
+		}
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("There is no note #%d on %s. Say \"show notes for %s\" to see its notes.", id, host, host), Steps: steps})
+	}
+	if err := c.Notes.DeleteDeviceNote(ctx, host, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return reply(models.ChatResponse{Answer: fmt.Sprintf("There is no note #%d on %s; nothing was deleted.", id, host), Steps: steps})
+		}
+		return reply(models.ChatResponse{Answer: "Failed to delete the note: " + err.Error(), Steps: steps})
+	}
+	return reply(models.ChatResponse{Answer: fmt.Sprintf("Deleted note #%d on %s.", id, host), Steps: steps})
+}
+
+// noteAuthor names a note's author for the caller: "you" for their own notes,
+// otherwise the author's key hash.
+func noteAuthor(n models.DeviceNote, ownerKey string) string {
+	if n.Author == OwnerHash(ownerKey) {
+		return "you"
+	}
+	if n.Author == "" {
+		return "unknown"
+	}
+	return "key " + n.Author
+}
+
+// withDeviceNotes appends the host's latest notes to a device answer, as
+// "📝 2 notes — latest: replaced modem (Oct 12); ...". Hosts without notes,
+// and notes that cannot be loaded, leave the answer unchanged.
+func (c *ChatService) withDeviceNotes(ctx context.Context, req models.ChatRequest, host, answer string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if c.Notes == nil || host == "" {
+		return answer
+	}
+	notes, err := c.Notes.ListDeviceNotes(ctx, host)
+	if err != nil || len(notes) == 0 {
+		return answer
+	}
+	count := fmt.Sprintf("%d notes", len(notes))
+	if len(notes) == 1 {
+		count = "1 note"
+	}
+	loc := requestLocation(req)
+	latest := make([]string, 0, deviceNoteFooterNotes)
+	for i, n := range notes {
+		if i == deviceNoteFooterNotes {
+			break
+		}
+		latest = append(latest, fmt.Sprintf("%s (%s)", truncateTitle(n.Note, 80), n.CreatedAt.In(loc).Format("Jan 2")))
+	}
+	return answer + fmt.Sprintf("\n\n📝 %s — latest: %s", count, strings.Join(latest, "; "))
+}
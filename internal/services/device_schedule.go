@@ -0,0 +1,593 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+const (
+	// scheduleConcurrency bounds the gateway calls a schedule answer runs at
+	// once.
+	scheduleConcurrency = 5
+	// scheduleMaxCampaigns caps the campaigns read for their targeting.
+	scheduleMaxCampaigns = 25
+	// scheduleMaxDays caps the days listed; longer windows are summarized
+	// past it.
+	scheduleMaxDays = 14
+)
+
+var (
+	// scheduleViewRe asks what is going to play, not what played.
+	scheduleViewRe = regexp.MustCompile(`\b(?:what(?:'s|\s+is|\s+will)?\s+(?:be\s+)?(?:scheduled|planned|lined\s+up|set\s+to\s+(?:play|run))|scheduled\s+to\s+(?:play|run)|(?:going\s+to|will)\s+(?:play|run)|schedule\s+(?:for|of|at|on))\b`)
+	// scheduleDeviceRe is the device cue that tells the question apart from
+	// campaign listings ("what campaigns are scheduled").
+	scheduleDeviceRe = regexp.MustCompile(`\b(?:kiosks?|devices?|screens?|hosts?|venues?)\b`)
+	// scheduleVenueRe reads the venue in "at the stadium kiosks" or "on the
+	// union station screens".
+	scheduleVenueRe = regexp.MustCompile(`\b(?:at|in|on)\s+(?:the\s+)?([a-z0-9][a-z0-9 '&.-]*?)\s+(?:kiosks?|devices?|screens?|venue)\b`)
+	// scheduleSkipStatusRe are creative and campaign statuses that do not run.
+	scheduleSkipStatusRe = regexp.MustCompile(`^(?:paused|ended|completed|archived|cancel+ed|draft|rejected|inactive|deleted)$`)
+)
+
+// scheduleVenueStopwords are words scheduleVenueRe can capture that name no
+// venue ("on the kiosks", "at my screens").
+var scheduleVenueStopwords = map[string]bool{"the": true, "all": true, "my": true, "our": true, "these": true, "those": true, "this": true, "that": true}
+
+// isDeviceScheduleIntent matches "what's scheduled to play at the stadium
+// kiosks this weekend" and "what will run on moco-brt-briggs-001 tomorrow".
+func isDeviceScheduleIntent(msg, msgLower string) bool {
+	if !scheduleViewRe.MatchString(msgLower) {
+		return false
+	}
+	if !scheduleDeviceRe.MatchString(msgLower) && len(detectHostTokens(msg)) == 0 {
+		return false
+	}
+	for _, k := range []string{"create", "played", "pop "} {
+		if strings.Contains(msgLower, k) {
+			return false
+		}
+	}
+	return true
+}
+
+// scheduleDevices is the device set a schedule question is about. Hosts is
+// capped; Total is the size before the cap.
+type scheduleDevices struct {
+	Label string
+	Hosts []string
+	Total int
+}
+
+// resolveScheduleDevices finds the devices a schedule question names: hosts,
+// a host pattern ("moco-brt-*", "all briggs kiosks"), a venue ("at the
+// stadium kiosks"), or the host or venue the conversation remembers. The
+// string is the answer to give when none resolves.
+func (c *ChatService) resolveScheduleDevices(ctx context.Context, req models.ChatRequest, msgLower string) (scheduleDevices, []models.Step, string) {
+	conversationID := strings.TrimSpace(req.ConversationID)
+	var steps []models.Step
+	venue := func(id int, name string) (scheduleDevices, []models.Step, string) {
+		hosts, capped, step, err := c.venueHosts(ctx, id)
+		steps = append(steps, step)
+		if err != nil {
+			return scheduleDevices{}, steps, fmt.Sprintf("Failed to fetch the devices of venue %s: %v.", name, err)
+		}
+		if len(hosts) == 0 {
+			return scheduleDevices{}, steps, fmt.Sprintf("Venue %s has no devices.", name)
+		}
+		if conversationID != "" {
+			c.updateConversationVenueID(conversationID, id)
+		}
+		total := len(hosts)
+		if capped {
+			total++
+		}
+		return scheduleDevices{Label: "venue " + name, Hosts: hosts, Total: total}, steps, ""
+	}
+
+	if hosts := detectHostTokens(req.Message); len(hosts) > 0 {
+		out := make([]string, 0, len(hosts))
+		for _, h := range hosts {
+			out = append(out, strings.ToLower(strings.TrimSpace(h)))
+		}
+		label := "kiosk " + out[0]
+		if len(out) > 1 {
+			label = fmt.Sprintf("%d kiosks", len(out))
+		} else if conversationID != "" {
+			c.updateConversationHost(conversationID, out[0])
+		}
+		return scheduleDevices{Label: label, Hosts: out, Total: len(out)}, nil, ""
+	}
+	pattern := func(p string, wildcard bool) (scheduleDevices, []models.Step, string, bool) {
+		matched, _, st, ok := c.expandHostPattern(ctx, msgLower, p, wildcard)
+		steps = append(steps, st...)
+		if !ok {
+			return scheduleDevices{}, steps, "Failed to fetch the device list to expand the host pattern.", true
+		}
+		if len(matched) == 0 {
+			return scheduleDevices{}, steps, "", false
+		}
+		return scheduleDevices{Label: fmt.Sprintf("kiosks matching '%s'", p), Hosts: matched, Total: len(matched)}, steps, "", true
+	}
+	if p, wildcard := extractHostPattern(msgLower); p != "" {
+		if set, st, answer, ok := pattern(p, wildcard); ok {
+			return set, st, answer
+		}
+		return scheduleDevices{}, steps, fmt.Sprintf("No kiosks matched '%s'.", p)
+	}
+	if m := scheduleVenueRe.FindStringSubmatch(msgLower); m != nil && !scheduleVenueStopwords[strings.TrimSpace(m[1])] {
+		name := strings.TrimSpace(m[1])
+		id, step := c.resolveVenueIDFromName(ctx, conversationID, name)
+		if step != nil {
+			steps = append(steps, *step)
+		}
+		if id > 0 {
+			return venue(id, fmt.Sprintf("'%s'", name))
+		}
+		// "the briggs kiosks" may name a host segment rather than a venue.
+		if !strings.Contains(name, " ") {
+			if set, st, answer, ok := pattern(name, false); ok {
+				return set, st, answer
+			}
+		}
+		return scheduleDevices{}, steps, fmt.Sprintf("I couldn't find a venue or kiosks matching '%s'.", name)
+	}
+	if st := c.getConversationState(conversationID); st != nil {
+		switch {
+		case strings.TrimSpace(st.Host) != "":
+			host := strings.ToLower(strings.TrimSpace(st.Host))
+			return scheduleDevices{Label: "kiosk " + host, Hosts: []string{host}, Total: 1}, nil, ""
+		case st.VenueID > 0:
+			return venue(st.VenueID, fmt.Sprintf("%d", st.VenueID))
+		}
+	}
+	return scheduleDevices{}, nil, "Which kiosks? Name a host, a venue (\"at the stadium kiosks\") or a host pattern (\"moco-brt-*\")."
+}
+
+// scheduleEntry is one creative assigned to devices, with the days and slots
+// it runs. Days empty means every day; Start and End are calendar days as
+// campaignDay returns them, zero when open.
+type scheduleEntry struct {
+	Creative   string
+	CampaignID string
+	Campaign   string
+	Hosts      map[string]bool
+	Days       map[time.Weekday]bool
+	Slots      []string
+	Start, End time.Time
+}
+
+// runsOn reports whether the entry runs on day, a calendar day at UTC
+// midnight.
+func (e scheduleEntry) runsOn(day time.Time) bool {
+	if !e.Start.IsZero() && day.Before(e.Start) {
+		return false
+	}
+	if !e.End.IsZero() && day.After(e.End) {
+		return false
+	}
+	return len(e.Days) == 0 || e.Days[day.Weekday()]
+}
+
+// scheduleWeekdays reads selected_days ("mon", "Saturday") into weekdays.
+func scheduleWeekdays(v any) map[time.Weekday]bool {
+	days := map[time.Weekday]bool{}
+	for _, d := range parseSelectedDays(strings.Join(stringList(v), " ")) {
+		for wd := time.Sunday; wd <= time.Saturday; wd++ {
+			if strings.HasPrefix(strings.ToLower(wd.String()), d) {
+				days[wd] = true
+			}
+		}
+	}
+	return days
+}
+
+// newScheduleEntry builds the entry of a creative the way the poster
+// footprint answer reads assignments: the creative's own devices, days, slots
+// and flight win, the campaign's fill in the rest. ok is false for creatives
+// or campaigns whose status does not run.
+func newScheduleEntry(creative, campaign map[string]any, hosts []string, loc *time.Location) (scheduleEntry, bool) {
+	if scheduleSkipStatusRe.MatchString(normalizeCampaignStatus(anyString(creative, "status"))) ||
+		scheduleSkipStatusRe.MatchString(normalizeCampaignStatus(anyString(campaign, "status"))) {
+		return scheduleEntry{}, false
+	}
+	e := scheduleEntry{
+		Creative:   anyString(creative, "name", "file_name", "id"),
+		CampaignID: anyString(creative, "campaign_id", "campaignId"),
+		Hosts:      map[string]bool{},
+	}
+	if e.CampaignID == "" {
+		e.CampaignID = anyString(campaign, "id")
+	}
+	e.Campaign = anyString(campaign, "name")
+	for _, h := range hosts {
+		e.Hosts[strings.ToLower(strings.TrimSpace(h))] = true
+	}
+	e.Days = scheduleWeekdays(creative["selected_days"])
+	if len(e.Days) == 0 && campaign != nil {
+		e.Days = scheduleWeekdays(campaign["selected_days"])
+	}
+	e.Slots = stringList(creative["time_slots"])
+	if len(e.Slots) == 0 && campaign != nil {
+		e.Slots = stringList(campaign["time_slots"])
+	}
+	startKeys := []string{"start_date", "flight_start", "starts_at", "start_at"}
+	endKeys := []string{"end_date", "flight_end", "ends_at", "end_at"}
+	var ok bool
+	if e.Start, ok = campaignDay(creative, loc, startKeys...); !ok && campaign != nil {
+		e.Start, _ = campaignDay(campaign, loc, startKeys...)
+	}
+	if e.End, ok = campaignDay(creative, loc, endKeys...); !ok && campaign != nil {
+		e.End, _ = campaignDay(campaign, loc, endKeys...)
+	}
+	return e, true
+}
+
+// scheduleFetch is what was read for one member or campaign; label names it
+// in a failure note.
+type scheduleFetch struct {
+	label   string
+	entries []scheduleEntry
+	steps   []models.Step
+	err     error
+}
+
+// deviceCreativesPath is the per-device schedule endpoint, used when the
+// gateway's catalog lists it.
+func deviceCreativesPath(host string) string {
+	return "/ads/devices/" + urlEscape(host) + "/creatives"
+}
+
+// fetchDeviceSchedules reads each host's creatives from the per-device
+// endpoint. Days, slots and flight a creative row leaves open come from its
+// campaign.
+func (c *ChatService) fetchDeviceSchedules(ctx context.Context, hosts []string, loc *time.Location) ([]scheduleFetch, []models.Step) {
+	rows := make([][]map[string]any, len(hosts))
+	fetched := make([]scheduleFetch, len(hosts))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, scheduleConcurrency)
+	for i, h := range hosts {
+		wg.Add(1)
+		go func(i int, h string) {
+			defer wg.Done()
+			defer recoverInto("device schedule fetch", func(err error) { fetched[i] = scheduleFetch{label: "the creatives of " + h, err: err} })
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			r, st, _, err := c.fetchListing(ctx, deviceCreativesPath(h), "adsDeviceCreatives", c.limits().ListPageSize, 1)
+			rows[i], fetched[i] = r, scheduleFetch{label: "the creatives of " + h, steps: st, err: err}
+		}(i, h)
+	}
+	wg.Wait()
+
+	var ids []string
+	seen := map[string]bool{}
+	for _, r := range rows {
+		for _, m := range r {
+			if id := anyString(m, "campaign_id", "campaignId"); id != "" && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Strings(ids)
+	if len(ids) > scheduleMaxCampaigns {
+		ids = ids[:scheduleMaxCampaigns]
+	}
+	records := make([]map[string]any, len(ids))
+	steps := make([]models.Step, len(ids))
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			defer recoverInto("schedule campaign fetch", func(error) {})
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			records[i], steps[i], _ = c.fetchCampaign(ctx, id)
+		}(i, id)
+	}
+	wg.Wait()
+	campaigns := map[string]map[string]any{}
+	for i, id := range ids {
+		campaigns[id] = records[i]
+	}
+
+	for i, h := range hosts {
+		for _, m := range rows[i] {
+			if e, ok := newScheduleEntry(m, campaigns[anyString(m, "campaign_id", "campaignId")], []string{h}, loc); ok {
+				fetched[i].entries = append(fetched[i].entries, e)
+			}
+		}
+	}
+	return fetched, steps
+}
+
+// assignedHosts is the hosts of a creative's or campaign's device assignment.
+func assignedHosts(m map[string]any) []string {
+	var hosts []string
+	for _, d := range parseAssignedDevices(m) {
+		hosts = append(hosts, d.Host)
+	}
+	return hosts
+}
+
+// fetchTargetingSchedules reads the schedule from campaign targeting: the
+// campaigns whose flight overlaps the window, each with its creatives and
+// devices. capped reports campaigns left out by scheduleMaxCampaigns.
+func (c *ChatService) fetchTargetingSchedules(ctx context.Context, first, last time.Time, loc *time.Location) ([]scheduleFetch, []models.Step, int, error) {
+	rows, steps, _, err := c.fetchListing(ctx, "/ads/campaigns", "adsCampaigns", c.limits().ListPageSize, c.limits().MetricsMaxPages)
+	if err != nil {
+		return nil, steps, 0, err
+	}
+	var campaigns []map[string]any
+	for _, m := range rows {
+		if anyString(m, "id") == "" || scheduleSkipStatusRe.MatchString(normalizeCampaignStatus(anyString(m, "status"))) {
+			continue
+		}
+		if start, ok := campaignDay(m, loc, "start_date", "flight_start", "starts_at", "start_at"); ok && start.After(last) {
+			continue
+		}
+		if end, ok := campaignDay(m, loc, "end_date", "flight_end", "ends_at", "end_at"); ok && end.Before(first) {
+			continue
+		}
+		campaigns = append(campaigns, m)
+	}
+	sort.Slice(campaigns, func(i, j int) bool { return anyString(campaigns[i], "id") < anyString(campaigns[j], "id") })
+	left := 0
+	if len(campaigns) > scheduleMaxCampaigns {
+		left = len(campaigns) - scheduleMaxCampaigns
+		campaigns = campaigns[:scheduleMaxCampaigns]
+	}
+
+	fetched := make([]scheduleFetch, len(campaigns))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, scheduleConcurrency)
+	for i, campaign := range campaigns {
+		wg.Add(1)
+		go func(i int, campaign map[string]any) {
+			defer wg.Done()
+			id := anyString(campaign, "id")
+			label := "campaign " + id
+			if name := anyString(campaign, "name"); name != "" {
+				label = "campaign " + name
+			}
+			defer recoverInto("schedule campaign fetch", func(err error) { fetched[i] = scheduleFetch{label: label, err: err} })
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			path := "/ads/creatives/campaign/" + urlEscape(id)
+			creatives, st, _, err := c.fetchListing(ctx, path, "adsCreativesByCampaign", c.limits().ListPageSize, 1)
+			f := scheduleFetch{label: label, steps: st, err: err}
+			if err != nil {
+				fetched[i] = f
+				return
+			}
+			// Creatives without their own assignment run on the campaign's
+			// devices, read from its targeting or the devices endpoint.
+			hosts := assignedHosts(campaign)
+			for _, m := range creatives {
+				if len(hosts) > 0 || len(parseAssignedDevices(m)) > 0 {
+					continue
+				}
+				devs, dst, found, derr := c.fetchCampaignDevices(ctx, id)
+				f.steps = append(f.steps, dst...)
+				if derr != nil {
+					f.err = derr
+					fetched[i] = f
+					return
+				}
+				if found {
+					hosts = devs
+				}
+				break
+			}
+			for _, m := range creatives {
+				assigned := hosts
+				if own := assignedHosts(m); len(own) > 0 {
+					assigned = own
+				}
+				if e, ok := newScheduleEntry(m, campaign, assigned, loc); ok && len(e.Hosts) > 0 {
+					f.entries = append(f.entries, e)
+				}
+			}
+			fetched[i] = f
+		}(i, campaign)
+	}
+	wg.Wait()
+	return fetched, steps, left, nil
+}
+
+// handleDeviceSchedule answers "what's scheduled to play at the stadium
+// kiosks this weekend": the creatives assigned to a device set for each day
+// of a future window, from the per-device creatives endpoint when the
+// gateway has one and from campaign targeting otherwise. It is the schedule,
+// not play history.
+func (c *ChatService) handleDeviceSchedule(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !isDeviceScheduleIntent(req.Message, msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	if c.Gateway == nil {
+		return reply(models.ChatResponse{Answer: "Tool gateway is not configured."})
+	}
+
+	set, steps, failure := c.resolveScheduleDevices(ctx, req, msgLower)
+	if failure != "" {
+		return reply(models.ChatResponse{Answer: failure, Steps: steps})
+	}
+	maxHosts := c.MaxPatternHosts
+	if maxHosts <= 0 {
+		maxHosts = 30
+	}
+	if len(set.Hosts) > maxHosts {
+		set.Hosts = set.Hosts[:maxHosts]
+	}
+
+	loc := requestLocation(req)
+	from, to, window, ok := futureWindow(req.Message, time.Now(), loc)
+	windowNote := ""
+	if !ok {
+		local := time.Now().In(loc)
+		from = time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+		to, window = from.AddDate(0, 0, 7), "the next 7 days"
+		windowNote = "No window was given, so this covers the next 7 days."
+	}
+	var days []time.Time
+	for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+		days = append(days, time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC))
+	}
+	if len(days) == 0 {
+		return reply(models.ChatResponse{Answer: "That window has no days left to schedule.", Steps: steps})
+	}
+
+	var (
+		fetched []scheduleFetch
+		failed  partialFailures
+		left    int
+		source  = "campaign targeting"
+	)
+	if c.Catalog != nil && c.Catalog.IsAllowed(ctx, "GET", deviceCreativesPath(set.Hosts[0])) {
+		source = "each device's assigned creatives"
+		var campaignSteps []models.Step
+		fetched, campaignSteps = c.fetchDeviceSchedules(ctx, set.Hosts, loc)
+		for _, f := range fetched {
+			steps = append(steps, f.steps...)
+			if f.err != nil {
+				failed.add(f.label, steps, len(steps)-1, "they are left out")
+			}
+		}
+		steps = append(steps, campaignSteps...)
+	} else {
+		var listSteps []models.Step
+		var err error
+		fetched, listSteps, left, err = c.fetchTargetingSchedules(ctx, days[0], days[len(days)-1], loc)
+		steps = append(steps, listSteps...)
+		if err != nil {
+			return reply(models.ChatResponse{Answer: "Failed to list campaigns: " + err.Error(), Steps: steps})
+		}
+		for _, f := range fetched {
+			steps = append(steps, f.steps...)
+			if f.err != nil {
+				failed.add(f.label+"'s creatives or devices", steps, len(steps)-1, "they are left out")
+			}
+		}
+	}
+
+	members := map[string]bool{}
+	for _, h := range set.Hosts {
+		members[h] = true
+	}
+	scheduled := map[string]bool{}
+	data := &models.DeviceSchedule{Scope: set.Label, Window: window, From: from, To: to, Devices: set.Hosts}
+	lines := []string{}
+	for i, day := range days {
+		sd := models.ScheduleDay{Date: day.Format("2006-01-02"), Items: []models.ScheduleItem{}}
+		for _, f := range fetched {
+			for _, e := range f.entries {
+				if !e.runsOn(day) {
+					continue
+				}
+				var hosts []string
+				for h := range e.Hosts {
+					if members[h] {
+						hosts = append(hosts, h)
+					}
+				}
+				if len(hosts) == 0 {
+					continue
+				}
+				sort.Strings(hosts)
+				for _, h := range hosts {
+					scheduled[h] = true
+				}
+				sd.Items = append(sd.Items, models.ScheduleItem{Creative: e.Creative, CampaignID: e.CampaignID, Campaign: e.Campaign, Slots: e.Slots, Hosts: hosts})
+			}
+		}
+		sort.SliceStable(sd.Items, func(a, b int) bool {
+			if len(sd.Items[a].Hosts) != len(sd.Items[b].Hosts) {
+				return len(sd.Items[a].Hosts) > len(sd.Items[b].Hosts)
+			}
+			return sd.Items[a].Creative < sd.Items[b].Creative
+		})
+		data.Days = append(data.Days, sd)
+		if i >= scheduleMaxDays {
+			continue
+		}
+		lines = append(lines, day.Format("Mon Jan 2")+":")
+		if len(sd.Items) == 0 {
+			lines = append(lines, "- nothing scheduled")
+		}
+		for j, it := range sd.Items {
+			if j >= c.limits().DisplayTopN {
+				lines = append(lines, fmt.Sprintf("- +%d more", len(sd.Items)-j))
+				break
+			}
+			lines = append(lines, "- "+scheduleItemLine(it, len(set.Hosts)))
+		}
+	}
+	if len(days) > scheduleMaxDays {
+		lines = append(lines, fmt.Sprintf("(%d more days are in the data but not listed.)", len(days)-scheduleMaxDays))
+	}
+	for _, h := range set.Hosts {
+		if !scheduled[h] {
+			data.Unscheduled = append(data.Unscheduled, h)
+		}
+	}
+
+	head := fmt.Sprintf("Scheduled on %s %s:", set.Label, windowPhrase(window))
+	if len(scheduled) == 0 {
+		lines = []string{fmt.Sprintf("Nothing is scheduled on %s %s.", set.Label, windowPhrase(window))}
+	} else if len(data.Unscheduled) > 0 {
+		shown := data.Unscheduled
+		if len(shown) > c.limits().DisplayTopN {
+			shown = append(shown[:c.limits().DisplayTopN:c.limits().DisplayTopN], "…")
+		}
+		lines = append(lines, fmt.Sprintf("Nothing scheduled %s on %d device(s): %s.", windowPhrase(window), len(data.Unscheduled), strings.Join(shown, ", ")))
+	}
+	if len(scheduled) > 0 {
+		lines = append([]string{head}, lines...)
+	}
+	if set.Total > len(set.Hosts) {
+		lines = append(lines, fmt.Sprintf("Only the first %d of %d devices were looked at.", len(set.Hosts), set.Total))
+	}
+	if left > 0 {
+		lines = append(lines, fmt.Sprintf("Only %d campaigns running in the window were read; %d more were not.", scheduleMaxCampaigns, left))
+	}
+	if windowNote != "" {
+		lines = append(lines, windowNote)
+	}
+	lines = append(lines, fmt.Sprintf("This is the schedule from %s, not play history; ask for POP to see what actually played.", source))
+	answer := failed.note(strings.Join(lines, "\n"))
+	return reply(models.ChatResponse{Answer: answer, Steps: steps, Meta: failed.meta(nil), Data: &models.ChatData{Schedule: data}})
+}
+
+// scheduleItemLine renders one creative of a schedule day; the device count
+// is left out for a single device.
+func scheduleItemLine(it models.ScheduleItem, devices int) string {
+	line := it.Creative
+	switch {
+	case it.Campaign != "":
+		line += " (campaign " + it.Campaign + ")"
+	case it.CampaignID != "":
+		line += " (campaign " + it.CampaignID + ")"
+	}
+	if len(it.Slots) > 0 {
+		line += " — " + strings.Join(it.Slots, ", ")
+	} else {
+		line += " — all day"
+	}
+	if devices > 1 {
+		line += fmt.Sprintf(" — %d of %d devices", len(it.Hosts), devices)
+	}
+	return line
+}
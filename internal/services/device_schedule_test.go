@@ -0,0 +1,108 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+// scheduleRoutes is campaign targeting with one running campaign whose
+// creative is assigned to Briggs Lobby, and one ended campaign.
+var scheduleRoutes = map[string]string{
+	"/ads/campaigns": `{"data":[
+		{"id":"camp-1","name":"Fall Promo","status":"active"},
+		{"id":"camp-2","name":"Summer","status":"ended"}
+	]}`,
+	"/ads/creatives/campaign/camp-1": `{"data":[
+		{"name":"fall.png","campaign_id":"camp-1","devices":["moco-brt-briggs-001"],"time_slots":["08:00-12:00"]}
+	]}`,
+	"/ads/creatives/campaign/camp-2": `{"data":[
+		{"name":"summer.png","campaign_id":"camp-2","devices":["moco-brt-briggs-001","moco-brt-briggs-002"]}
+	]}`,
+}
+
+func TestDeviceSchedule(t *testing.T) {
+	cases := []struct {
+		name       string
+		msg        string
+		maxHosts   int
+		want       []string
+		devices    int
+		unschedule []string
+		// items is the creatives listed on each day.
+		items int
+	}{
+		{
+			name:       "grouped by day",
+			msg:        "what's scheduled to play on moco-brt-briggs-001 and moco-brt-briggs-002 for the next 3 days",
+			want:       []string{"Scheduled on 2 kiosks", "fall.png (campaign Fall Promo) — 08:00-12:00 — 1 of 2 devices", "Nothing scheduled", "moco-brt-briggs-002", "not play history"},
+			devices:    2,
+			unschedule: []string{"moco-brt-briggs-002"},
+			items:      1,
+		},
+		{
+			name:     "member cap",
+			msg:      "what's scheduled to play on moco-brt-briggs-001 and moco-brt-briggs-002 for the next 3 days",
+			maxHosts: 1,
+			want:     []string{"fall.png (campaign Fall Promo) — 08:00-12:00", "Only the first 1 of 2 devices"},
+			devices:  1,
+			items:    1,
+		},
+		{
+			name:       "nothing scheduled",
+			msg:        "what will play on kc-kcmo-union-003 for the next 3 days",
+			want:       []string{"Nothing is scheduled on kiosk kc-kcmo-union-003"},
+			devices:    1,
+			unschedule: []string{"kc-kcmo-union-003"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Routes: scheduleRoutes})
+			c := newTestChat(g)
+			c.MaxPatternHosts = tc.maxHosts
+			resp := chatOnce(t, c, tc.msg)
+			for _, w := range tc.want {
+				if !strings.Contains(resp.Answer, w) {
+					t.Errorf("answer %q lacks %q", resp.Answer, w)
+				}
+			}
+			if strings.Contains(resp.Answer, "summer.png") {
+				t.Errorf("answer %q lists an ended campaign", resp.Answer)
+			}
+			if resp.Data == nil || resp.Data.Schedule == nil {
+				t.Fatal("no data.schedule")
+			}
+			s := resp.Data.Schedule
+			if len(s.Devices) != tc.devices || len(s.Days) != 3 || strings.Join(s.Unscheduled, ",") != strings.Join(tc.unschedule, ",") {
+				t.Errorf("schedule devices=%v days=%d unscheduled=%v", s.Devices, len(s.Days), s.Unscheduled)
+			}
+			for _, d := range s.Days {
+				if len(d.Items) != tc.items {
+					t.Errorf("%s: %d items, want %d", d.Date, len(d.Items), tc.items)
+				}
+			}
+			if n := len(g.Calls("/ads/creatives/campaign/camp-2")); n != 0 {
+				t.Errorf("%d calls for the ended campaign's creatives", n)
+			}
+			if len(resp.Steps) == 0 {
+				t.Error("no steps")
+			}
+		})
+	}
+}
+
+func TestDeviceScheduleIntent(t *testing.T) {
+	cases := map[string]bool{
+		"what's scheduled to play at the stadium kiosks this weekend": true,
+		"what will run on moco-brt-briggs-001 tomorrow":               true,
+		"schedule for the union station screens next week":            true,
+		"what campaigns are scheduled":                                false,
+		"what played on moco-brt-briggs-001 yesterday":                false,
+		"create a schedule for the stadium kiosks":                    false,
+	}
+	for msg, want := range cases {
+		if got := isDeviceScheduleIntent(msg, strings.ToLower(msg)); got != want {
+			t.Errorf("isDeviceScheduleIntent(%q) = %v, want %v", msg, got, want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// drillDownMaxAge is how long a ranking can be drilled into; after that the
+// numbers may have moved and "#3" may no longer be what the user saw.
+const drillDownMaxAge = 30 * time.Minute
+
+// listRanking is what a top-N answer was ranked over, so a drill-down asks
+// the detail handlers about the same scope.
+type listRanking struct {
+	// Scope is appended to the detail question ("in brt last week").
+	Scope string
+	// From and To bound a kiosk-wise breakdown's POP window.
+	From, To time.Time
+}
+
+// rememberRanking records a top-N answer's entities for "tell me more
+// about #3".
+func (c *ChatService) rememberRanking(conversationID, kind string, items []listedEntity, ranking listRanking) {
+	c.storeList(conversationID, kind, items, &ranking)
+}
+
+// drillDownRe matches a request for more on one entry of a list: "more about
+// number 3", "details on the third one", "drill into #2".
+var drillDownRe = regexp.MustCompile(`\b(?:more|details?|info|information|tell\s+me\s+about|drill|dig|expand)\b`)
+
+// drillKindFits reports whether the noun of a reference ("the third kiosk")
+// can mean an entry of a list of kind.
+func drillKindFits(refKind, kind string) bool {
+	return refKind == "" || refKind == kind || (refKind == listKindDevice && kind == listKindKiosk)
+}
+
+// handleDrillDown answers "tell me more about #3" after a top-N answer with
+// the detail of the entity at that rank: poster analytics over the ranking's
+// scope, a device's details and latest telemetry, or a kiosk's POP over the
+// breakdown's window. Rankings older than drillDownMaxAge are not used.
+func (c *ChatService) handleDrillDown(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	conversationID := strings.TrimSpace(req.ConversationID)
+	msgLower := strings.ToLower(strings.TrimSpace(req.Message))
+	if conversationID == "" || !drillDownRe.MatchString(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	ref, found := findListReference(msgLower)
+	if !found || ref.Index == 0 {
+		return models.ChatResponse{}, false, nil
+	}
+	st := c.getConversationState(conversationID)
+	if st == nil || st.LastList == nil || st.LastList.Ranking == nil || !drillKindFits(ref.Kind, st.LastList.Kind) {
+		return models.ChatResponse{}, false, nil
+	}
+	list := st.LastList
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	if age := time.Since(list.At); age > drillDownMaxAge {
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("That %s ranking is %d minutes old and may have changed. Ask for the top list again, then pick a number from it.", list.Kind, int(age.Minutes()))})
+	}
+	idx := ref.Index
+	if idx == -1 {
+		idx = len(list.Items)
+	}
+	if idx < 1 || idx > len(list.Items) {
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("The ranking had %d %ss; pick a number from 1 to %d.", len(list.Items), list.Kind, len(list.Items))})
+	}
+	e := list.Items[idx-1]
+	c.rememberEntity(conversationID, list.Kind, e)
+	if c.Gateway == nil {
+		return reply(models.ChatResponse{Answer: "Tool gateway is not configured."})
+	}
+
+	var resp models.ChatResponse
+	switch list.Kind {
+	case listKindPoster:
+		resp = c.drillPoster(ctx, req, e, list.Ranking)
+	case listKindDevice:
+		resp = c.drillDevice(ctx, req, e)
+	case listKindKiosk:
+		resp = c.drillKioskPop(ctx, e, list.Ranking)
+	default:
+		return models.ChatResponse{}, false, nil
+	}
+	resp.Answer = fmt.Sprintf("#%d = %s\n%s", idx, firstNonEmpty(e.Name, e.ID), resp.Answer)
+	return reply(resp)
+}
+
+// drillPoster answers with the poster's analytics over the ranking's scope:
+// by id when the ranking keyed posters by UUID, else by name.
+func (c *ChatService) drillPoster(ctx context.Context, req models.ChatRequest, e listedEntity, r *listRanking) models.ChatResponse {
+	sub := req
+	h := c.handlePosterPlayCount
+	if looksLikeUUID(e.ID) {
+		sub.Message = strings.TrimSpace("poster analytics " + e.ID + " " + r.Scope)
+		h = c.handlePosterAnalyticsByID
+	} else {
+		sub.Message = strings.TrimSpace("play count for poster " + firstNonEmpty(e.Name, e.ID) + " " + r.Scope)
+	}
+	resp, handled, err := h(ctx, sub, nil)
+	if err != nil || !handled {
+		return models.ChatResponse{Answer: fmt.Sprintf("I couldn't fetch analytics for poster %s.", firstNonEmpty(e.Name, e.ID)), Steps: resp.Steps}
+	}
+	return resp
+}
+
+// drillDevice answers with the device's details followed by its latest
+// telemetry.
+func (c *ChatService) drillDevice(ctx context.Context, req models.ChatRequest, e listedEntity) models.ChatResponse {
+	var out models.ChatResponse
+	parts := make([]string, 0, 2)
+	for _, q := range []struct {
+		msg string
+		h   chatHandler
+	}{
+		{"device details for " + e.ID, c.handleDeviceDetails},
+		{"latest telemetry for " + e.ID, c.handleDeviceTelemetry},
+	} {
+		sub := req
+		sub.Message = q.msg
+		resp, handled, err := q.h(ctx, sub, nil)
+		if !handled {
+			continue
+		}
+		if err != nil && strings.TrimSpace(resp.Answer) == "" {
+			resp.Answer = "Failed: " + err.Error()
+		}
+		parts = append(parts, strings.TrimSpace(resp.Answer))
+		out.Meta = mergeResponseMeta(out.Meta, resp.Meta, len(out.Steps))
+		out.Steps = append(out.Steps, resp.Steps...)
+		out.Data = mergeChatData(out.Data, resp.Data)
+	}
+	if len(parts) == 0 {
+		out.Answer = fmt.Sprintf("I couldn't fetch details for device %s.", e.ID)
+		return out
+	}
+	out.Answer = strings.Join(parts, "\n\n")
+	return out
+}
+
+// drillKioskPop answers with the kiosk's plays over the breakdown's window
+// and the posters it played most.
+func (c *ChatService) drillKioskPop(ctx context.Context, e listedEntity, r *listRanking) models.ChatResponse {
+	kiosk := firstNonEmpty(e.ID, e.Name)
+	window := fmt.Sprintf("%s to %s", r.From.Format("2006-01-02"), r.To.Format("2006-01-02"))
+	rows, steps, pager, err := c.fetchPopRows(ctx, "kiosk_name="+urlEscape(kiosk)+"&from="+urlEscape(r.From.Format(time.RFC3339))+"&to="+urlEscape(r.To.Format(time.RFC3339)))
+	if err != nil {
+		return models.ChatResponse{Answer: "Failed to fetch POP data: " + err.Error(), Steps: steps}
+	}
+	if len(rows) == 0 {
+		return models.ChatResponse{Answer: fmt.Sprintf("No POP rows found for kiosk %s from %s.", kiosk, window), Steps: steps}
+	}
+	plays := int64(0)
+	for _, it := range rows {
+		plays += it.PlayCount
+	}
+	ranked := rankPopRows(rows, "poster", "plays")
+	lines := []string{fmt.Sprintf("POP for kiosk %s from %s: %s plays across %d posters.", kiosk, window, formatThousands(plays), len(ranked))}
+	for i, rk := range ranked {
+		if i >= c.limits().DisplayTopN {
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s — %s plays", i+1, rk.Key, formatThousands(rk.Value)))
+	}
+	if len(ranked) > c.limits().DisplayTopN {
+		lines = append(lines, fmt.Sprintf("Showing the top %d of %d posters.", c.limits().DisplayTopN, len(ranked)))
+	}
+	return models.ChatResponse{Answer: pager.note(strings.Join(lines, "\n")), Steps: steps, Meta: pager.meta()}
+}
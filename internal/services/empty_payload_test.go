@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"openai-agent-service/internal/models"
+)
+
+// emptyPayloads are the bodies a gateway has sent for "nothing": every
+// listing shape with no rows, and bodies with nothing in them at all.
+var emptyPayloads = []string{
+	`{}`,
+	`{"data":[]}`,
+	`{"data":{}}`,
+	`{"data":null,"items":null}`,
+	`{"items":[],"total":0,"page":1,"page_size":200}`,
+	`{"data":[],"pagination":{"has_more":false,"total":0}}`,
+	`[]`,
+	`null`,
+	``,
+}
+
+// Each gateway-backed deterministic handler, given an empty payload on every
+// call, must still answer the question itself, without panicking.
+func TestHandlersSurviveEmptyGatewayPayloads(t *testing.T) {
+	cases := []struct{ handler, msg string }{
+		{"handleCampaignDeviceDiff", "which devices were added to the bet 365 campaign"},
+		{"handleChurn", "which posters stopped playing in brt"},
+		{"handlePopExport", "export all pop rows for poster Bet 365 in brt"},
+		{"handlePeriodComparison", "compare plays for poster Bet 365 in brt this week vs last week"},
+		{"handleActivePosters", "how many posters played in brt yesterday"},
+		{"handlePosterIDLookup", "poster id of Bet 365"},
+		{"handleCreativeReuse", "creatives used in more than one campaign"},
+		{"handleTelemetryCoverage", "telemetry coverage in moco"},
+		{"handleDeviceSchedule", "schedule for moco-brt-briggs-001"},
+		{"handleHostPatternSummary", "summary of moco-brt-* hosts"},
+		{"handlePosterCoPlay", "what else plays with Bet 365 in brt"},
+		{"handleHourlyDistribution", "hourly heatmap for moco-brt-briggs-001 today"},
+		{"handlePopPattern", "hourly distribution for poster Bet 365 in brt"},
+		{"handlePosterFootprint", "where is poster Bet 365 running"},
+		{"handleCampaignBudget", "budget for campaign " + testCampaignID},
+		{"handleCampaignPacing", "pacing for campaign " + testCampaignID},
+		{"handleTopPostersFromCity", "top posters in moco"},
+		{"handleTopDevicesFromCity", "top devices in moco"},
+		{"handlePosterAnalyticsByID", "poster analytics for " + testBetID},
+		{"handlePosterMonthData", "pop for poster Bet 365 for October 2024 in brt"},
+		{"handlePosterPlayCount", "play count for poster Bet 365 in brt"},
+		{"handlePosterPlayCount", "play count for poster Bet 365 in moco kiosk wise"},
+		{"handlePopForPosterID", "pop for poster " + testBetID},
+		{"handleKioskPosterPlayCount", "Briggs Lobby has played Bet 365 poster on kiosk"},
+		{"handleMetricsLatestByLocationDetails", "cpu metrics for kiosks in moco"},
+		{"handleKioskCountFromCity", "how many kiosks in moco"},
+		{"handlePopByHostForDate", "pop for moco-brt-briggs-001 on October 2 2024"},
+		{"handlePopStatsGeneric", "pop stats by region in moco"},
+		{"handleVenueLeaderboard", "top venues by plays"},
+		{"handleVenueDevices", "show devices for venue 12"},
+		{"handleDeviceVenues", "venues for moco-brt-briggs-001"},
+		{"handleVenueSearchList", "list venues"},
+		{"handleLowUptimeDevices", "devices with low uptime in moco"},
+		{"handleDeviceDetails", "details for device moco-brt-briggs-001"},
+		{"handleTelemetryBaseline", "what is normal cpu for moco-brt-briggs-001"},
+		{"handleDeviceTelemetry", "cpu of moco-brt-briggs-001"},
+		{"handleCreativesByStatus", "pending creatives"},
+		{"handleCampaignCreatives", "show creatives for campaign " + testCampaignID},
+		{"handlePosterDetails", "details of poster bet_365_fall"},
+	}
+	for _, body := range emptyPayloads {
+		body := body
+		t.Run("body="+body, func(t *testing.T) {
+			for _, tc := range cases {
+				// The region list stays real so the scope in each question
+				// resolves and the handler gets as far as the data.
+				g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Body: &body})
+				c := newTestChat(g)
+				resp, err := c.Chat(context.Background(), "test-key", models.ChatRequest{Message: tc.msg})
+				if errors.Is(err, ErrInternal) {
+					t.Errorf("%s panicked on %q: %v", tc.handler, tc.msg, err)
+					continue
+				}
+				if got := answeredBy(c); got != tc.handler {
+					t.Errorf("%q answered by %q, want %s", tc.msg, got, tc.handler)
+				}
+				if strings.TrimSpace(answerBody(resp.Answer)) == "" {
+					t.Errorf("%s gave an empty answer to %q", tc.handler, tc.msg)
+				}
+			}
+		})
+	}
+}
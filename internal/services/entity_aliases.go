@@ -0,0 +1,268 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"openai-agent-service/internal/models"
+)
+
+// EntityAliasStore persists each API key's nicknames for devices and
+// posters; implemented by store.PostgresStore.
+type EntityAliasStore interface {
+	SetEntityAlias(ctx context.Context, a models.EntityAlias) (models.EntityAlias, error)
+	ListEntityAliases(ctx context.Context, ownerKey string) ([]models.EntityAlias, error)
+	DeleteEntityAlias(ctx context.Context, ownerKey, alias string) error
+}
+
+const (
+	aliasKindDevice = "device"
+	aliasKindPoster = "poster"
+	// maxEntityAliasLen caps an alias, in characters.
+	maxEntityAliasLen = 60
+)
+
+var (
+	aliasRememberRe = regexp.MustCompile(`(?i)^(?:please\s+)?remember\s+(?:that\s+)?["'“‘]?(.+?)["'”’]?\s+(?:means|refers\s+to|stands\s+for|is\s+short\s+for)\s+(?:the\s+)?(?:(kiosk|device|host|screen|poster)\s+)?["'“‘]?(.+?)["'”’]?[.!]*$`)
+	aliasCallRe     = regexp.MustCompile(`(?i)^(?:please\s+)?(?:call|name|nickname)\s+(?:the\s+)?(?:(kiosk|device|host|screen|poster)\s+)?(\S+)\s+(?:as\s+)?["'“‘](.+?)["'”’][.!]*$`)
+	aliasListRe     = regexp.MustCompile(`(?i)^(?:please\s+)?(?:(?:list|show)\s+(?:me\s+)?(?:my|all(?:\s+my)?|the)\s+aliases|what\s+are\s+my\s+aliases|my\s+aliases)[.!?]*$`)
+	aliasForgetRe   = regexp.MustCompile(`(?i)^(?:please\s+)?(?:forget|delete|remove)\s+(?:the\s+|my\s+)?alias\s+["'“‘]?(.+?)["'”’]?[.!]*$`)
+)
+
+// isEntityAliasCommand reports whether msg creates, lists or deletes an
+// alias; such messages are never rewritten through aliases.
+func isEntityAliasCommand(msg string) bool {
+	msg = strings.TrimSpace(msg)
+	return aliasRememberRe.MatchString(msg) || aliasCallRe.MatchString(msg) || aliasListRe.MatchString(msg) || aliasForgetRe.MatchString(msg)
+}
+
+// normalizeAlias lower-cases an alias, collapses its spaces and drops quotes
+// and a leading "the", so "The Stadium  Screen" and "stadium screen" are
+// the same alias.
+func normalizeAlias(s string) string {
+	s = strings.ToLower(strings.Join(strings.Fields(s), " "))
+	s = strings.Trim(s, `"'“”‘’.!? `)
+	return strings.TrimPrefix(s, "the ")
+}
+
+// checkAlias returns why alias cannot be used, or "" when it can. Aliases
+// may not look like a poster id or a host, and may not be the name or
+// suffix of a device in the inventory, so they never shadow a real entity.
+func (c *ChatService) checkAlias(ctx context.Context, alias string) (string, []models.Step) {
+	n := utf8.RuneCountInString(alias)
+	switch {
+	case n < 3:
+		return "Aliases need at least 3 characters.", nil
+	case n > maxEntityAliasLen:
+		return fmt.Sprintf("Aliases are capped at %d characters and this one has %d.", maxEntityAliasLen, n), nil
+	case strings.IndexFunc(alias, unicode.IsLetter) < 0:
+		return "Aliases need at least one letter.", nil
+	}
+	first, _ := utf8.DecodeRuneInString(alias)
+	last, _ := utf8.DecodeLastRuneInString(alias)
+	if !unicode.IsLetter(first) && !unicode.IsDigit(first) || !unicode.IsLetter(last) && !unicode.IsDigit(last) {
+		return "Aliases must start and end with a letter or digit.", nil
+	}
+	for _, f := range strings.Fields(alias) {
+		if looksLikeUUID(f) {
+			return fmt.Sprintf("'%s' looks like a poster id, so it can't be an alias.", alias), nil
+		}
+	}
+	if tokens := detectHostTokens(alias); len(tokens) > 0 {
+		return fmt.Sprintf("'%s' looks like a host name, so it can't be an alias.", tokens[0]), nil
+	}
+	if strings.Contains(alias, " ") || c.Gateway == nil {
+		return "", nil
+	}
+	inv, steps := c.deviceHosts(ctx, "", "")
+	for _, d := range inv {
+		if d.Host == alias || strings.HasSuffix(d.Host, "-"+alias) {
+			return fmt.Sprintf("'%s' already names device %s, so it can't be an alias.", alias, d.Host), steps
+		}
+	}
+	return "", steps
+}
+
+// handleEntityAliases manages the caller's nicknames: "remember that
+// 'stadium screen' means moco-kc-stadium-003", "call poster <id> 'big
+// pepsi'", "list my aliases" and "forget the alias stadium screen". Using an
+// alias in a question is handled by resolveEntityAliases.
+func (c *ChatService) handleEntityAliases(ctx context.Context, ownerKey string, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msg := strings.TrimSpace(req.Message)
+	remember := aliasRememberRe.FindStringSubmatch(msg)
+	call := aliasCallRe.FindStringSubmatch(msg)
+	list := aliasListRe.MatchString(msg)
+	forget := aliasForgetRe.FindStringSubmatch(msg)
+	if remember == nil && call == nil && !list && forget == nil {
+		return models.ChatResponse{}, false, nil
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	if c.Aliases == nil {
+		return reply(models.ChatResponse{Answer: "Aliases are not configured."})
+	}
+
+	switch {
+	case list:
+		aliases, err := c.Aliases.ListEntityAliases(ctx, ownerKey)
+		if err != nil {
+			return reply(models.ChatResponse{Answer: "Failed to load your aliases: " + err.Error()})
+		}
+		if len(aliases) == 0 {
+			return reply(models.ChatResponse{Answer: "You have no aliases yet. Add one with \"remember that 'stadium screen' means moco-kc-stadium-003\"."})
+		}
+		lines := []string{fmt.Sprintf("Your aliases (%d):", len(aliases))}
+		for _, a := range aliases {
+			lines = append(lines, fmt.Sprintf("- %s → %s", a.Alias, aliasTargetLabel(a)))
+		}
+		return reply(models.ChatResponse{Answer: strings.Join(lines, "\n")})
+
+	case forget != nil:
+		alias := normalizeAlias(forget[1])
+		if err := c.Aliases.DeleteEntityAlias(ctx, ownerKey, alias); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return reply(models.ChatResponse{Answer: fmt.Sprintf("You have no alias '%s'; nothing was deleted. Say \"list my aliases\" to see them.", alias)})
+			}
+			return reply(models.ChatResponse{Answer: "Failed to delete the alias: " + err.Error()})
+		}
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("Deleted the alias '%s'.", alias)})
+	}
+
+	var alias, kindWord, target string
+	if remember != nil {
+		alias, kindWord, target = remember[1], remember[2], remember[3]
+	} else {
+		alias, kindWord, target = call[3], call[1], call[2]
+	}
+	alias = normalizeAlias(alias)
+	target = strings.Trim(strings.TrimSpace(target), `"'“”‘’`)
+	why, steps := c.checkAlias(ctx, alias)
+	if why != "" {
+		return reply(models.ChatResponse{Answer: why + " Nothing was saved.", Steps: steps})
+	}
+
+	kind := aliasKindDevice
+	switch strings.ToLower(kindWord) {
+	case "poster":
+		kind = aliasKindPoster
+	case "":
+		switch {
+		case looksLikeUUID(target):
+			kind = aliasKindPoster
+		case len(detectHostTokens(target)) == 0 || strings.Contains(target, " "):
+			return reply(models.ChatResponse{Answer: fmt.Sprintf("I can't tell whether '%s' is a device or a poster. Say \"remember that '%s' means kiosk <host>\" or \"... means poster <id or name>\".", target, alias), Steps: steps})
+		}
+	}
+	if kind == aliasKindPoster {
+		if looksLikeUUID(target) {
+			target = strings.ToLower(target)
+		}
+	} else {
+		target = strings.ToLower(target)
+		if c.Gateway != nil {
+			host, candidates, hostSteps := c.resolveShortHost(ctx, target, "", "")
+			steps = append(steps, hostSteps...)
+			if host == "" {
+				return reply(models.ChatResponse{Answer: fmt.Sprintf("'%s' matches several devices (%s); name the full host.", target, strings.Join(candidates, ", ")), Steps: steps})
+			}
+			target = host
+		}
+	}
+	if target == "" {
+		return reply(models.ChatResponse{Answer: "What should the alias point at? For example: \"remember that 'stadium screen' means moco-kc-stadium-003\".", Steps: steps})
+	}
+
+	saved, err := c.Aliases.SetEntityAlias(ctx, models.EntityAlias{OwnerKey: ownerKey, Alias: alias, Kind: kind, Target: target})
+	if err != nil {
+		return reply(models.ChatResponse{Answer: "Failed to save the alias: " + err.Error(), Steps: steps})
+	}
+	return reply(models.ChatResponse{Answer: fmt.Sprintf("Saved: '%s' now means %s.", saved.Alias, aliasTargetLabel(saved)), Steps: steps})
+}
+
+func aliasTargetLabel(a models.EntityAlias) string {
+	if a.Kind == aliasKindPoster {
+		return "poster " + a.Target
+	}
+	return a.Target
+}
+
+// resolveEntityAliases replaces the caller's aliases in msg with what they
+// stand for ("telemetry for the stadium screen" becomes "telemetry for
+// moco-kc-stadium-003"), so handlers see the real host or poster and no
+// fuzzy search is needed. It returns the rewritten message and a note for
+// the interpretation header, or msg and "" when no alias was used. Matches
+// are whole phrases, ignoring case and spacing; longer aliases win.
+func (c *ChatService) resolveEntityAliases(ctx context.Context, ownerKey, msg string) (string, string) {
+	if c.Aliases == nil || strings.TrimSpace(msg) == "" || isEntityAliasCommand(msg) {
+		return msg, ""
+	}
+	aliases, err := c.Aliases.ListEntityAliases(ctx, ownerKey)
+	if err != nil {
+		log.Printf("entity aliases: %v", err)
+		return msg, ""
+	}
+	if len(aliases) == 0 {
+		return msg, ""
+	}
+	sort.SliceStable(aliases, func(i, j int) bool { return len(aliases[i].Alias) > len(aliases[j].Alias) })
+	byAlias := make(map[string]models.EntityAlias, len(aliases))
+	alts := make([]string, 0, len(aliases))
+	for _, a := range aliases {
+		byAlias[a.Alias] = a
+		words := strings.Fields(a.Alias)
+		for i, w := range words {
+			words[i] = regexp.QuoteMeta(w)
+		}
+		alts = append(alts, strings.Join(words, `\s+`))
+	}
+	re, err := regexp.Compile(`(?i)(^|[^\p{L}\p{N}])(?:(poster)\s+)?(?:the\s+)?(` + strings.Join(alts, "|") + `)([^\p{L}\p{N}]|$)`)
+	if err != nil {
+		log.Printf("entity aliases: %v", err)
+		return msg, ""
+	}
+	matches := re.FindAllStringSubmatchIndex(msg, -1)
+	if matches == nil {
+		return msg, ""
+	}
+	var b strings.Builder
+	var notes []string
+	noted := map[string]bool{}
+	prev := 0
+	for _, m := range matches {
+		a, ok := byAlias[normalizeAlias(msg[m[6]:m[7]])]
+		if !ok {
+			continue
+		}
+		b.WriteString(msg[prev:m[3]])
+		if a.Kind == aliasKindPoster {
+			b.WriteString("poster " + a.Target)
+		} else {
+			if m[4] >= 0 {
+				b.WriteString(msg[m[4]:m[5]] + " ")
+			}
+			b.WriteString(a.Target)
+		}
+		prev = m[7]
+		if !noted[a.Alias] {
+			noted[a.Alias] = true
+			notes = append(notes, fmt.Sprintf("'%s' → %s", a.Alias, aliasTargetLabel(a)))
+		}
+	}
+	if len(notes) == 0 {
+		return msg, ""
+	}
+	b.WriteString(msg[prev:])
+	return b.String(), strings.Join(notes, "; ")
+}
@@ -0,0 +1,273 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"openai-agent-service/internal/models"
+)
+
+// popExclusion is an "excluding ..." clause from a POP question. The gateway
+// has no NOT filter, so excluded kiosks are dropped client-side after the rows
+// are fetched.
+type popExclusion struct {
+	Terms []string
+	Steps []models.Step
+
+	hosts         map[string]bool
+	droppedRows   int
+	droppedKiosks map[string]bool
+}
+
+var exclusionKeywords = []string{" but not on ", " but not at ", " but not in ", " excluding ", " except for ", " except ", " without "}
+
+// exclusionTail marks where an exclusion clause stops and the rest of the
+// question ("... excluding briggs kiosks in brt last week") resumes.
+var exclusionTail = []string{" in ", " from ", " for ", " during ", " over ", " last ", " this ", " since ", " between ", " by "}
+
+// parsePopExclusion splits an exclusion clause off msg. It returns nil and msg
+// unchanged when there is none; otherwise the returned message has the clause
+// removed so the usual poster/scope parsing is not confused by it.
+func parsePopExclusion(msg string) (*popExclusion, string) {
+	lower := strings.ToLower(msg)
+	at, kw := -1, ""
+	for _, k := range exclusionKeywords {
+		if i := strings.Index(lower, k); i >= 0 && (at < 0 || i < at) {
+			at, kw = i, k
+		}
+	}
+	if at < 0 {
+		return nil, msg
+	}
+	clause := msg[at+len(kw):]
+	cut := len(clause)
+	clauseLower := strings.ToLower(clause)
+	for _, sep := range exclusionTail {
+		if i := strings.Index(clauseLower, sep); i >= 0 && i < cut {
+			cut = i
+		}
+	}
+	clause, tail := clause[:cut], clause[cut:]
+	ex := &popExclusion{}
+	clause = strings.NewReplacer(" and ", ",", " or ", ",", " nor ", ",").Replace(" " + strings.TrimRight(clause, "?.! ") + " ")
+	for _, part := range strings.Split(clause, ",") {
+		words := strings.Fields(strings.Trim(part, "'\" "))
+		for len(words) > 0 {
+			w := strings.ToLower(words[0])
+			if w != "the" && w != "any" && w != "all" && w != "venue" && w != "kiosk" && w != "kiosks" && w != "host" && w != "device" {
+				break
+			}
+			words = words[1:]
+		}
+		for len(words) > 0 {
+			w := strings.ToLower(words[len(words)-1])
+			if w != "kiosk" && w != "kiosks" && w != "device" && w != "devices" && w != "hosts" && w != "venue" && w != "venues" && w != "screens" {
+				break
+			}
+			words = words[:len(words)-1]
+		}
+		term := strings.Trim(strings.Join(words, " "), "'\" ")
+		if len([]rune(term)) >= 3 {
+			ex.Terms = append(ex.Terms, term)
+		}
+	}
+	if len(ex.Terms) == 0 {
+		return nil, msg
+	}
+	rest := strings.TrimSpace(msg[:at]) + tail
+	return ex, strings.TrimSpace(rest)
+}
+
+// isHostTerm reports whether an excluded term is a host name or host suffix
+// ("moco-brt-briggs-001", "briggs-001") rather than a kiosk or venue name.
+func isHostTerm(term string) bool {
+	return !strings.Contains(term, " ") && strings.Contains(term, "-")
+}
+
+// resolveExclusion expands venue references to the hosts of their devices.
+// Terms that look like hosts are matched directly and are not looked up.
+func (c *ChatService) resolveExclusion(ctx context.Context, ex *popExclusion) {
+	ex.hosts = map[string]bool{}
+	if c.Gateway == nil {
+		return
+	}
+	for _, term := range ex.Terms {
+		if isHostTerm(term) {
+			continue
+		}
+		venueID, step := c.resolveVenueIDFromName(ctx, "", term)
+		if step != nil {
+			ex.Steps = append(ex.Steps, *step)
+		}
+		if venueID <= 0 {
+			continue
+		}
+		status, body, err := c.Gateway.Get(ctx, fmt.Sprintf("/ads/venues/%d/devices?page=1&page_size=100", venueID))
+		vs := models.Step{Tool: "adsVenueDevices", Status: status}
+		if err != nil {
+			vs.Error = err.Error()
+		} else {
+			vs.Body = c.clipStep(strings.TrimSpace(string(body)))
+		}
+		ex.Steps = append(ex.Steps, vs)
+		if err != nil || status < 200 || status >= 300 {
+			continue
+		}
+		var parsed map[string]any
+		if json.Unmarshal(body, &parsed) != nil {
+			continue
+		}
+		rows, _ := parsed["data"].([]any)
+		for _, it := range rows {
+			if m, ok := it.(map[string]any); ok {
+				if hn := strings.ToLower(anyString(m, "host_name", "hostname", "host")); hn != "" {
+					ex.hosts[hn] = true
+				}
+			}
+		}
+	}
+}
+
+func (ex *popExclusion) matches(it popItem) bool {
+	host := strings.ToLower(strings.TrimSpace(it.HostName))
+	if host != "" && ex.hosts[host] {
+		return true
+	}
+	for _, term := range ex.Terms {
+		if host != "" && containsFolded(host, term) {
+			return true
+		}
+		if containsFolded(it.KioskName, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter drops excluded rows and records how much was removed.
+func (ex *popExclusion) filter(rows []popItem) []popItem {
+	if ex.droppedKiosks == nil {
+		ex.droppedKiosks = map[string]bool{}
+	}
+	kept := rows[:0:0]
+	for _, it := range rows {
+		if !ex.matches(it) {
+			kept = append(kept, it)
+			continue
+		}
+		ex.droppedRows++
+		k := strings.TrimSpace(it.KioskName)
+		if k == "" {
+			k = strings.ToLower(strings.TrimSpace(it.HostName))
+		}
+		ex.droppedKiosks[k] = true
+	}
+	return kept
+}
+
+func (ex *popExclusion) label() string {
+	return "'" + strings.Join(ex.Terms, "', '") + "'"
+}
+
+// note states what the exclusion removed, including when it matched nothing.
+func (ex *popExclusion) note() string {
+	if ex == nil {
+		return ""
+	}
+	if ex.droppedRows == 0 {
+		return fmt.Sprintf("Note: the exclusion %s matched no kiosks, so nothing was excluded.", ex.label())
+	}
+	rows, kiosks := "rows", "kiosks"
+	if ex.droppedRows == 1 {
+		rows = "row"
+	}
+	if len(ex.droppedKiosks) == 1 {
+		kiosks = "kiosk"
+	}
+	return fmt.Sprintf("Excluded %s POP %s from %d %s matching %s.", formatThousands(int64(ex.droppedRows)), rows, len(ex.droppedKiosks), kiosks, ex.label())
+}
+
+// rankedKey is one group of a client-side POP ranking.
+type rankedKey struct {
+	Key   string
+	Value int64
+}
+
+// rankPopRows aggregates raw /pop rows the way /pop/stats would, by poster,
+// device (host) or kiosk, summing plays or counting rows.
+func rankPopRows(rows []popItem, groupBy, metric string) []rankedKey {
+	sums := map[string]int64{}
+	for _, it := range rows {
+		key := ""
+		switch groupBy {
+		case "device":
+			key = strings.ToLower(strings.TrimSpace(it.HostName))
+		case "kiosk":
+			key = strings.TrimSpace(it.KioskName)
+		default:
+			key = strings.TrimSpace(it.PosterName)
+		}
+		if key == "" {
+			continue
+		}
+		if metric == "count" {
+			sums[key]++
+		} else {
+			sums[key] += it.PlayCount
+		}
+	}
+	out := make([]rankedKey, 0, len(sums))
+	for k, v := range sums {
+		out = append(out, rankedKey{Key: k, Value: v})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Value == out[j].Value {
+			return out[i].Key < out[j].Key
+		}
+		return out[i].Value > out[j].Value
+	})
+	return out
+}
+
+// popTopExcluding answers a top-N POP question with exclusions applied. It
+// aggregates raw /pop rows because /pop/stats cannot leave kiosks out. Raw
+// rows carry no clicks, so click rankings fall back to plays.
+func (c *ChatService) popTopExcluding(ctx context.Context, ex *popExclusion, filter, groupBy, metric, scopeLabel string, limit int) models.ChatResponse {
+	rows, steps, pager, err := c.fetchPopRows(ctx, filter)
+	if err != nil {
+		return models.ChatResponse{Answer: "Failed to fetch POP data: " + err.Error(), Steps: steps}
+	}
+	c.resolveExclusion(ctx, ex)
+	steps = append(steps, ex.Steps...)
+	rows = ex.filter(rows)
+
+	notes := make([]string, 0, 2)
+	if metric == "clicks" {
+		metric = "plays"
+		notes = append(notes, "Clicks are not available on raw POP rows, so this ranking uses plays.")
+	}
+	ranked := rankPopRows(rows, groupBy, metric)
+	noun := map[string]string{"poster": "posters", "device": "devices", "kiosk": "kiosks"}[groupBy]
+	if noun == "" {
+		noun = "posters"
+	}
+	lines := make([]string, 0, limit+4)
+	if len(ranked) == 0 {
+		lines = append(lines, fmt.Sprintf("No POP %s found for %s after exclusions.", metric, scopeLabel))
+	} else {
+		lines = append(lines, fmt.Sprintf("Top %s in %s by %s, excluding %s:", noun, scopeLabel, metric, ex.label()))
+		for i, r := range ranked {
+			if i >= limit {
+				break
+			}
+			lines = append(lines, fmt.Sprintf("%d. %s — %s %s", i+1, r.Key, formatThousands(r.Value), metric))
+		}
+	}
+	notes = append(notes, ex.note())
+	answer := strings.Join(lines, "\n") + "\n\n" + strings.Join(notes, "\n")
+	answer = pager.note(answer)
+	return models.ChatResponse{Answer: answer, Steps: steps, Meta: pager.meta()}
+}
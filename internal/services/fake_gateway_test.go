@@ -0,0 +1,273 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// fakeDevice is one kiosk the fake gateway knows.
+type fakeDevice struct {
+	Host, Kiosk, City, Region string
+}
+
+// fakeGateway is an in-memory tool gateway for handler tests. It serves the
+// region list, device search and a filtered, paged /pop built from Pop;
+// Routes answers any other path, with or without its query, with a fixed
+// body, and anything else gets an empty listing.
+type fakeGateway struct {
+	Devices []fakeDevice
+	Pop     []popItem
+	Routes  map[string]string
+	// Reject lists /pop query params answered with a 400, as deployments
+	// that don't support them do.
+	Reject map[string]bool
+	// MaxPageSize caps the page_size /pop honours and echoes.
+	MaxPageSize int
+	// PopQuirk, when set, may rewrite a /pop page before it is sent, e.g.
+	// to drop the total or echo another page number.
+	PopQuirk func(page int, resp map[string]any)
+	// PopStatus, when set, answers /pop page n with this status instead.
+	PopStatus map[int]int
+	// Body, when set, answers every path but the region list with this
+	// body, e.g. an empty payload.
+	Body *string
+	// Hold, when set, keeps every /pop request waiting until it is closed.
+	Hold chan struct{}
+
+	srv    *httptest.Server
+	mu     sync.Mutex
+	calls  []string
+	accept map[string]bool
+	keys   []string
+}
+
+// Accept makes the gateway answer 401 to any X-API-Key but keys, as after
+// a rotation; with no keys every request is accepted again.
+func (g *fakeGateway) Accept(keys ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.accept = nil
+	for _, k := range keys {
+		if g.accept == nil {
+			g.accept = map[string]bool{}
+		}
+		g.accept[k] = true
+	}
+}
+
+// Keys returns the X-API-Key of every request so far.
+func (g *fakeGateway) Keys() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.keys...)
+}
+
+func newFakeGateway(t *testing.T, g *fakeGateway) *fakeGateway {
+	t.Helper()
+	g.srv = httptest.NewServer(http.HandlerFunc(g.serve))
+	t.Cleanup(g.srv.Close)
+	return g
+}
+
+// testDevices and testPop are the fixture most handler tests share: two
+// Briggs kiosks in moco/brt and one Union Station kiosk in kc/kcmo, with
+// October 2024 plays of two posters.
+var testDevices = []fakeDevice{
+	{Host: "moco-brt-briggs-001", Kiosk: "Briggs Lobby", City: "moco", Region: "brt"},
+	{Host: "moco-brt-briggs-002", Kiosk: "Briggs Annex", City: "moco", Region: "brt"},
+	{Host: "kc-kcmo-union-003", Kiosk: "Union Station", City: "kc", Region: "kcmo"},
+}
+
+const (
+	testBetID   = "0b5d1a8e-3c5f-4d7e-9a61-2f4b8c9d0e11"
+	testLorlaID = "7c2e9f40-1b3a-4c6d-8e5f-a0b1c2d3e4f5"
+)
+
+func testPop() []popItem {
+	row := func(poster, id string, d fakeDevice, day, hour int, plays int64) popItem {
+		return popItem{
+			PosterName: poster, PosterID: id, PosterType: "image",
+			HostName: d.Host, KioskName: d.Kiosk, City: d.City, Region: d.Region,
+			PopDatetime: time.Date(2024, 10, day, hour, 0, 0, 0, time.UTC),
+			PlayCount:   plays, Value: plays * 15,
+		}
+	}
+	lobby, annex, union := testDevices[0], testDevices[1], testDevices[2]
+	return []popItem{
+		row("Bet 365", testBetID, lobby, 1, 9, 120),
+		row("Bet 365", testBetID, lobby, 2, 14, 80),
+		row("Bet 365", testBetID, annex, 2, 18, 45),
+		row("Bet 365", testBetID, union, 3, 8, 300),
+		row("Bet 365", testBetID, lobby, 15, 11, 60),
+		row("Lorla Studio", testLorlaID, lobby, 1, 10, 20),
+		row("Lorla Studio", testLorlaID, annex, 9, 16, 35),
+		row("Lorla Studio", testLorlaID, union, 20, 20, 5),
+	}
+}
+
+// newTestChat returns a ChatService backed by g with no stores, reading /pop
+// two rows per page so pagination is exercised.
+func newTestChat(g *fakeGateway) *ChatService {
+	return &ChatService{
+		Gateway: &GatewayClient{BaseURL: g.srv.URL},
+		Limits:  Limits{PopPageSize: 2},
+	}
+}
+
+// answeredBy names the handler that answered on c, or "" when none did.
+func answeredBy(c *ChatService) string {
+	c.flags.mu.Lock()
+	defer c.flags.mu.Unlock()
+	for name, n := range c.flags.hits {
+		if n > 0 {
+			return name
+		}
+	}
+	return ""
+}
+
+// Calls returns the gateway paths requested so far, with queries, that
+// start with prefix.
+func (g *fakeGateway) Calls(prefix string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var out []string
+	for _, c := range g.calls {
+		if strings.HasPrefix(c, prefix) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (g *fakeGateway) serve(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("X-API-Key")
+	g.mu.Lock()
+	g.calls = append(g.calls, r.URL.RequestURI())
+	g.keys = append(g.keys, key)
+	denied := g.accept != nil && !g.accept[key]
+	g.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if denied {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":"invalid api key"}`))
+		return
+	}
+	switch p := r.URL.Path; {
+	case g.Body != nil && p != "/ads/devices/counts/regions":
+		_, _ = w.Write([]byte(*g.Body))
+	case p == "/pop":
+		if g.Hold != nil {
+			<-g.Hold
+		}
+		g.servePop(w, r.URL.Query())
+	case p == "/ads/devices/counts/regions":
+		// One row per city and region, counting its kiosks.
+		rows := []map[string]any{}
+		index := map[[2]string]map[string]any{}
+		city := r.URL.Query().Get("city")
+		for _, d := range g.Devices {
+			if city != "" && !strings.EqualFold(city, d.City) {
+				continue
+			}
+			k := [2]string{d.City, d.Region}
+			if index[k] == nil {
+				index[k] = map[string]any{"city": d.City, "region": d.Region, "count": 0}
+				rows = append(rows, index[k])
+			}
+			index[k]["count"] = index[k]["count"].(int) + 1
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": rows})
+	case p == "/ads/devices/search" || p == "/ads/devices":
+		rows := []map[string]any{}
+		for _, d := range g.Devices {
+			rows = append(rows, map[string]any{"host_name": d.Host, "kiosk_name": d.Kiosk, "display_name": d.Kiosk, "city": d.City, "region": d.Region})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": rows})
+	case g.Routes[p] != "":
+		_, _ = w.Write([]byte(g.Routes[p]))
+	case g.Routes[r.URL.RequestURI()] != "":
+		_, _ = w.Write([]byte(g.Routes[r.URL.RequestURI()]))
+	default:
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	}
+}
+
+func (g *fakeGateway) servePop(w http.ResponseWriter, q url.Values) {
+	for k := range q {
+		if g.Reject[k] {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"unsupported filter ` + k + `"}`))
+			return
+		}
+	}
+	page, _ := strconv.Atoi(q.Get("page"))
+	if st := g.PopStatus[page]; st != 0 {
+		w.WriteHeader(st)
+		_, _ = w.Write([]byte(`{"error":"upstream"}`))
+		return
+	}
+	page = max(page, 1)
+	size, _ := strconv.Atoi(q.Get("page_size"))
+	size = max(size, 1)
+	if g.MaxPageSize > 0 && size > g.MaxPageSize {
+		size = g.MaxPageSize
+	}
+	from, _ := time.Parse(time.RFC3339, q.Get("from"))
+	to, _ := time.Parse(time.RFC3339, q.Get("to"))
+	eq := func(key, v string) bool {
+		want := q.Get(key)
+		return want == "" || strings.EqualFold(strings.TrimSpace(want), strings.TrimSpace(v))
+	}
+	rows := []popItem{}
+	for _, it := range g.Pop {
+		if !eq("poster_name", it.PosterName) || !eq("poster_id", it.PosterID) ||
+			!eq("host_name", it.HostName) || !eq("host", it.HostName) ||
+			!eq("kiosk_name", it.KioskName) || !eq("kiosk", it.KioskName) ||
+			!eq("city", it.City) || !eq("region", it.Region) {
+			continue
+		}
+		if !from.IsZero() && it.PopDatetime.Before(from) || !to.IsZero() && !it.PopDatetime.Before(to) {
+			continue
+		}
+		rows = append(rows, it)
+	}
+	total := len(rows)
+	start := min((page-1)*size, total)
+	end := min(start+size, total)
+	resp := map[string]any{"items": rows[start:end], "total": total, "page": page, "page_size": size}
+	if g.PopQuirk != nil {
+		g.PopQuirk(page, resp)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// chatOnce asks c one question and returns the answer.
+func chatOnce(t *testing.T, c *ChatService, msg string) models.ChatResponse {
+	t.Helper()
+	resp, err := c.Chat(context.Background(), "test-key", models.ChatRequest{Message: msg})
+	if err != nil {
+		t.Fatalf("Chat(%q): %v", msg, err)
+	}
+	return resp
+}
+
+// chatTurn asks c one question in conversation id, which needs c.Store.
+func chatTurn(t *testing.T, c *ChatService, id, msg string) string {
+	t.Helper()
+	resp, err := c.Chat(context.Background(), "test-key", models.ChatRequest{ConversationID: id, Message: msg})
+	if err != nil {
+		t.Fatalf("Chat(%q): %v", msg, err)
+	}
+	return resp.Answer
+}
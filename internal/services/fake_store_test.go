@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// memStore is an in-memory Store for multi-turn handler tests.
+type memStore struct {
+	mu       sync.Mutex
+	owners   map[string]string
+	titles   map[string]string
+	messages map[string][]models.Message
+}
+
+func newMemStore() *memStore {
+	return &memStore{owners: map[string]string{}, titles: map[string]string{}, messages: map[string][]models.Message{}}
+}
+
+func (s *memStore) AppendMessage(_ context.Context, ownerKey, conversationID, role, content string) error {
+	s.append(ownerKey, conversationID, models.Message{Role: role, Content: content})
+	return nil
+}
+
+func (s *memStore) AppendAssistantMessage(_ context.Context, ownerKey, conversationID, content, handler string) error {
+	s.append(ownerKey, conversationID, models.Message{Role: "assistant", Content: content, Handler: handler})
+	return nil
+}
+
+func (s *memStore) append(ownerKey, conversationID string, m models.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owners[conversationID] == "" {
+		s.owners[conversationID] = ownerKey
+	}
+	m.ID = int64(len(s.messages[conversationID]) + 1)
+	m.ConversationID = conversationID
+	m.CreatedAt = time.Now()
+	s.messages[conversationID] = append(s.messages[conversationID], m)
+}
+
+func (s *memStore) ListMessages(_ context.Context, _, conversationID string, limit int) ([]models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs := s.messages[conversationID]
+	if limit > 0 && len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+	}
+	return append([]models.Message(nil), msgs...), nil
+}
+
+func (s *memStore) CreateConversation(_ context.Context, ownerKey string) (models.Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := "conv-" + time.Now().Format("150405.000000000")
+	s.owners[id] = ownerKey
+	return models.Conversation{ConversationID: id, CreatedAt: time.Now(), UpdatedAt: time.Now()}, nil
+}
+
+func (s *memStore) GetConversation(_ context.Context, _, conversationID string) (models.Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return models.Conversation{ConversationID: conversationID, Title: s.titles[conversationID]}, nil
+}
+
+func (s *memStore) ConversationOwner(_ context.Context, conversationID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.owners[conversationID], nil
+}
+
+func (s *memStore) SetDefaultConversationTitle(_ context.Context, _, conversationID, title string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.titles[conversationID] == "" {
+		s.titles[conversationID] = title
+	}
+	return nil
+}
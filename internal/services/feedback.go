@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// FeedbackStore stores and aggregates answer ratings. PostgresStore
+// implements it.
+type FeedbackStore interface {
+	RecordFeedback(ctx context.Context, f models.Feedback) error
+	FeedbackSummary(ctx context.Context, from, to time.Time, recentDown int) (models.FeedbackSummary, error)
+}
+
+// maxFeedbackWrites bounds the feedback inserts in flight.
+const maxFeedbackWrites = 16
+
+// ErrFeedbackBusy is returned by SubmitFeedback when too many writes are
+// pending.
+var ErrFeedbackBusy = errors.New("too many feedback writes pending")
+
+// SubmitFeedback stores f in the background and returns at once. Store
+// errors, including an unknown message, are only logged.
+func (c *ChatService) SubmitFeedback(ctx context.Context, f models.Feedback) error {
+	if c.Feedback == nil {
+		return errors.New("feedback is not configured")
+	}
+	c.feedbackOnce.Do(func() { c.feedbackSlots = make(chan struct{}, maxFeedbackWrites) })
+	select {
+	case c.feedbackSlots <- struct{}{}:
+	default:
+		log.Printf("feedback: dropped rating=%s message_id=%d (writes pending)", f.Rating, f.MessageID)
+		return ErrFeedbackBusy
+	}
+	go func() {
+		defer func() { <-c.feedbackSlots }()
+		defer logPanic("feedback write")
+		wctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if err := c.Feedback.RecordFeedback(wctx, f); err != nil {
+			log.Printf("feedback: record failed rating=%s message_id=%d: %v", f.Rating, f.MessageID, err)
+		}
+	}()
+	return nil
+}
+
+var (
+	// A standalone "that's wrong", "wrong answer" or 👎.
+	thumbsDownRe = regexp.MustCompile(`(?i)^\s*(?:(?:no,?\s+)?(?:that'?s|that\s+is|this\s+is|it'?s)\s+(?:wrong|incorrect|not\s+right|not\s+correct|useless|not\s+helpful)|wrong\s+answer|bad\s+answer|thumbs\s+down|👎+)\s*[.!]*\s*$`)
+	// Replies that are a new question rather than what the answer should
+	// have been.
+	newQuestionRe = regexp.MustCompile(`(?i)^\s*(?:how|what|which|who|where|when|why|show|list|give|get|top|is|are|do|does|can)\b`)
+)
+
+// handleFeedback turns a standalone "that's wrong" into a down-vote of the
+// previous answer and asks what was expected; the reply is stored as the
+// feedback comment unless it is a new question.
+func (c *ChatService) handleFeedback(ctx context.Context, ownerKey string, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	conversationID := strings.TrimSpace(req.ConversationID)
+	if conversationID != "" {
+		if resp, handled := c.feedbackComment(ctx, ownerKey, conversationID, req); handled {
+			if onToken != nil {
+				onToken(resp.Answer)
+			}
+			return resp, true, nil
+		}
+	}
+	if !thumbsDownRe.MatchString(req.Message) {
+		return models.ChatResponse{}, false, nil
+	}
+	answer := c.flagPreviousAnswer(ctx, ownerKey, conversationID)
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer}, true, nil
+}
+
+func (c *ChatService) flagPreviousAnswer(ctx context.Context, ownerKey, conversationID string) string {
+	if conversationID == "" || c.Store == nil || c.Feedback == nil {
+		return "Sorry about that. Feedback can only be recorded in a conversation (send a conversation_id)."
+	}
+	msgs, err := c.Store.ListMessages(ctx, ownerKey, conversationID, 20)
+	if err != nil {
+		return "Sorry about that. The previous answer could not be looked up to record your feedback."
+	}
+	var prev *models.Message
+	for i := len(msgs) - 1; i >= 0; i-- {
+		// Skip this handler's own replies so a second "that's wrong" still
+		// flags the answer before them.
+		if msgs[i].Role == "assistant" && msgs[i].Handler != "handleFeedback" {
+			prev = &msgs[i]
+			break
+		}
+	}
+	if prev == nil {
+		return "There is no earlier answer in this conversation to flag."
+	}
+	f := models.Feedback{OwnerKey: ownerKey, ConversationID: conversationID, MessageID: prev.ID, Rating: models.FeedbackDown}
+	if err := c.SubmitFeedback(ctx, f); err != nil {
+		return "Sorry about that. Your feedback could not be recorded right now; please try again."
+	}
+	c.setPending(conversationID, "feedbackComment", strconv.FormatInt(prev.ID, 10))
+	return "Thanks, I've flagged the previous answer as wrong. What did you expect it to show?"
+}
+
+// feedbackComment stores the reply to the "what did you expect" question.
+func (c *ChatService) feedbackComment(ctx context.Context, ownerKey, conversationID string, req models.ChatRequest) (models.ChatResponse, bool) {
+	st := c.getConversationState(conversationID)
+	if st == nil || st.PendingHandler != "feedbackComment" {
+		return models.ChatResponse{}, false
+	}
+	messageID, _ := strconv.ParseInt(st.PendingMessage, 10, 64)
+	c.clearPending(conversationID)
+	comment := strings.TrimSpace(req.Message)
+	if messageID <= 0 || comment == "" || thumbsDownRe.MatchString(comment) || newQuestionRe.MatchString(comment) || strings.HasSuffix(comment, "?") {
+		return models.ChatResponse{}, false
+	}
+	f := models.Feedback{OwnerKey: ownerKey, ConversationID: conversationID, MessageID: messageID, Rating: models.FeedbackDown, Comment: clipString(comment, 2000)}
+	if err := c.SubmitFeedback(ctx, f); err != nil {
+		return models.ChatResponse{Answer: "Your comment could not be recorded right now; please try again."}, true
+	}
+	return models.ChatResponse{Answer: "Thanks, I've added that to the feedback."}, true
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// forgetMarks records when the user explicitly cleared each part of the
+// remembered context. Hydration ignores history older than the matching
+// mark so cleared context does not come back from earlier messages.
+type forgetMarks struct {
+	Poster time.Time
+	Scope  time.Time
+	Host   time.Time
+	// All is set by "start fresh" and also covers the campaign and venue.
+	All time.Time
+}
+
+// forgetRequest is what one "forget ..." message asks to clear.
+type forgetRequest struct {
+	Poster bool
+	Scope  bool
+	Host   bool
+	All    bool
+}
+
+var (
+	// The verb must open the message so questions that merely mention
+	// forgetting ("which posters did we clear yesterday") are not captured.
+	forgetVerbRe   = regexp.MustCompile(`^(?:please\s+|ok(?:ay)?,?\s+|now\s+|and\s+)?(?:forget|clear|drop|reset|remove)\b`)
+	forgetNounRe   = regexp.MustCompile(`\b(poster|region|city|location|scope|device|kiosk|host)s?\b`)
+	startFreshRe   = regexp.MustCompile(`^(?:please\s+|ok(?:ay)?,?\s+|let'?s\s+)?(?:start\s+(?:fresh|over|again)|(?:forget|clear)\s+(?:all|everything|the\s+context|context)|reset\s+(?:the\s+)?(?:context|memory|conversation))\b`)
+	forgetTrailing = regexp.MustCompile(`[.!?\s]+$`)
+)
+
+// parseForgetRequest recognises "forget the poster", "clear the region",
+// "forget this device", "forget the poster and the city" and "start fresh".
+func parseForgetRequest(msgLower string) (forgetRequest, bool) {
+	msg := forgetTrailing.ReplaceAllString(strings.TrimSpace(msgLower), "")
+	if startFreshRe.MatchString(msg) {
+		return forgetRequest{Poster: true, Scope: true, Host: true, All: true}, true
+	}
+	if !forgetVerbRe.MatchString(msg) || len(strings.Fields(msg)) > 10 {
+		return forgetRequest{}, false
+	}
+	var r forgetRequest
+	for _, m := range forgetNounRe.FindAllStringSubmatch(msg, -1) {
+		switch m[1] {
+		case "poster":
+			r.Poster = true
+		case "region", "city", "location", "scope":
+			r.Scope = true
+		case "device", "kiosk", "host":
+			r.Host = true
+		}
+	}
+	return r, r.Poster || r.Scope || r.Host
+}
+
+// apply moves the marks forward for everything r clears.
+func (m *forgetMarks) apply(r forgetRequest, at time.Time) {
+	if r.Poster && at.After(m.Poster) {
+		m.Poster = at
+	}
+	if r.Scope && at.After(m.Scope) {
+		m.Scope = at
+	}
+	if r.Host && at.After(m.Host) {
+		m.Host = at
+	}
+	if r.All && at.After(m.All) {
+		m.All = at
+	}
+}
+
+// forgetContext clears what r asks for and returns a description of each
+// cleared item. Everything except the conversation itself (and its title)
+// is cleared for "start fresh".
+func (c *ChatService) forgetContext(conversationID string, r forgetRequest) []string {
+	var cleared []string
+	c.updateConversationState(conversationID, func(st *conversationState) {
+		if r.Poster {
+			if p := strings.TrimSpace(st.PosterName); p != "" {
+				cleared = append(cleared, "poster ("+p+")")
+			} else if st.PosterID != "" {
+				cleared = append(cleared, "poster ("+st.PosterID+")")
+			}
+			st.PosterName, st.PosterID, st.PosterCity, st.PosterRegion = "", "", "", ""
+		}
+		if r.Scope {
+			var parts []string
+			if st.City != "" {
+				parts = append(parts, "city "+st.City)
+			}
+			if st.Region != "" {
+				parts = append(parts, "region "+st.Region)
+			}
+			if len(parts) > 0 {
+				cleared = append(cleared, "location ("+strings.Join(parts, ", ")+")")
+			}
+			st.City, st.Region = "", ""
+		}
+		if r.Host {
+			if st.Host != "" {
+				cleared = append(cleared, "device ("+st.Host+")")
+			}
+			st.Host = ""
+		}
+		if r.All {
+			if st.CampaignID != "" {
+				cleared = append(cleared, "campaign ("+st.CampaignID+")")
+			}
+			if st.VenueID > 0 {
+				cleared = append(cleared, fmt.Sprintf("venue (#%d)", st.VenueID))
+			}
+			if st.CampaignDraft != nil {
+				cleared = append(cleared, "campaign draft")
+			}
+			if st.PendingHandler != "" {
+				cleared = append(cleared, "pending question")
+			}
+			if st.Unit != "" {
+				cleared = append(cleared, "unit ("+st.Unit+")")
+			}
+			st.CampaignID, st.VenueID, st.CampaignDraft, st.Unit = "", 0, nil, ""
+			st.PendingHandler, st.PendingMessage, st.PendingCampaigns = "", "", nil
+		}
+		st.Forgotten.apply(r, time.Now())
+	})
+	return cleared
+}
+
+func (c *ChatService) handleForgetContext(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	r, ok := parseForgetRequest(strings.ToLower(req.Message))
+	if !ok {
+		return models.ChatResponse{}, false, nil
+	}
+	conversationID := strings.TrimSpace(req.ConversationID)
+	answer := ""
+	if conversationID == "" {
+		answer = "Nothing is remembered outside a conversation, so there is nothing to clear."
+	} else {
+		cleared := c.forgetContext(conversationID, r)
+		var asked []string
+		if r.Poster {
+			asked = append(asked, "poster")
+		}
+		if r.Scope {
+			asked = append(asked, "location")
+		}
+		if r.Host {
+			asked = append(asked, "device")
+		}
+		switch {
+		case r.All && len(cleared) == 0:
+			answer = "Starting fresh. Nothing was remembered yet; this conversation and its history are kept."
+		case r.All:
+			answer = "Starting fresh. Cleared the remembered " + strings.Join(cleared, ", ") + ". This conversation and its history are kept, but follow-up questions will not reuse them."
+		case len(cleared) == 0:
+			answer = "No " + strings.Join(asked, " or ") + " was remembered in this conversation, so there was nothing to clear."
+		default:
+			it := "it"
+			if len(cleared) > 1 {
+				it = "them"
+			}
+			answer = "Cleared the remembered " + strings.Join(cleared, ", ") + ". Follow-up questions will not assume " + it + "; name " + it + " again when you need " + it + "."
+		}
+	}
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer}, true, nil
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// freshnessUnknown is the Meta.Freshness value of a source whose responses
+// carried no timestamp.
+const freshnessUnknown = "unknown"
+
+// freshnessKeys are the row fields read as a source's data timestamp, by
+// source; freshnessCommonKeys apply to every source.
+var (
+	freshnessKeys = map[string][]string{
+		"pop":     {"pop_datetime"},
+		"metrics": {"time", "timestamp"},
+	}
+	freshnessCommonKeys = []string{"updated_at", "updatedAt", "last_modified"}
+)
+
+// freshnessMaxDepth bounds how deep a response body is walked for
+// timestamps; rows sit at most a couple of levels below "data".
+const freshnessMaxDepth = 4
+
+// freshness collects, for one request, the newest data timestamp seen from
+// each gateway source, so the answer can say as of when it was true. It
+// travels in the request context like cacheHits; methods are safe on a nil
+// receiver.
+type freshness struct {
+	mu     sync.Mutex
+	newest map[string]time.Time
+}
+
+type freshnessKey struct{}
+
+func withFreshness(ctx context.Context, f *freshness) context.Context {
+	return context.WithValue(ctx, freshnessKey{}, f)
+}
+
+func freshnessFrom(ctx context.Context) *freshness {
+	f, _ := ctx.Value(freshnessKey{}).(*freshness)
+	return f
+}
+
+// withoutFreshness is for reference lookups, such as refreshing the city
+// and region caches, that are not data behind the answer.
+func withoutFreshness(ctx context.Context) context.Context {
+	if freshnessFrom(ctx) == nil {
+		return ctx
+	}
+	return withFreshness(ctx, nil)
+}
+
+func newFreshness() *freshness {
+	return &freshness{newest: map[string]time.Time{}}
+}
+
+// record notes a successful gateway read of path: the newest timestamp in
+// body, or the Last-Modified header when the body has none. A source read
+// without either is still recorded, with a zero time.
+func (f *freshness) record(path string, header http.Header, body []byte) {
+	if f == nil {
+		return
+	}
+	source := freshnessSource(path)
+	if source == "" {
+		return
+	}
+	newest := newestTimestamp(source, body)
+	if newest.IsZero() && header != nil {
+		if t, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+			newest = t
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if cur, ok := f.newest[source]; !ok || newest.After(cur) {
+		f.newest[source] = newest
+	}
+}
+
+// freshnessSource names the source a gateway path reads: its first segment,
+// or the second under /ads ("/ads/devices/x" is "devices").
+func freshnessSource(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segs) > 1 && segs[0] == "ads" {
+		segs = segs[1:]
+	}
+	return strings.ToLower(segs[0])
+}
+
+// newestTimestamp is the newest value of source's timestamp fields anywhere
+// in body; zero when there is none.
+func newestTimestamp(source string, body []byte) time.Time {
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return time.Time{}
+	}
+	keys := append(append([]string(nil), freshnessKeys[source]...), freshnessCommonKeys...)
+	var newest time.Time
+	var walk func(v any, depth int)
+	walk = func(v any, depth int) {
+		if depth > freshnessMaxDepth {
+			return
+		}
+		switch x := v.(type) {
+		case []any:
+			for _, it := range x {
+				walk(it, depth+1)
+			}
+		case map[string]any:
+			for _, k := range keys {
+				if t, ok := rowTime(x, k); ok && t.After(newest) {
+					newest = t
+				}
+			}
+			for _, it := range x {
+				walk(it, depth+1)
+			}
+		}
+	}
+	walk(v, 0)
+	return newest
+}
+
+// snapshot returns the sources seen, known ones first in order of name.
+func (f *freshness) snapshot() (sources []string, newest map[string]time.Time) {
+	if f == nil {
+		return nil, nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	newest = make(map[string]time.Time, len(f.newest))
+	for s, t := range f.newest {
+		sources = append(sources, s)
+		newest[s] = t
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		ki, kj := !newest[sources[i]].IsZero(), !newest[sources[j]].IsZero()
+		if ki != kj {
+			return ki
+		}
+		return sources[i] < sources[j]
+	})
+	return sources, newest
+}
+
+// freshnessFooter renders "Data as of 2024-10-15 14:32 UTC (pop), 14:55 UTC
+// (metrics)", naming the date only when it changes, with sources that had
+// no timestamp listed as "freshness unknown".
+func freshnessFooter(sources []string, newest map[string]time.Time) string {
+	var known, unknown []string
+	lastDate := ""
+	for _, s := range sources {
+		t := newest[s]
+		if t.IsZero() {
+			unknown = append(unknown, s)
+			continue
+		}
+		t = t.UTC()
+		stamp := t.Format("15:04") + " UTC"
+		if d := t.Format("2006-01-02"); d != lastDate {
+			stamp = d + " " + stamp
+			lastDate = d
+		}
+		known = append(known, stamp+" ("+s+")")
+	}
+	switch {
+	case len(known) == 0:
+		return "Data freshness unknown (" + strings.Join(unknown, ", ") + ")."
+	case len(unknown) == 0:
+		return "Data as of " + strings.Join(known, ", ") + "."
+	}
+	return "Data as of " + strings.Join(known, ", ") + "; freshness unknown (" + strings.Join(unknown, ", ") + ")."
+}
+
+// withFreshnessFooter puts the freshness of the gateway data behind resp in
+// Meta.Freshness and, unless the request asked for brief answers, ends the
+// answer with its footer. Answers that read nothing from the gateway are
+// left as they are.
+func withFreshnessFooter(ctx context.Context, req models.ChatRequest, resp models.ChatResponse, err error, onToken func(string)) models.ChatResponse {
+	if err != nil || resp.Error != nil {
+		return resp
+	}
+	sources, newest := freshnessFrom(ctx).snapshot()
+	if len(sources) == 0 {
+		return resp
+	}
+	if resp.Meta == nil {
+		resp.Meta = &models.ResponseMeta{}
+	} else {
+		m := *resp.Meta
+		resp.Meta = &m
+	}
+	resp.Meta.Freshness = make(map[string]string, len(sources))
+	for _, s := range sources {
+		if t := newest[s]; !t.IsZero() {
+			resp.Meta.Freshness[s] = t.UTC().Format(time.RFC3339)
+		} else {
+			resp.Meta.Freshness[s] = freshnessUnknown
+		}
+	}
+	if strings.EqualFold(strings.TrimSpace(req.Verbosity), "brief") || strings.TrimSpace(resp.Answer) == "" {
+		return resp
+	}
+	note := "\n\n" + freshnessFooter(sources, newest)
+	resp.Answer += note
+	if onToken != nil {
+		onToken(note)
+	}
+	return resp
+}
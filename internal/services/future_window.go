@@ -0,0 +1,102 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	futureNextDaysRe = regexp.MustCompile(`\b(?:next|coming|following)\s+(\d{1,2})\s+days?\b`)
+	futureWeekdayRe  = regexp.MustCompile(`\b(next\s+|this\s+|on\s+)?(monday|tuesday|wednesday|thursday|friday|saturday|sunday)\b`)
+)
+
+// futureWindow resolves the date range of a question about what is coming,
+// as whole days in loc starting no earlier than today:
+//   - an explicit range that ends after today;
+//   - "today" and "tomorrow";
+//   - "this weekend" (the coming Saturday and Sunday, or what is left of the
+//     current one) and "next weekend" (the one after);
+//   - "this week" (today up to Monday) and "next week" (Monday to Monday);
+//   - "the next N days", counting today;
+//   - "this month" (today to the 1st) and "next month";
+//   - a weekday: its next occurrence, today included unless it says "next".
+//
+// ok is false when the message names none of these. It is exportWindow's
+// counterpart for the future: exportWindow reads "this week" as the days so
+// far.
+func futureWindow(msg string, now time.Time, loc *time.Location) (from, to time.Time, label string, ok bool) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	msgLower := strings.ToLower(msg)
+	local := now.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	day := func(t time.Time) string { return t.Format("Mon Jan 2") }
+
+	fromRFC, toRFC := extractDateRangeRFC3339(msgLower)
+	if fromRFC == "" && toRFC == "" {
+		fromRFC, toRFC = extractNaturalDateRangeRFC3339(msg)
+	}
+	f, errF := time.Parse(time.RFC3339, fromRFC)
+	t, errT := time.Parse(time.RFC3339, toRFC)
+	if errF == nil && errT == nil && t.After(today) && t.After(f) {
+		f, t = f.In(loc), t.In(loc)
+		f = time.Date(f.Year(), f.Month(), f.Day(), 0, 0, 0, 0, loc)
+		if f.Before(today) {
+			f = today
+		}
+		return f, t, day(f) + "–" + day(t.Add(-time.Second)), true
+	}
+
+	// Saturday of the current weekend when today is in one, else the coming.
+	saturday := today.AddDate(0, 0, (int(time.Saturday)-int(today.Weekday())+7)%7)
+	if today.Weekday() == time.Sunday {
+		saturday = today.AddDate(0, 0, -1)
+	}
+	monday := today.AddDate(0, 0, 7-(int(today.Weekday())+6)%7)
+	firstOfMonth := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, loc)
+	switch {
+	case strings.Contains(msgLower, "next weekend"):
+		return saturday.AddDate(0, 0, 7), saturday.AddDate(0, 0, 9), "next weekend", true
+	case strings.Contains(msgLower, "weekend"):
+		from := saturday
+		if from.Before(today) {
+			from = today
+		}
+		return from, saturday.AddDate(0, 0, 2), "this weekend", true
+	case strings.Contains(msgLower, "tomorrow"):
+		return today.AddDate(0, 0, 1), today.AddDate(0, 0, 2), "tomorrow", true
+	case strings.Contains(msgLower, "today") || strings.Contains(msgLower, "tonight"):
+		return today, today.AddDate(0, 0, 1), "today", true
+	case strings.Contains(msgLower, "next week"):
+		return monday, monday.AddDate(0, 0, 7), "next week", true
+	case strings.Contains(msgLower, "this week") || strings.Contains(msgLower, "rest of the week"):
+		return today, monday, "the rest of this week", true
+	case strings.Contains(msgLower, "next month"):
+		return firstOfMonth.AddDate(0, 1, 0), firstOfMonth.AddDate(0, 2, 0), "next month", true
+	case strings.Contains(msgLower, "this month") || strings.Contains(msgLower, "rest of the month"):
+		return today, firstOfMonth.AddDate(0, 1, 0), "the rest of this month", true
+	}
+	if m := futureNextDaysRe.FindStringSubmatch(msgLower); m != nil {
+		if n, _ := strconv.Atoi(m[1]); n > 0 {
+			return today, today.AddDate(0, 0, n), fmt.Sprintf("the next %d days", n), true
+		}
+	}
+	if m := futureWeekdayRe.FindStringSubmatch(msgLower); m != nil {
+		for wd := time.Sunday; wd <= time.Saturday; wd++ {
+			if strings.ToLower(wd.String()) != m[2] {
+				continue
+			}
+			ahead := (int(wd) - int(today.Weekday()) + 7) % 7
+			if ahead == 0 && strings.HasPrefix(m[1], "next") {
+				ahead = 7
+			}
+			d := today.AddDate(0, 0, ahead)
+			return d, d.AddDate(0, 0, 1), day(d), true
+		}
+	}
+	return time.Time{}, time.Time{}, "", false
+}
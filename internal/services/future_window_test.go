@@ -0,0 +1,61 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFutureWindow(t *testing.T) {
+	// A Wednesday.
+	wed := time.Date(2024, 11, 13, 15, 0, 0, 0, time.UTC)
+	sun := time.Date(2024, 11, 17, 9, 0, 0, 0, time.UTC)
+	est := time.FixedZone("EST", -5*3600)
+	cases := []struct {
+		msg      string
+		now      time.Time
+		loc      *time.Location
+		from, to string // "" when no window is found
+		label    string
+	}{
+		{msg: "what's scheduled this weekend", now: wed, from: "2024-11-16", to: "2024-11-18", label: "this weekend"},
+		{msg: "what's scheduled this weekend", now: sun, from: "2024-11-17", to: "2024-11-18", label: "this weekend"},
+		{msg: "what's scheduled next weekend", now: wed, from: "2024-11-23", to: "2024-11-25", label: "next weekend"},
+		{msg: "what will run tomorrow", now: wed, from: "2024-11-14", to: "2024-11-15", label: "tomorrow"},
+		{msg: "what's on tonight", now: wed, from: "2024-11-13", to: "2024-11-14", label: "today"},
+		{msg: "schedule for next week", now: wed, from: "2024-11-18", to: "2024-11-25", label: "next week"},
+		{msg: "schedule for next week", now: sun, from: "2024-11-18", to: "2024-11-25", label: "next week"},
+		{msg: "rest of the week", now: wed, from: "2024-11-13", to: "2024-11-18", label: "the rest of this week"},
+		{msg: "the next 3 days", now: wed, from: "2024-11-13", to: "2024-11-16", label: "the next 3 days"},
+		{msg: "next month", now: wed, from: "2024-12-01", to: "2025-01-01", label: "next month"},
+		{msg: "this month", now: wed, from: "2024-11-13", to: "2024-12-01", label: "the rest of this month"},
+		{msg: "on friday", now: wed, from: "2024-11-15", to: "2024-11-16", label: "Fri Nov 15"},
+		{msg: "on wednesday", now: wed, from: "2024-11-13", to: "2024-11-14", label: "Wed Nov 13"},
+		{msg: "next wednesday", now: wed, from: "2024-11-20", to: "2024-11-21", label: "Wed Nov 20"},
+		// Explicit ranges start no earlier than today.
+		{msg: "from 2024-11-10 to 2024-11-20", now: wed, from: "2024-11-13", to: "2024-11-21", label: "Wed Nov 13–Wed Nov 20"},
+		{msg: "from 2024-11-20 to 2024-11-22", now: wed, from: "2024-11-20", to: "2024-11-23", label: "Wed Nov 20–Fri Nov 22"},
+		{msg: "from 2024-10-01 to 2024-10-05", now: wed},
+		// "Tomorrow" is the request timezone's: 03:00 UTC on the 14th is
+		// still the 13th in EST.
+		{msg: "tomorrow", now: time.Date(2024, 11, 14, 3, 0, 0, 0, time.UTC), loc: est, from: "2024-11-14", to: "2024-11-15", label: "tomorrow"},
+		{msg: "what's scheduled at the stadium kiosks", now: wed},
+	}
+	for _, tc := range cases {
+		from, to, label, ok := futureWindow(tc.msg, tc.now, tc.loc)
+		if tc.from == "" {
+			if ok {
+				t.Errorf("futureWindow(%q) = %v – %v (%s), want no window", tc.msg, from, to, label)
+			}
+			continue
+		}
+		loc := tc.loc
+		if loc == nil {
+			loc = time.UTC
+		}
+		wantFrom, _ := time.ParseInLocation("2006-01-02", tc.from, loc)
+		wantTo, _ := time.ParseInLocation("2006-01-02", tc.to, loc)
+		if !ok || !from.Equal(wantFrom) || !to.Equal(wantTo) || label != tc.label {
+			t.Errorf("futureWindow(%q) = %v – %v %q (%v), want %v – %v %q", tc.msg, from, to, label, ok, wantFrom, wantTo, tc.label)
+		}
+	}
+}
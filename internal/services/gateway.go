@@ -2,9 +2,11 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
@@ -13,6 +15,7 @@ import (
 	"net/textproto"
 	"os"
 	"strings"
+	"time"
 )
 
 func gwDebugEnabled() bool {
@@ -29,8 +32,82 @@ func gwDebugLogf(format string, args ...any) {
 
 type GatewayClient struct {
 	BaseURL string
-	APIKey  string
-	HTTP    *http.Client
+	// APIKey is used when Credentials is nil.
+	APIKey string
+	// Credentials, when set, supplies rotatable primary and secondary keys.
+	Credentials *GatewayCredentials
+	HTTP        *http.Client
+	// Breaker, when set, is shared by every caller of this client and
+	// short-circuits requests with ErrGatewayUnavailable during outages.
+	Breaker *CircuitBreaker
+	// ETags, when set, revalidates repeat GETs with If-None-Match and
+	// answers a 304 from the cached body.
+	ETags *ETagCache
+}
+
+func (c *GatewayClient) keys() []string {
+	if c.Credentials == nil {
+		return []string{c.APIKey}
+	}
+	return c.Credentials.Keys()
+}
+
+// do sends req with the current API key, retrying once with the secondary
+// key when the primary gets a 401. A 401 for every key is ErrGatewayAuth.
+func (c *GatewayClient) do(req *http.Request) (*http.Response, error) {
+	keys := c.keys()
+	for i, key := range keys {
+		if i > 0 {
+			if req.Body != nil && req.GetBody == nil {
+				break
+			}
+			retry := req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				retry.Body = body
+			}
+			req = retry
+			gwDebugLogf("gateway %s %s -> 401, retrying with secondary key", req.Method, req.URL)
+		}
+		req.Header.Set("X-API-Key", key)
+		resp, err := c.send(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			return resp, nil
+		}
+		resp.Body.Close()
+	}
+	return nil, ErrGatewayAuth
+}
+
+// send issues req through the circuit breaker. Network errors and 5xx
+// responses count as failures; a cancelled or expired caller context counts as
+// neither, since it says nothing about the gateway.
+func (c *GatewayClient) send(req *http.Request) (*http.Response, error) {
+	if err := c.Breaker.Allow(); err != nil {
+		gwDebugLogf("gateway %s %s -> circuit open", req.Method, req.URL)
+		return nil, err
+	}
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		if req.Context().Err() != nil {
+			c.Breaker.Abandon()
+		} else {
+			c.Breaker.Record(true)
+		}
+		return nil, err
+	}
+	c.Breaker.Record(resp.StatusCode >= 500)
+	return resp, nil
 }
 
 func (c *GatewayClient) buildURL(path string) (string, error) {
@@ -44,32 +121,69 @@ func (c *GatewayClient) buildURL(path string) (string, error) {
 	return base + path, nil
 }
 
-func (c *GatewayClient) Get(path string) (int, []byte, error) {
+// Get issues a GET against the tool gateway. The request is bound to ctx so
+// aggregation loops stop paginating once the caller goes away. With ETags
+// set, a 304 is returned as 200 with the cached body.
+func (c *GatewayClient) Get(ctx context.Context, path string) (int, []byte, error) {
 	u, err := c.buildURL(path)
 	if err != nil {
 		return 0, nil, err
 	}
 
 	gwDebugLogf("gateway %s %s", http.MethodGet, u)
-	req, err := http.NewRequest(http.MethodGet, u, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	if err != nil {
 		return 0, nil, err
 	}
-	req.Header.Set("X-API-Key", c.APIKey)
 	req.Header.Set("Accept", "application/json")
-
-	hc := c.HTTP
-	if hc == nil {
-		hc = http.DefaultClient
+	cacheable := c.ETags.cacheable(path)
+	var cached []byte
+	if cacheable {
+		if etag, body, ok := c.ETags.get(path); ok {
+			req.Header.Set("If-None-Match", etag)
+			cached = body
+		}
 	}
-	resp, err := hc.Do(req)
+
+	start := time.Now()
+	defer stageTimerFrom(ctx).since(stageGateway, start)
+	resp, err := c.do(req)
 	if err != nil {
 		gwDebugLogf("gateway %s %s -> err=%v", http.MethodGet, u, err)
+		if d := debugFrom(ctx); d != nil {
+			d.call(http.MethodGet, redactedPath(c.BaseURL, u), nil, 0, nil, err, start)
+		}
 		return 0, nil, err
 	}
 	defer resp.Body.Close()
 	b, _ := io.ReadAll(resp.Body)
 	gwDebugLogf("gateway %s %s -> status=%d bytes=%d", http.MethodGet, u, resp.StatusCode, len(b))
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		// The debug bundle keeps the 304 the gateway sent, with the body
+		// it stood for; callers see the 200 they asked for.
+		if d := debugFrom(ctx); d != nil {
+			d.call(http.MethodGet, redactedPath(c.BaseURL, u), nil, resp.StatusCode, cached, nil, start)
+		}
+		c.ETags.touch(path)
+		stepSpillFrom(ctx).record(cached)
+		cacheHitsFrom(ctx).record(cached)
+		freshnessFrom(ctx).record(path, resp.Header, cached)
+		return http.StatusOK, cached, nil
+	}
+	if cacheable && resp.StatusCode == http.StatusOK {
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			c.ETags.put(path, etag, b)
+		} else {
+			c.ETags.drop(path)
+		}
+	}
+	if d := debugFrom(ctx); d != nil {
+		d.call(http.MethodGet, redactedPath(c.BaseURL, u), nil, resp.StatusCode, b, nil, start)
+	}
+	stepSpillFrom(ctx).record(b)
+	if resp.StatusCode == http.StatusOK {
+		freshnessFrom(ctx).record(path, resp.Header, b)
+	}
 	return resp.StatusCode, b, nil
 }
 
@@ -85,7 +199,7 @@ type MultipartPayload struct {
 	Files  []MultipartFile     `json:"files"`
 }
 
-func (c *GatewayClient) DoMultipart(method, path string, query map[string]string, payload MultipartPayload) (int, []byte, error) {
+func (c *GatewayClient) DoMultipart(ctx context.Context, method, path string, query map[string]string, payload MultipartPayload) (int, []byte, error) {
 	u, err := c.buildURL(path)
 	if err != nil {
 		return 0, nil, err
@@ -146,30 +260,34 @@ func (c *GatewayClient) DoMultipart(method, path string, query map[string]string
 		return 0, nil, err
 	}
 
-	req, err := http.NewRequest(strings.ToUpper(method), u, &buf)
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(method), u, &buf)
 	if err != nil {
 		return 0, nil, err
 	}
-	req.Header.Set("X-API-Key", c.APIKey)
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Content-Type", mw.FormDataContentType())
 
-	hc := c.HTTP
-	if hc == nil {
-		hc = http.DefaultClient
-	}
-	resp, err := hc.Do(req)
+	start := time.Now()
+	defer stageTimerFrom(ctx).since(stageGateway, start)
+	resp, err := c.do(req)
 	if err != nil {
 		gwDebugLogf("gateway %s %s -> err=%v", strings.ToUpper(strings.TrimSpace(method)), u, err)
+		if d := debugFrom(ctx); d != nil {
+			d.call(strings.ToUpper(method), redactedPath(c.BaseURL, u), multipartSummary(payload), 0, nil, err, start)
+		}
 		return 0, nil, err
 	}
 	defer resp.Body.Close()
 	b, _ := io.ReadAll(resp.Body)
 	gwDebugLogf("gateway %s %s -> status=%d bytes=%d", strings.ToUpper(strings.TrimSpace(method)), u, resp.StatusCode, len(b))
+	if d := debugFrom(ctx); d != nil {
+		d.call(strings.ToUpper(method), redactedPath(c.BaseURL, u), multipartSummary(payload), resp.StatusCode, b, nil, start)
+	}
+	stepSpillFrom(ctx).record(b)
 	return resp.StatusCode, b, nil
 }
 
-func (c *GatewayClient) DoJSON(method, path string, query map[string]string, body any) (int, []byte, error) {
+func (c *GatewayClient) DoJSON(ctx context.Context, method, path string, query map[string]string, body any) (int, []byte, error) {
 	u, err := c.buildURL(path)
 	if err != nil {
 		return 0, nil, err
@@ -194,27 +312,45 @@ func (c *GatewayClient) DoJSON(method, path string, query map[string]string, bod
 		rbody = bytes.NewReader(b)
 	}
 
-	req, err := http.NewRequest(strings.ToUpper(method), u, rbody)
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(method), u, rbody)
 	if err != nil {
 		return 0, nil, err
 	}
-	req.Header.Set("X-API-Key", c.APIKey)
 	req.Header.Set("Accept", "application/json")
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
 
-	hc := c.HTTP
-	if hc == nil {
-		hc = http.DefaultClient
-	}
-	resp, err := hc.Do(req)
+	start := time.Now()
+	defer stageTimerFrom(ctx).since(stageGateway, start)
+	resp, err := c.do(req)
 	if err != nil {
 		gwDebugLogf("gateway %s %s -> err=%v", strings.ToUpper(strings.TrimSpace(method)), u, err)
+		if d := debugFrom(ctx); d != nil {
+			d.call(strings.ToUpper(method), redactedPath(c.BaseURL, u), summarizeMutationBody(body), 0, nil, err, start)
+		}
 		return 0, nil, err
 	}
 	defer resp.Body.Close()
 	b, _ := io.ReadAll(resp.Body)
 	gwDebugLogf("gateway %s %s -> status=%d bytes=%d", strings.ToUpper(strings.TrimSpace(method)), u, resp.StatusCode, len(b))
+	if d := debugFrom(ctx); d != nil {
+		d.call(strings.ToUpper(method), redactedPath(c.BaseURL, u), summarizeMutationBody(body), resp.StatusCode, b, nil, start)
+	}
+	stepSpillFrom(ctx).record(b)
 	return resp.StatusCode, b, nil
 }
+
+// multipartSummary describes a multipart payload for debug capture: field
+// values are summarized and files reduced to name, type and size.
+func multipartSummary(p MultipartPayload) any {
+	fields := map[string]any{}
+	for k, vs := range p.Fields {
+		fields[k] = summarizeMutationBody(vs)
+	}
+	files := make([]string, 0, len(p.Files))
+	for _, f := range p.Files {
+		files = append(files, fmt.Sprintf("%s (%s, %d bytes)", f.FileName, f.ContentType, base64DecodedLen(strings.TrimSpace(f.Base64))))
+	}
+	return map[string]any{"fields": fields, "files": files}
+}
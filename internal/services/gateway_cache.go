@@ -0,0 +1,221 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// defaultETagExclude are the gateway path prefixes whose responses change
+// too often for revalidation to pay off.
+var defaultETagExclude = []string{"/pop", "/metrics"}
+
+// ETagCache keeps the last body and ETag of gateway GETs by path and query,
+// so a repeat request can be sent with If-None-Match and a 304 answered
+// from memory. Entries the gateway has not confirmed within MaxAge are
+// dropped rather than revalidated; the least recently used are evicted to
+// stay under MaxBytes.
+type ETagCache struct {
+	MaxBytes int64
+	MaxAge   time.Duration
+	// Exclude lists path prefixes that are never cached.
+	Exclude []string
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	bytes   int64
+}
+
+type etagEntry struct {
+	key  string
+	etag string
+	body []byte
+	at   time.Time
+}
+
+func NewETagCache(maxBytes int64, maxAge time.Duration, exclude []string) *ETagCache {
+	if exclude == nil {
+		exclude = defaultETagExclude
+	}
+	return &ETagCache{MaxBytes: maxBytes, MaxAge: maxAge, Exclude: exclude}
+}
+
+// cacheable reports whether GETs of path are cached.
+func (c *ETagCache) cacheable(path string) bool {
+	if c == nil || c.MaxBytes <= 0 {
+		return false
+	}
+	p, _, _ := strings.Cut(path, "?")
+	for _, prefix := range c.Exclude {
+		if p == prefix || strings.HasPrefix(p, strings.TrimRight(prefix, "/")+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// get returns the ETag and body stored for key.
+func (c *ETagCache) get(key string) (string, []byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return "", nil, false
+	}
+	e := el.Value.(*etagEntry)
+	if c.MaxAge > 0 && time.Since(e.at) > c.MaxAge {
+		c.remove(el)
+		return "", nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e.etag, e.body, true
+}
+
+// put stores body under key, evicting the least recently used entries to
+// make room. A body larger than the whole cache is not stored.
+func (c *ETagCache) put(key, etag string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	size := int64(len(body))
+	if size > c.MaxBytes {
+		return
+	}
+	if c.entries == nil {
+		c.entries = map[string]*list.Element{}
+		c.lru = list.New()
+	}
+	for c.bytes+size > c.MaxBytes && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	c.entries[key] = c.lru.PushFront(&etagEntry{key: key, etag: etag, body: body, at: time.Now()})
+	c.bytes += size
+}
+
+// touch restarts an entry's max age after the gateway confirmed it.
+func (c *ETagCache) touch(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*etagEntry).at = time.Now()
+	}
+}
+
+// drop forgets key, for a response that came back without an ETag.
+func (c *ETagCache) drop(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *ETagCache) remove(el *list.Element) {
+	e := el.Value.(*etagEntry)
+	c.lru.Remove(el)
+	delete(c.entries, e.key)
+	c.bytes -= int64(len(e.body))
+}
+
+// Flush empties the cache.
+func (c *ETagCache) Flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries, c.lru, c.bytes = nil, nil, 0
+}
+
+// snapshot returns the cached keys and when the oldest was stored.
+func (c *ETagCache) snapshot() ([]string, time.Time) {
+	if c == nil {
+		return nil, time.Time{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.entries))
+	oldest := time.Time{}
+	for k, el := range c.entries {
+		keys = append(keys, k)
+		if at := el.Value.(*etagEntry).at; oldest.IsZero() || at.Before(oldest) {
+			oldest = at
+		}
+	}
+	sort.Strings(keys)
+	return keys, oldest
+}
+
+// cacheHits collects, for one request, the step excerpts of gateway bodies
+// served from the ETag cache, so the steps built from them can be flagged.
+// It travels in the request context like stepSpill; methods are safe on a
+// nil receiver.
+type cacheHits struct {
+	mu       sync.Mutex
+	clip     int
+	excerpts map[string]bool
+}
+
+type cacheHitsKey struct{}
+
+func withCacheHits(ctx context.Context, h *cacheHits) context.Context {
+	return context.WithValue(ctx, cacheHitsKey{}, h)
+}
+
+func cacheHitsFrom(ctx context.Context) *cacheHits {
+	h, _ := ctx.Value(cacheHitsKey{}).(*cacheHits)
+	return h
+}
+
+func (h *cacheHits) record(body []byte) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.excerpts[clipString(strings.TrimSpace(string(body)), h.clip)] = true
+}
+
+// newCacheHits returns a collector for one request, or nil when the gateway
+// does not cache.
+func (c *ChatService) newCacheHits() *cacheHits {
+	if c.Gateway == nil || c.Gateway.ETags == nil {
+		return nil
+	}
+	return &cacheHits{clip: c.limits().StepBodyClip, excerpts: map[string]bool{}}
+}
+
+// markCachedSteps flags the steps of resp whose body was served from the
+// ETag cache.
+func markCachedSteps(h *cacheHits, resp models.ChatResponse) models.ChatResponse {
+	if h == nil || len(resp.Steps) == 0 {
+		return resp
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.excerpts) == 0 {
+		return resp
+	}
+	var steps []models.Step
+	for i, st := range resp.Steps {
+		if st.Body == "" || !h.excerpts[st.Body] {
+			continue
+		}
+		if steps == nil {
+			steps = append([]models.Step(nil), resp.Steps...)
+		}
+		steps[i].Cached = true
+	}
+	if steps != nil {
+		resp.Steps = steps
+	}
+	return resp
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrGatewayAuth is returned by GatewayClient when the tool gateway rejects
+// every configured API key with 401.
+var ErrGatewayAuth = errors.New("the data gateway rejected the configured API key")
+
+// GatewayCredentials holds the tool gateway API keys shared by GatewayClient
+// and ToolCatalog. Keys are swapped atomically: a request reads them once when
+// it is sent, so in-flight requests finish with the old key and later ones use
+// the new key. The secondary key is tried when the primary gets a 401, which
+// keeps a rotation safe while the gateway and this service disagree.
+type GatewayCredentials struct {
+	// Load re-reads the keys for Reload, from the key file or the environment.
+	Load func() (primary, secondary string, err error)
+	// File, when set, is polled by Watch and reloaded when it changes.
+	File string
+
+	mu        sync.RWMutex
+	primary   string
+	secondary string
+	modTime   time.Time
+}
+
+func NewGatewayCredentials(primary, secondary string) *GatewayCredentials {
+	k := &GatewayCredentials{}
+	k.Set(primary, secondary)
+	return k
+}
+
+// Keys returns the keys to try in order: the primary, then the secondary when
+// it is set and differs.
+func (k *GatewayCredentials) Keys() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.secondary == "" || k.secondary == k.primary {
+		return []string{k.primary}
+	}
+	return []string{k.primary, k.secondary}
+}
+
+func (k *GatewayCredentials) Set(primary, secondary string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.primary = strings.TrimSpace(primary)
+	k.secondary = strings.TrimSpace(secondary)
+}
+
+// Reload re-reads the keys through Load and swaps them in. The current keys
+// stay in place when the new primary is missing.
+func (k *GatewayCredentials) Reload() error {
+	if k.Load == nil {
+		return errors.New("credential reload is not configured")
+	}
+	primary, secondary, err := k.Load()
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(primary) == "" {
+		return errors.New("reloaded credentials have no primary key")
+	}
+	k.Set(primary, secondary)
+	return nil
+}
+
+// Watch polls File every interval (default 30s) and reloads the keys when its
+// modification time changes.
+func (k *GatewayCredentials) Watch(ctx context.Context, interval time.Duration) {
+	if strings.TrimSpace(k.File) == "" {
+		return
+	}
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	if fi, err := os.Stat(k.File); err == nil {
+		k.modTime = fi.ModTime()
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			fi, err := os.Stat(k.File)
+			if err != nil {
+				log.Printf("gateway credentials: %v", err)
+				continue
+			}
+			if fi.ModTime().Equal(k.modTime) {
+				continue
+			}
+			if err := k.Reload(); err != nil {
+				log.Printf("gateway credentials: reload failed, keeping current keys: %v", err)
+				continue
+			}
+			k.modTime = fi.ModTime()
+			log.Printf("gateway credentials: reloaded from %s", k.File)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// rotationQuestion reads /pop directly; a refused region list only makes
+// city-scoped questions ask for a city.
+var rotationQuestion = "show pop for poster id " + testBetID
+
+func TestGatewayKeyFallback(t *testing.T) {
+	cases := []struct {
+		name               string
+		primary, secondary string
+		accept             []string
+		wantKeys           []string
+		wantErr            error
+	}{
+		{name: "primary accepted", primary: "new", secondary: "old", accept: []string{"new"}, wantKeys: []string{"new"}},
+		{name: "secondary on 401", primary: "new", secondary: "old", accept: []string{"old"}, wantKeys: []string{"new", "old"}},
+		{name: "both rejected", primary: "new", secondary: "old", accept: []string{"other"}, wantKeys: []string{"new", "old"}, wantErr: ErrGatewayAuth},
+		{name: "no secondary", primary: "new", accept: []string{"old"}, wantKeys: []string{"new"}, wantErr: ErrGatewayAuth},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newFakeGateway(t, &fakeGateway{Devices: testDevices})
+			g.Accept(tc.accept...)
+			gw := &GatewayClient{BaseURL: g.srv.URL, Credentials: NewGatewayCredentials(tc.primary, tc.secondary)}
+			status, _, err := gw.Get(context.Background(), "/ads/devices")
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tc.wantErr)
+			}
+			if err == nil && status != 200 {
+				t.Errorf("status = %d", status)
+			}
+			if got := g.Keys(); strings.Join(got, ",") != strings.Join(tc.wantKeys, ",") {
+				t.Errorf("keys sent = %v, want %v", got, tc.wantKeys)
+			}
+		})
+	}
+}
+
+// A rotation under load: the gateway accepts both keys while it overlaps,
+// the service reloads the new key mid-traffic, and the gateway then drops the
+// old one. No request fails, and once the reload returns every request
+// leads with the new key.
+func TestGatewayKeyRotation(t *testing.T) {
+	g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})
+	c := newTestChat(g)
+	creds := NewGatewayCredentials("old", "")
+	var mu sync.Mutex
+	next := [2]string{"old", ""}
+	creds.Load = func() (string, string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return next[0], next[1], nil
+	}
+	c.Gateway = &GatewayClient{BaseURL: g.srv.URL, Credentials: creds}
+	ctx := context.Background()
+	want := chatOnce(t, c, rotationQuestion).Answer
+
+	ask := func() models.ChatResponse {
+		resp, err := c.Chat(ctx, "owner-a", models.ChatRequest{Message: rotationQuestion})
+		if err != nil {
+			t.Errorf("chat: %v", err)
+		}
+		if resp.Error == nil && resp.Answer != want {
+			t.Errorf("answered\n%s\nwant\n%s", resp.Answer, want)
+		}
+		return resp
+	}
+
+	g.Accept("old", "new")
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if resp := ask(); resp.Error != nil {
+					t.Errorf("request failed during the overlap: %+v", resp.Error)
+					return
+				}
+			}
+		}()
+	}
+	sent := len(g.Keys())
+	waitFor(t, "traffic with the old key", func() bool { return len(g.Keys()) > sent })
+	mu.Lock()
+	next = [2]string{"new", "old"}
+	mu.Unlock()
+	if err := creds.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	close(stop)
+	wg.Wait()
+
+	g.Accept("new")
+	before := len(g.Keys())
+	if resp := ask(); resp.Error != nil {
+		t.Fatalf("request after the gateway dropped the old key: %+v", resp.Error)
+	}
+	for _, k := range g.Keys()[before:] {
+		if k != "new" {
+			t.Errorf("sent %q after the rotation, want only the new key", k)
+		}
+	}
+}
+
+// Once every key is refused, the answer carries the structured auth error.
+func TestGatewayAuthError(t *testing.T) {
+	g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})
+	c := newTestChat(g)
+	c.Gateway.Credentials = NewGatewayCredentials("new", "old")
+	g.Accept("revoked")
+	resp, err := c.Chat(context.Background(), "owner-a", models.ChatRequest{Message: rotationQuestion})
+	if err != nil && !errors.Is(err, ErrGatewayAuth) {
+		t.Fatalf("err = %v", err)
+	}
+	if resp.Error == nil || resp.Error.Code != "gateway_auth_failed" || resp.Error.Retryable {
+		t.Errorf("error = %+v, want a non-retryable gateway_auth_failed", resp.Error)
+	}
+	if !strings.Contains(resp.Answer, ErrGatewayAuth.Error()) {
+		t.Errorf("answer %q does not say the key was rejected", resp.Answer)
+	}
+	if got := g.Keys(); got[len(got)-2] != "new" || got[len(got)-1] != "old" {
+		t.Errorf("keys sent = %v, want the primary then the secondary", got)
+	}
+}
+
+func TestGatewayCredentialsReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "gateway.key")
+	write := func(content string, mod time.Time) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("old\n", time.Now().Add(-time.Hour))
+	creds := NewGatewayCredentials("old", "")
+	creds.File = file
+	creds.Load = func() (string, string, error) {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", "", err
+		}
+		primary, secondary, _ := strings.Cut(string(b), "\n")
+		return primary, secondary, nil
+	}
+
+	// An empty file keeps the current keys.
+	write("", time.Now().Add(-time.Hour))
+	if err := creds.Reload(); err == nil {
+		t.Error("reloaded an empty key file")
+	}
+	if got := creds.Keys(); len(got) != 1 || got[0] != "old" {
+		t.Errorf("keys = %v after a failed reload, want [old]", got)
+	}
+	if err := (&GatewayCredentials{}).Reload(); err == nil {
+		t.Error("reload without a loader succeeded")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go creds.Watch(ctx, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	write(" new \nold\n", time.Now())
+	waitFor(t, "the watcher to reload the key file", func() bool { return creds.Keys()[0] == "new" })
+	if got := creds.Keys(); len(got) != 2 || got[1] != "old" {
+		t.Errorf("keys = %v, want [new old]", got)
+	}
+}
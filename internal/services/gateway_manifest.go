@@ -0,0 +1,33 @@
+package services
+
+// gatewayExpectation is a gateway GET endpoint the deterministic handlers
+// read and the response fields they rely on. The schema drift detector
+// checks each against the gateway's OpenAPI spec; fields are matched by name
+// anywhere in the response schema.
+type gatewayExpectation struct {
+	Path   string
+	Fields []string
+}
+
+// gatewayManifest lists what the handlers expect from the gateway. Keep it in
+// step with the structs and map keys they decode: a handler that starts
+// reading a new field should add it here.
+var gatewayManifest = []gatewayExpectation{
+	// popItem, popListResponse and gatewayPagination.
+	{Path: "/pop", Fields: []string{"items", "poster_id", "poster_name", "host_name", "kiosk_name", "pop_datetime", "play_count", "has_more"}},
+	{Path: "/pop/stats", Fields: []string{"items", "Key", "Metric"}},
+	// Low uptime, coverage and the alert evaluator's alertMetricRow.
+	{Path: "/metrics/latest", Fields: []string{"data", "server_id", "time", "uptime", "city", "region", "has_more"}},
+	// Baselines and trend hints also read the location and daily network
+	// counters.
+	{Path: "/metrics/history", Fields: []string{"data", "server_id", "time", "cpu", "memory", "disk", "temperature", "city", "region", "net_daily_rx_bytes", "net_daily_tx_bytes", "has_more"}},
+	{Path: "/metrics/servers/status/city", Fields: []string{"data", "city", "online", "offline", "total"}},
+	// Device inventory (fetchDeviceInventory) and the city/region lists.
+	{Path: "/ads/devices", Fields: []string{"city", "region", "kiosk_name", "has_more"}},
+	{Path: "/ads/devices/counts/regions", Fields: []string{"data", "city", "region"}},
+	{Path: "/ads/campaigns", Fields: []string{"id", "name"}},
+	{Path: "/ads/campaigns/search", Fields: []string{"id", "name"}},
+	{Path: "/ads/campaigns/{id}/impressions", Fields: []string{"impressions", "posters", "poster_id", "poster_name"}},
+	{Path: "/ads/venues", Fields: []string{"id", "name"}},
+	{Path: "/ads/venues/{id}/devices", Fields: []string{"data", "name", "host_name"}},
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"openai-agent-service/internal/models"
+)
+
+// ErrUnknownHandler is returned when a flag names no registered handler.
+var ErrUnknownHandler = errors.New("unknown handler")
+
+// chatHandler is the shape chatStream dispatches deterministic handlers in.
+type chatHandler func(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error)
+
+// ownerHandler is a handler that also needs the caller's API key.
+type ownerHandler func(ctx context.Context, ownerKey string, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error)
+
+func withOwner(ownerKey string, h ownerHandler) chatHandler {
+	return func(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+		return h(ctx, ownerKey, req, onToken)
+	}
+}
+
+// skipHandler stands in for a disabled handler: it never answers.
+func skipHandler(context.Context, models.ChatRequest, func(string)) (models.ChatResponse, bool, error) {
+	return models.ChatResponse{}, false, nil
+}
+
+// HandlerNames are the registered names of the deterministic handlers, in
+// dispatch order. They are the method names, which saved queries and debug
+// bundles already record, so they must not be renamed lightly.
+var HandlerNames = []string{
+	"handleEntityAliases",
+	"handleForgetContext",
+	"handleMemoryProvenance",
+	"handleFeedback",
+	"handleDeviceNotes",
+	"handleTelemetryThresholds",
+	"handleSavedQueries",
+	"handleConversationSummary",
+	"handleDeviceCommand",
+	"handleDrillDown",
+	"handleCampaignFollowUp",
+	"handleCampaignCreate",
+	"handleAlertRules",
+	"handlePlayTargets",
+	"handleCampaignDeviceDiff",
+	"handleChurn",
+	"handlePopExport",
+	"handlePeriodComparison",
+	"handleActivePosters",
+	"handlePosterIDLookup",
+	"handleCreativeReuse",
+	"handleTelemetryCoverage",
+	"handleDeviceSchedule",
+	"handleHostPatternSummary",
+	"handlePosterCoPlay",
+	"handleHourlyDistribution",
+	"handlePopPattern",
+	"handlePosterFootprint",
+	"handleCampaignBudget",
+	"handleCampaignPacing",
+	"handleTopPostersFromCity",
+	"handlePopKioskWiseFollowup",
+	"handleTopDevicesFromCity",
+	"handlePosterAnalyticsByID",
+	"handlePosterMonthData",
+	"handlePosterPlayCount",
+	"handlePopForPosterID",
+	"handleKioskPosterPlayCount",
+	"handleMetricsLatestByLocationDetails",
+	"handleKioskCountFromCity",
+	"handlePopByHostForDate",
+	"handlePopStatsGeneric",
+	"handleVenueLeaderboard",
+	"handleVenueDevices",
+	"handleDeviceVenues",
+	"handleVenueSearchList",
+	"handleLowUptimeDevices",
+	"handleDeviceDetails",
+	"handleTelemetryBaseline",
+	"handleDeviceTelemetry",
+	"handleCreativesByStatus",
+	"handleCampaignCreatives",
+	"handleCreativeUpload",
+	"handlePosterDetails",
+}
+
+// IsHandlerName reports whether name is a registered handler.
+func IsHandlerName(name string) bool {
+	for _, n := range HandlerNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// handlerFlags holds runtime overrides of HandlerFlags and per-handler hit
+// counts (requests the handler answered on this instance).
+type handlerFlags struct {
+	mu       sync.Mutex
+	override map[string]bool
+	hits     map[string]int64
+}
+
+func (c *ChatService) handlerEnabled(name string) bool {
+	c.flags.mu.Lock()
+	defer c.flags.mu.Unlock()
+	if on, ok := c.flags.override[name]; ok {
+		return on
+	}
+	if on, ok := c.HandlerFlags[name]; ok {
+		return on
+	}
+	return true
+}
+
+// handler returns h wrapped to count its answers, or skipHandler when the
+// handler is disabled so the question falls through to the next one and
+// eventually the model.
+func (c *ChatService) handler(name string, h chatHandler) chatHandler {
+	if !c.handlerEnabled(name) {
+		debugLogf("handler %s disabled, skipped", name)
+		return skipHandler
+	}
+	return func(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+		resp, handled, err := h(ctx, req, onToken)
+		if handled {
+			c.flags.mu.Lock()
+			if c.flags.hits == nil {
+				c.flags.hits = map[string]int64{}
+			}
+			c.flags.hits[name]++
+			c.flags.mu.Unlock()
+		}
+		return resp, handled, err
+	}
+}
+
+// SetHandlerEnabled turns a handler on or off on this instance until the
+// next restart, which goes back to HandlerFlags.
+func (c *ChatService) SetHandlerEnabled(name string, enabled bool) error {
+	if !IsHandlerName(name) {
+		return ErrUnknownHandler
+	}
+	c.flags.mu.Lock()
+	defer c.flags.mu.Unlock()
+	if c.flags.override == nil {
+		c.flags.override = map[string]bool{}
+	}
+	c.flags.override[name] = enabled
+	return nil
+}
+
+// HandlerStates lists every registered handler with its flag and hit count.
+func (c *ChatService) HandlerStates() []models.HandlerState {
+	out := make([]models.HandlerState, 0, len(HandlerNames))
+	for _, name := range HandlerNames {
+		enabled := c.handlerEnabled(name)
+		c.flags.mu.Lock()
+		hits := c.flags.hits[name]
+		c.flags.mu.Unlock()
+		out = append(out, models.HandlerState{Name: name, Enabled: enabled, Hits: hits})
+	}
+	return out
+}
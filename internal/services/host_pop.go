@@ -0,0 +1,384 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// hostPopDay is the calendar day a per-host POP answer covers.
+type hostPopDay struct {
+	// From and To are the local day bounds, [From, To).
+	From, To time.Time
+	Label    string
+	// Phrase is the date text matched in the message; it is left out of the
+	// display-name lookup.
+	Phrase string
+	// Range asks the gateway for the from/to bounds. When it rejects them,
+	// days with Presets fall back to probing those; today skips the range and
+	// uses the gateway's own "today" preset.
+	Range   bool
+	Presets []string
+}
+
+// explicit reports whether the day was named by date rather than by "today"
+// or "yesterday".
+func (d hostPopDay) explicit() bool {
+	return len(d.Presets) == 0
+}
+
+var (
+	hostPopWeekdayRe = regexp.MustCompile(`\blast\s+(mon|tue|tues|wed|thu|thur|thurs|fri|sat|sun)(?:day|nesday|sday|urday)?\b`)
+	hostPopDaysAgoRe = regexp.MustCompile(`\b(\d{1,3}|a|one|two|three|four|five|six|seven)\s+days?\s+ago\b`)
+)
+
+var hostPopNumberWords = map[string]int{"a": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7}
+
+// parseHostPopDay resolves the day of a per-host POP question in the request
+// timezone: "today", "yesterday", "2026-10-14", "October 14", "last Tuesday"
+// or "3 days ago". A date without a year is its latest occurrence, and "last
+// Tuesday" is the most recent Tuesday before today.
+func parseHostPopDay(req models.ChatRequest, msgLower string, now time.Time) (hostPopDay, bool) {
+	loc := requestLocation(req)
+	local := now.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	on := func(d time.Time, phrase string) hostPopDay {
+		return hostPopDay{From: d, To: d.AddDate(0, 0, 1), Label: d.Format("Mon Jan 2, 2006"), Phrase: phrase, Range: true}
+	}
+	yesterday := func(phrase string) hostPopDay {
+		d := today.AddDate(0, 0, -1)
+		return hostPopDay{From: d, To: today, Label: say(req, "pop_day_yesterday", d.Format("Mon Jan 2")), Phrase: phrase, Range: true,
+			Presets: []string{"yesterday", "previous_day", "prev_day", "last_day"}}
+	}
+
+	switch {
+	case strings.Contains(msgLower, "today") || strings.Contains(msgLower, "current_day"):
+		return hostPopDay{From: today, To: today.AddDate(0, 0, 1), Label: say(req, "pop_day_today", today.Format("Mon Jan 2")), Phrase: "today", Presets: []string{"today"}}, true
+	case strings.Contains(msgLower, "yesterday"):
+		return yesterday("yesterday"), true
+	}
+	if s := flightDateRe.FindString(msgLower); s != "" {
+		if t, ok := parseFlightDate(s, local); ok {
+			d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+			if !yearRe.MatchString(s) && d.After(today) {
+				d = d.AddDate(-1, 0, 0)
+			}
+			return on(d, s), true
+		}
+	}
+	if m := hostPopWeekdayRe.FindStringSubmatch(msgLower); m != nil {
+		for wd := time.Sunday; wd <= time.Saturday; wd++ {
+			if strings.HasPrefix(strings.ToLower(wd.String()), m[1][:3]) {
+				back := (int(today.Weekday()) - int(wd) + 7) % 7
+				if back == 0 {
+					back = 7
+				}
+				return on(today.AddDate(0, 0, -back), m[0]), true
+			}
+		}
+	}
+	if m := hostPopDaysAgoRe.FindStringSubmatch(msgLower); m != nil {
+		n, ok := hostPopNumberWords[m[1]]
+		if !ok {
+			n, _ = strconv.Atoi(m[1])
+		}
+		switch {
+		case n == 1:
+			return yesterday(m[0]), true
+		case n > 1:
+			return on(today.AddDate(0, 0, -n), m[0]), true
+		}
+	}
+	return hostPopDay{}, false
+}
+
+// handlePopByHostForDate lists a host's POP for one day: "pop for briggs-001
+// yesterday", "pop for briggs-001 on October 14", "pop for briggs-001 last
+// Tuesday". "stats for <device>" and a bare "show pop" follow-up mean today,
+// as does a daypart without a day ("pop for briggs-001 during evening rush"),
+// which limits the day's rows to that window.
+func (c *ChatService) handlePopByHostForDate(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	isPop := strings.Contains(msgLower, "pop") || strings.Contains(msgLower, "stats")
+	isStatsForDevice := strings.Contains(msgLower, "stats") && (strings.Contains(msgLower, "device") || strings.Contains(msgLower, "kiosk") || strings.Contains(msgLower, "server"))
+	// Follow-up like "show pop" after a device info query: reuse last resolved host.
+	msgTrim := strings.TrimSpace(msgLower)
+	isShowPopFollowup := msgTrim == "pop" || msgTrim == "show pop" || msgTrim == "show me pop" || msgTrim == "show the pop" || msgTrim == "get pop" || msgTrim == "get me pop"
+	if !isPop {
+		return models.ChatResponse{}, false, nil
+	}
+	daypart, msgNoDaypart := c.parseDaypart(req.Message)
+	req.Message = msgNoDaypart
+	msgLower = strings.ToLower(msgNoDaypart)
+	now := time.Now()
+	day, dated := parseHostPopDay(req, msgLower, now)
+	daypartOnly := false
+	if !dated {
+		if !isStatsForDevice && !isShowPopFollowup && daypart == nil {
+			return models.ChatResponse{}, false, nil
+		}
+		daypartOnly = !isStatsForDevice && !isShowPopFollowup
+		if daypartOnly && strings.Contains(msgLower, "poster") {
+			return models.ChatResponse{}, false, nil
+		}
+		day, _ = parseHostPopDay(req, "today", now)
+	}
+	showMinutes, unitNote := c.minutesPreference(req.ConversationID, msgLower)
+
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: say(req, "gateway_not_configured")}, true, nil
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	host, resolveStep := c.resolvePopHost(ctx, req, strings.Replace(msgLower, day.Phrase, "", 1))
+	if host == "" {
+		// Dated questions without a host are left to the city-wide handlers,
+		// as are poster questions that only name a daypart.
+		if day.explicit() || daypartOnly {
+			return models.ChatResponse{}, false, nil
+		}
+		return models.ChatResponse{Answer: say(req, "need_host")}, true, nil
+	}
+	if conversationID != "" {
+		c.updateConversationHost(conversationID, host)
+		c.clearPending(conversationID)
+	}
+	if day.From.After(now) {
+		return models.ChatResponse{Answer: say(req, "pop_day_future", day.Label)}, true, nil
+	}
+
+	pager := c.newPopPager()
+	items, steps, failure := c.fetchHostPop(ctx, req, host, day, pager)
+	if resolveStep != nil {
+		steps = append([]models.Step{*resolveStep}, steps...)
+	}
+	if failure != "" {
+		return models.ChatResponse{Answer: failure, Steps: steps}, true, nil
+	}
+	daypartNote := ""
+	if daypart != nil {
+		loc := requestLocation(req)
+		items = daypart.filter(items, loc)
+		daypartNote = "\n" + daypart.note(req, loc)
+	}
+	answer, ok := c.renderHostPop(req, host, day, items, showMinutes)
+	if !ok {
+		return models.ChatResponse{Answer: say(req, "pop_day_none", host, day.Label) + daypartNote, Steps: steps}, true, nil
+	}
+	if unitNote != "" {
+		answer += "\n" + unitNote
+	}
+	answer += daypartNote
+	answer = pager.note(answer)
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps, Meta: pager.meta()}, true, nil
+}
+
+// resolvePopHost finds the host a per-host POP question is about: a host
+// token in the message, then the conversation's host, then a kiosk display
+// name. msgLower should already have the date phrase removed.
+func (c *ChatService) resolvePopHost(ctx context.Context, req models.ChatRequest, msgLower string) (string, *models.Step) {
+	if tokens := detectHostTokens(req.Message); len(tokens) > 0 {
+		candidate := strings.ToLower(strings.TrimSpace(tokens[0]))
+		if parts := strings.Split(strings.ReplaceAll(candidate, "_", "-"), "-"); len(parts) >= 3 {
+			return candidate, nil
+		}
+	}
+	conversationID := strings.TrimSpace(req.ConversationID)
+	if conversationID != "" {
+		if st := c.getConversationState(conversationID); st != nil && strings.TrimSpace(st.Host) != "" {
+			return strings.ToLower(strings.TrimSpace(st.Host)), nil
+		}
+	}
+	// Try to extract the display name portion (avoid passing the whole sentence into the resolver).
+	lookup := ""
+	for _, kw := range []string{"stats for", "for", "of", "info", "details", "about"} {
+		if lookup = extractAfterKeyword(msgLower, kw); lookup != "" {
+			break
+		}
+	}
+	lookup = strings.TrimSpace(lookup)
+	if lookup == "" {
+		lookup = msgLower
+	}
+	if resolved, step := c.resolveHostFromDeviceName(ctx, conversationID, lookup); strings.TrimSpace(resolved) != "" {
+		return strings.ToLower(strings.TrimSpace(resolved)), step
+	}
+	if lookup != msgLower {
+		// Fallback: some phrasings may not extract cleanly; try resolving using the full message.
+		if resolved, step := c.resolveHostFromDeviceName(ctx, conversationID, msgLower); strings.TrimSpace(resolved) != "" {
+			return strings.ToLower(strings.TrimSpace(resolved)), step
+		}
+	}
+	return "", nil
+}
+
+// fetchHostPop pulls a host's POP rows for day. A non-empty failure is the
+// answer to give instead.
+func (c *ChatService) fetchHostPop(ctx context.Context, req models.ChatRequest, host string, day hostPopDay, pager *popPager) ([]popItem, []models.Step, string) {
+	steps := make([]models.Step, 0, 2)
+	base := "host_name=" + urlEscape(host)
+	if day.Range {
+		// UTC bounds keep the query deterministic across gateway timezones.
+		query := base + "&from=" + urlEscape(day.From.UTC().Format(time.RFC3339)) + "&to=" + urlEscape(day.To.UTC().Format(time.RFC3339))
+		items, status, _, failure := c.popPages(ctx, req, query, pager, &steps)
+		switch {
+		case failure != "":
+			return nil, steps, failure
+		case status >= 200 && status < 300:
+			return items, steps, ""
+		case status != http.StatusBadRequest:
+			return nil, steps, say(req, "pop_failed_status", status)
+		case day.explicit():
+			return nil, steps, say(req, "pop_no_date_range")
+		}
+		// The gateway doesn't support from/to filtering; fall back to presets.
+	}
+	items, failure := c.probePopPresets(ctx, req, base, day.Presets, pager, &steps)
+	return items, steps, failure
+}
+
+// probePopPresets fetches with the first preset the gateway accepts. Preset
+// names have differed across deployments, and an unknown one is answered with
+// 400 {"error":"invalid preset"}.
+func (c *ChatService) probePopPresets(ctx context.Context, req models.ChatRequest, base string, presets []string, pager *popPager, steps *[]models.Step) ([]popItem, string) {
+	for _, p := range presets {
+		items, status, body, failure := c.popPages(ctx, req, base+"&preset="+urlEscape(p), pager, steps)
+		if failure != "" {
+			return nil, failure
+		}
+		if status >= 200 && status < 300 {
+			return items, ""
+		}
+		if status == http.StatusBadRequest && strings.Contains(strings.ToLower(string(body)), "invalid preset") {
+			continue
+		}
+		return nil, say(req, "pop_failed_status", status)
+	}
+	return nil, say(req, "pop_no_preset", presets[0])
+}
+
+// popPages pages through /pop?<query>. A non-2xx first page is returned as
+// its status and body with no failure, so the caller can fall back; any other
+// failure is returned as the answer to give.
+func (c *ChatService) popPages(ctx context.Context, req models.ChatRequest, query string, pager *popPager, steps *[]models.Step) (items []popItem, status int, body []byte, failure string) {
+	items, err := c.scanPop(ctx, popQuery{Filter: query}, pager, steps)
+	if err == nil {
+		return items, http.StatusOK, nil, ""
+	}
+	var pe *popPageError
+	switch {
+	case !errors.As(err, &pe) || pe.Err != nil:
+		return nil, 0, nil, say(req, "pop_failed", err.Error())
+	case pe.Status != 0 && pe.Page == 1:
+		return nil, pe.Status, pe.Body, ""
+	case pe.Status != 0:
+		return nil, pe.Status, pe.Body, say(req, "pop_failed_status", pe.Status)
+	}
+	return nil, http.StatusOK, nil, say(req, "pop_unparsable")
+}
+
+// renderHostPop aggregates a host's POP rows by poster, busiest first, and
+// renders them under a header naming the day. It reports false when no row
+// names a poster.
+func (c *ChatService) renderHostPop(req models.ChatRequest, host string, day hostPopDay, items []popItem, showMinutes bool) (string, bool) {
+	type agg struct {
+		PosterID   string
+		PosterName string
+		PosterType string
+		PlayCount  int64
+		Value      int64
+		LastSeen   time.Time
+		KioskName  string
+		KioskLat   float64
+		KioskLong  float64
+	}
+	byPoster := map[string]*agg{}
+	for _, it := range items {
+		key := strings.TrimSpace(it.PosterID)
+		if key == "" {
+			key = strings.TrimSpace(it.PosterName)
+		}
+		if key == "" {
+			continue
+		}
+		a := byPoster[key]
+		if a == nil {
+			a = &agg{
+				PosterID:   strings.TrimSpace(it.PosterID),
+				PosterName: strings.TrimSpace(it.PosterName),
+				PosterType: strings.TrimSpace(it.PosterType),
+				KioskName:  strings.TrimSpace(it.KioskName),
+				KioskLat:   it.KioskLat,
+				KioskLong:  it.KioskLong,
+			}
+			byPoster[key] = a
+		}
+		a.PlayCount += it.PlayCount
+		a.Value += it.Value
+		if it.PopDatetime.After(a.LastSeen) {
+			a.LastSeen = it.PopDatetime
+			if strings.TrimSpace(it.PosterType) != "" {
+				a.PosterType = strings.TrimSpace(it.PosterType)
+			}
+		}
+		if a.PosterName == "" && strings.TrimSpace(it.PosterName) != "" {
+			a.PosterName = strings.TrimSpace(it.PosterName)
+		}
+	}
+	if len(byPoster) == 0 {
+		return "", false
+	}
+
+	rows := make([]*agg, 0, len(byPoster))
+	for _, a := range byPoster {
+		rows = append(rows, a)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].PlayCount > rows[j].PlayCount })
+	seconds := make([]int64, len(rows))
+	for i, r := range rows {
+		seconds[i] = r.Value
+		if seconds[i] <= 0 {
+			seconds[i] = r.PlayCount * 10
+		}
+	}
+	minutes := minuteParts(seconds)
+	if n := c.limits().DisplayTopN; len(rows) > n {
+		rows = rows[:n]
+	}
+
+	first := rows[0]
+	lines := make([]string, 0, len(rows)+3)
+	if showMinutes {
+		lines = append(lines, say(req, "pop_day_title_minutes", host, first.KioskName, day.Label))
+		lines = append(lines, popMinutesNote)
+	} else {
+		lines = append(lines, say(req, "pop_day_title", host, first.KioskName, day.Label))
+	}
+	for i, r := range rows {
+		name := r.PosterName
+		if name == "" {
+			name = r.PosterID
+		}
+		extra := ""
+		if r.PosterType != "" {
+			extra = " (" + r.PosterType + ")"
+		}
+		if showMinutes {
+			lines = append(lines, say(req, "pop_line_minutes", i+1, name, formatDecimal(minutes[i], 1), extra))
+		} else {
+			lines = append(lines, say(req, "pop_line_plays", i+1, name, formatThousands(r.PlayCount), extra))
+		}
+	}
+	lines = append(lines, say(req, "pop_location", first.KioskLat, first.KioskLong, first.LastSeen.UTC().Format(time.RFC3339)))
+	return strings.Join(lines, "\n"), true
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// hostScope is the city and region read from a host's prefix. It scopes a
+// request whose message names no city or region the detectors recognise,
+// typically because the gateway's region list is briefly unavailable.
+type hostScope struct {
+	Host   string
+	City   string
+	Region string
+}
+
+type hostScopeKey struct{}
+
+func withHostScope(ctx context.Context, hs hostScope) context.Context {
+	return context.WithValue(ctx, hostScopeKey{}, hs)
+}
+
+// hostScopeFrom returns the inferred scope of the request, zero when none.
+func hostScopeFrom(ctx context.Context) hostScope {
+	hs, _ := ctx.Value(hostScopeKey{}).(hostScope)
+	return hs
+}
+
+// scopeStopWords are the words around "city", "region", "in" and "from"
+// that do not name a scope ("which region", "from today").
+var scopeStopWords = map[string]bool{
+	"": true, "a": true, "an": true, "the": true, "this": true, "that": true, "these": true, "those": true,
+	"which": true, "what": true, "each": true, "every": true, "per": true, "by": true, "wise": true,
+	"same": true, "my": true, "our": true, "all": true, "and": true, "or": true, "for": true, "in": true,
+	"of": true, "from": true, "is": true, "are": true, "today": true, "yesterday": true, "last": true, "total": true,
+}
+
+// namesScope reports whether msgLower names a city or region in words the
+// detectors may not know, such as "kcmo city", "region brt" or "in kcmo".
+// Such a scope wins over one read from a host.
+func namesScope(msgLower string) bool {
+	words := tokenizeWords(msgLower)
+	word := func(i int) string {
+		if i < 0 || i >= len(words) {
+			return ""
+		}
+		return words[i]
+	}
+	for i, w := range words {
+		switch w {
+		case "city", "region":
+			if !scopeStopWords[word(i-1)] || !scopeStopWords[word(i+1)] {
+				return true
+			}
+		case "in", "from":
+			if next := word(i + 1); !scopeStopWords[next] && len(next) <= 6 && isLetters(next) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hostScopeConfirmed accepts a host's city and region when the city/region
+// caches list them, or when a cache is empty or stale and cannot say.
+func (c *ChatService) hostScopeConfirmed(ctx context.Context, city, region string) bool {
+	c.cityMu.Lock()
+	defer c.cityMu.Unlock()
+
+	c.ensureCityRegionCachesLocked(ctx)
+	confirms := func(cache map[string]struct{}, at time.Time, code string) bool {
+		if len(cache) == 0 || at.IsZero() || time.Since(at) >= c.cityCacheTTL {
+			return true
+		}
+		_, ok := cache[code]
+		return ok
+	}
+	return confirms(c.cityCache, c.cityCacheAt, city) && confirms(c.regionCache, c.regionCacheAt, region)
+}
+
+// inferHostScope derives a scope from the first host in msg, or from the
+// conversation's host when the conversation has no city or region yet. It
+// applies only when the message has no scope of its own: none the
+// detectors find, and none spelled out around the host.
+func (c *ChatService) inferHostScope(ctx context.Context, conversationID, msg string) (hostScope, bool) {
+	candidates := detectHostTokens(msg)
+	if len(candidates) == 0 && conversationID != "" {
+		if st := c.getConversationState(conversationID); st != nil && st.City == "" && st.Region == "" && st.Host != "" {
+			candidates = []string{st.Host}
+		}
+	}
+	if len(candidates) == 0 {
+		return hostScope{}, false
+	}
+	msgLower := strings.ToLower(msg)
+	if c.detectCityCode(ctx, msgLower) != "" || c.detectRegionCode(ctx, msgLower) != "" {
+		return hostScope{}, false
+	}
+	rest := msgLower
+	for _, h := range candidates {
+		rest = strings.ReplaceAll(rest, strings.ToLower(h), " ")
+	}
+	if namesScope(rest) {
+		return hostScope{}, false
+	}
+	for _, h := range candidates {
+		city, region := deviceKeyScope(h)
+		if city == "" || !c.hostScopeConfirmed(ctx, city, region) {
+			continue
+		}
+		return hostScope{Host: strings.ToLower(strings.TrimSpace(h)), City: city, Region: region}, true
+	}
+	return hostScope{}, false
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// isHourlyDistributionIntent matches per-day hourly questions ("hourly play
+// distribution for briggs-001 yesterday"). Open-ended hourly patterns without
+// a day are left to handlePopPattern.
+func isHourlyDistributionIntent(msgLower string) bool {
+	hourly := strings.Contains(msgLower, "hourly") || strings.Contains(msgLower, "by hour") || strings.Contains(msgLower, "per hour") || strings.Contains(msgLower, "each hour")
+	if !hourly {
+		return false
+	}
+	if strings.Contains(msgLower, "distribution") || strings.Contains(msgLower, "heatmap") || strings.Contains(msgLower, "heat map") || strings.Contains(msgLower, "breakdown") {
+		return true
+	}
+	return strings.Contains(msgLower, "today") || strings.Contains(msgLower, "yesterday") || flightDateRe.MatchString(msgLower)
+}
+
+var (
+	lastNDaysRe = regexp.MustCompile(`\b(?:last|past)\s+(\d{1,2})\s+days?\b`)
+	yearRe      = regexp.MustCompile(`\d{4}`)
+)
+
+// hourlyWindow resolves the local day window for an hourly distribution:
+// today (so far), yesterday, an explicit date, a date range, or the last N
+// days. It defaults to yesterday, the most recent full day. days is the number
+// of calendar days covered.
+func hourlyWindow(msg string, now time.Time, loc *time.Location) (from, to time.Time, days int, label string) {
+	msgLower := strings.ToLower(msg)
+	local := now.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+
+	if m := lastNDaysRe.FindStringSubmatch(msgLower); m != nil {
+		if n, err := strconv.Atoi(m[1]); err == nil && n > 0 {
+			return today.AddDate(0, 0, -n), today, n, fmt.Sprintf("the last %d days", n)
+		}
+	}
+	var dates []time.Time
+	for _, s := range flightDateRe.FindAllString(msgLower, -1) {
+		t, ok := parseFlightDate(s, now)
+		if !ok {
+			continue
+		}
+		d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		// A date without a year means its latest occurrence, not the next one.
+		if !yearRe.MatchString(s) && d.After(today) {
+			d = d.AddDate(-1, 0, 0)
+		}
+		dates = append(dates, d)
+	}
+	switch {
+	case len(dates) >= 2 && dates[1].After(dates[0]):
+		to = dates[1].AddDate(0, 0, 1)
+		return dates[0], to, int(to.Sub(dates[0]).Hours()/24 + 0.5), dates[0].Format("Jan 2") + " to " + dates[1].Format("Jan 2, 2006")
+	case len(dates) >= 1:
+		return dates[0], dates[0].AddDate(0, 0, 1), 1, dates[0].Format("Mon Jan 2, 2006")
+	case strings.Contains(msgLower, "today"):
+		return today, local, 1, "today so far"
+	}
+	y := today.AddDate(0, 0, -1)
+	return y, today, 1, "yesterday (" + y.Format("Mon Jan 2") + ")"
+}
+
+// bucketHourly sums play_count by local hour for rows inside [from, to).
+// Half-open bounds keep a row stamped exactly on a boundary in one bucket and
+// drop rows the gateway returns for an inclusive `to`.
+func bucketHourly(rows []popItem, from, to time.Time, loc *time.Location) [24]int64 {
+	var buckets [24]int64
+	for _, r := range rows {
+		t := r.PopDatetime
+		if t.IsZero() || t.Before(from) || !t.Before(to) {
+			continue
+		}
+		buckets[t.In(loc).Hour()] += r.PlayCount
+	}
+	return buckets
+}
+
+// hourRanges renders hours as compact ranges ("00–05, 23").
+func hourRanges(hours []int) string {
+	parts := make([]string, 0, len(hours))
+	for i := 0; i < len(hours); {
+		j := i
+		for j+1 < len(hours) && hours[j+1] == hours[j]+1 {
+			j++
+		}
+		if j > i {
+			parts = append(parts, fmt.Sprintf("%02d–%02d", hours[i], hours[j]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%02d", hours[i]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
+
+// resolveShortHost expands a host suffix such as "briggs-001" against the
+// device inventory. Full host ids are returned unchanged; ambiguous suffixes
+// return the candidates instead.
+func (c *ChatService) resolveShortHost(ctx context.Context, token, city, region string) (string, []string, []models.Step) {
+	token = strings.ToLower(strings.TrimSpace(token))
+	if strings.Count(token, "-") >= 2 {
+		return token, nil, nil
+	}
+	inv, steps := c.deviceHosts(ctx, city, region)
+	matches := make([]string, 0, 2)
+	for _, d := range inv {
+		if d.Host == token || strings.HasSuffix(d.Host, "-"+token) {
+			matches = append(matches, d.Host)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return token, nil, steps
+	case 1:
+		return matches[0], nil, steps
+	}
+	return "", matches, steps
+}
+
+func (c *ChatService) handleHourlyDistribution(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !isHourlyDistributionIntent(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	daypart, msgNoDaypart := c.parseDaypart(req.Message)
+	req.Message = msgNoDaypart
+	conversationID := strings.TrimSpace(req.ConversationID)
+	token := alertHostToken(req.Message)
+	if token == "" {
+		if st := c.getConversationState(conversationID); st != nil {
+			token = strings.TrimSpace(st.Host)
+		}
+	}
+	if token == "" {
+		// Posters and kiosk names are covered by the general pattern handler.
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+
+	city := c.detectCityCode(ctx, msgLower)
+	region := c.detectRegionCode(ctx, msgLower)
+	host, candidates, steps := c.resolveShortHost(ctx, token, city, region)
+	if host == "" {
+		if len(candidates) > 8 {
+			candidates = append(candidates[:8], "…")
+		}
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("'%s' matches several kiosks: %s. Which one?", token, strings.Join(candidates, ", ")), Steps: steps})
+	}
+	if conversationID != "" {
+		c.updateConversationHost(conversationID, host)
+	}
+
+	loc := requestLocation(req)
+	from, to, days, windowLabel := hourlyWindow(req.Message, time.Now(), loc)
+	filter := "host_name=" + urlEscape(host) + "&from=" + urlEscape(from.UTC().Format(time.RFC3339)) + "&to=" + urlEscape(to.UTC().Format(time.RFC3339))
+	rows, popSteps, pager, err := c.fetchPopRows(ctx, filter)
+	steps = append(steps, popSteps...)
+	if err != nil {
+		return reply(models.ChatResponse{Answer: "Failed to fetch POP data: " + err.Error(), Steps: steps})
+	}
+	names, metaSteps := c.deviceLabels(ctx, city, region, []string{host})
+	steps = append(steps, metaSteps...)
+	target := names[host]
+
+	// A daypart limits the rows and the hours listed to its window.
+	hours := make([]int, 24)
+	for h := range hours {
+		hours[h] = h
+	}
+	daypartNote := ""
+	if daypart != nil {
+		rows = daypart.filter(rows, loc)
+		hours = daypart.hours()
+		daypartNote = daypart.note(req, loc)
+	}
+	buckets := bucketHourly(rows, from, to, loc)
+	total := int64(0)
+	peak := hours[0]
+	zero := make([]int, 0, 24)
+	for _, h := range hours {
+		v := buckets[h]
+		total += v
+		if v > buckets[peak] {
+			peak = h
+		}
+		if v == 0 {
+			zero = append(zero, h)
+		}
+	}
+	if total == 0 {
+		answer := fmt.Sprintf("No plays were recorded on %s for %s (%s).", target, windowLabel, loc.String())
+		if daypartNote != "" {
+			answer += "\n" + daypartNote
+		}
+		return reply(models.ChatResponse{Answer: answer, Steps: steps})
+	}
+
+	metric := "plays"
+	header := fmt.Sprintf("Hourly plays on %s for %s (%s): %s total.", target, windowLabel, loc.String(), formatThousands(total))
+	if days > 1 {
+		metric = "avg plays per day"
+		header = fmt.Sprintf("Average plays per hour on %s over %s (%s), averaged across %d days: %s total.", target, windowLabel, loc.String(), days, formatThousands(total))
+	}
+	series := &models.TimeSeries{Name: "plays by hour", Bucket: "hour", Timezone: loc.String(), Metric: metric}
+	lines := make([]string, 0, 28)
+	lines = append(lines, header)
+	for _, h := range hours {
+		v := buckets[h]
+		value := formatThousands(v)
+		point := v
+		if days > 1 {
+			avg := float64(v) / float64(days)
+			value = formatDecimal(avg, 1)
+			point = int64(avg + 0.5)
+		}
+		line := fmt.Sprintf("%02d:00  %s", h, value)
+		if h == peak {
+			line += "  ← peak"
+		}
+		lines = append(lines, line)
+		series.Points = append(series.Points, models.TimeSeriesPoint{Label: fmt.Sprintf("%02d:00", h), Value: point})
+	}
+	peakLine := fmt.Sprintf("Peak hour: %02d:00 with %s plays (%s of the total).", peak, formatThousands(buckets[peak]), formatPercent(float64(buckets[peak])*100/float64(total)))
+	if days > 1 {
+		peakLine = fmt.Sprintf("Peak hour: %02d:00 with %s plays per day (%s of the total).", peak, formatDecimal(float64(buckets[peak])/float64(days), 1), formatPercent(float64(buckets[peak])*100/float64(total)))
+	}
+	lines = append(lines, peakLine)
+	if len(zero) > 0 {
+		lines = append(lines, "No plays during: "+hourRanges(zero)+".")
+	}
+	if daypartNote != "" {
+		lines = append(lines, daypartNote)
+	}
+	answer := pager.note(strings.Join(lines, "\n"))
+	return reply(models.ChatResponse{Answer: answer, Steps: steps, Meta: pager.meta(), Data: &models.ChatData{TimeSeries: series}})
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"openai-agent-service/internal/models"
+)
+
+// IdempotencyStore keeps idempotency keys and the responses they produced;
+// implemented by store.PostgresStore.
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, r models.IdempotencyRecord) (models.IdempotencyRecord, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, ownerKey, key, token string, resp models.ChatResponse, expiresAt time.Time) error
+	ReleaseIdempotencyKey(ctx context.Context, ownerKey, key, token string) error
+	IdempotencyKeyHeld(ctx context.Context, ownerKey, key, token string) (bool, error)
+	DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error)
+}
+
+// MaxIdempotencyKeyLen bounds an idempotency key.
+const MaxIdempotencyKeyLen = 128
+
+// idempotencyLease is how long a running request holds its key before a
+// retry may take it over, should the instance running it have died.
+const idempotencyLease = 10 * time.Minute
+
+var (
+	// ErrInvalidIdempotencyKey is returned for keys that are too long or
+	// contain anything but printable ASCII.
+	ErrInvalidIdempotencyKey = fmt.Errorf("idempotency key must be 1-%d printable ASCII characters without spaces", MaxIdempotencyKeyLen)
+	// ErrIdempotencyKeyReused is returned when a key comes back with a
+	// different request.
+	ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different request")
+	// ErrIdempotencyKeyLost is returned when a request no longer holds its
+	// key at the point of a gateway mutation, so the mutation is skipped.
+	ErrIdempotencyKeyLost = errors.New("idempotency key expired or was taken over by a retry; nothing was changed")
+)
+
+// ValidateIdempotencyKey checks a client-supplied key.
+func ValidateIdempotencyKey(key string) error {
+	if key == "" || len(key) > MaxIdempotencyKeyLen {
+		return ErrInvalidIdempotencyKey
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] > '~' {
+			return ErrInvalidIdempotencyKey
+		}
+	}
+	return nil
+}
+
+// idempotencyHash identifies the request a key was first used with.
+func idempotencyHash(req models.ChatRequest) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(req.ConversationID) + "\x00" + requestDigest(req)))
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotencyClaim is the key a running request holds, carried in its
+// context so mutating handlers can confirm it before calling the gateway.
+type idempotencyClaim struct {
+	owner, key, token string
+}
+
+type idempotencyClaimKey struct{}
+
+// checkIdempotencyClaim returns ErrIdempotencyKeyLost when the request runs
+// under an idempotency key it no longer holds. Requests without a key, and
+// store failures, let the mutation through.
+func (c *ChatService) checkIdempotencyClaim(ctx context.Context) error {
+	claim, ok := ctx.Value(idempotencyClaimKey{}).(idempotencyClaim)
+	if !ok || c.Idempotency == nil {
+		return nil
+	}
+	held, err := c.Idempotency.IdempotencyKeyHeld(ctx, claim.owner, claim.key, claim.token)
+	if err != nil {
+		log.Printf("idempotency: check failed key=%q: %v", claim.key, err)
+		return nil
+	}
+	if !held {
+		return ErrIdempotencyKeyLost
+	}
+	return nil
+}
+
+// chatIdempotent answers a request that carries an idempotency key. A retry
+// on this instance attaches to the running original like an identical
+// duplicate does; otherwise the key is reserved in the store, and a key
+// already completed replays the stored response with Meta.Replayed set. The
+// response is kept for IdempotencyRetention (default 24h); a request that
+// fails releases its key so the retry runs again.
+func (c *ChatService) chatIdempotent(ctx context.Context, ownerKey string, req models.ChatRequest, onToken func(string)) (models.ChatResponse, error) {
+	key := strings.TrimSpace(req.IdempotencyKey)
+	if err := ValidateIdempotencyKey(key); err != nil {
+		return models.ChatResponse{Answer: err.Error() + ".", Error: &models.ResponseError{Code: "invalid_idempotency_key"}}, err
+	}
+	hash := idempotencyHash(req)
+	flightKey := "idempotency\x00" + ownerKey + "\x00" + key + "\x00" + hash
+	call, leader := c.joinInflight(flightKey)
+	if !leader {
+		resp, err := c.awaitInflight(ctx, call, onToken)
+		if err == nil {
+			resp = replayed(resp)
+		}
+		return resp, err
+	}
+	emit := func(tok string) {
+		if onToken != nil {
+			onToken(tok)
+		}
+		call.emit(tok)
+	}
+	resp, err := c.chatReserved(ctx, ownerKey, key, hash, req, emit)
+	c.finishInflight(flightKey, call, resp, err)
+	return resp, err
+}
+
+// chatReserved reserves key in the store and runs, replays or rejects req.
+func (c *ChatService) chatReserved(ctx context.Context, ownerKey, key, hash string, req models.ChatRequest, onToken func(string)) (models.ChatResponse, error) {
+	rec := models.IdempotencyRecord{OwnerKey: ownerKey, Key: key, RequestHash: hash, Token: uuid.NewString(), ExpiresAt: time.Now().Add(idempotencyLease)}
+	held, reserved, err := c.Idempotency.ReserveIdempotencyKey(ctx, rec)
+	if err != nil {
+		// Answering beats refusing: without the store the key cannot be
+		// honoured, but the request itself can still run.
+		log.Printf("idempotency: reserve failed key=%q: %v", key, err)
+		return c.chatStreamDebug(ctx, ownerKey, req, onToken)
+	}
+	if !reserved {
+		switch {
+		case held.RequestHash != hash:
+			return models.ChatResponse{Answer: ErrIdempotencyKeyReused.Error() + ".", Error: &models.ResponseError{Code: "idempotency_key_reused"}}, ErrIdempotencyKeyReused
+		case held.Response != nil:
+			resp := replayed(*held.Response)
+			if onToken != nil {
+				onToken(resp.Answer)
+			}
+			return resp, nil
+		}
+		// Still running on another instance.
+		return models.ChatResponse{
+			Answer: ErrDuplicateInFlight.Error() + ".",
+			Error:  &models.ResponseError{Code: "duplicate_in_flight", Retryable: true},
+		}, ErrDuplicateInFlight
+	}
+
+	ctx = context.WithValue(ctx, idempotencyClaimKey{}, idempotencyClaim{owner: ownerKey, key: key, token: rec.Token})
+	resp, err := c.chatStreamDebug(ctx, ownerKey, req, onToken)
+	storeCtx := context.WithoutCancel(ctx)
+	if err != nil {
+		if rerr := c.Idempotency.ReleaseIdempotencyKey(storeCtx, ownerKey, key, rec.Token); rerr != nil {
+			log.Printf("idempotency: release failed key=%q: %v", key, rerr)
+		}
+		return resp, err
+	}
+	retention := c.IdempotencyRetention
+	if retention <= 0 {
+		retention = 24 * time.Hour
+	}
+	if cerr := c.Idempotency.CompleteIdempotencyKey(storeCtx, ownerKey, key, rec.Token, resp, time.Now().Add(retention)); cerr != nil {
+		log.Printf("idempotency: save failed key=%q: %v", key, cerr)
+	}
+	return resp, nil
+}
+
+// replayed marks a copy of resp as a replay of an earlier request.
+func replayed(resp models.ChatResponse) models.ChatResponse {
+	if resp.Meta == nil {
+		resp.Meta = &models.ResponseMeta{}
+	} else {
+		m := *resp.Meta
+		resp.Meta = &m
+	}
+	resp.Meta.Replayed = true
+	return resp
+}
+
+// IdempotencyJanitor deletes expired idempotency keys on an interval.
+type IdempotencyJanitor struct {
+	Store    IdempotencyStore
+	Interval time.Duration
+}
+
+func (j *IdempotencyJanitor) Run(ctx context.Context) {
+	interval := j.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if n, err := j.Store.DeleteExpiredIdempotencyKeys(ctx, time.Now()); err != nil {
+				log.Printf("idempotency janitor: %v", err)
+			} else if n > 0 {
+				log.Printf("idempotency janitor: deleted %d expired key(s)", n)
+			}
+		}
+	}
+}
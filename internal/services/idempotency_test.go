@@ -0,0 +1,295 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// memIdempotency is an in-memory IdempotencyStore with the store's rules: a
+// key is taken only when absent or expired, and only its token completes or
+// releases it.
+type memIdempotency struct {
+	mu   sync.Mutex
+	recs map[[2]string]models.IdempotencyRecord
+}
+
+func (s *memIdempotency) ReserveIdempotencyKey(_ context.Context, r models.IdempotencyRecord) (models.IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recs == nil {
+		s.recs = map[[2]string]models.IdempotencyRecord{}
+	}
+	k := [2]string{r.OwnerKey, r.Key}
+	if held, ok := s.recs[k]; ok && held.ExpiresAt.After(time.Now()) {
+		return held, false, nil
+	}
+	s.recs[k] = r
+	return r, true, nil
+}
+
+func (s *memIdempotency) CompleteIdempotencyKey(_ context.Context, ownerKey, key, token string, resp models.ChatResponse, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := [2]string{ownerKey, key}
+	if r, ok := s.recs[k]; ok && r.Token == token {
+		r.Response, r.ExpiresAt = &resp, expiresAt
+		s.recs[k] = r
+	}
+	return nil
+}
+
+func (s *memIdempotency) ReleaseIdempotencyKey(_ context.Context, ownerKey, key, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := [2]string{ownerKey, key}
+	if r, ok := s.recs[k]; ok && r.Token == token && r.Response == nil {
+		delete(s.recs, k)
+	}
+	return nil
+}
+
+func (s *memIdempotency) IdempotencyKeyHeld(_ context.Context, ownerKey, key, token string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.recs[[2]string{ownerKey, key}]
+	return ok && r.Token == token && r.Response == nil && r.ExpiresAt.After(time.Now()), nil
+}
+
+func (s *memIdempotency) DeleteExpiredIdempotencyKeys(_ context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for k, r := range s.recs {
+		if !r.ExpiresAt.After(now) {
+			delete(s.recs, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+const idempotentQuestion = "play count for poster Bet 365 in brt"
+
+func newIdempotentChat(t *testing.T, g *fakeGateway, keys *memIdempotency) *ChatService {
+	t.Helper()
+	c := newTestChat(g)
+	c.Store = newMemStore()
+	c.Idempotency = keys
+	return c
+}
+
+func assistantMessages(t *testing.T, c *ChatService, conv string) int {
+	t.Helper()
+	msgs, _ := c.Store.ListMessages(context.Background(), "owner-a", conv, 0)
+	n := 0
+	for _, m := range msgs {
+		if m.Role == "assistant" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})
+	c := newIdempotentChat(t, g, &memIdempotency{})
+	ctx := context.Background()
+	req := models.ChatRequest{ConversationID: "conv-1", Message: idempotentQuestion, IdempotencyKey: "retry-1"}
+
+	first, err := c.Chat(ctx, "owner-a", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Meta != nil && first.Meta.Replayed {
+		t.Error("first response marked as replayed")
+	}
+	calls := len(g.Calls("/"))
+
+	cases := []struct {
+		name    string
+		owner   string
+		req     models.ChatRequest
+		replay  bool
+		wantErr error
+		code    string
+	}{
+		{name: "same key and request", owner: "owner-a", req: req, replay: true},
+		{name: "same key, different request", owner: "owner-a", req: models.ChatRequest{ConversationID: "conv-1", Message: "play count for poster Lorla Studio in brt", IdempotencyKey: "retry-1"},
+			wantErr: ErrIdempotencyKeyReused, code: "idempotency_key_reused"},
+		{name: "invalid key", owner: "owner-a", req: models.ChatRequest{ConversationID: "conv-1", Message: idempotentQuestion, IdempotencyKey: "has space"},
+			wantErr: ErrInvalidIdempotencyKey, code: "invalid_idempotency_key"},
+		{name: "too long", owner: "owner-a", req: models.ChatRequest{ConversationID: "conv-1", Message: idempotentQuestion, IdempotencyKey: strings.Repeat("k", MaxIdempotencyKeyLen+1)},
+			wantErr: ErrInvalidIdempotencyKey, code: "invalid_idempotency_key"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var streamed strings.Builder
+			resp, err := c.ChatStream(ctx, tc.owner, tc.req, func(tok string) { streamed.WriteString(tok) })
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tc.wantErr)
+			}
+			if tc.code != "" {
+				if resp.Error == nil || resp.Error.Code != tc.code {
+					t.Errorf("error = %+v, want %s", resp.Error, tc.code)
+				}
+				return
+			}
+			if !tc.replay {
+				return
+			}
+			if resp.Meta == nil || !resp.Meta.Replayed || resp.Answer != first.Answer {
+				t.Errorf("replay = %+v, want the first answer marked replayed", resp)
+			}
+			if streamed.String() != first.Answer {
+				t.Errorf("streamed %q, want the stored answer", streamed.String())
+			}
+		})
+	}
+	if n := len(g.Calls("/")); n != calls {
+		t.Errorf("%d gateway calls after the first request", n-calls)
+	}
+	if n := assistantMessages(t, c, "conv-1"); n != 1 {
+		t.Errorf("%d assistant messages, want 1", n)
+	}
+}
+
+// Retries racing the original on the same instance attach to it; on another
+// instance sharing the store they are told to retry.
+func TestIdempotencyConcurrentRetry(t *testing.T) {
+	hold := make(chan struct{})
+	g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop(), Hold: hold})
+	keys := &memIdempotency{}
+	c := newIdempotentChat(t, g, keys)
+	ctx := context.Background()
+	req := models.ChatRequest{ConversationID: "conv-1", Message: idempotentQuestion, IdempotencyKey: "retry-1"}
+
+	const n = 4
+	var wg sync.WaitGroup
+	resps := make([]models.ChatResponse, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resps[i], errs[i] = c.ChatStream(ctx, "owner-a", req, func(string) {})
+		}(i)
+		if i == 0 {
+			waitFor(t, "the original to reach the gateway", func() bool { return len(g.Calls("/pop")) > 0 })
+		}
+	}
+	flightKey := "idempotency\x00owner-a\x00retry-1\x00" + idempotencyHash(req)
+	waitFor(t, "the retries to attach", func() bool { return inflightSubs(c, flightKey) == n-1 })
+
+	other := newIdempotentChat(t, g, keys)
+	resp, err := other.Chat(ctx, "owner-a", req)
+	if !errors.Is(err, ErrDuplicateInFlight) || resp.Error == nil || !resp.Error.Retryable {
+		t.Errorf("retry on another instance: %+v, %v; want a retryable duplicate_in_flight", resp.Error, err)
+	}
+
+	close(hold)
+	wg.Wait()
+	for i := range resps {
+		if errs[i] != nil {
+			t.Fatalf("request %d: %v", i, errs[i])
+		}
+		if resps[i].Answer != resps[0].Answer {
+			t.Errorf("request %d answered %q, want %q", i, resps[i].Answer, resps[0].Answer)
+		}
+	}
+	if resps[0].Meta != nil && resps[0].Meta.Replayed {
+		t.Error("the original is marked replayed")
+	}
+	for i := 1; i < n; i++ {
+		if resps[i].Meta == nil || !resps[i].Meta.Replayed {
+			t.Errorf("retry %d not marked replayed", i)
+		}
+	}
+	base := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})
+	chatOnce(t, newTestChat(base), idempotentQuestion)
+	if got, want := len(g.Calls("/pop")), len(base.Calls("/pop")); got != want {
+		t.Errorf("%d /pop calls, want the %d of one execution", got, want)
+	}
+	if n := assistantMessages(t, c, "conv-1"); n != 1 {
+		t.Errorf("%d assistant messages, want 1", n)
+	}
+}
+
+// A stored response is replayed only for IdempotencyRetention; after that
+// the key runs a new request. A failed request releases its key at once.
+func TestIdempotencyExpiry(t *testing.T) {
+	g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})
+	keys := &memIdempotency{}
+	c := newIdempotentChat(t, g, keys)
+	c.IdempotencyRetention = 20 * time.Millisecond
+	ctx := context.Background()
+	req := models.ChatRequest{ConversationID: "conv-1", Message: idempotentQuestion, IdempotencyKey: "retry-1"}
+
+	if _, err := c.Chat(ctx, "owner-a", req); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := c.Chat(ctx, "owner-a", req); resp.Meta == nil || !resp.Meta.Replayed {
+		t.Fatal("retry within the retention was not replayed")
+	}
+	time.Sleep(30 * time.Millisecond)
+	calls := len(g.Calls("/pop"))
+	resp, err := c.Chat(ctx, "owner-a", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Meta != nil && resp.Meta.Replayed {
+		t.Error("replayed after the retention ended")
+	}
+	if len(g.Calls("/pop")) == calls {
+		t.Error("the reused key did not run the request")
+	}
+	if n := assistantMessages(t, c, "conv-1"); n != 2 {
+		t.Errorf("%d assistant messages, want 2", n)
+	}
+	if n, _ := keys.DeleteExpiredIdempotencyKeys(ctx, time.Now().Add(time.Minute)); n != 1 {
+		t.Errorf("cleanup deleted %d keys, want 1", n)
+	}
+
+	// owner-b may not use owner-a's conversation; the refusal frees the key.
+	refused := models.ChatRequest{ConversationID: "conv-1", Message: idempotentQuestion, IdempotencyKey: "retry-2"}
+	if _, err := c.Chat(ctx, "owner-b", refused); !errors.Is(err, ErrConversationForbidden) {
+		t.Fatalf("err = %v, want ErrConversationForbidden", err)
+	}
+	if _, reserved, _ := keys.ReserveIdempotencyKey(ctx, models.IdempotencyRecord{OwnerKey: "owner-b", Key: "retry-2", ExpiresAt: time.Now().Add(time.Minute)}); !reserved {
+		t.Error("a failed request kept its key")
+	}
+}
+
+// A request that lost its key, to expiry or a retry's takeover, must not
+// make gateway mutations.
+func TestIdempotencyClaim(t *testing.T) {
+	keys := &memIdempotency{}
+	c := &ChatService{Idempotency: keys}
+	ctx := context.Background()
+	rec := models.IdempotencyRecord{OwnerKey: "owner-a", Key: "k", Token: "mine", ExpiresAt: time.Now().Add(time.Minute)}
+	if _, ok, _ := keys.ReserveIdempotencyKey(ctx, rec); !ok {
+		t.Fatal("reserve failed")
+	}
+	claim := func(token string) context.Context {
+		return context.WithValue(ctx, idempotencyClaimKey{}, idempotencyClaim{owner: "owner-a", key: "k", token: token})
+	}
+	cases := []struct {
+		name string
+		ctx  context.Context
+		want error
+	}{
+		{name: "no key", ctx: ctx},
+		{name: "held", ctx: claim("mine")},
+		{name: "taken over", ctx: claim("stale"), want: ErrIdempotencyKeyLost},
+	}
+	for _, tc := range cases {
+		if err := c.checkIdempotencyClaim(tc.ctx); !errors.Is(err, tc.want) {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
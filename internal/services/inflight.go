@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// ErrDuplicateInFlight is returned to a duplicate request that gave up waiting
+// for the identical request it attached to.
+var ErrDuplicateInFlight = errors.New("an identical question is still being answered in this conversation, please retry shortly")
+
+// inflightCall is one executing request that identical duplicates attach to.
+// Tokens are recorded so a duplicate that attaches mid-stream can replay what
+// it missed before receiving live tokens.
+type inflightCall struct {
+	done chan struct{}
+	resp models.ChatResponse
+	err  error
+
+	mu     sync.Mutex
+	tokens []string
+	subs   map[int]func(string)
+	nextID int
+}
+
+// emit records tok and forwards it to attached duplicates. Subscribers are
+// called under mu so detach guarantees no later call.
+func (f *inflightCall) emit(tok string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = append(f.tokens, tok)
+	for _, fn := range f.subs {
+		fn(tok)
+	}
+}
+
+func (f *inflightCall) attach(onToken func(string)) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, tok := range f.tokens {
+		onToken(tok)
+	}
+	if f.subs == nil {
+		f.subs = map[int]func(string){}
+	}
+	f.nextID++
+	f.subs[f.nextID] = onToken
+	return f.nextID
+}
+
+func (f *inflightCall) detach(id int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subs, id)
+}
+
+// inflightKey identifies identical requests: same caller, same conversation,
+// same message up to case and whitespace, same attachments and options.
+// Requests without a conversation are never deduplicated.
+func inflightKey(ownerKey string, req models.ChatRequest) string {
+	conversationID := strings.TrimSpace(req.ConversationID)
+	if conversationID == "" {
+		return ""
+	}
+	return ownerKey + "\x00" + conversationID + "\x00" + requestDigest(req)
+}
+
+// requestDigest hashes what makes two requests the same question: the
+// message up to case and whitespace, attachments and options.
+func requestDigest(req models.ChatRequest) string {
+	h := sha256.New()
+	h.Write([]byte(strings.Join(strings.Fields(strings.ToLower(req.Message)), " ")))
+	for _, a := range req.Attachments {
+		h.Write([]byte{0})
+		h.Write([]byte(a.FileName))
+		h.Write([]byte{0})
+		h.Write([]byte(a.Base64))
+	}
+	if req.DryRun != nil {
+		if *req.DryRun {
+			h.Write([]byte("\x00dry_run=true"))
+		} else {
+			h.Write([]byte("\x00dry_run=false"))
+		}
+	}
+	h.Write([]byte("\x00" + req.Timezone + "\x00" + req.Units + "\x00" + req.TemperatureUnit))
+	if req.Debug {
+		h.Write([]byte("\x00debug"))
+	}
+	h.Write([]byte("\x00" + strings.ToLower(strings.TrimSpace(req.Verbosity)) + "\x00" + strings.ToLower(strings.TrimSpace(req.Language))))
+	if req.RenderTables {
+		h.Write([]byte("\x00tables"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// joinInflight returns the call registered under key and whether the caller
+// is its leader (and must execute the request and call finishInflight).
+func (c *ChatService) joinInflight(key string) (*inflightCall, bool) {
+	c.inflightMu.Lock()
+	defer c.inflightMu.Unlock()
+	if f, ok := c.inflight[key]; ok {
+		return f, false
+	}
+	if c.inflight == nil {
+		c.inflight = map[string]*inflightCall{}
+	}
+	f := &inflightCall{done: make(chan struct{})}
+	c.inflight[key] = f
+	return f, true
+}
+
+func (c *ChatService) finishInflight(key string, f *inflightCall, resp models.ChatResponse, err error) {
+	f.resp, f.err = resp, err
+	c.inflightMu.Lock()
+	delete(c.inflight, key)
+	c.inflightMu.Unlock()
+	close(f.done)
+}
+
+// awaitInflight waits for the leader's result, streaming its tokens to
+// onToken as they arrive. It gives up after DedupWait (default 90s) so a stuck
+// original cannot hold duplicates forever.
+func (c *ChatService) awaitInflight(ctx context.Context, f *inflightCall, onToken func(string)) (models.ChatResponse, error) {
+	if onToken != nil {
+		id := f.attach(onToken)
+		defer f.detach(id)
+	}
+	wait := c.DedupWait
+	if wait <= 0 {
+		wait = 90 * time.Second
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-f.done:
+		return f.resp, f.err
+	case <-ctx.Done():
+		return models.ChatResponse{}, ctx.Err()
+	case <-t.C:
+		return models.ChatResponse{
+			Answer: ErrDuplicateInFlight.Error() + ".",
+			Error:  &models.ResponseError{Code: "duplicate_in_flight", Retryable: true},
+		}, ErrDuplicateInFlight
+	}
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+const dedupQuestion = "play count for poster Bet 365 in brt"
+
+// waitFor polls cond until it holds or a second passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// inflightSubs counts the duplicates streaming from the call under key.
+func inflightSubs(c *ChatService, key string) int {
+	c.inflightMu.Lock()
+	f := c.inflight[key]
+	c.inflightMu.Unlock()
+	if f == nil {
+		return 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subs)
+}
+
+// Identical requests fired while the first is still running join it: the
+// gateway sees one execution, the conversation one question and one answer,
+// and every caller gets the same answer.
+func TestInflightDedup(t *testing.T) {
+	// The gateway calls one uncontended request makes.
+	base := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})
+	bc := newTestChat(base)
+	bc.Store = newMemStore()
+	want := chatTurn(t, bc, "conv-base", dedupQuestion)
+	baseCalls := len(base.Calls("/pop"))
+	if baseCalls == 0 {
+		t.Fatalf("%q read no /pop: %s", dedupQuestion, want)
+	}
+
+	cases := []struct {
+		name   string
+		stream bool
+	}{
+		{name: "chat"},
+		{name: "stream", stream: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			hold := make(chan struct{})
+			g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop(), Hold: hold})
+			c := newTestChat(g)
+			store := newMemStore()
+			c.Store = store
+			ctx := context.Background()
+			const conv, n = "conv-dedup", 5
+			req := models.ChatRequest{ConversationID: conv, Message: dedupQuestion}
+
+			var wg sync.WaitGroup
+			answers := make([]string, n)
+			streamed := make([]string, n)
+			errs := make([]error, n)
+			run := func(i int, r models.ChatRequest) {
+				defer wg.Done()
+				var resp models.ChatResponse
+				if tc.stream {
+					var mu sync.Mutex
+					var b strings.Builder
+					resp, errs[i] = c.ChatStream(ctx, "owner-a", r, func(tok string) {
+						mu.Lock()
+						b.WriteString(tok)
+						mu.Unlock()
+					})
+					mu.Lock()
+					streamed[i] = b.String()
+					mu.Unlock()
+				} else {
+					resp, errs[i] = c.Chat(ctx, "owner-a", r)
+				}
+				answers[i] = resp.Answer
+			}
+
+			wg.Add(1)
+			go run(0, req)
+			waitFor(t, "the first request to reach the gateway", func() bool { return len(g.Calls("/pop")) > 0 })
+			for i := 1; i < n; i++ {
+				// Case and spacing don't make a question different.
+				dup := req
+				if i%2 == 0 {
+					dup.Message = "  PLAY COUNT for poster Bet 365   in brt "
+				}
+				wg.Add(1)
+				go run(i, dup)
+			}
+			if tc.stream {
+				waitFor(t, "the duplicates to attach", func() bool { return inflightSubs(c, inflightKey("owner-a", req)) == n-1 })
+			} else {
+				// Plain duplicates wait without registering anything.
+				time.Sleep(50 * time.Millisecond)
+			}
+			close(hold)
+			wg.Wait()
+
+			for i := range answers {
+				if errs[i] != nil {
+					t.Fatalf("request %d: %v", i, errs[i])
+				}
+				if answers[i] != want {
+					t.Errorf("request %d answered\n%s\nwant\n%s", i, answers[i], want)
+				}
+				if tc.stream && streamed[i] != streamed[0] {
+					t.Errorf("request %d streamed %q, want %q", i, streamed[i], streamed[0])
+				}
+			}
+			if got := len(g.Calls("/pop")); got != baseCalls {
+				t.Errorf("%d /pop calls for %d identical requests, want the %d of one", got, n, baseCalls)
+			}
+			msgs, _ := store.ListMessages(ctx, "owner-a", conv, 0)
+			var assistant int
+			for _, m := range msgs {
+				if m.Role == "assistant" {
+					assistant++
+				}
+			}
+			if len(msgs) != 2 || assistant != 1 {
+				t.Errorf("stored %d messages, %d from the assistant; want one question and one answer", len(msgs), assistant)
+			}
+		})
+	}
+}
+
+// A duplicate waits at most DedupWait for a stuck original, then gives up
+// with a retryable duplicate_in_flight; the original still completes.
+func TestInflightDedupTimeout(t *testing.T) {
+	hold := make(chan struct{})
+	g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop(), Hold: hold})
+	c := newTestChat(g)
+	c.Store = newMemStore()
+	c.DedupWait = 20 * time.Millisecond
+	ctx := context.Background()
+	req := models.ChatRequest{ConversationID: "conv-stuck", Message: dedupQuestion}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Chat(ctx, "owner-a", req)
+		done <- err
+	}()
+	waitFor(t, "the first request to reach the gateway", func() bool { return len(g.Calls("/pop")) > 0 })
+
+	resp, err := c.Chat(ctx, "owner-a", req)
+	if !errors.Is(err, ErrDuplicateInFlight) {
+		t.Fatalf("err = %v, want ErrDuplicateInFlight", err)
+	}
+	if resp.Error == nil || resp.Error.Code != "duplicate_in_flight" || !resp.Error.Retryable {
+		t.Errorf("error = %+v, want a retryable duplicate_in_flight", resp.Error)
+	}
+	if ce := ClassifyChatError(err); ce.Code != "duplicate_in_flight" || !ce.Retryable {
+		t.Errorf("classified as %+v", ce)
+	}
+
+	close(hold)
+	if err := <-done; err != nil {
+		t.Fatalf("original: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"context"
+	"log"
+
+	"openai-agent-service/internal/models"
+)
+
+type keyRoleKey struct{}
+
+// WithKeyRole records the role of the API key a request came in with. A
+// context without a role (background jobs such as the alert evaluator) is
+// treated as admin.
+func WithKeyRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, keyRoleKey{}, role)
+}
+
+// keyRole returns the caller's role, admin when none was recorded.
+func keyRole(ctx context.Context) string {
+	if r, _ := ctx.Value(keyRoleKey{}).(string); r != "" {
+		return r
+	}
+	return models.KeyRoleAdmin
+}
+
+func readOnlyKey(ctx context.Context) bool {
+	return keyRole(ctx) == models.KeyRoleReadOnly
+}
+
+// readOnlyRefusal is the answer when a read-only key asks for a mutation;
+// it is returned before any gateway call.
+func readOnlyRefusal(ctx context.Context, action string) models.ChatResponse {
+	log.Printf("refused %s: role=%s", action, keyRole(ctx))
+	return models.ChatResponse{
+		Answer: "This API key is read-only, so it cannot " + action + "; nothing was changed.",
+		Error:  &models.ResponseError{Code: "forbidden", Retryable: false},
+	}
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"openai-agent-service/internal/models"
+)
+
+// A read-only key is refused an upload before any gateway call; an admin
+// key, or a background caller without a role, uploads.
+func TestKeyRoleUpload(t *testing.T) {
+	msg := "upload creative to campaign " + testCampaignID + " on mon,tue 08:00-12:00 devices: moco-brt-briggs-001,moco-brt-briggs-002"
+	cases := []struct {
+		name     string
+		role     string
+		uploaded bool
+	}{
+		{name: "readonly", role: models.KeyRoleReadOnly},
+		{name: "admin", role: models.KeyRoleAdmin, uploaded: true},
+		{name: "no role", uploaded: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newFakeGateway(t, &fakeGateway{Routes: map[string]string{
+				"/ads/creatives/upload": `{"data":{"results":[{"file_name":"pixel.png","creative_id":"cr-1"}]}}`,
+			}})
+			c := newTestChat(g)
+			ctx := context.Background()
+			if tc.role != "" {
+				ctx = WithKeyRole(ctx, tc.role)
+			}
+			resp, err := c.Chat(ctx, "test-key", models.ChatRequest{
+				Message:     msg,
+				Attachments: []models.ChatAttachment{{FileName: "pixel.png", ContentType: "image/png", Base64: pixelPNG}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			uploads := len(g.Calls("/ads/creatives/upload"))
+			if !tc.uploaded {
+				if resp.Error == nil || resp.Error.Code != "forbidden" || resp.Error.Retryable {
+					t.Errorf("error = %+v, want a non-retryable forbidden", resp.Error)
+				}
+				if !strings.Contains(resp.Answer, "read-only") {
+					t.Errorf("answer %q does not explain the refusal", resp.Answer)
+				}
+				if uploads != 0 {
+					t.Errorf("%d upload calls for a read-only key", uploads)
+				}
+				return
+			}
+			if resp.Error != nil || uploads != 1 || !strings.Contains(resp.Answer, "creative cr-1") {
+				t.Errorf("upload: error=%+v calls=%d answer=%q", resp.Error, uploads, resp.Answer)
+			}
+		})
+	}
+}
+
+// Read-only keys still get deterministic read answers.
+func TestKeyRoleReadOnlyReads(t *testing.T) {
+	g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})
+	c := newTestChat(g)
+	want := chatOnce(t, c, "play count for poster Bet 365 in brt").Answer
+	resp, err := c.Chat(WithKeyRole(context.Background(), models.KeyRoleReadOnly), "test-key", models.ChatRequest{Message: "play count for poster Bet 365 in brt"})
+	if err != nil || resp.Error != nil || resp.Answer != want {
+		t.Errorf("read-only read: %v, %+v, %q; want %q", err, resp.Error, resp.Answer, want)
+	}
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"openai-agent-service/internal/models"
+)
+
+// builtinAliases maps non-English trigger words, per language, to the English
+// words the intent matchers and date parsers look for. LANGUAGE_ALIASES_FILE
+// adds to and overrides these.
+var builtinAliases = map[string]map[string]string{
+	"es": {
+		"cuántos": "how many", "cuantos": "how many", "cuántas": "how many", "cuantas": "how many",
+		"número de": "number of", "numero de": "number of",
+		"kiosco": "kiosk", "kioscos": "kiosks", "quiosco": "kiosk", "quioscos": "kiosks",
+		"dispositivo": "device", "dispositivos": "devices", "pantalla": "screen", "pantallas": "screens",
+		"reproducciones": "plays", "reproducción": "play", "reproduccion": "play",
+		"desconectado": "offline", "desconectados": "offline", "desconectadas": "offline",
+		"fuera de línea": "offline", "fuera de linea": "offline",
+		"conectado": "online", "conectados": "online", "conectadas": "online",
+		"en línea": "online", "en linea": "online",
+		"estado": "status", "ciudad": "city", "región": "region",
+		"ayer": "yesterday", "hoy": "today", "semana": "week", "mes": "month", "para": "for",
+		"enero": "january", "febrero": "february", "marzo": "march", "abril": "april",
+		"mayo": "may", "junio": "june", "julio": "july", "agosto": "august",
+		"septiembre": "september", "setiembre": "september", "octubre": "october",
+		"noviembre": "november", "diciembre": "december",
+	},
+}
+
+// languageNames are the languages answers can be written in, by code.
+var languageNames = map[string]string{"en": "English", "es": "Spanish"}
+
+// messageCatalog holds the fixed answer strings of the deterministic
+// handlers by language and message id. Templates take the same arguments in
+// every language; numbers and entity names are passed through unchanged.
+var messageCatalog = map[string]map[string]string{
+	"en": {
+		"gateway_not_configured": "Tool gateway is not configured.",
+		"language_fallback":      "Answers in '%s' are not available yet; answering in English.",
+		"need_city":              "Please specify a city code (for example: kcmo).",
+		"need_city_or_region":    "Please specify a city code (for example: kcmo) or a region code (for example: kc).",
+		"need_host":              "Please specify the device host/server id (for example: moco-brt-briggs-001) or a kiosk display name.",
+		"status_failed":          "Failed to fetch device status: %s",
+		"status_failed_status":   "Failed to fetch device status (status %d).",
+		"status_city":            "City '%s': %d offline / %d online (total %d devices in the last 5m).",
+		"status_region":          "Region '%s': %d offline / %d online (total %d devices in the last 5m).",
+		"status_none_city":       "No device status data was found for city '%s'.",
+		"status_none_region":     "No device status data was found for region '%s'.",
+		"counts_failed":          "Failed to fetch kiosk counts: %s",
+		"counts_failed_status":   "Failed to fetch kiosk counts (status %d).",
+		"count_region":           "There are %s kiosks/devices recorded for region '%s' (city '%s').",
+		"count_none_region":      "No kiosk/device counts were found for region '%s' (city '%s').",
+		"count_city":             "There are %s kiosks/devices recorded for city '%s'.",
+		"count_none_city":        "No kiosk/device counts were found for city '%s'.",
+		"pop_failed":             "Failed to fetch POP data: %s",
+		"pop_failed_status":      "Failed to fetch POP data (status %d).",
+		"pop_unparsable":         "POP list response could not be parsed.",
+		"pop_no_preset":          "This POP endpoint does not appear to support a '%s' preset on this gateway.",
+		"pop_no_date_range":      "This gateway's POP endpoint does not accept from/to dates, so only today and yesterday can be shown.",
+		"pop_day_none":           "No POP data was found for '%s' (%s).",
+		"pop_day_future":         "%s is in the future, so there is no POP for it yet.",
+		"pop_day_title":          "POP for '%s' (%s) — %s:",
+		"pop_day_title_minutes":  "POP for '%s' (%s) in minutes — %s:",
+		"pop_day_today":          "today, %s",
+		"pop_day_yesterday":      "yesterday, %s",
+		"pop_line_plays":         "%d. %s — %s plays%s",
+		"pop_line_minutes":       "%d. %s — %s minutes%s",
+		"pop_location":           "Location: %.6f, %.6f | Last update: %s",
+		"pop_daypart":            "Counting only plays during %s (%s–%s %s) on each day.",
+		"pop_daypart_custom":     "Counting only plays from %s to %s (%s) on each day.",
+	},
+	"es": {
+		"gateway_not_configured": "El gateway de herramientas no está configurado.",
+		"need_city":              "Indica un código de ciudad (por ejemplo: kcmo).",
+		"need_city_or_region":    "Indica un código de ciudad (por ejemplo: kcmo) o de región (por ejemplo: kc).",
+		"need_host":              "Indica el host/id de servidor del dispositivo (por ejemplo: moco-brt-briggs-001) o el nombre visible de un kiosco.",
+		"status_failed":          "No se pudo obtener el estado de los dispositivos: %s",
+		"status_failed_status":   "No se pudo obtener el estado de los dispositivos (estado %d).",
+		"status_city":            "Ciudad '%s': %d desconectados / %d conectados (total %d dispositivos en los últimos 5 min).",
+		"status_region":          "Región '%s': %d desconectados / %d conectados (total %d dispositivos en los últimos 5 min).",
+		"status_none_city":       "No se encontraron datos de estado de dispositivos para la ciudad '%s'.",
+		"status_none_region":     "No se encontraron datos de estado de dispositivos para la región '%s'.",
+		"counts_failed":          "No se pudo obtener el número de kioscos: %s",
+		"counts_failed_status":   "No se pudo obtener el número de kioscos (estado %d).",
+		"count_region":           "Hay %s kioscos/dispositivos registrados en la región '%s' (ciudad '%s').",
+		"count_none_region":      "No se encontraron kioscos/dispositivos para la región '%s' (ciudad '%s').",
+		"count_city":             "Hay %s kioscos/dispositivos registrados en la ciudad '%s'.",
+		"count_none_city":        "No se encontraron kioscos/dispositivos para la ciudad '%s'.",
+		"pop_failed":             "No se pudieron obtener los datos de POP: %s",
+		"pop_failed_status":      "No se pudieron obtener los datos de POP (estado %d).",
+		"pop_unparsable":         "No se pudo interpretar la respuesta de la lista de POP.",
+		"pop_no_preset":          "Este endpoint de POP no parece admitir el preset '%s' en este gateway.",
+		"pop_no_date_range":      "El endpoint de POP de este gateway no acepta fechas from/to, así que solo se pueden mostrar hoy y ayer.",
+		"pop_day_none":           "No se encontraron datos de POP para '%s' (%s).",
+		"pop_day_future":         "%s está en el futuro, así que todavía no hay POP.",
+		"pop_day_title":          "POP para '%s' (%s) — %s:",
+		"pop_day_title_minutes":  "POP para '%s' (%s) en minutos — %s:",
+		"pop_day_today":          "hoy, %s",
+		"pop_day_yesterday":      "ayer, %s",
+		"pop_line_plays":         "%d. %s — %s reproducciones%s",
+		"pop_line_minutes":       "%d. %s — %s minutos%s",
+		"pop_location":           "Ubicación: %.6f, %.6f | Última actualización: %s",
+		"pop_daypart":            "Solo se cuentan las reproducciones de %s (%s–%s %s) de cada día.",
+		"pop_daypart_custom":     "Solo se cuentan las reproducciones de %s a %s (%s) de cada día.",
+	},
+}
+
+// answerLanguage is the catalog language for req: its Language when the
+// catalog has it, English otherwise.
+func answerLanguage(req models.ChatRequest) string {
+	lang := strings.ToLower(strings.TrimSpace(req.Language))
+	if _, ok := messageCatalog[lang]; ok {
+		return lang
+	}
+	return "en"
+}
+
+// say renders catalog message id in the answer language of req, falling
+// back to the English template when the language lacks it.
+func say(req models.ChatRequest, id string, args ...any) string {
+	tmpl, ok := messageCatalog[answerLanguage(req)][id]
+	if !ok {
+		tmpl = messageCatalog["en"][id]
+	}
+	return fmt.Sprintf(tmpl, args...)
+}
+
+// languageFallbackNote says so when req asks for a language answers cannot
+// be written in.
+func languageFallbackNote(req models.ChatRequest) string {
+	lang := strings.ToLower(strings.TrimSpace(req.Language))
+	if lang == "" || answerLanguage(req) == lang {
+		return ""
+	}
+	return say(req, "language_fallback", lang)
+}
+
+var aliasWordRe = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// applyLanguage rewrites the non-English trigger words in req.Message to
+// their English equivalents and, unless the request names a Language,
+// answers in the language most of those words came from.
+func (c *ChatService) applyLanguage(req models.ChatRequest) models.ChatRequest {
+	msg, detected := c.translateAliases(req.Message)
+	req.Message = msg
+	if strings.TrimSpace(req.Language) == "" {
+		req.Language = detected
+	}
+	return req
+}
+
+// translateAliases replaces alias words and phrases (up to three words
+// separated by spaces) in msg, longest match first, and returns the
+// language with the most matches.
+func (c *ChatService) translateAliases(msg string) (string, string) {
+	spans := aliasWordRe.FindAllStringIndex(msg, -1)
+	if len(spans) == 0 {
+		return msg, ""
+	}
+	langs := make([]string, 0, len(builtinAliases)+len(c.LanguageAliases))
+	for lang := range builtinAliases {
+		langs = append(langs, lang)
+	}
+	for lang := range c.LanguageAliases {
+		if _, ok := builtinAliases[lang]; !ok {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Strings(langs)
+	lookup := func(phrase string) (string, string, bool) {
+		for _, lang := range langs {
+			if en, ok := c.LanguageAliases[lang][phrase]; ok {
+				return en, lang, true
+			}
+			if en, ok := builtinAliases[lang][phrase]; ok {
+				return en, lang, true
+			}
+		}
+		return "", "", false
+	}
+
+	var b strings.Builder
+	hits := map[string]int{}
+	last := 0
+	for i := 0; i < len(spans); {
+		matched := false
+		for n := min(3, len(spans)-i); n >= 1; n-- {
+			start, end := spans[i][0], spans[i+n-1][1]
+			phrase := strings.ToLower(msg[start:end])
+			if strings.Join(strings.Fields(phrase), " ") != phrase {
+				// Words joined by anything but single spaces are not a phrase.
+				continue
+			}
+			en, lang, ok := lookup(phrase)
+			if !ok {
+				continue
+			}
+			b.WriteString(msg[last:start])
+			b.WriteString(en)
+			last = end
+			hits[lang]++
+			i += n
+			matched = true
+			break
+		}
+		if !matched {
+			i++
+		}
+	}
+	if len(hits) == 0 {
+		return msg, ""
+	}
+	b.WriteString(msg[last:])
+	detected := ""
+	for _, lang := range langs {
+		if hits[lang] > hits[detected] {
+			detected = lang
+		}
+	}
+	return b.String(), detected
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// Timing stages, in the order the summary log line lists them. hydrate,
+// dispatch, store and total are wall time; gateway and openai sum the calls
+// made, so concurrent gateway pages can add up to more than dispatch.
+// aggregation is dispatch less the gateway and OpenAI time inside it: our
+// own matching, aggregation and rendering.
+const (
+	stageHydrate     = "hydrate"
+	stageDispatch    = "dispatch"
+	stageGateway     = "gateway"
+	stageOpenAI      = "openai"
+	stageAggregation = "aggregation"
+	stageStore       = "store"
+	stageFirstToken  = "first_token"
+	stageTotal       = "total"
+)
+
+var timingStages = []string{stageHydrate, stageDispatch, stageGateway, stageOpenAI, stageAggregation, stageStore, stageFirstToken, stageTotal}
+
+// stageTimer accumulates per-stage durations while one request runs. It
+// travels in the request context like debugCapture; methods are safe on a
+// nil receiver.
+type stageTimer struct {
+	mu         sync.Mutex
+	start      time.Time
+	mark       time.Time
+	firstToken time.Duration
+	stages     map[string]time.Duration
+}
+
+type stageTimerKey struct{}
+
+func newStageTimer() *stageTimer {
+	now := time.Now()
+	return &stageTimer{start: now, mark: now, stages: map[string]time.Duration{}}
+}
+
+func withStageTimer(ctx context.Context, t *stageTimer) context.Context {
+	return context.WithValue(ctx, stageTimerKey{}, t)
+}
+
+func stageTimerFrom(ctx context.Context) *stageTimer {
+	t, _ := ctx.Value(stageTimerKey{}).(*stageTimer)
+	return t
+}
+
+// since adds the time elapsed from start to stage.
+func (t *stageTimer) since(stage string, start time.Time) {
+	if t == nil {
+		return
+	}
+	d := time.Since(start)
+	t.mu.Lock()
+	t.stages[stage] += d
+	t.mu.Unlock()
+}
+
+// reset starts the next lap now without charging the time since the last
+// one to any stage.
+func (t *stageTimer) reset() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.mark = time.Now()
+	t.mu.Unlock()
+}
+
+// lap adds the time since the previous lap (or reset) to stage.
+func (t *stageTimer) lap(stage string) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.mu.Lock()
+	t.stages[stage] += now.Sub(t.mark)
+	t.mark = now
+	t.mu.Unlock()
+}
+
+// token notes when the first token went out.
+func (t *stageTimer) token() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if t.firstToken == 0 {
+		t.firstToken = time.Since(t.start)
+	}
+	t.mu.Unlock()
+}
+
+// timings returns the stages in milliseconds. Stages that did not run are
+// omitted, except total.
+func (t *stageTimer) timings() map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]int64, len(timingStages))
+	for stage, d := range t.stages {
+		out[stage] = d.Milliseconds()
+	}
+	if dispatch, ok := t.stages[stageDispatch]; ok {
+		own := dispatch - t.stages[stageGateway] - t.stages[stageOpenAI]
+		if own < 0 {
+			own = 0
+		}
+		out[stageAggregation] = own.Milliseconds()
+	}
+	if t.firstToken > 0 {
+		out[stageFirstToken] = t.firstToken.Milliseconds()
+	}
+	out[stageTotal] = time.Since(t.start).Milliseconds()
+	return out
+}
+
+// timingSummary renders timings as one key=value log line.
+func timingSummary(conversationID string, timings map[string]int64) string {
+	parts := make([]string, 0, len(timingStages)+1)
+	if conversationID != "" {
+		parts = append(parts, "conversation="+conversationID)
+	}
+	for _, stage := range timingStages {
+		if ms, ok := timings[stage]; ok {
+			parts = append(parts, fmt.Sprintf("%s_ms=%d", stage, ms))
+		}
+	}
+	return "chat timings: " + strings.Join(parts, " ")
+}
+
+// wantsTimings reports whether the stage breakdown goes into Meta: the
+// request asked for it or is being debugged. It is logged either way.
+func (c *ChatService) wantsTimings(req models.ChatRequest) bool {
+	return req.IncludeTimings || req.Debug || c.wantsDebug(req)
+}
+
+// withTimings logs the request's stage breakdown and adds it to resp.Meta
+// when wanted.
+func (c *ChatService) withTimings(t *stageTimer, req models.ChatRequest, resp models.ChatResponse) models.ChatResponse {
+	timings := t.timings()
+	log.Print(timingSummary(strings.TrimSpace(req.ConversationID), timings))
+	if !c.wantsTimings(req) {
+		return resp
+	}
+	if resp.Meta == nil {
+		resp.Meta = &models.ResponseMeta{}
+	} else {
+		m := *resp.Meta
+		resp.Meta = &m
+	}
+	resp.Meta.Timings = timings
+	return resp
+}
@@ -0,0 +1,99 @@
+package services
+
+// Limits bounds how much the handlers ask the gateway for and how much of it
+// ends up in answers, steps and model prompts. Zero fields take the
+// defaults; fields above the maxima are capped, so a bad setting cannot send
+// page_size=100000 to the gateway.
+type Limits struct {
+	// PopPageSize and PopMaxPages page /pop listings.
+	PopPageSize int
+	PopMaxPages int
+	// ListPageSize pages the other gateway listings: /metrics/latest,
+	// /ads/devices, /ads/campaigns and /ads/creatives.
+	ListPageSize int
+	// MetricsMaxPages caps the fleet-wide scans of /metrics/latest and the
+	// /ads/devices inventory, and the history read for a device baseline.
+	MetricsMaxPages int
+	// DisplayTopN is how many rows a ranked or listed answer shows.
+	DisplayTopN int
+	// FullListMax is how many rows it shows when the question asks for all
+	// of them ("all kiosks", "full list").
+	FullListMax int
+	// StepBodyClip is how much of a gateway response a Step keeps.
+	StepBodyClip int
+	// ToolBodyClip is how much of a tool result or context JSON is sent to
+	// the model.
+	ToolBodyClip int
+	// HistoryLimit is how many stored messages hydrate a conversation.
+	HistoryLimit int
+	// PopRawRangeDays is the longest date range read as raw /pop rows in
+	// one crawl; PopRangeMaxChunks caps the month-sized chunks a longer
+	// range is split into.
+	PopRawRangeDays   int
+	PopRangeMaxChunks int
+}
+
+// DefaultLimits are the limits used for zero fields.
+var DefaultLimits = Limits{
+	PopPageSize:       200,
+	PopMaxPages:       10,
+	ListPageSize:      200,
+	MetricsMaxPages:   10,
+	DisplayTopN:       10,
+	FullListMax:       100,
+	StepBodyClip:      2000,
+	ToolBodyClip:      8000,
+	HistoryLimit:      50,
+	PopRawRangeDays:   62,
+	PopRangeMaxChunks: 6,
+}
+
+// MaxLimits are the largest values Limits accepts.
+var MaxLimits = Limits{
+	PopPageSize:       1000,
+	PopMaxPages:       100,
+	ListPageSize:      1000,
+	MetricsMaxPages:   100,
+	DisplayTopN:       100,
+	FullListMax:       1000,
+	StepBodyClip:      20000,
+	ToolBodyClip:      32000,
+	HistoryLimit:      500,
+	PopRawRangeDays:   366,
+	PopRangeMaxChunks: 24,
+}
+
+// Normalized fills zero or negative fields from DefaultLimits and caps the
+// rest at MaxLimits.
+func (l Limits) Normalized() Limits {
+	clamp := func(v, def, hi int) int {
+		if v <= 0 {
+			return def
+		}
+		return min(v, hi)
+	}
+	d, m := DefaultLimits, MaxLimits
+	return Limits{
+		PopPageSize:       clamp(l.PopPageSize, d.PopPageSize, m.PopPageSize),
+		PopMaxPages:       clamp(l.PopMaxPages, d.PopMaxPages, m.PopMaxPages),
+		ListPageSize:      clamp(l.ListPageSize, d.ListPageSize, m.ListPageSize),
+		MetricsMaxPages:   clamp(l.MetricsMaxPages, d.MetricsMaxPages, m.MetricsMaxPages),
+		DisplayTopN:       clamp(l.DisplayTopN, d.DisplayTopN, m.DisplayTopN),
+		FullListMax:       clamp(l.FullListMax, d.FullListMax, m.FullListMax),
+		StepBodyClip:      clamp(l.StepBodyClip, d.StepBodyClip, m.StepBodyClip),
+		ToolBodyClip:      clamp(l.ToolBodyClip, d.ToolBodyClip, m.ToolBodyClip),
+		HistoryLimit:      clamp(l.HistoryLimit, d.HistoryLimit, m.HistoryLimit),
+		PopRawRangeDays:   clamp(l.PopRawRangeDays, d.PopRawRangeDays, m.PopRawRangeDays),
+		PopRangeMaxChunks: clamp(l.PopRangeMaxChunks, d.PopRangeMaxChunks, m.PopRangeMaxChunks),
+	}
+}
+
+// limits returns c.Limits with defaults and maxima applied.
+func (c *ChatService) limits() Limits {
+	return c.Limits.Normalized()
+}
+
+// clipStep clips a gateway response body for a Step.
+func (c *ChatService) clipStep(body string) string {
+	return clipString(body, c.limits().StepBodyClip)
+}
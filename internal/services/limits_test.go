@@ -0,0 +1,70 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// One handler under two Limits: the page size it asks /pop for, the pages it
+// reads and the kiosks it lists all follow the configuration.
+func TestLimitsDriveKioskWisePlays(t *testing.T) {
+	cases := []struct {
+		name   string
+		limits Limits
+		calls  []string
+		want   []string
+		absent []string
+	}{
+		{
+			name:   "small pages",
+			limits: Limits{PopPageSize: 2},
+			calls: []string{
+				"/pop?poster_name=Bet+365&city=moco&page=1&page_size=2",
+				"/pop?poster_name=Bet+365&city=moco&page=2&page_size=2",
+			},
+			want:   []string{"305 plays", "1. Briggs Lobby — 260 plays", "2. Briggs Annex — 45 plays"},
+			absent: []string{"Showing", "page limit reached"},
+		},
+		{
+			name:   "one page, one row shown",
+			limits: Limits{PopPageSize: 3, PopMaxPages: 1, DisplayTopN: 1},
+			calls:  []string{"/pop?poster_name=Bet+365&city=moco&page=1&page_size=3"},
+			want: []string{
+				"245 plays",
+				"1. Briggs Lobby — 200 plays",
+				"Showing 1 of 2 kiosks",
+				"Note: totals are based on the first 3 of 4 POP rows (page limit reached).",
+			},
+			absent: []string{"2. Briggs Annex"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})
+			c := newTestChat(g)
+			c.Limits = tc.limits
+			resp := chatOnce(t, c, "play count for poster Bet 365 in moco kiosk wise")
+			if got := g.Calls("/pop"); !reflect.DeepEqual(got, tc.calls) {
+				t.Errorf("/pop calls\n got %v\nwant %v", got, tc.calls)
+			}
+			for _, w := range tc.want {
+				if !strings.Contains(resp.Answer, w) {
+					t.Errorf("answer\n%s\nlacks %q", resp.Answer, w)
+				}
+			}
+			for _, a := range tc.absent {
+				if strings.Contains(resp.Answer, a) {
+					t.Errorf("answer\n%s\nhas %q", resp.Answer, a)
+				}
+			}
+		})
+	}
+}
+
+func TestLimitsNormalized(t *testing.T) {
+	got := Limits{PopPageSize: -1, PopMaxPages: 5000, DisplayTopN: 3}.Normalized()
+	if got.PopPageSize != DefaultLimits.PopPageSize || got.PopMaxPages != MaxLimits.PopMaxPages || got.DisplayTopN != 3 || got.ListPageSize != DefaultLimits.ListPageSize {
+		t.Errorf("Normalized = %+v", got)
+	}
+}
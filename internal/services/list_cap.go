@@ -0,0 +1,75 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// fullListRe matches questions that ask for every row rather than the top
+// few: "all kiosks", "every device", "full list", "show all". A bare
+// "complete" is not enough, since it is also a campaign status word.
+var fullListRe = regexp.MustCompile(`\b(?:(?:show|list)\s+(?:me\s+)?)?(?:all|every)\s+(?:the\s+|of\s+the\s+|its\s+)?(?:kiosks?|devices?|screens?|servers?|hosts?|posters?|rows?|results?)\b|\b(?:full|complete|entire|whole)\s+list\b|\b(?:show|list)\s+(?:me\s+)?all\b|\ball\s+of\s+them\b`)
+
+func wantsFullList(msgLower string) bool {
+	return fullListRe.MatchString(msgLower)
+}
+
+// fullListPhraseRe is fullListRe with a leading preposition, for removing
+// the phrase from a name ("bet365 across all kiosks" -> "bet365"). A
+// trailing "across all" is matched too, for names whose "kiosks" was
+// already stripped.
+var fullListPhraseRe = regexp.MustCompile(`(?i)(?:\b(?:across|on|in|for|at|over)\s+)?(?:` + fullListRe.String() + `)|\b(?:across|on|in|for|at|over)\s+(?:all|every)\s*$`)
+
+// withoutFullList removes "all kiosks"-style phrasing from s.
+func withoutFullList(s string) string {
+	return strings.Join(strings.Fields(fullListPhraseRe.ReplaceAllString(s, " ")), " ")
+}
+
+// listCap is how many of a list's rows an answer shows.
+type listCap struct {
+	Shown int
+	Total int
+	// More is set when rows past Total exist but were not fetched, so the
+	// true total is unknown.
+	More bool
+	// Full is set when the question asked for the whole list.
+	Full bool
+	// Noun names the rows in the note, plural ("kiosks").
+	Noun string
+}
+
+// listLimit is how many rows a list answer shows: DisplayTopN, or
+// FullListMax when the question asks for all of them.
+func (c *ChatService) listLimit(msgLower string) (int, bool) {
+	if wantsFullList(msgLower) {
+		return c.limits().FullListMax, true
+	}
+	return c.limits().DisplayTopN, false
+}
+
+// capList caps a list of total rows at listLimit.
+func (c *ChatService) capList(msgLower string, total int, noun string) listCap {
+	limit, full := c.listLimit(msgLower)
+	return listCap{Shown: min(total, limit), Total: total, Full: full, Noun: noun}
+}
+
+// note states how the rows shown compare with the total: every row when the
+// whole list was asked for and fits, otherwise how to see the rest. It is ""
+// for a short list shown whole.
+func (lc listCap) note() string {
+	const narrow = "narrow the question to a city, region or shorter period to see the rest."
+	switch {
+	case lc.More && lc.Full:
+		return fmt.Sprintf("Showing %d %s, the most one answer lists; more exist, so %s", lc.Shown, lc.Noun, narrow)
+	case lc.More:
+		return fmt.Sprintf("Showing %d %s; more exist, so ask for all %s to see the full list.", lc.Shown, lc.Noun, lc.Noun)
+	case lc.Full && lc.Shown >= lc.Total:
+		return fmt.Sprintf("Showing all %d %s.", lc.Total, lc.Noun)
+	case lc.Full:
+		return fmt.Sprintf("Showing %d of %d %s, the most one answer lists; %s", lc.Shown, lc.Total, lc.Noun, narrow)
+	case lc.Shown < lc.Total:
+		return fmt.Sprintf("Showing %d of %d %s; ask for all %s to see the full list.", lc.Shown, lc.Total, lc.Noun, lc.Noun)
+	}
+	return ""
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of listed entities.
+const (
+	listKindCampaign = "campaign"
+	listKindDevice   = "device"
+	listKindVenue    = "venue"
+	listKindPoster   = "poster"
+	// listKindKiosk is a kiosk of a kiosk-wise POP breakdown, named by
+	// kiosk_name rather than host.
+	listKindKiosk = "kiosk"
+)
+
+// maxListedEntities caps the entities remembered from one list.
+const maxListedEntities = 50
+
+// listedEntity is one item of a list shown in an answer.
+type listedEntity struct {
+	ID   string
+	Name string
+}
+
+// listedEntities is the last numbered or bulleted list an answer showed, in
+// display order, so "the second one" can be resolved against it. It is
+// replaced, never modified, so conversationState.clone can share it.
+type listedEntities struct {
+	Kind  string
+	Items []listedEntity
+	At    time.Time
+	// Ranking is set for top-N answers, which can be drilled into.
+	Ranking *listRanking
+}
+
+// rememberList records the entities of a list just rendered.
+func (c *ChatService) rememberList(conversationID, kind string, items []listedEntity) {
+	c.storeList(conversationID, kind, items, nil)
+}
+
+func (c *ChatService) storeList(conversationID, kind string, items []listedEntity, ranking *listRanking) {
+	if strings.TrimSpace(conversationID) == "" || len(items) == 0 {
+		return
+	}
+	if len(items) > maxListedEntities {
+		items = items[:maxListedEntities]
+	}
+	list := &listedEntities{Kind: kind, Items: append([]listedEntity(nil), items...), At: time.Now(), Ranking: ranking}
+	c.updateConversationState(conversationID, func(st *conversationState) {
+		st.LastList = list
+	})
+}
+
+var (
+	listOrdinalRe = regexp.MustCompile(`\b(the\s+)?(first|second|third|fourth|fifth|sixth|seventh|eighth|ninth|tenth|last|\d{1,2}(?:st|nd|rd|th))\s+(one|campaign|kiosk|device|screen|host|venue|poster)\b`)
+	listNumberRe  = regexp.MustCompile(`(?:\b(?:the\s+)?(?:number|no\.)\s*|#)(\d{1,2})\b`)
+	listPronounRe = regexp.MustCompile(`\b(?:that|this|the same)\s+(one|campaign|kiosk|device|screen|host|venue|poster)\b`)
+	listItRe      = regexp.MustCompile(`\bit\b`)
+)
+
+var listOrdinalWords = map[string]int{
+	"first": 1, "second": 2, "third": 3, "fourth": 4, "fifth": 5,
+	"sixth": 6, "seventh": 7, "eighth": 8, "ninth": 9, "tenth": 10,
+}
+
+// listNounKinds maps the noun of a reference to the list kind it needs; "one"
+// fits any list.
+var listNounKinds = map[string]string{
+	"campaign": listKindCampaign,
+	"kiosk":    listKindDevice,
+	"device":   listKindDevice,
+	"screen":   listKindDevice,
+	"host":     listKindDevice,
+	"venue":    listKindVenue,
+	"poster":   listKindPoster,
+}
+
+// listReference is an ordinal or pronoun found in a message. Index is 1-based,
+// -1 for "last" and 0 for a pronoun; It marks a bare "it".
+type listReference struct {
+	Phrase string
+	Kind   string
+	Index  int
+	It     bool
+}
+
+// findListReference locates the first list reference in msgLower: an
+// ordinal ("the second one", "the last kiosk", "number 3"), a demonstrative
+// ("that one", "this campaign"), or a bare "it" in a short follow-up.
+// Ordinals without "the" only count before "one", so "my first campaign"
+// is not a reference.
+func findListReference(msgLower string) (listReference, bool) {
+	if m := listOrdinalRe.FindStringSubmatch(msgLower); m != nil && (m[1] != "" || m[3] == "one") {
+		ref := listReference{Phrase: m[0], Kind: listNounKinds[m[3]]}
+		switch word := m[2]; {
+		case word == "last":
+			ref.Index = -1
+		case listOrdinalWords[word] > 0:
+			ref.Index = listOrdinalWords[word]
+		default:
+			ref.Index, _ = strconv.Atoi(strings.TrimRight(word, "stndrh"))
+		}
+		return ref, ref.Index != 0
+	}
+	if m := listNumberRe.FindStringSubmatch(msgLower); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return listReference{Phrase: m[0], Index: n}, n > 0
+	}
+	if m := listPronounRe.FindStringSubmatch(msgLower); m != nil {
+		return listReference{Phrase: m[0], Kind: listNounKinds[m[1]]}, true
+	}
+	if len(strings.Fields(msgLower)) <= 6 {
+		if loc := listItRe.FindStringIndex(msgLower); loc != nil {
+			return listReference{Phrase: msgLower[loc[0]:loc[1]], It: true}, true
+		}
+	}
+	return listReference{}, false
+}
+
+// entityReference is how a resolved entity is written into the question so
+// the handlers' own parsing picks it up.
+func entityReference(kind string, e listedEntity) string {
+	switch kind {
+	case listKindCampaign:
+		return "campaign " + e.ID
+	case listKindVenue:
+		return "venue " + e.ID
+	case listKindPoster:
+		if looksLikeUUID(e.ID) {
+			return "poster " + e.ID
+		}
+		return "poster " + e.Name
+	}
+	return e.ID
+}
+
+func entityLabel(e listedEntity) string {
+	if e.Name == "" || strings.EqualFold(e.Name, e.ID) {
+		return e.ID
+	}
+	return e.Name + " (" + e.ID + ")"
+}
+
+// hasAntecedent reports whether the conversation already tracks an entity of
+// kind outside the list, which a pronoun may just as well mean.
+func (st *conversationState) hasAntecedent(kind string) bool {
+	switch kind {
+	case listKindCampaign:
+		return st.CampaignID != ""
+	case listKindDevice:
+		return st.Host != ""
+	case listKindVenue:
+		return st.VenueID > 0
+	case listKindPoster:
+		return st.PosterName != "" || st.PosterID != ""
+	}
+	return false
+}
+
+// listClarification asks which listed entity a pronoun means.
+func listClarification(list *listedEntities) string {
+	lines := []string{fmt.Sprintf("Which %s do you mean? Reply with its number:", list.Kind)}
+	for i, e := range list.Items {
+		if i >= 10 {
+			lines = append(lines, fmt.Sprintf("…and %d more", len(list.Items)-10))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, entityLabel(e)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// replacePhrase substitutes the first whole-word, case-insensitive
+// occurrence of phrase in msg, keeping the rest of the message as typed.
+func replacePhrase(msg, phrase, with string) string {
+	re := regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(phrase) + `\b`)
+	loc := re.FindStringIndex(msg)
+	if loc == nil {
+		return strings.TrimSpace(msg) + " " + with
+	}
+	return msg[:loc[0]] + with + msg[loc[1]:]
+}
+
+// resolveListReference rewrites ordinal and pronoun references to the last
+// list shown in the conversation ("show impressions for the second one")
+// into the entity they point at, and remembers that entity as the current
+// one. It returns the rewritten message and a note for the interpretation
+// header, or a clarification to answer instead. ok is false when the message
+// refers to no list entity.
+func (c *ChatService) resolveListReference(conversationID, msg string) (rewritten, note, clarify string, ok bool) {
+	st := c.getConversationState(conversationID)
+	if st == nil {
+		return "", "", "", false
+	}
+	list := st.LastList
+	msgLower := strings.ToLower(strings.TrimSpace(msg))
+
+	// A reply to our own "which one do you mean?" completes the question
+	// that asked it.
+	if st.PendingHandler == "listReference" && list != nil {
+		idx := 0
+		if ref, found := findListReference(msgLower); found && ref.Index != 0 {
+			idx = ref.Index
+		} else if len(strings.Fields(msgLower)) <= 3 {
+			idx = extractFirstInt(msgLower)
+		}
+		if idx == -1 {
+			idx = len(list.Items)
+		}
+		if idx >= 1 && idx <= len(list.Items) {
+			pending := st.PendingMessage
+			c.clearPending(conversationID)
+			e := list.Items[idx-1]
+			c.rememberEntity(conversationID, list.Kind, e)
+			base := pending
+			if ref, found := findListReference(strings.ToLower(pending)); found {
+				base = replacePhrase(pending, ref.Phrase, entityReference(list.Kind, e))
+			} else {
+				base = strings.TrimSpace(pending) + " " + entityReference(list.Kind, e)
+			}
+			return base, fmt.Sprintf("Taking it as %s.", entityLabel(e)), "", true
+		}
+	}
+
+	ref, found := findListReference(msgLower)
+	if !found {
+		return "", "", "", false
+	}
+	if list == nil {
+		// Ordinals need a list; pronouns may point at remembered context.
+		if ref.Index != 0 {
+			return "", "", fmt.Sprintf("I haven't shown a list in this conversation yet, so I can't tell which %s \"%s\" means. Please name it.", firstNonEmpty(ref.Kind, "item"), ref.Phrase), true
+		}
+		return "", "", "", false
+	}
+	if ref.Kind != "" && ref.Kind != list.Kind {
+		return "", "", "", false
+	}
+	idx := ref.Index
+	switch {
+	case idx == -1:
+		idx = len(list.Items)
+	case idx > len(list.Items):
+		return "", "", fmt.Sprintf("The last list had only %d %s(s); which one do you mean?", len(list.Items), list.Kind), true
+	case idx == 0 && len(list.Items) == 1:
+		idx = 1
+	case idx == 0:
+		// "it" is too common to ask about; only "that one" and the like
+		// get a clarification.
+		if ref.It || st.hasAntecedent(list.Kind) {
+			return "", "", "", false
+		}
+		c.setPending(conversationID, "listReference", msg)
+		return "", "", listClarification(list), true
+	}
+	e := list.Items[idx-1]
+	c.rememberEntity(conversationID, list.Kind, e)
+	return replacePhrase(msg, ref.Phrase, entityReference(list.Kind, e)), fmt.Sprintf("Taking \"%s\" as %s.", ref.Phrase, entityLabel(e)), "", true
+}
+
+// rememberEntity makes a resolved list entity the conversation's current one.
+func (c *ChatService) rememberEntity(conversationID, kind string, e listedEntity) {
+	switch kind {
+	case listKindCampaign:
+		c.updateConversationCampaignID(conversationID, e.ID)
+	case listKindDevice:
+		c.updateConversationHost(conversationID, e.ID)
+	case listKindVenue:
+		if id, err := strconv.Atoi(e.ID); err == nil {
+			c.updateConversationVenueID(conversationID, id)
+		}
+	case listKindPoster:
+		c.updateConversationPoster(conversationID, e.Name, "", "")
+		c.updateConversationPosterID(conversationID, e.ID)
+	}
+}
@@ -0,0 +1,405 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (c *ChatService) isKnownRegionCode(ctx context.Context, code string) bool {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == "" {
+		return false
+	}
+	c.cityMu.Lock()
+	defer c.cityMu.Unlock()
+
+	c.ensureCityRegionCachesLocked(ctx)
+	if c.regionCache == nil {
+		return false
+	}
+	_, ok := c.regionCache[code]
+	return ok
+}
+
+func (c *ChatService) isKnownProjectCityCode(ctx context.Context, code string) bool {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == "" {
+		return false
+	}
+	c.projectMu.Lock()
+	defer c.projectMu.Unlock()
+
+	c.ensureProjectLookupsLocked(ctx)
+	if c.projectCityCache == nil {
+		return false
+	}
+	_, ok := c.projectCityCache[code]
+	return ok
+}
+
+type projectLookup struct {
+	city        string
+	name        string
+	description string
+	words       []string
+}
+
+func (c *ChatService) ensureCityRegionCachesLocked(ctx context.Context) {
+	if c.cityCacheTTL <= 0 {
+		c.cityCacheTTL = 10 * time.Minute
+	}
+	if c.cityCache != nil && !c.cityCacheAt.IsZero() && time.Since(c.cityCacheAt) < c.cityCacheTTL &&
+		c.regionCache != nil && !c.regionCacheAt.IsZero() && time.Since(c.regionCacheAt) < c.cityCacheTTL {
+		return
+	}
+	if c.Gateway == nil {
+		return
+	}
+
+	status, body, err := c.Gateway.Get(withoutFreshness(ctx), "/ads/devices/counts/regions")
+	_ = status
+	if err != nil {
+		return
+	}
+	var root map[string]any
+	if json.Unmarshal(body, &root) != nil {
+		return
+	}
+	rows, _ := root["data"].([]any)
+
+	citySet := map[string]struct{}{}
+	regionSet := map[string]struct{}{}
+	for _, it := range rows {
+		m, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		city, _ := m["city"].(string)
+		city = strings.ToLower(strings.TrimSpace(city))
+		if city != "" {
+			citySet[city] = struct{}{}
+		}
+		region, _ := m["region"].(string)
+		region = strings.ToLower(strings.TrimSpace(region))
+		if region != "" {
+			regionSet[region] = struct{}{}
+		}
+	}
+
+	// Only overwrite caches when we have data; otherwise keep last good values.
+	now := time.Now()
+	if len(citySet) > 0 {
+		c.cityCache = citySet
+		c.cityCacheAt = now
+	}
+	if len(regionSet) > 0 {
+		c.regionCache = regionSet
+		c.regionCacheAt = now
+	}
+}
+
+func (c *ChatService) cityCodes(ctx context.Context) []string {
+	c.cityMu.Lock()
+	defer c.cityMu.Unlock()
+
+	c.ensureCityRegionCachesLocked(ctx)
+	if c.cityCache != nil && !c.cityCacheAt.IsZero() && time.Since(c.cityCacheAt) < c.cityCacheTTL {
+		out := make([]string, 0, len(c.cityCache))
+		for k := range c.cityCache {
+			out = append(out, k)
+		}
+		return out
+	}
+	if c.cityCache != nil {
+		out := make([]string, 0, len(c.cityCache))
+		for k := range c.cityCache {
+			out = append(out, k)
+		}
+		return out
+	}
+	return nil
+}
+
+func (c *ChatService) detectCityCode(ctx context.Context, msgLower string) string {
+	s := strings.ToLower(strings.TrimSpace(msgLower))
+	if s == "" {
+		return ""
+	}
+	codes := c.cityCodes(ctx)
+	if len(codes) == 0 {
+		return hostScopeFrom(ctx).City
+	}
+	sort.Slice(codes, func(i, j int) bool { return len(codes[i]) > len(codes[j]) })
+	for _, city := range codes {
+		city = strings.ToLower(strings.TrimSpace(city))
+		if city == "" {
+			continue
+		}
+		if strings.Contains(s, city) {
+			if !cityCandidateAllowed(city, s) {
+				continue
+			}
+			return city
+		}
+	}
+	if projectCity := c.detectCityFromProjects(ctx, s); projectCity != "" {
+		return projectCity
+	}
+	// Fall back to the city of a host the request names.
+	return hostScopeFrom(ctx).City
+}
+
+func (c *ChatService) detectCityFromProjects(ctx context.Context, msgLower string) string {
+	s := strings.ToLower(strings.TrimSpace(msgLower))
+	if s == "" {
+		return ""
+	}
+
+	// If the user provided a region code (e.g. "brt", "kc") and did not explicitly ask for a city,
+	// do not infer a city from project description/keywords. This avoids cases like "show brt stats"
+	// incorrectly mapping to a city whose description mentions BRT.
+	regionCode := c.detectRegionCode(ctx, s)
+	regionOnly := regionCode != "" && !strings.Contains(s, "city")
+
+	c.projectMu.Lock()
+	defer c.projectMu.Unlock()
+
+	c.ensureProjectLookupsLocked(ctx)
+	if len(c.projectLookups) == 0 {
+		return ""
+	}
+
+	msgWords := tokenizeWords(s)
+	wordSet := make(map[string]struct{}, len(msgWords))
+	for _, w := range msgWords {
+		if len(w) < 2 {
+			continue
+		}
+		wordSet[w] = struct{}{}
+	}
+
+	bestCity := ""
+	bestScore := 0
+
+	for _, lookup := range c.projectLookups {
+		if lookup.city == "" {
+			continue
+		}
+		score := 0
+		if len(lookup.city) <= 2 {
+			if containsWord(s, lookup.city) {
+				score = len(lookup.city)
+			}
+		} else if strings.Contains(s, lookup.city) {
+			score = len(lookup.city)
+		} else {
+			for _, w := range msgWords {
+				if len(w) < 3 {
+					continue
+				}
+				if strings.Contains(lookup.city, w) && len(w) > score {
+					score = len(w)
+				}
+			}
+		}
+
+		if score == 0 && lookup.name != "" && lookup.name != lookup.city && strings.Contains(s, lookup.name) {
+			score = len(lookup.name)
+		}
+
+		if score == 0 && lookup.description != "" && strings.Contains(s, lookup.description) {
+			score = len(lookup.description)
+		}
+
+		if score == 0 && len(lookup.words) > 0 && !regionOnly {
+			for _, alias := range lookup.words {
+				if len(alias) < 3 {
+					continue
+				}
+				if strings.Contains(s, alias) || strings.Contains(alias, s) {
+					if len(alias) > score {
+						score = len(alias)
+					}
+					continue
+				}
+				if _, ok := wordSet[alias]; ok && len(alias) > score {
+					score = len(alias)
+				}
+			}
+		}
+
+		if score > bestScore {
+			bestScore = score
+			bestCity = lookup.city
+		}
+	}
+
+	return bestCity
+}
+
+func (c *ChatService) ensureProjectLookupsLocked(ctx context.Context) {
+	if c.projectCacheTTL <= 0 {
+		c.projectCacheTTL = 10 * time.Minute
+	}
+	if c.projectLookups != nil && !c.projectCityCacheAt.IsZero() && time.Since(c.projectCityCacheAt) < c.projectCacheTTL {
+		return
+	}
+	if c.Gateway == nil {
+		return
+	}
+
+	status, body, err := c.Gateway.Get(withoutFreshness(ctx), "/ads/projects?page=1&page_size="+strconv.Itoa(c.limits().ListPageSize))
+	if err != nil || status < 200 || status >= 300 {
+		return
+	}
+
+	var resp struct {
+		Data []struct {
+			Name        string  `json:"name"`
+			Description *string `json:"description"`
+		} `json:"data"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return
+	}
+
+	lookups := make([]projectLookup, 0, len(resp.Data))
+	citySet := make(map[string]struct{})
+	for _, item := range resp.Data {
+		nameLower := strings.ToLower(strings.TrimSpace(item.Name))
+		if nameLower == "" {
+			continue
+		}
+		descLower := ""
+		if item.Description != nil {
+			descLower = strings.ToLower(strings.TrimSpace(*item.Description))
+		}
+		words := collectProjectWords(nameLower, descLower)
+		lookups = append(lookups, projectLookup{
+			city:        nameLower,
+			name:        nameLower,
+			description: descLower,
+			words:       words,
+		})
+		citySet[nameLower] = struct{}{}
+	}
+
+	if len(lookups) == 0 {
+		return
+	}
+
+	c.projectLookups = lookups
+	c.projectCityCache = citySet
+	c.projectCityCacheAt = time.Now()
+}
+
+func cityCandidateAllowed(city, msgLower string) bool {
+	if strings.TrimSpace(city) == "" || strings.TrimSpace(msgLower) == "" {
+		return false
+	}
+	if len(city) >= 3 {
+		return true
+	}
+	return strings.Contains(msgLower, "city") || strings.Contains(msgLower, "cities")
+}
+
+func (c *ChatService) regionCodes(ctx context.Context) []string {
+	c.cityMu.Lock()
+	defer c.cityMu.Unlock()
+
+	c.ensureCityRegionCachesLocked(ctx)
+	if c.regionCache != nil && !c.regionCacheAt.IsZero() && time.Since(c.regionCacheAt) < c.cityCacheTTL {
+		out := make([]string, 0, len(c.regionCache))
+		for k := range c.regionCache {
+			out = append(out, k)
+		}
+		return out
+	}
+	if c.regionCache != nil {
+		out := make([]string, 0, len(c.regionCache))
+		for k := range c.regionCache {
+			out = append(out, k)
+		}
+		return out
+	}
+	return nil
+}
+
+func (c *ChatService) detectRegionCode(ctx context.Context, msgLower string) string {
+	s := strings.ToLower(strings.TrimSpace(msgLower))
+	if s == "" {
+		return ""
+	}
+
+	// Prefer explicit "<code> region" or "region <code>" patterns to avoid mis-detecting city tokens
+	// as region tokens when both are present (e.g. "moco city brt region").
+	if strings.Contains(s, "region") {
+		words := tokenizeWords(s)
+		for i := 0; i < len(words); i++ {
+			if words[i] != "region" {
+				continue
+			}
+			// region <code>
+			if i+1 < len(words) {
+				cand := strings.ToLower(strings.TrimSpace(words[i+1]))
+				if cand != "" && c.isKnownRegionCode(ctx, cand) {
+					// If the user also mentioned "city" and this token looks like a city code,
+					// do not treat it as a region.
+					if strings.Contains(s, "city") && c.isKnownProjectCityCode(ctx, cand) {
+						// continue searching; this is likely the city token (e.g. "moco city")
+					} else {
+						return cand
+					}
+				}
+			}
+			// <code> region
+			if i-1 >= 0 {
+				cand := strings.ToLower(strings.TrimSpace(words[i-1]))
+				if cand != "" && c.isKnownRegionCode(ctx, cand) {
+					if strings.Contains(s, "city") && c.isKnownProjectCityCode(ctx, cand) {
+						// continue searching
+					} else {
+						return cand
+					}
+				}
+			}
+		}
+	}
+
+	// Minimal aliases for common user phrasing.
+	if strings.Contains(s, "bus rapid transit") {
+		return "brt"
+	}
+
+	codes := c.regionCodes(ctx)
+	if len(codes) == 0 {
+		return hostScopeFrom(ctx).Region
+	}
+	sort.Slice(codes, func(i, j int) bool { return len(codes[i]) > len(codes[j]) })
+	for _, r := range codes {
+		r = strings.ToLower(strings.TrimSpace(r))
+		if r == "" {
+			continue
+		}
+		// If a token is both a region code and a known project city code (e.g. "kcmo"),
+		// treat it as a city unless the user explicitly asked for a region.
+		if !strings.Contains(s, "region") && len(r) > 3 && c.isKnownProjectCityCode(ctx, r) {
+			continue
+		}
+		// Avoid false positives for very short codes like "da" matching inside words like "today".
+		if len(r) <= 2 {
+			if containsWord(s, r) {
+				return r
+			}
+			continue
+		}
+		if strings.Contains(s, r) {
+			return r
+		}
+	}
+	return hostScopeFrom(ctx).Region
+}
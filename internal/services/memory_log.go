@@ -0,0 +1,332 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// memoryLogCap bounds the change log kept per conversation; older events are
+// dropped first.
+const memoryLogCap = 50
+
+// memorySourceHistory is the source of values recovered from the stored
+// history after a restart (see ensureConversationStateHydrated).
+const memorySourceHistory = "history"
+
+// memoryEvent is one change to a remembered value. New is empty when the
+// value was cleared.
+type memoryEvent struct {
+	Field   string
+	Old     string
+	New     string
+	Handler string
+	Message string
+	Turn    int
+	At      time.Time
+}
+
+// memoryTurn is the message whose handling is changing the memory. Handler is
+// filled in once the answer is recorded, except for hydration, which sets it
+// up front.
+type memoryTurn struct {
+	Seq     int
+	Message string
+	Handler string
+}
+
+// memoryField is one remembered value the change log tracks.
+type memoryField struct {
+	Name string
+	get  func(st *conversationState) string
+}
+
+var memoryFields = []memoryField{
+	{"poster", func(st *conversationState) string { return st.PosterName }},
+	{"poster id", func(st *conversationState) string { return st.PosterID }},
+	{"poster city", func(st *conversationState) string { return st.PosterCity }},
+	{"poster region", func(st *conversationState) string { return st.PosterRegion }},
+	{"host", func(st *conversationState) string { return st.Host }},
+	{"city", func(st *conversationState) string { return st.City }},
+	{"region", func(st *conversationState) string { return st.Region }},
+	{"campaign", func(st *conversationState) string { return st.CampaignID }},
+	{"venue", func(st *conversationState) string {
+		if st.VenueID <= 0 {
+			return ""
+		}
+		return strconv.Itoa(st.VenueID)
+	}},
+}
+
+// memoryValues snapshots the tracked fields in memoryFields order.
+func (st *conversationState) memoryValues() []string {
+	out := make([]string, len(memoryFields))
+	for i, f := range memoryFields {
+		out[i] = f.get(st)
+	}
+	return out
+}
+
+// recordMemoryChanges appends an event for every tracked field that differs
+// from before, attributed to the current turn.
+func (st *conversationState) recordMemoryChanges(before []string, at time.Time) {
+	for i, f := range memoryFields {
+		after := f.get(st)
+		if after == before[i] {
+			continue
+		}
+		st.MemoryLog = append(st.MemoryLog, memoryEvent{
+			Field:   f.Name,
+			Old:     before[i],
+			New:     after,
+			Handler: st.MemoryTurn.Handler,
+			Message: st.MemoryTurn.Message,
+			Turn:    st.MemoryTurn.Seq,
+			At:      at,
+		})
+	}
+	if n := len(st.MemoryLog); n > memoryLogCap {
+		st.MemoryLog = append([]memoryEvent(nil), st.MemoryLog[n-memoryLogCap:]...)
+	}
+}
+
+// beginMemoryTurn attributes the memory changes that follow to message.
+func (c *ChatService) beginMemoryTurn(conversationID, message string) {
+	c.updateConversationState(conversationID, func(st *conversationState) {
+		st.MemoryTurn = memoryTurn{Seq: st.MemoryTurn.Seq + 1, Message: strings.TrimSpace(message)}
+	})
+}
+
+// attributeMemoryTurn records handler as the source of the changes the
+// current turn made.
+func (c *ChatService) attributeMemoryTurn(conversationID, handler string) {
+	c.updateConversationState(conversationID, func(st *conversationState) {
+		st.MemoryTurn.Handler = handler
+		for i := range st.MemoryLog {
+			if ev := &st.MemoryLog[i]; ev.Turn == st.MemoryTurn.Seq && ev.Handler == "" {
+				ev.Handler = handler
+			}
+		}
+	})
+}
+
+var (
+	memoryShowRe = regexp.MustCompile(`^(?:please\s+|ok(?:ay)?,?\s+|can\s+you\s+)?(?:(?:show|list|tell)\s+(?:me\s+)?what\s+you\s+(?:remember|know|assume)|what\s+(?:do|did)\s+you\s+(?:remember|assume)|what\s+are\s+you\s+(?:remembering|assuming))\b`)
+	memoryWhyRe  = regexp.MustCompile(`^(?:why|how\s+come)\s+(?:do|did)\s+you\s+(?:think|assume|believe|remember)\s+(?:that\s+)?(?:the\s+|my\s+|our\s+|this\s+)?(poster|host|device|kiosk|city|region|location|campaign|venue)\b(?:\s+(?:is|was|=)\s+(.+))?`)
+	// "why do you think the device is offline" questions an answer, not
+	// the memory.
+	memoryStatusRe = regexp.MustCompile(`\b(?:offline|online|down|up|paused|running|active|inactive|ended|over\w*|under\w*|behind|ahead|pacing|broken|wrong|hot|slow|stale|missing|empty)\b`)
+)
+
+// memoryNounFields maps the nouns a "why do you think ..." question uses to
+// the fields it asks about.
+var memoryNounFields = map[string][]string{
+	"poster":   {"poster", "poster id", "poster city", "poster region"},
+	"host":     {"host"},
+	"device":   {"host"},
+	"kiosk":    {"host"},
+	"city":     {"city"},
+	"region":   {"region"},
+	"location": {"city", "region"},
+	"campaign": {"campaign"},
+	"venue":    {"venue"},
+}
+
+// memoryQuestion is a parsed "show what you remember" or "why do you think
+// the region is brt" message. Fields is empty for the former; Claimed is the
+// value the user attributes to the bot, if stated.
+type memoryQuestion struct {
+	Fields  []string
+	Claimed string
+}
+
+func parseMemoryQuestion(msgLower string) (memoryQuestion, bool) {
+	msg := strings.TrimSpace(forgetTrailing.ReplaceAllString(strings.TrimSpace(msgLower), ""))
+	if memoryShowRe.MatchString(msg) {
+		return memoryQuestion{}, true
+	}
+	m := memoryWhyRe.FindStringSubmatch(msg)
+	if m == nil || memoryStatusRe.MatchString(m[2]) {
+		return memoryQuestion{}, false
+	}
+	claimed := strings.Trim(strings.TrimSpace(m[2]), `"'“”‘’`)
+	return memoryQuestion{Fields: memoryNounFields[m[1]], Claimed: claimed}, true
+}
+
+// handleMemoryProvenance answers "show what you remember and why" and "why do
+// you think the region is brt" from the conversation's change log.
+func (c *ChatService) handleMemoryProvenance(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	q, ok := parseMemoryQuestion(strings.ToLower(req.Message))
+	if !ok {
+		return models.ChatResponse{}, false, nil
+	}
+	conversationID := strings.TrimSpace(req.ConversationID)
+	answer := ""
+	if conversationID == "" {
+		answer = "Nothing is remembered outside a conversation."
+	} else {
+		answer = describeMemory(c.getConversationState(conversationID), q, time.Now())
+	}
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer}, true, nil
+}
+
+// describeMemory lists the current value of each field q asks about (all
+// tracked fields when it names none) with the event that set it.
+func describeMemory(st *conversationState, q memoryQuestion, now time.Time) string {
+	fields := q.Fields
+	all := len(fields) == 0
+	if all {
+		for _, f := range memoryFields {
+			fields = append(fields, f.Name)
+		}
+	}
+	current := map[string]string{}
+	for i, v := range st.memoryValues() {
+		current[memoryFields[i].Name] = v
+	}
+	var lines []string
+	for _, name := range fields {
+		last, found := lastMemoryEvent(st.MemoryLog, name)
+		switch {
+		case current[name] != "" && found && last.New == current[name]:
+			lines = append(lines, fmt.Sprintf("- %s=%s — set %s %s", name, current[name], formatAgo(now.Sub(last.At)), describeMemorySource(last)))
+		case current[name] != "":
+			lines = append(lines, fmt.Sprintf("- %s=%s — set before the last %d changes this conversation keeps", name, current[name], memoryLogCap))
+		case found:
+			lines = append(lines, fmt.Sprintf("- %s (was %s) — cleared %s %s", name, last.Old, formatAgo(now.Sub(last.At)), describeMemorySource(last)))
+		}
+	}
+	if len(lines) == 0 {
+		if all {
+			return "Nothing is remembered in this conversation yet. Questions that name a poster, device, city, region, campaign or venue are remembered for follow-ups."
+		}
+		return fmt.Sprintf("No %s has been remembered in this conversation.", q.Fields[0])
+	}
+	head := "Here is what I remember and why:"
+	if !all {
+		head = "Here is where that comes from:"
+		switch v := current[q.Fields[0]]; {
+		case q.Claimed == "":
+		case v == "":
+			head = fmt.Sprintf("No %s is remembered now. Here is what happened to it:", q.Fields[0])
+		case !strings.EqualFold(q.Claimed, v):
+			head = fmt.Sprintf("I remember the %s as %s, not %s. Here is where that comes from:", q.Fields[0], v, q.Claimed)
+		}
+		// A single field also shows the earlier values it replaced.
+		if len(q.Fields) == 1 {
+			history := memoryFieldHistory(st.MemoryLog, q.Fields[0])
+			if len(history) > 1 {
+				lines = append(lines, "Earlier:")
+				for _, ev := range history[1:] {
+					lines = append(lines, fmt.Sprintf("- %s %s", describeMemoryChange(ev), describeMemoryTime(ev, now)))
+				}
+			}
+		}
+	}
+	for _, name := range fields {
+		if current[name] != "" {
+			lines = append(lines, forgetHint(q.Fields))
+			break
+		}
+	}
+	return head + "\n" + strings.Join(lines, "\n")
+}
+
+// lastMemoryEvent is the newest event for field.
+func lastMemoryEvent(log []memoryEvent, field string) (memoryEvent, bool) {
+	for i := len(log) - 1; i >= 0; i-- {
+		if log[i].Field == field {
+			return log[i], true
+		}
+	}
+	return memoryEvent{}, false
+}
+
+// memoryFieldHistory is the events for field, newest first.
+func memoryFieldHistory(log []memoryEvent, field string) []memoryEvent {
+	var out []memoryEvent
+	for i := len(log) - 1; i >= 0; i-- {
+		if log[i].Field == field {
+			out = append(out, log[i])
+		}
+	}
+	return out
+}
+
+func describeMemoryChange(ev memoryEvent) string {
+	switch {
+	case ev.New == "":
+		return fmt.Sprintf("%s %s cleared", ev.Field, ev.Old)
+	case ev.Old == "":
+		return fmt.Sprintf("%s set to %s", ev.Field, ev.New)
+	}
+	return fmt.Sprintf("%s changed from %s to %s", ev.Field, ev.Old, ev.New)
+}
+
+func describeMemoryTime(ev memoryEvent, now time.Time) string {
+	return formatAgo(now.Sub(ev.At)) + " " + describeMemorySource(ev)
+}
+
+// describeMemorySource names the message (and handler) an event came from.
+func describeMemorySource(ev memoryEvent) string {
+	if ev.Handler == memorySourceHistory {
+		return "from earlier messages in this conversation"
+	}
+	src := "while answering another question"
+	if ev.Message != "" {
+		kind := "question"
+		if ev.New == "" {
+			kind = "request"
+		}
+		src = fmt.Sprintf("from your %s '%s'", kind, ev.Message)
+	}
+	if ev.Handler != "" {
+		src += " (" + ev.Handler + ")"
+	}
+	return src
+}
+
+// forgetHint names the forget request that clears fields; campaign and
+// venue are only cleared by "start fresh".
+func forgetHint(fields []string) string {
+	if len(fields) == 0 {
+		return `Say "forget the poster", "forget the region" or "forget the device" to clear one, or "start fresh" to clear everything.`
+	}
+	switch fields[0] {
+	case "host":
+		return `Say "forget the device" to clear it.`
+	case "city", "region":
+		return `Say "forget the region" to clear the location.`
+	case "campaign", "venue":
+		return `Say "start fresh" to clear it.`
+	}
+	return `Say "forget the poster" to clear it.`
+}
+
+// formatAgo renders a time since ("just now", "12 minutes ago", "3 hours
+// ago", "2 days ago").
+func formatAgo(d time.Duration) string {
+	unit, n := "", int64(0)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		unit, n = "minute", int64(d/time.Minute)
+	case d < 24*time.Hour:
+		unit, n = "hour", int64(d/time.Hour)
+	default:
+		unit, n = "day", int64(d/(24*time.Hour))
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s ago", n, unit)
+}
@@ -0,0 +1,303 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"openai-agent-service/internal/models"
+)
+
+// maxIntents bounds the sub-requests one message is split into.
+const maxIntents = 3
+
+// clauseKind is one kind of request a clause of a multi-intent message can
+// be recognised as. hostScoped kinds need a host and posterScoped kinds a
+// poster; a clause missing one borrows it from another clause.
+type clauseKind struct {
+	name         string
+	re           *regexp.Regexp
+	hostScoped   bool
+	posterScoped bool
+}
+
+var clauseKinds = []clauseKind{
+	{name: "device_info", re: regexp.MustCompile(`\b(?:device|kiosk|server|host)\s+(?:info|information|details?)\b|\bdetails?\s+(?:of|for)\b`), hostScoped: true},
+	{name: "telemetry", re: regexp.MustCompile(`\b(?:telemetry|health|cpu|memory|ram|disk|storage|uptime|battery)\b`), hostScoped: true},
+	{name: "pop_day", re: regexp.MustCompile(`\bpop\b.*\b(?:today|yesterday)\b|\b(?:today|yesterday)'?s?\s+pop\b`), hostScoped: true},
+	{name: "poster_plays", re: regexp.MustCompile(`\b(?:play\s*counts?|plays|played)\b`), posterScoped: true},
+	{name: "impressions", re: regexp.MustCompile(`\bimpressions?\b`)},
+	{name: "budget", re: campaignBudgetRe},
+	{name: "pacing", re: regexp.MustCompile(`\b(?:pacing|on[\s-]track)\b`)},
+	{name: "top_list", re: regexp.MustCompile(`\btop\s+(?:\d+\s+)?(?:posters?|devices?|kiosks?|venues?)\b`)},
+	{name: "kiosk_count", re: regexp.MustCompile(`\bhow\s+many\s+(?:kiosks?|devices?|screens?)\b|\b(?:kiosk|device)\s+count\b`)},
+}
+
+// intentSeparatorRe splits "a and b", "a, also b" and "a; b".
+var intentSeparatorRe = regexp.MustCompile(`\s*[,;]\s*(?:and\s+|also\s+)?|\s+and\s+(?:also\s+)?|\s+also\s+`)
+
+// classifyClause returns the single clause kind clause matches, or false
+// when it matches none or several.
+func classifyClause(clauseLower string) (clauseKind, bool) {
+	var found []clauseKind
+	for _, k := range clauseKinds {
+		if k.re.MatchString(clauseLower) {
+			found = append(found, k)
+		}
+	}
+	if len(found) != 1 {
+		return clauseKind{}, false
+	}
+	return found[0], true
+}
+
+// splitIntents splits msg into sub-requests when every clause between
+// "and", "also", "," or ";" is recognised as a different kind of request. A
+// clause that is not recognised ("play count for bet 365 and friends
+// poster") or two clauses of the same kind ("cpu and memory for x") mean
+// the message is one request, and nil is returned. A single host or poster
+// named in one clause is added to the clauses that need one.
+func splitIntents(msg string) []string {
+	raw := intentSeparatorRe.Split(strings.TrimRight(strings.TrimSpace(msg), "?.!"), -1)
+	if len(raw) < 2 {
+		return nil
+	}
+	clauses := make([]string, 0, len(raw))
+	kinds := make([]clauseKind, 0, len(raw))
+	seen := map[string]bool{}
+	for _, r := range raw {
+		clause := strings.TrimSpace(r)
+		if clause == "" {
+			return nil
+		}
+		kind, ok := classifyClause(strings.ToLower(clause))
+		if !ok || seen[kind.name] {
+			return nil
+		}
+		seen[kind.name] = true
+		clauses = append(clauses, clause)
+		kinds = append(kinds, kind)
+	}
+
+	hosts := detectHostTokens(msg)
+	poster := ""
+	for _, clause := range clauses {
+		if p := strings.TrimSpace(extractAfterKeyword(strings.ToLower(clause), "poster")); p != "" {
+			if poster != "" && poster != p {
+				poster = ""
+				break
+			}
+			poster = p
+		}
+	}
+	for i, clause := range clauses {
+		lower := strings.ToLower(clause)
+		switch {
+		case kinds[i].hostScoped && len(hosts) == 1 && len(detectHostTokens(clause)) == 0:
+			clauses[i] = clause + " for " + hosts[0]
+		case kinds[i].posterScoped && poster != "" && !strings.Contains(lower, "poster"):
+			clauses[i] = clause + " for poster " + poster
+		}
+	}
+	return clauses
+}
+
+// chatDispatch answers req, as separate sub-requests when it asks several
+// things at once.
+func (c *ChatService) chatDispatch(ctx context.Context, ownerKey string, req models.ChatRequest, onToken func(string)) (models.ChatResponse, error) {
+	if st := c.getConversationState(req.ConversationID); st == nil || st.PendingHandler == "" {
+		if parts := splitIntents(req.Message); len(parts) > 1 {
+			return c.chatMultiIntent(ctx, ownerKey, req, parts, onToken)
+		}
+	}
+	return c.chatStream(ctx, ownerKey, req, onToken)
+}
+
+// chatMultiIntent runs each part as its own request in the same
+// conversation, in order, so entities the first resolves are remembered
+// for the next. The answers are composed as numbered sections and their
+// steps merged; the call fails only when every part failed.
+func (c *ChatService) chatMultiIntent(ctx context.Context, ownerKey string, req models.ChatRequest, parts []string, onToken func(string)) (models.ChatResponse, error) {
+	note := ""
+	if len(parts) > maxIntents {
+		note = fmt.Sprintf("\n\nYour message asked %d things; I answered the first %d. Ask the rest separately.", len(parts), maxIntents)
+		parts = parts[:maxIntents]
+	}
+	var out models.ChatResponse
+	sections := make([]string, 0, len(parts))
+	var firstErr error
+	failed := 0
+	for i, part := range parts {
+		title := fmt.Sprintf("[%d/%d] %s", i+1, len(parts), part)
+		if i > 0 {
+			title = "\n\n" + title
+		}
+		if onToken != nil {
+			onToken(title + "\n")
+		}
+		sub := req
+		sub.Message = part
+		resp, err := c.chatStream(ctx, ownerKey, sub, onToken)
+		if err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		answer := strings.TrimSpace(resp.Answer)
+		if answer == "" && err != nil {
+			answer = "This part could not be answered: " + err.Error()
+			if onToken != nil {
+				onToken(answer)
+			}
+		}
+		sections = append(sections, title+"\n"+answer)
+		// Citations index the part's own steps; shift them past the steps
+		// already merged.
+		for _, ct := range resp.Citations {
+			shifted := make([]int, len(ct.Steps))
+			for j, s := range ct.Steps {
+				shifted[j] = s + len(out.Steps)
+			}
+			ct.Steps = shifted
+			out.Citations = append(out.Citations, ct)
+		}
+		out.Meta = mergeResponseMeta(out.Meta, resp.Meta, len(out.Steps))
+		out.Steps = append(out.Steps, resp.Steps...)
+		out.Data = mergeChatData(out.Data, resp.Data)
+		if out.Error == nil {
+			out.Error = resp.Error
+		}
+	}
+	out.Answer = strings.Join(sections, "") + note
+	if note != "" && onToken != nil {
+		onToken(note)
+	}
+	if failed == len(parts) {
+		return out, firstErr
+	}
+	return out, nil
+}
+
+// mergeResponseMeta folds a part's meta into the composed answer's. Every
+// field is merged: flags are set when any part set them, counts and
+// timings are summed, lists are joined and the first debug id is kept. The
+// part's steps start at stepOffset in the composed answer.
+func mergeResponseMeta(dst, src *models.ResponseMeta, stepOffset int) *models.ResponseMeta {
+	if src == nil {
+		return dst
+	}
+	if dst == nil {
+		dst = &models.ResponseMeta{}
+	}
+	dst.Truncated = dst.Truncated || src.Truncated
+	dst.TimedOut = dst.TimedOut || src.TimedOut
+	dst.PartialFailure = dst.PartialFailure || src.PartialFailure
+	dst.Replayed = dst.Replayed || src.Replayed
+	for _, s := range src.FailedSteps {
+		dst.FailedSteps = append(dst.FailedSteps, s+stepOffset)
+	}
+	dst.FetchedRows += src.FetchedRows
+	dst.TotalRows += src.TotalRows
+	if dst.DebugID == "" {
+		dst.DebugID = src.DebugID
+	}
+	for stage, ms := range src.Timings {
+		if dst.Timings == nil {
+			dst.Timings = make(map[string]int64, len(src.Timings))
+		}
+		dst.Timings[stage] += ms
+	}
+	for _, p := range src.Scope {
+		if !slices.Contains(dst.Scope, p) {
+			dst.Scope = append(dst.Scope, p)
+		}
+	}
+	mergeRangeStrategy(dst, src)
+	mergeFreshness(dst, src)
+	return dst
+}
+
+// mergeRangeStrategy carries the range strategy of a part that needed one
+// into dst: the shared strategy while the parts agree, popRangeMixed once
+// they do not, with each part's choice listed in RangeStrategies.
+func mergeRangeStrategy(dst, src *models.ResponseMeta) {
+	if src.RangeStrategy == "" {
+		return
+	}
+	switch dst.RangeStrategy {
+	case "":
+		dst.RangeStrategy = src.RangeStrategy
+	case src.RangeStrategy:
+	default:
+		dst.RangeStrategy = popRangeMixed
+	}
+	dst.RangeChunks += src.RangeChunks
+	if len(src.RangeStrategies) > 0 {
+		dst.RangeStrategies = append(dst.RangeStrategies, src.RangeStrategies...)
+	} else {
+		dst.RangeStrategies = append(dst.RangeStrategies, src.RangeStrategy)
+	}
+}
+
+// mergeChatData keeps dst's fields and fills the ones it lacks from src;
+// suggestions are joined.
+func mergeChatData(dst, src *models.ChatData) *models.ChatData {
+	if src == nil {
+		return dst
+	}
+	if dst == nil {
+		d := *src
+		return &d
+	}
+	if dst.CampaignImpressions == nil {
+		dst.CampaignImpressions = src.CampaignImpressions
+	}
+	if dst.CampaignBudget == nil {
+		dst.CampaignBudget = src.CampaignBudget
+	}
+	if dst.Geo == nil {
+		dst.Geo = src.Geo
+	}
+	if dst.TimeSeries == nil {
+		dst.TimeSeries = src.TimeSeries
+	}
+	if dst.VenueRanking == nil {
+		dst.VenueRanking = src.VenueRanking
+	}
+	if dst.CSV == nil {
+		dst.CSV = src.CSV
+	}
+	if dst.ActivePosters == nil {
+		dst.ActivePosters = src.ActivePosters
+	}
+	if dst.Schedule == nil {
+		dst.Schedule = src.Schedule
+	}
+	for _, s := range src.Suggestions {
+		if !slices.Contains(dst.Suggestions, s) {
+			dst.Suggestions = append(dst.Suggestions, s)
+		}
+	}
+	return dst
+}
+
+// mergeFreshness keeps, for each source, the newest timestamp any part
+// reported; "unknown" only when no part knew it.
+func mergeFreshness(dst, src *models.ResponseMeta) {
+	for source, ts := range src.Freshness {
+		if dst.Freshness == nil {
+			dst.Freshness = make(map[string]string, len(src.Freshness))
+		}
+		cur, ok := dst.Freshness[source]
+		switch {
+		case !ok, cur == freshnessUnknown:
+			dst.Freshness[source] = ts
+		case ts != freshnessUnknown && ts > cur:
+			// RFC 3339 UTC timestamps order as strings.
+			dst.Freshness[source] = ts
+		}
+	}
+}
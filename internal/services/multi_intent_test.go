@@ -0,0 +1,111 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"openai-agent-service/internal/models"
+)
+
+// fillFields sets every exported field of the struct v points to a non-zero
+// value, so a merge that forgets a field leaves it zero.
+func fillFields(t *testing.T, v any) {
+	t.Helper()
+	rv := reflect.ValueOf(v).Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		f := rv.Field(i)
+		switch f.Kind() {
+		case reflect.Bool:
+			f.SetBool(true)
+		case reflect.Int, reflect.Int64:
+			f.SetInt(2)
+		case reflect.String:
+			f.SetString("x")
+		case reflect.Pointer:
+			f.Set(reflect.New(f.Type().Elem()))
+		case reflect.Slice:
+			f.Set(reflect.MakeSlice(f.Type(), 1, 1))
+		case reflect.Map:
+			m := reflect.MakeMap(f.Type())
+			m.SetMapIndex(reflect.New(f.Type().Key()).Elem(), reflect.New(f.Type().Elem()).Elem())
+			f.Set(m)
+		default:
+			t.Fatalf("fillFields: %s.%s has unhandled kind %s", rv.Type(), rv.Type().Field(i).Name, f.Kind())
+		}
+	}
+}
+
+// zeroFields names the exported fields of the struct v points to that are
+// still zero.
+func zeroFields(v any) []string {
+	rv := reflect.ValueOf(v).Elem()
+	var out []string
+	for i := 0; i < rv.NumField(); i++ {
+		if rv.Field(i).IsZero() {
+			out = append(out, rv.Type().Field(i).Name)
+		}
+	}
+	return out
+}
+
+// A field added to ChatData or ResponseMeta must be merged too, or a
+// multi-intent answer silently drops it.
+func TestMergeKeepsEveryField(t *testing.T) {
+	var data models.ChatData
+	fillFields(t, &data)
+	merged := mergeChatData(&models.ChatData{}, &data)
+	if missing := zeroFields(merged); len(missing) > 0 {
+		t.Errorf("mergeChatData drops %v", missing)
+	}
+
+	var meta models.ResponseMeta
+	fillFields(t, &meta)
+	mergedMeta := mergeResponseMeta(&models.ResponseMeta{}, &meta, 0)
+	if missing := zeroFields(mergedMeta); len(missing) > 0 {
+		t.Errorf("mergeResponseMeta drops %v", missing)
+	}
+}
+
+func TestMergeResponseMetaAcrossParts(t *testing.T) {
+	parts := []*models.ResponseMeta{
+		{
+			FetchedRows: 200, FailedSteps: []int{1},
+			RangeStrategy: popRangeStats,
+			Timings:       map[string]int64{"gateway": 40},
+			Scope:         []models.ScopeParam{{Name: "host", Value: "briggs-001", Source: "message"}},
+			Freshness:     map[string]string{"pop": "2024-10-15T14:32:00Z", "devices": freshnessUnknown},
+		},
+		{
+			FetchedRows: 50, FailedSteps: []int{0}, Truncated: true,
+			RangeStrategy: popRangeChunked, RangeChunks: 4,
+			Timings:   map[string]int64{"gateway": 10, "openai": 5},
+			Scope:     []models.ScopeParam{{Name: "host", Value: "briggs-001", Source: "message"}},
+			Freshness: map[string]string{"pop": "2024-10-15T14:00:00Z", "devices": "2024-10-15T13:00:00Z"},
+		},
+	}
+	var got *models.ResponseMeta
+	steps := 0
+	for _, p := range parts {
+		got = mergeResponseMeta(got, p, steps)
+		steps += 3
+	}
+	want := &models.ResponseMeta{
+		Truncated:       true,
+		FetchedRows:     250,
+		FailedSteps:     []int{1, 3},
+		RangeStrategy:   popRangeMixed,
+		RangeChunks:     4,
+		RangeStrategies: []string{popRangeStats, popRangeChunked},
+		Timings:         map[string]int64{"gateway": 50, "openai": 5},
+		Scope:           []models.ScopeParam{{Name: "host", Value: "briggs-001", Source: "message"}},
+		Freshness:       map[string]string{"pop": "2024-10-15T14:32:00Z", "devices": "2024-10-15T13:00:00Z"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("merged meta\n got %+v\nwant %+v", got, want)
+	}
+
+	same := mergeResponseMeta(mergeResponseMeta(nil, &models.ResponseMeta{RangeStrategy: popRangeChunked, RangeChunks: 2}, 0), &models.ResponseMeta{RangeStrategy: popRangeChunked, RangeChunks: 3}, 0)
+	if same.RangeStrategy != popRangeChunked || same.RangeChunks != 5 {
+		t.Fatalf("agreeing parts = %q/%d, want chunked/5", same.RangeStrategy, same.RangeChunks)
+	}
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Numbers in answers follow one set of rules, so a figure reads the same in
+// every handler and a headline agrees with the rows under it:
+//
+//   - counts (plays, impressions, devices) are integers with thousands
+//     separators: formatThousands.
+//   - percentages have one decimal, rounded half away from zero:
+//     formatPercent, or formatSignedPercent for a change.
+//   - other measurements (minutes, CPU, temperature) are rounded the same
+//     way: formatDecimal.
+//   - durations are humanized: formatDuration.
+//   - money has two decimals and the currency: formatMoney.
+//
+// A total is summed from the unrounded values and rounded once; the rows of
+// its breakdown are rounded with roundParts so the rows shown add up to the
+// total shown.
+
+// formatThousands renders n with comma separators (5431 -> "5,431").
+func formatThousands(n int64) string {
+	neg := n < 0
+	if neg {
+		n = -n
+	}
+	digits := strconv.FormatInt(n, 10)
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	if neg {
+		return "-" + b.String()
+	}
+	return b.String()
+}
+
+// roundHalfUp rounds v to decimals places, halves away from zero. The scaled
+// value is first snapped to six places so a half that binary floating point
+// stores a hair low (1.005 is 1.00499…) still rounds up.
+func roundHalfUp(v float64, decimals int) float64 {
+	p := math.Pow10(decimals)
+	scaled := math.Round(v*p*1e6) / 1e6
+	return math.Round(scaled) / p
+}
+
+// formatDecimal renders v rounded half-up to decimals places, with thousands
+// separators (1234.56, 1 -> "1,234.6"). A value that rounds to zero has no
+// sign.
+func formatDecimal(v float64, decimals int) string {
+	r := roundHalfUp(v, decimals)
+	s := strconv.FormatFloat(math.Abs(r), 'f', decimals, 64)
+	whole, frac, _ := strings.Cut(s, ".")
+	n, _ := strconv.ParseInt(whole, 10, 64)
+	s = formatThousands(n)
+	if frac != "" {
+		s += "." + frac
+	}
+	if r < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// formatPercent renders a percentage with one decimal ("12.5%").
+func formatPercent(v float64) string {
+	return formatDecimal(v, 1) + "%"
+}
+
+// formatSignedPercent renders a percentage change with its sign ("+12.5%",
+// "-3.0%"); a change that rounds to zero is "0.0%".
+func formatSignedPercent(v float64) string {
+	s := formatPercent(v)
+	if roundHalfUp(v, 1) > 0 {
+		s = "+" + s
+	}
+	return s
+}
+
+// percentOf is part as a percentage of whole, or 0 when whole is 0.
+func percentOf(part, whole float64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return part * 100 / whole
+}
+
+// formatDuration renders a duration as days, hours and minutes ("3d 4h 12m"),
+// or seconds when it is under a minute.
+func formatDuration(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int64(d/time.Second))
+	}
+	days := int64(d / (24 * time.Hour))
+	hours := int64(d/time.Hour) % 24
+	minutes := int64(d/time.Minute) % 60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+// formatMoney renders an amount with two decimals and thousands separators,
+// followed by the currency when known.
+func formatMoney(amount float64, currency string) string {
+	s := formatDecimal(amount, 2)
+	if currency != "" {
+		s += " " + currency
+	}
+	return s
+}
+
+// roundParts rounds the parts of a breakdown to decimals places so that they
+// sum to their total rounded the same way. Each part is rounded down, and the
+// units left over go to the parts with the largest remainders (the largest
+// remainder method); ties go to the larger part, then the earlier one.
+func roundParts(parts []float64, decimals int) []float64 {
+	p := math.Pow10(decimals)
+	units := make([]int64, len(parts))
+	rem := make([]float64, len(parts))
+	sum, floored := 0.0, int64(0)
+	for i, v := range parts {
+		sum += v
+		scaled := math.Round(v*p*1e6) / 1e6
+		units[i] = int64(math.Floor(scaled))
+		rem[i] = scaled - float64(units[i])
+		floored += units[i]
+	}
+	order := make([]int, len(parts))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		if rem[order[a]] != rem[order[b]] {
+			return rem[order[a]] > rem[order[b]]
+		}
+		return parts[order[a]] > parts[order[b]]
+	})
+	left := int64(math.Round(roundHalfUp(sum, decimals)*p)) - floored
+	for i := 0; left > 0 && i < len(order); i++ {
+		units[order[i]]++
+		left--
+	}
+	out := make([]float64, len(parts))
+	for i, u := range units {
+		out[i] = float64(u) / p
+	}
+	return out
+}
+
+// shareParts is each count's share of their sum as a percentage, rounded
+// with roundParts so the shares shown add up to 100.0%.
+func shareParts(counts []int64) []float64 {
+	total := int64(0)
+	for _, n := range counts {
+		total += n
+	}
+	shares := make([]float64, len(counts))
+	for i, n := range counts {
+		shares[i] = percentOf(float64(n), float64(total))
+	}
+	return roundParts(shares, 1)
+}
+
+// minuteParts is each duration in seconds as minutes, rounded with roundParts
+// so the rows shown add up to their sum rounded the same way.
+func minuteParts(seconds []int64) []float64 {
+	minutes := make([]float64, len(seconds))
+	for i, s := range seconds {
+		minutes[i] = float64(s) / 60
+	}
+	return roundParts(minutes, 1)
+}
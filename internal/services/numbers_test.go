@@ -0,0 +1,155 @@
+package services
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestFormatNumbers(t *testing.T) {
+	cases := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"thousands zero", formatThousands(0), "0"},
+		{"thousands small", formatThousands(999), "999"},
+		{"thousands", formatThousands(5431), "5,431"},
+		{"thousands millions", formatThousands(1234567), "1,234,567"},
+		{"thousands negative", formatThousands(-1234567), "-1,234,567"},
+		{"decimal", formatDecimal(1234.56, 1), "1,234.6"},
+		{"decimal half up", formatDecimal(2.25, 1), "2.3"},
+		{"decimal stored low", formatDecimal(1.005, 2), "1.01"},
+		{"decimal negative half", formatDecimal(-2.25, 1), "-2.3"},
+		{"decimal rounds to zero", formatDecimal(-0.04, 1), "0.0"},
+		{"decimal no places", formatDecimal(1499.5, 0), "1,500"},
+		{"percent", formatPercent(12.45), "12.5%"},
+		{"percent zero", formatPercent(0), "0.0%"},
+		{"signed percent up", formatSignedPercent(12.45), "+12.5%"},
+		{"signed percent down", formatSignedPercent(-3), "-3.0%"},
+		{"signed percent flat", formatSignedPercent(0.04), "0.0%"},
+		{"signed percent flat negative", formatSignedPercent(-0.04), "0.0%"},
+		{"duration seconds", formatDuration(42 * time.Second), "42s"},
+		{"duration minutes", formatDuration(12*time.Minute + 30*time.Second), "12m"},
+		{"duration hours", formatDuration(4*time.Hour + 12*time.Minute), "4h 12m"},
+		{"duration days", formatDuration(76*time.Hour + 12*time.Minute), "3d 4h 12m"},
+		{"money", formatMoney(1234.5, "USD"), "1,234.50 USD"},
+		{"money no currency", formatMoney(0.125, ""), "0.13"},
+	}
+	for _, tc := range cases {
+		if tc.got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+func TestPercentOf(t *testing.T) {
+	cases := []struct{ part, whole, want float64 }{
+		{1, 4, 25},
+		{3, 0, 0},
+		{0, 10, 0},
+		{5, 2, 250},
+	}
+	for _, tc := range cases {
+		if got := percentOf(tc.part, tc.whole); got != tc.want {
+			t.Errorf("percentOf(%v, %v) = %v, want %v", tc.part, tc.whole, got, tc.want)
+		}
+	}
+}
+
+func TestRoundParts(t *testing.T) {
+	cases := []struct {
+		name     string
+		parts    []float64
+		decimals int
+		want     []float64
+	}{
+		// Rounded one by one these are 33.3 ×3 = 99.9.
+		{"thirds", []float64{100.0 / 3, 100.0 / 3, 100.0 / 3}, 1, []float64{33.4, 33.3, 33.3}},
+		{"tie goes to the earlier part", []float64{1.26, 1.26, 1.48}, 1, []float64{1.3, 1.2, 1.5}},
+		{"tie goes to the larger part", []float64{1.26, 2.26, 0.48}, 1, []float64{1.2, 2.3, 0.5}},
+		{"exact", []float64{1.5, 2.5}, 1, []float64{1.5, 2.5}},
+		{"whole numbers", []float64{0.4, 0.4, 0.2}, 0, []float64{1, 0, 0}},
+		{"empty", nil, 1, []float64{}},
+	}
+	for _, tc := range cases {
+		got := roundParts(tc.parts, tc.decimals)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: roundParts = %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if math.Abs(got[i]-tc.want[i]) > 1e-9 {
+				t.Errorf("%s: roundParts = %v, want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+// units is v in whole units of the given decimal places.
+func units(v float64, decimals int) int64 {
+	return int64(math.Round(v * math.Pow10(decimals)))
+}
+
+// The rounded parts of any breakdown add up to its rounded total, and no part
+// moves by a whole unit or more.
+func TestRoundPartsSumToTotal(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		decimals := rng.Intn(3)
+		parts := make([]float64, 1+rng.Intn(12))
+		sum := 0.0
+		for j := range parts {
+			parts[j] = rng.Float64() * math.Pow10(rng.Intn(6))
+			sum += parts[j]
+		}
+		got := roundParts(parts, decimals)
+		var total int64
+		for j, r := range got {
+			total += units(r, decimals)
+			if d := math.Abs(r-parts[j]) * math.Pow10(decimals); d >= 1 {
+				t.Fatalf("roundParts(%v, %d)[%d] = %v, %v units off", parts, decimals, j, r, d)
+			}
+		}
+		if want := units(roundHalfUp(sum, decimals), decimals); total != want {
+			t.Fatalf("roundParts(%v, %d) = %v sums to %d units, want %d", parts, decimals, got, total, want)
+		}
+	}
+}
+
+// Shares of any non-zero breakdown add up to 100.0%, and so do minutes to
+// their rounded sum.
+func TestSharePartsSumToHundred(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for i := 0; i < 2000; i++ {
+		counts := make([]int64, 1+rng.Intn(12))
+		for j := range counts {
+			counts[j] = rng.Int63n(100000)
+		}
+		counts[0]++
+		var total int64
+		for _, s := range shareParts(counts) {
+			total += units(s, 1)
+		}
+		if total != 1000 {
+			t.Fatalf("shareParts(%v) sums to %.1f%%", counts, float64(total)/10)
+		}
+
+		var seconds int64
+		for _, n := range counts {
+			seconds += n
+		}
+		total = 0
+		for _, m := range minuteParts(counts) {
+			total += units(m, 1)
+		}
+		if want := units(roundHalfUp(float64(seconds)/60, 1), 1); total != want {
+			t.Fatalf("minuteParts(%v) sums to %d tenths, want %d", counts, total, want)
+		}
+	}
+	if got := shareParts([]int64{0, 0}); got[0] != 0 || got[1] != 0 {
+		t.Errorf("shareParts of nothing = %v, want zeros", got)
+	}
+}
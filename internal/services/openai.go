@@ -11,6 +11,9 @@ import (
 	"strings"
 )
 
+// ErrOpenAIFailed is returned when the OpenAI API answers with a non-2xx status.
+var ErrOpenAIFailed = errors.New("openai request failed")
+
 type OpenAIMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
@@ -92,7 +95,7 @@ func (c *OpenAIClient) Chat(messages []OpenAIMessage) (string, error) {
 	defer resp.Body.Close()
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", fmt.Errorf("openai request failed: status=%d body=%s", resp.StatusCode, strings.TrimSpace(string(body)))
+		return "", fmt.Errorf("%w: status=%d body=%s", ErrOpenAIFailed, resp.StatusCode, strings.TrimSpace(string(body)))
 	}
 	var out chatResponse
 	if err := json.Unmarshal(body, &out); err != nil {
@@ -127,7 +130,7 @@ func (c *OpenAIClient) ChatWithToolsChoice(messages []OpenAIMessage, tools []Ope
 	defer resp.Body.Close()
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return OpenAIMessage{}, fmt.Errorf("openai request failed: status=%d body=%s", resp.StatusCode, strings.TrimSpace(string(body)))
+		return OpenAIMessage{}, fmt.Errorf("%w: status=%d body=%s", ErrOpenAIFailed, resp.StatusCode, strings.TrimSpace(string(body)))
 	}
 
 	var out chatResponse
@@ -159,7 +162,7 @@ func (c *OpenAIClient) ChatStream(messages []OpenAIMessage, onToken func(string)
 	defer resp.Body.Close()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("openai request failed: status=%d body=%s", resp.StatusCode, strings.TrimSpace(string(body)))
+		return "", fmt.Errorf("%w: status=%d body=%s", ErrOpenAIFailed, resp.StatusCode, strings.TrimSpace(string(body)))
 	}
 
 	reader := bufio.NewReader(resp.Body)
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// OutboxStore queues side effects for the OutboxDispatcher and keeps the
+// ones that used up their attempts; implemented by store.PostgresStore.
+type OutboxStore interface {
+	EnqueueOutbox(ctx context.Context, kind string, payload []byte) error
+	MarkAlertRuleFiredAndEnqueue(ctx context.Context, id int64, at time.Time, kind string, payload []byte) error
+	ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEntry, error)
+	CompleteOutbox(ctx context.Context, id int64) error
+	RetryOutbox(ctx context.Context, id int64, next time.Time, lastErr string) error
+	DeadLetterOutbox(ctx context.Context, id int64, lastErr string) error
+	ListDeadLetters(ctx context.Context, limit int) ([]models.DeadLetter, error)
+	RequeueDeadLetter(ctx context.Context, id int64) (models.OutboxEntry, error)
+}
+
+// Outbox entry kinds.
+const (
+	OutboxWebhook            = "webhook"
+	OutboxUsage              = "usage"
+	OutboxDeviceCommandAudit = "device_command_audit"
+)
+
+// outboxWebhook is the payload of a webhook entry.
+type outboxWebhook struct {
+	URL  string          `json:"url"`
+	Body json.RawMessage `json:"body"`
+}
+
+// outboxAudit is the payload of a device command audit entry; the model
+// keeps OwnerKey out of JSON.
+type outboxAudit struct {
+	models.DeviceCommandAudit
+	OwnerKey string `json:"owner_key"`
+}
+
+// errOutboxPermanent marks a failure that retrying cannot fix; the entry is
+// dead-lettered at once.
+var errOutboxPermanent = errors.New("permanent failure")
+
+const (
+	defaultOutboxInterval    = 5 * time.Second
+	defaultOutboxMaxAttempts = 8
+	defaultOutboxBackoff     = 30 * time.Second
+	maxOutboxBackoff         = time.Hour
+	outboxBatch              = 20
+	// outboxLease hides a claimed entry from other dispatchers; it must
+	// outlast one delivery.
+	outboxLease = 2 * time.Minute
+	// outboxDeliveryTimeout bounds one delivery.
+	outboxDeliveryTimeout = 15 * time.Second
+)
+
+// enqueueOutbox marshals v and queues it as kind.
+func enqueueOutbox(ctx context.Context, s OutboxStore, kind string, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.EnqueueOutbox(ctx, kind, payload)
+}
+
+// OutboxDispatcher delivers queued side effects with retries. Each entry is
+// claimed before delivery, so several replicas can run it at once; an entry
+// whose replica dies mid-delivery is claimed again after the lease, which
+// makes delivery at-least-once. Failures are retried with exponential
+// backoff (Backoff, doubling, at most an hour) until MaxAttempts, then moved
+// to dead_letters.
+type OutboxDispatcher struct {
+	Store  OutboxStore
+	Usage  UsageStore
+	Audits DeviceCommandAuditStore
+	// Webhooks limits the hosts webhook entries are delivered to.
+	Webhooks *WebhookGuard
+	// Interval between polls when the outbox is empty (default 5s).
+	Interval time.Duration
+	// MaxAttempts before an entry is dead-lettered (default 8).
+	MaxAttempts int
+	// Backoff is the delay before the first retry (default 30s).
+	Backoff time.Duration
+}
+
+func (d *OutboxDispatcher) Run(ctx context.Context) {
+	interval := d.Interval
+	if interval <= 0 {
+		interval = defaultOutboxInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			// Drain full batches before waiting for the next tick.
+			guardTick("outbox", func() {
+				for {
+					n, err := d.dispatchOnce(ctx)
+					if err != nil {
+						log.Printf("outbox: %v", err)
+					}
+					if err != nil || n < outboxBatch {
+						break
+					}
+				}
+			})
+		}
+	}
+}
+
+// dispatchOnce claims and delivers one batch, returning its size.
+func (d *OutboxDispatcher) dispatchOnce(ctx context.Context) (int, error) {
+	entries, err := d.Store.ClaimOutbox(ctx, outboxBatch, outboxLease)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		derr := d.deliver(ctx, e)
+		switch {
+		case derr == nil:
+			err = d.Store.CompleteOutbox(ctx, e.ID)
+		case errors.Is(derr, errOutboxPermanent) || e.Attempts >= d.maxAttempts():
+			log.Printf("outbox: entry %d (%s) dead-lettered after %d attempt(s): %v", e.ID, e.Kind, e.Attempts, derr)
+			err = d.Store.DeadLetterOutbox(ctx, e.ID, derr.Error())
+		default:
+			next := time.Now().Add(d.backoff(e.Attempts))
+			log.Printf("outbox: entry %d (%s) attempt %d failed, retrying at %s: %v", e.ID, e.Kind, e.Attempts, next.UTC().Format(time.RFC3339), derr)
+			err = d.Store.RetryOutbox(ctx, e.ID, next, derr.Error())
+		}
+		if err != nil {
+			// The lease expires and the entry is claimed again.
+			log.Printf("outbox: entry %d: %v", e.ID, err)
+		}
+	}
+	return len(entries), nil
+}
+
+func (d *OutboxDispatcher) maxAttempts() int {
+	if d.MaxAttempts > 0 {
+		return d.MaxAttempts
+	}
+	return defaultOutboxMaxAttempts
+}
+
+// backoff is the delay after the given number of failed attempts.
+func (d *OutboxDispatcher) backoff(attempts int) time.Duration {
+	wait := d.Backoff
+	if wait <= 0 {
+		wait = defaultOutboxBackoff
+	}
+	for i := 1; i < attempts && wait < maxOutboxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxOutboxBackoff)
+}
+
+func (d *OutboxDispatcher) deliver(ctx context.Context, e models.OutboxEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, outboxDeliveryTimeout)
+	defer cancel()
+	switch e.Kind {
+	case OutboxWebhook:
+		var w outboxWebhook
+		if err := json.Unmarshal(e.Payload, &w); err != nil || w.URL == "" {
+			return fmt.Errorf("%w: bad webhook payload", errOutboxPermanent)
+		}
+		return d.Webhooks.Post(ctx, w.URL, w.Body)
+	case OutboxUsage:
+		if d.Usage == nil {
+			return errors.New("usage recording is not configured")
+		}
+		var u models.UsageEvent
+		if err := json.Unmarshal(e.Payload, &u); err != nil {
+			return fmt.Errorf("%w: bad usage payload: %v", errOutboxPermanent, err)
+		}
+		return d.Usage.RecordUsage(ctx, u)
+	case OutboxDeviceCommandAudit:
+		if d.Audits == nil {
+			return errors.New("device command audit is not configured")
+		}
+		var a outboxAudit
+		if err := json.Unmarshal(e.Payload, &a); err != nil {
+			return fmt.Errorf("%w: bad audit payload: %v", errOutboxPermanent, err)
+		}
+		a.DeviceCommandAudit.OwnerKey = a.OwnerKey
+		return d.Audits.AppendDeviceCommandAudit(ctx, a.DeviceCommandAudit)
+	}
+	return fmt.Errorf("%w: unknown kind %q", errOutboxPermanent, e.Kind)
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// memOutbox is an in-memory OutboxStore with the store's claim rules: only
+// due entries are claimed, each claim counts an attempt and hides the entry
+// for the lease.
+type memOutbox struct {
+	mu      sync.Mutex
+	nextID  int64
+	entries map[int64]models.OutboxEntry
+	dead    map[int64]models.DeadLetter
+}
+
+func (s *memOutbox) EnqueueOutbox(_ context.Context, kind string, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.add(kind, payload)
+	return nil
+}
+
+func (s *memOutbox) add(kind string, payload []byte) models.OutboxEntry {
+	if s.entries == nil {
+		s.entries = map[int64]models.OutboxEntry{}
+	}
+	s.nextID++
+	now := time.Now()
+	e := models.OutboxEntry{ID: s.nextID, Kind: kind, Payload: payload, NextAttemptAt: now, CreatedAt: now}
+	s.entries[e.ID] = e
+	return e
+}
+
+func (s *memOutbox) MarkAlertRuleFiredAndEnqueue(ctx context.Context, _ int64, _ time.Time, kind string, payload []byte) error {
+	return s.EnqueueOutbox(ctx, kind, payload)
+}
+
+func (s *memOutbox) ClaimOutbox(_ context.Context, limit int, lease time.Duration) ([]models.OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var out []models.OutboxEntry
+	for id, e := range s.entries {
+		if len(out) == limit || e.NextAttemptAt.After(now) {
+			continue
+		}
+		e.Attempts++
+		e.NextAttemptAt = now.Add(lease)
+		s.entries[id] = e
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+func (s *memOutbox) CompleteOutbox(_ context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, id)
+	return nil
+}
+
+func (s *memOutbox) RetryOutbox(_ context.Context, id int64, next time.Time, lastErr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[id]; ok {
+		e.NextAttemptAt, e.LastError = next, lastErr
+		s.entries[id] = e
+	}
+	return nil
+}
+
+func (s *memOutbox) DeadLetterOutbox(_ context.Context, id int64, lastErr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return nil
+	}
+	delete(s.entries, id)
+	if s.dead == nil {
+		s.dead = map[int64]models.DeadLetter{}
+	}
+	s.dead[id] = models.DeadLetter{ID: id, Kind: e.Kind, Payload: e.Payload, Attempts: e.Attempts, LastError: lastErr, CreatedAt: e.CreatedAt, FailedAt: time.Now()}
+	return nil
+}
+
+func (s *memOutbox) ListDeadLetters(_ context.Context, _ int) ([]models.DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]models.DeadLetter, 0, len(s.dead))
+	for _, d := range s.dead {
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+func (s *memOutbox) RequeueDeadLetter(_ context.Context, id int64) (models.OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.dead[id]
+	if !ok {
+		return models.OutboxEntry{}, sql.ErrNoRows
+	}
+	delete(s.dead, id)
+	return s.add(d.Kind, d.Payload), nil
+}
+
+// due makes every queued entry claimable now, standing in for the backoff
+// running out.
+func (s *memOutbox) due() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, e := range s.entries {
+		e.NextAttemptAt = time.Now()
+		s.entries[id] = e
+	}
+}
+
+func (s *memOutbox) counts() (queued, dead int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries), len(s.dead)
+}
+
+// webhookTarget answers every POST with status and counts the deliveries.
+func webhookTarget(t *testing.T, status int) (url string, hits func() int) {
+	t.Helper()
+	var mu sync.Mutex
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		n++
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/hook", func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return n
+	}
+}
+
+func newTestDispatcher(s OutboxStore) *OutboxDispatcher {
+	// The test servers listen on loopback, which the guard blocks unless
+	// listed.
+	return &OutboxDispatcher{Store: s, Webhooks: &WebhookGuard{AllowedHosts: []string{"127.0.0.1"}}, MaxAttempts: 3, Backoff: time.Minute}
+}
+
+func TestOutboxDispatch(t *testing.T) {
+	cases := []struct {
+		name     string
+		status   int
+		kind     string
+		payload  func(url string) string
+		attempts int // dispatch rounds
+		hits     int
+		queued   int
+		dead     int
+	}{
+		{name: "delivered", status: http.StatusNoContent, attempts: 1, hits: 1},
+		{name: "failing webhook retried", status: http.StatusInternalServerError, attempts: 2, hits: 2, queued: 1},
+		{name: "failing webhook dead-lettered", status: http.StatusInternalServerError, attempts: 3, hits: 3, dead: 1},
+		{name: "bad payload dead-lettered at once", kind: OutboxWebhook, payload: func(string) string { return `{"body":{}}` }, attempts: 1, dead: 1},
+		{name: "unknown kind dead-lettered at once", kind: "fax", payload: func(string) string { return `{}` }, attempts: 1, dead: 1},
+		{name: "blocked host dead-lettered at once", payload: func(string) string { return `{"url":"http://169.254.169.254/x","body":{}}` }, attempts: 1, dead: 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			url, hits := webhookTarget(t, tc.status)
+			store := &memOutbox{}
+			kind, payload := tc.kind, `{"url":"`+url+`","body":{"rule":1}}`
+			if kind == "" {
+				kind = OutboxWebhook
+			}
+			if tc.payload != nil {
+				payload = tc.payload(url)
+			}
+			ctx := context.Background()
+			if err := store.EnqueueOutbox(ctx, kind, []byte(payload)); err != nil {
+				t.Fatal(err)
+			}
+			d := newTestDispatcher(store)
+			for i := 0; i < tc.attempts; i++ {
+				store.due()
+				if _, err := d.dispatchOnce(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+			queued, dead := store.counts()
+			if hits() != tc.hits || queued != tc.queued || dead != tc.dead {
+				t.Errorf("hits=%d queued=%d dead=%d, want %d, %d, %d", hits(), queued, dead, tc.hits, tc.queued, tc.dead)
+			}
+			if tc.queued > 0 {
+				for _, e := range store.entries {
+					if e.LastError == "" || time.Until(e.NextAttemptAt) < 30*time.Second {
+						t.Errorf("retry %+v lacks its error or backoff", e)
+					}
+				}
+			}
+			if tc.dead > 0 {
+				letters, _ := store.ListDeadLetters(ctx, 50)
+				if letters[0].LastError == "" || string(letters[0].Payload) != payload {
+					t.Errorf("dead letter %+v lost its error or payload", letters[0])
+				}
+			}
+		})
+	}
+}
+
+// A claimed entry is hidden from other dispatchers until its lease ends.
+func TestOutboxLease(t *testing.T) {
+	url, hits := webhookTarget(t, http.StatusNoContent)
+	store := &memOutbox{}
+	ctx := context.Background()
+	_ = enqueueOutbox(ctx, store, OutboxWebhook, outboxWebhook{URL: url, Body: json.RawMessage(`{}`)})
+	claimed, _ := store.ClaimOutbox(ctx, outboxBatch, outboxLease)
+	if len(claimed) != 1 {
+		t.Fatalf("claimed %d entries, want 1", len(claimed))
+	}
+	if n, _ := newTestDispatcher(store).dispatchOnce(ctx); n != 0 || hits() != 0 {
+		t.Errorf("a second dispatcher delivered %d leased entries (%d hits)", n, hits())
+	}
+}
+
+// A re-queued dead letter starts over with fresh attempts and is delivered
+// once the webhook recovers.
+func TestOutboxRequeue(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+	store := &memOutbox{}
+	ctx := context.Background()
+	_ = enqueueOutbox(ctx, store, OutboxWebhook, outboxWebhook{URL: srv.URL, Body: json.RawMessage(`{}`)})
+	d := newTestDispatcher(store)
+	d.MaxAttempts = 1
+	if _, err := d.dispatchOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	letters, _ := store.ListDeadLetters(ctx, 50)
+	if len(letters) != 1 {
+		t.Fatalf("%d dead letters, want 1", len(letters))
+	}
+
+	if _, err := store.RequeueDeadLetter(ctx, letters[0].ID+100); err != sql.ErrNoRows {
+		t.Errorf("unknown dead letter: err = %v, want sql.ErrNoRows", err)
+	}
+	e, err := store.RequeueDeadLetter(ctx, letters[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Attempts != 0 || e.Kind != OutboxWebhook || string(e.Payload) != string(letters[0].Payload) {
+		t.Errorf("re-queued entry %+v, want the dead letter with no attempts", e)
+	}
+	status.Store(http.StatusNoContent)
+	if _, err := d.dispatchOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if queued, dead := store.counts(); queued != 0 || dead != 0 || hits != 2 {
+		t.Errorf("queued=%d dead=%d hits=%d after the re-queue, want it delivered", queued, dead, hits)
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	d := &OutboxDispatcher{}
+	cases := map[int]time.Duration{
+		1:  defaultOutboxBackoff,
+		2:  2 * defaultOutboxBackoff,
+		3:  4 * defaultOutboxBackoff,
+		8:  maxOutboxBackoff,
+		50: maxOutboxBackoff,
+	}
+	for attempts, want := range cases {
+		if got := d.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
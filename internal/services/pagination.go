@@ -0,0 +1,50 @@
+package services
+
+// gatewayPagination is the pagination object gateway listings such as
+// /metrics/latest and /ads/devices return. HasMore is a pointer so a missing
+// flag can be told apart from has_more=false.
+type gatewayPagination struct {
+	HasMore *bool `json:"has_more"`
+	Total   int64 `json:"total"`
+}
+
+// paginationFrom reads the pagination object of a listing decoded into a
+// generic map.
+func paginationFrom(root map[string]any) gatewayPagination {
+	var p gatewayPagination
+	m, _ := root["pagination"].(map[string]any)
+	if v, ok := m["has_more"].(bool); ok {
+		p.HasMore = &v
+	}
+	if v, ok := m["total"].(float64); ok {
+		p.Total = int64(v)
+	}
+	return p
+}
+
+// done reports whether the listing is exhausted after a page of n rows, with
+// fetched rows collected so far including this page.
+func (p gatewayPagination) done(n, pageSize int, fetched int64) bool {
+	return pageDone(n, pageSize, fetched, p.Total, p.HasMore)
+}
+
+// pageDone is the termination rule shared by the gateway pagination loops:
+//   - an empty page ends the listing;
+//   - has_more decides when the gateway sends it;
+//   - otherwise the listing ends once fetched reaches a positive total, or on
+//     a page shorter than pageSize (a total of 0 is treated as unknown).
+//
+// Page numbers echoed by the gateway are not consulted: they are 0-based on
+// some deployments, and counting rows gives the same answer either way.
+func pageDone(n, pageSize int, fetched, total int64, hasMore *bool) bool {
+	if n == 0 {
+		return true
+	}
+	if hasMore != nil {
+		return !*hasMore
+	}
+	if total > 0 && fetched >= total {
+		return true
+	}
+	return n < pageSize
+}
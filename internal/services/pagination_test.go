@@ -0,0 +1,117 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPageDone(t *testing.T) {
+	yes, no := true, false
+	cases := []struct {
+		name     string
+		n, size  int
+		fetched  int64
+		total    int64
+		hasMore  *bool
+		wantDone bool
+	}{
+		{"empty page", 0, 50, 100, 200, nil, true},
+		{"total divisible, middle page", 50, 50, 50, 100, nil, false},
+		{"total divisible, last full page", 50, 50, 100, 100, nil, true},
+		{"one past a boundary, full page", 50, 50, 100, 101, nil, false},
+		{"one past a boundary, last row", 1, 50, 101, 101, nil, true},
+		{"total 0 with rows, full page", 50, 50, 50, 0, nil, false},
+		{"total 0 with rows, short page", 20, 50, 70, 0, nil, true},
+		{"has_more true beats a short page", 20, 50, 20, 0, &yes, false},
+		{"has_more false beats the total", 50, 50, 50, 500, &no, true},
+		{"has_more true beats a reached total", 50, 50, 100, 100, &yes, false},
+	}
+	for _, tc := range cases {
+		if got := pageDone(tc.n, tc.size, tc.fetched, tc.total, tc.hasMore); got != tc.wantDone {
+			t.Errorf("%s: pageDone = %v, want %v", tc.name, got, tc.wantDone)
+		}
+	}
+}
+
+func TestPaginationFrom(t *testing.T) {
+	p := paginationFrom(map[string]any{"pagination": map[string]any{"has_more": false, "total": float64(7)}})
+	if p.HasMore == nil || *p.HasMore || p.Total != 7 {
+		t.Errorf("pagination = %+v", p)
+	}
+	// A listing without the flag falls back to counting rows.
+	p = paginationFrom(map[string]any{"data": []any{}})
+	if p.HasMore != nil || p.Total != 0 {
+		t.Errorf("pagination without the object = %+v", p)
+	}
+	if p.done(200, 200, 200) {
+		t.Error("a full page without has_more or a total ended the listing")
+	}
+}
+
+// The Bet 365 rows in brt are four rows totalling 305 plays; each case pages
+// through them differently and must still count all of them.
+func TestPopPaginationBoundaries(t *testing.T) {
+	cases := []struct {
+		name     string
+		pageSize int
+		g        *fakeGateway
+		calls    int
+	}{
+		{
+			name:     "total divisible by page_size",
+			pageSize: 2,
+			g:        &fakeGateway{},
+			calls:    2,
+		},
+		{
+			name:     "total one past a page boundary",
+			pageSize: 3,
+			g:        &fakeGateway{},
+			calls:    2,
+		},
+		{
+			// Without a total only a short or empty page ends the listing.
+			name:     "total 0 while rows exist",
+			pageSize: 2,
+			g: &fakeGateway{PopQuirk: func(page int, resp map[string]any) {
+				resp["total"] = 0
+			}},
+			calls: 3,
+		},
+		{
+			name:     "page echo differs from the page requested",
+			pageSize: 2,
+			g: &fakeGateway{PopQuirk: func(page int, resp map[string]any) {
+				resp["page"] = page - 1
+			}},
+			calls: 2,
+		},
+		{
+			// Regression: the gateway caps page_size at 2 and echoes it. The
+			// old rule, page*requested_size >= total, stopped after the first
+			// page (1*4 >= 4) and dropped the last two rows.
+			name:     "capped page size hides the last page",
+			pageSize: 4,
+			g:        &fakeGateway{MaxPageSize: 2},
+			calls:    2,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.g.Devices, tc.g.Pop = testDevices, testPop()
+			g := newFakeGateway(t, tc.g)
+			c := newTestChat(g)
+			c.Limits.PopPageSize = tc.pageSize
+			resp := chatOnce(t, c, "play count for poster Bet 365 in brt")
+			if !strings.Contains(resp.Answer, "305 plays") {
+				t.Errorf("answer\n%s\nlacks the full total of 305 plays", resp.Answer)
+			}
+			if resp.Meta != nil && resp.Meta.Truncated {
+				t.Errorf("answer marked truncated: %+v", resp.Meta)
+			}
+			if got := g.Calls("/pop?"); len(got) != tc.calls {
+				t.Errorf("%d /pop calls, want %d: %v", len(got), tc.calls, got)
+			}
+		})
+	}
+}
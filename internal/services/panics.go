@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+
+	"github.com/google/uuid"
+
+	"openai-agent-service/internal/models"
+)
+
+// ErrInternal is returned for a request whose handling panicked. The panic
+// and its stack are logged under the incident id the error carries; see
+// IncidentID.
+var ErrInternal = errors.New("the service hit an unexpected error")
+
+// panicError is ErrInternal for one recovered panic.
+type panicError struct {
+	Incident string
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("%s; quote incident %s when reporting it", ErrInternal.Error(), e.Incident)
+}
+
+func (e *panicError) Unwrap() error { return ErrInternal }
+
+// IncidentID is the incident id of an ErrInternal, in the same urn:uuid form
+// as the instance of an HTTP panic response; empty for any other error.
+func IncidentID(err error) string {
+	var pe *panicError
+	if errors.As(err, &pe) {
+		return pe.Incident
+	}
+	return ""
+}
+
+// logIncident logs a recovered panic with its stack under a new incident id
+// and returns the id.
+func logIncident(where string, v any) string {
+	incident := "urn:uuid:" + uuid.NewString()
+	log.Printf("panic in %s incident=%s err=%v\n%s", where, incident, v, debug.Stack())
+	return incident
+}
+
+// logPanic is deferred at the top of background goroutines so a panic in one
+// is logged with its stack instead of taking the process down.
+func logPanic(where string) {
+	if v := recover(); v != nil {
+		logIncident(where, v)
+	}
+}
+
+// chatDispatchRecovered is chatDispatch with a panic turned into ErrInternal,
+// so an in-flight request's duplicates are released, usage is recorded and a
+// stream that already sent tokens still gets its closing error event.
+func (c *ChatService) chatDispatchRecovered(ctx context.Context, ownerKey string, req models.ChatRequest, onToken func(string)) (resp models.ChatResponse, err error) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		incident := logIncident("chat", v)
+		resp = models.ChatResponse{
+			Answer: fmt.Sprintf("Something went wrong while answering this question. Quote incident %s when reporting it.", incident),
+			Error:  &models.ResponseError{Code: "internal_error"},
+		}
+		err = &panicError{Incident: incident}
+	}()
+	return c.chatDispatch(ctx, ownerKey, req, onToken)
+}
+
+// recoverInto is deferred in a request's fan-out goroutines, where the HTTP
+// middleware cannot see a panic: it is logged and handed to fail as
+// ErrInternal, so the answer reports that part as failed.
+func recoverInto(where string, fail func(err error)) {
+	if v := recover(); v != nil {
+		fail(&panicError{Incident: logIncident(where, v)})
+	}
+}
+
+// guardTick runs one iteration of a background loop; a panic in it is
+// logged and the loop carries on at its next tick.
+func guardTick(where string, fn func()) {
+	defer logPanic(where)
+	fn()
+}
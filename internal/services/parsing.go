@@ -0,0 +1,743 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+func extractNaturalDateRangeRFC3339(msg string) (string, string) {
+	s := strings.ToLower(strings.TrimSpace(msg))
+	if s == "" {
+		return "", ""
+	}
+	idx := strings.Index(s, "from ")
+	if idx < 0 {
+		return "", ""
+	}
+	rest := strings.TrimSpace(s[idx+5:])
+	endIdx, sepLen := -1, 0
+	for _, sep := range []string{" till ", " to "} {
+		if j := strings.Index(rest, sep); j >= 0 {
+			endIdx, sepLen = j, len(sep)
+			break
+		}
+	}
+	if endIdx < 0 {
+		return "", ""
+	}
+	fromPart := strings.TrimSpace(rest[:endIdx])
+	// Only the separator is cut, so "till today" keeps "today".
+	toPart := strings.TrimSpace(rest[endIdx+sepLen:])
+
+	fromPart = strings.ReplaceAll(fromPart, ",", " ")
+	fromPart = stripOrdinals(strings.Join(strings.Fields(fromPart), " "))
+
+	fromT, err := time.Parse("January 2 2006", fromPart)
+	if err != nil {
+		fromT, err = time.Parse("Jan 2 2006", fromPart)
+		if err != nil {
+			return "", ""
+		}
+	}
+	from := time.Date(fromT.Year(), fromT.Month(), fromT.Day(), 0, 0, 0, 0, time.UTC)
+
+	var to time.Time
+	toPart = strings.ReplaceAll(toPart, ",", " ")
+	toPart = strings.Join(strings.Fields(toPart), " ")
+	if strings.Contains(toPart, "today") {
+		now := time.Now().UTC()
+		to = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	} else {
+		toPart = stripOrdinals(toPart)
+		toT, err2 := time.Parse("January 2 2006", toPart)
+		if err2 != nil {
+			toT, err2 = time.Parse("Jan 2 2006", toPart)
+			if err2 != nil {
+				return "", ""
+			}
+		}
+		to = time.Date(toT.Year(), toT.Month(), toT.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	}
+	if !from.Before(to) {
+		return "", ""
+	}
+	return from.Format(time.RFC3339), to.Format(time.RFC3339)
+}
+
+var monthNames = map[string]time.Month{
+	"january":   time.January,
+	"jan":       time.January,
+	"february":  time.February,
+	"feb":       time.February,
+	"march":     time.March,
+	"mar":       time.March,
+	"april":     time.April,
+	"apr":       time.April,
+	"may":       time.May,
+	"june":      time.June,
+	"jun":       time.June,
+	"july":      time.July,
+	"jul":       time.July,
+	"august":    time.August,
+	"aug":       time.August,
+	"september": time.September,
+	"sep":       time.September,
+	"sept":      time.September,
+	"october":   time.October,
+	"oct":       time.October,
+	"november":  time.November,
+	"nov":       time.November,
+	"december":  time.December,
+	"dec":       time.December,
+}
+
+var (
+	numericMonthRe = regexp.MustCompile(`(?:^|[^\d/])(0?[1-9]|1[0-2])/(\d{4})(?:[^\d/]|$)`)
+	isoMonthRe     = regexp.MustCompile(`(?:^|[^\d-])(\d{4})-(0[1-9]|1[0-2])(?:[^\d-]|$)`)
+	quarterWordRe  = regexp.MustCompile(`\b(first|second|third|fourth|1st|2nd|3rd|4th)\s+quarter(?:\s+of)?(?:\s+(\d{4}))?\b`)
+	ordinalDayRe   = regexp.MustCompile(`\b(\d{1,2})(?:st|nd|rd|th)\b`)
+)
+
+var quarterWords = map[string]int{"first": 1, "1st": 1, "second": 2, "2nd": 2, "third": 3, "3rd": 3, "fourth": 4, "4th": 4}
+
+// stripOrdinals turns "1st", "22nd" and "4th" into plain day numbers, leaving
+// words such as "august" alone.
+func stripOrdinals(s string) string {
+	return ordinalDayRe.ReplaceAllString(s, "$1")
+}
+
+func validYear(y int) bool { return y >= 2000 && y <= 2100 }
+
+// impliedYear is the year of a month or quarter named without one: the
+// current year, or the previous one when that start is still in the future.
+func impliedYear(month time.Month, now time.Time) int {
+	if month > now.Month() {
+		return now.Year() - 1
+	}
+	return now.Year()
+}
+
+// parseMonthRange finds the month or quarter a message is about, as a
+// half-open range in loc:
+//   - "10/2024" and "2024-10";
+//   - "Q4 2024", "fourth quarter of 2024", "last quarter", "this quarter";
+//   - a month name with an optional year ("October 2024", "October data");
+//   - "last month" and "this month".
+//
+// A month or quarter without a year is the latest one not in the future.
+// "may" without a year only counts after "in", "for", "of" or "during", and
+// abbreviations need a year, so names like "May Flowers" are not dates.
+func parseMonthRange(msg string, now time.Time, loc *time.Location) (time.Time, time.Time, bool) {
+	s := strings.ToLower(strings.TrimSpace(msg))
+	if s == "" {
+		return time.Time{}, time.Time{}, false
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	now = now.In(loc)
+	month := func(y int, m time.Month, n int) (time.Time, time.Time, bool) {
+		from := time.Date(y, m, 1, 0, 0, 0, 0, loc)
+		return from, from.AddDate(0, n, 0), true
+	}
+	quarter := func(y, q int) (time.Time, time.Time, bool) {
+		m := time.Month(3*(q-1) + 1)
+		if y == 0 {
+			y = impliedYear(m, now)
+		}
+		return month(y, m, 3)
+	}
+
+	if m := numericMonthRe.FindStringSubmatch(s); m != nil {
+		mo, _ := strconv.Atoi(m[1])
+		if y, _ := strconv.Atoi(m[2]); validYear(y) {
+			return month(y, time.Month(mo), 1)
+		}
+	}
+	if m := isoMonthRe.FindStringSubmatch(s); m != nil {
+		mo, _ := strconv.Atoi(m[2])
+		if y, _ := strconv.Atoi(m[1]); validYear(y) {
+			return month(y, time.Month(mo), 1)
+		}
+	}
+
+	words := tokenizeWords(s)
+	yearAt := func(i int) int {
+		if i < 0 || i >= len(words) {
+			return 0
+		}
+		if y, err := strconv.Atoi(words[i]); err == nil && validYear(y) {
+			return y
+		}
+		return 0
+	}
+	for i, w := range words {
+		if len(w) == 2 && w[0] == 'q' && w[1] >= '1' && w[1] <= '4' {
+			y := yearAt(i + 1)
+			if y == 0 {
+				y = yearAt(i - 1)
+			}
+			return quarter(y, int(w[1]-'0'))
+		}
+	}
+	if m := quarterWordRe.FindStringSubmatch(s); m != nil {
+		y, _ := strconv.Atoi(m[2])
+		if !validYear(y) {
+			y = 0
+		}
+		return quarter(y, quarterWords[m[1]])
+	}
+	currentQuarter := time.Date(now.Year(), time.Month(3*((int(now.Month())-1)/3)+1), 1, 0, 0, 0, 0, loc)
+	if strings.Contains(s, "last quarter") || strings.Contains(s, "previous quarter") {
+		return currentQuarter.AddDate(0, -3, 0), currentQuarter, true
+	}
+	if strings.Contains(s, "this quarter") || strings.Contains(s, "current quarter") {
+		return currentQuarter, currentQuarter.AddDate(0, 3, 0), true
+	}
+
+	mon := time.Month(0)
+	year := 0
+	bare := false
+	for i, w := range words {
+		if mon == 0 {
+			if m, ok := monthNames[w]; ok {
+				if y := yearAt(i + 1); y != 0 {
+					mon, year = m, y
+					continue
+				}
+				prev := ""
+				if i > 0 {
+					prev = words[i-1]
+				}
+				full := strings.ToLower(m.String()) == w
+				if full && (w != "may" || prev == "in" || prev == "for" || prev == "of" || prev == "during") {
+					mon, bare = m, true
+				}
+				continue
+			}
+		}
+		if year == 0 {
+			year = yearAt(i)
+		}
+	}
+	if mon != 0 && year != 0 {
+		return month(year, mon, 1)
+	}
+	if mon != 0 && bare {
+		return month(impliedYear(mon, now), mon, 1)
+	}
+
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	if strings.Contains(s, "last month") || strings.Contains(s, "previous month") {
+		return thisMonth.AddDate(0, -1, 0), thisMonth, true
+	}
+	if strings.Contains(s, "this month") || strings.Contains(s, "current month") {
+		return thisMonth, thisMonth.AddDate(0, 1, 0), true
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// parseMonthYearRangeRFC3339 is parseMonthRange in UTC as of now, formatted
+// as RFC 3339.
+func parseMonthYearRangeRFC3339(msg string) (string, string) {
+	from, to, ok := parseMonthRange(msg, time.Now(), time.UTC)
+	if !ok {
+		return "", ""
+	}
+	return from.Format(time.RFC3339), to.Format(time.RFC3339)
+}
+
+// monthRangeLabel names a range from parseMonthRange: "October 2024",
+// "Q4 2024", or "Oct 2024 – Dec 2024".
+func monthRangeLabel(from, to time.Time) string {
+	switch {
+	case from.AddDate(0, 1, 0).Equal(to):
+		return from.Format("January 2006")
+	case from.AddDate(0, 3, 0).Equal(to) && (from.Month()-1)%3 == 0:
+		return fmt.Sprintf("Q%d %d", (from.Month()-1)/3+1, from.Year())
+	}
+	return from.Format("Jan 2006") + " – " + to.AddDate(0, 0, -1).Format("Jan 2006")
+}
+
+func normalizeCitySelection(city, region, msgLower string) (string, bool) {
+	city = strings.ToLower(strings.TrimSpace(city))
+	region = strings.ToLower(strings.TrimSpace(region))
+	cityExplicit := false
+	if city != "" {
+		if len(city) >= 3 || region == "" || strings.Contains(msgLower, "city") {
+			cityExplicit = true
+		}
+	}
+	if region != "" && !cityExplicit {
+		city = ""
+	}
+	return city, cityExplicit
+}
+
+func extractFirstInt(s string) int {
+	re := regexp.MustCompile(`\b(\d{1,9})\b`)
+	mm := re.FindStringSubmatch(s)
+	if len(mm) != 2 {
+		return 0
+	}
+	n, err := strconv.Atoi(mm[1])
+	if err != nil {
+		return 0
+	}
+	if n <= 0 {
+		return 0
+	}
+	return n
+}
+
+func floatField(m map[string]any, keys ...string) float64 {
+	for _, k := range keys {
+		switch v := m[k].(type) {
+		case float64:
+			return v
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f
+			}
+		}
+	}
+	return 0
+}
+
+// foldRunes maps accented Latin letters and typographic punctuation to their
+// plain ASCII equivalents for matching.
+var foldRunes = func() map[rune]string {
+	m := map[rune]string{
+		'‘': "'", '’': "'", '‚': "'", '‛': "'", '′': "'", '`': "'", '´': "'",
+		'“': "\"", '”': "\"", '„': "\"", '‟': "\"", '″': "\"", '«': "\"", '»': "\"",
+		'‐': "-", '‑': "-", '‒': "-", '–': "-", '—': "-", '―': "-", '−': "-",
+		'…': "...", '\u00a0': " ", '\u2007': " ", '\u202f': " ", '\u3000': " ",
+		'ß': "ss", 'æ': "ae", 'Æ': "ae", 'œ': "oe", 'Œ': "oe", 'ø': "o", 'Ø': "o",
+		'đ': "d", 'Đ': "d", 'ð': "d", 'Ð': "d", 'ł': "l", 'Ł': "l", 'þ': "th", 'Þ': "th", 'ı': "i",
+	}
+	groups := map[string]string{
+		"a": "àáâãäåāăąǎÀÁÂÃÄÅĀĂĄǍ",
+		"c": "çćĉċčÇĆĈĊČ",
+		"d": "ďĎ",
+		"e": "èéêëēĕėęěÈÉÊËĒĔĖĘĚ",
+		"g": "ĝğġģĜĞĠĢ",
+		"h": "ĥĤ",
+		"i": "ìíîïĩīĭįìÌÍÎÏĨĪĬĮİ",
+		"j": "ĵĴ",
+		"k": "ķĶ",
+		"l": "ĺļľŀĹĻĽĿ",
+		"n": "ñńņňÑŃŅŇ",
+		"o": "òóôõöōŏőǒÒÓÔÕÖŌŎŐǑ",
+		"r": "ŕŗřŔŖŘ",
+		"s": "śŝşšșŚŜŞŠȘ",
+		"t": "ţťțŢŤȚ",
+		"u": "ùúûüũūŭůűųǔÙÚÛÜŨŪŬŮŰŲǓ",
+		"w": "ŵŴ",
+		"y": "ýÿŷÝŸŶ",
+		"z": "źżžŹŻŽ",
+	}
+	for base, chars := range groups {
+		for _, r := range chars {
+			m[r] = base
+		}
+	}
+	return m
+}()
+
+// foldText lowercases s, strips diacritics, maps full-width forms and smart
+// quotes/dashes to ASCII and collapses whitespace, so "Café Río" and
+// "cafe rio" compare equal. Use it only for matching; gateway queries and
+// answers keep the original names.
+func foldText(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		switch {
+		case r >= '\uff01' && r <= '\uff5e':
+			r -= 0xfee0
+		case unicode.Is(unicode.Mn, r):
+			// Combining marks from decomposed input ("e\u0301").
+			continue
+		}
+		if rep, ok := foldRunes[r]; ok {
+			b.WriteString(rep)
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func containsFolded(s, substr string) bool {
+	return strings.Contains(foldText(s), foldText(substr))
+}
+
+func normalizeLooseText(s string) string {
+	s = foldText(s)
+	if s == "" {
+		return ""
+	}
+	repl := strings.NewReplacer("_", " ", "-", " ")
+	s = repl.Replace(s)
+	s = strings.Join(strings.Fields(s), " ")
+	return s
+}
+
+func parseRows(body []byte) []any {
+	var root map[string]any
+	if json.Unmarshal(body, &root) != nil {
+		return nil
+	}
+	if v, ok := root["data"]; ok {
+		if rows, ok := v.([]any); ok {
+			return rows
+		}
+		if mm, ok := v.(map[string]any); ok {
+			if rows, ok := mm["data"].([]any); ok {
+				return rows
+			}
+			if rows, ok := mm["items"].([]any); ok {
+				return rows
+			}
+		}
+	}
+	if rows, ok := root["items"].([]any); ok {
+		return rows
+	}
+	return nil
+}
+
+func tokenizeWords(input string) []string {
+	if strings.TrimSpace(input) == "" {
+		return nil
+	}
+	lower := strings.ToLower(input)
+	var words []string
+	var b strings.Builder
+	flush := func() {
+		if b.Len() == 0 {
+			return
+		}
+		words = append(words, b.String())
+		b.Reset()
+	}
+	for _, r := range lower {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		} else {
+			flush()
+		}
+	}
+	flush()
+	return words
+}
+
+func collectProjectWords(values ...string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	seen := make(map[string]struct{})
+	result := make([]string, 0)
+	for _, val := range values {
+		for _, w := range tokenizeWords(val) {
+			if len(w) < 2 {
+				continue
+			}
+			if _, ok := seen[w]; ok {
+				continue
+			}
+			seen[w] = struct{}{}
+			result = append(result, w)
+		}
+	}
+	return result
+}
+
+func containsWord(text, word string) bool {
+	if strings.TrimSpace(text) == "" || strings.TrimSpace(word) == "" {
+		return false
+	}
+	target := strings.ToLower(strings.TrimSpace(word))
+	for _, w := range tokenizeWords(text) {
+		if strings.EqualFold(w, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func extractTopN(msgLower string) int {
+	s := strings.ToLower(strings.TrimSpace(msgLower))
+	if s == "" {
+		return 0
+	}
+	// Simple patterns: "top 5", "top 10".
+	for _, tok := range strings.Fields(s) {
+		if tok == "top" {
+			continue
+		}
+	}
+	parts := strings.Fields(s)
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] == "top" {
+			if n, err := strconv.Atoi(strings.TrimSpace(parts[i+1])); err == nil {
+				if n > 0 {
+					if n > 200 {
+						return 200
+					}
+					return n
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func looksLikeUUID(s string) bool {
+	s = strings.TrimSpace(s)
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '-' {
+			continue
+		}
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			continue
+		}
+		return false
+	}
+	return s[8] == '-' && s[13] == '-' && s[18] == '-' && s[23] == '-'
+}
+
+func detectHostTokens(msg string) []string {
+	if strings.TrimSpace(msg) == "" {
+		return nil
+	}
+	parts := strings.FieldsFunc(msg, func(r rune) bool {
+		switch r {
+		case ' ', '\n', '\t', ',', ';', ':', '/', '\\', '|':
+			return true
+		}
+		return strings.ContainsRune("()[]{}\"", r)
+	})
+	var out []string
+	seen := map[string]struct{}{}
+	for _, raw := range parts {
+		token := strings.Trim(raw, "\"'.,;:()[]{}")
+		if len(token) < 3 || len(token) > 50 {
+			continue
+		}
+		tokenLower := strings.ToLower(token)
+		if looksLikeUUID(tokenLower) {
+			continue
+		}
+		hasDigit := false
+		hasLetter := false
+		valid := true
+		for _, ch := range tokenLower {
+			if ch >= '0' && ch <= '9' {
+				hasDigit = true
+				continue
+			}
+			if ch >= 'a' && ch <= 'z' {
+				hasLetter = true
+				continue
+			}
+			if ch == '-' || ch == '_' {
+				continue
+			}
+			if ch == '.' {
+				continue
+			}
+			valid = false
+			break
+		}
+		if !valid || !hasDigit || !hasLetter {
+			continue
+		}
+		if _, exists := seen[tokenLower]; exists {
+			continue
+		}
+		seen[tokenLower] = struct{}{}
+		out = append(out, strings.TrimSpace(token))
+	}
+	return out
+}
+
+func clipString(s string, max int) string {
+	if max <= 0 {
+		return ""
+	}
+	if len(s) <= max {
+		return s
+	}
+	return s[:max]
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// yearRangeRe matches a whole year: "all of 2024", "for 2024", "in the year
+// 2024", "during the whole year 2024". A month such as "in 2024-10" is left
+// to the month parsers.
+var yearRangeRe = regexp.MustCompile(`\b(?:all\s+of|whole\s+of|(?:in|for|during)(?:\s+(?:the\s+)?(?:whole\s+|full\s+|entire\s+)?year(?:\s+of)?)?)\s+(\d{4})(?:[^\d/-]|$)`)
+
+// extractYearRangeRFC3339 returns the year a message names as a whole, as
+// UTC day boundaries like extractDateRangeRFC3339.
+func extractYearRangeRFC3339(msgLower string) (string, string) {
+	m := yearRangeRe.FindStringSubmatch(msgLower)
+	if m == nil {
+		return "", ""
+	}
+	y, _ := strconv.Atoi(m[1])
+	if !validYear(y) {
+		return "", ""
+	}
+	from := time.Date(y, time.January, 1, 0, 0, 0, 0, time.UTC)
+	return from.Format(time.RFC3339), from.AddDate(1, 0, 0).Format(time.RFC3339)
+}
+
+func extractDateRangeRFC3339(msgLower string) (string, string) {
+	// Accept a simple pattern in the user message: "from YYYY-MM-DD to YYYY-MM-DD".
+	// POP API expects RFC3339; normalize to UTC day boundaries.
+	s := strings.ToLower(strings.TrimSpace(msgLower))
+	if s == "" {
+		return "", ""
+	}
+	idx := strings.Index(s, " to ")
+	if idx < 0 {
+		return "", ""
+	}
+	left := strings.TrimSpace(s[:idx])
+	right := strings.TrimSpace(s[idx+4:])
+	fromTok := left
+	if j := strings.LastIndex(left, "from "); j >= 0 {
+		fromTok = strings.TrimSpace(left[j+5:])
+	}
+	toTok := right
+	if k := strings.IndexAny(toTok, "\n,;."); k >= 0 {
+		toTok = strings.TrimSpace(toTok[:k])
+	}
+	if len(fromTok) < 10 || len(toTok) < 10 {
+		return "", ""
+	}
+	fromTok = strings.TrimSpace(fromTok[:10])
+	toTok = strings.TrimSpace(toTok[:10])
+	fromT, err1 := time.Parse("2006-01-02", fromTok)
+	toT, err2 := time.Parse("2006-01-02", toTok)
+	if err1 != nil || err2 != nil {
+		return "", ""
+	}
+	from := time.Date(fromT.Year(), fromT.Month(), fromT.Day(), 0, 0, 0, 0, time.UTC)
+	to := time.Date(toT.Year(), toT.Month(), toT.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	if !from.Before(to) {
+		return "", ""
+	}
+	return from.Format(time.RFC3339), to.Format(time.RFC3339)
+}
+
+func extractCampaignID(s string) string {
+	tokens := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == '\n' || r == '\t' || r == ',' || r == ';'
+	})
+	for _, t := range tokens {
+		u := strings.TrimSpace(t)
+		u = strings.Trim(u, "()[]{}\"' ")
+		if looksLikeUUID(u) {
+			return u
+		}
+	}
+	return ""
+}
+
+// campaignStatusWords map what people (and gateways) call a campaign status
+// to the status it filters on, checked in order. "Upcoming" and "ended" are
+// not gateway statuses; they are read from the flight dates (see
+// effectiveCampaignStatus).
+var campaignStatusWords = []struct {
+	re     *regexp.Regexp
+	status string
+}{
+	{regexp.MustCompile(`\bscheduled\b`), "scheduled"},
+	{regexp.MustCompile(`\b(?:upcoming|future|not\s+(?:yet\s+)?started|starting\s+soon)\b`), "upcoming"},
+	{regexp.MustCompile(`\b(?:paused|on\s+hold|suspended)\b`), "paused"},
+	{regexp.MustCompile(`\b(?:ended|finished|completed?|expired)\b`), "ended"},
+	{regexp.MustCompile(`\b(?:active|running|live|ongoing)\b`), "active"},
+}
+
+func extractStatusFilter(msgLower string) string {
+	for _, w := range campaignStatusWords {
+		if w.re.MatchString(msgLower) {
+			return w.status
+		}
+	}
+	return ""
+}
+
+func extractAfterKeyword(msgLower, keyword string) string {
+	idx := strings.Index(msgLower, keyword)
+	if idx < 0 {
+		return ""
+	}
+	rest := msgLower[idx+len(keyword):]
+	return strings.TrimSpace(rest)
+}
+
+func extractAfterKeywordOriginal(msg string, keyword string) string {
+	msgTrim := strings.TrimSpace(msg)
+	if msgTrim == "" {
+		return ""
+	}
+	key := strings.ToLower(strings.TrimSpace(keyword))
+	if key == "" {
+		return ""
+	}
+	low := strings.ToLower(msgTrim)
+	idx := strings.Index(low, key)
+	if idx < 0 {
+		return ""
+	}
+	start := idx + len(key)
+	if start < 0 || start > len(msgTrim) {
+		return ""
+	}
+	return strings.TrimSpace(msgTrim[start:])
+}
+
+var (
+	posterKeywordRe  = regexp.MustCompile(`(?i)\bposter\s+(?:id\s+|named\s+|called\s+)?`)
+	posterNameStopRe = regexp.MustCompile(`(?i)\s+(?:in|for|from|during|by|same|kiosks?(?:[\s-]+wise)?|kioskwise|month|monthly|data|(?:jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec)[a-z]*\s+\d{4}|(?:last|this|past|previous)\s+(?:week|\d{1,3}\s+days?)|yesterday|today)\b`)
+)
+
+// posterFromMessage returns the poster a message names after the word
+// "poster", cut before the scope, date and breakdown words that usually
+// follow it ("pop for poster Lorla Studio for October 2024 in brt" ->
+// "Lorla Studio"). It returns "" when no poster is named.
+func posterFromMessage(msg string) string {
+	loc := posterKeywordRe.FindStringIndex(msg)
+	if loc == nil {
+		return ""
+	}
+	rest := " " + strings.TrimSpace(msg[loc[1]:])
+	if stop := posterNameStopRe.FindStringIndex(rest); stop != nil {
+		rest = rest[:stop[0]]
+	}
+	return strings.Trim(strings.TrimSpace(rest), `'"?.,!`)
+}
+
+func urlEscape(s string) string {
+	// url.QueryEscape is appropriate for encoding query parameter values.
+	return url.QueryEscape(strings.TrimSpace(s))
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMonthRange(t *testing.T) {
+	now := time.Date(2024, 11, 15, 12, 0, 0, 0, time.UTC)
+	est := time.FixedZone("EST", -5*3600)
+	cases := []struct {
+		msg      string
+		now      time.Time
+		loc      *time.Location
+		from, to string // "" when no range is found
+	}{
+		// Month names, with and without a year.
+		{msg: "pop for October 2024", from: "2024-10-01", to: "2024-11-01"},
+		{msg: "play count oct 2024", from: "2024-10-01", to: "2024-11-01"},
+		{msg: "August 2024 plays", from: "2024-08-01", to: "2024-09-01"},
+		{msg: "October data", from: "2024-10-01", to: "2024-11-01"},
+		{msg: "November data", from: "2024-11-01", to: "2024-12-01"},
+		{msg: "December data", from: "2023-12-01", to: "2024-01-01"},
+		{msg: "plays in may", from: "2024-05-01", to: "2024-06-01"},
+		{msg: "May Flowers poster plays"},
+		{msg: "oct data"},
+		// Numeric forms.
+		{msg: "pop 10/2024", from: "2024-10-01", to: "2024-11-01"},
+		{msg: "pop 3/2024", from: "2024-03-01", to: "2024-04-01"},
+		{msg: "show 2024-10 plays", from: "2024-10-01", to: "2024-11-01"},
+		{msg: "pop 13/2024"},
+		{msg: "pop 10/1999"},
+		{msg: "pop 2024-13"},
+		// Quarters.
+		{msg: "Q4 2024", from: "2024-10-01", to: "2025-01-01"},
+		{msg: "2024 q1 plays", from: "2024-01-01", to: "2024-04-01"},
+		{msg: "q4 plays", from: "2024-10-01", to: "2025-01-01"},
+		{msg: "q4 plays", now: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), from: "2024-10-01", to: "2025-01-01"},
+		{msg: "fourth quarter of 2023", from: "2023-10-01", to: "2024-01-01"},
+		{msg: "2nd quarter", from: "2024-04-01", to: "2024-07-01"},
+		{msg: "last quarter", from: "2024-07-01", to: "2024-10-01"},
+		{msg: "this quarter", from: "2024-10-01", to: "2025-01-01"},
+		// Relative months, in the request's timezone.
+		{msg: "pop for last month", from: "2024-10-01", to: "2024-11-01"},
+		{msg: "this month", from: "2024-11-01", to: "2024-12-01"},
+		{msg: "last month", now: time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), from: "2023-12-01", to: "2024-01-01"},
+		{msg: "this month", now: time.Date(2024, 11, 1, 2, 0, 0, 0, time.UTC), loc: est, from: "2024-10-01", to: "2024-11-01"},
+		{msg: "how many kiosks in moco"},
+		{msg: ""},
+	}
+	for _, tc := range cases {
+		n := tc.now
+		if n.IsZero() {
+			n = now
+		}
+		from, to, ok := parseMonthRange(tc.msg, n, tc.loc)
+		if tc.from == "" {
+			if ok {
+				t.Errorf("parseMonthRange(%q) = %v – %v, want no range", tc.msg, from, to)
+			}
+			continue
+		}
+		loc := tc.loc
+		if loc == nil {
+			loc = time.UTC
+		}
+		wantFrom, _ := time.ParseInLocation("2006-01-02", tc.from, loc)
+		wantTo, _ := time.ParseInLocation("2006-01-02", tc.to, loc)
+		if !ok || !from.Equal(wantFrom) || !to.Equal(wantTo) {
+			t.Errorf("parseMonthRange(%q) = %v – %v (%v), want %v – %v", tc.msg, from, to, ok, wantFrom, wantTo)
+		}
+	}
+}
+
+func TestStripOrdinals(t *testing.T) {
+	cases := map[string]string{
+		"august 1st 2024":   "august 1 2024",
+		"august 22nd 2024":  "august 22 2024",
+		"3rd august":        "3 august",
+		"31st december":     "31 december",
+		"first quarter":     "first quarter",
+		"augustine's 4th":   "augustine's 4",
+		"north 5th street":  "north 5 street",
+		"the 100th kiosk":   "the 100th kiosk",
+		"september 30 2024": "september 30 2024",
+	}
+	for in, want := range cases {
+		if got := stripOrdinals(in); got != want {
+			t.Errorf("stripOrdinals(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestExtractNaturalDateRange(t *testing.T) {
+	cases := []struct {
+		msg, from, to string
+	}{
+		// "august" used to lose its "st" to the ordinal stripping.
+		{"pop from August 1st 2024 to August 22nd 2024", "2024-08-01T00:00:00Z", "2024-08-23T00:00:00Z"},
+		{"plays from aug 3rd, 2024 till september 2nd, 2024", "2024-08-03T00:00:00Z", "2024-09-03T00:00:00Z"},
+		{"from October 1 2024 to October 31 2024", "2024-10-01T00:00:00Z", "2024-11-01T00:00:00Z"},
+		{"from October 31 2024 to October 1 2024", "", ""},
+		{"from someday to never", "", ""},
+		{"pop for August 2024", "", ""},
+	}
+	for _, tc := range cases {
+		from, to := extractNaturalDateRangeRFC3339(tc.msg)
+		if from != tc.from || to != tc.to {
+			t.Errorf("extractNaturalDateRangeRFC3339(%q) = %q, %q; want %q, %q", tc.msg, from, to, tc.from, tc.to)
+		}
+	}
+}
+
+func TestMonthRangeLabel(t *testing.T) {
+	d := func(y int, m time.Month) time.Time { return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC) }
+	cases := []struct {
+		from, to time.Time
+		want     string
+	}{
+		{d(2024, 10), d(2024, 11), "October 2024"},
+		{d(2024, 10), d(2025, 1), "Q4 2024"},
+		{d(2024, 11), d(2025, 2), "Nov 2024 – Jan 2025"},
+	}
+	for _, tc := range cases {
+		if got := monthRangeLabel(tc.from, tc.to); got != tc.want {
+			t.Errorf("monthRangeLabel(%v, %v) = %q, want %q", tc.from, tc.to, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"openai-agent-service/internal/models"
+)
+
+// partialFailures collects the gateway calls that failed in a handler that
+// chains several, so the sections already fetched are still answered with a
+// "could not fetch" line per failed call rather than replaced by one failure
+// message.
+type partialFailures struct {
+	lines []string
+	steps []int
+}
+
+// add records that what could not be fetched by steps[idx]. note, when set,
+// says how the answer is affected.
+func (p *partialFailures) add(what string, steps []models.Step, idx int, note string) {
+	reason := "no response"
+	if idx >= 0 && idx < len(steps) {
+		switch st := steps[idx]; {
+		case st.Error != "":
+			reason = st.Error
+		case st.Status != 0:
+			reason = fmt.Sprintf("status %d", st.Status)
+		}
+		p.steps = append(p.steps, idx)
+	}
+	line := fmt.Sprintf("Could not fetch %s (%s)", what, reason)
+	if note != "" {
+		line += "; " + note
+	}
+	p.lines = append(p.lines, line+".")
+}
+
+func (p *partialFailures) any() bool {
+	return len(p.lines) > 0
+}
+
+// note appends the failure lines to answer.
+func (p *partialFailures) note(answer string) string {
+	if !p.any() {
+		return answer
+	}
+	if strings.TrimSpace(answer) == "" {
+		return strings.Join(p.lines, "\n")
+	}
+	return strings.TrimSpace(answer) + "\n\n" + strings.Join(p.lines, "\n")
+}
+
+// meta flags m as built from partial data and names the failed steps.
+func (p *partialFailures) meta(m *models.ResponseMeta) *models.ResponseMeta {
+	if !p.any() {
+		return m
+	}
+	if m == nil {
+		m = &models.ResponseMeta{}
+	}
+	m.PartialFailure = true
+	m.FailedSteps = append(m.FailedSteps, p.steps...)
+	return m
+}
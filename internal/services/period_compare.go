@@ -0,0 +1,381 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+var (
+	// periodCompareRe matches "vs last week", "compared to the previous
+	// month", "week over week" and the like; group 1 or 2 is the unit.
+	periodCompareRe = regexp.MustCompile(`(?i)\b(?:vs\.?|versus|compared\s+(?:to|with))\s+(?:the\s+)?(?:last|previous|prior|preceding)\s+(week|month|period)\b|\b(week|month)[\s-]+over[\s-]+(?:week|month)\b`)
+	// periodCurrentRe matches the current-period words around a comparison.
+	periodCurrentRe = regexp.MustCompile(`(?i)\b(?:this|current)\s+(?:week|month|period)\b`)
+)
+
+// periodCompareUnit returns the unit a comparison question names ("week",
+// "month" or "period"), or "" when the message asks for no comparison.
+func periodCompareUnit(msg string) string {
+	m := periodCompareRe.FindStringSubmatch(msg)
+	if m == nil {
+		return ""
+	}
+	return strings.ToLower(firstNonEmpty(m[1], m[2]))
+}
+
+// stripPeriodPhrases removes the comparison wording so the rest of the
+// message parses like an ordinary POP question.
+func stripPeriodPhrases(msg string) string {
+	msg = periodCompareRe.ReplaceAllString(msg, " ")
+	msg = periodCurrentRe.ReplaceAllString(msg, " ")
+	return strings.Join(strings.Fields(msg), " ")
+}
+
+// periodWindows are the two equally long windows of a comparison.
+type periodWindows struct {
+	CurFrom, CurTo   time.Time
+	PrevFrom, PrevTo time.Time
+	CurLabel         string
+	PrevLabel        string
+}
+
+// comparisonWindows picks the windows of a comparison. An explicit date range
+// is compared with the equally long span before it. Otherwise the current
+// week (from Monday 00:00 in loc) or month so far is compared with the same
+// stretch of the previous week or month: Monday to the same weekday and time,
+// or the 1st to the same day. A stretch that would run past the end of a
+// shorter previous month ends at the month boundary instead, keeping both
+// windows the same length.
+func comparisonWindows(msg, unit string, now time.Time, loc *time.Location) periodWindows {
+	if loc == nil {
+		loc = time.UTC
+	}
+	rest := stripPeriodPhrases(msg)
+	fromRFC, toRFC := extractDateRangeRFC3339(strings.ToLower(rest))
+	if fromRFC == "" && toRFC == "" {
+		fromRFC, toRFC = extractNaturalDateRangeRFC3339(rest)
+	}
+	from, errF := time.Parse(time.RFC3339, fromRFC)
+	to, errT := time.Parse(time.RFC3339, toRFC)
+	if errF == nil && errT == nil && to.After(from) {
+		span := to.Sub(from)
+		return periodWindows{
+			CurFrom: from, CurTo: to, PrevFrom: from.Add(-span), PrevTo: from,
+			CurLabel: "selected period", PrevLabel: "previous period",
+		}
+	}
+
+	now = now.In(loc)
+	if unit == "month" {
+		curFrom := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+		span := now.Sub(curFrom)
+		prevFrom := curFrom.AddDate(0, -1, 0)
+		prevTo := prevFrom.Add(span)
+		if prevTo.After(curFrom) {
+			prevFrom, prevTo = curFrom.Add(-span), curFrom
+		}
+		return periodWindows{
+			CurFrom: curFrom, CurTo: now, PrevFrom: prevFrom, PrevTo: prevTo,
+			CurLabel: "this month", PrevLabel: "last month",
+		}
+	}
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	curFrom := day.AddDate(0, 0, -((int(now.Weekday()) + 6) % 7))
+	prevFrom := curFrom.AddDate(0, 0, -7)
+	return periodWindows{
+		CurFrom: curFrom, CurTo: now, PrevFrom: prevFrom, PrevTo: prevFrom.Add(now.Sub(curFrom)),
+		CurLabel: "this week", PrevLabel: "last week",
+	}
+}
+
+// periodTotals is one window's total with its breakdown by kiosk or poster
+// (nil without a breakdown).
+type periodTotals struct {
+	Total int64
+	By    map[string]int64
+}
+
+// periodAggregator computes totals over [from, to). The comparison decorator
+// runs it once per window.
+type periodAggregator func(ctx context.Context, from, to time.Time) (periodTotals, []models.Step, *popPager, error)
+
+// Breakdown levels of a POP period comparison.
+const (
+	periodByNone   = ""
+	periodByKiosk  = "kiosk"
+	periodByPoster = "poster"
+)
+
+// popPeriodAggregator sums POP plays matching filter (without a date range),
+// broken down by host or poster name when by asks for it.
+func (c *ChatService) popPeriodAggregator(filter, by string) periodAggregator {
+	return func(ctx context.Context, from, to time.Time) (periodTotals, []models.Step, *popPager, error) {
+		window := "from=" + urlEscape(from.UTC().Format(time.RFC3339)) + "&to=" + urlEscape(to.UTC().Format(time.RFC3339))
+		if filter != "" {
+			window = filter + "&" + window
+		}
+		rows, steps, pager, err := c.fetchPopRows(ctx, window)
+		out := periodTotals{}
+		if by != periodByNone {
+			out.By = map[string]int64{}
+		}
+		for _, r := range rows {
+			out.Total += r.PlayCount
+			key := ""
+			switch by {
+			case periodByKiosk:
+				key = strings.ToLower(strings.TrimSpace(r.HostName))
+			case periodByPoster:
+				key = strings.TrimSpace(r.PosterName)
+				if key == "" {
+					key = strings.TrimSpace(r.PosterID)
+				}
+			}
+			if key != "" {
+				out.By[key] += r.PlayCount
+			}
+		}
+		return out, steps, pager, err
+	}
+}
+
+// periodMover is a breakdown key whose value moved between the windows.
+type periodMover struct {
+	Key       string
+	Prev, Cur int64
+}
+
+// periodComparison is the result of running an aggregator over both windows.
+type periodComparison struct {
+	Windows periodWindows
+	Cur     periodTotals
+	Prev    periodTotals
+	Movers  []periodMover
+}
+
+// comparePeriods runs agg over the current and previous window and ranks the
+// breakdown keys by absolute change. It decorates any aggregator, so a new
+// metric only has to compute one window. The pager that truncated wins.
+func comparePeriods(ctx context.Context, agg periodAggregator, w periodWindows) (periodComparison, []models.Step, *popPager, error) {
+	cur, steps, pager, err := agg(ctx, w.CurFrom, w.CurTo)
+	if err != nil {
+		return periodComparison{}, steps, pager, fmt.Errorf("%s: %w", w.CurLabel, err)
+	}
+	prev, prevSteps, prevPager, err := agg(ctx, w.PrevFrom, w.PrevTo)
+	steps = append(steps, prevSteps...)
+	if err != nil {
+		return periodComparison{}, steps, pager, fmt.Errorf("%s: %w", w.PrevLabel, err)
+	}
+	if pager == nil || (prevPager != nil && prevPager.Truncated && !pager.Truncated) {
+		pager = prevPager
+	}
+	cmp := periodComparison{Windows: w, Cur: cur, Prev: prev}
+	for k, v := range cur.By {
+		if v != prev.By[k] {
+			cmp.Movers = append(cmp.Movers, periodMover{Key: k, Prev: prev.By[k], Cur: v})
+		}
+	}
+	for k, v := range prev.By {
+		if _, ok := cur.By[k]; !ok && v != 0 {
+			cmp.Movers = append(cmp.Movers, periodMover{Key: k, Prev: v})
+		}
+	}
+	sort.Slice(cmp.Movers, func(i, j int) bool {
+		di, dj := absInt64(cmp.Movers[i].Cur-cmp.Movers[i].Prev), absInt64(cmp.Movers[j].Cur-cmp.Movers[j].Prev)
+		if di != dj {
+			return di > dj
+		}
+		return cmp.Movers[i].Key < cmp.Movers[j].Key
+	})
+	return cmp, steps, pager, nil
+}
+
+func absInt64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// formatPeriodChange renders the move from prev to cur with an up/down
+// indicator and, when prev is not zero, the percentage.
+func formatPeriodChange(prev, cur int64) string {
+	d := cur - prev
+	switch {
+	case d == 0:
+		return "no change"
+	case prev == 0:
+		return fmt.Sprintf("▲ +%s (none in the previous period)", formatThousands(d))
+	}
+	arrow, sign := "▲", "+"
+	if d < 0 {
+		arrow, sign = "▼", "-"
+	}
+	pct := math.Abs(float64(d)) / float64(prev) * 100
+	return fmt.Sprintf("%s %s%s (%s%s)", arrow, sign, formatThousands(absInt64(d)), sign, formatPercent(pct))
+}
+
+// windowLabel renders [from, to) as dates in loc.
+func windowLabel(from, to time.Time, loc *time.Location) string {
+	from, last := from.In(loc), to.In(loc).Add(-time.Second)
+	if from.Format("2006-01-02") == last.Format("2006-01-02") {
+		return from.Format("Jan 2")
+	}
+	return from.Format("Jan 2") + "–" + last.Format("Jan 2")
+}
+
+// formatPeriodComparison renders both totals, the change and the top three
+// movers of the breakdown; labels maps breakdown keys to display names.
+func formatPeriodComparison(subject string, cmp periodComparison, by string, labels map[string]string, loc *time.Location) string {
+	w := cmp.Windows
+	lines := []string{
+		fmt.Sprintf("%s, %s (%s) vs %s (%s):", subject, w.CurLabel, windowLabel(w.CurFrom, w.CurTo, loc), w.PrevLabel, windowLabel(w.PrevFrom, w.PrevTo, loc)),
+		fmt.Sprintf("- %s: %s plays", capitalize(w.CurLabel), formatThousands(cmp.Cur.Total)),
+		fmt.Sprintf("- %s: %s plays", capitalize(w.PrevLabel), formatThousands(cmp.Prev.Total)),
+		"- Change: " + formatPeriodChange(cmp.Prev.Total, cmp.Cur.Total),
+	}
+	if by != periodByNone && len(cmp.Movers) > 0 {
+		lines = append(lines, fmt.Sprintf("Top movers by %s:", by))
+		for i, m := range cmp.Movers {
+			if i >= 3 {
+				break
+			}
+			label := m.Key
+			if l := labels[m.Key]; l != "" {
+				label = l
+			}
+			lines = append(lines, fmt.Sprintf("%d. %s — %s → %s, %s", i+1, label, formatThousands(m.Prev), formatThousands(m.Cur), formatPeriodChange(m.Prev, m.Cur)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// periodBreakdown picks the breakdown level: the one the message asks for,
+// else kiosks for a poster and posters for a kiosk.
+func periodBreakdown(msgLower, posterName, host string) string {
+	switch {
+	case strings.Contains(msgLower, "kiosk wise") || strings.Contains(msgLower, "kiosk-wise") || strings.Contains(msgLower, "kioskwise") || strings.Contains(msgLower, "by kiosk"):
+		return periodByKiosk
+	case strings.Contains(msgLower, "poster wise") || strings.Contains(msgLower, "poster-wise") || strings.Contains(msgLower, "posterwise") || strings.Contains(msgLower, "by poster"):
+		return periodByPoster
+	case posterName != "":
+		return periodByKiosk
+	case host != "":
+		return periodByPoster
+	}
+	return periodByNone
+}
+
+// handlePeriodComparison answers "this week vs last week" style questions
+// for poster plays, kiosk plays and scope-level POP totals.
+func (c *ChatService) handlePeriodComparison(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	unit := periodCompareUnit(req.Message)
+	if unit == "" {
+		return models.ChatResponse{}, false, nil
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	rest := stripPeriodPhrases(req.Message)
+	restLower := strings.ToLower(rest)
+	if strings.Contains(restLower, "campaign") && strings.Contains(restLower, "impression") {
+		// ADS campaign impressions are lifetime totals without a date filter.
+		return reply(models.ChatResponse{Answer: "Campaign impressions are only available as lifetime totals, so they can't be split into periods. Ask for a poster's plays instead, e.g. \"plays for poster Bet 365 this week vs last week\"."})
+	}
+	if c.Gateway == nil {
+		return reply(models.ChatResponse{Answer: "Tool gateway is not configured."})
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	posterName := posterFromMessage(rest)
+	host := alertHostToken(rest)
+	if strings.Count(host, "-") < 2 {
+		host = ""
+	}
+	city := c.detectCityCode(ctx, restLower)
+	region := c.detectRegionCode(ctx, restLower)
+	st := c.getConversationState(conversationID)
+	if posterName == "" && host == "" && st != nil && (listItRe.MatchString(restLower) || strings.Contains(restLower, "same")) {
+		posterName = strings.TrimSpace(st.PosterName)
+		if posterName == "" {
+			host = strings.TrimSpace(st.Host)
+		}
+	}
+	if city == "" && region == "" && st != nil {
+		city = strings.ToLower(strings.TrimSpace(st.City))
+		region = strings.ToLower(strings.TrimSpace(st.Region))
+	}
+	if conversationID != "" {
+		c.updateConversationLocation(conversationID, city, region)
+		if posterName != "" {
+			c.updateConversationPoster(conversationID, posterName, "", "")
+		} else if host != "" {
+			c.updateConversationHost(conversationID, host)
+		}
+	}
+
+	filters := make([]string, 0, 2)
+	subject := "POP plays"
+	switch {
+	case host != "":
+		filters = append(filters, "host_name="+urlEscape(host))
+		subject = "Plays on kiosk " + host
+	case posterName != "":
+		key := "poster_name"
+		if looksLikeUUID(posterName) {
+			key = "poster_id"
+		}
+		filters = append(filters, key+"="+urlEscape(posterName))
+		subject = "Plays for poster '" + posterName + "'"
+	}
+	if region != "" {
+		filters = append(filters, "region="+urlEscape(region))
+		subject += " in region '" + region + "'"
+	} else if city != "" {
+		filters = append(filters, "city="+urlEscape(city))
+		subject += " in city '" + city + "'"
+	} else if host == "" {
+		subject += " across all locations"
+	}
+
+	loc := requestLocation(req)
+	by := periodBreakdown(restLower, posterName, host)
+	w := comparisonWindows(req.Message, unit, time.Now(), loc)
+	cmp, steps, pager, err := comparePeriods(ctx, c.popPeriodAggregator(strings.Join(filters, "&"), by), w)
+	if err != nil {
+		return reply(models.ChatResponse{Answer: "Failed to fetch POP data for " + err.Error(), Steps: steps})
+	}
+
+	var labels map[string]string
+	if by == periodByKiosk {
+		hosts := make([]string, 0, 3)
+		for i, m := range cmp.Movers {
+			if i >= 3 {
+				break
+			}
+			hosts = append(hosts, m.Key)
+		}
+		var metaSteps []models.Step
+		labels, metaSteps = c.deviceLabels(ctx, city, region, hosts)
+		steps = append(steps, metaSteps...)
+	}
+	answer := pager.note(formatPeriodComparison(subject, cmp, by, labels, loc))
+	return reply(models.ChatResponse{Answer: answer, Steps: steps, Meta: pager.meta()})
+}
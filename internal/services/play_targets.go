@@ -0,0 +1,402 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// PlayTargetStore persists per-kiosk play targets; implemented by
+// store.PostgresStore.
+type PlayTargetStore interface {
+	CreatePlayTarget(ctx context.Context, target models.PlayTarget) (models.PlayTarget, error)
+	ListPlayTargets(ctx context.Context, ownerKey, period string) ([]models.PlayTarget, error)
+	DeletePlayTarget(ctx context.Context, ownerKey string, id int64) error
+}
+
+// ValidatePlayTarget normalizes a target and checks it is evaluable. An empty
+// period means the current month (UTC).
+func ValidatePlayTarget(t *models.PlayTarget) error {
+	t.CampaignID = strings.TrimSpace(t.CampaignID)
+	t.PosterID = strings.TrimSpace(t.PosterID)
+	t.ScopeType = strings.ToLower(strings.TrimSpace(t.ScopeType))
+	t.ScopeValue = strings.ToLower(strings.TrimSpace(t.ScopeValue))
+	t.Period = strings.TrimSpace(t.Period)
+	if (t.CampaignID == "") == (t.PosterID == "") {
+		return errors.New("exactly one of campaign_id or poster_id is required")
+	}
+	seen := map[string]bool{}
+	hosts := make([]string, 0, len(t.Hosts))
+	for _, h := range t.Hosts {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h != "" && !seen[h] {
+			seen[h] = true
+			hosts = append(hosts, h)
+		}
+	}
+	t.Hosts = hosts
+	if t.ScopeType == "" && len(t.Hosts) > 0 {
+		t.ScopeType = "hosts"
+	}
+	switch t.ScopeType {
+	case "hosts":
+		if len(t.Hosts) == 0 {
+			return errors.New("hosts is required for scope_type \"hosts\"")
+		}
+		t.ScopeValue = ""
+	case "city", "region":
+		if t.ScopeValue == "" {
+			return fmt.Errorf("scope_value is required for scope_type %q", t.ScopeType)
+		}
+		t.Hosts = nil
+	case "":
+		return errors.New("scope_type (city, region or hosts) or a hosts list is required")
+	default:
+		return fmt.Errorf("unknown scope_type %q (use city, region or hosts)", t.ScopeType)
+	}
+	if t.TargetPlays <= 0 {
+		return errors.New("target_plays must be positive")
+	}
+	if t.Period == "" {
+		t.Period = time.Now().UTC().Format("2006-01")
+	}
+	if _, err := time.Parse("2006-01", t.Period); err != nil {
+		return fmt.Errorf("period must look like 2026-10, got %q", t.Period)
+	}
+	return nil
+}
+
+func describePlayTargetScope(t models.PlayTarget) string {
+	switch t.ScopeType {
+	case "city":
+		return "in city '" + t.ScopeValue + "'"
+	case "region":
+		return "in region '" + t.ScopeValue + "'"
+	}
+	if len(t.Hosts) == 1 {
+		return "on " + t.Hosts[0]
+	}
+	return fmt.Sprintf("on %d listed kiosks", len(t.Hosts))
+}
+
+func isPlayTargetIntent(msgLower string) bool {
+	if !strings.Contains(msgLower, "target") || strings.Contains(msgLower, "alert") {
+		return false
+	}
+	for _, k := range []string{"play target", "plays target", "target plays", "hitting", "meeting", "attainment", "below target", "under target", "on target", "achiev"} {
+		if strings.Contains(msgLower, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripTargetPhrases leaves just the campaign reference for name resolution
+// ("are we hitting the play targets for campaign spring sale this month" ->
+// "... campaign spring sale").
+func stripTargetPhrases(msgLower string) string {
+	s := msgLower
+	for _, k := range []string{"this month", "last month", "current month", "so far", "right now"} {
+		s = strings.ReplaceAll(s, k, " ")
+	}
+	s = strings.TrimRight(strings.TrimSpace(s), "?.!")
+	if from, _ := parseMonthYearRangeRFC3339(s); from != "" {
+		// Drop a trailing "in October 2026" / "for oct 2026".
+		words := strings.Fields(s)
+		for i := len(words) - 1; i >= 0; i-- {
+			if f, _ := parseMonthYearRangeRFC3339(strings.Join(words[i:], " ")); f != "" {
+				words = words[:i]
+				if n := len(words); n > 0 && (words[n-1] == "in" || words[n-1] == "for" || words[n-1] == "during") {
+					words = words[:n-1]
+				}
+				break
+			}
+		}
+		s = strings.Join(words, " ")
+	}
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// targetPeriod picks the month a target question is about: an explicit
+// "October 2026", "last month", or the current month in loc.
+func targetPeriod(msgLower string, now time.Time, loc *time.Location) (time.Time, time.Time) {
+	// Targets are monthly, so quarter phrases fall back to the current month.
+	if from, to, ok := parseMonthRange(msgLower, now, loc); ok && from.AddDate(0, 1, 0).Equal(to) {
+		return from, to
+	}
+	now = now.In(loc)
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// campaignPosterIDs lists the posters that belong to a campaign, taken from
+// the /pop/impressions breakdown.
+func (c *ChatService) campaignPosterIDs(ctx context.Context, campaignID string) ([]string, *models.Step) {
+	status, body, err := c.Gateway.Get(ctx, "/pop/impressions?campaign_id="+urlEscape(campaignID))
+	step := &models.Step{Tool: "popImpressions", CampaignID: campaignID, Status: status}
+	if err != nil {
+		step.Error = err.Error()
+		return nil, step
+	}
+	step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	if status < 200 || status >= 300 {
+		return nil, step
+	}
+	var resp struct {
+		Posters []struct {
+			PosterID string `json:"poster_id"`
+		} `json:"posters"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return nil, step
+	}
+	ids := make([]string, 0, len(resp.Posters))
+	for _, p := range resp.Posters {
+		if id := strings.TrimSpace(p.PosterID); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, step
+}
+
+// kioskAttainment is one kiosk's plays against its (pro-rated) target.
+type kioskAttainment struct {
+	Host     string
+	Name     string
+	Plays    int64
+	Expected int64
+}
+
+func (k kioskAttainment) label() string {
+	if k.Name != "" && !strings.EqualFold(k.Name, k.Host) {
+		return k.Host + " (" + k.Name + ")"
+	}
+	return k.Host
+}
+
+// handlePlayTargets answers "are we hitting the play targets for campaign X
+// this month": it sums the period's POP plays per kiosk for the campaign's
+// posters and compares them with the registered per-kiosk targets. Targets
+// for a month in progress are pro-rated to the elapsed share of the month.
+func (c *ChatService) handlePlayTargets(ctx context.Context, ownerKey string, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(strings.TrimSpace(req.Message))
+	if !isPlayTargetIntent(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	if c.Targets == nil {
+		return reply(models.ChatResponse{Answer: "Play targets are not configured."})
+	}
+	if c.Gateway == nil {
+		return reply(models.ChatResponse{Answer: "Tool gateway is not configured."})
+	}
+
+	steps := make([]models.Step, 0, 4)
+	campaign, query, ok := c.resolveCampaign(ctx, stripTargetPhrases(msgLower))
+	if !ok {
+		if query != "" {
+			return reply(models.ChatResponse{Answer: fmt.Sprintf("I couldn't find a single campaign matching '%s'. Please give the campaign name or id.", query)})
+		}
+		if st := c.getConversationState(req.ConversationID); st != nil && st.CampaignID != "" {
+			campaign = campaignCandidate{ID: st.CampaignID}
+		} else {
+			return reply(models.ChatResponse{Answer: "Which campaign? For example: \"are we hitting the play targets for campaign Spring Sale this month\"."})
+		}
+	}
+	c.updateConversationCampaignID(req.ConversationID, campaign.ID)
+	campaignLabel := campaign.ID
+	if campaign.Name != "" {
+		campaignLabel = campaign.Name
+	}
+
+	loc := requestLocation(req)
+	now := time.Now().In(loc)
+	start, end := targetPeriod(msgLower, now, loc)
+	period := start.Format("2006-01")
+	monthLabel := start.Format("January 2006")
+
+	all, err := c.Targets.ListPlayTargets(ctx, ownerKey, period)
+	if err != nil {
+		return reply(models.ChatResponse{Answer: "Failed to load play targets: " + err.Error()})
+	}
+	posterIDs, step := c.campaignPosterIDs(ctx, campaign.ID)
+	if step != nil {
+		steps = append(steps, *step)
+	}
+	inCampaign := map[string]bool{}
+	for _, id := range posterIDs {
+		inCampaign[id] = true
+	}
+	targets := make([]models.PlayTarget, 0, len(all))
+	for _, t := range all {
+		if t.CampaignID == campaign.ID || (t.PosterID != "" && inCampaign[t.PosterID]) {
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 {
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("No play targets are registered for campaign %s in %s. Register one with POST /targets.", campaignLabel, monthLabel), Steps: steps})
+	}
+
+	// Share of the month that has elapsed; 1 for past months.
+	frac := 1.0
+	if now.Before(end) {
+		frac = math.Max(0, float64(now.Sub(start))/float64(end.Sub(start)))
+	}
+
+	window := "&from=" + urlEscape(start.UTC().Format(time.RFC3339)) + "&to=" + urlEscape(end.UTC().Format(time.RFC3339))
+	playsByPoster := map[string]map[string]int64{}
+	kioskNames := map[string]string{}
+	var pager *popPager
+	fetchPoster := func(id string) error {
+		if _, done := playsByPoster[id]; done {
+			return nil
+		}
+		rows, popSteps, p, err := c.fetchPopRows(ctx, "poster_id="+urlEscape(id)+window)
+		steps = append(steps, popSteps...)
+		if pager == nil || (p != nil && p.Truncated) {
+			pager = p
+		}
+		if err != nil {
+			return err
+		}
+		byHost := map[string]int64{}
+		for _, r := range rows {
+			host := strings.ToLower(strings.TrimSpace(r.HostName))
+			if host == "" {
+				continue
+			}
+			byHost[host] += r.PlayCount
+			if kioskNames[host] == "" {
+				kioskNames[host] = strings.TrimSpace(r.KioskName)
+			}
+		}
+		playsByPoster[id] = byHost
+		return nil
+	}
+
+	lines := make([]string, 0, 16)
+	header := fmt.Sprintf("Play targets for campaign %s, %s", campaignLabel, monthLabel)
+	if frac < 1 {
+		header += fmt.Sprintf(" (through %s, %s of the month; targets are pro-rated)", now.Format("Jan 2"), formatPercent(frac*100))
+	}
+	lines = append(lines, header+":")
+
+	var sumCapped, sumExpected int64
+	onTrackTotal, kioskTotal := 0, 0
+	for _, t := range targets {
+		posters := []string{t.PosterID}
+		if t.CampaignID != "" {
+			posters = posterIDs
+		}
+		for _, id := range posters {
+			if err := fetchPoster(id); err != nil {
+				return reply(models.ChatResponse{Answer: "Failed to fetch POP data: " + err.Error(), Steps: steps})
+			}
+		}
+
+		kiosks := make([]kioskAttainment, 0, len(t.Hosts))
+		switch t.ScopeType {
+		case "hosts":
+			for _, h := range t.Hosts {
+				kiosks = append(kiosks, kioskAttainment{Host: h})
+			}
+		default:
+			city, region := "", ""
+			if t.ScopeType == "city" {
+				city = t.ScopeValue
+			} else {
+				region = t.ScopeValue
+			}
+			inv, invSteps := c.deviceHosts(ctx, city, region)
+			steps = append(steps, invSteps...)
+			for _, d := range inv {
+				kiosks = append(kiosks, kioskAttainment{Host: d.Host, Name: d.Name})
+			}
+		}
+
+		expected := int64(math.Ceil(float64(t.TargetPlays) * frac))
+		below := make([]kioskAttainment, 0, len(kiosks))
+		onTrack := make([]kioskAttainment, 0, len(kiosks))
+		for _, k := range kiosks {
+			for _, id := range posters {
+				k.Plays += playsByPoster[id][k.Host]
+			}
+			if k.Name == "" {
+				k.Name = kioskNames[k.Host]
+			}
+			k.Expected = expected
+			sumExpected += expected
+			sumCapped += min(k.Plays, expected)
+			if k.Plays >= expected {
+				onTrack = append(onTrack, k)
+			} else {
+				below = append(below, k)
+			}
+		}
+		kioskTotal += len(kiosks)
+		onTrackTotal += len(onTrack)
+		sort.Slice(below, func(i, j int) bool {
+			if below[i].Plays == below[j].Plays {
+				return below[i].Host < below[j].Host
+			}
+			return below[i].Plays < below[j].Plays
+		})
+		sort.Slice(onTrack, func(i, j int) bool { return onTrack[i].Host < onTrack[j].Host })
+
+		subject := "campaign posters"
+		if t.CampaignID == "" {
+			subject = "poster " + t.PosterID
+		}
+		target := fmt.Sprintf("Target #%d: %s plays per kiosk %s for %s", t.ID, formatThousands(t.TargetPlays), describePlayTargetScope(t), subject)
+		if frac < 1 {
+			target += fmt.Sprintf(" (expected to date: %s)", formatThousands(expected))
+		}
+		lines = append(lines, "", target)
+		if len(kiosks) == 0 {
+			lines = append(lines, "- No kiosks found in this scope.")
+			continue
+		}
+		if len(below) > 0 {
+			lines = append(lines, fmt.Sprintf("Below target (%d):", len(below)))
+			for _, k := range below {
+				played := formatThousands(k.Plays) + " plays"
+				if k.Plays == 0 {
+					played = "0 plays (nothing recorded)"
+				}
+				lines = append(lines, fmt.Sprintf("- %s — %s, %s short", k.label(), played, formatThousands(k.Expected-k.Plays)))
+			}
+		}
+		if len(onTrack) > 0 {
+			label := "On track"
+			if frac >= 1 {
+				label = "Met target"
+			}
+			lines = append(lines, fmt.Sprintf("%s (%d):", label, len(onTrack)))
+			for _, k := range onTrack {
+				lines = append(lines, fmt.Sprintf("- %s — %s plays", k.label(), formatThousands(k.Plays)))
+			}
+		}
+	}
+
+	if sumExpected > 0 {
+		lines = append(lines, "", fmt.Sprintf("Overall attainment: %s of expected plays (kiosks above target count at their target); %d of %d kiosks at or above target.",
+			formatPercent(float64(sumCapped)*100/float64(sumExpected)), onTrackTotal, kioskTotal))
+	}
+	if len(posterIDs) == 0 {
+		lines = append(lines, fmt.Sprintf("Note: no posters were found for campaign %s, so its campaign-wide targets count zero plays.", campaignLabel))
+	}
+	answer := strings.Join(lines, "\n")
+	return reply(models.ChatResponse{Answer: pager.note(answer), Steps: steps, Meta: pager.meta()})
+}
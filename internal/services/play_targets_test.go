@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"openai-agent-service/internal/models"
+)
+
+// memTargets is an in-memory PlayTargetStore.
+type memTargets struct{ targets []models.PlayTarget }
+
+func (s *memTargets) CreatePlayTarget(_ context.Context, t models.PlayTarget) (models.PlayTarget, error) {
+	t.ID = int64(len(s.targets) + 1)
+	s.targets = append(s.targets, t)
+	return t, nil
+}
+
+func (s *memTargets) ListPlayTargets(_ context.Context, ownerKey, period string) ([]models.PlayTarget, error) {
+	var out []models.PlayTarget
+	for _, t := range s.targets {
+		if t.OwnerKey == ownerKey && (period == "" || t.Period == period) {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+func (s *memTargets) DeletePlayTarget(context.Context, string, int64) error { return nil }
+
+func TestPlayTargetAttainment(t *testing.T) {
+	g := newFakeGateway(t, &fakeGateway{
+		Devices: testDevices,
+		Pop:     testPop(),
+		Routes: map[string]string{
+			"/pop/impressions": `{"posters":[{"poster_id":"` + testBetID + `"}]}`,
+		},
+	})
+	c := newTestChat(g)
+	targets := &memTargets{}
+	c.Targets = targets
+	ctx := context.Background()
+	for _, tgt := range []models.PlayTarget{
+		// Bet 365 in October 2024: 260 on the lobby, 45 on the annex, 300 at
+		// Union Station and nothing on the fourth kiosk.
+		{OwnerKey: "test-key", CampaignID: testCampaignID, Hosts: []string{"moco-brt-briggs-001", "moco-brt-briggs-002", "kc-kcmo-union-003", "moco-brt-idle-004"}, TargetPlays: 150, Period: "2024-10"},
+		// Another month and another owner are ignored.
+		{OwnerKey: "test-key", CampaignID: testCampaignID, Hosts: []string{"moco-brt-briggs-001"}, TargetPlays: 9999, Period: "2024-11"},
+		{OwnerKey: "other-key", CampaignID: testCampaignID, Hosts: []string{"moco-brt-briggs-001"}, TargetPlays: 9999, Period: "2024-10"},
+	} {
+		if err := ValidatePlayTarget(&tgt); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := targets.CreatePlayTarget(ctx, tgt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	answer := chatOnce(t, c, "are we hitting the play targets for campaign "+testCampaignID+" in October 2024").Answer
+	for _, want := range []string{
+		"Play targets for campaign " + testCampaignID + ", October 2024:",
+		"Target #1: 150 plays per kiosk on 4 listed kiosks for campaign posters",
+		"Below target (2):",
+		"- moco-brt-idle-004 — 0 plays (nothing recorded), 150 short",
+		"- moco-brt-briggs-002 (Briggs Annex) — 45 plays, 105 short",
+		"Met target (2):",
+		"- kc-kcmo-union-003 (Union Station) — 300 plays",
+		"- moco-brt-briggs-001 (Briggs Lobby) — 260 plays",
+		"2 of 4 kiosks at or above target.",
+	} {
+		if !strings.Contains(answer, want) {
+			t.Errorf("answer\n%s\nlacks %q", answer, want)
+		}
+	}
+	// Kiosks above target count at their target: (150+45+150+0)/600.
+	if !strings.Contains(answer, "Overall attainment: 57.5%") {
+		t.Errorf("answer\n%s\nlacks the 57.5%% attainment", answer)
+	}
+	if strings.Contains(answer, "9,999") || strings.Contains(answer, "Target #2") || strings.Contains(answer, "Target #3") {
+		t.Errorf("answer\n%s\nuses another month's or owner's target", answer)
+	}
+}
+
+func TestValidatePlayTarget(t *testing.T) {
+	cases := []struct {
+		name    string
+		target  models.PlayTarget
+		wantErr string
+		check   func(models.PlayTarget) bool
+	}{
+		{name: "hosts imply scope", target: models.PlayTarget{PosterID: testBetID, Hosts: []string{" Moco-BRT-1 ", "moco-brt-1", ""}, TargetPlays: 10, Period: "2024-10"},
+			check: func(t models.PlayTarget) bool {
+				return t.ScopeType == "hosts" && len(t.Hosts) == 1 && t.Hosts[0] == "moco-brt-1"
+			}},
+		{name: "region", target: models.PlayTarget{CampaignID: testCampaignID, ScopeType: "Region", ScopeValue: " BRT ", TargetPlays: 10, Period: "2024-10"},
+			check: func(t models.PlayTarget) bool { return t.ScopeType == "region" && t.ScopeValue == "brt" }},
+		{name: "default period", target: models.PlayTarget{CampaignID: testCampaignID, ScopeType: "city", ScopeValue: "moco", TargetPlays: 10},
+			check: func(t models.PlayTarget) bool { return len(t.Period) == len("2006-01") }},
+		{name: "both ids", target: models.PlayTarget{CampaignID: testCampaignID, PosterID: testBetID, ScopeType: "city", ScopeValue: "moco", TargetPlays: 10}, wantErr: "exactly one of campaign_id or poster_id"},
+		{name: "no id", target: models.PlayTarget{ScopeType: "city", ScopeValue: "moco", TargetPlays: 10}, wantErr: "exactly one of campaign_id or poster_id"},
+		{name: "no scope", target: models.PlayTarget{CampaignID: testCampaignID, TargetPlays: 10}, wantErr: "scope_type"},
+		{name: "empty hosts", target: models.PlayTarget{CampaignID: testCampaignID, ScopeType: "hosts", TargetPlays: 10}, wantErr: "hosts is required"},
+		{name: "city without value", target: models.PlayTarget{CampaignID: testCampaignID, ScopeType: "city", TargetPlays: 10}, wantErr: "scope_value is required"},
+		{name: "unknown scope", target: models.PlayTarget{CampaignID: testCampaignID, ScopeType: "venue", ScopeValue: "1", TargetPlays: 10}, wantErr: "unknown scope_type"},
+		{name: "zero plays", target: models.PlayTarget{CampaignID: testCampaignID, ScopeType: "city", ScopeValue: "moco"}, wantErr: "target_plays must be positive"},
+		{name: "bad period", target: models.PlayTarget{CampaignID: testCampaignID, ScopeType: "city", ScopeValue: "moco", TargetPlays: 10, Period: "Oct 2024"}, wantErr: "period must look like"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tgt := tc.target
+			err := ValidatePlayTarget(&tgt)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want one containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.check(tgt) {
+				t.Errorf("normalized to %+v", tgt)
+			}
+		})
+	}
+}
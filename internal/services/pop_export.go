@@ -0,0 +1,366 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"openai-agent-service/internal/models"
+)
+
+// ExtractStore persists POP extracts; implemented by store.PostgresStore.
+type ExtractStore interface {
+	CreateExtract(ctx context.Context, e models.Extract) (models.Extract, error)
+	GetExtract(ctx context.Context, id string) (models.Extract, error)
+	DeleteExpiredExtracts(ctx context.Context, now time.Time) (int64, error)
+}
+
+// ErrExtractExpired is returned for an extract link past its expiry.
+var ErrExtractExpired = errors.New("this extract link has expired; ask for the export again")
+
+const (
+	defaultExportInlineMaxRows = 1000
+	defaultExtractTTL          = 24 * time.Hour
+)
+
+var (
+	popExportVerbRe    = regexp.MustCompile(`(?i)\b(?:export|download|extract|dump)\b|\bcsv\b`)
+	popExportSubjectRe = regexp.MustCompile(`(?i)\b(?:pop|plays?|play\s+rows|proof[\s-]+of[\s-]+play)\b`)
+	// popExportStripRe removes the file and date wording so the rest of the
+	// message parses like an ordinary POP question.
+	popExportStripRe = regexp.MustCompile(`(?i)\s*\b(?:(?:as|to|in|into)\s+(?:an?\s+)?csv(?:\s+file)?|csv|today|yesterday|(?:this|last|previous|current|past)\s+(?:week|month|quarter)|(?:last|past)\s+\d{1,3}\s+days?|from\s+\S+\s+(?:to|until|through)\s+\S+)\b`)
+	exportLastDaysRe = regexp.MustCompile(`(?i)\b(?:last|past)\s+(\d{1,3})\s+days?\b`)
+)
+
+// isPopExportIntent matches "export all pop rows for poster X this month",
+// "download plays for kiosk <host> as csv" and the like.
+func isPopExportIntent(msg string) bool {
+	return popExportVerbRe.MatchString(msg) && popExportSubjectRe.MatchString(msg)
+}
+
+// exportWindow resolves the date range of an export: an explicit range,
+// today, yesterday, this or last week, the last N days, or a month or
+// quarter. ok is false when the message names none, and the export covers
+// every date the gateway has.
+func exportWindow(msg string, now time.Time, loc *time.Location) (from, to time.Time, label string, ok bool) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	msgLower := strings.ToLower(msg)
+	fromRFC, toRFC := extractDateRangeRFC3339(msgLower)
+	if fromRFC == "" && toRFC == "" {
+		fromRFC, toRFC = extractNaturalDateRangeRFC3339(msg)
+	}
+	f, errF := time.Parse(time.RFC3339, fromRFC)
+	t, errT := time.Parse(time.RFC3339, toRFC)
+	if errF == nil && errT == nil && t.After(f) {
+		return f, t, f.In(loc).Format("Jan 2") + "–" + t.Add(-time.Second).In(loc).Format("Jan 2"), true
+	}
+	local := now.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	monday := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	switch {
+	case strings.Contains(msgLower, "yesterday"):
+		return today.AddDate(0, 0, -1), today, "yesterday", true
+	case strings.Contains(msgLower, "today"):
+		return today, now, "today", true
+	case strings.Contains(msgLower, "this week"):
+		return monday, now, "this week", true
+	case strings.Contains(msgLower, "last week") || strings.Contains(msgLower, "previous week"):
+		return monday.AddDate(0, 0, -7), monday, "last week", true
+	}
+	if m := exportLastDaysRe.FindStringSubmatch(msgLower); m != nil {
+		if n, _ := strconv.Atoi(m[1]); n > 0 {
+			return today.AddDate(0, 0, -n), today, fmt.Sprintf("the last %d days", n), true
+		}
+	}
+	if f, t, ok := parseMonthRange(msgLower, now, loc); ok {
+		return f, t, monthRangeLabel(f, t), true
+	}
+	return time.Time{}, time.Time{}, "", false
+}
+
+func (c *ChatService) exportInlineMaxRows() int64 {
+	if c.ExportInlineMaxRows > 0 {
+		return c.ExportInlineMaxRows
+	}
+	return defaultExportInlineMaxRows
+}
+
+func (c *ChatService) extractTTL() time.Duration {
+	if c.ExtractTTL > 0 {
+		return c.ExtractTTL
+	}
+	return defaultExtractTTL
+}
+
+var popCSVHeader = []string{"pop_datetime", "poster_id", "poster_name", "poster_type", "host_name", "kiosk_name", "city", "region", "play_count", "kiosk_lat", "kiosk_long"}
+
+func popCSVRecord(it popItem) []string {
+	when := ""
+	if !it.PopDatetime.IsZero() {
+		when = it.PopDatetime.UTC().Format(time.RFC3339)
+	}
+	return []string{
+		when, it.PosterID, it.PosterName, it.PosterType, it.HostName, it.KioskName, it.City, it.Region,
+		strconv.FormatInt(it.PlayCount, 10),
+		strconv.FormatFloat(it.KioskLat, 'f', -1, 64),
+		strconv.FormatFloat(it.KioskLong, 'f', -1, 64),
+	}
+}
+
+// handlePopExport answers "export all pop rows for poster X this month".
+// The first /pop page tells how many rows match: up to ExportInlineMaxRows
+// are returned as data.csv, and larger exports get a link to GET
+// /extracts/{id}.csv, which streams the rows from the gateway when opened.
+func (c *ChatService) handlePopExport(ctx context.Context, ownerKey string, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	if !isPopExportIntent(req.Message) {
+		return models.ChatResponse{}, false, nil
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	if c.Gateway == nil {
+		return reply(models.ChatResponse{Answer: "Tool gateway is not configured."})
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	rest := strings.Join(strings.Fields(popExportStripRe.ReplaceAllString(req.Message, " ")), " ")
+	restLower := strings.ToLower(rest)
+	posterName := posterFromMessage(rest)
+	host := alertHostToken(rest)
+	if strings.Count(host, "-") < 2 {
+		host = ""
+	}
+	city := c.detectCityCode(ctx, restLower)
+	region := c.detectRegionCode(ctx, restLower)
+	st := c.getConversationState(conversationID)
+	if posterName == "" && host == "" && st != nil && (listItRe.MatchString(restLower) || strings.Contains(restLower, "same")) {
+		posterName = strings.TrimSpace(st.PosterName)
+		if posterName == "" {
+			host = strings.TrimSpace(st.Host)
+		}
+	}
+	loc := requestLocation(req)
+	from, to, window, hasWindow := exportWindow(req.Message, time.Now(), loc)
+	if posterName == "" && host == "" && city == "" && region == "" && !hasWindow {
+		return reply(models.ChatResponse{Answer: "Which POP rows should I export? Name a poster, kiosk, city or region, or a date range, e.g. \"export pop rows for poster Bet 365 this month\"."})
+	}
+	if conversationID != "" {
+		c.updateConversationLocation(conversationID, city, region)
+		if posterName != "" {
+			c.updateConversationPoster(conversationID, posterName, "", "")
+		} else if host != "" {
+			c.updateConversationHost(conversationID, host)
+		}
+	}
+
+	// scope completes "POP rows": " for poster 'Bet 365' in region 'brt'
+	// (October 2026)".
+	filters := make([]string, 0, 4)
+	scope := ""
+	switch {
+	case host != "":
+		filters = append(filters, "host_name="+urlEscape(host))
+		scope += " for kiosk " + host
+	case posterName != "":
+		key := "poster_name"
+		if looksLikeUUID(posterName) {
+			key = "poster_id"
+		}
+		filters = append(filters, key+"="+urlEscape(posterName))
+		scope += " for poster '" + posterName + "'"
+	}
+	if region != "" {
+		filters = append(filters, "region="+urlEscape(region))
+		scope += " in region '" + region + "'"
+	} else if city != "" {
+		filters = append(filters, "city="+urlEscape(city))
+		scope += " in city '" + city + "'"
+	}
+	if hasWindow {
+		filters = append(filters, "from="+urlEscape(from.UTC().Format(time.RFC3339)), "to="+urlEscape(to.UTC().Format(time.RFC3339)))
+		scope += " (" + window + ")"
+	} else {
+		scope += " (all dates)"
+	}
+	filter := strings.Join(filters, "&")
+
+	limit := c.exportInlineMaxRows()
+	pager := c.newPopPager()
+	var steps []models.Step
+	// An unknown total is taken as large: paging on to find out could read
+	// the whole extract just to decide where it goes.
+	rows, err := c.scanPop(ctx, popQuery{Filter: filter, More: func(p *popPager) bool {
+		return p.Total > 0 && p.Total <= limit
+	}}, pager, &steps)
+	if err != nil {
+		return reply(models.ChatResponse{Answer: "Failed to fetch POP data: " + err.Error(), Steps: steps})
+	}
+	if len(rows) == 0 {
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("No POP rows%s were found, so there is nothing to export.", scope), Steps: steps})
+	}
+	if pager.Complete && int64(len(rows)) <= limit {
+		var b strings.Builder
+		cw := csv.NewWriter(&b)
+		_ = cw.Write(popCSVHeader)
+		for _, it := range rows {
+			_ = cw.Write(popCSVRecord(it))
+		}
+		cw.Flush()
+		name := popExportFilename(posterName, host, from, to, hasWindow)
+		return reply(models.ChatResponse{
+			Answer: fmt.Sprintf("Exported %s POP rows%s. The CSV is attached as %s.", formatThousands(int64(len(rows))), scope, name),
+			Data:   &models.ChatData{CSV: &models.CSVAttachment{Filename: name, Rows: len(rows), Content: b.String()}},
+			Steps:  steps,
+		})
+	}
+
+	if c.Extracts == nil {
+		return reply(models.ChatResponse{Answer: fmt.Sprintf("That export has more than %s rows, which is too many to attach, and extract links are not configured. Narrow it to a shorter date range or a single kiosk.", formatThousands(limit)), Steps: steps})
+	}
+	ex, err := c.Extracts.CreateExtract(ctx, models.Extract{
+		ID:          uuid.NewString(),
+		OwnerKey:    ownerKey,
+		Subject:     "POP rows" + scope,
+		Filter:      filter,
+		RowEstimate: pager.Total,
+		ExpiresAt:   time.Now().Add(c.extractTTL()),
+	})
+	if err != nil {
+		return reply(models.ChatResponse{Answer: "Failed to create the extract: " + err.Error(), Steps: steps})
+	}
+	count := "About " + formatThousands(ex.RowEstimate)
+	if ex.RowEstimate <= 0 {
+		count = "More than " + formatThousands(limit)
+	}
+	answer := fmt.Sprintf("%s POP rows%s match, too many to attach. Download the CSV from /extracts/%s.csv with this API key; the link is valid until %s (%s). Rows are read from the gateway when the link is opened.",
+		count, scope, ex.ID, ex.ExpiresAt.In(loc).Format("Jan 2, 15:04 MST"), extractTTLLabel(c.extractTTL()))
+	return reply(models.ChatResponse{Answer: answer, Steps: steps})
+}
+
+// popExportFilename names an export after its poster or kiosk and dates,
+// e.g. "pop-bet-365-2026-10-01-2026-10-31.csv".
+func popExportFilename(posterName, host string, from, to time.Time, hasWindow bool) string {
+	parts := []string{"pop"}
+	if slug := strings.Trim(nonSlugRe.ReplaceAllString(strings.ToLower(firstNonEmpty(host, posterName)), "-"), "-"); slug != "" {
+		if len(slug) > 40 {
+			slug = strings.TrimRight(slug[:40], "-")
+		}
+		parts = append(parts, slug)
+	}
+	if hasWindow {
+		parts = append(parts, from.Format("2006-01-02"), to.Add(-time.Second).Format("2006-01-02"))
+	}
+	return strings.Join(parts, "-") + ".csv"
+}
+
+var nonSlugRe = regexp.MustCompile(`[^a-z0-9]+`)
+
+func extractTTLLabel(d time.Duration) string {
+	switch {
+	case d == time.Hour:
+		return "1 hour"
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	}
+	return d.Round(time.Minute).String()
+}
+
+// Extract returns the extract with id; sql.ErrNoRows when there is none.
+// Callers check the owner; expired extracts are returned with
+// ErrExtractExpired.
+func (c *ChatService) Extract(ctx context.Context, id string) (models.Extract, error) {
+	if c.Extracts == nil {
+		return models.Extract{}, sql.ErrNoRows
+	}
+	ex, err := c.Extracts.GetExtract(ctx, id)
+	if err != nil {
+		return ex, err
+	}
+	if !time.Now().Before(ex.ExpiresAt) {
+		return ex, ErrExtractExpired
+	}
+	return ex, nil
+}
+
+// StreamExtract writes the extract's rows to w as CSV, one /pop page at a
+// time, calling flush after each page, so memory stays at one page however
+// many rows there are. Nothing is written until the first page has been
+// read. It returns the number of rows written.
+func (c *ChatService) StreamExtract(ctx context.Context, ex models.Extract, w io.Writer, flush func()) (int64, error) {
+	if c.Gateway == nil {
+		return 0, errors.New("tool gateway is not configured")
+	}
+	pager := &popPager{PageSize: c.limits().PopPageSize, MaxPages: math.MaxInt}
+	cw := csv.NewWriter(w)
+	var (
+		n      int64
+		header bool
+		steps  []models.Step
+	)
+	_, err := c.scanPop(ctx, popQuery{Filter: ex.Filter, Visit: func(rows []popItem) error {
+		if !header {
+			_ = cw.Write(popCSVHeader)
+			header = true
+		}
+		for _, it := range rows {
+			_ = cw.Write(popCSVRecord(it))
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+		n += int64(len(rows))
+		if flush != nil {
+			flush()
+		}
+		return nil
+	}}, pager, &steps)
+	var pe *popPageError
+	if errors.As(err, &pe) {
+		return n, fmt.Errorf("page %d: %w", pe.Page, err)
+	}
+	return n, err
+}
+
+// ExtractJanitor deletes expired extracts on an interval.
+type ExtractJanitor struct {
+	Store    ExtractStore
+	Interval time.Duration
+}
+
+func (j *ExtractJanitor) Run(ctx context.Context) {
+	interval := j.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if n, err := j.Store.DeleteExpiredExtracts(ctx, time.Now()); err != nil {
+				log.Printf("extract janitor: %v", err)
+			} else if n > 0 {
+				log.Printf("extract janitor: deleted %d expired extract(s)", n)
+			}
+		}
+	}
+}
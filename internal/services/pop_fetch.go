@@ -0,0 +1,281 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// popItem is one row of the gateway's /pop listing. Handlers decode only the
+// fields they need; the rest stay zero.
+type popItem struct {
+	PosterName  string    `json:"poster_name"`
+	PosterID    string    `json:"poster_id"`
+	HostName    string    `json:"host_name"`
+	KioskName   string    `json:"kiosk_name"`
+	PosterType  string    `json:"poster_type"`
+	PopDatetime time.Time `json:"pop_datetime"`
+	KioskLat    float64   `json:"kiosk_lat"`
+	KioskLong   float64   `json:"kiosk_long"`
+	City        string    `json:"city"`
+	Region      string    `json:"region"`
+	PlayCount   int64     `json:"play_count"`
+	Value       int64     `json:"value"`
+	Type        string    `json:"type"`
+	Url         string    `json:"url"`
+
+	// step is the index of the Step that fetched the row, for citations.
+	step int
+}
+
+type popListResponse struct {
+	Items      []popItem         `json:"items"`
+	Total      int64             `json:"total"`
+	Page       int               `json:"page"`
+	PageSize   int               `json:"page_size"`
+	Pagination gatewayPagination `json:"pagination"`
+}
+
+// popPager tracks a /pop pagination loop so answers can say when totals were
+// built from a window capped at MaxPages or cut short by the handler deadline,
+// and how a range too long for one crawl was read (see pop_range.go).
+type popPager struct {
+	PageSize  int
+	MaxPages  int
+	Fetched   int64
+	Total     int64
+	Truncated bool
+	TimedOut  bool
+	// Strategy is popRangeStats, popRangeChunked or popRangeRefused when
+	// Range was longer than PopRawRangeDays; Chunks counts the chunks read.
+	Strategy string
+	Range    popRange
+	Chunks   int
+	// Complete is set by scanPop once the listing is exhausted, as opposed
+	// to stopped on the page cap, the deadline or popQuery.More.
+	Complete bool
+}
+
+func (c *ChatService) newPopPager() *popPager {
+	l := c.limits()
+	return &popPager{PageSize: l.PopPageSize, MaxPages: l.PopMaxPages}
+}
+
+func (p *popPager) record(n int, total int64) {
+	p.Fetched += int64(n)
+	if total > 0 {
+		p.Total = total
+	}
+}
+
+// done records a fetched page and reports whether the loop should stop,
+// flagging truncation when it stops on the page cap with rows remaining. The
+// page size the gateway echoes wins over the requested one, since a gateway
+// that caps page_size would otherwise look like it returned a short last page.
+func (p *popPager) done(page int, resp popListResponse) bool {
+	total := resp.Total
+	if total <= 0 {
+		total = resp.Pagination.Total
+	}
+	p.record(len(resp.Items), total)
+	pageSize := p.PageSize
+	if resp.PageSize > 0 {
+		pageSize = resp.PageSize
+	}
+	if pageDone(len(resp.Items), pageSize, p.Fetched, total, resp.Pagination.HasMore) {
+		return true
+	}
+	if page >= p.MaxPages {
+		p.Truncated = true
+		return true
+	}
+	return false
+}
+
+// timeout marks the listing as cut short by ctx's deadline. It reports false
+// when ctx is still live or was cancelled for another reason.
+func (p *popPager) timeout(ctx context.Context) bool {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return false
+	}
+	p.TimedOut = true
+	p.Truncated = true
+	return true
+}
+
+// note appends the range strategy and the truncation warning to an answer.
+func (p *popPager) note(answer string) string {
+	if p == nil {
+		return answer
+	}
+	if n := p.rangeNote(); n != "" {
+		answer += "\n\n" + n
+	}
+	if !p.Truncated {
+		return answer
+	}
+	if p.TimedOut {
+		return answer + fmt.Sprintf("\n\n%s: totals are based on the first %s POP rows fetched before the time limit.", partialResultsWarning, formatThousands(p.Fetched))
+	}
+	if p.Total > p.Fetched {
+		return answer + fmt.Sprintf("\n\nNote: totals are based on the first %s of %s POP rows (page limit reached).", formatThousands(p.Fetched), formatThousands(p.Total))
+	}
+	return answer + fmt.Sprintf("\n\nNote: totals are based on the first %s POP rows; more rows were available (page limit reached).", formatThousands(p.Fetched))
+}
+
+func (p *popPager) meta() *models.ResponseMeta {
+	if p == nil || (!p.Truncated && p.Strategy == "") {
+		return nil
+	}
+	return &models.ResponseMeta{Truncated: p.Truncated, TimedOut: p.TimedOut, FetchedRows: p.Fetched, TotalRows: p.Total, RangeStrategy: p.Strategy, RangeChunks: p.Chunks}
+}
+
+// popPageError is a /pop page that could not be read: a transport error
+// (Err), a non-2xx status (Status, with the response Body) or a body that is
+// not a POP listing.
+type popPageError struct {
+	Page   int
+	Status int
+	Body   []byte
+	Err    error
+}
+
+func (e *popPageError) Error() string {
+	switch {
+	case e.Err != nil:
+		return e.Err.Error()
+	case e.Status != 0:
+		return fmt.Sprintf("status %d", e.Status)
+	}
+	return "unparseable POP response"
+}
+
+func (e *popPageError) Unwrap() error { return e.Err }
+
+// fetchPopPage reads one page of /pop for filter. A page that cannot be read
+// is returned as a *popPageError alongside its step.
+func (c *ChatService) fetchPopPage(ctx context.Context, filter string, page, pageSize int) (popListResponse, models.Step, error) {
+	p := fmt.Sprintf("/pop?page=%d&page_size=%d", page, pageSize)
+	if filter != "" {
+		p = fmt.Sprintf("/pop?%s&page=%d&page_size=%d", filter, page, pageSize)
+	}
+	var resp popListResponse
+	status, body, err := c.Gateway.Get(ctx, p)
+	step := models.Step{Tool: "popList", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+		return resp, step, &popPageError{Page: page, Err: err}
+	}
+	step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	if status < 200 || status >= 300 {
+		return resp, step, &popPageError{Page: page, Status: status, Body: body}
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		step.Error = "unparseable POP response"
+		return resp, step, &popPageError{Page: page}
+	}
+	return resp, step, nil
+}
+
+// popQuery is one /pop listing for scanPop.
+type popQuery struct {
+	// Filter is the query without page parameters.
+	Filter string
+	// Fallback, when set, replaces Filter from the page a 400 is answered
+	// on, for deployments that reject one of its parameters.
+	Fallback string
+	// Visit, when set, receives each page's rows as it is read instead of
+	// scanPop collecting them. An error from Visit stops the listing.
+	Visit func(rows []popItem) error
+	// More, when set, is asked after each page that was not the last
+	// whether to read on.
+	More func(pager *popPager) bool
+}
+
+// scanPop pages through /pop for q, appending one step per request to
+// steps and tagging each row with its step. It stops on an empty page, when
+// the pager says the listing is done or capped, or when q.More declines.
+//
+// A page that cannot be read ends the listing with a *popPageError and the
+// rows read before it, so callers decide between a partial answer and a
+// failure. When ctx's deadline fires after the first page, the rows read so
+// far are returned without an error and the pager is marked TimedOut; with
+// Visit set the deadline is an error instead, since a visitor cannot tell a
+// short listing from a cut one.
+func (c *ChatService) scanPop(ctx context.Context, q popQuery, pager *popPager, steps *[]models.Step) ([]popItem, error) {
+	filter := q.Filter
+	var rows []popItem
+	for page := 1; ; page++ {
+		if page > 1 && q.Visit == nil && pager.timeout(ctx) {
+			return rows, nil
+		}
+		resp, step, err := c.fetchPopPage(ctx, filter, page, pager.PageSize)
+		if q.Fallback != "" && filter != q.Fallback && step.Status == http.StatusBadRequest {
+			*steps = append(*steps, step)
+			filter = q.Fallback
+			resp, step, err = c.fetchPopPage(ctx, filter, page, pager.PageSize)
+		}
+		*steps = append(*steps, step)
+		if err != nil {
+			var pe *popPageError
+			if page > 1 && q.Visit == nil && errors.As(err, &pe) && pe.Err != nil && pager.timeout(ctx) {
+				return rows, nil
+			}
+			return rows, err
+		}
+		tagRows(resp.Items, len(*steps)-1)
+		if q.Visit != nil {
+			if err := q.Visit(resp.Items); err != nil {
+				return rows, err
+			}
+		} else {
+			rows = append(rows, resp.Items...)
+		}
+		if len(resp.Items) == 0 || pager.done(page, resp) {
+			pager.Complete = !pager.Truncated
+			return rows, nil
+		}
+		if q.More != nil && !q.More(pager) {
+			return rows, nil
+		}
+	}
+}
+
+// popScopeFilter is the /pop scope parameter for region, or for city when
+// region is empty, with its leading "&"; "" when both are empty.
+func popScopeFilter(city, region string) string {
+	if strings.TrimSpace(region) != "" {
+		return "&region=" + urlEscape(region)
+	}
+	if strings.TrimSpace(city) != "" {
+		return "&city=" + urlEscape(city)
+	}
+	return ""
+}
+
+// popFailureAnswer is the answer for a /pop listing that failed with err.
+func popFailureAnswer(err error) string {
+	var pe *popPageError
+	if errors.As(err, &pe) && pe.Err == nil {
+		if pe.Status != 0 {
+			return fmt.Sprintf("Failed to fetch POP data (status %d).", pe.Status)
+		}
+		return "POP list response could not be parsed."
+	}
+	return "Failed to fetch POP data: " + err.Error()
+}
+
+// popFailedPage is the page number a scanPop error names, or 0.
+func popFailedPage(err error) int {
+	var pe *popPageError
+	if errors.As(err, &pe) {
+		return pe.Page
+	}
+	return 0
+}
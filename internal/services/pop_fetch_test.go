@@ -0,0 +1,278 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+// answerBody is a chat answer without its "Interpreted request" header.
+func answerBody(answer string) string {
+	if strings.HasPrefix(answer, "Interpreted request:") {
+		if i := strings.Index(answer, "\n"); i >= 0 {
+			return answer[i+1:]
+		}
+	}
+	return answer
+}
+
+// Every /pop handler reads its pages through scanPop. The cases pin which
+// handler answers each question, what it answers, and how many /pop pages
+// and steps it took, including gateway quirks: 400 fallbacks, failed later
+// pages and missing totals.
+func TestPopHandlerParity(t *testing.T) {
+	const (
+		fresh = "\n\nData as of 2024-10-15 11:00 UTC (pop)."
+		// Answers that looked a kiosk up also report the devices source.
+		freshDevices = "\n\nData as of 2024-10-15 11:00 UTC (pop); freshness unknown (devices)."
+	)
+	status := func(page, code int) map[int]int { return map[int]int{page: code} }
+	reject := func(params ...string) map[string]bool {
+		m := map[string]bool{}
+		for _, p := range params {
+			m[p] = true
+		}
+		return m
+	}
+	cases := []struct {
+		name      string
+		msg       string
+		gw        *fakeGateway
+		noDevices bool
+		handler   string
+		answer    string // exact, without the header
+		contains  string // when the answer depends on today's date
+		popCalls  int
+		steps     int
+	}{
+		{
+			name:    "analytics by id",
+			msg:     "poster analytics for " + testBetID,
+			handler: "handlePosterAnalyticsByID",
+			answer: "Analytics for poster Bet 365 (" + testBetID + "): 605 plays\nKiosks matched: 3\nTop kiosks:\n" +
+				"1. Union Station — 300 plays\n2. Briggs Lobby — 260 plays\n3. Briggs Annex — 45 plays" + fresh,
+			popCalls: 3, steps: 3,
+		},
+		{
+			name:    "analytics by id in region",
+			msg:     "poster analytics for " + testBetID + " in brt",
+			handler: "handlePosterAnalyticsByID",
+			answer: "Analytics for poster Bet 365 (" + testBetID + ") in region 'brt': 305 plays\nKiosks matched: 2\nTop kiosks:\n" +
+				"1. Briggs Lobby — 260 plays\n2. Briggs Annex — 45 plays" + fresh,
+			popCalls: 2, steps: 2,
+		},
+		{
+			name:    "analytics keeps earlier pages when a later one fails",
+			msg:     "poster analytics for " + testBetID,
+			gw:      &fakeGateway{PopStatus: status(2, 502)},
+			handler: "handlePosterAnalyticsByID",
+			answer: "Analytics for poster Bet 365 (" + testBetID + "): 200 plays\nKiosks matched: 1\nTop kiosks:\n1. Briggs Lobby — 200 plays\n\n" +
+				"Could not fetch POP page 2 (status 502); totals cover the first 2 rows.\n\nData as of 2024-10-02 14:00 UTC (pop).",
+			popCalls: 2, steps: 2,
+		},
+		{
+			name:     "analytics first page fails",
+			msg:      "poster analytics for " + testBetID,
+			gw:       &fakeGateway{PopStatus: status(1, 502)},
+			handler:  "handlePosterAnalyticsByID",
+			answer:   "Failed to fetch POP data (status 502).",
+			popCalls: 1, steps: 1,
+		},
+		{
+			name:     "pop by poster id",
+			msg:      "pop for poster " + testBetID,
+			handler:  "handlePopForPosterID",
+			answer:   "POP for poster Bet 365 (" + testBetID + "): 605 plays." + fresh,
+			popCalls: 3, steps: 3,
+		},
+		{
+			name:    "pop by poster id keeps earlier pages when a later one fails",
+			msg:     "pop for poster " + testBetID,
+			gw:      &fakeGateway{PopStatus: status(3, 500)},
+			handler: "handlePopForPosterID",
+			answer: "POP for poster Bet 365 (" + testBetID + "): 545 plays.\n\n" +
+				"Could not fetch POP page 3 (status 500); totals cover the first 4 rows.\n\nData as of 2024-10-03 08:00 UTC (pop).",
+			popCalls: 3, steps: 3,
+		},
+		{
+			name:    "poster id kiosk wise in city",
+			msg:     "pop for poster " + testBetID + " kiosk wise in moco",
+			handler: "handlePosterPlayCount",
+			answer: "Play count for poster '" + testBetID + "' in city 'moco': 305 plays\nKiosk-wise:\n" +
+				"1. Briggs Lobby — 260 plays\n2. Briggs Annex — 45 plays" + fresh,
+			popCalls: 2, steps: 2,
+		},
+		{
+			name:     "kiosk resolved to host",
+			msg:      "Briggs Lobby has played Bet 365 poster on kiosk",
+			handler:  "handleKioskPosterPlayCount",
+			answer:   "Kiosk 'Briggs Lobby' (moco-brt-briggs-001) has played poster 'Bet 365': 260 plays." + freshDevices,
+			popCalls: 2, steps: 3,
+		},
+		{
+			name:     "kiosk host filter falls back to host",
+			msg:      "Briggs Lobby has played Bet 365 poster on kiosk",
+			gw:       &fakeGateway{Reject: reject("host_name")},
+			handler:  "handleKioskPosterPlayCount",
+			answer:   "Kiosk 'Briggs Lobby' (moco-brt-briggs-001) has played poster 'Bet 365': 260 plays." + freshDevices,
+			popCalls: 3, steps: 4,
+		},
+		{
+			name:     "kiosk host page fails",
+			msg:      "Briggs Lobby has played Bet 365 poster on kiosk",
+			gw:       &fakeGateway{PopStatus: status(1, 502)},
+			handler:  "handleKioskPosterPlayCount",
+			answer:   "Failed to fetch POP data (status 502).\n\nData freshness unknown (devices).",
+			popCalls: 1, steps: 2,
+		},
+		{
+			name:      "unresolved kiosk filtered server side",
+			msg:       "Briggs Lobby has played Bet 365 poster on kiosk",
+			noDevices: true,
+			handler:   "handleKioskPosterPlayCount",
+			answer:    "Kiosk 'Briggs Lobby' has played poster 'Bet 365': 200 plays.\n\nData as of 2024-10-02 14:00 UTC (pop); freshness unknown (devices).",
+			popCalls:  1, steps: 2,
+		},
+		{
+			name:      "unresolved kiosk tallied from poster rows",
+			msg:       "Briggs has played Bet 365 poster on kiosk",
+			gw:        &fakeGateway{Reject: reject("kiosk_name")},
+			noDevices: true,
+			handler:   "handleKioskPosterPlayCount",
+			answer: "'Briggs' matches 2 kiosks. Plays of poster 'Bet 365' per kiosk:\n- 'Briggs Lobby': 260 plays\n- 'Briggs Annex': 45 plays\n" +
+				"Together they played it 305 times. If you meant a single screen, tell me which kiosk." + freshDevices,
+			popCalls: 5, steps: 6,
+		},
+		{
+			name:     "poster month data",
+			msg:      "pop for poster Bet 365 for October 2024 in brt",
+			handler:  "handlePosterMonthData",
+			answer:   "POP for poster 'Bet 365' for October 2024: 305 plays." + fresh,
+			popCalls: 2, steps: 2,
+		},
+		{
+			name:     "poster month data without totals",
+			msg:      "pop for poster Bet 365 for October 2024 in brt",
+			gw:       &fakeGateway{PopQuirk: func(_ int, r map[string]any) { r["total"] = 0 }},
+			handler:  "handlePosterMonthData",
+			answer:   "POP for poster 'Bet 365' for October 2024: 305 plays." + fresh,
+			popCalls: 3, steps: 3,
+		},
+		{
+			name:    "poster month data kiosk wise",
+			msg:     "pop for poster Lorla Studio for October 2024 in moco kiosk wise",
+			handler: "handlePosterMonthData",
+			answer: "POP for poster 'Lorla Studio' for October 2024: 55 plays\nKiosk-wise:\n1. Briggs Annex — 35 plays\n2. Briggs Lobby — 20 plays" +
+				"\n\nData as of 2024-10-09 16:00 UTC (pop).",
+			popCalls: 1, steps: 1,
+		},
+		{
+			name:     "poster play count",
+			msg:      "play count for poster Bet 365 in brt",
+			handler:  "handlePosterPlayCount",
+			answer:   "Play count for poster 'Bet 365' in region 'brt': 305 plays." + fresh,
+			popCalls: 2, steps: 2,
+		},
+		{
+			name:     "poster play count in a date range",
+			msg:      "play count for poster Bet 365 in brt from October 1 2024 to October 10 2024",
+			handler:  "handlePosterPlayCount",
+			answer:   "Play count for poster 'Bet 365' in region 'brt': 245 plays.\n\nData as of 2024-10-02 18:00 UTC (pop).",
+			popCalls: 2, steps: 2,
+		},
+		{
+			name:     "poster play count without date filtering",
+			msg:      "play count for poster Bet 365 in brt from October 1 2024 to October 10 2024",
+			gw:       &fakeGateway{Reject: reject("from")},
+			handler:  "handlePosterPlayCount",
+			answer:   "Play count for poster 'Bet 365' in region 'brt': 305 plays." + fresh,
+			popCalls: 3, steps: 3,
+		},
+		{
+			name:     "poster play count page fails",
+			msg:      "play count for poster Bet 365 in brt",
+			gw:       &fakeGateway{PopStatus: status(2, 500)},
+			handler:  "handlePosterPlayCount",
+			answer:   "Failed to fetch POP data (status 500).\n\nData as of 2024-10-02 14:00 UTC (pop).",
+			popCalls: 2, steps: 2,
+		},
+		{
+			name:    "host pop for a date",
+			msg:     "pop for moco-brt-briggs-001 on October 2 2024",
+			handler: "handlePopByHostForDate",
+			answer: "POP for 'moco-brt-briggs-001' (Briggs Lobby) — Wed Oct 2, 2024:\n1. Bet 365 — 80 plays (image)\n" +
+				"Location: 0.000000, 0.000000 | Last update: 2024-10-02T14:00:00Z\n\nData as of 2024-10-02 14:00 UTC (pop).",
+			popCalls: 1, steps: 1,
+		},
+		{
+			name:     "host pop for a date without date filtering",
+			msg:      "pop for moco-brt-briggs-001 on October 2 2024",
+			gw:       &fakeGateway{Reject: reject("from")},
+			handler:  "handlePopByHostForDate",
+			answer:   "This gateway's POP endpoint does not accept from/to dates, so only today and yesterday can be shown.",
+			popCalls: 1, steps: 1,
+		},
+		{
+			name:     "host pop today falls back to presets",
+			msg:      "pop for moco-brt-briggs-001 today",
+			gw:       &fakeGateway{Reject: reject("from")},
+			handler:  "handlePopByHostForDate",
+			contains: "1. Bet 365 — 260 plays (image)\n2. Lorla Studio — 20 plays (image)\n",
+			popCalls: 2, steps: 2,
+		},
+		{
+			name:     "export attaches a small listing",
+			msg:      "export all pop rows for poster Bet 365 in brt",
+			handler:  "handlePopExport",
+			answer:   "Exported 4 POP rows for poster 'Bet 365' in region 'brt' (all dates). The CSV is attached as pop-bet-365.csv." + fresh,
+			popCalls: 2, steps: 2,
+		},
+		{
+			name:     "export page fails",
+			msg:      "export all pop rows for poster Bet 365 in brt",
+			gw:       &fakeGateway{PopStatus: status(2, 500)},
+			handler:  "handlePopExport",
+			answer:   "Failed to fetch POP data: status 500\n\nData as of 2024-10-02 14:00 UTC (pop).",
+			popCalls: 2, steps: 2,
+		},
+		{
+			name:     "export with an unknown total stops after one page",
+			msg:      "export all pop rows for poster Bet 365",
+			gw:       &fakeGateway{PopQuirk: func(_ int, r map[string]any) { delete(r, "total") }},
+			handler:  "handlePopExport",
+			answer:   "That export has more than 1,000 rows, which is too many to attach, and extract links are not configured. Narrow it to a shorter date range or a single kiosk.\n\nData as of 2024-10-02 14:00 UTC (pop).",
+			popCalls: 1, steps: 1,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := tc.gw
+			if g == nil {
+				g = &fakeGateway{}
+			}
+			g.Pop = testPop()
+			if !tc.noDevices {
+				g.Devices = testDevices
+			}
+			newFakeGateway(t, g)
+			c := newTestChat(g)
+			resp := chatOnce(t, c, tc.msg)
+			if got := answeredBy(c); got != tc.handler {
+				t.Fatalf("answered by %q, want %q", got, tc.handler)
+			}
+			body := answerBody(resp.Answer)
+			if tc.contains != "" {
+				if !strings.Contains(body, tc.contains) {
+					t.Fatalf("answer\n%s\nlacks\n%s", body, tc.contains)
+				}
+			} else if body != tc.answer {
+				t.Fatalf("answer\n got %q\nwant %q", body, tc.answer)
+			}
+			if n := len(g.Calls("/pop?")); n != tc.popCalls {
+				t.Fatalf("%d /pop calls, want %d: %v", n, tc.popCalls, g.Calls("/pop?"))
+			}
+			if len(resp.Steps) != tc.steps {
+				t.Fatalf("%d steps, want %d", len(resp.Steps), tc.steps)
+			}
+		})
+	}
+}
@@ -0,0 +1,2491 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+func (c *ChatService) handlePosterAnalyticsByID(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !strings.Contains(msgLower, "analytics") {
+		return models.ChatResponse{}, false, nil
+	}
+	if !strings.Contains(msgLower, "poster") {
+		return models.ChatResponse{}, false, nil
+	}
+	posterID := extractCampaignID(req.Message)
+	if !looksLikeUUID(posterID) {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	scope := c.resolvePosterScope(ctx, conversationID, msgLower)
+	city, region := scope.City.Value, scope.Region.Value
+
+	pager := c.newPopPager()
+	steps := make([]models.Step, 0, 2)
+	var failures partialFailures
+	items, err := c.scanPop(ctx, popQuery{Filter: "poster_id=" + urlEscape(posterID) + popScopeFilter(city, region)}, pager, &steps)
+	if err != nil {
+		if len(items) == 0 {
+			return models.ChatResponse{Answer: popFailureAnswer(err), Steps: steps}, true, nil
+		}
+		// A page failing after earlier ones succeeded still answers from
+		// the rows fetched so far.
+		failures.add(fmt.Sprintf("POP page %d", popFailedPage(err)), steps, len(steps)-1, fmt.Sprintf("totals cover the first %s rows", formatThousands(int64(len(items)))))
+	}
+	if len(items) == 0 {
+		return models.ChatResponse{Answer: fmt.Sprintf("No POP rows found for poster %s.", posterID), Steps: steps}, true, nil
+	}
+
+	posterName := strings.TrimSpace(items[0].PosterName)
+	label := posterID
+	if posterName != "" {
+		label = posterName + " (" + posterID + ")"
+	}
+	scopeLabel := ""
+	if strings.TrimSpace(region) != "" {
+		scopeLabel = "region '" + strings.TrimSpace(region) + "'"
+	} else if strings.TrimSpace(city) != "" {
+		scopeLabel = "city '" + strings.TrimSpace(city) + "'"
+	}
+
+	totalPlays := int64(0)
+	byKiosk := map[string]int64{}
+	kioskSteps := map[string][]int{}
+	geo := kioskGeo{}
+	for _, it := range items {
+		totalPlays += it.PlayCount
+		k := strings.TrimSpace(it.KioskName)
+		if k == "" {
+			k = strings.TrimSpace(it.HostName)
+		}
+		if k == "" {
+			continue
+		}
+		byKiosk[k] += it.PlayCount
+		kioskSteps[k] = appendStep(kioskSteps[k], it.step)
+		geo.note(k, it.KioskName, it.HostName, it.KioskLat, it.KioskLong)
+	}
+
+	type kv struct {
+		Key   string
+		Plays int64
+	}
+	rows := make([]kv, 0, len(byKiosk))
+	for k, v := range byKiosk {
+		rows = append(rows, kv{Key: k, Plays: v})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Plays > rows[j].Plays })
+	listed := c.capList(msgLower, len(rows), "kiosks")
+	rows = rows[:listed.Shown]
+	lines := make([]string, 0, len(rows)+3)
+	if scopeLabel != "" {
+		lines = append(lines, fmt.Sprintf("Analytics for poster %s in %s: %s plays", label, scopeLabel, formatThousands(totalPlays)))
+	} else {
+		lines = append(lines, fmt.Sprintf("Analytics for poster %s: %s plays", label, formatThousands(totalPlays)))
+	}
+	sources := rowSteps(items)
+	citations := cite(nil, formatThousands(totalPlays)+" plays", 0, sources...)
+	lines = append(lines, fmt.Sprintf("Kiosks matched: %d", len(byKiosk)))
+	citations = cite(citations, fmt.Sprintf("Kiosks matched: %d", len(byKiosk)), len(lines)-1, sources...)
+	lines = append(lines, "Top kiosks:")
+	if wantsTables(req) {
+		t := newTextTable("#", "Kiosk", "Plays").alignRight(0, 2)
+		for i, r := range rows {
+			t.add(fmt.Sprintf("%d", i+1), r.Key, formatThousands(r.Plays))
+		}
+		base := len(lines) + 2
+		lines = append(lines, t.lines()...)
+		for i, r := range rows {
+			citations = cite(citations, formatThousands(r.Plays), base+i, kioskSteps[r.Key]...)
+		}
+	} else {
+		for i, r := range rows {
+			lines = append(lines, fmt.Sprintf("%d. %s — %s plays", i+1, r.Key, formatThousands(r.Plays)))
+			citations = cite(citations, formatThousands(r.Plays)+" plays", len(lines)-1, kioskSteps[r.Key]...)
+		}
+	}
+	if note := listed.note(); note != "" {
+		lines = append(lines, note)
+	}
+	answer := strings.Join(lines, "\n")
+	answer = failures.note(pager.note(answer))
+	if onToken != nil {
+		onToken(answer)
+	}
+	if conversationID != "" {
+		c.updateConversationPoster(conversationID, posterName, city, region)
+		c.updateConversationPosterID(conversationID, posterID)
+		c.clearPending(conversationID)
+	}
+	resp := models.ChatResponse{Answer: answer, Steps: steps, Citations: citations}
+	if fc := geo.collection("plays", byKiosk); fc != nil {
+		resp.Data = &models.ChatData{Geo: fc}
+	}
+	resp.Meta = failures.meta(pager.meta())
+	return resp, true, nil
+}
+
+// kioskTally sums plays per distinct kiosk_name for a fuzzy kiosk phrase, so a
+// generic phrase ("station") that matches several kiosks is reported per
+// kiosk instead of as one merged number.
+type kioskTally struct {
+	phrase string
+	order  []string
+	names  map[string]string
+	plays  map[string]int64
+}
+
+func newKioskTally(phrase string) *kioskTally {
+	return &kioskTally{phrase: foldText(phrase), names: map[string]string{}, plays: map[string]int64{}}
+}
+
+// add counts row when its kiosk name matches the phrase either way round.
+func (t *kioskTally) add(kioskName string, plays int64) bool {
+	kn := foldText(kioskName)
+	if kn == "" || !(kn == t.phrase || strings.Contains(kn, t.phrase) || strings.Contains(t.phrase, kn)) {
+		return false
+	}
+	if _, ok := t.names[kn]; !ok {
+		t.order = append(t.order, kn)
+		t.names[kn] = strings.TrimSpace(kioskName)
+	}
+	t.plays[kn] += plays
+	return true
+}
+
+func (t *kioskTally) total() int64 {
+	n := int64(0)
+	for _, v := range t.plays {
+		n += v
+	}
+	return n
+}
+
+// answer names the matched kiosk, or lists each matched kiosk with its own
+// count and asks which one was meant.
+func (t *kioskTally) answer(phrase, scope, posterName string) string {
+	if len(t.order) == 1 {
+		kn := t.order[0]
+		matched := ""
+		if kn != t.phrase {
+			matched = fmt.Sprintf(" (matched from '%s')", phrase)
+		}
+		return fmt.Sprintf("Kiosk '%s'%s%s has played poster '%s': %s plays.", t.names[kn], matched, scope, posterName, formatThousands(t.plays[kn]))
+	}
+	keys := append([]string(nil), t.order...)
+	sort.SliceStable(keys, func(i, j int) bool { return t.plays[keys[i]] > t.plays[keys[j]] })
+	lines := []string{fmt.Sprintf("'%s' matches %d kiosks%s. Plays of poster '%s' per kiosk:", phrase, len(keys), scope, posterName)}
+	for _, kn := range keys {
+		lines = append(lines, fmt.Sprintf("- '%s': %s plays", t.names[kn], formatThousands(t.plays[kn])))
+	}
+	lines = append(lines, fmt.Sprintf("Together they played it %s times. If you meant a single screen, tell me which kiosk.", formatThousands(t.total())))
+	return strings.Join(lines, "\n")
+}
+
+func (c *ChatService) handleKioskPosterPlayCount(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !(strings.Contains(msgLower, "played") || strings.Contains(msgLower, "play")) {
+		return models.ChatResponse{}, false, nil
+	}
+	if !strings.Contains(msgLower, "poster") {
+		return models.ChatResponse{}, false, nil
+	}
+	if !(strings.Contains(msgLower, "kiosk") || strings.Contains(msgLower, "device")) {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	low := strings.ToLower(req.Message)
+	playedIdx := strings.Index(low, " has played ")
+	if playedIdx < 0 {
+		playedIdx = strings.Index(low, " played ")
+	}
+	if playedIdx < 0 {
+		return models.ChatResponse{}, false, nil
+	}
+	kioskName := strings.TrimSpace(req.Message[:playedIdx])
+	// Strip common leading question prefixes so we don't feed "How much ..." into device search.
+	{
+		knLower := strings.ToLower(strings.TrimSpace(kioskName))
+		prefixes := []string{"how much ", "how many ", "what is ", "what's ", "whats ", "tell me ", "show me ", "give me "}
+		for _, p := range prefixes {
+			if strings.HasPrefix(knLower, p) {
+				kioskName = strings.TrimSpace(kioskName[len(p):])
+				knLower = strings.ToLower(strings.TrimSpace(kioskName))
+				break
+			}
+		}
+	}
+	if kioskName == "" {
+		return models.ChatResponse{}, false, nil
+	}
+
+	rest := strings.TrimSpace(req.Message[playedIdx:])
+	restLow := strings.ToLower(rest)
+	hasPlayedPrefix := " has played "
+	if j := strings.Index(restLow, hasPlayedPrefix); j >= 0 {
+		rest = strings.TrimSpace(rest[j+len(hasPlayedPrefix):])
+	} else if j := strings.Index(restLow, " played "); j >= 0 {
+		rest = strings.TrimSpace(rest[j+len(" played "):])
+	}
+	posterEndIdx := strings.Index(strings.ToLower(rest), " poster")
+	posterName := rest
+	if posterEndIdx >= 0 {
+		posterName = strings.TrimSpace(rest[:posterEndIdx])
+	}
+	posterName = strings.TrimSpace(strings.Trim(posterName, "\"' "))
+	if posterName == "" {
+		if conversationID != "" {
+			if st := c.getConversationState(conversationID); st != nil {
+				if strings.TrimSpace(st.PosterName) != "" {
+					posterName = strings.TrimSpace(st.PosterName)
+				}
+			}
+		}
+	}
+	if posterName == "" {
+		return models.ChatResponse{Answer: "Please specify a poster name."}, true, nil
+	}
+
+	resolvedHost, resolveStep := c.resolveHostFromDeviceName(ctx, conversationID, kioskName)
+	resolvedHost = strings.ToLower(strings.TrimSpace(resolvedHost))
+	if resolvedHost == "" {
+		// Fallback: try direct POP filtering by kiosk name first; then query by poster_name + scope and filter by kiosk_name.
+		steps := make([]models.Step, 0, 2)
+		if resolveStep != nil {
+			steps = append(steps, *resolveStep)
+		}
+		city := ""
+		region := ""
+		if conversationID != "" {
+			if st := c.getConversationState(conversationID); st != nil {
+				if strings.TrimSpace(st.PosterRegion) != "" {
+					region = strings.ToLower(strings.TrimSpace(st.PosterRegion))
+				}
+				if strings.TrimSpace(st.PosterCity) != "" {
+					city = strings.ToLower(strings.TrimSpace(st.PosterCity))
+				}
+				if region == "" && strings.TrimSpace(st.Region) != "" {
+					region = strings.ToLower(strings.TrimSpace(st.Region))
+				}
+				if city == "" && strings.TrimSpace(st.City) != "" {
+					city = strings.ToLower(strings.TrimSpace(st.City))
+				}
+			}
+		}
+
+		pager := c.newPopPager()
+		tally := newKioskTally(kioskName)
+		posterIDFound := ""
+		scopeFilter := popScopeFilter(city, region)
+
+		// Attempt server-side filtering when supported: one page, by
+		// kiosk_name or, where that is rejected, kiosk.
+		probe := &popPager{PageSize: pager.PageSize, MaxPages: 1}
+		byKiosk := "poster_name=" + urlEscape(posterName) + "&kiosk_name=" + urlEscape(kioskName) + scopeFilter
+		byKioskAlt := "poster_name=" + urlEscape(posterName) + "&kiosk=" + urlEscape(kioskName) + scopeFilter
+		if rows, err := c.scanPop(ctx, popQuery{Filter: byKiosk, Fallback: byKioskAlt}, probe, &steps); err == nil && len(rows) > 0 {
+			filtered := newKioskTally(kioskName)
+			for _, it := range rows {
+				// Still double-check kiosk match just in case the server-side filter is fuzzy.
+				filtered.add(it.KioskName, it.PlayCount)
+			}
+			if filtered.total() > 0 {
+				scope := ""
+				if region != "" {
+					scope = " in region '" + region + "'"
+				} else if city != "" {
+					scope = " in city '" + city + "'"
+				}
+				answer := filtered.answer(kioskName, scope, posterName)
+				if onToken != nil {
+					onToken(answer)
+				}
+				return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+			}
+		}
+		// A page that fails with a status or an unreadable body ends the
+		// tally with the rows read so far.
+		rows, err := c.scanPop(ctx, popQuery{Filter: "poster_name=" + urlEscape(posterName) + scopeFilter}, pager, &steps)
+		var pe *popPageError
+		if err != nil && (!errors.As(err, &pe) || pe.Err != nil) {
+			return models.ChatResponse{Answer: popFailureAnswer(err), Steps: steps}, true, nil
+		}
+		for _, it := range rows {
+			if tally.add(it.KioskName, it.PlayCount) && posterIDFound == "" && looksLikeUUID(it.PosterID) {
+				posterIDFound = it.PosterID
+			}
+		}
+		if len(tally.order) == 0 {
+			return models.ChatResponse{Answer: "I couldn't resolve that kiosk name to a host, and I couldn't find matching POP rows by kiosk name. Please provide the server/host name.", Steps: steps}, true, nil
+		}
+		scope := ""
+		if region != "" {
+			scope = " in region '" + region + "'"
+		} else if city != "" {
+			scope = " in city '" + city + "'"
+		}
+		answer := tally.answer(kioskName, scope, posterName)
+		answer = pager.note(answer)
+		if onToken != nil {
+			onToken(answer)
+		}
+		if conversationID != "" {
+			c.updateConversationPoster(conversationID, posterName, city, region)
+			c.updateConversationPosterID(conversationID, posterIDFound)
+			c.clearPending(conversationID)
+		}
+		return models.ChatResponse{Answer: answer, Steps: steps, Meta: pager.meta()}, true, nil
+	}
+
+	pager := c.newPopPager()
+	steps := make([]models.Step, 0, 2)
+	if resolveStep != nil {
+		steps = append(steps, *resolveStep)
+	}
+	items, err := c.scanPop(ctx, popQuery{
+		Filter:   "poster_name=" + urlEscape(posterName) + "&host_name=" + urlEscape(resolvedHost),
+		Fallback: "poster_name=" + urlEscape(posterName) + "&host=" + urlEscape(resolvedHost),
+	}, pager, &steps)
+	if err != nil {
+		return models.ChatResponse{Answer: popFailureAnswer(err), Steps: steps}, true, nil
+	}
+
+	if len(items) == 0 {
+		answer := fmt.Sprintf("No play counts found for poster '%s' on kiosk '%s'.", posterName, kioskName)
+		if onToken != nil {
+			onToken(answer)
+		}
+		return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+	}
+
+	totalPlays := int64(0)
+	for _, it := range items {
+		totalPlays += it.PlayCount
+	}
+	answer := fmt.Sprintf("Kiosk '%s' (%s) has played poster '%s': %s plays.", kioskName, resolvedHost, posterName, formatThousands(totalPlays))
+	answer = pager.note(answer)
+	if onToken != nil {
+		onToken(answer)
+	}
+	if conversationID != "" {
+		c.updateConversationPoster(conversationID, posterName, "", "")
+		c.updateConversationHost(conversationID, resolvedHost)
+		c.clearPending(conversationID)
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps, Meta: pager.meta()}, true, nil
+}
+
+func (c *ChatService) handlePopForPosterID(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !strings.Contains(msgLower, "pop") {
+		return models.ChatResponse{}, false, nil
+	}
+	if !strings.Contains(msgLower, "poster") {
+		return models.ChatResponse{}, false, nil
+	}
+	posterID := extractCampaignID(req.Message)
+	if !looksLikeUUID(posterID) {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	isKioskWise := strings.Contains(msgLower, "kiosk wise") || strings.Contains(msgLower, "kiosk-wise") || strings.Contains(msgLower, "kioskwise") || strings.Contains(msgLower, "by kiosk")
+
+	scope := c.resolvePosterScope(ctx, conversationID, msgLower)
+	city, region := scope.City.Value, scope.Region.Value
+
+	// Pull POP rows filtered by poster_id + optional scope.
+	pager := c.newPopPager()
+	steps := make([]models.Step, 0, 2)
+	var failures partialFailures
+	items, err := c.scanPop(ctx, popQuery{Filter: "poster_id=" + urlEscape(posterID) + popScopeFilter(city, region)}, pager, &steps)
+	if err != nil {
+		if len(items) == 0 {
+			return models.ChatResponse{Answer: popFailureAnswer(err), Steps: steps}, true, nil
+		}
+		// A page failing after earlier ones succeeded still answers from
+		// the rows fetched so far.
+		failures.add(fmt.Sprintf("POP page %d", popFailedPage(err)), steps, len(steps)-1, fmt.Sprintf("totals cover the first %s rows", formatThousands(int64(len(items)))))
+	}
+
+	if len(items) == 0 {
+		answer := fmt.Sprintf("No POP rows found for poster %s.", posterID)
+		if onToken != nil {
+			onToken(answer)
+		}
+		return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+	}
+
+	totalPlays := int64(0)
+	for _, it := range items {
+		totalPlays += it.PlayCount
+	}
+
+	posterName := strings.TrimSpace(items[0].PosterName)
+	label := posterID
+	if posterName != "" {
+		label = posterName + " (" + posterID + ")"
+	}
+	scopeLabel := ""
+	if strings.TrimSpace(region) != "" {
+		scopeLabel = "region '" + strings.TrimSpace(region) + "'"
+	} else if strings.TrimSpace(city) != "" {
+		scopeLabel = "city '" + strings.TrimSpace(city) + "'"
+	}
+	if scopeLabel != "" {
+		label = label + " in " + scopeLabel
+	}
+
+	if !isKioskWise {
+		answer := fmt.Sprintf("POP for poster %s: %s plays.", label, formatThousands(totalPlays))
+		answer = failures.note(pager.note(answer))
+		if onToken != nil {
+			onToken(answer)
+		}
+		return models.ChatResponse{Answer: answer, Steps: steps, Meta: failures.meta(pager.meta())}, true, nil
+	}
+
+	type kv struct {
+		Key   string
+		Plays int64
+	}
+	byKiosk := map[string]int64{}
+	for _, it := range items {
+		k := strings.TrimSpace(it.KioskName)
+		if k == "" {
+			k = strings.TrimSpace(it.HostName)
+		}
+		if k == "" {
+			continue
+		}
+		byKiosk[k] += it.PlayCount
+	}
+	rows := make([]kv, 0, len(byKiosk))
+	for k, v := range byKiosk {
+		rows = append(rows, kv{Key: k, Plays: v})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Plays > rows[j].Plays })
+	listed := c.capList(msgLower, len(rows), "kiosks")
+	rows = rows[:listed.Shown]
+	lines := make([]string, 0, len(rows)+2)
+	lines = append(lines, fmt.Sprintf("POP for poster %s: %s plays", label, formatThousands(totalPlays)))
+	lines = append(lines, "Kiosk-wise:")
+	if wantsTables(req) {
+		t := newTextTable("#", "Kiosk", "Plays").alignRight(0, 2)
+		for i, r := range rows {
+			t.add(fmt.Sprintf("%d", i+1), r.Key, formatThousands(r.Plays))
+		}
+		lines = append(lines, t.lines()...)
+	} else {
+		for i, r := range rows {
+			lines = append(lines, fmt.Sprintf("%d. %s — %s plays", i+1, r.Key, formatThousands(r.Plays)))
+		}
+	}
+	if note := listed.note(); note != "" {
+		lines = append(lines, note)
+	}
+	answer := strings.Join(lines, "\n")
+	answer = failures.note(pager.note(answer))
+	if onToken != nil {
+		onToken(answer)
+	}
+	if conversationID != "" {
+		c.updateConversationPoster(conversationID, posterName, city, region)
+		c.updateConversationPosterID(conversationID, posterID)
+		c.clearPending(conversationID)
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps, Meta: failures.meta(pager.meta())}, true, nil
+}
+
+func (c *ChatService) handlePosterMonthData(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	// A poster named in the message makes this a one-shot question ("pop for
+	// poster Lorla Studio for October 2024 in brt"); without one the poster
+	// comes from the conversation.
+	named := posterFromMessage(req.Message)
+	if !(strings.Contains(msgLower, "month") || strings.Contains(msgLower, "data") || (named != "" && (strings.Contains(msgLower, "pop") || strings.Contains(msgLower, "plays")))) {
+		return models.ChatResponse{}, false, nil
+	}
+	fromT, toT, ok := parseMonthRange(req.Message, time.Now(), requestLocation(req))
+	if !ok {
+		return models.ChatResponse{}, false, nil
+	}
+	fromRFC, toRFC := fromT.Format(time.RFC3339), toT.Format(time.RFC3339)
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	var st *conversationState
+	if conversationID != "" {
+		st = c.getConversationState(conversationID)
+	}
+	posterID, posterName := "", ""
+	switch {
+	case looksLikeUUID(named):
+		posterID = named
+	case named != "":
+		posterName = named
+	case st != nil:
+		posterID = strings.TrimSpace(st.PosterID)
+		posterName = strings.TrimSpace(st.PosterName)
+	}
+	if posterID == "" && posterName == "" {
+		// "device data this month" is not a poster question.
+		if !strings.Contains(msgLower, "poster") && !strings.Contains(msgLower, "pop") {
+			return models.ChatResponse{}, false, nil
+		}
+		return models.ChatResponse{Answer: "Please specify a poster (by name or id) for month data (for example: pop for poster Lorla Studio for October 2024 in brt)."}, true, nil
+	}
+
+	city := c.detectCityCode(ctx, msgLower)
+	region := c.detectRegionCode(ctx, msgLower)
+	if city == "" && region == "" {
+		if st != nil {
+			if strings.TrimSpace(st.PosterRegion) != "" {
+				region = strings.ToLower(strings.TrimSpace(st.PosterRegion))
+			}
+			if strings.TrimSpace(st.PosterCity) != "" {
+				city = strings.ToLower(strings.TrimSpace(st.PosterCity))
+			}
+			if region == "" && strings.TrimSpace(st.Region) != "" {
+				region = strings.ToLower(strings.TrimSpace(st.Region))
+			}
+			if city == "" && strings.TrimSpace(st.City) != "" {
+				city = strings.ToLower(strings.TrimSpace(st.City))
+			}
+		}
+	}
+	if city == "" && region == "" {
+		return models.ChatResponse{Answer: "Please specify a city or region code (for example: moco or brt)."}, true, nil
+	}
+
+	isKioskWise := strings.Contains(msgLower, "kiosk wise") || strings.Contains(msgLower, "kiosk-wise") || strings.Contains(msgLower, "by kiosk")
+
+	filter := "from=" + urlEscape(fromRFC) + "&to=" + urlEscape(toRFC)
+	if looksLikeUUID(posterID) {
+		filter += "&poster_id=" + urlEscape(posterID)
+	} else {
+		filter += "&poster_name=" + urlEscape(posterName)
+	}
+	pager := c.newPopPager()
+	steps := make([]models.Step, 0, 2)
+	items, err := c.scanPop(ctx, popQuery{Filter: filter + popScopeFilter(city, region)}, pager, &steps)
+	if err != nil {
+		return models.ChatResponse{Answer: popFailureAnswer(err), Steps: steps}, true, nil
+	}
+
+	if len(items) == 0 {
+		return models.ChatResponse{Answer: "No POP rows found for " + monthRangeLabel(fromT, toT) + ".", Steps: steps}, true, nil
+	}
+	totalPlays, totalSeconds := int64(0), int64(0)
+	for _, it := range items {
+		totalPlays += it.PlayCount
+		totalSeconds += popSeconds(it)
+	}
+	showMinutes, unitNote := c.minutesPreference(conversationID, msgLower)
+	figure := func(plays int64, minutes float64) string {
+		if showMinutes {
+			return formatMinutes(minutes)
+		}
+		return formatThousands(plays) + " plays"
+	}
+	unitLines := ""
+	if showMinutes {
+		unitLines += "\n" + popMinutesNote
+	}
+	if unitNote != "" {
+		unitLines += "\n" + unitNote
+	}
+	actualPosterName := strings.TrimSpace(items[0].PosterName)
+	actualPosterID := strings.TrimSpace(items[0].PosterID)
+	label := strings.TrimSpace(posterName)
+	if label == "" {
+		label = strings.TrimSpace(actualPosterName)
+	}
+	if label == "" {
+		label = strings.TrimSpace(posterID)
+	}
+	if label == "" {
+		label = strings.TrimSpace(actualPosterID)
+	}
+	monthLabel := monthRangeLabel(fromT, toT)
+	if conversationID != "" {
+		// Keep poster memory consistent with what we actually queried/received.
+		if actualPosterName != "" {
+			c.updateConversationPoster(conversationID, actualPosterName, city, region)
+		} else {
+			c.updateConversationPoster(conversationID, posterName, city, region)
+		}
+		if looksLikeUUID(actualPosterID) {
+			c.updateConversationPosterID(conversationID, actualPosterID)
+		} else if looksLikeUUID(posterID) {
+			c.updateConversationPosterID(conversationID, posterID)
+		}
+		c.clearPending(conversationID)
+	}
+	if !isKioskWise {
+		answer := fmt.Sprintf("POP for poster '%s' for %s: %s.", label, monthLabel, figure(totalPlays, float64(totalSeconds)/60)) + unitLines
+		if onToken != nil {
+			onToken(answer)
+		}
+		return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+	}
+
+	// Kiosk-wise.
+	byKiosk := map[string]int64{}
+	kioskSeconds := map[string]int64{}
+	for _, it := range items {
+		k := strings.TrimSpace(it.KioskName)
+		if k == "" {
+			k = strings.TrimSpace(it.HostName)
+		}
+		if k == "" {
+			continue
+		}
+		byKiosk[k] += it.PlayCount
+		kioskSeconds[k] += popSeconds(it)
+	}
+	type kv struct {
+		Key     string
+		Plays   int64
+		Minutes float64
+	}
+	rows := make([]kv, 0, len(byKiosk))
+	for k, v := range byKiosk {
+		rows = append(rows, kv{Key: k, Plays: v})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Plays > rows[j].Plays })
+	seconds := make([]int64, len(rows))
+	for i, r := range rows {
+		seconds[i] = kioskSeconds[r.Key]
+	}
+	for i, m := range minuteParts(seconds) {
+		rows[i].Minutes = m
+	}
+	listed := c.capList(msgLower, len(rows), "kiosks")
+	rows = rows[:listed.Shown]
+	lines := make([]string, 0, len(rows)+2)
+	lines = append(lines, fmt.Sprintf("POP for poster '%s' for %s: %s", label, monthLabel, figure(totalPlays, float64(totalSeconds)/60)))
+	lines = append(lines, "Kiosk-wise:")
+	if wantsTables(req) {
+		t := newTextTable("#", "Kiosk", "Plays", "Minutes").alignRight(0, 2, 3)
+		for i, r := range rows {
+			t.add(fmt.Sprintf("%d", i+1), r.Key, formatThousands(r.Plays), formatDecimal(r.Minutes, 1))
+		}
+		lines = append(lines, t.lines()...)
+	} else {
+		for i, r := range rows {
+			lines = append(lines, fmt.Sprintf("%d. %s — %s", i+1, r.Key, figure(r.Plays, r.Minutes)))
+		}
+	}
+	if note := listed.note(); note != "" {
+		lines = append(lines, note)
+	}
+	answer := strings.Join(lines, "\n") + unitLines
+	answer = pager.note(answer)
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps, Meta: pager.meta()}, true, nil
+}
+
+func applyQueryDefaults(method, path string, query map[string]string) map[string]string {
+	m := strings.ToUpper(strings.TrimSpace(method))
+	p := strings.TrimSpace(path)
+	if m != "GET" {
+		return query
+	}
+	if query == nil {
+		query = map[string]string{}
+	}
+
+	setIfMissing := func(k, v string) {
+		if strings.TrimSpace(query[k]) == "" {
+			query[k] = v
+		}
+	}
+
+	capInt := func(k string, max int) {
+		v := strings.TrimSpace(query[k])
+		if v == "" {
+			return
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return
+		}
+		if n > max {
+			query[k] = strconv.Itoa(max)
+		}
+	}
+
+	// POP stats defaults
+	if p == "/pop/stats" {
+		setIfMissing("limit", "10")
+		setIfMissing("last_days", "30")
+		capInt("limit", 200)
+	}
+
+	// POP list defaults
+	if p == "/pop" {
+		setIfMissing("page", "1")
+		setIfMissing("page_size", "20")
+		capInt("page_size", 200)
+	}
+
+	// Ads list-ish defaults (safe pagination)
+	if p == "/ads/devices" || p == "/ads/venues" || strings.HasPrefix(p, "/ads/venues/") || strings.HasPrefix(p, "/ads/devices/") || p == "/ads/campaigns" || p == "/ads/creatives" || p == "/ads/projects" || p == "/ads/advertisers" {
+		setIfMissing("page", "1")
+		// campaigns tend to be heavier; still allow larger default
+		if p == "/ads/campaigns" {
+			setIfMissing("page_size", "50")
+			capInt("page_size", 200)
+		} else {
+			setIfMissing("page_size", "20")
+			capInt("page_size", 100)
+		}
+	}
+
+	// Metrics defaults: avoid totals unless explicitly requested.
+	if strings.HasPrefix(p, "/metrics/") {
+		setIfMissing("include_totals", "false")
+		setIfMissing("page", "1")
+		setIfMissing("page_size", "50")
+		capInt("page_size", 200)
+	}
+
+	return query
+}
+
+func (c *ChatService) normalizePopQueryLocation(ctx context.Context, path string, query map[string]string) map[string]string {
+	if query == nil {
+		return query
+	}
+	p := strings.TrimSpace(path)
+	if p != "/pop/stats" {
+		return query
+	}
+	city := strings.ToLower(strings.TrimSpace(query["city"]))
+	if city == "" {
+		return query
+	}
+	// If model incorrectly passes a region code in city param (e.g. city=brt, city=kc),
+	// rewrite to region=<code> and drop city to enforce correct scoping.
+	// Heuristic for overlaps (tokens that can exist as both city and region):
+	// - Short codes (<=3) default to region unless user explicitly sets region/city elsewhere.
+	// - Longer codes (e.g. moco, kcmo) default to city.
+	if strings.TrimSpace(query["region"]) == "" && c.isKnownRegionCode(ctx, city) {
+		if len(city) <= 3 || !c.isKnownProjectCityCode(ctx, city) {
+			query["region"] = city
+			delete(query, "city")
+		}
+	}
+	return query
+}
+
+func isTopPostersFromCityIntent(msgLower string) bool {
+	s := strings.ToLower(strings.TrimSpace(msgLower))
+	if s == "" {
+		return false
+	}
+	if strings.Contains(s, "top") && (strings.Contains(s, "poster") || strings.Contains(s, "posters")) {
+		// Allow follow-ups like "show top posters" to be handled deterministically using the
+		// remembered city/region scope in the conversation.
+		if strings.Contains(s, "from") || strings.Contains(s, "in") || strings.Contains(s, "city") {
+			return true
+		}
+		if strings.Contains(s, "perform") {
+			return true
+		}
+		return true
+	}
+	return false
+}
+
+// refersToRememberedPoster reports whether a message without a poster name,
+// such as "show it kiosk wise", points back at the poster the conversation
+// remembers with "it", "that" or "this".
+func (c *ChatService) refersToRememberedPoster(conversationID, msgLower string) bool {
+	if conversationID == "" {
+		return false
+	}
+	pronoun := false
+	for _, w := range tokenizeWords(msgLower) {
+		if w == "it" || w == "its" || w == "that" || w == "this" {
+			pronoun = true
+			break
+		}
+	}
+	if !pronoun {
+		return false
+	}
+	st := c.getConversationState(conversationID)
+	return st != nil && (strings.TrimSpace(st.PosterID) != "" || strings.TrimSpace(st.PosterName) != "")
+}
+
+func (c *ChatService) handlePosterPlayCount(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	excl, msgNoExcl := parsePopExclusion(req.Message)
+	req.Message = msgNoExcl
+	daypart, msgNoDaypart := c.parseDaypart(req.Message)
+	req.Message = msgNoDaypart
+	msgLower := strings.ToLower(req.Message)
+	conversationID := strings.TrimSpace(req.ConversationID)
+	isKioskWise := strings.Contains(msgLower, "kiosk wise") || strings.Contains(msgLower, "kiosk-wise") || strings.Contains(msgLower, "kioskwise") || strings.Contains(msgLower, "kiosks wise") || strings.Contains(msgLower, "kiosks-wise") || strings.Contains(msgLower, "by kiosk") || strings.Contains(msgLower, "by kiosks")
+	isSameFollowup := isKioskWise && (strings.Contains(msgLower, "same") || strings.Contains(msgLower, "same as") || strings.Contains(msgLower, "same kiosk"))
+	isWholeKioskWiseFollowup := false
+	hasPlayCount := strings.Contains(msgLower, "play count") || strings.Contains(msgLower, "plays")
+	hasPosterWord := strings.Contains(msgLower, "poster")
+	hasAdWord := strings.Contains(msgLower, " ad ") || strings.HasSuffix(strings.TrimSpace(msgLower), " ad") || strings.Contains(msgLower, " creative ") || strings.HasSuffix(strings.TrimSpace(msgLower), " creative")
+
+	if isKioskWise && !hasPosterWord && !hasPlayCount {
+		// Follow-up like: "get me whole kiosks wise data" after a poster-specific query.
+		// Only treat it as poster analytics if conversation memory already has poster context.
+		if (strings.Contains(msgLower, "whole") || strings.Contains(msgLower, "all") || strings.Contains(msgLower, "overall") || strings.Contains(msgLower, "entire") || strings.Contains(msgLower, "data")) && conversationID != "" {
+			if st := c.getConversationState(conversationID); st != nil {
+				if strings.TrimSpace(st.PosterID) != "" || strings.TrimSpace(st.PosterName) != "" {
+					isWholeKioskWiseFollowup = true
+				}
+			}
+		}
+		// "show it kiosk wise" asks the same of the remembered poster.
+		if c.refersToRememberedPoster(conversationID, msgLower) {
+			isWholeKioskWiseFollowup = true
+		}
+		if isWholeKioskWiseFollowup {
+			hasPlayCount = true
+		}
+	}
+	if isKioskWise && hasPosterWord && posterFromMessage(req.Message) != "" {
+		// "kiosk wise for poster Lorla Studio in brt" names everything itself.
+		hasPlayCount = true
+	}
+
+	// Explicit query: requires poster + play count.
+	// Follow-up query: allow "same kiosk wise" (or "whole kiosks wise data") to reuse poster + scope from conversation memory.
+	if !hasPosterWord && !isSameFollowup && !isWholeKioskWiseFollowup && !(hasPlayCount && hasAdWord) {
+		return models.ChatResponse{}, false, nil
+	}
+	// Allow ad/creative phrasing: "play count of <ad name> ..." (no "poster" keyword).
+	if !hasPlayCount && !isSameFollowup && !isWholeKioskWiseFollowup {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+	if isSameFollowup {
+		isKioskWise = true
+	}
+	if isWholeKioskWiseFollowup {
+		isKioskWise = true
+	}
+	if !hasPosterWord {
+		// If the user didn't say "poster", still treat it as a poster play-count query when
+		// they used "play count" and mention an ad/creative.
+		if !isSameFollowup && !isWholeKioskWiseFollowup && !(hasPlayCount && hasAdWord) {
+			return models.ChatResponse{}, false, nil
+		}
+	}
+
+	// Extract poster name (preserve original casing for poster_name query).
+	posterName := ""
+	if strings.Contains(msgLower, " posters") || strings.HasPrefix(msgLower, "posters ") || strings.Contains(msgLower, " posters ") {
+		low := strings.ToLower(req.Message)
+		idx := strings.Index(low, " posters")
+		if idx < 0 {
+			idx = strings.Index(low, " posters ")
+		}
+		if idx >= 0 {
+			posterName = strings.TrimSpace(req.Message[:idx])
+		}
+	} else if hasPosterWord {
+		// Cut before "same kiosk wise", scope and dates, so a follow-up that
+		// names its poster does not need an earlier turn.
+		posterName = posterFromMessage(req.Message)
+	} else {
+		// Handle: "play count of <name> ...".
+		posterName = strings.TrimSpace(extractAfterKeywordOriginal(req.Message, "play count of"))
+		if posterName == "" {
+			posterName = strings.TrimSpace(extractAfterKeywordOriginal(req.Message, "plays of"))
+		}
+	}
+	posterName = strings.TrimSpace(strings.TrimSuffix(posterName, "kiosk wise"))
+	posterName = strings.TrimSpace(strings.TrimSuffix(posterName, "kiosk-wise"))
+	posterName = withoutFullList(posterName)
+	posterNameLower := strings.ToLower(posterName)
+	if strings.Contains(posterNameLower, " from ") {
+		posterName = strings.TrimSpace(strings.SplitN(posterName, " from ", 2)[0])
+		posterNameLower = strings.ToLower(posterName)
+	}
+	if strings.Contains(posterNameLower, " in ") {
+		posterName = strings.TrimSpace(strings.SplitN(posterName, " in ", 2)[0])
+		posterNameLower = strings.ToLower(posterName)
+	}
+	if strings.Contains(posterNameLower, " for ") {
+		posterName = strings.TrimSpace(strings.SplitN(posterName, " for ", 2)[0])
+		posterNameLower = strings.ToLower(posterName)
+	}
+	// Strip a trailing "ad"/"creative" token from the extracted name.
+	posterNameLower = strings.ToLower(strings.TrimSpace(posterName))
+	if strings.HasSuffix(posterNameLower, " ad") {
+		posterName = strings.TrimSpace(posterName[:len(posterName)-len(" ad")])
+	}
+	posterNameLower = strings.ToLower(strings.TrimSpace(posterName))
+	if strings.HasSuffix(posterNameLower, " creative") {
+		posterName = strings.TrimSpace(posterName[:len(posterName)-len(" creative")])
+	}
+	posterIDFromMem := ""
+	if conversationID != "" {
+		if st := c.getConversationState(conversationID); st != nil {
+			if strings.TrimSpace(st.PosterID) != "" {
+				posterIDFromMem = strings.TrimSpace(st.PosterID)
+			}
+			if posterName == "" && strings.TrimSpace(st.PosterName) != "" {
+				posterName = strings.TrimSpace(st.PosterName)
+			}
+		}
+	}
+	if strings.Contains(strings.ToLower(posterName), "interpreted request") || strings.Contains(posterName, "poster=") {
+		posterName = ""
+	}
+	if posterName == "" {
+		if looksLikeUUID(posterIDFromMem) {
+			posterName = posterIDFromMem
+		} else {
+			return models.ChatResponse{Answer: "Please specify a poster name (for example: play count of poster Lorla Studio)."}, true, nil
+		}
+	}
+
+	// Reuse last poster scope for follow-ups like "same kiosk wise".
+	scope := c.resolvePosterScope(ctx, conversationID, msgLower)
+	city, region := scope.City.Value, scope.Region.Value
+	if city == "" && region == "" {
+		return models.ChatResponse{Answer: "Please specify a city or region code (for example: moco or brt)."}, true, nil
+	}
+	if conversationID != "" {
+		c.updateConversationPoster(conversationID, posterName, city, region)
+		c.clearPending(conversationID)
+	}
+
+	scopeLabel := ""
+	if strings.TrimSpace(region) != "" {
+		scopeLabel = "region '" + strings.TrimSpace(region) + "'"
+	} else {
+		scopeLabel = "city '" + strings.TrimSpace(city) + "'"
+	}
+	showMinutes, unitNote := c.minutesPreference(conversationID, msgLower)
+	figure := func(plays int64, minutes float64) string {
+		if showMinutes {
+			return formatMinutes(minutes)
+		}
+		return formatThousands(plays) + " plays"
+	}
+
+	// Pull POP rows filtered by poster_name (or poster_id) + scope.
+
+	fromRFC, toRFC := extractDateRangeRFC3339(msgLower)
+	if fromRFC == "" && toRFC == "" {
+		fromRFC, toRFC = extractNaturalDateRangeRFC3339(req.Message)
+	}
+	if fromRFC == "" && toRFC == "" {
+		fromRFC, toRFC = extractYearRangeRFC3339(msgLower)
+	}
+	// "last week", "the past 3 days" and the like, so a daypart can be
+	// applied to each day of them ("evening rush last week").
+	if fromRFC == "" && toRFC == "" {
+		if phrase := relativeWindowRe.FindString(msgLower); phrase != "" {
+			if from, to, label, ok := exportWindow(phrase, time.Now(), requestLocation(req)); ok {
+				fromRFC, toRFC = from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339)
+				scopeLabel += " " + windowPhrase(label)
+			}
+		}
+	}
+
+	var (
+		items []popItem
+		steps []models.Step
+		pager *popPager
+		err   error
+	)
+	if rng, ok := parsePopRange(fromRFC, toRFC); ok && c.rawRangeTooLong(rng) {
+		// A plain play total over a long range comes from the stats
+		// aggregate; breakdowns, minutes, exclusions and dayparts need
+		// rows, read month by month.
+		var statsSteps []models.Step
+		if !isKioskWise && !showMinutes && excl == nil && daypart == nil {
+			plays, step, found := c.posterStatsPlays(ctx, posterName, city, region, rng)
+			if found {
+				pager = &popPager{Strategy: popRangeStats, Range: rng}
+				answer := pager.note(fmt.Sprintf("Play count for poster '%s' in %s: %s.", posterName, scopeLabel, figure(plays, 0)) + unitLines(showMinutes, unitNote))
+				if onToken != nil {
+					onToken(answer)
+				}
+				citations := cite(nil, figure(plays, 0), 0, 0)
+				return models.ChatResponse{Answer: answer, Steps: []models.Step{step}, Citations: citations, Meta: pager.meta()}, true, nil
+			}
+			statsSteps = append(statsSteps, step)
+		}
+		items, steps, pager, err = c.fetchPopChunks(ctx, rng, func(ctx context.Context, chunk popRange) ([]popItem, []models.Step, *popPager, error) {
+			rows, steps, p, err := c.fetchPosterPlays(ctx, posterName, city, region, chunk.From.Format(time.RFC3339), chunk.To.Format(time.RFC3339))
+			return chunk.keep(rows), steps, p, err
+		})
+		for i := range items {
+			items[i].step += len(statsSteps)
+		}
+		steps = append(statsSteps, steps...)
+	} else {
+		items, steps, pager, err = c.fetchPosterPlays(ctx, posterName, city, region, fromRFC, toRFC)
+	}
+	var rangeErr *popRangeError
+	if errors.As(err, &rangeErr) {
+		answer := fmt.Sprintf("Can't answer for poster '%s' in %s: %s", posterName, scopeLabel, rangeErr.Error())
+		if onToken != nil {
+			onToken(answer)
+		}
+		return models.ChatResponse{Answer: answer, Steps: steps, Meta: pager.meta()}, true, nil
+	}
+	if err != nil {
+		// A fetch that failed before reading a page has no step to inspect.
+		var last models.Step
+		if len(steps) > 0 {
+			last = steps[len(steps)-1]
+		}
+		switch {
+		case last.Error != "" || len(steps) == 0:
+			return models.ChatResponse{Answer: "Failed to fetch POP data: " + err.Error(), Steps: steps}, true, nil
+		case last.Status < 200 || last.Status >= 300:
+			return models.ChatResponse{Answer: fmt.Sprintf("Failed to fetch POP data (status %d).", last.Status), Steps: steps}, true, nil
+		}
+		return models.ChatResponse{Answer: "POP list response could not be parsed.", Steps: steps}, true, nil
+	}
+	filterNotes := ""
+	if excl != nil {
+		c.resolveExclusion(ctx, excl)
+		steps = append(steps, excl.Steps...)
+		items = excl.filter(items)
+		filterNotes = "\n\n" + excl.note()
+	}
+	if daypart != nil {
+		loc := requestLocation(req)
+		items = daypart.filter(items, loc)
+		filterNotes += "\n\n" + daypart.note(req, loc)
+	}
+	if len(items) == 0 {
+		answer := pager.note(fmt.Sprintf("No play counts found for poster '%s' in %s.", posterName, scopeLabel) + filterNotes)
+		if onToken != nil {
+			onToken(answer)
+		}
+		return models.ChatResponse{Answer: answer, Steps: steps, Meta: pager.meta()}, true, nil
+	}
+
+	totalPlays, totalSeconds := sumPopPlays(items)
+	unitLines := unitLines(showMinutes, unitNote)
+
+	sources := rowSteps(items)
+	citations := cite(nil, figure(totalPlays, float64(totalSeconds)/60), 0, sources...)
+	if !isKioskWise {
+		answer := fmt.Sprintf("Play count for poster '%s' in %s: %s.", posterName, scopeLabel, figure(totalPlays, float64(totalSeconds)/60)) + unitLines + filterNotes
+		// Only a long range read in chunks adds a note here; a single
+		// crawl keeps its answer as it was.
+		if pager.Strategy != "" {
+			answer = pager.note(answer)
+		}
+		if onToken != nil {
+			onToken(answer)
+		}
+		resp := models.ChatResponse{Answer: answer, Steps: steps, Citations: citations}
+		if pager.Strategy != "" {
+			resp.Meta = pager.meta()
+		}
+		return resp, true, nil
+	}
+
+	// Kiosk-wise aggregation.
+	kp := playsByKiosk(items)
+	listed := c.capList(msgLower, len(kp.Rows), "kiosks")
+	rows := kp.Rows[:listed.Shown]
+	lines := make([]string, 0, len(rows)+2)
+	lines = append(lines, fmt.Sprintf("Play count for poster '%s' in %s: %s", posterName, scopeLabel, figure(totalPlays, float64(totalSeconds)/60)))
+	lines = append(lines, "Kiosk-wise:")
+	if wantsTables(req) {
+		t := newTextTable("#", "Kiosk", "Plays", "Minutes").alignRight(0, 2, 3)
+		for i, r := range rows {
+			t.add(fmt.Sprintf("%d", i+1), r.Key, formatThousands(r.Plays), formatDecimal(r.Minutes, 1))
+		}
+		base := len(lines) + 2
+		lines = append(lines, t.lines()...)
+		for i, r := range rows {
+			cell := formatThousands(r.Plays)
+			if showMinutes {
+				cell = formatDecimal(r.Minutes, 1)
+			}
+			citations = cite(citations, cell, base+i, r.Steps...)
+		}
+	} else {
+		for i, r := range rows {
+			lines = append(lines, fmt.Sprintf("%d. %s — %s", i+1, r.Key, figure(r.Plays, r.Minutes)))
+			citations = cite(citations, figure(r.Plays, r.Minutes), len(lines)-1, r.Steps...)
+		}
+	}
+	if note := listed.note(); note != "" {
+		lines = append(lines, note)
+	}
+	answer := strings.Join(lines, "\n") + unitLines + filterNotes
+	answer = pager.note(answer)
+	if onToken != nil {
+		onToken(answer)
+	}
+	resp := models.ChatResponse{Answer: answer, Steps: steps, Citations: citations}
+	if fc := kp.Geo.collection("plays", kp.Plays); fc != nil {
+		resp.Data = &models.ChatData{Geo: fc}
+	}
+	resp.Meta = pager.meta()
+	return resp, true, nil
+}
+
+// fetchPosterPlays reads every POP row of a poster (by name, or by id when
+// poster is a UUID) in region, or in city when region is empty, over
+// [fromRFC, toRFC] when both are set. A gateway that rejects the dates with
+// a 400 is asked again without them. Poster play counts in chat and the
+// poster_plays query are both summed from these rows.
+func (c *ChatService) fetchPosterPlays(ctx context.Context, poster, city, region, fromRFC, toRFC string) ([]popItem, []models.Step, *popPager, error) {
+	pager := c.newPopPager()
+	steps := make([]models.Step, 0, 2)
+	posterQueryKey := "poster_name"
+	if looksLikeUUID(poster) {
+		posterQueryKey = "poster_id"
+	}
+	q := popQuery{Filter: posterQueryKey + "=" + urlEscape(poster) + popScopeFilter(city, region)}
+	if strings.TrimSpace(fromRFC) != "" && strings.TrimSpace(toRFC) != "" {
+		q.Fallback = q.Filter
+		q.Filter += "&from=" + urlEscape(fromRFC) + "&to=" + urlEscape(toRFC)
+	}
+	items, err := c.scanPop(ctx, q, pager, &steps)
+	return items, steps, pager, err
+}
+
+// posterStatsPlays reads a poster's plays in region (or city) over r from the
+// /pop/stats poster ranking. found is false when the poster is not among the
+// ranked rows, and the caller reads rows instead.
+func (c *ChatService) posterStatsPlays(ctx context.Context, poster, city, region string, r popRange) (plays int64, step models.Step, found bool) {
+	top := c.fetchTopPosters(ctx, topPostersQuery{City: city, Region: region, Metric: "plays", From: r.From, To: r.To, Limit: 200})
+	for _, row := range top.Rows {
+		if strings.EqualFold(row.Key, poster) || strings.EqualFold(row.Name, poster) {
+			return int64(math.Round(row.Value)), top.Step, true
+		}
+	}
+	return 0, top.Step, false
+}
+
+// unitLines are the minutes note and the carried-over unit note of a POP
+// answer.
+func unitLines(showMinutes bool, unitNote string) string {
+	lines := ""
+	if showMinutes {
+		lines += "\n" + popMinutesNote
+	}
+	if unitNote != "" {
+		lines += "\n" + unitNote
+	}
+	return lines
+}
+
+// sumPopPlays totals the plays and played seconds of items.
+func sumPopPlays(items []popItem) (plays, seconds int64) {
+	for _, it := range items {
+		plays += it.PlayCount
+		seconds += popSeconds(it)
+	}
+	return plays, seconds
+}
+
+// kioskPlays is a poster's plays per kiosk (by kiosk name, else host name).
+type kioskPlays struct {
+	// Rows are highest plays first.
+	Rows  []kioskPlayRow
+	Plays map[string]int64
+	Geo   kioskGeo
+}
+
+type kioskPlayRow struct {
+	Key     string
+	Plays   int64
+	Seconds int64
+	// Minutes is Seconds in minutes, rounded with the other rows so that
+	// they add up to the total (see minuteParts).
+	Minutes float64
+	// Steps are the POP pages the kiosk's rows came from.
+	Steps []int
+}
+
+func playsByKiosk(items []popItem) kioskPlays {
+	kp := kioskPlays{Plays: map[string]int64{}, Geo: kioskGeo{}}
+	kioskSeconds := map[string]int64{}
+	kioskSteps := map[string][]int{}
+	for _, it := range items {
+		k := strings.TrimSpace(it.KioskName)
+		if k == "" {
+			k = strings.TrimSpace(it.HostName)
+		}
+		if k == "" {
+			continue
+		}
+		kp.Plays[k] += it.PlayCount
+		kioskSeconds[k] += popSeconds(it)
+		kioskSteps[k] = appendStep(kioskSteps[k], it.step)
+		kp.Geo.note(k, it.KioskName, it.HostName, it.KioskLat, it.KioskLong)
+	}
+	kp.Rows = make([]kioskPlayRow, 0, len(kp.Plays))
+	for k, v := range kp.Plays {
+		kp.Rows = append(kp.Rows, kioskPlayRow{Key: k, Plays: v, Seconds: kioskSeconds[k], Steps: kioskSteps[k]})
+	}
+	sort.Slice(kp.Rows, func(i, j int) bool { return kp.Rows[i].Plays > kp.Rows[j].Plays })
+	seconds := make([]int64, len(kp.Rows))
+	for i, r := range kp.Rows {
+		seconds[i] = r.Seconds
+	}
+	for i, m := range minuteParts(seconds) {
+		kp.Rows[i].Minutes = m
+	}
+	return kp
+}
+
+// kioskGeo remembers the coordinates seen for each aggregation key so per-kiosk
+// answers can ship a map-ready GeoJSON block alongside the text.
+type kioskGeo map[string]kioskGeoPoint
+
+type kioskGeoPoint struct {
+	Name string
+	Host string
+	Lat  float64
+	Lon  float64
+}
+
+func (g kioskGeo) note(key, name, host string, lat, lon float64) {
+	if key == "" || (lat == 0 && lon == 0) {
+		return
+	}
+	if _, ok := g[key]; ok {
+		return
+	}
+	g[key] = kioskGeoPoint{Name: strings.TrimSpace(name), Host: strings.ToLower(strings.TrimSpace(host)), Lat: lat, Lon: lon}
+}
+
+// collection builds a FeatureCollection with metric values per key, or nil when
+// no kiosk had usable coordinates.
+func (g kioskGeo) collection(metric string, values map[string]int64) *models.GeoFeatureCollection {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return values[keys[i]] > values[keys[j]] })
+	fc := &models.GeoFeatureCollection{}
+	for _, k := range keys {
+		p, ok := g[k]
+		if !ok {
+			continue
+		}
+		props := map[string]any{"kiosk_name": p.Name, "host": p.Host}
+		if metric != "" {
+			props[metric] = values[k]
+		}
+		fc.AddPoint(p.Lat, p.Lon, props)
+	}
+	if len(fc.Features) == 0 {
+		return nil
+	}
+	return fc
+}
+
+func (c *ChatService) handlePopKioskWiseFollowup(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msg := strings.TrimSpace(req.Message)
+	msgLower := strings.ToLower(msg)
+	if msg == "" {
+		return models.ChatResponse{}, false, nil
+	}
+	// This handler is specifically for location-scoped POP/analytics follow-ups that ask for kiosk-wise breakdown
+	// but do not specify a host.
+	if len(detectHostTokens(msg)) > 0 {
+		return models.ChatResponse{}, false, nil
+	}
+	if !(strings.Contains(msgLower, "kiosk") || strings.Contains(msgLower, "kiosks")) {
+		return models.ChatResponse{}, false, nil
+	}
+	if !(strings.Contains(msgLower, "wise") || strings.Contains(msgLower, "split") || strings.Contains(msgLower, "registered")) {
+		return models.ChatResponse{}, false, nil
+	}
+	// Avoid taking over explicit telemetry requests.
+	if strings.Contains(msgLower, "telemetry") || strings.Contains(msgLower, "device status") || strings.Contains(msgLower, "health") || strings.Contains(msgLower, "metrics") {
+		return models.ChatResponse{}, false, nil
+	}
+	// A named poster ("same kiosk wise for poster Lorla Studio") is a poster
+	// breakdown, answered by handlePosterPlayCount.
+	if posterFromMessage(msg) != "" {
+		return models.ChatResponse{}, false, nil
+	}
+	conversationID := strings.TrimSpace(req.ConversationID)
+	// So is "show it kiosk wise" while a poster is remembered.
+	if c.refersToRememberedPoster(conversationID, msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	scope := c.resolveScope(ctx, conversationID, msgLower)
+	city, region := scope.City.Value, scope.Region.Value
+	if city == "" && region == "" {
+		return models.ChatResponse{Answer: "Please specify a city or region code (for example: moco city brt region)."}, true, nil
+	}
+	if conversationID != "" {
+		c.updateConversationLocation(conversationID, city, region)
+		c.clearPending(conversationID)
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+
+	metric := "clicks"
+	if strings.Contains(msgLower, "play") {
+		metric = "plays"
+	}
+	limit, full := c.listLimit(msgLower)
+	// One row past the limit tells whether the list was cut.
+	path := fmt.Sprintf("/pop/stats?group_by=kiosk&metric=%s&order=top&limit=%d", metric, limit+1)
+	// Follow-up UX: if user didn't specify an explicit window, default to last 7 days.
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -7)
+	to := now
+	if strings.Contains(msgLower, "today") {
+		from = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	if strings.Contains(msgLower, "yesterday") {
+		from = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+		to = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	// If the user explicitly asked for "last week", keep the same default (7 days), but make intent explicit.
+	path += "&from=" + urlEscape(from.Format(time.RFC3339)) + "&to=" + urlEscape(to.Format(time.RFC3339))
+	if region != "" {
+		path += "&region=" + urlEscape(region)
+	} else {
+		path += "&city=" + urlEscape(city)
+	}
+
+	status, body, err := c.Gateway.Get(ctx, path)
+	step := models.Step{Tool: "popStats", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+	} else {
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	}
+	if err != nil {
+		return models.ChatResponse{Answer: "Failed to fetch POP stats: " + err.Error(), Steps: []models.Step{step}}, true, nil
+	}
+	if status < 200 || status >= 300 {
+		return models.ChatResponse{Answer: fmt.Sprintf("Failed to fetch POP stats (status %d).", status), Steps: []models.Step{step}}, true, nil
+	}
+	var parsed struct {
+		Items []map[string]any `json:"items"`
+	}
+	if json.Unmarshal(body, &parsed) != nil {
+		return models.ChatResponse{Answer: "POP stats response could not be parsed.", Steps: []models.Step{step}}, true, nil
+	}
+	if len(parsed.Items) == 0 {
+		return models.ChatResponse{Answer: "No kiosk stats found for that scope.", Steps: []models.Step{step}}, true, nil
+	}
+	lines := make([]string, 0, 11)
+	scopeLabel := ""
+	if region != "" {
+		scopeLabel = fmt.Sprintf("region '%s'", region)
+	} else {
+		scopeLabel = fmt.Sprintf("city '%s'", city)
+	}
+	lines = append(lines, fmt.Sprintf("Top kiosks in %s by %s (last 7 days):", scopeLabel, metric))
+	table := newTextTable("#", "Kiosk", capitalize(metric)).alignRight(0, 2)
+	listed := make([]listedEntity, 0, limit)
+	more := false
+	for _, row := range parsed.Items {
+		k, _ := row["Key"].(string)
+		if strings.TrimSpace(k) == "" {
+			continue
+		}
+		if len(lines)-1 >= limit {
+			more = true
+			break
+		}
+		val := 0.0
+		switch v := row["Metric"].(type) {
+		case float64:
+			val = v
+		case int:
+			val = float64(v)
+		case json.Number:
+			if f, e := v.Float64(); e == nil {
+				val = f
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s — %s %s", len(lines), k, formatDecimal(val, 0), metric))
+		table.add(fmt.Sprintf("%d", len(lines)-1), k, formatDecimal(val, 0))
+		listed = append(listed, listedEntity{ID: k, Name: k})
+	}
+	c.rememberRanking(conversationID, listKindKiosk, listed, listRanking{From: from, To: to})
+	if wantsTables(req) {
+		lines = append(lines[:1], table.lines()...)
+	}
+	shown := len(table.rows)
+	if note := (listCap{Shown: shown, Total: shown, More: more, Full: full, Noun: "kiosks"}).note(); note != "" {
+		lines = append(lines, note)
+	}
+	answer := strings.Join(lines, "\n")
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: []models.Step{step}}, true, nil
+}
+
+func (c *ChatService) handlePopStatsGeneric(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	excl, msgNoExcl := parsePopExclusion(req.Message)
+	req.Message = msgNoExcl
+	msgLower := strings.ToLower(req.Message)
+	if !(strings.Contains(msgLower, "stat") || strings.Contains(msgLower, "pop") || strings.Contains(msgLower, "analytic")) {
+		return models.ChatResponse{}, false, nil
+	}
+
+	// When the user asks for "top X" analytics, allow this generic stats handler to answer.
+	// Specific top-* handlers run earlier and will still take precedence.
+	if !strings.Contains(msgLower, "analytic") && (strings.Contains(msgLower, "top poster") || strings.Contains(msgLower, "top device") || strings.Contains(msgLower, "top kiosk")) {
+		// Covered by specific handlers that run earlier.
+		return models.ChatResponse{}, false, nil
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	// If the user explicitly mentioned a city or region in the message, prioritize that.
+	// Otherwise, fallback to the conversation state.
+	scope := c.resolveScope(ctx, conversationID, msgLower)
+	city, region := scope.City.Value, scope.Region.Value
+	if city == "" && region == "" {
+		return models.ChatResponse{Answer: "Please specify a city or region code (for example: kcmo or brt)."}, true, nil
+	}
+	if conversationID != "" {
+		c.updateConversationLocation(conversationID, city, region)
+		c.clearPending(conversationID)
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+
+	groupBy := ""
+	switch {
+	case strings.Contains(msgLower, "poster"):
+		groupBy = "poster"
+	case strings.Contains(msgLower, "device"):
+		groupBy = "device"
+	case strings.Contains(msgLower, "kiosk"):
+		groupBy = "kiosk"
+	}
+	if groupBy == "" {
+		groupBy = "poster"
+	}
+
+	metric := "clicks"
+	if strings.Contains(msgLower, "play") {
+		metric = "plays"
+	} else if strings.Contains(msgLower, "count") {
+		metric = "count"
+	}
+
+	limit := c.limits().DisplayTopN
+	basePath := fmt.Sprintf("/pop/stats?group_by=%s&metric=%s&order=top&limit=%d", groupBy, metric, limit)
+	path := basePath
+	scopeLabel := ""
+	scopeFilter := ""
+	if region != "" {
+		scopeFilter = "region=" + urlEscape(region)
+		scopeLabel = fmt.Sprintf("region '%s'", region)
+	} else if city != "" {
+		scopeFilter = "city=" + urlEscape(city)
+		scopeLabel = fmt.Sprintf("city '%s'", city)
+	}
+	if excl != nil {
+		// /pop/stats has no NOT filter; aggregate raw rows instead.
+		resp := c.popTopExcluding(ctx, excl, scopeFilter, groupBy, metric, scopeLabel, limit)
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	path += "&" + scopeFilter
+
+	status, body, err := c.Gateway.Get(ctx, path)
+	step := models.Step{Tool: "popStats", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+	} else {
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	}
+	steps := []models.Step{step}
+
+	if err != nil {
+		return models.ChatResponse{Answer: "Failed to fetch POP stats: " + err.Error(), Steps: steps}, true, nil
+	}
+	if status < 200 || status >= 300 {
+		answer := fmt.Sprintf("Failed to fetch POP stats (status %d).", status)
+		return models.ChatResponse{Answer: answer, Steps: []models.Step{step}}, true, nil
+	}
+
+	type popStatsResponse struct {
+		Items []map[string]any `json:"items"`
+	}
+	var parsed popStatsResponse
+	if json.Unmarshal(body, &parsed) != nil {
+		return models.ChatResponse{Answer: fmt.Sprintf("POP stats response for %s could not be parsed.", scopeLabel), Steps: []models.Step{step}}, true, nil
+	}
+
+	if len(parsed.Items) == 0 {
+		answer := fmt.Sprintf("No POP %s stats found for %s.", metric, scopeLabel)
+		return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+	}
+
+	lines := make([]string, 0, len(parsed.Items))
+	for idx, row := range parsed.Items {
+		if idx >= c.limits().DisplayTopN {
+			break
+		}
+		name := ""
+		if groupBy == "poster" {
+			if s, ok := row["PosterName"].(string); ok && strings.TrimSpace(s) != "" {
+				name = s
+			}
+		}
+		if name == "" {
+			if s, ok := row["Key"].(string); ok {
+				name = s
+			}
+		}
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		value := 0.0
+		switch v := row["Metric"].(type) {
+		case float64:
+			value = v
+		case int:
+			value = float64(v)
+		case json.Number:
+			if f, e := v.Float64(); e == nil {
+				value = f
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s — %s %s", len(lines)+1, name, formatDecimal(value, 0), metric))
+	}
+
+	if len(lines) == 0 {
+		answer := fmt.Sprintf("No POP %s stats found for %s.", metric, scopeLabel)
+		return models.ChatResponse{Answer: answer, Steps: []models.Step{step}}, true, nil
+	}
+
+	prefix := "Top"
+	switch groupBy {
+	case "poster":
+		prefix = "Top posters"
+	case "device":
+		prefix = "Top devices"
+	case "kiosk":
+		prefix = "Top kiosks"
+	}
+	answer := fmt.Sprintf("%s in %s by %s:\n%s", prefix, scopeLabel, metric, strings.Join(lines, "\n"))
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: []models.Step{step}}, true, nil
+}
+
+// popMinutesNote explains how minute figures are derived.
+const popMinutesNote = "(Minutes computed from POP 'value' duration; if missing, estimated assuming 10 seconds per play.)"
+
+// popSeconds is a row's on-screen time: the POP value duration, or 10 seconds
+// per play when the gateway did not report one.
+func popSeconds(it popItem) int64 {
+	if it.Value > 0 {
+		return it.Value
+	}
+	return it.PlayCount * 10
+}
+
+// formatMinutes renders on-screen time in minutes; breakdown rows pass their
+// minuteParts so they add up to the headline.
+func formatMinutes(minutes float64) string {
+	return formatDecimal(minutes, 1) + " minutes"
+}
+
+// explicitUnit returns the unit a message asks for, or "" when it names none.
+func explicitUnit(msgLower string) string {
+	switch {
+	case strings.Contains(msgLower, "minute"):
+		return "minutes"
+	case strings.Contains(msgLower, "impression"):
+		return "impressions"
+	case strings.Contains(msgLower, "in plays") || strings.Contains(msgLower, "play count") || strings.Contains(msgLower, "plays"):
+		return "plays"
+	}
+	return ""
+}
+
+// minutesPreference decides whether a POP answer is rendered in minutes. A
+// unit named in the message wins and is remembered for the conversation;
+// otherwise the remembered unit carries over and note says so.
+func (c *ChatService) minutesPreference(conversationID, msgLower string) (showMinutes bool, note string) {
+	if unit := explicitUnit(msgLower); unit != "" {
+		c.rememberUnit(conversationID, unit)
+		return unit == "minutes", ""
+	}
+	if st := c.getConversationState(conversationID); st != nil && st.Unit == "minutes" {
+		return true, "(continuing in minutes — say 'in plays' to switch)"
+	}
+	return false, ""
+}
+
+// fetchPopRows pages through /pop for the given filter query (without page
+// params) and returns the collected rows plus one Step per page. The pager
+// reports whether the page cap cut the listing short. If ctx's deadline fires
+// after at least one page, the rows fetched so far are returned without an
+// error and the pager is marked TimedOut. A from/to range longer than
+// PopRawRangeDays is read in month-sized chunks, or refused with a
+// popRangeError when that needs too many.
+func (c *ChatService) fetchPopRows(ctx context.Context, filter string) ([]popItem, []models.Step, *popPager, error) {
+	if r, ok := popFilterRange(filter); ok && c.rawRangeTooLong(r) {
+		return c.fetchPopChunks(ctx, r, func(ctx context.Context, chunk popRange) ([]popItem, []models.Step, *popPager, error) {
+			rows, steps, p, err := c.fetchPopRowsRaw(ctx, withPopRange(filter, chunk))
+			return chunk.keep(rows), steps, p, err
+		})
+	}
+	return c.fetchPopRowsRaw(ctx, filter)
+}
+
+// fetchPopRowsRaw is fetchPopRows for a single crawl.
+func (c *ChatService) fetchPopRowsRaw(ctx context.Context, filter string) ([]popItem, []models.Step, *popPager, error) {
+	pager := c.newPopPager()
+	steps := make([]models.Step, 0, 1)
+	rows, err := c.scanPop(ctx, popQuery{Filter: filter}, pager, &steps)
+	return rows, steps, pager, err
+}
+
+// fetchHostPopPlays sums play_count for one host over [from, to).
+func (c *ChatService) fetchHostPopPlays(ctx context.Context, host, fromRFC, toRFC string) (int64, []models.Step, error) {
+	rows, steps, _, err := c.fetchPopRows(ctx, "host_name="+urlEscape(host)+"&from="+urlEscape(fromRFC)+"&to="+urlEscape(toRFC))
+	plays := int64(0)
+	for _, r := range rows {
+		plays += r.PlayCount
+	}
+	return plays, steps, err
+}
+
+func isPosterCoPlayIntent(msgLower string) bool {
+	for _, k := range []string{"what else plays", "what else is playing", "other posters on the same kiosk", "other posters on same kiosk", "co-played with", "coplayed with", "co-play", "coplay"} {
+		if strings.Contains(msgLower, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractCoPlayPosterName pulls the target poster out of phrasings like
+// "what else plays on the kiosks where poster Lorla Studio plays" or
+// "posters co-played with Lorla Studio in brt".
+func extractCoPlayPosterName(msg string) string {
+	name := ""
+	for _, k := range []string{"where poster", "co-played with", "coplayed with", "as poster", "same kiosks as", "same kiosk as", "poster "} {
+		if v := extractAfterKeywordOriginal(msg, k); v != "" {
+			name = v
+			break
+		}
+	}
+	if name == "" {
+		name = extractAfterKeywordOriginal(msg, " with ")
+	}
+	name = strings.TrimSpace(strings.TrimRight(name, "?.!"))
+	lower := strings.ToLower(name)
+	for _, sep := range []string{" from ", " in ", " for ", " during ", " between ", " on "} {
+		if i := strings.Index(lower, sep); i >= 0 {
+			name = strings.TrimSpace(name[:i])
+			lower = strings.ToLower(name)
+		}
+	}
+	for _, suffix := range []string{" is playing", " plays", " runs", " is running"} {
+		if strings.HasSuffix(lower, suffix) {
+			name = strings.TrimSpace(name[:len(name)-len(suffix)])
+			lower = strings.ToLower(name)
+		}
+	}
+	return strings.Trim(name, "'\" ")
+}
+
+func (c *ChatService) handlePosterCoPlay(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !isPosterCoPlayIntent(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+	conversationID := strings.TrimSpace(req.ConversationID)
+
+	posterName := extractCoPlayPosterName(req.Message)
+	if id := extractCampaignID(req.Message); looksLikeUUID(id) {
+		posterName = id
+	}
+	city := c.detectCityCode(ctx, msgLower)
+	region := c.detectRegionCode(ctx, msgLower)
+	if st := c.getConversationState(conversationID); st != nil {
+		if posterName == "" {
+			if strings.TrimSpace(st.PosterName) != "" {
+				posterName = strings.TrimSpace(st.PosterName)
+			} else if looksLikeUUID(st.PosterID) {
+				posterName = st.PosterID
+			}
+		}
+		if city == "" && region == "" {
+			region = strings.ToLower(strings.TrimSpace(st.PosterRegion))
+			city = strings.ToLower(strings.TrimSpace(st.PosterCity))
+			if region == "" && city == "" {
+				region = strings.ToLower(strings.TrimSpace(st.Region))
+				city = strings.ToLower(strings.TrimSpace(st.City))
+			}
+		}
+	}
+	if posterName == "" {
+		return models.ChatResponse{Answer: "Please specify the poster (for example: what else plays on the kiosks where poster Lorla Studio plays)."}, true, nil
+	}
+	if conversationID != "" {
+		c.updateConversationPoster(conversationID, posterName, city, region)
+	}
+
+	fromRFC, toRFC := extractDateRangeRFC3339(msgLower)
+	if fromRFC == "" && toRFC == "" {
+		fromRFC, toRFC = extractNaturalDateRangeRFC3339(req.Message)
+	}
+	dateFilter := ""
+	if fromRFC != "" && toRFC != "" {
+		dateFilter = "&from=" + urlEscape(fromRFC) + "&to=" + urlEscape(toRFC)
+	}
+	scopeFilter := ""
+	scopeLabel := "all locations"
+	if region != "" {
+		scopeFilter = "&region=" + urlEscape(region)
+		scopeLabel = "region '" + region + "'"
+	} else if city != "" {
+		scopeFilter = "&city=" + urlEscape(city)
+		scopeLabel = "city '" + city + "'"
+	}
+
+	// Stage 1: the target poster's kiosk footprint.
+	posterKey := "poster_name"
+	if looksLikeUUID(posterName) {
+		posterKey = "poster_id"
+	}
+	targetRows, steps, pager, err := c.fetchPopRows(ctx, posterKey+"="+urlEscape(posterName)+scopeFilter+dateFilter)
+	if err != nil {
+		return models.ChatResponse{Answer: "Failed to fetch POP data for the poster: " + err.Error(), Steps: steps}, true, nil
+	}
+	targetByHost := map[string]int64{}
+	kioskNames := map[string]string{}
+	targetID := ""
+	for _, r := range targetRows {
+		h := strings.ToLower(strings.TrimSpace(r.HostName))
+		if h == "" {
+			continue
+		}
+		targetByHost[h] += r.PlayCount
+		if kioskNames[h] == "" {
+			kioskNames[h] = strings.TrimSpace(r.KioskName)
+		}
+		if targetID == "" {
+			targetID = strings.TrimSpace(r.PosterID)
+		}
+	}
+	if len(targetByHost) == 0 {
+		answer := fmt.Sprintf("No POP data was found for poster '%s' in %s, so I can't tell which kiosks it shares.", posterName, scopeLabel)
+		if onToken != nil {
+			onToken(answer)
+		}
+		return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+	}
+	hosts := make([]string, 0, len(targetByHost))
+	for h := range targetByHost {
+		hosts = append(hosts, h)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if targetByHost[hosts[i]] != targetByHost[hosts[j]] {
+			return targetByHost[hosts[i]] > targetByHost[hosts[j]]
+		}
+		return hosts[i] < hosts[j]
+	})
+	totalKiosks := len(hosts)
+	const maxCoPlayKiosks = 15
+	if len(hosts) > maxCoPlayKiosks {
+		hosts = hosts[:maxCoPlayKiosks]
+	}
+	targetPlays := int64(0)
+	for _, h := range hosts {
+		targetPlays += targetByHost[h]
+	}
+
+	// Stage 2: everything else on those kiosks, fetched with bounded concurrency.
+	type hostFetch struct {
+		rows  []popItem
+		steps []models.Step
+		err   error
+	}
+	fetched := make([]hostFetch, len(hosts))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 5)
+	for i, h := range hosts {
+		wg.Add(1)
+		go func(i int, h string) {
+			defer wg.Done()
+			defer recoverInto("co-play fetch", func(err error) { fetched[i] = hostFetch{err: err} })
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			rows, st, _, err := c.fetchPopRows(ctx, "host_name="+urlEscape(h)+dateFilter)
+			fetched[i] = hostFetch{rows: rows, steps: st, err: err}
+		}(i, h)
+	}
+	wg.Wait()
+
+	type coPoster struct {
+		Name  string
+		Plays int64
+		Hosts map[string]struct{}
+	}
+	byPoster := map[string]*coPoster{}
+	failedHosts := 0
+	targetLower := foldText(posterName)
+	for _, f := range fetched {
+		steps = append(steps, f.steps...)
+		if f.err != nil {
+			failedHosts++
+			continue
+		}
+		for _, r := range f.rows {
+			id := strings.TrimSpace(r.PosterID)
+			name := strings.TrimSpace(r.PosterName)
+			if (targetID != "" && id == targetID) || foldText(name) == targetLower || strings.EqualFold(id, posterName) {
+				continue
+			}
+			key := id
+			if key == "" {
+				key = strings.ToLower(name)
+			}
+			if key == "" {
+				continue
+			}
+			cp := byPoster[key]
+			if cp == nil {
+				cp = &coPoster{Name: name, Hosts: map[string]struct{}{}}
+				byPoster[key] = cp
+			}
+			if cp.Name == "" {
+				cp.Name = name
+			}
+			if cp.Name == "" {
+				cp.Name = id
+			}
+			cp.Plays += r.PlayCount
+			if h := strings.ToLower(strings.TrimSpace(r.HostName)); h != "" {
+				cp.Hosts[h] = struct{}{}
+			}
+		}
+	}
+
+	ranked := make([]*coPoster, 0, len(byPoster))
+	for _, cp := range byPoster {
+		ranked = append(ranked, cp)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Plays != ranked[j].Plays {
+			return ranked[i].Plays > ranked[j].Plays
+		}
+		return ranked[i].Name < ranked[j].Name
+	})
+	if n := c.limits().DisplayTopN; len(ranked) > n {
+		ranked = ranked[:n]
+	}
+
+	lines := make([]string, 0, len(ranked)+4)
+	lines = append(lines, fmt.Sprintf("Posters co-playing with '%s' on its kiosks in %s ('%s' itself: %s plays on %d kiosk(s)):", posterName, scopeLabel, posterName, formatThousands(targetPlays), len(hosts)))
+	if totalKiosks > len(hosts) {
+		lines = append(lines, fmt.Sprintf("(Analyzed the top %d of %d kiosks by '%s' plays.)", len(hosts), totalKiosks, posterName))
+	}
+	if len(ranked) == 0 {
+		lines = append(lines, "No other posters were found on those kiosks.")
+	}
+	for i, cp := range ranked {
+		lines = append(lines, fmt.Sprintf("%d. %s — %s plays on %d/%d shared kiosks", i+1, cp.Name, formatThousands(cp.Plays), len(cp.Hosts), len(hosts)))
+	}
+	if failedHosts > 0 {
+		lines = append(lines, fmt.Sprintf("Note: POP for %d kiosk(s) could not be fetched and is not included.", failedHosts))
+	}
+	answer := pager.note(strings.Join(lines, "\n"))
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps, Meta: pager.meta()}, true, nil
+}
+
+func extractPosterLookupToken(msgLower string) string {
+	s := strings.ToLower(strings.TrimSpace(msgLower))
+	if s == "" {
+		return ""
+	}
+	needsPosterContext := !(strings.Contains(s, "poster") || strings.Contains(s, "creative") || strings.Contains(s, "detail"))
+	tokens := strings.FieldsFunc(msgLower, func(r rune) bool {
+		return r == ' ' || r == '\n' || r == '\t' || r == ',' || r == ';'
+	})
+	for _, raw := range tokens {
+		token := strings.Trim(raw, "\"'.,;:()[]{}")
+		if token == "" {
+			continue
+		}
+		tokenLower := strings.ToLower(token)
+		if looksLikeUUID(token) {
+			continue
+		}
+		if !strings.Contains(tokenLower, "_") && !strings.Contains(tokenLower, "-") {
+			continue
+		}
+		if needsPosterContext && !strings.HasPrefix(tokenLower, "vistar") {
+			continue
+		}
+		if len(token) < 8 {
+			continue
+		}
+		return token
+	}
+	return ""
+}
+
+func (c *ChatService) handlePosterDetails(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	token := extractPosterLookupToken(msgLower)
+	if token == "" {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+	path := "/ads/creatives/search?query=" + urlEscape(token)
+	status, body, err := c.Gateway.Get(ctx, path)
+	step := models.Step{Tool: "adsCreativesSearch", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+	} else {
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	}
+	answer := ""
+	if err != nil {
+		answer = "Failed to search creatives: " + err.Error()
+	} else if status < 200 || status >= 300 {
+		answer = fmt.Sprintf("Creative search failed with status %d.", status)
+	} else {
+		var parsed map[string]any
+		if json.Unmarshal(body, &parsed) != nil {
+			answer = "Creative search returned an unreadable response."
+		} else {
+			items, _ := parsed["data"].([]any)
+			if len(items) == 0 {
+				answer = fmt.Sprintf("No creatives matched '%s'.", token)
+			} else {
+				lines := make([]string, 0, min(3, len(items)))
+				for _, it := range items {
+					row, ok := it.(map[string]any)
+					if !ok {
+						continue
+					}
+					name, _ := row["name"].(string)
+					id, _ := row["id"].(string)
+					campaignID, _ := row["campaign_id"].(string)
+					urlStr, _ := row["url"].(string)
+					line := name
+					if line == "" {
+						line = id
+					}
+					if strings.TrimSpace(line) == "" {
+						continue
+					}
+					extras := make([]string, 0, 2)
+					if campaignID != "" {
+						extras = append(extras, "campaign "+campaignID)
+					}
+					if urlStr != "" {
+						extras = append(extras, urlStr)
+					}
+					if len(extras) > 0 {
+						line = line + " (" + strings.Join(extras, ", ") + ")"
+					}
+					lines = append(lines, line)
+					if len(lines) >= 3 {
+						break
+					}
+				}
+				if len(lines) == 0 {
+					answer = fmt.Sprintf("Creatives were found for '%s', but the records were missing readable fields.", token)
+				} else {
+					answer = fmt.Sprintf("Found creatives matching '%s':\n%s", token, strings.Join(lines, "\n"))
+				}
+			}
+		}
+	}
+	if onToken != nil && answer != "" {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: []models.Step{step}}, true, nil
+}
+
+func (c *ChatService) handleTopPostersFromCity(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	excl, msgNoExcl := parsePopExclusion(req.Message)
+	req.Message = msgNoExcl
+	msgLower := strings.ToLower(req.Message)
+	if !isTopPostersFromCityIntent(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	conversationID := strings.TrimSpace(req.ConversationID)
+	city := c.detectCityCode(ctx, msgLower)
+	region := ""
+	if city == "" {
+		region = c.detectRegionCode(ctx, msgLower)
+		// Memory fallback: "show top posters" should reuse last scope.
+		if region == "" && conversationID != "" {
+			if st := c.getConversationState(conversationID); st != nil {
+				if strings.TrimSpace(st.Region) != "" {
+					region = strings.ToLower(strings.TrimSpace(st.Region))
+				} else if strings.TrimSpace(st.City) != "" {
+					city = strings.ToLower(strings.TrimSpace(st.City))
+				}
+			}
+		}
+		if region == "" && city == "" {
+			return models.ChatResponse{Answer: "Please specify a city code (for example: kcmo, kc, brt, dart)."}, true, nil
+		}
+	}
+	if conversationID != "" {
+		c.updateConversationLocation(conversationID, city, region)
+		c.clearPending(conversationID)
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+	metric := "clicks"
+	if strings.Contains(msgLower, "play") {
+		metric = "plays"
+	}
+	limit, full := c.listLimit(msgLower)
+	// Without an explicit N, one row past the limit tells whether the list
+	// was cut.
+	q := topPostersQuery{City: city, Region: region, Metric: metric, Limit: limit + 1}
+	explicitN := false
+	if n := extractTopN(msgLower); n > 0 {
+		limit, full, explicitN = n, false, true
+		q.Limit = n
+	}
+	// What a drill-down into the ranking asks the poster handlers about.
+	rankingScope := "in " + firstNonEmpty(region, city)
+	// Basic relative time window support.
+	// If the user asked for "last week", apply from/to to scope the POP stats query.
+	if strings.Contains(msgLower, "last week") || strings.Contains(msgLower, "past week") {
+		rankingScope += " last week"
+		now := time.Now().UTC()
+		q.From = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -7)
+		q.To = now
+	}
+	scopeLabel := firstNonEmpty(region, city)
+	if excl != nil {
+		resp := c.popTopExcluding(ctx, excl, q.filter(), "poster", metric, scopeLabel, limit)
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	top := c.fetchTopPosters(ctx, q)
+	answer := ""
+	if top.Err != nil {
+		answer = "Failed to fetch POP stats: " + top.Err.Error()
+	} else if top.Step.Status < 200 || top.Step.Status >= 300 {
+		answer = fmt.Sprintf("Failed to fetch POP stats (status %d).", top.Step.Status)
+	} else if len(top.Rows) == 0 {
+		if region != "" {
+			answer = fmt.Sprintf("No poster %s stats found for region '%s'.", metric, scopeLabel)
+		} else {
+			answer = fmt.Sprintf("No poster %s stats found for city '%s'.", metric, scopeLabel)
+		}
+	} else {
+		lines := make([]string, 0, len(top.Rows))
+		table := newTextTable("#", "Poster", capitalize(metric)).alignRight(0, 2)
+		snapshot := make([]models.AnswerRow, 0, len(top.Rows))
+		listed := make([]listedEntity, 0, len(top.Rows))
+		more := len(top.Rows) > limit
+		for _, row := range top.Rows {
+			if len(lines) >= limit {
+				break
+			}
+			lines = append(lines, fmt.Sprintf("%d. %s — %s %s", len(lines)+1, row.Name, formatDecimal(row.Value, 0), metric))
+			table.add(fmt.Sprintf("%d", len(lines)), row.Name, formatDecimal(row.Value, 0))
+			snapshot = append(snapshot, models.AnswerRow{Key: row.Key, Label: row.Name, Value: row.Value})
+			listed = append(listed, listedEntity{ID: row.Key, Name: row.Name})
+		}
+		if wantsTables(req) {
+			lines = table.lines()
+		}
+		if !explicitN {
+			if note := (listCap{Shown: len(listed), Total: len(listed), More: more, Full: full, Noun: "posters"}).note(); note != "" {
+				lines = append(lines, note)
+			}
+		}
+		c.rememberRanking(conversationID, listKindPoster, listed, listRanking{Scope: rankingScope})
+		changes := c.answerChanges(ctx, req, "top_posters_"+metric, snapshot, func(v float64) string {
+			return formatDecimal(v, 0) + " " + metric
+		})
+		answer = withChanges(req, changes, fmt.Sprintf("Top posters in %s by %s:\n%s", scopeLabel, metric, strings.Join(lines, "\n")))
+	}
+	if onToken != nil {
+		for i := 0; i < len(answer); i += 20 {
+			end := i + 20
+			if end > len(answer) {
+				end = len(answer)
+			}
+			onToken(answer[i:end])
+		}
+	}
+	return models.ChatResponse{Answer: answer, Steps: []models.Step{top.Step}}, true, nil
+}
+
+// topPostersQuery is a poster ranking from /pop/stats: by Metric ("plays" or
+// "clicks") in Region, or in City when Region is empty, over [From, To] when
+// set.
+type topPostersQuery struct {
+	City, Region string
+	Metric       string
+	From, To     time.Time
+	// Limit is how many posters the gateway is asked for.
+	Limit int
+}
+
+// filter is the query's scope and window as POP query parameters.
+func (q topPostersQuery) filter() string {
+	filter := ""
+	if !q.From.IsZero() && !q.To.IsZero() {
+		filter += "from=" + urlEscape(q.From.Format(time.RFC3339)) + "&to=" + urlEscape(q.To.Format(time.RFC3339)) + "&"
+	}
+	if q.Region != "" {
+		return filter + "region=" + urlEscape(q.Region)
+	}
+	return filter + "city=" + urlEscape(q.City)
+}
+
+// topPosters is the ranking answered by handleTopPostersFromCity and the
+// top_posters query.
+type topPosters struct {
+	Step models.Step
+	Err  error
+	Rows []rankedPoster
+}
+
+type rankedPoster struct {
+	Key   string
+	Name  string
+	Value float64
+}
+
+func (c *ChatService) fetchTopPosters(ctx context.Context, q topPostersQuery) topPosters {
+	path := "/pop/stats?group_by=poster&metric=" + q.Metric + "&order=top&limit=" + fmt.Sprintf("%d", q.Limit) + "&" + q.filter()
+	status, body, err := c.Gateway.Get(ctx, path)
+	top := topPosters{Step: models.Step{Tool: "popStats", Status: status}, Err: err}
+	if err != nil {
+		top.Step.Error = err.Error()
+		return top
+	}
+	top.Step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	if status < 200 || status >= 300 {
+		return top
+	}
+	var parsed map[string]any
+	_ = json.Unmarshal(body, &parsed)
+	itemsAny, _ := parsed["items"].([]any)
+	for _, it := range itemsAny {
+		row, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		key, _ := row["Key"].(string)
+		name, _ := row["PosterName"].(string)
+		if strings.TrimSpace(name) == "" {
+			name = key
+		}
+		val := 0.0
+		switch v := row["Metric"].(type) {
+		case float64:
+			val = v
+		case int:
+			val = float64(v)
+		}
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		if strings.TrimSpace(key) == "" {
+			key = name
+		}
+		top.Rows = append(top.Rows, rankedPoster{Key: key, Name: name, Value: val})
+	}
+	return top
+}
+
+func isPopPatternIntent(msgLower string) (hourly bool, ok bool) {
+	hourly = strings.Contains(msgLower, "hourly") || strings.Contains(msgLower, "time of day") || strings.Contains(msgLower, "hour of day") || strings.Contains(msgLower, "by hour") || strings.Contains(msgLower, "which hour") || strings.Contains(msgLower, "best hour")
+	weekly := strings.Contains(msgLower, "day of week") || strings.Contains(msgLower, "day of the week") || strings.Contains(msgLower, "weekday") || strings.Contains(msgLower, "which day") || strings.Contains(msgLower, "best day") || strings.Contains(msgLower, "weekly pattern")
+	if !hourly && !weekly {
+		return false, false
+	}
+	if strings.Contains(msgLower, "poster") || strings.Contains(msgLower, "kiosk") || strings.Contains(msgLower, "device") || strings.Contains(msgLower, "pattern") || strings.Contains(msgLower, "perform") || strings.Contains(msgLower, "plays") {
+		return hourly, true
+	}
+	return false, false
+}
+
+// extractPatternTarget returns the poster name or kiosk named in a pattern
+// question ("best day of week for poster X in kcmo", "hourly pattern for
+// kiosk briggs-001").
+func extractPatternTarget(msg string) (poster string, kiosk string) {
+	cut := func(v string) string {
+		v = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(v), "?.!"))
+		lower := strings.ToLower(v)
+		for _, sep := range []string{" in ", " perform", " does ", " do ", " during ", " over ", " from ", " for the ", " last ", " between ", " by ", " play"} {
+			if i := strings.Index(lower, sep); i >= 0 {
+				v = strings.TrimSpace(v[:i])
+				lower = strings.ToLower(v)
+			}
+		}
+		return strings.Trim(v, "'\" ")
+	}
+	for _, k := range []string{"for kiosk ", "of kiosk ", "kiosk ", "for device ", "device "} {
+		if v := cut(extractAfterKeywordOriginal(msg, k)); v != "" {
+			return "", v
+		}
+	}
+	for _, k := range []string{"for poster ", "does poster ", "of poster ", "poster "} {
+		if v := cut(extractAfterKeywordOriginal(msg, k)); v != "" {
+			return v, ""
+		}
+	}
+	return "", ""
+}
+
+// requestLocation resolves the request timezone, falling back to UTC.
+func requestLocation(req models.ChatRequest) *time.Location {
+	tz := strings.TrimSpace(req.Timezone)
+	if tz == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+var weekdayLabels = []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
+
+// bucketPopPlays sums play_count by weekday (Monday first) or hour of day in
+// loc, and counts the distinct local calendar days seen.
+func bucketPopPlays(rows []popItem, loc *time.Location, hourly bool) ([]int64, int) {
+	n := 7
+	if hourly {
+		n = 24
+	}
+	buckets := make([]int64, n)
+	days := map[string]struct{}{}
+	for _, r := range rows {
+		if r.PopDatetime.IsZero() {
+			continue
+		}
+		t := r.PopDatetime.In(loc)
+		days[t.Format("2006-01-02")] = struct{}{}
+		if hourly {
+			buckets[t.Hour()] += r.PlayCount
+		} else {
+			buckets[(int(t.Weekday())+6)%7] += r.PlayCount
+		}
+	}
+	return buckets, len(days)
+}
+
+func (c *ChatService) handlePopPattern(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	hourly, ok := isPopPatternIntent(msgLower)
+	if !ok {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+	daypart, msgNoDaypart := c.parseDaypart(req.Message)
+	req.Message = msgNoDaypart
+	msgLower = strings.ToLower(msgNoDaypart)
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	posterName, kiosk := extractPatternTarget(req.Message)
+	// Full host ids (moco-brt-briggs-001) filter by host; shorter tokens are kiosk names.
+	host := alertHostToken(req.Message)
+	if strings.Count(host, "-") < 2 {
+		host = ""
+	} else {
+		kiosk = ""
+	}
+	city := c.detectCityCode(ctx, msgLower)
+	region := c.detectRegionCode(ctx, msgLower)
+	if posterName == "" && kiosk == "" && host == "" && conversationID != "" {
+		if st := c.getConversationState(conversationID); st != nil {
+			posterName = strings.TrimSpace(st.PosterName)
+			if posterName == "" {
+				host = strings.TrimSpace(st.Host)
+			}
+		}
+	}
+	filter := ""
+	target := ""
+	switch {
+	case host != "":
+		filter, target = "host_name="+urlEscape(host), "kiosk "+host
+	case kiosk != "":
+		filter, target = "kiosk_name="+urlEscape(kiosk), "kiosk '"+kiosk+"'"
+	case posterName != "":
+		key := "poster_name"
+		if looksLikeUUID(posterName) {
+			key = "poster_id"
+		}
+		filter, target = key+"="+urlEscape(posterName), "poster '"+posterName+"'"
+	default:
+		return reply(models.ChatResponse{Answer: "Which poster or kiosk? For example: \"best day of week for poster Bet 365 in kcmo\" or \"hourly pattern for kiosk moco-brt-briggs-001\"."})
+	}
+	scopeLabel := ""
+	if region != "" {
+		filter += "&region=" + urlEscape(region)
+		scopeLabel = " in region '" + region + "'"
+	} else if city != "" {
+		filter += "&city=" + urlEscape(city)
+		scopeLabel = " in city '" + city + "'"
+	}
+
+	fromRFC, toRFC := extractDateRangeRFC3339(msgLower)
+	if fromRFC == "" || toRFC == "" {
+		fromRFC, toRFC = extractNaturalDateRangeRFC3339(req.Message)
+	}
+	windowLabel := ""
+	if fromRFC == "" || toRFC == "" {
+		now := time.Now().UTC()
+		fromRFC = now.AddDate(0, 0, -28).Format(time.RFC3339)
+		toRFC = now.Format(time.RFC3339)
+		windowLabel = "last 28 days"
+	} else {
+		windowLabel = fromRFC[:10] + " to " + toRFC[:10]
+	}
+	filter += "&from=" + urlEscape(fromRFC) + "&to=" + urlEscape(toRFC)
+
+	rows, steps, pager, err := c.fetchPopRows(ctx, filter)
+	if err != nil {
+		return reply(models.ChatResponse{Answer: "Failed to fetch POP data: " + err.Error(), Steps: steps})
+	}
+	loc := requestLocation(req)
+	daypartNote := ""
+	if daypart != nil {
+		rows = daypart.filter(rows, loc)
+		daypartNote = daypart.note(req, loc)
+	}
+	if len(rows) == 0 {
+		answer := fmt.Sprintf("No POP data found for %s%s (%s).", target, scopeLabel, windowLabel)
+		if daypartNote != "" {
+			answer += "\n" + daypartNote
+		}
+		return reply(models.ChatResponse{Answer: answer, Steps: steps})
+	}
+	if conversationID != "" {
+		if host != "" {
+			c.updateConversationHost(conversationID, host)
+		} else if posterName != "" {
+			c.updateConversationPoster(conversationID, posterName, city, region)
+		}
+	}
+
+	buckets, distinctDays := bucketPopPlays(rows, loc, hourly)
+	labels := weekdayLabels
+	bucketName := "weekday"
+	if hourly {
+		bucketName = "hour"
+		labels = make([]string, 24)
+		for h := range labels {
+			labels[h] = fmt.Sprintf("%02d:00", h)
+		}
+	}
+	series := &models.TimeSeries{Name: "plays by " + bucketName, Bucket: bucketName, Timezone: loc.String(), Metric: "plays"}
+	// An hourly pattern within a daypart ranks only the daypart's hours.
+	order := make([]int, len(buckets))
+	for i := range order {
+		order[i] = i
+	}
+	if hourly && daypart != nil {
+		order = daypart.hours()
+	}
+	total := int64(0)
+	for _, i := range order {
+		total += buckets[i]
+		series.Points = append(series.Points, models.TimeSeriesPoint{Label: labels[i], Value: buckets[i]})
+	}
+	sort.SliceStable(order, func(a, b int) bool { return buckets[order[a]] > buckets[order[b]] })
+	peak, trough := order[0], order[len(order)-1]
+
+	lines := []string{fmt.Sprintf("Plays by %s for %s%s (%s, %s): %s total.", bucketName, target, scopeLabel, windowLabel, loc.String(), formatThousands(total))}
+	limit := len(order)
+	if hourly && limit > c.limits().DisplayTopN {
+		limit = c.limits().DisplayTopN
+	}
+	shares := shareParts(buckets)
+	for i := 0; i < limit; i++ {
+		b := order[i]
+		lines = append(lines, fmt.Sprintf("%d. %s — %s plays (%s)", i+1, labels[b], formatThousands(buckets[b]), formatPercent(shares[b])))
+	}
+	peakLine := fmt.Sprintf("Peak: %s (%d). Trough: %s (%d).", labels[peak], buckets[peak], labels[trough], buckets[trough])
+	if buckets[trough] > 0 {
+		peakLine += fmt.Sprintf(" Spread: peak is %s above trough.", formatPercent(float64(buckets[peak]-buckets[trough])*100/float64(buckets[trough])))
+	}
+	lines = append(lines, peakLine)
+	if distinctDays < 7 {
+		lines = append(lines, fmt.Sprintf("Only %d distinct day(s) of data in this window, so the pattern is not yet reliable.", distinctDays))
+	}
+	if daypartNote != "" {
+		lines = append(lines, daypartNote)
+	}
+	answer := pager.note(strings.Join(lines, "\n"))
+	return reply(models.ChatResponse{Answer: answer, Steps: steps, Meta: pager.meta(), Data: &models.ChatData{TimeSeries: series}})
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// How a date range too long to read as raw /pop rows in one crawl was
+// answered. The strategy is stated in the answer and in Meta.RangeStrategy.
+const (
+	// popRangeStats answers from /pop/stats aggregates instead of rows.
+	popRangeStats = "stats"
+	// popRangeChunked reads the range as month-sized sub-ranges, each
+	// paged on its own, and combines the rows.
+	popRangeChunked = "chunked"
+	// popRangeRefused declines a range that would need more chunks than
+	// PopRangeMaxChunks.
+	popRangeRefused = "refused"
+	// popRangeMixed marks a multi-intent answer whose parts chose
+	// differently.
+	popRangeMixed = "mixed"
+)
+
+// popRange is a half-open [From, To) date range of a POP query.
+type popRange struct {
+	From, To time.Time
+}
+
+// parsePopRange reads the RFC 3339 bounds the date extractors produce.
+func parsePopRange(fromRFC, toRFC string) (popRange, bool) {
+	from, errF := time.Parse(time.RFC3339, strings.TrimSpace(fromRFC))
+	to, errT := time.Parse(time.RFC3339, strings.TrimSpace(toRFC))
+	if errF != nil || errT != nil || !to.After(from) {
+		return popRange{}, false
+	}
+	return popRange{From: from, To: to}, true
+}
+
+// popFilterRange reads the from and to parameters of a /pop filter query.
+func popFilterRange(filter string) (popRange, bool) {
+	q, err := url.ParseQuery(filter)
+	if err != nil {
+		return popRange{}, false
+	}
+	return parsePopRange(q.Get("from"), q.Get("to"))
+}
+
+// days is the number of days the range touches, rounded up.
+func (r popRange) days() int {
+	return int(math.Ceil(r.To.Sub(r.From).Hours() / 24))
+}
+
+func (r popRange) label() string {
+	last := r.To.Add(-time.Second)
+	if r.From.Year() == last.Year() {
+		return r.From.Format("Jan 2") + "–" + last.Format("Jan 2, 2006")
+	}
+	return r.From.Format("Jan 2, 2006") + "–" + last.Format("Jan 2, 2006")
+}
+
+// monthChunks splits the range at calendar month boundaries, so a range of
+// four whole months is four chunks.
+func (r popRange) monthChunks() []popRange {
+	var out []popRange
+	for from := r.From; from.Before(r.To); {
+		next := time.Date(from.Year(), from.Month()+1, 1, 0, 0, 0, 0, from.Location())
+		if next.After(r.To) {
+			next = r.To
+		}
+		out = append(out, popRange{From: from, To: next})
+		from = next
+	}
+	return out
+}
+
+// keep drops rows dated outside the chunk, so a gateway that ignores the
+// dates does not count the same rows once per chunk.
+func (r popRange) keep(rows []popItem) []popItem {
+	out := rows[:0]
+	for _, row := range rows {
+		if row.PopDatetime.IsZero() || (!row.PopDatetime.Before(r.From) && row.PopDatetime.Before(r.To)) {
+			out = append(out, row)
+		}
+	}
+	return out
+}
+
+// rawRangeTooLong reports whether r spans more days than one raw /pop crawl
+// may cover.
+func (c *ChatService) rawRangeTooLong(r popRange) bool {
+	return r.days() > c.limits().PopRawRangeDays
+}
+
+// popRangeError is returned for a range that is too long for raw rows and
+// too long to chunk.
+type popRangeError struct {
+	Range     popRange
+	MaxDays   int
+	MaxChunks int
+	// Largest is the supported window closest to the end of Range.
+	Largest popRange
+}
+
+func (e *popRangeError) Error() string {
+	return fmt.Sprintf("the range %s (%d days) is too large for raw POP rows, which are read for at most %d days at a time or in up to %d month-sized chunks. The largest supported window is %s; to get every row for the full range, ask to export the POP rows as CSV instead.", e.Range.label(), e.Range.days(), e.MaxDays, e.MaxChunks, e.Largest.label())
+}
+
+// planPopChunks returns the month chunks r is read in, or a popRangeError
+// when there are more than PopRangeMaxChunks.
+func (c *ChatService) planPopChunks(r popRange) ([]popRange, error) {
+	chunks := r.monthChunks()
+	maxChunks := c.limits().PopRangeMaxChunks
+	if len(chunks) <= maxChunks {
+		return chunks, nil
+	}
+	kept := chunks[len(chunks)-maxChunks:]
+	return nil, &popRangeError{
+		Range:     r,
+		MaxDays:   c.limits().PopRawRangeDays,
+		MaxChunks: maxChunks,
+		Largest:   popRange{From: kept[0].From, To: kept[len(kept)-1].To},
+	}
+}
+
+// popChunkFetch reads the raw rows of one chunk.
+type popChunkFetch func(ctx context.Context, chunk popRange) ([]popItem, []models.Step, *popPager, error)
+
+// fetchPopChunks reads r chunk by chunk with fetch and combines the rows,
+// steps and paging. Row citations are re-pointed at the combined steps. A
+// deadline that fires between chunks keeps the rows read so far.
+func (c *ChatService) fetchPopChunks(ctx context.Context, r popRange, fetch popChunkFetch) ([]popItem, []models.Step, *popPager, error) {
+	pager := c.newPopPager()
+	pager.Range = r
+	chunks, err := c.planPopChunks(r)
+	if err != nil {
+		pager.Strategy = popRangeRefused
+		return nil, nil, pager, err
+	}
+	pager.Strategy = popRangeChunked
+	var (
+		rows  []popItem
+		steps []models.Step
+	)
+	for i, chunk := range chunks {
+		if i > 0 && pager.timeout(ctx) {
+			break
+		}
+		chunkRows, chunkSteps, p, err := fetch(ctx, chunk)
+		for j := range chunkRows {
+			chunkRows[j].step += len(steps)
+		}
+		rows = append(rows, chunkRows...)
+		steps = append(steps, chunkSteps...)
+		pager.Chunks++
+		if p != nil {
+			pager.Fetched += p.Fetched
+			pager.Total += p.Total
+			pager.Truncated = pager.Truncated || p.Truncated
+			pager.TimedOut = pager.TimedOut || p.TimedOut
+		}
+		if err != nil {
+			return rows, steps, pager, err
+		}
+	}
+	return rows, steps, pager, nil
+}
+
+// withPopRange replaces the from and to parameters of a /pop filter query.
+func withPopRange(filter string, r popRange) string {
+	q, _ := url.ParseQuery(filter)
+	q.Set("from", r.From.Format(time.RFC3339))
+	q.Set("to", r.To.Format(time.RFC3339))
+	return q.Encode()
+}
+
+// rangeNote states how a long range was answered.
+func (p *popPager) rangeNote() string {
+	if p == nil {
+		return ""
+	}
+	switch p.Strategy {
+	case popRangeStats:
+		return fmt.Sprintf("The range %s spans %d days, more than raw POP rows are read for, so the total comes from POP aggregate stats.", p.Range.label(), p.Range.days())
+	case popRangeChunked:
+		return fmt.Sprintf("The range %s spans %d days, more than raw POP rows are read for at once, so it was read in %d month-sized chunks and combined.", p.Range.label(), p.Range.days(), p.Chunks)
+	}
+	return ""
+}
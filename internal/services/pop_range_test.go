@@ -0,0 +1,144 @@
+package services
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// monthlyPop is Bet 365 plays on the Briggs kiosks from July to October
+// 2024, plus one Union Station row outside brt.
+func monthlyPop() []popItem {
+	lobby, annex, union := testDevices[0], testDevices[1], testDevices[2]
+	row := func(d fakeDevice, month time.Month, day int, plays int64) popItem {
+		return popItem{
+			PosterName: "Bet 365", PosterID: testBetID, PosterType: "image",
+			HostName: d.Host, KioskName: d.Kiosk, City: d.City, Region: d.Region,
+			PopDatetime: time.Date(2024, month, day, 12, 0, 0, 0, time.UTC),
+			PlayCount:   plays, Value: plays * 15,
+		}
+	}
+	return []popItem{
+		row(lobby, time.July, 1, 100),
+		row(annex, time.July, 31, 50),
+		row(lobby, time.August, 15, 200),
+		row(annex, time.September, 1, 25),
+		row(lobby, time.September, 30, 75),
+		row(annex, time.October, 31, 10),
+		row(union, time.August, 2, 999),
+	}
+}
+
+func TestPopRangeStats(t *testing.T) {
+	g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: monthlyPop(), Routes: map[string]string{
+		"/pop/stats": `{"items":[{"Key":"Lorla Studio","Metric":40},{"Key":"Bet 365","PosterName":"Bet 365","Metric":12345}]}`,
+	}})
+	resp := chatOnce(t, newTestChat(g), "play count for poster Bet 365 in brt for all of 2024")
+	if resp.Meta == nil || resp.Meta.RangeStrategy != popRangeStats {
+		t.Fatalf("meta = %+v, want the stats strategy; answer %q", resp.Meta, resp.Answer)
+	}
+	if !strings.Contains(resp.Answer, "12,345 plays") || !strings.Contains(resp.Answer, "aggregate stats") {
+		t.Errorf("answer %q lacks the stats total or the strategy", resp.Answer)
+	}
+	if n := len(g.Calls("/pop?")); n != 0 {
+		t.Errorf("%d raw /pop calls for a stats answer", n)
+	}
+	stats := g.Calls("/pop/stats")
+	if len(stats) != 1 || !strings.Contains(stats[0], "from=2024-01-01") || !strings.Contains(stats[0], "to=2025-01-01") {
+		t.Errorf("stats calls %v, want one for 2024", stats)
+	}
+}
+
+func TestPopRangeChunked(t *testing.T) {
+	g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: monthlyPop()})
+	resp := chatOnce(t, newTestChat(g), "kiosk wise play count for poster Bet 365 in brt from 2024-07-01 to 2024-10-31")
+	if resp.Meta == nil || resp.Meta.RangeStrategy != popRangeChunked || resp.Meta.RangeChunks != 4 {
+		t.Fatalf("meta = %+v, want 4 chunks; answer %q", resp.Meta, resp.Answer)
+	}
+	// 100+50+200+25+75+10 in brt; Union Station is in kcmo.
+	for _, want := range []string{"460 plays", "Briggs Lobby", "375", "Briggs Annex", "85", "4 month-sized chunks"} {
+		if !strings.Contains(resp.Answer, want) {
+			t.Errorf("answer %q lacks %q", resp.Answer, want)
+		}
+	}
+	var ranges []string
+	for _, call := range g.Calls("/pop?") {
+		u, _ := url.Parse(call)
+		if r := u.Query().Get("from") + "–" + u.Query().Get("to"); len(ranges) == 0 || ranges[len(ranges)-1] != r {
+			ranges = append(ranges, r)
+		}
+	}
+	want := []string{
+		"2024-07-01T00:00:00Z–2024-08-01T00:00:00Z",
+		"2024-08-01T00:00:00Z–2024-09-01T00:00:00Z",
+		"2024-09-01T00:00:00Z–2024-10-01T00:00:00Z",
+		"2024-10-01T00:00:00Z–2024-11-01T00:00:00Z",
+	}
+	if strings.Join(ranges, " ") != strings.Join(want, " ") {
+		t.Errorf("/pop ranges %v, want %v", ranges, want)
+	}
+}
+
+func TestPopRangeRefused(t *testing.T) {
+	g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: monthlyPop()})
+	resp := chatOnce(t, newTestChat(g), "kiosk wise play count for poster Bet 365 in brt from 2023-01-01 to 2024-10-31")
+	if resp.Meta == nil || resp.Meta.RangeStrategy != popRangeRefused {
+		t.Fatalf("meta = %+v, want a refusal; answer %q", resp.Meta, resp.Answer)
+	}
+	for _, want := range []string{"too large", "May 1–Oct 31, 2024", "export"} {
+		if !strings.Contains(resp.Answer, want) {
+			t.Errorf("answer %q lacks %q", resp.Answer, want)
+		}
+	}
+	if n := len(g.Calls("/pop?")); n != 0 {
+		t.Errorf("%d /pop calls for a refused range", n)
+	}
+}
+
+func TestPopRangeMonthChunks(t *testing.T) {
+	d := func(m time.Month, day int) time.Time { return time.Date(2024, m, day, 0, 0, 0, 0, time.UTC) }
+	cases := []struct {
+		name   string
+		r      popRange
+		chunks int
+		days   int
+	}{
+		{"one month", popRange{d(10, 1), d(11, 1)}, 1, 31},
+		{"four months", popRange{d(7, 1), d(11, 1)}, 4, 123},
+		{"mid-month", popRange{d(7, 15), d(9, 10)}, 3, 57},
+		{"partial day", popRange{d(7, 1), d(7, 1).Add(time.Hour)}, 1, 1},
+	}
+	for _, tc := range cases {
+		chunks := tc.r.monthChunks()
+		if len(chunks) != tc.chunks || tc.r.days() != tc.days {
+			t.Errorf("%s: %d chunks, %d days; want %d, %d", tc.name, len(chunks), tc.r.days(), tc.chunks, tc.days)
+			continue
+		}
+		// The chunks tile the range with no gap or overlap.
+		from := tc.r.From
+		for _, c := range chunks {
+			if !c.From.Equal(from) || !c.To.After(c.From) {
+				t.Errorf("%s: chunk %v–%v does not follow %v", tc.name, c.From, c.To, from)
+			}
+			from = c.To
+		}
+		if !from.Equal(tc.r.To) {
+			t.Errorf("%s: chunks end at %v, want %v", tc.name, from, tc.r.To)
+		}
+	}
+}
+
+func TestPopRangeKeep(t *testing.T) {
+	r := popRange{From: time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)}
+	var kept []string
+	for _, it := range r.keep(monthlyPop()) {
+		kept = append(kept, it.PopDatetime.Format("01-02"))
+	}
+	if strings.Join(kept, " ") != "08-15 08-02" {
+		t.Errorf("kept %v, want only the August rows", kept)
+	}
+	if got := r.keep([]popItem{{PlayCount: 1}}); len(got) != 1 {
+		t.Error("an undated row was dropped")
+	}
+}
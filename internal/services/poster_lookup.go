@@ -0,0 +1,270 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"openai-agent-service/internal/models"
+)
+
+var (
+	// "poster id for Lorla Studio", "what is the creative uuid of Lorla Studio"
+	posterIDForRe = regexp.MustCompile(`(?i)\b(?:poster|creative)\s+(?:id|uuid)\s+(?:for|of)\s+(.+)$`)
+	// "what's the id of Lorla Studio", "what is the id for the poster Lorla Studio"
+	idOfRe = regexp.MustCompile(`(?i)^\s*what(?:'s|\s+is)\s+the\s+(?:id|uuid)\s+(?:for|of)\s+(?:the\s+)?(?:(?:poster|creative)\s+)?(.+)$`)
+	// "what poster is <uuid>", "which creative is <uuid>", "what is poster <uuid>"
+	posterIsRe = regexp.MustCompile(`(?i)\b(?:what|which)(?:'s|\s+is)?\s+(?:poster|creative)\s+(?:is\s+)?([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})\b`)
+	// Names after "id of" that are not posters.
+	idOfOtherRe = regexp.MustCompile(`(?i)^(?:the\s+)?(?:campaign|venue|device|kiosk|host|server|advertiser|project)\b`)
+)
+
+// posterIDQuery returns the poster name a message asks the id of, or the id
+// it asks the poster of.
+func posterIDQuery(msg string) (name, id string) {
+	msg = strings.TrimSpace(msg)
+	if m := posterIsRe.FindStringSubmatch(msg); m != nil {
+		return "", strings.ToLower(m[1])
+	}
+	m := posterIDForRe.FindStringSubmatch(msg)
+	if m == nil {
+		if m = idOfRe.FindStringSubmatch(msg); m == nil || idOfOtherRe.MatchString(m[1]) {
+			return "", ""
+		}
+	}
+	name = strings.Trim(strings.TrimSpace(m[1]), `'"?.,!`)
+	if looksLikeUUID(name) {
+		return "", strings.ToLower(name)
+	}
+	return name, ""
+}
+
+// posterRecord is one poster found by the id lookup.
+type posterRecord struct {
+	ID         string
+	Name       string
+	Type       string
+	CampaignID string
+	URL        string
+}
+
+func posterRecordFromCreative(m map[string]any) posterRecord {
+	return posterRecord{
+		ID:         anyString(m, "id"),
+		Name:       anyString(m, "name", "file_name"),
+		Type:       anyString(m, "type", "creative_type", "media_type", "poster_type"),
+		CampaignID: anyString(m, "campaign_id", "campaignId"),
+		URL:        anyString(m, "url", "file_url"),
+	}
+}
+
+func (r posterRecord) describe() string {
+	extras := make([]string, 0, 3)
+	if r.Type != "" {
+		extras = append(extras, r.Type)
+	}
+	if r.CampaignID != "" {
+		extras = append(extras, "campaign "+r.CampaignID)
+	}
+	if r.URL != "" {
+		extras = append(extras, r.URL)
+	}
+	if len(extras) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(extras, ", ") + ")"
+}
+
+// handlePosterIDLookup answers "poster id for <name>" with the poster's id,
+// listing every distinct id when the name is shared, and "what poster is
+// <uuid>" with the poster's name, type, campaign and file URL.
+func (c *ChatService) handlePosterIDLookup(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	name, id := posterIDQuery(req.Message)
+	if name == "" && id == "" {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+	var resp models.ChatResponse
+	if id != "" {
+		resp = c.posterByID(ctx, req, id)
+	} else {
+		resp = c.posterIDsByName(ctx, req, name)
+	}
+	if onToken != nil {
+		onToken(resp.Answer)
+	}
+	return resp, true, nil
+}
+
+// searchCreatives runs /ads/creatives/search and returns its rows.
+func (c *ChatService) searchCreatives(ctx context.Context, query string) ([]map[string]any, models.Step, error) {
+	status, body, err := c.Gateway.Get(ctx, "/ads/creatives/search?query="+urlEscape(query))
+	step := models.Step{Tool: "adsCreativesSearch", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+		return nil, step, err
+	}
+	step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	if status < 200 || status >= 300 {
+		return nil, step, fmt.Errorf("creative search failed with status %d", status)
+	}
+	rows := make([]map[string]any, 0, 8)
+	for _, it := range parseRows(body) {
+		if m, ok := it.(map[string]any); ok {
+			rows = append(rows, m)
+		}
+	}
+	return rows, step, nil
+}
+
+// popPosterRows fetches one page of /pop rows for filter.
+func (c *ChatService) popPosterRows(ctx context.Context, filter string) ([]popItem, models.Step, error) {
+	resp, step, err := c.fetchPopPage(ctx, filter, 1, c.newPopPager().PageSize)
+	var pe *popPageError
+	if errors.As(err, &pe) && pe.Err == nil {
+		if pe.Status != 0 {
+			return nil, step, fmt.Errorf("POP list failed with status %d", pe.Status)
+		}
+		return nil, step, fmt.Errorf("POP list response could not be parsed")
+	}
+	if err != nil {
+		return nil, step, err
+	}
+	return resp.Items, step, nil
+}
+
+func (c *ChatService) posterIDsByName(ctx context.Context, req models.ChatRequest, name string) models.ChatResponse {
+	conversationID := strings.TrimSpace(req.ConversationID)
+	steps := make([]models.Step, 0, 2)
+	found := map[string]posterRecord{}
+
+	rows, step, err := c.searchCreatives(ctx, name)
+	steps = append(steps, step)
+	if err != nil {
+		return models.ChatResponse{Answer: "Failed to search creatives: " + err.Error() + ".", Steps: steps}
+	}
+	// Exact names win; otherwise any creative whose name contains the query.
+	for _, exact := range []bool{true, false} {
+		for _, m := range rows {
+			r := posterRecordFromCreative(m)
+			if r.ID == "" {
+				continue
+			}
+			if exact && !strings.EqualFold(r.Name, name) || !exact && !strings.Contains(strings.ToLower(r.Name), strings.ToLower(name)) {
+				continue
+			}
+			found[r.ID] = r
+		}
+		if len(found) > 0 {
+			break
+		}
+	}
+
+	if len(found) == 0 {
+		// Posters outside the creative library (e.g. programmatic ones) only
+		// show up in POP rows.
+		items, step, err := c.popPosterRows(ctx, "poster_name="+urlEscape(name))
+		steps = append(steps, step)
+		if err != nil {
+			return models.ChatResponse{Answer: "Failed to fetch POP data: " + err.Error() + ".", Steps: steps}
+		}
+		for _, it := range items {
+			id := strings.TrimSpace(it.PosterID)
+			if id == "" || !strings.EqualFold(strings.TrimSpace(it.PosterName), name) {
+				continue
+			}
+			if _, ok := found[id]; !ok {
+				found[id] = posterRecord{ID: id, Name: strings.TrimSpace(it.PosterName), Type: strings.TrimSpace(it.PosterType), URL: strings.TrimSpace(it.Url)}
+			}
+		}
+	}
+
+	if len(found) == 0 {
+		return models.ChatResponse{Answer: fmt.Sprintf("No poster named '%s' was found in the creative library or in POP data.", name), Steps: steps}
+	}
+	records := make([]posterRecord, 0, len(found))
+	for _, r := range found {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		return records[i].ID < records[j].ID
+	})
+
+	if len(records) == 1 {
+		r := records[0]
+		if conversationID != "" {
+			c.updateConversationPoster(conversationID, r.Name, "", "")
+			c.updateConversationPosterID(conversationID, r.ID)
+		}
+		return models.ChatResponse{Answer: fmt.Sprintf("The poster id for '%s' is %s%s.", r.Name, r.ID, r.describe()), Steps: steps}
+	}
+
+	lines := make([]string, 0, len(records)+1)
+	lines = append(lines, fmt.Sprintf("'%s' matches %d posters:", name, len(records)))
+	listed := make([]listedEntity, 0, len(records))
+	for i, r := range records {
+		lines = append(lines, fmt.Sprintf("%d. %s — %s%s", i+1, r.Name, r.ID, r.describe()))
+		listed = append(listed, listedEntity{ID: r.ID, Name: r.Name})
+	}
+	c.rememberList(conversationID, listKindPoster, listed)
+	return models.ChatResponse{Answer: strings.Join(lines, "\n"), Steps: steps}
+}
+
+func (c *ChatService) posterByID(ctx context.Context, req models.ChatRequest, id string) models.ChatResponse {
+	conversationID := strings.TrimSpace(req.ConversationID)
+	steps := make([]models.Step, 0, 2)
+
+	var rec posterRecord
+	rows, step, err := c.searchCreatives(ctx, id)
+	steps = append(steps, step)
+	if err == nil {
+		for _, m := range rows {
+			if r := posterRecordFromCreative(m); strings.EqualFold(r.ID, id) {
+				rec = r
+				break
+			}
+		}
+	}
+	if rec.ID == "" {
+		items, step, perr := c.popPosterRows(ctx, "poster_id="+urlEscape(id))
+		steps = append(steps, step)
+		if perr != nil && err != nil {
+			return models.ChatResponse{Answer: "Failed to look up the poster: " + perr.Error() + ".", Steps: steps}
+		}
+		for _, it := range items {
+			if strings.EqualFold(strings.TrimSpace(it.PosterID), id) && strings.TrimSpace(it.PosterName) != "" {
+				rec = posterRecord{ID: id, Name: strings.TrimSpace(it.PosterName), Type: strings.TrimSpace(it.PosterType), URL: strings.TrimSpace(it.Url)}
+				break
+			}
+		}
+	}
+	if rec.ID == "" {
+		return models.ChatResponse{Answer: fmt.Sprintf("No poster or creative with id %s was found.", id), Steps: steps}
+	}
+	if conversationID != "" {
+		c.updateConversationPoster(conversationID, rec.Name, "", "")
+		c.updateConversationPosterID(conversationID, rec.ID)
+		if rec.CampaignID != "" {
+			c.updateConversationCampaignID(conversationID, rec.CampaignID)
+		}
+	}
+	lines := []string{fmt.Sprintf("Poster %s is '%s'.", rec.ID, rec.Name)}
+	if rec.Type != "" {
+		lines = append(lines, "Type: "+rec.Type)
+	}
+	if rec.CampaignID != "" {
+		lines = append(lines, "Campaign: "+rec.CampaignID)
+	}
+	if rec.URL != "" {
+		lines = append(lines, "File: "+rec.URL)
+	}
+	return models.ChatResponse{Answer: strings.Join(lines, "\n"), Steps: steps}
+}
@@ -0,0 +1,286 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// ErrQueryFailed is returned by Query when the gateway could not answer; it
+// wraps the gateway error, so ErrGatewayUnavailable and ErrGatewayAuth can
+// still be told apart.
+var ErrQueryFailed = errors.New("the data gateway could not answer the query")
+
+// QueryIntents are the intents POST /query answers.
+var QueryIntents = []string{"poster_plays", "kiosk_count", "top_posters", "device_status", "campaign_impressions"}
+
+// QueryError is a query that cannot be run as asked. Fields maps each
+// offending field ("intent", "params.city") to what is wrong with it.
+type QueryError struct {
+	Fields map[string]string
+}
+
+func (e *QueryError) Error() string {
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+": "+e.Fields[name])
+	}
+	return "invalid query: " + strings.Join(parts, "; ")
+}
+
+// queryScopeSource marks scope values a /query request passed.
+const queryScopeSource = "params"
+
+// Query answers a numeric question from the same fetch and aggregation the
+// chat handlers answer it from, without their prose and without OpenAI.
+func (c *ChatService) Query(ctx context.Context, req models.QueryRequest) (models.QueryResult, error) {
+	intent := strings.ToLower(strings.TrimSpace(req.Intent))
+	p := req.Params
+	p.Poster = strings.TrimSpace(p.Poster)
+	p.City = strings.ToLower(strings.TrimSpace(p.City))
+	p.Region = strings.ToLower(strings.TrimSpace(p.Region))
+	p.CampaignID = strings.TrimSpace(p.CampaignID)
+	p.Metric = strings.ToLower(strings.TrimSpace(p.Metric))
+
+	fields := map[string]string{}
+	need := func(name, v, why string) {
+		if v == "" {
+			fields["params."+name] = why
+		}
+	}
+	needScope := func() {
+		if p.City == "" && p.Region == "" {
+			fields["params.city"] = "city or region is required"
+		}
+	}
+	switch intent {
+	case "poster_plays":
+		need("poster", p.Poster, "required")
+		needScope()
+	case "kiosk_count":
+		need("city", p.City, "required")
+	case "device_status":
+		needScope()
+	case "top_posters":
+		needScope()
+		switch p.Metric {
+		case "":
+			p.Metric = "plays"
+		case "plays", "clicks":
+		default:
+			fields["params.metric"] = "must be plays or clicks"
+		}
+	case "campaign_impressions":
+		if p.CampaignID == "" {
+			fields["params.campaign_id"] = "required"
+		} else if !looksLikeUUID(p.CampaignID) {
+			fields["params.campaign_id"] = "must be a campaign UUID"
+		}
+	case "":
+		fields["intent"] = "required"
+	default:
+		fields["intent"] = "unknown intent; use one of " + strings.Join(QueryIntents, ", ")
+	}
+	window, err := parseQueryWindow(p.From, p.To)
+	if err != nil {
+		fields["params.from"] = err.Error()
+	}
+	limit := c.limits().DisplayTopN
+	switch maxRows := c.limits().FullListMax; {
+	case p.Limit < 0:
+		fields["params.limit"] = "must be positive"
+	case p.Limit > maxRows:
+		fields["params.limit"] = fmt.Sprintf("must be at most %d", maxRows)
+	case p.Limit > 0:
+		limit = p.Limit
+	}
+	if len(fields) > 0 {
+		return models.QueryResult{}, &QueryError{Fields: fields}
+	}
+	if c.Gateway == nil {
+		return models.QueryResult{}, fmt.Errorf("%w: tool gateway is not configured", ErrQueryFailed)
+	}
+
+	res := models.QueryResult{Intent: intent, Totals: map[string]float64{}, Window: window}
+	for _, sp := range []struct{ name, value string }{{"poster", p.Poster}, {"campaign_id", p.CampaignID}, {"region", p.Region}, {"city", p.City}} {
+		if sp.value != "" {
+			res.Scope = append(res.Scope, models.ScopeParam{Name: sp.name, Value: sp.value, Source: queryScopeSource})
+		}
+	}
+	switch intent {
+	case "poster_plays":
+		err = c.queryPosterPlays(ctx, p, limit, &res)
+	case "kiosk_count":
+		err = c.queryKioskCount(ctx, p, &res)
+	case "device_status":
+		err = c.queryDeviceStatus(ctx, p, &res)
+	case "top_posters":
+		err = c.queryTopPosters(ctx, p, limit, &res)
+	case "campaign_impressions":
+		err = c.queryCampaignImpressions(ctx, p, limit, &res)
+	}
+	if err != nil {
+		return res, err
+	}
+	if res.Meta != nil && res.Meta.Truncated {
+		res.Truncated = true
+	}
+	return res, nil
+}
+
+// parseQueryWindow reads from and to as RFC 3339 times or YYYY-MM-DD dates;
+// a date to includes its whole day. Both or neither must be set.
+func parseQueryWindow(from, to string) (*models.QueryWindow, error) {
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if from == "" && to == "" {
+		return nil, nil
+	}
+	if from == "" || to == "" {
+		return nil, errors.New("from and to must be given together")
+	}
+	parse := func(v string, end bool) (time.Time, bool) {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t.UTC(), true
+		}
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, true
+	}
+	f, ok1 := parse(from, false)
+	t, ok2 := parse(to, true)
+	if !ok1 || !ok2 {
+		return nil, errors.New("from and to must be RFC 3339 times or YYYY-MM-DD dates")
+	}
+	if !f.Before(t) {
+		return nil, errors.New("from must be before to")
+	}
+	return &models.QueryWindow{From: f, To: t}, nil
+}
+
+// queryFailure wraps the error of the last gateway call in steps.
+func queryFailure(steps []models.Step, err error) error {
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrQueryFailed, err)
+	}
+	last := models.Step{}
+	if len(steps) > 0 {
+		last = steps[len(steps)-1]
+	}
+	return fmt.Errorf("%w: %s returned status %d", ErrQueryFailed, last.Tool, last.Status)
+}
+
+func (c *ChatService) queryPosterPlays(ctx context.Context, p models.QueryParams, limit int, res *models.QueryResult) error {
+	fromRFC, toRFC := "", ""
+	if res.Window != nil {
+		fromRFC, toRFC = res.Window.From.Format(time.RFC3339), res.Window.To.Format(time.RFC3339)
+	}
+	items, steps, pager, err := c.fetchPosterPlays(ctx, p.Poster, p.City, p.Region, fromRFC, toRFC)
+	res.Steps = steps
+	if err != nil {
+		return queryFailure(steps, err)
+	}
+	plays, seconds := sumPopPlays(items)
+	res.Totals["plays"] = float64(plays)
+	res.Totals["seconds"] = float64(seconds)
+	kp := playsByKiosk(items)
+	for i, r := range kp.Rows {
+		if i >= limit {
+			res.Truncated = true
+			break
+		}
+		res.Rows = append(res.Rows, models.QueryRow{Key: r.Key, Values: map[string]float64{"plays": float64(r.Plays), "seconds": float64(r.Seconds)}})
+	}
+	res.Meta = pager.meta()
+	return nil
+}
+
+func (c *ChatService) queryKioskCount(ctx context.Context, p models.QueryParams, res *models.QueryResult) error {
+	kc := c.fetchKioskCount(ctx, p.City, p.Region)
+	res.Steps = []models.Step{kc.Step}
+	if kc.Err != nil || kc.Step.Status < 200 || kc.Step.Status >= 300 {
+		return queryFailure(res.Steps, kc.Err)
+	}
+	res.Totals["kiosks"] = kc.Total
+	if p.Region != "" {
+		res.Totals["region_kiosks"] = kc.RegionCount
+	}
+	return nil
+}
+
+// queryDeviceStatus leaves Totals empty when the gateway lists no kiosks for
+// the scope.
+func (c *ChatService) queryDeviceStatus(ctx context.Context, p models.QueryParams, res *models.QueryResult) error {
+	ds := c.fetchDeviceStatus(ctx, p.City, p.Region)
+	res.Steps = []models.Step{ds.Step}
+	if !ds.ok() {
+		return queryFailure(res.Steps, ds.Err)
+	}
+	if ds.Matched {
+		res.Totals["online"] = float64(ds.Online)
+		res.Totals["offline"] = float64(ds.Offline)
+		res.Totals["total"] = float64(ds.Total)
+	}
+	return nil
+}
+
+func (c *ChatService) queryTopPosters(ctx context.Context, p models.QueryParams, limit int, res *models.QueryResult) error {
+	// One row past the limit tells whether the ranking was cut.
+	q := topPostersQuery{City: p.City, Region: p.Region, Metric: p.Metric, Limit: limit + 1}
+	if res.Window != nil {
+		q.From, q.To = res.Window.From, res.Window.To
+	}
+	top := c.fetchTopPosters(ctx, q)
+	res.Steps = []models.Step{top.Step}
+	if top.Err != nil || top.Step.Status < 200 || top.Step.Status >= 300 {
+		return queryFailure(res.Steps, top.Err)
+	}
+	for i, r := range top.Rows {
+		if i >= limit {
+			res.Truncated = true
+			break
+		}
+		res.Rows = append(res.Rows, models.QueryRow{Key: r.Key, Label: r.Name, Values: map[string]float64{p.Metric: r.Value}})
+	}
+	return nil
+}
+
+func (c *ChatService) queryCampaignImpressions(ctx context.Context, p models.QueryParams, limit int, res *models.QueryResult) error {
+	ci := c.fetchCampaignImpressions(ctx, p.CampaignID)
+	res.Steps = ci.Steps
+	if !ci.AdsOK && !ci.PopOK {
+		return queryFailure(ci.Steps[:ci.AdsIdx+1], ci.AdsErr)
+	}
+	var failures partialFailures
+	if !ci.AdsOK {
+		failures.add("the ads impressions total", ci.Steps, ci.AdsIdx, "")
+	}
+	if ci.popFailed() {
+		failures.add("the POP poster breakdown", ci.Steps, ci.PopIdx, "")
+	}
+	total, _ := ci.total()
+	res.Totals["impressions"] = float64(total)
+	for i, pi := range ci.Pop.Posters {
+		if i >= limit {
+			res.Truncated = true
+			break
+		}
+		res.Rows = append(res.Rows, models.QueryRow{Key: pi.PosterID, Label: pi.PosterName, Values: map[string]float64{"impressions": float64(pi.Impressions), "play_time": float64(pi.PlayTime)}})
+	}
+	res.Meta = failures.meta(nil)
+	return nil
+}
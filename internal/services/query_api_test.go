@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"openai-agent-service/internal/models"
+)
+
+const testCampaignID = "3f1e2d3c-4b5a-4c6d-8e7f-9a0b1c2d3e4f"
+
+// queryRoutes are the non-/pop gateway answers the query intents read.
+func queryRoutes() map[string]string {
+	return map[string]string{
+		"/metrics/servers/status/city": `{"data":[{"city":"moco","online":5,"offline":2,"total":7},{"city":"kc","online":1,"offline":0,"total":1}]}`,
+		"/pop/stats": `{"items":[{"Key":"` + testBetID + `","PosterName":"Bet 365","Metric":305},` +
+			`{"Key":"` + testLorlaID + `","PosterName":"Lorla Studio","Metric":55}]}`,
+		"/ads/campaigns/" + testCampaignID + "/impressions": `{"data":{"impressions":900}}`,
+		"/pop/impressions": `{"campaign_id":"` + testCampaignID + `","impressions":1000,"posters":[` +
+			`{"poster_id":"` + testLorlaID + `","poster_name":"Lorla Studio","impressions":300,"play_time":900},` +
+			`{"poster_id":"` + testBetID + `","poster_name":"Bet 365","impressions":700,"play_time":3600}]}`,
+	}
+}
+
+func newQueryGateway(t *testing.T) *fakeGateway {
+	return newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop(), Routes: queryRoutes()})
+}
+
+// Each intent's contract: the gateway calls it makes and the totals and
+// rows it returns for them.
+func TestQueryIntents(t *testing.T) {
+	row := func(key, label string, values map[string]float64) models.QueryRow {
+		return models.QueryRow{Key: key, Label: label, Values: values}
+	}
+	cases := []struct {
+		name      string
+		req       models.QueryRequest
+		calls     []string
+		totals    map[string]float64
+		rows      []models.QueryRow
+		truncated bool
+	}{
+		{
+			name: "poster_plays",
+			req:  models.QueryRequest{Intent: "poster_plays", Params: models.QueryParams{Poster: "Bet 365", Region: "BRT"}},
+			calls: []string{
+				"/pop?poster_name=Bet+365&region=brt&page=1&page_size=2",
+				"/pop?poster_name=Bet+365&region=brt&page=2&page_size=2",
+			},
+			totals: map[string]float64{"plays": 305, "seconds": 4575},
+			rows: []models.QueryRow{
+				row("Briggs Lobby", "", map[string]float64{"plays": 260, "seconds": 3900}),
+				row("Briggs Annex", "", map[string]float64{"plays": 45, "seconds": 675}),
+			},
+		},
+		{
+			name: "poster_plays in a window",
+			req:  models.QueryRequest{Intent: "poster_plays", Params: models.QueryParams{Poster: "Bet 365", Region: "brt", From: "2024-10-01", To: "2024-10-10", Limit: 1}},
+			calls: []string{
+				"/pop?poster_name=Bet+365&region=brt&from=2024-10-01T00%3A00%3A00Z&to=2024-10-11T00%3A00%3A00Z&page=1&page_size=2",
+				"/pop?poster_name=Bet+365&region=brt&from=2024-10-01T00%3A00%3A00Z&to=2024-10-11T00%3A00%3A00Z&page=2&page_size=2",
+			},
+			totals:    map[string]float64{"plays": 245, "seconds": 3675},
+			rows:      []models.QueryRow{row("Briggs Lobby", "", map[string]float64{"plays": 200, "seconds": 3000})},
+			truncated: true,
+		},
+		{
+			name:   "kiosk_count",
+			req:    models.QueryRequest{Intent: "kiosk_count", Params: models.QueryParams{City: "moco", Region: "brt"}},
+			calls:  []string{"/ads/devices/counts/regions?city=moco"},
+			totals: map[string]float64{"kiosks": 2, "region_kiosks": 2},
+		},
+		{
+			name:   "device_status",
+			req:    models.QueryRequest{Intent: "device_status", Params: models.QueryParams{City: "moco"}},
+			calls:  []string{"/metrics/servers/status/city?city=moco"},
+			totals: map[string]float64{"online": 5, "offline": 2, "total": 7},
+		},
+		{
+			name:   "top_posters",
+			req:    models.QueryRequest{Intent: "top_posters", Params: models.QueryParams{Region: "brt", Limit: 1}},
+			calls:  []string{"/pop/stats?group_by=poster&metric=plays&order=top&limit=2&region=brt"},
+			totals: map[string]float64{},
+			rows:   []models.QueryRow{row(testBetID, "Bet 365", map[string]float64{"plays": 305})},
+			// The second poster tells the ranking was cut at the limit.
+			truncated: true,
+		},
+		{
+			name:   "campaign_impressions",
+			req:    models.QueryRequest{Intent: "campaign_impressions", Params: models.QueryParams{CampaignID: testCampaignID}},
+			calls:  []string{"/ads/campaigns/" + testCampaignID + "/impressions", "/pop/impressions?campaign_id=" + testCampaignID},
+			totals: map[string]float64{"impressions": 1000},
+			rows: []models.QueryRow{
+				row(testBetID, "Bet 365", map[string]float64{"impressions": 700, "play_time": 3600}),
+				row(testLorlaID, "Lorla Studio", map[string]float64{"impressions": 300, "play_time": 900}),
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newQueryGateway(t)
+			res, err := newTestChat(g).Query(context.Background(), tc.req)
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			if got := g.Calls("/"); !reflect.DeepEqual(got, tc.calls) {
+				t.Errorf("gateway calls\n got %v\nwant %v", got, tc.calls)
+			}
+			if !reflect.DeepEqual(res.Totals, tc.totals) {
+				t.Errorf("totals = %v, want %v", res.Totals, tc.totals)
+			}
+			if !reflect.DeepEqual(res.Rows, tc.rows) {
+				t.Errorf("rows\n got %+v\nwant %+v", res.Rows, tc.rows)
+			}
+			if res.Truncated != tc.truncated {
+				t.Errorf("truncated = %v, want %v", res.Truncated, tc.truncated)
+			}
+			if len(res.Steps) != len(tc.calls) {
+				t.Errorf("%d steps, want one per gateway call (%d)", len(res.Steps), len(tc.calls))
+			}
+		})
+	}
+}
+
+func TestQueryRejectsInvalidParams(t *testing.T) {
+	cases := []struct {
+		req   models.QueryRequest
+		field string
+	}{
+		{models.QueryRequest{}, "intent"},
+		{models.QueryRequest{Intent: "weather"}, "intent"},
+		{models.QueryRequest{Intent: "poster_plays", Params: models.QueryParams{Region: "brt"}}, "params.poster"},
+		{models.QueryRequest{Intent: "poster_plays", Params: models.QueryParams{Poster: "Bet 365"}}, "params.city"},
+		{models.QueryRequest{Intent: "kiosk_count"}, "params.city"},
+		{models.QueryRequest{Intent: "device_status"}, "params.city"},
+		{models.QueryRequest{Intent: "top_posters", Params: models.QueryParams{City: "moco", Metric: "minutes"}}, "params.metric"},
+		{models.QueryRequest{Intent: "campaign_impressions", Params: models.QueryParams{CampaignID: "spring"}}, "params.campaign_id"},
+		{models.QueryRequest{Intent: "poster_plays", Params: models.QueryParams{Poster: "Bet 365", City: "moco", From: "2024-10-01"}}, "params.from"},
+		{models.QueryRequest{Intent: "top_posters", Params: models.QueryParams{City: "moco", Limit: -1}}, "params.limit"},
+	}
+	for _, tc := range cases {
+		g := newQueryGateway(t)
+		_, err := newTestChat(g).Query(context.Background(), tc.req)
+		var qe *QueryError
+		if !errors.As(err, &qe) || qe.Fields[tc.field] == "" {
+			t.Errorf("Query(%+v) = %v, want a QueryError on %s", tc.req, err, tc.field)
+		}
+		if calls := g.Calls("/"); len(calls) > 0 {
+			t.Errorf("Query(%+v) called the gateway: %v", tc.req, calls)
+		}
+	}
+}
+
+func TestQueryGatewayFailure(t *testing.T) {
+	g := newFakeGateway(t, &fakeGateway{Pop: testPop(), PopStatus: map[int]int{1: 502}})
+	_, err := newTestChat(g).Query(context.Background(), models.QueryRequest{Intent: "poster_plays", Params: models.QueryParams{Poster: "Bet 365", City: "moco"}})
+	if !errors.Is(err, ErrQueryFailed) {
+		t.Fatalf("Query = %v, want ErrQueryFailed", err)
+	}
+}
+
+// /chat and /query answer each intent from the same fetch, so the figures
+// chat states must be the totals query returns.
+func TestChatAndQueryTotalsAgree(t *testing.T) {
+	cases := []struct {
+		intent string
+		msg    string
+		params models.QueryParams
+		// figures renders the query result as chat states it.
+		figures func(models.QueryResult) []string
+	}{
+		{
+			intent: "poster_plays",
+			msg:    "play count for poster Bet 365 in brt",
+			params: models.QueryParams{Poster: "Bet 365", Region: "brt"},
+			figures: func(r models.QueryResult) []string {
+				return []string{formatThousands(int64(r.Totals["plays"])) + " plays"}
+			},
+		},
+		{
+			intent: "kiosk_count",
+			msg:    "how many kiosks in moco",
+			params: models.QueryParams{City: "moco"},
+			figures: func(r models.QueryResult) []string {
+				return []string{"There are " + formatDecimal(r.Totals["kiosks"], 0) + " kiosks"}
+			},
+		},
+		{
+			intent: "device_status",
+			msg:    "device status in moco",
+			params: models.QueryParams{City: "moco"},
+			figures: func(r models.QueryResult) []string {
+				return []string{
+					formatDecimal(r.Totals["offline"], 0) + " offline",
+					formatDecimal(r.Totals["online"], 0) + " online",
+					"total " + formatDecimal(r.Totals["total"], 0) + " devices",
+				}
+			},
+		},
+		{
+			intent: "top_posters",
+			msg:    "top posters by plays in brt",
+			params: models.QueryParams{Region: "brt"},
+			figures: func(r models.QueryResult) []string {
+				var out []string
+				for i, row := range r.Rows {
+					out = append(out, formatDecimal(float64(i+1), 0)+". "+row.Label+" — "+formatDecimal(row.Values["plays"], 0)+" plays")
+				}
+				return out
+			},
+		},
+		{
+			// Chat reaches campaign impressions as a follow-up, which
+			// forwards this message to handleCampaignImpressions.
+			intent: "campaign_impressions",
+			msg:    "impressions for campaign " + testCampaignID,
+			params: models.QueryParams{CampaignID: testCampaignID},
+			figures: func(r models.QueryResult) []string {
+				out := []string{formatThousands(int64(r.Totals["impressions"])) + " total"}
+				for _, row := range r.Rows {
+					out = append(out, row.Label+" — "+formatThousands(int64(row.Values["impressions"]))+" impressions")
+				}
+				return out
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.intent, func(t *testing.T) {
+			res, err := newTestChat(newQueryGateway(t)).Query(context.Background(), models.QueryRequest{Intent: tc.intent, Params: tc.params})
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			c := newTestChat(newQueryGateway(t))
+			var answer string
+			if tc.intent == "campaign_impressions" {
+				resp, _, err := c.handleCampaignImpressions(context.Background(), models.ChatRequest{Message: tc.msg}, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				answer = resp.Answer
+			} else {
+				answer = chatOnce(t, c, tc.msg).Answer
+			}
+			figures := tc.figures(res)
+			if len(figures) == 0 {
+				t.Fatal("query returned nothing to compare")
+			}
+			for _, f := range figures {
+				if !strings.Contains(answer, f) {
+					t.Errorf("chat answer\n%s\nlacks the query figure %q", answer, f)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,369 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"openai-agent-service/internal/models"
+)
+
+// SavedQueryStore persists named questions; implemented by
+// store.PostgresStore.
+type SavedQueryStore interface {
+	PutSavedQuery(ctx context.Context, q models.SavedQuery) (models.SavedQuery, error)
+	ListSavedQueries(ctx context.Context, ownerKey string) ([]models.SavedQuery, error)
+	DeleteSavedQuery(ctx context.Context, ownerKey, name string) error
+}
+
+// ErrSavedQueryNotFound is returned by RunSavedQuery for an unknown name.
+var ErrSavedQueryNotFound = errors.New("saved query not found")
+
+const (
+	maxSavedQueries      = 50
+	maxSavedQueryNameLen = 60
+)
+
+// askedQuestion is the last question answered in a conversation, kept so
+// "save this as ..." can store how it was interpreted. It is replaced, never
+// modified, so conversationState.clone can share it.
+type askedQuestion struct {
+	Message string
+	Handler string
+	Slots   map[string]string
+}
+
+// answerRoute records which deterministic handler answered a request; an
+// empty handler means the OpenAI tool loop did. owner is the caller's API
+// key, for handlers that keep per-owner results.
+type answerRoute struct {
+	handler string
+	owner   string
+	// scope is what the interpretation header read the request as.
+	scope []models.ScopeParam
+}
+
+type answerRouteKey struct{}
+
+func withAnswerRoute(ctx context.Context) context.Context {
+	return context.WithValue(ctx, answerRouteKey{}, &answerRoute{})
+}
+
+func answerRouteFrom(ctx context.Context) *answerRoute {
+	r, _ := ctx.Value(answerRouteKey{}).(*answerRoute)
+	return r
+}
+
+// metaHandlers answer questions about the conversation itself; they are never
+// the question "save this as ..." refers to.
+var metaHandlers = map[string]bool{
+	"handleForgetContext":       true,
+	"handleMemoryProvenance":    true,
+	"handleSavedQueries":        true,
+	"handleConversationSummary": true,
+	// Device commands need a fresh confirmation and are never saved.
+	"handleDeviceCommand": true,
+	// Notes are written once; re-running "add a note" would duplicate it.
+	"handleDeviceNotes": true,
+	// Alias commands change the caller's nicknames, not a question.
+	"handleEntityAliases": true,
+	// Threshold commands change the caller's settings, not a question.
+	"handleTelemetryThresholds": true,
+}
+
+var (
+	savedQuerySaveRe      = regexp.MustCompile(`^(?:please\s+)?save\s+(?:(?:this|that|it)\s+)?(?:(?:the\s+)?(?:last|previous)\s+)?(?:(?:question|query)\s+)?as\s+(.+)$`)
+	savedQueryOverwriteRe = regexp.MustCompile(`^(?:yes,?\s+)?overwrite\s+(?:my\s+|the\s+)?(?:saved\s+query\s+)?(.+)$`)
+	savedQueryDeleteRe    = regexp.MustCompile(`^(?:please\s+)?(?:delete|remove)\s+(?:my\s+|the\s+)?saved\s+query\s+(.+)$`)
+	savedQueryConfirmRe   = regexp.MustCompile(`^confirm\s+(?:delete|deletion\s+of)\s+(?:my\s+|the\s+)?(?:saved\s+query\s+)?(.+)$`)
+	savedQueryRunRe       = regexp.MustCompile(`^(?:please\s+)?(?:run|re-?run|execute)\s+(my\s+|the\s+)?(saved\s+query\s+)?(.+)$`)
+	savedQueryNameTrimRe  = regexp.MustCompile(`^["'“‘]+|["'”’.!?]+$`)
+)
+
+// NormalizeQueryName folds case, quotes and spacing so "Morning  Report" and
+// "morning report" name the same saved query.
+func NormalizeQueryName(name string) string {
+	name = savedQueryNameTrimRe.ReplaceAllString(strings.TrimSpace(strings.ToLower(name)), "")
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// isMetaQuestion reports whether msg is a saved-query or forget command.
+func isMetaQuestion(msg string) bool {
+	msgLower := strings.ToLower(strings.TrimSpace(msg))
+	if _, ok := parseForgetRequest(msgLower); ok {
+		return true
+	}
+	if parseSummaryRequest(msgLower) {
+		return true
+	}
+	for _, re := range []*regexp.Regexp{savedQuerySaveRe, savedQueryOverwriteRe, savedQueryDeleteRe, savedQueryConfirmRe} {
+		if re.MatchString(msgLower) {
+			return true
+		}
+	}
+	if m := savedQueryRunRe.FindStringSubmatch(msgLower); m != nil && (m[1] != "" || m[2] != "") {
+		return true
+	}
+	return strings.Contains(msgLower, "saved quer")
+}
+
+// stateSlots is the remembered context a question was answered with.
+func stateSlots(st *conversationState) map[string]string {
+	slots := map[string]string{}
+	for k, v := range map[string]string{
+		"city":          st.City,
+		"region":        st.Region,
+		"host":          st.Host,
+		"poster_name":   st.PosterName,
+		"poster_id":     st.PosterID,
+		"poster_city":   st.PosterCity,
+		"poster_region": st.PosterRegion,
+		"campaign_id":   st.CampaignID,
+		"unit":          st.Unit,
+	} {
+		if strings.TrimSpace(v) != "" {
+			slots[k] = v
+		}
+	}
+	if st.VenueID > 0 {
+		slots["venue_id"] = strconv.Itoa(st.VenueID)
+	}
+	if len(slots) == 0 {
+		return nil
+	}
+	return slots
+}
+
+// seedSlots replaces the conversation's remembered context with the context a
+// saved query was answered with, so follow-up phrasing in the saved question
+// ("that poster") resolves the same way in any conversation.
+func (c *ChatService) seedSlots(conversationID string, slots map[string]string) {
+	if conversationID == "" || len(slots) == 0 {
+		return
+	}
+	c.updateConversationState(conversationID, func(st *conversationState) {
+		st.City, st.Region, st.Host = slots["city"], slots["region"], slots["host"]
+		st.PosterName, st.PosterID = slots["poster_name"], slots["poster_id"]
+		st.PosterCity, st.PosterRegion = slots["poster_city"], slots["poster_region"]
+		st.CampaignID, st.Unit = slots["campaign_id"], slots["unit"]
+		st.VenueID, _ = strconv.Atoi(slots["venue_id"])
+	})
+}
+
+// recordQuestion remembers the question just answered for "save this as".
+func (c *ChatService) recordQuestion(ctx context.Context, conversationID string, req models.ChatRequest) {
+	handler := ""
+	if r := answerRouteFrom(ctx); r != nil {
+		handler = r.handler
+	}
+	if metaHandlers[handler] || isMetaQuestion(req.Message) {
+		return
+	}
+	c.updateConversationState(conversationID, func(st *conversationState) {
+		st.LastQuestion = &askedQuestion{Message: strings.TrimSpace(req.Message), Handler: handler, Slots: stateSlots(st)}
+	})
+}
+
+// lastQuestion is the question "save this" refers to: the recorded one, or
+// after a restart the previous non-command user message with no
+// interpretation attached.
+func (c *ChatService) lastQuestion(ctx context.Context, ownerKey, conversationID string) *askedQuestion {
+	if st := c.getConversationState(conversationID); st != nil && st.LastQuestion != nil {
+		return st.LastQuestion
+	}
+	if c.Store == nil {
+		return nil
+	}
+	msgs, err := c.Store.ListMessages(ctx, ownerKey, conversationID, 20)
+	if err != nil {
+		return nil
+	}
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role == "user" && strings.TrimSpace(msgs[i].Content) != "" && !isMetaQuestion(msgs[i].Content) {
+			return &askedQuestion{Message: strings.TrimSpace(msgs[i].Content)}
+		}
+	}
+	return nil
+}
+
+func (c *ChatService) findSavedQuery(ctx context.Context, ownerKey, name string) (models.SavedQuery, []models.SavedQuery, bool, error) {
+	if c.Queries == nil {
+		return models.SavedQuery{}, nil, false, errors.New("saved queries are not configured")
+	}
+	all, err := c.Queries.ListSavedQueries(ctx, ownerKey)
+	if err != nil {
+		return models.SavedQuery{}, nil, false, err
+	}
+	name = NormalizeQueryName(name)
+	for _, q := range all {
+		if q.Name == name {
+			return q, all, true, nil
+		}
+	}
+	return models.SavedQuery{}, all, false, nil
+}
+
+// resolveSavedQueryRun looks up the saved query a "run <name>" message names.
+func (c *ChatService) resolveSavedQueryRun(ctx context.Context, ownerKey, msg string) (models.SavedQuery, bool) {
+	m := savedQueryRunRe.FindStringSubmatch(strings.ToLower(strings.TrimSpace(msg)))
+	if m == nil || c.Queries == nil {
+		return models.SavedQuery{}, false
+	}
+	q, _, ok, err := c.findSavedQuery(ctx, ownerKey, m[3])
+	return q, ok && err == nil
+}
+
+// RunSavedQuery answers the named saved query through the chat pipeline, in
+// req's conversation.
+func (c *ChatService) RunSavedQuery(ctx context.Context, ownerKey, name string, req models.ChatRequest) (models.ChatResponse, error) {
+	_, _, ok, err := c.findSavedQuery(ctx, ownerKey, name)
+	if err != nil {
+		return models.ChatResponse{}, err
+	}
+	if !ok {
+		return models.ChatResponse{}, ErrSavedQueryNotFound
+	}
+	req.Message = "run saved query " + NormalizeQueryName(name)
+	return c.Chat(ctx, ownerKey, req)
+}
+
+func savedQueryNames(all []models.SavedQuery) string {
+	names := make([]string, 0, len(all))
+	for _, q := range all {
+		names = append(names, "'"+q.Name+"'")
+	}
+	return strings.Join(names, ", ")
+}
+
+// handleSavedQueries saves, overwrites, lists and deletes saved queries.
+// Running one is resolved before the handler chain (see chatStream), so only
+// unknown names reach this handler.
+func (c *ChatService) handleSavedQueries(ctx context.Context, ownerKey string, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(strings.TrimSpace(req.Message))
+	save := savedQuerySaveRe.FindStringSubmatch(msgLower)
+	overwrite := savedQueryOverwriteRe.FindStringSubmatch(msgLower)
+	del := savedQueryDeleteRe.FindStringSubmatch(msgLower)
+	confirm := savedQueryConfirmRe.FindStringSubmatch(msgLower)
+	run := savedQueryRunRe.FindStringSubmatch(msgLower)
+	list := strings.Contains(msgLower, "saved quer")
+	// An unknown "run <name>" is only ours when it says it means a saved query.
+	if run != nil && run[1] == "" && run[2] == "" {
+		run = nil
+	}
+	if save == nil && overwrite == nil && del == nil && confirm == nil && run == nil && !list {
+		return models.ChatResponse{}, false, nil
+	}
+	reply := func(answer string) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(answer)
+		}
+		return models.ChatResponse{Answer: answer}, true, nil
+	}
+	if c.Queries == nil {
+		return reply("Saved queries are not configured.")
+	}
+
+	switch {
+	case save != nil || overwrite != nil:
+		raw := ""
+		if save != nil {
+			raw = save[1]
+		} else {
+			raw = overwrite[1]
+		}
+		name := NormalizeQueryName(raw)
+		if name == "" || len(name) > maxSavedQueryNameLen {
+			return reply(fmt.Sprintf("Saved query names must be 1 to %d characters.", maxSavedQueryNameLen))
+		}
+		existing, all, found, err := c.findSavedQuery(ctx, ownerKey, name)
+		if err != nil {
+			return reply("Failed to load saved queries: " + err.Error())
+		}
+		if overwrite != nil && !found {
+			return reply(fmt.Sprintf("There is no saved query named '%s' to overwrite. Say \"save this as %s\" to create it.", name, name))
+		}
+		if save != nil && found {
+			return reply(fmt.Sprintf("You already have a saved query named '%s': \"%s\". Say \"overwrite %s\" to replace it with the last question.", name, existing.Message, name))
+		}
+		if !found && len(all) >= maxSavedQueries {
+			return reply(fmt.Sprintf("You already have %d saved queries, the maximum. Delete one first (\"delete saved query <name>\").", maxSavedQueries))
+		}
+		conversationID := strings.TrimSpace(req.ConversationID)
+		var q *askedQuestion
+		if conversationID != "" {
+			q = c.lastQuestion(ctx, ownerKey, conversationID)
+		}
+		if q == nil {
+			return reply("There is no earlier question in this conversation to save. Ask it first, then say \"save this as <name>\".")
+		}
+		saved, err := c.Queries.PutSavedQuery(ctx, models.SavedQuery{OwnerKey: ownerKey, Name: name, Message: q.Message, Handler: q.Handler, Slots: q.Slots})
+		if err != nil {
+			return reply("Failed to save the query: " + err.Error())
+		}
+		verb := "Saved"
+		if found {
+			verb = "Overwrote"
+		}
+		answer := fmt.Sprintf("%s '%s': \"%s\". Say \"run %s\" to ask it again.", verb, saved.Name, saved.Message, saved.Name)
+		if len(saved.Slots) > 0 {
+			keys := make([]string, 0, len(saved.Slots))
+			for _, k := range []string{"poster_name", "poster_id", "city", "region", "host", "campaign_id", "venue_id", "unit"} {
+				if v := saved.Slots[k]; v != "" {
+					keys = append(keys, strings.ReplaceAll(k, "_", " ")+" "+v)
+				}
+			}
+			answer += " It will reuse the context it was answered with (" + strings.Join(keys, ", ") + ")."
+		}
+		return reply(answer)
+
+	case del != nil || confirm != nil:
+		raw := ""
+		if del != nil {
+			raw = del[1]
+		} else {
+			raw = confirm[1]
+		}
+		name := NormalizeQueryName(raw)
+		existing, _, found, err := c.findSavedQuery(ctx, ownerKey, name)
+		if err != nil {
+			return reply("Failed to load saved queries: " + err.Error())
+		}
+		if !found {
+			return reply(fmt.Sprintf("There is no saved query named '%s'.", name))
+		}
+		if del != nil {
+			return reply(fmt.Sprintf("'%s' runs \"%s\". Say \"confirm delete %s\" to delete it.", name, existing.Message, name))
+		}
+		if err := c.Queries.DeleteSavedQuery(ctx, ownerKey, name); err != nil {
+			return reply(fmt.Sprintf("There is no saved query named '%s'.", name))
+		}
+		return reply(fmt.Sprintf("Deleted saved query '%s'.", name))
+
+	case run != nil:
+		name := NormalizeQueryName(run[3])
+		_, all, _, err := c.findSavedQuery(ctx, ownerKey, name)
+		if err != nil {
+			return reply("Failed to load saved queries: " + err.Error())
+		}
+		if len(all) == 0 {
+			return reply(fmt.Sprintf("There is no saved query named '%s', and you have none yet. Ask a question, then say \"save this as <name>\".", name))
+		}
+		return reply(fmt.Sprintf("There is no saved query named '%s'. Your saved queries: %s.", name, savedQueryNames(all)))
+	}
+
+	all, err := c.Queries.ListSavedQueries(ctx, ownerKey)
+	if err != nil {
+		return reply("Failed to load saved queries: " + err.Error())
+	}
+	if len(all) == 0 {
+		return reply("You have no saved queries. Ask a question, then say \"save this as <name>\".")
+	}
+	lines := []string{fmt.Sprintf("Your saved queries (%d of %d):", len(all), maxSavedQueries)}
+	for _, q := range all {
+		lines = append(lines, fmt.Sprintf("- %s: \"%s\"", q.Name, q.Message))
+	}
+	return reply(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Schema drift statuses of one manifest endpoint.
+const (
+	DriftOK              = "ok"
+	DriftMissingFields   = "drift"            // the response schema lacks expected fields
+	DriftMissingEndpoint = "missing_endpoint" // the spec has no GET for the path
+	DriftUnverifiable    = "unverifiable"     // the spec gives no response schema to check
+)
+
+// EndpointDrift is the schema check of one gateway endpoint.
+type EndpointDrift struct {
+	Path    string   `json:"path"`
+	Status  string   `json:"status"`
+	Missing []string `json:"missing,omitempty"`
+}
+
+// DriftReport is the latest schema drift check. Degraded is set when an
+// endpoint drifted or disappeared; unverifiable endpoints do not count.
+type DriftReport struct {
+	CheckedAt time.Time       `json:"checked_at"`
+	Degraded  bool            `json:"degraded"`
+	Error     string          `json:"error,omitempty"`
+	Endpoints []EndpointDrift `json:"endpoints,omitempty"`
+}
+
+// Counts returns the number of endpoints per status.
+func (r DriftReport) Counts() map[string]int {
+	out := map[string]int{DriftOK: 0, DriftMissingFields: 0, DriftMissingEndpoint: 0, DriftUnverifiable: 0}
+	for _, e := range r.Endpoints {
+		out[e.Status]++
+	}
+	return out
+}
+
+// SchemaDriftDetector compares the gateway's OpenAPI spec, fetched through
+// the ToolCatalog, with gatewayManifest at startup and then every Interval.
+type SchemaDriftDetector struct {
+	Catalog  *ToolCatalog
+	Interval time.Duration
+
+	mu     sync.Mutex
+	report DriftReport
+}
+
+func (d *SchemaDriftDetector) Run(ctx context.Context) {
+	interval := d.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	check := func() { d.Check(ctx) }
+	guardTick("schema drift", check)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			guardTick("schema drift", check)
+		}
+	}
+}
+
+// Check fetches the spec, compares it with the manifest, logs every endpoint
+// that is not ok and keeps the result for Report.
+func (d *SchemaDriftDetector) Check(ctx context.Context) DriftReport {
+	report := DriftReport{CheckedAt: time.Now().UTC()}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	spec, err := d.Catalog.Fetch(ctx)
+	if err != nil {
+		report.Error = err.Error()
+		log.Printf("schema drift: spec fetch failed: %v", err)
+	} else {
+		report.Endpoints = checkSchemaDrift(spec, gatewayManifest)
+		for _, e := range report.Endpoints {
+			switch e.Status {
+			case DriftMissingFields, DriftMissingEndpoint:
+				report.Degraded = true
+				log.Printf("schema drift: endpoint=%s status=%s missing=%s", e.Path, e.Status, strings.Join(e.Missing, ","))
+			case DriftUnverifiable:
+				log.Printf("schema drift: endpoint=%s status=%s", e.Path, e.Status)
+			}
+		}
+	}
+	d.mu.Lock()
+	d.report = report
+	d.mu.Unlock()
+	return report
+}
+
+// Report returns the latest check; CheckedAt is zero before the first one.
+func (d *SchemaDriftDetector) Report() DriftReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.report
+}
+
+// checkSchemaDrift checks every manifest endpoint against spec.
+func checkSchemaDrift(spec OpenAPISpec, manifest []gatewayExpectation) []EndpointDrift {
+	out := make([]EndpointDrift, 0, len(manifest))
+	for _, exp := range manifest {
+		op := specOperation(spec, exp.Path, "get")
+		if op == nil {
+			out = append(out, EndpointDrift{Path: exp.Path, Status: DriftMissingEndpoint})
+			continue
+		}
+		schema := responseSchema(op)
+		if schema == nil {
+			out = append(out, EndpointDrift{Path: exp.Path, Status: DriftUnverifiable})
+			continue
+		}
+		fields := map[string]bool{}
+		collectSchemaFields(spec, schema, fields, map[string]bool{})
+		if len(fields) == 0 {
+			// A bare {"type": "object"} says nothing about the fields.
+			out = append(out, EndpointDrift{Path: exp.Path, Status: DriftUnverifiable})
+			continue
+		}
+		e := EndpointDrift{Path: exp.Path, Status: DriftOK}
+		for _, f := range exp.Fields {
+			if !fields[f] {
+				e.Missing = append(e.Missing, f)
+			}
+		}
+		if len(e.Missing) > 0 {
+			e.Status = DriftMissingFields
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// specOperation finds the operation for path in spec, exactly or through a
+// templated spec path.
+func specOperation(spec OpenAPISpec, path, method string) map[string]any {
+	if ops, ok := spec.Paths[path]; ok {
+		op, _ := ops[method].(map[string]any)
+		return op
+	}
+	specPaths := make([]string, 0, len(spec.Paths))
+	for p := range spec.Paths {
+		specPaths = append(specPaths, p)
+	}
+	sort.Strings(specPaths)
+	for _, p := range specPaths {
+		if !matchOpenAPIPath(p, path) {
+			continue
+		}
+		if op, ok := spec.Paths[p][method].(map[string]any); ok {
+			return op
+		}
+	}
+	return nil
+}
+
+// responseSchema returns the JSON schema of the operation's success
+// response: 200, else the first 2xx, else default. OpenAPI 3 keeps it under
+// content, Swagger 2.0 directly on the response.
+func responseSchema(op map[string]any) any {
+	responses, _ := op["responses"].(map[string]any)
+	if len(responses) == 0 {
+		return nil
+	}
+	codes := make([]string, 0, len(responses))
+	for code := range responses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	pick := ""
+	if _, ok := responses["200"]; ok {
+		pick = "200"
+	} else {
+		for _, code := range codes {
+			if strings.HasPrefix(code, "2") {
+				pick = code
+				break
+			}
+		}
+	}
+	if pick == "" {
+		pick = "default"
+	}
+	resp, _ := responses[pick].(map[string]any)
+	if resp == nil {
+		return nil
+	}
+	if s, ok := resp["schema"]; ok {
+		return s
+	}
+	content, _ := resp["content"].(map[string]any)
+	for mediaType, v := range content {
+		if !strings.Contains(mediaType, "json") {
+			continue
+		}
+		if m, ok := v.(map[string]any); ok && m["schema"] != nil {
+			return m["schema"]
+		}
+	}
+	return nil
+}
+
+// collectSchemaFields adds every property name found in schema, at any
+// depth, to fields, following $refs once each.
+func collectSchemaFields(spec OpenAPISpec, schema any, fields, seen map[string]bool) {
+	m, ok := schema.(map[string]any)
+	if !ok {
+		return
+	}
+	if ref, _ := m["$ref"].(string); ref != "" {
+		if seen[ref] {
+			return
+		}
+		seen[ref] = true
+		collectSchemaFields(spec, resolveSchemaRef(spec, ref), fields, seen)
+		return
+	}
+	if props, ok := m["properties"].(map[string]any); ok {
+		for name, p := range props {
+			fields[name] = true
+			collectSchemaFields(spec, p, fields, seen)
+		}
+	}
+	for _, k := range []string{"items", "additionalProperties"} {
+		collectSchemaFields(spec, m[k], fields, seen)
+	}
+	for _, k := range []string{"allOf", "anyOf", "oneOf"} {
+		list, _ := m[k].([]any)
+		for _, s := range list {
+			collectSchemaFields(spec, s, fields, seen)
+		}
+	}
+}
+
+func resolveSchemaRef(spec OpenAPISpec, ref string) any {
+	if name, ok := strings.CutPrefix(ref, "#/components/schemas/"); ok {
+		return spec.Components.Schemas[name]
+	}
+	if name, ok := strings.CutPrefix(ref, "#/definitions/"); ok {
+		return spec.Definitions[name]
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	"openai-agent-service/internal/models"
+)
+
+// scopeSource is where a parameter an answer is scoped by came from.
+type scopeSource string
+
+const (
+	// scopeStated parameters are named in the message itself.
+	scopeStated scopeSource = "message"
+	// scopeMemory parameters are remembered from earlier in the
+	// conversation.
+	scopeMemory scopeSource = "conversation"
+	// scopeHost parameters are read from the prefix of a host the message
+	// (or the conversation) names.
+	scopeHost scopeSource = "host"
+)
+
+// scopeParam is one scope value and its source; the zero value is unset.
+type scopeParam struct {
+	Value  string
+	Source scopeSource
+}
+
+func scopeValue(v string, src scopeSource) scopeParam {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if v == "" {
+		return scopeParam{}
+	}
+	return scopeParam{Value: v, Source: src}
+}
+
+// resolvedScope is the city and region a request is scoped to.
+type resolvedScope struct {
+	City   scopeParam
+	Region scopeParam
+}
+
+func (rs resolvedScope) empty() bool {
+	return rs.City.Value == "" && rs.Region.Value == ""
+}
+
+// resolveScope reads the city and region named in msgLower, falling back to
+// a host's prefix (see inferHostScope) and then to the conversation's
+// remembered scope when the message names neither.
+func (c *ChatService) resolveScope(ctx context.Context, conversationID, msgLower string) resolvedScope {
+	return c.resolveScopeWith(ctx, conversationID, msgLower, false)
+}
+
+// resolvePosterScope is resolveScope for poster questions: the remembered
+// poster's own city and region come before the conversation's.
+func (c *ChatService) resolvePosterScope(ctx context.Context, conversationID, msgLower string) resolvedScope {
+	return c.resolveScopeWith(ctx, conversationID, msgLower, true)
+}
+
+func (c *ChatService) resolveScopeWith(ctx context.Context, conversationID, msgLower string, posterFirst bool) resolvedScope {
+	hs := hostScopeFrom(ctx)
+	source := func(v, fromHost string) scopeSource {
+		if hs.Host != "" && v == fromHost {
+			return scopeHost
+		}
+		return scopeStated
+	}
+	city := c.detectCityCode(ctx, msgLower)
+	region := c.detectRegionCode(ctx, msgLower)
+	rs := resolvedScope{City: scopeValue(city, source(city, hs.City)), Region: scopeValue(region, source(region, hs.Region))}
+	if !rs.empty() || strings.TrimSpace(conversationID) == "" {
+		return rs
+	}
+	st := c.getConversationState(strings.TrimSpace(conversationID))
+	if st == nil {
+		return rs
+	}
+	if posterFirst {
+		rs = resolvedScope{City: scopeValue(st.PosterCity, scopeMemory), Region: scopeValue(st.PosterRegion, scopeMemory)}
+	}
+	if rs.City.Value == "" {
+		rs.City = scopeValue(st.City, scopeMemory)
+	}
+	if rs.Region.Value == "" {
+		rs.Region = scopeValue(st.Region, scopeMemory)
+	}
+	return rs
+}
+
+// scopeSourceLabel says in the interpretation header where an inherited
+// value came from; stated values are not labelled.
+func scopeSourceLabel(src scopeSource, host string) string {
+	switch src {
+	case scopeMemory:
+		return " (from earlier in this conversation)"
+	case scopeHost:
+		return " (from host " + host + ")"
+	}
+	return ""
+}
+
+// renderScopeParams renders name=value pairs, labelling each run of values
+// that share a source once: "region=brt,city=kcmo (from earlier in this
+// conversation)".
+func renderScopeParams(params []models.ScopeParam, host string) string {
+	var b strings.Builder
+	for i, p := range params {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(p.Name + "=" + p.Value)
+		if i == len(params)-1 || params[i+1].Source != p.Source {
+			b.WriteString(scopeSourceLabel(scopeSource(p.Source), host))
+		}
+	}
+	return b.String()
+}
+
+// scopeOverrideHint tells the user how to replace the inherited parameters
+// among params, or is "" when every parameter was stated.
+func scopeOverrideHint(params []models.ScopeParam) string {
+	var scope, poster, host bool
+	current := map[string]bool{}
+	for _, p := range params {
+		if scopeSource(p.Source) == scopeStated {
+			continue
+		}
+		switch p.Name {
+		case "city", "region":
+			scope = true
+			current[p.Value] = true
+		case "poster":
+			poster = true
+		case "host":
+			host = true
+		}
+	}
+	hints := make([]string, 0, 3)
+	if scope {
+		example := "kcmo"
+		if current[example] {
+			example = "brt"
+		}
+		hints = append(hints, `say "in `+example+`" to change the scope`)
+	}
+	if poster {
+		hints = append(hints, `name another poster or say "forget the poster"`)
+	}
+	if host {
+		hints = append(hints, "name another host to ask about it")
+	}
+	if len(hints) == 0 {
+		return ""
+	}
+	return "To override: " + strings.Join(hints, "; ") + "."
+}
+
+// headerlessHandlers answer without the interpretation header, so their
+// answers carry no scope either.
+var headerlessHandlers = map[string]bool{
+	"handleForgetContext":       true,
+	"handleFeedback":            true,
+	"handleSavedQueries":        true,
+	"handleConversationSummary": true,
+}
+
+// withScopeMeta adds the scope the request was interpreted with to
+// resp.Meta.
+func withScopeMeta(ctx context.Context, resp models.ChatResponse) models.ChatResponse {
+	r := answerRouteFrom(ctx)
+	if r == nil || len(r.scope) == 0 || headerlessHandlers[r.handler] {
+		return resp
+	}
+	if resp.Meta == nil {
+		resp.Meta = &models.ResponseMeta{}
+	} else {
+		m := *resp.Meta
+		resp.Meta = &m
+	}
+	resp.Meta.Scope = r.scope
+	return resp
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StartupCheck catches deployments whose settings obviously disagree — a
+// gateway that is down or has no cities, an OpenAI key that cannot be
+// right, a database on another environment's host — before the service
+// starts answering. It only runs when main calls it.
+type StartupCheck struct {
+	Environment string
+	Gateway     *GatewayClient
+	GatewayURL  string
+	OpenAIKey   string
+	Model       string
+	MockMode    bool
+	DatabaseURL string
+	// ExpectedDBHost, when set, must match the host in DatabaseURL.
+	ExpectedDBHost *regexp.Regexp
+	HandlerFlags   map[string]bool
+	// Timeout bounds the whole check; 5s when zero.
+	Timeout time.Duration
+}
+
+// StartupProblem is one failed startup check.
+type StartupProblem struct {
+	Check   string
+	Message string
+}
+
+func (p StartupProblem) String() string {
+	return p.Check + ": " + p.Message
+}
+
+// openAIKeyRe is the shape of an OpenAI API key; it says nothing about
+// whether the key works.
+var openAIKeyRe = regexp.MustCompile(`^sk-[A-Za-z0-9_\-]{16,}$`)
+
+// Run performs every check and returns the ones that failed.
+func (s *StartupCheck) Run(ctx context.Context) []StartupProblem {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var problems []StartupProblem
+	if msg := s.checkGateway(ctx); msg != "" {
+		problems = append(problems, StartupProblem{Check: "gateway", Message: msg})
+	}
+	if !s.MockMode && !openAIKeyRe.MatchString(s.OpenAIKey) {
+		problems = append(problems, StartupProblem{Check: "openai_key", Message: "OPENAI_API_KEY does not look like an OpenAI key (expected sk-...)"})
+	}
+	if msg := s.checkDatabaseHost(); msg != "" {
+		problems = append(problems, StartupProblem{Check: "database_host", Message: msg})
+	}
+	return problems
+}
+
+// checkGateway asks the gateway for its region/city list, which every
+// location-scoped answer depends on.
+func (s *StartupCheck) checkGateway(ctx context.Context) string {
+	if s.Gateway == nil {
+		return "tool gateway is not configured"
+	}
+	status, body, err := s.Gateway.Get(ctx, "/ads/devices/counts/regions")
+	if err != nil {
+		return fmt.Sprintf("%s is not reachable: %v", gatewayHost(s.GatewayURL), err)
+	}
+	if status < 200 || status >= 300 {
+		return fmt.Sprintf("%s answered the region list with status %d", gatewayHost(s.GatewayURL), status)
+	}
+	var root struct {
+		Data []map[string]any `json:"data"`
+	}
+	if json.Unmarshal(body, &root) != nil {
+		return fmt.Sprintf("%s returned a region list that could not be parsed", gatewayHost(s.GatewayURL))
+	}
+	for _, row := range root.Data {
+		city, _ := row["city"].(string)
+		region, _ := row["region"].(string)
+		if strings.TrimSpace(city) != "" || strings.TrimSpace(region) != "" {
+			return ""
+		}
+	}
+	return fmt.Sprintf("%s returned no regions or cities", gatewayHost(s.GatewayURL))
+}
+
+func (s *StartupCheck) checkDatabaseHost() string {
+	if s.ExpectedDBHost == nil {
+		return ""
+	}
+	host := databaseHost(s.DatabaseURL)
+	if host == "" {
+		return "DATABASE_URL has no host to compare with EXPECTED_DATABASE_HOST"
+	}
+	if !s.ExpectedDBHost.MatchString(host) {
+		return fmt.Sprintf("DATABASE_URL host %q does not match EXPECTED_DATABASE_HOST %q", host, s.ExpectedDBHost.String())
+	}
+	return ""
+}
+
+// Summary is the one-line startup log of what this instance is wired to.
+func (s *StartupCheck) Summary() string {
+	env := strings.TrimSpace(s.Environment)
+	if env == "" {
+		env = "unset"
+	}
+	names := make([]string, 0, len(s.HandlerFlags))
+	for name := range s.HandlerFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	flags := make([]string, 0, len(names))
+	for _, name := range names {
+		flags = append(flags, fmt.Sprintf("%s=%t", name, s.HandlerFlags[name]))
+	}
+	handlerFlags := "defaults"
+	if len(flags) > 0 {
+		handlerFlags = strings.Join(flags, ",")
+	}
+	return fmt.Sprintf("startup: environment=%s gateway_host=%s database_host=%s model=%s mock_mode=%t handler_flags=%s",
+		env, gatewayHost(s.GatewayURL), databaseHost(s.DatabaseURL), s.Model, s.MockMode, handlerFlags)
+}
+
+func gatewayHost(raw string) string {
+	if u, err := url.Parse(strings.TrimSpace(raw)); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return strings.TrimSpace(raw)
+}
+
+// databaseHost returns the host of a postgres URL or key=value DSN, without
+// credentials.
+func databaseHost(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if u, err := url.Parse(raw); err == nil && u.Scheme != "" {
+		return u.Hostname()
+	}
+	for _, part := range strings.Fields(raw) {
+		if v, ok := strings.CutPrefix(part, "host="); ok {
+			return strings.Trim(v, `'"`)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"openai-agent-service/internal/models"
+)
+
+// StepBodyStore persists the full bodies of clipped steps. PostgresStore
+// implements it.
+type StepBodyStore interface {
+	SaveStepBody(ctx context.Context, b models.StepBody) error
+	GetStepBody(ctx context.Context, id string) (models.StepBody, error)
+	DeleteExpiredStepBodies(ctx context.Context, now time.Time) (int64, error)
+}
+
+// maxSpillBytes bounds the gateway bodies one request keeps in memory for
+// spillover; bodies past it are clipped as usual and not stored.
+const maxSpillBytes = 8 << 20
+
+// stepSpill holds, for one request, the gateway bodies longer than the step
+// clip, keyed by the excerpt clipStep makes of them. A Step whose Body is
+// such an excerpt gets the full body stored. It travels in the request
+// context like debugCapture; methods are safe on a nil receiver.
+type stepSpill struct {
+	mu     sync.Mutex
+	clip   int
+	budget int
+	bodies map[string]string
+}
+
+type stepSpillKey struct{}
+
+func withStepSpill(ctx context.Context, sp *stepSpill) context.Context {
+	return context.WithValue(ctx, stepSpillKey{}, sp)
+}
+
+func stepSpillFrom(ctx context.Context) *stepSpill {
+	sp, _ := ctx.Value(stepSpillKey{}).(*stepSpill)
+	return sp
+}
+
+// record keeps body when clipStep would clip it.
+func (sp *stepSpill) record(body []byte) {
+	if sp == nil || len(body) <= sp.clip {
+		return
+	}
+	full := strings.TrimSpace(string(body))
+	if len(full) <= sp.clip {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if len(full) > sp.budget {
+		return
+	}
+	sp.budget -= len(full)
+	sp.bodies[clipString(full, sp.clip)] = full
+}
+
+func (sp *stepSpill) lookup(excerpt string) (string, bool) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	full, ok := sp.bodies[excerpt]
+	return full, ok
+}
+
+// newStepSpill returns a collector for one request, or nil when spillover
+// is off.
+func (c *ChatService) newStepSpill() *stepSpill {
+	if c.StepBodies == nil {
+		return nil
+	}
+	clip := c.limits().StepBodyClip
+	if clip <= 0 {
+		return nil
+	}
+	return &stepSpill{clip: clip, budget: maxSpillBytes, bodies: map[string]string{}}
+}
+
+// spillSteps stores the full body of each clipped step in resp and sets its
+// BodyID. Store failures are logged and leave the step as it was.
+func (c *ChatService) spillSteps(ctx context.Context, sp *stepSpill, ownerKey string, resp models.ChatResponse) models.ChatResponse {
+	if sp == nil || len(resp.Steps) == 0 {
+		return resp
+	}
+	retention := c.StepBodyRetention
+	if retention <= 0 {
+		retention = time.Hour
+	}
+	var steps []models.Step
+	for i, st := range resp.Steps {
+		full, ok := sp.lookup(st.Body)
+		if !ok || st.BodyID != "" {
+			continue
+		}
+		now := time.Now().UTC()
+		b := models.StepBody{ID: uuid.NewString(), OwnerKey: ownerKey, Tool: st.Tool, Body: full, CreatedAt: now, ExpiresAt: now.Add(retention)}
+		if err := c.StepBodies.SaveStepBody(context.WithoutCancel(ctx), b); err != nil {
+			log.Printf("step spill: save failed tool=%s: %v", st.Tool, err)
+			continue
+		}
+		if steps == nil {
+			steps = append([]models.Step(nil), resp.Steps...)
+		}
+		steps[i].BodyID = b.ID
+	}
+	if steps != nil {
+		resp.Steps = steps
+	}
+	return resp
+}
+
+// StepBodyJanitor deletes expired step bodies on an interval.
+type StepBodyJanitor struct {
+	Store    StepBodyStore
+	Interval time.Duration
+}
+
+func (j *StepBodyJanitor) Run(ctx context.Context) {
+	interval := j.Interval
+	if interval <= 0 {
+		interval = 10 * time.Minute
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if n, err := j.Store.DeleteExpiredStepBodies(ctx, time.Now()); err != nil {
+				log.Printf("step body janitor: %v", err)
+			} else if n > 0 {
+				log.Printf("step body janitor: deleted %d expired body(ies)", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// memStepBodies is an in-memory StepBodyStore.
+type memStepBodies struct {
+	mu     sync.Mutex
+	bodies map[string]models.StepBody
+}
+
+func (s *memStepBodies) SaveStepBody(_ context.Context, b models.StepBody) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bodies == nil {
+		s.bodies = map[string]models.StepBody{}
+	}
+	s.bodies[b.ID] = b
+	return nil
+}
+
+func (s *memStepBodies) GetStepBody(_ context.Context, id string) (models.StepBody, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.bodies[id]
+	if !ok || !b.ExpiresAt.After(time.Now()) {
+		return models.StepBody{}, sql.ErrNoRows
+	}
+	return b, nil
+}
+
+func (s *memStepBodies) DeleteExpiredStepBodies(_ context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for id, b := range s.bodies {
+		if !b.ExpiresAt.After(now) {
+			delete(s.bodies, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memStepBodies) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.bodies)
+}
+
+const spillQuestion = "play count for poster Bet 365 in brt"
+
+// newSpillChat clips step bodies at 100 bytes, so every /pop page is clipped.
+func newSpillChat(g *fakeGateway, bodies StepBodyStore) *ChatService {
+	c := newTestChat(g)
+	c.Limits.StepBodyClip = 100
+	c.StepBodies = bodies
+	return c
+}
+
+func TestStepSpill(t *testing.T) {
+	// What the service answers with spillover off.
+	off := newSpillChat(newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()}), nil)
+	want := chatOnce(t, off, spillQuestion)
+
+	cases := []struct {
+		name  string
+		clip  int
+		spill bool
+	}{
+		{name: "clipped bodies spill", clip: 100, spill: true},
+		{name: "nothing clipped", clip: MaxLimits.StepBodyClip},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})
+			bodies := &memStepBodies{}
+			c := newSpillChat(g, bodies)
+			c.Limits.StepBodyClip = tc.clip
+			resp, err := c.Chat(context.Background(), "owner-a", models.ChatRequest{Message: spillQuestion})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Answer != want.Answer {
+				t.Errorf("answer changed by spillover:\n%s\nwant\n%s", resp.Answer, want.Answer)
+			}
+
+			spilled := 0
+			for _, st := range resp.Steps {
+				if st.BodyID == "" {
+					if tc.clip == 100 && len(st.Body) > 100 {
+						t.Errorf("step %s has %d bytes and no body id", st.Tool, len(st.Body))
+					}
+					continue
+				}
+				spilled++
+				b, err := bodies.GetStepBody(context.Background(), st.BodyID)
+				if err != nil {
+					t.Fatalf("step %s: %v", st.Tool, err)
+				}
+				if b.OwnerKey != "owner-a" || b.Tool != st.Tool || len(b.Body) <= len(st.Body) || clipString(b.Body, 100) != st.Body {
+					t.Errorf("stored body %+v does not extend step excerpt %q", b, st.Body)
+				}
+				if got := b.ExpiresAt.Sub(b.CreatedAt); got != time.Hour {
+					t.Errorf("retention = %v, want the 1h default", got)
+				}
+			}
+			if tc.spill && spilled == 0 {
+				t.Error("no step spilled")
+			}
+			if tc.spill {
+				// Apart from the body ids, the steps are those of spillover off.
+				steps := append([]models.Step(nil), resp.Steps...)
+				for i := range steps {
+					steps[i].BodyID = ""
+				}
+				if !reflect.DeepEqual(steps, want.Steps) {
+					t.Errorf("steps\n%+v\nwant\n%+v", steps, want.Steps)
+				}
+			}
+			if !tc.spill && (spilled != 0 || bodies.len() != 0) {
+				t.Errorf("%d steps spilled with nothing clipped", spilled)
+			}
+		})
+	}
+
+	// Off by default: no store, no body ids, steps exactly as clipped today.
+	for _, st := range want.Steps {
+		if st.BodyID != "" {
+			t.Errorf("step %s has a body id with spillover off", st.Tool)
+		}
+	}
+}
+
+// Spilled bodies are readable only for StepBodyRetention; the janitor then
+// deletes them.
+func TestStepSpillExpiry(t *testing.T) {
+	g := newFakeGateway(t, &fakeGateway{Devices: testDevices, Pop: testPop()})
+	bodies := &memStepBodies{}
+	c := newSpillChat(g, bodies)
+	c.StepBodyRetention = 20 * time.Millisecond
+	resp := chatOnce(t, c, spillQuestion)
+	var id string
+	for _, st := range resp.Steps {
+		if st.BodyID != "" {
+			id = st.BodyID
+			break
+		}
+	}
+	if id == "" {
+		t.Fatal("no step spilled")
+	}
+	ctx := context.Background()
+	if _, err := bodies.GetStepBody(ctx, id); err != nil {
+		t.Fatalf("fresh body: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, err := bodies.GetStepBody(ctx, id); err != sql.ErrNoRows {
+		t.Fatalf("expired body: err = %v, want sql.ErrNoRows", err)
+	}
+	jctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go (&StepBodyJanitor{Store: bodies, Interval: time.Millisecond}).Run(jctx)
+	waitFor(t, "the janitor to delete the bodies", func() bool { return bodies.len() == 0 })
+}
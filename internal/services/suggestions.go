@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	"openai-agent-service/internal/models"
+)
+
+// suggestionSlots are the conversation facts a follow-up suggestion may use.
+type suggestionSlots struct {
+	Poster string
+	Host   string
+	// Scope is " in brt" style text for the remembered city or region, or
+	// "" when there is none.
+	Scope string
+	// ListKind is the kind of the list the answer just showed, if any.
+	ListKind string
+}
+
+// followUp builds one canned follow-up, or "" when a slot it needs is
+// unknown, so no suggestion ever carries a placeholder.
+type followUp func(s suggestionSlots) string
+
+func posterFollowUp(format func(poster, scope string) string) followUp {
+	return func(s suggestionSlots) string {
+		if s.Poster == "" {
+			return ""
+		}
+		return format(s.Poster, s.Scope)
+	}
+}
+
+func scopeFollowUp(format func(scope string) string) followUp {
+	return func(s suggestionSlots) string {
+		if s.Scope == "" {
+			return ""
+		}
+		return format(s.Scope)
+	}
+}
+
+func hostFollowUp(format func(host string) string) followUp {
+	return func(s suggestionSlots) string {
+		if s.Host == "" {
+			return ""
+		}
+		return format(s.Host)
+	}
+}
+
+func listFollowUp(kind, text string) followUp {
+	return func(s suggestionSlots) string {
+		if s.ListKind != kind {
+			return ""
+		}
+		return text
+	}
+}
+
+var (
+	posterKioskWise = posterFollowUp(func(p, scope string) string { return "kiosk wise play count for poster " + p + scope })
+	posterVsLastWk  = posterFollowUp(func(p, scope string) string { return "plays for poster " + p + scope + " this week vs last week" })
+	posterBestDay   = posterFollowUp(func(p, _ string) string { return "which day of the week does poster " + p + " perform best" })
+	posterPlayCount = posterFollowUp(func(p, scope string) string { return "play count for poster " + p + scope })
+	scopeTopPosters = scopeFollowUp(func(scope string) string { return "top posters" + scope })
+	scopeTopDevices = scopeFollowUp(func(scope string) string { return "top devices" + scope })
+	scopeLowUptime  = scopeFollowUp(func(scope string) string { return "lowest uptime devices" + scope })
+	scopeSilent     = scopeFollowUp(func(scope string) string { return "devices not reporting metrics" + scope })
+	hostTelemetry   = hostFollowUp(func(h string) string { return "telemetry for " + h })
+	hostPopToday    = hostFollowUp(func(h string) string { return "pop today for " + h })
+	hostHourly      = hostFollowUp(func(h string) string { return "hourly play distribution for " + h + " yesterday" })
+)
+
+// followUps lists, per deterministic handler, the follow-ups worth offering
+// after it answered, most useful first. Every text is phrased so the handler
+// named in the comment answers it.
+var followUps = map[string][]followUp{
+	"handlePosterPlayCount": {
+		posterKioskWise, // handlePosterPlayCount
+		posterVsLastWk,  // handlePeriodComparison
+		posterBestDay,   // handlePopPattern
+	},
+	"handlePeriodComparison": {
+		posterKioskWise,
+		posterBestDay,
+	},
+	"handlePopPattern": {
+		posterPlayCount,
+		posterKioskWise,
+	},
+	"handleTopPostersFromCity": {
+		scopeTopDevices, // handleTopDevicesFromCity
+		listFollowUp(listKindPoster, "kiosk wise play count for the first one"), // list reference, then handlePosterPlayCount
+	},
+	"handleTopDevicesFromCity": {
+		scopeTopPosters, // handleTopPostersFromCity
+		scopeLowUptime,  // handleLowUptimeDevices
+		scopeSilent,     // handleTelemetryCoverage
+	},
+	"handleKioskCountFromCity": {
+		scopeTopDevices,
+		scopeLowUptime,
+		scopeSilent,
+	},
+	"handleLowUptimeDevices": {
+		listFollowUp(listKindDevice, "telemetry for the first one"), // list reference, then handleDeviceTelemetry
+		scopeSilent,
+		scopeTopDevices,
+	},
+	"handleTelemetryCoverage": {
+		scopeLowUptime,
+		scopeTopDevices,
+	},
+	"handleDeviceDetails": {
+		hostTelemetry, // handleDeviceTelemetry
+		hostPopToday,  // handlePopByHostForDate
+		hostHourly,    // handleHourlyDistribution
+	},
+	"handleDeviceTelemetry": {
+		hostPopToday,
+		hostHourly,
+	},
+}
+
+const (
+	minSuggestions = 2
+	maxSuggestions = 4
+)
+
+// suggestionScope renders the remembered city or region the way the scope
+// detectors read it back; two-letter cities need the word "city".
+func suggestionScope(st *conversationState) string {
+	if r := strings.TrimSpace(st.Region); r != "" {
+		return " in " + r
+	}
+	if city := strings.TrimSpace(st.City); city != "" {
+		if len(city) < 3 {
+			return " in " + city + " city"
+		}
+		return " in " + city
+	}
+	return ""
+}
+
+// suggestFollowUps returns 2–4 follow-ups for the handler that answered, or
+// nil when the conversation state cannot fill enough of them.
+func suggestFollowUps(handler string, st *conversationState) []string {
+	builders := followUps[handler]
+	if len(builders) == 0 || st == nil {
+		return nil
+	}
+	slots := suggestionSlots{
+		Poster: strings.TrimSpace(st.PosterName),
+		Host:   strings.TrimSpace(st.Host),
+		Scope:  suggestionScope(st),
+	}
+	if st.LastList != nil {
+		slots.ListKind = st.LastList.Kind
+	}
+	out := make([]string, 0, maxSuggestions)
+	for _, b := range builders {
+		if s := b(slots); s != "" {
+			out = append(out, s)
+		}
+		if len(out) == maxSuggestions {
+			break
+		}
+	}
+	if len(out) < minSuggestions {
+		return nil
+	}
+	return out
+}
+
+// withSuggestions adds follow-up suggestions to a deterministic answer:
+// always in Data, and as a closing "You could also ask" list unless the
+// request is brief. Failed answers and answers waiting on a clarification
+// get none.
+func (c *ChatService) withSuggestions(ctx context.Context, req models.ChatRequest, resp models.ChatResponse, err error, onToken func(string)) models.ChatResponse {
+	route := answerRouteFrom(ctx)
+	if err != nil || resp.Error != nil || route == nil || route.handler == "" {
+		return resp
+	}
+	st := c.getConversationState(req.ConversationID)
+	if st == nil || st.PendingHandler != "" {
+		return resp
+	}
+	suggestions := suggestFollowUps(route.handler, st)
+	if len(suggestions) == 0 {
+		return resp
+	}
+	if resp.Data == nil {
+		resp.Data = &models.ChatData{}
+	}
+	resp.Data.Suggestions = suggestions
+	if strings.EqualFold(strings.TrimSpace(req.Verbosity), "brief") {
+		return resp
+	}
+	note := "\n\nYou could also ask:\n- " + strings.Join(suggestions, "\n- ")
+	resp.Answer += note
+	if onToken != nil {
+		onToken(note)
+	}
+	return resp
+}
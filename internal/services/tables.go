@@ -0,0 +1,108 @@
+package services
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"openai-agent-service/internal/models"
+)
+
+// maxTableCell bounds a table cell; longer values are cut with "…".
+const maxTableCell = 48
+
+// wantsTables reports whether tabular answers should render as markdown
+// tables: asked for with render_tables, or implied by verbosity "detailed".
+// The numbered list stays the default.
+func wantsTables(req models.ChatRequest) bool {
+	return req.RenderTables || strings.EqualFold(strings.TrimSpace(req.Verbosity), "detailed")
+}
+
+// textTable builds a GitHub-flavored markdown table whose columns are padded
+// to a common width so it also reads well as plain monospace text.
+type textTable struct {
+	headers []string
+	right   []bool
+	rows    [][]string
+}
+
+func newTextTable(headers ...string) *textTable {
+	return &textTable{headers: headers, right: make([]bool, len(headers))}
+}
+
+// alignRight right-aligns the given columns, typically the numeric ones.
+func (t *textTable) alignRight(cols ...int) *textTable {
+	for _, c := range cols {
+		if c >= 0 && c < len(t.right) {
+			t.right[c] = true
+		}
+	}
+	return t
+}
+
+// add appends a row; missing cells are left empty and extra cells dropped.
+func (t *textTable) add(cells ...string) {
+	row := make([]string, len(t.headers))
+	for i := range row {
+		if i < len(cells) {
+			row[i] = tableCell(cells[i])
+		}
+	}
+	t.rows = append(t.rows, row)
+}
+
+// lines renders the header, the separator and one line per row, in that
+// order, so row i of the table is lines()[i+2].
+func (t *textTable) lines() []string {
+	widths := make([]int, len(t.headers))
+	for i, h := range t.headers {
+		widths[i] = max(utf8.RuneCountInString(h), 3)
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	out := make([]string, 0, len(t.rows)+2)
+	out = append(out, t.line(t.headers, widths))
+	sep := make([]string, len(widths))
+	for i, w := range widths {
+		if t.right[i] {
+			sep[i] = strings.Repeat("-", w-1) + ":"
+		} else {
+			sep[i] = strings.Repeat("-", w)
+		}
+	}
+	out = append(out, "| "+strings.Join(sep, " | ")+" |")
+	for _, row := range t.rows {
+		out = append(out, t.line(row, widths))
+	}
+	return out
+}
+
+func (t *textTable) line(cells []string, widths []int) string {
+	padded := make([]string, len(cells))
+	for i, cell := range cells {
+		pad := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+		if t.right[i] {
+			padded[i] = pad + cell
+		} else {
+			padded[i] = cell + pad
+		}
+	}
+	return "| " + strings.Join(padded, " | ") + " |"
+}
+
+func (t *textTable) String() string {
+	return strings.Join(t.lines(), "\n")
+}
+
+// tableCell flattens a value onto one line, truncates it to maxTableCell and
+// escapes pipes so names like "Gate 3 | North" keep the columns intact.
+func tableCell(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) > maxTableCell {
+		r := []rune(s)
+		s = strings.TrimSpace(string(r[:maxTableCell-1])) + "…"
+	}
+	return strings.ReplaceAll(s, "|", `\|`)
+}
@@ -0,0 +1,1119 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+func (c *ChatService) handleLowUptimeDevices(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !strings.Contains(msgLower, "uptime") {
+		return models.ChatResponse{}, false, nil
+	}
+	if !(strings.Contains(msgLower, "low") || strings.Contains(msgLower, "lowest") || strings.Contains(msgLower, "down") || strings.Contains(msgLower, "unstable")) {
+		return models.ChatResponse{}, false, nil
+	}
+	if !(strings.Contains(msgLower, "device") || strings.Contains(msgLower, "devices") || strings.Contains(msgLower, "kiosk") || strings.Contains(msgLower, "kiosks")) {
+		return models.ChatResponse{}, false, nil
+	}
+	// If a specific host is requested, let the per-host telemetry handler answer.
+	if len(detectHostTokens(req.Message)) > 0 {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	scope := c.resolveScope(ctx, conversationID, msgLower)
+	city, region := scope.City.Value, scope.Region.Value
+
+	filterCity := strings.ToLower(strings.TrimSpace(city))
+	filterRegion := strings.ToLower(strings.TrimSpace(region))
+
+	type row struct {
+		ServerID string
+		City     string
+		Region   string
+		Uptime   int64
+		Time     time.Time
+	}
+
+	rows := make([]row, 0, 128)
+	steps := make([]models.Step, 0, 3)
+	page := 1
+	pageSize := c.limits().ListPageSize
+	maxPages := c.limits().MetricsMaxPages
+	var fetched int64
+	for {
+		path := fmt.Sprintf("/metrics/latest?page=%d&page_size=%d&include_totals=false", page, pageSize)
+		status, body, err := c.Gateway.Get(ctx, path)
+		step := models.Step{Tool: "metricsLatest", Status: status}
+		if err != nil {
+			step.Error = err.Error()
+		} else {
+			step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		}
+		steps = append(steps, step)
+		if err != nil {
+			return models.ChatResponse{Answer: "Failed to fetch latest metrics: " + err.Error(), Steps: steps}, true, nil
+		}
+		if status < 200 || status >= 300 {
+			return models.ChatResponse{Answer: fmt.Sprintf("Failed to fetch latest metrics (status %d).", status), Steps: steps}, true, nil
+		}
+
+		var payload struct {
+			Data []struct {
+				Time     time.Time `json:"time"`
+				Uptime   int64     `json:"uptime"`
+				ServerID string    `json:"server_id"`
+				City     string    `json:"city"`
+				Region   string    `json:"region"`
+			} `json:"data"`
+			Pagination gatewayPagination `json:"pagination"`
+		}
+		if json.Unmarshal(body, &payload) != nil {
+			return models.ChatResponse{Answer: "Latest metrics response could not be parsed.", Steps: steps}, true, nil
+		}
+
+		for _, it := range payload.Data {
+			sid := strings.ToLower(strings.TrimSpace(it.ServerID))
+			if sid == "" {
+				continue
+			}
+			itCity := strings.ToLower(strings.TrimSpace(it.City))
+			itRegion := strings.ToLower(strings.TrimSpace(it.Region))
+			if filterCity != "" && itCity != filterCity {
+				continue
+			}
+			if filterRegion != "" && itRegion != filterRegion {
+				continue
+			}
+			rows = append(rows, row{ServerID: sid, City: itCity, Region: itRegion, Uptime: it.Uptime, Time: it.Time})
+		}
+
+		fetched += int64(len(payload.Data))
+		if payload.Pagination.done(len(payload.Data), pageSize, fetched) {
+			break
+		}
+		page++
+		if page > maxPages {
+			break
+		}
+	}
+
+	if len(rows) == 0 {
+		scope := "the current scope"
+		if filterRegion != "" || filterCity != "" {
+			parts := make([]string, 0, 2)
+			if filterRegion != "" {
+				parts = append(parts, "region '"+filterRegion+"'")
+			}
+			if filterCity != "" {
+				parts = append(parts, "city '"+filterCity+"'")
+			}
+			scope = strings.Join(parts, ", ")
+		}
+		answer := fmt.Sprintf("No devices with uptime metrics were found for %s.", scope)
+		if onToken != nil {
+			onToken(answer)
+		}
+		return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		ui := rows[i].Uptime
+		uj := rows[j].Uptime
+		if ui == 0 && uj > 0 {
+			return true
+		}
+		if uj == 0 && ui > 0 {
+			return false
+		}
+		if ui == uj {
+			return rows[i].ServerID < rows[j].ServerID
+		}
+		return ui < uj
+	})
+
+	capped := c.capList(msgLower, len(rows), "devices")
+	limit := capped.Shown
+	explicitN := false
+	if n := extractTopN(msgLower); n > 0 {
+		limit, explicitN = min(n, 50, len(rows)), true
+	}
+
+	listed := make([]string, 0, limit)
+	for _, r := range rows[:limit] {
+		listed = append(listed, r.ServerID)
+	}
+	names, metaSteps := c.deviceLabels(ctx, filterCity, filterRegion, listed)
+	steps = append(steps, metaSteps...)
+
+	lines := make([]string, 0, limit)
+	table := newTextTable("#", "Device", "Uptime", "City", "Region").alignRight(0, 2)
+	snapshot := make([]models.AnswerRow, 0, limit)
+	entities := make([]listedEntity, 0, limit)
+	for i := 0; i < limit; i++ {
+		r := rows[i]
+		label := names[r.ServerID]
+		if label == r.ServerID && (r.City != "" || r.Region != "") {
+			label = fmt.Sprintf("%s (%s/%s)", r.ServerID, r.City, r.Region)
+		}
+		snapshot = append(snapshot, models.AnswerRow{Key: r.ServerID, Label: label, Value: float64(r.Uptime)})
+		entities = append(entities, listedEntity{ID: r.ServerID, Name: names[r.ServerID]})
+		d := time.Duration(r.Uptime) * time.Second
+		if r.Uptime == 0 {
+			lines = append(lines, fmt.Sprintf("%d. %s — uptime unknown/0", i+1, label))
+			table.add(fmt.Sprintf("%d", i+1), names[r.ServerID], "unknown/0", r.City, r.Region)
+		} else {
+			lines = append(lines, fmt.Sprintf("%d. %s — uptime %s", i+1, label, formatDuration(d)))
+			table.add(fmt.Sprintf("%d", i+1), names[r.ServerID], formatDuration(d), r.City, r.Region)
+		}
+	}
+	if wantsTables(req) {
+		lines = table.lines()
+	}
+	if note := capped.note(); note != "" && !explicitN {
+		lines = append(lines, note)
+	}
+
+	c.rememberList(conversationID, listKindDevice, entities)
+	changes := c.answerChanges(ctx, req, "low_uptime", snapshot, func(v float64) string {
+		return formatDuration(time.Duration(v) * time.Second)
+	})
+	answer := withChanges(req, changes, "Devices with lowest uptime:\n"+strings.Join(lines, "\n"))
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+}
+
+func boolToOnOff(val bool) string {
+	if val {
+		return "on"
+	}
+	return "off"
+}
+
+func (c *ChatService) handleMetricsLatestByLocationDetails(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	contains := func(tokens ...string) bool {
+		for _, t := range tokens {
+			if strings.Contains(msgLower, t) {
+				return true
+			}
+		}
+		return false
+	}
+	// Kiosk-wise follow-ups like "kiosk wise" or "split" should return a per-kiosk table.
+	isKioskWise := (contains("kiosk") || contains("kiosks")) && (contains("wise") || contains("split") || contains("registered"))
+	if !contains("metric", "metrics") {
+		return models.ChatResponse{}, false, nil
+	}
+	// Only handle location-wide metrics when the user asks for concrete metrics (cpu/memory/etc)
+	// and does not provide a specific host.
+	if len(detectHostTokens(req.Message)) > 0 {
+		return models.ChatResponse{}, false, nil
+	}
+	if !(contains("cpu", "ram", "memory", "disk", "storage", "temperature", "network", "bandwidth", "uptime")) {
+		return models.ChatResponse{}, false, nil
+	}
+	if !(contains("city", "region") || contains("kiosk", "kiosks", "device", "devices")) {
+		return models.ChatResponse{}, false, nil
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	cityRaw := c.detectCityCode(ctx, msgLower)
+	region := c.detectRegionCode(ctx, msgLower)
+	city, _ := normalizeCitySelection(cityRaw, region, msgLower)
+	if city == "" && region == "" && conversationID != "" {
+		if st := c.getConversationState(conversationID); st != nil {
+			city = strings.ToLower(strings.TrimSpace(st.City))
+			region = strings.ToLower(strings.TrimSpace(st.Region))
+		}
+	}
+	if city == "" && region == "" {
+		return models.ChatResponse{Answer: "Please specify a city and/or region (for example: moco city brt region)."}, true, nil
+	}
+	if conversationID != "" {
+		c.updateConversationLocation(conversationID, city, region)
+		c.clearPending(conversationID)
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+
+	filterCity := strings.ToLower(strings.TrimSpace(city))
+	filterRegion := strings.ToLower(strings.TrimSpace(region))
+
+	type rowMetric struct {
+		ServerID         string  `json:"server_id"`
+		Time             string  `json:"time"`
+		CPU              float64 `json:"cpu"`
+		Memory           float64 `json:"memory"`
+		Disk             float64 `json:"disk"`
+		Temperature      float64 `json:"temperature"`
+		PowerOnline      bool    `json:"power_online"`
+		City             string  `json:"city"`
+		Region           string  `json:"region"`
+		UptimeSeconds    int64   `json:"uptime"`
+		MemoryTotalBytes int64   `json:"memory_total_bytes"`
+		MemoryUsedBytes  int64   `json:"memory_used_bytes"`
+		DiskTotalBytes   int64   `json:"disk_total_bytes"`
+		DiskUsedBytes    int64   `json:"disk_used_bytes"`
+	}
+
+	rows := make([]rowMetric, 0, 64)
+	steps := make([]models.Step, 0, 3)
+	page := 1
+	pageSize := c.limits().ListPageSize
+	maxPages := c.limits().MetricsMaxPages
+	var fetched int64
+	for {
+		path := fmt.Sprintf("/metrics/latest?page=%d&page_size=%d&include_totals=false", page, pageSize)
+		status, body, err := c.Gateway.Get(ctx, path)
+		step := models.Step{Tool: "metricsLatest", Status: status}
+		if err != nil {
+			step.Error = err.Error()
+		} else {
+			step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		}
+		steps = append(steps, step)
+
+		if err != nil {
+			return models.ChatResponse{Answer: "Failed to fetch latest metrics: " + err.Error(), Steps: steps}, true, nil
+		}
+		if status < 200 || status >= 300 {
+			return models.ChatResponse{Answer: fmt.Sprintf("Failed to fetch latest metrics (status %d).", status), Steps: steps}, true, nil
+		}
+		var payload struct {
+			Data       []rowMetric       `json:"data"`
+			Pagination gatewayPagination `json:"pagination"`
+		}
+		if json.Unmarshal(body, &payload) != nil {
+			return models.ChatResponse{Answer: "Latest metrics response could not be parsed.", Steps: steps}, true, nil
+		}
+		for _, r := range payload.Data {
+			rowCity := strings.ToLower(strings.TrimSpace(r.City))
+			rowRegion := strings.ToLower(strings.TrimSpace(r.Region))
+			if filterCity != "" && rowCity != filterCity {
+				continue
+			}
+			if filterRegion != "" && rowRegion != filterRegion {
+				continue
+			}
+			if strings.TrimSpace(r.ServerID) == "" {
+				continue
+			}
+			rows = append(rows, r)
+		}
+		fetched += int64(len(payload.Data))
+		if payload.Pagination.done(len(payload.Data), pageSize, fetched) {
+			break
+		}
+		page++
+		if page > maxPages {
+			break
+		}
+	}
+
+	if len(rows) == 0 {
+		scope := "the requested scope"
+		if filterRegion != "" {
+			scope = fmt.Sprintf("region '%s'", filterRegion)
+		} else if filterCity != "" {
+			scope = fmt.Sprintf("city '%s'", filterCity)
+		}
+		return models.ChatResponse{Answer: fmt.Sprintf("No latest metrics were found for %s.", scope), Steps: steps}, true, nil
+	}
+
+	now := time.Now().UTC()
+	online := 0
+	var cpuSum, memSum, diskSum, tempSum float64
+	latestTime := ""
+	for _, r := range rows {
+		// The `power_online` field appears unreliable in some deployments (often false for all rows).
+		// Treat a device as online if it reported within the last 5 minutes.
+		if t, err := time.Parse(time.RFC3339, strings.TrimSpace(r.Time)); err == nil {
+			if now.Sub(t) <= 5*time.Minute {
+				online++
+			}
+		}
+		cpuSum += r.CPU
+		memSum += r.Memory
+		diskSum += r.Disk
+		tempSum += r.Temperature
+		if r.Time != "" {
+			// Keep the max lexicographically; RFC3339 sorts lexicographically.
+			if latestTime == "" || r.Time > latestTime {
+				latestTime = r.Time
+			}
+		}
+	}
+	count := len(rows)
+	avgCPU := cpuSum / float64(count)
+	avgMem := memSum / float64(count)
+	avgDisk := diskSum / float64(count)
+	avgTemp := tempSum / float64(count)
+
+	// Top offenders
+	type ranked struct {
+		ID  string
+		Val float64
+	}
+	topN := func(get func(rowMetric) float64, n int) []ranked {
+		out := make([]ranked, 0, len(rows))
+		for _, r := range rows {
+			out = append(out, ranked{ID: r.ServerID, Val: get(r)})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Val > out[j].Val })
+		if n > len(out) {
+			n = len(out)
+		}
+		return out[:n]
+	}
+
+	scopeLabel := ""
+	if filterRegion != "" {
+		scopeLabel = fmt.Sprintf("region '%s'", filterRegion)
+	} else {
+		scopeLabel = fmt.Sprintf("city '%s'", filterCity)
+	}
+
+	lines := make([]string, 0, 30)
+	units := c.unitPrefs(req)
+	limits := c.thresholds(ctx)
+	report := newThresholdReport()
+	breaches := make(map[string][]thresholdBreach, len(rows))
+	for _, r := range rows {
+		b := limits.checkAll(units,
+			thresholdReading{Metric: models.ThresholdTemperature, Value: r.Temperature},
+			thresholdReading{Metric: models.ThresholdMemory, Value: r.Memory},
+			thresholdReading{Metric: models.ThresholdDisk, Value: r.Disk},
+		)
+		report.add(r.ServerID, b)
+		breaches[r.ServerID] = b
+	}
+	// flagged returns " " and the markers of host's breaches of metric, or
+	// "" when it has none.
+	flagged := func(host, metric string) string {
+		var of []thresholdBreach
+		for _, b := range breaches[host] {
+			if metric == "" || b.Metric == metric {
+				of = append(of, b)
+			}
+		}
+		if len(of) == 0 {
+			return ""
+		}
+		return " " + thresholdMarkers(of)
+	}
+	lines = append(lines, fmt.Sprintf("Latest metrics for %s: %d devices (%d online). Avg CPU %s, memory %s, disk %s, temp %s.%s",
+		scopeLabel, count, online, formatPercent(avgCPU), formatPercent(avgMem), formatPercent(avgDisk), units.temp(avgTemp),
+		func() string {
+			if latestTime == "" {
+				return ""
+			}
+			return " (latest " + latestTime + ")"
+		}(),
+	))
+
+	if isKioskWise {
+		// Show a per-kiosk table. Sort by the most relevant metric requested.
+		sortMetric := func(r rowMetric) float64 {
+			if contains("cpu") {
+				return r.CPU
+			}
+			if contains("memory") || contains("ram") {
+				return r.Memory
+			}
+			if contains("disk") || contains("storage") {
+				return r.Disk
+			}
+			if contains("temp") || contains("temperature") {
+				return r.Temperature
+			}
+			// Default: CPU.
+			return r.CPU
+		}
+		sorted := make([]rowMetric, 0, len(rows))
+		sorted = append(sorted, rows...)
+		sort.Slice(sorted, func(i, j int) bool { return sortMetric(sorted[i]) > sortMetric(sorted[j]) })
+		capped := c.capList(msgLower, len(sorted), "kiosks")
+		lines = append(lines, "Kiosk-wise:")
+		for i, r := range sorted[:capped.Shown] {
+			lines = append(lines, fmt.Sprintf("%d. %s — CPU %s | Mem %s | Disk %s | Temp %s%s",
+				i+1, r.ServerID, formatPercent(r.CPU), formatPercent(r.Memory), formatPercent(r.Disk), units.temp(r.Temperature), flagged(r.ServerID, ""),
+			))
+		}
+		if note := capped.note(); note != "" {
+			lines = append(lines, note)
+		}
+		answer := report.prepend(strings.Join(lines, "\n"), count)
+		if onToken != nil {
+			onToken(answer)
+		}
+		return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+	}
+	if contains("cpu") {
+		cpuTop := topN(func(r rowMetric) float64 { return r.CPU }, 5)
+		lines = append(lines, "Top CPU:")
+		for i, it := range cpuTop {
+			lines = append(lines, fmt.Sprintf("%d. %s — %s", i+1, it.ID, formatPercent(it.Val)))
+		}
+	}
+	if contains("memory") || contains("ram") {
+		memTop := topN(func(r rowMetric) float64 { return r.Memory }, 5)
+		lines = append(lines, "Top memory:")
+		for i, it := range memTop {
+			lines = append(lines, fmt.Sprintf("%d. %s — %s%s", i+1, it.ID, formatPercent(it.Val), flagged(it.ID, models.ThresholdMemory)))
+		}
+	}
+	if contains("disk") || contains("storage") {
+		diskTop := topN(func(r rowMetric) float64 { return r.Disk }, 5)
+		lines = append(lines, "Top disk:")
+		for i, it := range diskTop {
+			lines = append(lines, fmt.Sprintf("%d. %s — %s%s", i+1, it.ID, formatPercent(it.Val), flagged(it.ID, models.ThresholdDisk)))
+		}
+	}
+	if contains("temp") {
+		tempTop := topN(func(r rowMetric) float64 { return r.Temperature }, 5)
+		lines = append(lines, "Top temperature:")
+		for i, it := range tempTop {
+			lines = append(lines, fmt.Sprintf("%d. %s — %s%s", i+1, it.ID, units.temp(it.Val), flagged(it.ID, models.ThresholdTemperature)))
+		}
+	}
+	answer := report.prepend(strings.Join(lines, "\n"), count)
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+}
+
+func (c *ChatService) handleMetricsTodayByLocation(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !(strings.Contains(msgLower, "metric") || strings.Contains(msgLower, "metrics")) {
+		return models.ChatResponse{}, false, nil
+	}
+	if !(strings.Contains(msgLower, "today") || strings.Contains(msgLower, "today's") || strings.Contains(msgLower, "todays")) {
+		return models.ChatResponse{}, false, nil
+	}
+	// If the user specified a host, the per-host telemetry handler should handle it.
+	if len(detectHostTokens(req.Message)) > 0 {
+		return models.ChatResponse{}, false, nil
+	}
+
+	conversationID := strings.TrimSpace(req.ConversationID)
+	cityRaw := c.detectCityCode(ctx, msgLower)
+	region := c.detectRegionCode(ctx, msgLower)
+	city, _ := normalizeCitySelection(cityRaw, region, msgLower)
+	if city == "" && region == "" && conversationID != "" {
+		// Reuse last location context for follow-ups like "show today's metrics".
+		if st := c.getConversationState(conversationID); st != nil {
+			if strings.TrimSpace(st.City) != "" {
+				city = strings.ToLower(strings.TrimSpace(st.City))
+			}
+			if strings.TrimSpace(st.Region) != "" {
+				region = strings.ToLower(strings.TrimSpace(st.Region))
+			}
+		}
+	}
+	if city == "" && region == "" {
+		// Don't fall through into host telemetry prompts for "today's metrics".
+		return models.ChatResponse{Answer: "Please specify a city and/or region (for example: city moco, region brt)."}, true, nil
+	}
+	if strings.TrimSpace(req.ConversationID) != "" {
+		c.updateConversationLocation(req.ConversationID, city, region)
+		if strings.TrimSpace(region) != "" {
+			c.updateConversationLocation(req.ConversationID, "", region)
+		}
+		c.clearPending(req.ConversationID)
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+
+	filterCity := strings.ToLower(strings.TrimSpace(city))
+	filterRegion := strings.ToLower(strings.TrimSpace(region))
+	trend := c.startTrend(ctx, filterCity, filterRegion, trendMetricsWindow, time.Now())
+	defer trend.stop()
+
+	count := 0
+	online := 0
+	var cpuSum, memSum, tempSum float64
+	var dailyRx, dailyTx, monthlyRx, monthlyTx int64
+	latest := time.Time{}
+	units := c.unitPrefs(req)
+	limits := c.thresholds(ctx)
+	report := newThresholdReport()
+
+	steps := make([]models.Step, 0, 3)
+	page := 1
+	pageSize := c.limits().ListPageSize
+	maxPages := c.limits().MetricsMaxPages
+	var fetched int64
+	for {
+		path := fmt.Sprintf("/metrics/latest?page=%d&page_size=%d&include_totals=false", page, pageSize)
+		status, body, err := c.Gateway.Get(ctx, path)
+		step := models.Step{Tool: "metricsLatest", Status: status}
+		if err != nil {
+			step.Error = err.Error()
+		} else {
+			step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		}
+		steps = append(steps, step)
+
+		if err != nil {
+			return models.ChatResponse{Answer: "Failed to fetch latest metrics: " + err.Error(), Steps: steps}, true, nil
+		}
+		if status < 200 || status >= 300 {
+			return models.ChatResponse{Answer: fmt.Sprintf("Failed to fetch latest metrics (status %d).", status), Steps: steps}, true, nil
+		}
+
+		var payload struct {
+			Data []struct {
+				ServerID          string    `json:"server_id"`
+				Time              time.Time `json:"time"`
+				CPU               float64   `json:"cpu"`
+				Memory            float64   `json:"memory"`
+				Temperature       float64   `json:"temperature"`
+				NetDailyRxBytes   int64     `json:"net_daily_rx_bytes"`
+				NetDailyTxBytes   int64     `json:"net_daily_tx_bytes"`
+				NetMonthlyRxBytes int64     `json:"net_monthly_rx_bytes"`
+				NetMonthlyTxBytes int64     `json:"net_monthly_tx_bytes"`
+				PowerOnline       bool      `json:"power_online"`
+				City              string    `json:"city"`
+				Region            string    `json:"region"`
+			} `json:"data"`
+			Pagination gatewayPagination `json:"pagination"`
+		}
+		if json.Unmarshal(body, &payload) != nil {
+			return models.ChatResponse{Answer: "Latest metrics response could not be parsed.", Steps: steps}, true, nil
+		}
+
+		for _, row := range payload.Data {
+			rowCity := strings.ToLower(strings.TrimSpace(row.City))
+			rowRegion := strings.ToLower(strings.TrimSpace(row.Region))
+			if filterCity != "" && rowCity != filterCity {
+				continue
+			}
+			if filterRegion != "" && rowRegion != filterRegion {
+				continue
+			}
+			count++
+			if row.PowerOnline {
+				online++
+			}
+			cpuSum += row.CPU
+			memSum += row.Memory
+			tempSum += row.Temperature
+			report.add(strings.ToLower(strings.TrimSpace(row.ServerID)), limits.checkAll(units,
+				thresholdReading{Metric: models.ThresholdTemperature, Value: row.Temperature},
+				thresholdReading{Metric: models.ThresholdMemory, Value: row.Memory},
+			))
+			dailyRx += row.NetDailyRxBytes
+			dailyTx += row.NetDailyTxBytes
+			monthlyRx += row.NetMonthlyRxBytes
+			monthlyTx += row.NetMonthlyTxBytes
+			if row.Time.After(latest) {
+				latest = row.Time
+			}
+		}
+
+		fetched += int64(len(payload.Data))
+		if payload.Pagination.done(len(payload.Data), pageSize, fetched) {
+			break
+		}
+		page++
+		if page > maxPages {
+			break
+		}
+	}
+
+	scopeParts := make([]string, 0, 2)
+	if filterCity != "" {
+		scopeParts = append(scopeParts, fmt.Sprintf("city '%s'", filterCity))
+	}
+	if filterRegion != "" {
+		scopeParts = append(scopeParts, fmt.Sprintf("region '%s'", filterRegion))
+	}
+	scopeLabel := strings.Join(scopeParts, ", ")
+	if scopeLabel == "" {
+		scopeLabel = "the requested scope"
+	}
+
+	if count == 0 {
+		answer := fmt.Sprintf("No latest metrics were found for %s.", scopeLabel)
+		if onToken != nil {
+			onToken(answer)
+		}
+		return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+	}
+
+	avgCPU := cpuSum / float64(count)
+	avgMem := memSum / float64(count)
+	avgTemp := tempSum / float64(count)
+
+	answer := fmt.Sprintf(
+		"Today's metrics for %s: %d devices (%d online). Network daily RX %s, TX %s | monthly RX %s, TX %s. Avg CPU %s, memory %s, temp %s. (latest %s UTC).",
+		scopeLabel,
+		count,
+		online,
+		units.bytes(dailyRx),
+		units.bytes(dailyTx),
+		units.bytes(monthlyRx),
+		units.bytes(monthlyTx),
+		formatPercent(avgCPU),
+		formatPercent(avgMem),
+		units.temp(avgTemp),
+		latest.Format(time.RFC3339),
+	)
+	if snap := trend.wait(); snap != nil {
+		answer += " " + metricsTrendHints(units, dailyRx+dailyTx, avgCPU, snap)
+		steps = append(steps, snap.Step)
+	}
+	answer = report.prepend(answer, count)
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+}
+
+func (c *ChatService) handleDeviceTelemetry(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	contains := func(tokens ...string) bool {
+		for _, token := range tokens {
+			if strings.Contains(msgLower, token) {
+				return true
+			}
+		}
+		return false
+	}
+	// Avoid misrouting analytics/POP/stats queries to telemetry just because they mention "kiosk" or "device".
+	// Telemetry intent should be explicit (telemetry/health/status/metrics).
+	if strings.Contains(msgLower, "analytic") || strings.Contains(msgLower, "pop") || strings.Contains(msgLower, "stats") {
+		if !(contains("telemetry", "health", "device status", "metrics", "temperature", "battery", "uptime", "disk", "storage", "cpu", "ram", "volume", "mute", "network", "bandwidth", "data usage")) {
+			return models.ChatResponse{}, false, nil
+		}
+	}
+
+	wantsTemp := contains("temp", "temperature", "heat")
+	units := c.unitPrefs(req)
+	wantsVolume := contains("volume", "sound", "speaker", "audio")
+	wantsMute := contains("mute", "muted", "unmute")
+	wantsPower := contains("power", "online", "offline")
+	wantsBattery := contains("battery")
+	wantsDisplay := contains("display", "screen", "panel")
+	wantsFan := contains("fan")
+	wantsCPU := contains("cpu", "processor")
+	wantsMemory := contains("memory", "ram")
+	wantsDisk := contains("disk", "storage")
+	// Network usage is often phrased as "how much data <host> is using".
+	wantsNetwork := contains("network", "bandwidth", "traffic", "throughput", "internet", "data usage", "data-use", "consumed", "consumption", "usage", "using")
+	wantsProcesses := contains("process", "service", "app", "apps", "kiosk")
+	wantsInputDevices := contains("input", "usb", "peripheral")
+	wantsUptime := contains("uptime")
+	wantsTelemetry := contains("telemetry", "status", "health", "metrics", "device status")
+	if !wantsNetwork {
+		// Heuristic: "how much data" implies usage even without the explicit token "usage".
+		if strings.Contains(msgLower, "how much") && strings.Contains(msgLower, "data") {
+			wantsNetwork = true
+		}
+	}
+	if wantsTelemetry {
+		wantsTemp = true
+		wantsVolume = true
+		wantsPower = true
+		wantsBattery = true
+		wantsDisplay = true
+		wantsFan = true
+		wantsCPU = true
+		wantsMemory = true
+		wantsDisk = true
+		wantsNetwork = true
+		wantsProcesses = true
+		wantsInputDevices = true
+		wantsUptime = true
+		wantsMute = true
+	}
+	if !(wantsTemp || wantsVolume || wantsMute || wantsPower || wantsBattery || wantsDisplay || wantsFan || wantsCPU || wantsMemory || wantsDisk || wantsNetwork || wantsProcesses || wantsInputDevices || wantsUptime) {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+
+	hostTokens := detectHostTokens(req.Message)
+	if len(hostTokens) == 0 {
+		conversationID := strings.TrimSpace(req.ConversationID)
+		if conversationID != "" {
+			// If we already resolved a host earlier in this conversation, reuse it.
+			if st := c.getConversationState(conversationID); st != nil {
+				lastHost := strings.TrimSpace(st.Host)
+				if lastHost != "" {
+					req2 := req
+					req2.Message = strings.TrimSpace(req.Message) + " " + lastHost
+					return c.handleDeviceTelemetry(ctx, req2, onToken)
+				}
+			}
+			c.setPending(conversationID, "deviceTelemetry", req.Message)
+		}
+		return models.ChatResponse{Answer: "Please specify the device or server name (for example: dart2)."}, true, nil
+	}
+
+	host := strings.ToLower(strings.TrimSpace(hostTokens[0]))
+	if host == "" {
+		conversationID := strings.TrimSpace(req.ConversationID)
+		if conversationID != "" {
+			c.setPending(conversationID, "deviceTelemetry", req.Message)
+		}
+		return models.ChatResponse{Answer: "Please specify the device or server name (for example: dart2)."}, true, nil
+	}
+	if strings.TrimSpace(req.ConversationID) != "" {
+		c.updateConversationHost(req.ConversationID, host)
+		// Derive city/region from host prefix when possible (e.g. moco-brt-...).
+		parts := strings.Split(strings.ReplaceAll(host, "_", "-"), "-")
+		if len(parts) >= 2 {
+			c.updateConversationLocation(req.ConversationID, parts[0], parts[1])
+		}
+		c.clearPending(req.ConversationID)
+	}
+
+	includeTotals := "false"
+	if wantsNetwork {
+		includeTotals = "true"
+	}
+	path := "/metrics/history?page=1&page_size=1&include_totals=" + includeTotals + "&server_id=" + urlEscape(host)
+	status, body, err := c.Gateway.Get(ctx, path)
+	step := models.Step{Tool: "metricsHistory", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+	} else {
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	}
+	steps := []models.Step{step}
+
+	answer := ""
+	if err != nil {
+		answer = "Failed to fetch telemetry data: " + err.Error()
+	} else if status < 200 || status >= 300 {
+		answer = fmt.Sprintf("Failed to fetch telemetry data (status %d).", status)
+	} else {
+		var payload struct {
+			Data []struct {
+				Time                 time.Time `json:"time"`
+				CPU                  float64   `json:"cpu"`
+				Memory               float64   `json:"memory"`
+				Temperature          float64   `json:"temperature"`
+				ChassisTemperature   float64   `json:"chassis_temperature"`
+				HotspotTemperature   float64   `json:"hotspot_temperature"`
+				SoundVolumePercent   float64   `json:"sound_volume_percent"`
+				SoundMuted           bool      `json:"sound_muted"`
+				PowerOnline          bool      `json:"power_online"`
+				BatteryPresent       bool      `json:"battery_present"`
+				BatteryChargePercent int64     `json:"battery_charge_percent"`
+				DisplayConnected     bool      `json:"display_connected"`
+				DisplayWidth         int64     `json:"display_width"`
+				DisplayHeight        int64     `json:"display_height"`
+				DisplayRefreshHz     int64     `json:"display_refresh_hz"`
+				DisplayPrimary       bool      `json:"display_primary"`
+				DisplayDpmsEnabled   bool      `json:"display_dpms_enabled"`
+				FanRPM               int64     `json:"fan_rpm"`
+				Disk                 float64   `json:"disk"`
+				DiskTotalBytes       int64     `json:"disk_total_bytes"`
+				DiskUsedBytes        int64     `json:"disk_used_bytes"`
+				NetBytesSent         int64     `json:"net_bytes_sent"`
+				NetBytesRecv         int64     `json:"net_bytes_recv"`
+				NetDailyRxBytes      int64     `json:"net_daily_rx_bytes"`
+				NetDailyTxBytes      int64     `json:"net_daily_tx_bytes"`
+				NetMonthlyRxBytes    int64     `json:"net_monthly_rx_bytes"`
+				NetMonthlyTxBytes    int64     `json:"net_monthly_tx_bytes"`
+				InputDevicesHealthy  int64     `json:"input_devices_healthy"`
+				InputDevicesMissing  int64     `json:"input_devices_missing"`
+				ProcessStatuses      []struct {
+					Name         string `json:"name"`
+					Running      bool   `json:"running"`
+					ProcessCount int    `json:"process_count"`
+				} `json:"process_statuses"`
+				LinkState struct {
+					Interface  string `json:"interface"`
+					Type       string `json:"type"`
+					LinkUp     bool   `json:"link_up"`
+					SpeedMbps  int    `json:"speed_mbps"`
+					DuplexFull bool   `json:"duplex_full"`
+					Autoneg    bool   `json:"autoneg"`
+					RXDropped  int64  `json:"rx_dropped"`
+				} `json:"link_state"`
+				Uptime int64 `json:"uptime"`
+			} `json:"data"`
+			Totals map[string]any `json:"totals"`
+		}
+		if json.Unmarshal(body, &payload) != nil || len(payload.Data) == 0 {
+			answer = fmt.Sprintf("No telemetry was found for device '%s'.", host)
+		} else {
+			entry := payload.Data[0]
+			limits := c.thresholds(ctx)
+			report := newThresholdReport()
+			// flag appends the markers of readings outside their thresholds.
+			flag := func(section string, readings ...thresholdReading) string {
+				if markers := report.add(host, limits.checkAll(units, readings...)); markers != "" {
+					return section + " " + markers
+				}
+				return section
+			}
+			var sections []string
+			if wantsTemp {
+				tempChunks := make([]string, 0, 3)
+				tempChunks = append(tempChunks, "ambient "+units.temp(entry.Temperature))
+				readings := []thresholdReading{{Metric: models.ThresholdTemperature, Value: entry.Temperature}}
+				if entry.ChassisTemperature != 0 {
+					tempChunks = append(tempChunks, "chassis "+units.temp(entry.ChassisTemperature))
+					readings = append(readings, thresholdReading{Metric: models.ThresholdTemperature, Label: "chassis temperature", Value: entry.ChassisTemperature})
+				}
+				if entry.HotspotTemperature != 0 {
+					tempChunks = append(tempChunks, "hotspot "+units.temp(entry.HotspotTemperature))
+					readings = append(readings, thresholdReading{Metric: models.ThresholdTemperature, Label: "hotspot temperature", Value: entry.HotspotTemperature})
+				}
+				sections = append(sections, flag("Temperature: "+strings.Join(tempChunks, ", "), readings...))
+			}
+			if wantsVolume || wantsMute {
+				vol := formatPercent(entry.SoundVolumePercent)
+				if wantsMute {
+					if entry.SoundMuted {
+						sections = append(sections, fmt.Sprintf("Volume muted (level %s).", vol))
+					} else {
+						sections = append(sections, fmt.Sprintf("Volume active at %s (not muted).", vol))
+					}
+				} else {
+					status := "Volume " + vol
+					if entry.SoundMuted {
+						status += " (muted)"
+					}
+					sections = append(sections, status)
+				}
+			}
+			if wantsPower {
+				state := "Power offline"
+				if entry.PowerOnline {
+					state = "Power online"
+				}
+				sections = append(sections, state)
+			}
+			if wantsBattery {
+				if entry.BatteryPresent {
+					sections = append(sections, flag(fmt.Sprintf("Battery %d%% charge.", entry.BatteryChargePercent), thresholdReading{Metric: models.ThresholdBattery, Value: float64(entry.BatteryChargePercent)}))
+				} else {
+					sections = append(sections, "Battery not present.")
+				}
+			}
+			if wantsDisplay {
+				if entry.DisplayConnected {
+					sections = append(sections, fmt.Sprintf("Display %dx%d @ %dHz (DPMS %v).", entry.DisplayWidth, entry.DisplayHeight, entry.DisplayRefreshHz, boolToOnOff(!entry.DisplayDpmsEnabled)))
+				} else {
+					sections = append(sections, "Display disconnected.")
+				}
+			}
+			if wantsFan {
+				sections = append(sections, fmt.Sprintf("Fan %d RPM.", entry.FanRPM))
+			}
+			if wantsCPU || wantsMemory {
+				var stats []string
+				var readings []thresholdReading
+				if wantsCPU {
+					stats = append(stats, fmt.Sprintf("CPU %s", formatPercent(entry.CPU)))
+				}
+				if wantsMemory {
+					stats = append(stats, fmt.Sprintf("Memory %s", formatPercent(entry.Memory)))
+					readings = append(readings, thresholdReading{Metric: models.ThresholdMemory, Value: entry.Memory})
+				}
+				if len(stats) > 0 {
+					sections = append(sections, flag(strings.Join(stats, ", "), readings...))
+				}
+			}
+			if wantsDisk {
+				sections = append(sections, flag(fmt.Sprintf("Disk %s used (%s/%s).", formatPercent(entry.Disk), units.bytes(entry.DiskUsedBytes), units.bytes(entry.DiskTotalBytes)), thresholdReading{Metric: models.ThresholdDisk, Value: entry.Disk}))
+			}
+			if wantsNetwork {
+				monthlyRx := int64(0)
+				monthlyTx := int64(0)
+				monthlyFromTotals := false
+				if payload.Totals != nil {
+					if v, ok := payload.Totals["net_monthly_rx_bytes"]; ok {
+						switch vv := v.(type) {
+						case float64:
+							monthlyRx = int64(vv)
+							monthlyFromTotals = true
+						case int64:
+							monthlyRx = vv
+							monthlyFromTotals = true
+						case json.Number:
+							if n, e := vv.Int64(); e == nil {
+								monthlyRx = n
+								monthlyFromTotals = true
+							}
+						}
+					}
+					if v, ok := payload.Totals["net_monthly_tx_bytes"]; ok {
+						switch vv := v.(type) {
+						case float64:
+							monthlyTx = int64(vv)
+							monthlyFromTotals = true
+						case int64:
+							monthlyTx = vv
+							monthlyFromTotals = true
+						case json.Number:
+							if n, e := vv.Int64(); e == nil {
+								monthlyTx = n
+								monthlyFromTotals = true
+							}
+						}
+					}
+				}
+				if !monthlyFromTotals {
+					// Some metrics deployments return monthly totals on the per-record entry.
+					monthlyRx = entry.NetMonthlyRxBytes
+					monthlyTx = entry.NetMonthlyTxBytes
+				}
+				netLine := fmt.Sprintf(
+					"Network current RX %s, TX %s | daily RX %s, TX %s",
+					units.bytes(entry.NetBytesRecv),
+					units.bytes(entry.NetBytesSent),
+					units.bytes(entry.NetDailyRxBytes),
+					units.bytes(entry.NetDailyTxBytes),
+				)
+				netLine += fmt.Sprintf(" | monthly RX %s, TX %s", units.bytes(monthlyRx), units.bytes(monthlyTx))
+				sections = append(sections, netLine+".")
+			}
+			if wantsProcesses && len(entry.ProcessStatuses) > 0 {
+				var offline []string
+				for _, ps := range entry.ProcessStatuses {
+					if !ps.Running {
+						offline = append(offline, ps.Name)
+					}
+				}
+				if len(offline) == 0 {
+					sections = append(sections, "All monitored processes running.")
+				} else {
+					sections = append(sections, "Processes down: "+strings.Join(offline, ", "))
+				}
+			}
+			if wantsInputDevices {
+				sections = append(sections, flag(fmt.Sprintf("Input devices healthy %d, missing %d.", entry.InputDevicesHealthy, entry.InputDevicesMissing), thresholdReading{Metric: models.ThresholdInputMissing, Value: float64(entry.InputDevicesMissing)}))
+			}
+			if wantsNetwork && (entry.LinkState.Interface != "" || entry.LinkState.SpeedMbps > 0) {
+				link := entry.LinkState
+				status := "link down"
+				if link.LinkUp {
+					status = "link up"
+				}
+				sections = append(sections, fmt.Sprintf("Interface %s (%s) %s @ %dMbps, duplex=%v.", link.Interface, link.Type, status, link.SpeedMbps, link.DuplexFull))
+			}
+			if wantsUptime && entry.Uptime > 0 {
+				uptime := time.Duration(entry.Uptime) * time.Second
+				sections = append(sections, fmt.Sprintf("Uptime %s.", uptime.String()))
+			}
+			if len(sections) == 0 {
+				sections = append(sections, "No matching telemetry fields requested.")
+			}
+			if c.BaselineAnnotations {
+				current := map[string]float64{}
+				if wantsCPU {
+					current["cpu"] = entry.CPU
+				}
+				if wantsMemory {
+					current["memory"] = entry.Memory
+				}
+				if wantsTemp {
+					current["temperature"] = entry.Temperature
+				}
+				note, baselineSteps := c.baselineNotes(ctx, host, units, current)
+				if note != "" {
+					sections = append(sections, note)
+				}
+				steps = append(steps, baselineSteps...)
+			}
+			timestamp := entry.Time.UTC().Format(time.RFC3339)
+			answer = report.prepend(fmt.Sprintf("Latest telemetry for '%s': %s (recorded %s UTC).", host, strings.Join(sections, " | "), timestamp), 1)
+		}
+	}
+
+	answer = c.withDeviceNotes(ctx, req, host, answer)
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+}
+
+type hostTelemetrySample struct {
+	Found               bool
+	CPU                 float64
+	Temperature         float64
+	PowerOnline         bool
+	Memory              float64
+	Disk                float64
+	BatteryPresent      bool
+	BatteryCharge       float64
+	InputDevicesMissing float64
+}
+
+// thresholdReadings are the sample's values graded against the telemetry
+// thresholds.
+func (s hostTelemetrySample) thresholdReadings() []thresholdReading {
+	out := []thresholdReading{
+		{Metric: models.ThresholdTemperature, Value: s.Temperature},
+		{Metric: models.ThresholdMemory, Value: s.Memory},
+		{Metric: models.ThresholdDisk, Value: s.Disk},
+		{Metric: models.ThresholdInputMissing, Value: s.InputDevicesMissing},
+	}
+	if s.BatteryPresent {
+		out = append(out, thresholdReading{Metric: models.ThresholdBattery, Value: s.BatteryCharge})
+	}
+	return out
+}
+
+func (c *ChatService) fetchHostLatestTelemetry(ctx context.Context, host string) (hostTelemetrySample, models.Step) {
+	status, body, err := c.Gateway.Get(ctx, "/metrics/history?page=1&page_size=1&include_totals=false&server_id="+urlEscape(host))
+	step := models.Step{Tool: "metricsHistory", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+		return hostTelemetrySample{}, step
+	}
+	step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	if status < 200 || status >= 300 {
+		return hostTelemetrySample{}, step
+	}
+	var payload struct {
+		Data []struct {
+			CPU                  float64 `json:"cpu"`
+			Temperature          float64 `json:"temperature"`
+			PowerOnline          bool    `json:"power_online"`
+			Memory               float64 `json:"memory"`
+			Disk                 float64 `json:"disk"`
+			BatteryPresent       bool    `json:"battery_present"`
+			BatteryChargePercent int64   `json:"battery_charge_percent"`
+			InputDevicesMissing  int64   `json:"input_devices_missing"`
+		} `json:"data"`
+	}
+	if json.Unmarshal(body, &payload) != nil || len(payload.Data) == 0 {
+		return hostTelemetrySample{}, step
+	}
+	e := payload.Data[0]
+	return hostTelemetrySample{
+		Found:               true,
+		CPU:                 e.CPU,
+		Temperature:         e.Temperature,
+		PowerOnline:         e.PowerOnline,
+		Memory:              e.Memory,
+		Disk:                e.Disk,
+		BatteryPresent:      e.BatteryPresent,
+		BatteryCharge:       float64(e.BatteryChargePercent),
+		InputDevicesMissing: float64(e.InputDevicesMissing),
+	}, step
+}
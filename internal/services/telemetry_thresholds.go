@@ -0,0 +1,434 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"openai-agent-service/internal/models"
+)
+
+// TelemetryThresholdStore persists each API key's threshold overrides;
+// implemented by store.PostgresStore.
+type TelemetryThresholdStore interface {
+	SetTelemetryThreshold(ctx context.Context, t models.TelemetryThreshold) (models.TelemetryThreshold, error)
+	ListTelemetryThresholds(ctx context.Context, ownerKey string) ([]models.TelemetryThreshold, error)
+	DeleteTelemetryThresholds(ctx context.Context, ownerKey, metric string) (int64, error)
+}
+
+const (
+	severityWarning  = "warning"
+	severityCritical = "critical"
+)
+
+// thresholdSpec describes one metric of the threshold table: how it is
+// named, which way it breaches and how its readings and tiers render.
+type thresholdSpec struct {
+	Metric string
+	Label  string
+	// Below marks metrics that breach by falling to a tier (battery charge)
+	// rather than rising to it.
+	Below bool
+	// Max bounds the tiers a caller may set; 0 is unbounded.
+	Max float64
+	// Default is used when the service configures no tiers for the metric.
+	Default models.TelemetryThreshold
+	format  func(u unitPrefs, v float64, tier bool) string
+}
+
+// telemetryThresholdTable is the one list every telemetry answer grades its
+// readings against; adding a metric here is all a new threshold needs.
+var telemetryThresholdTable = []thresholdSpec{
+	{Metric: models.ThresholdTemperature, Label: "temperature", Default: models.TelemetryThreshold{Warning: 70, Critical: 85}, format: formatThresholdTemp},
+	{Metric: models.ThresholdDisk, Label: "disk", Max: 100, Default: models.TelemetryThreshold{Warning: 85, Critical: 95}, format: formatThresholdPercent},
+	{Metric: models.ThresholdMemory, Label: "memory", Max: 100, Default: models.TelemetryThreshold{Warning: 85, Critical: 95}, format: formatThresholdPercent},
+	{Metric: models.ThresholdBattery, Label: "battery", Below: true, Max: 100, Default: models.TelemetryThreshold{Warning: 20, Critical: 10}, format: formatThresholdPercent},
+	{Metric: models.ThresholdInputMissing, Label: "input devices missing", Default: models.TelemetryThreshold{Warning: 1, Critical: 2}, format: formatThresholdCount},
+}
+
+func thresholdSpecFor(metric string) (thresholdSpec, bool) {
+	for _, sp := range telemetryThresholdTable {
+		if sp.Metric == metric {
+			return sp, true
+		}
+	}
+	return thresholdSpec{}, false
+}
+
+func formatThresholdTemp(u unitPrefs, v float64, tier bool) string {
+	if !tier {
+		return u.temp(v)
+	}
+	if u.Fahrenheit {
+		return trimFloat(v*9/5+32) + "°F"
+	}
+	return trimFloat(v) + "°C"
+}
+
+func formatThresholdPercent(_ unitPrefs, v float64, tier bool) string {
+	if tier {
+		return trimFloat(v) + "%"
+	}
+	return formatPercent(v)
+}
+
+func formatThresholdCount(_ unitPrefs, v float64, _ bool) string {
+	return trimFloat(v)
+}
+
+// trimFloat renders v with at most one decimal and no trailing ".0".
+func trimFloat(v float64) string {
+	return strconv.FormatFloat(math.Round(v*10)/10, 'f', -1, 64)
+}
+
+// thresholdSet is the tiers in effect for one request: the built-in
+// defaults, then the service configuration, then the caller's overrides.
+type thresholdSet struct {
+	tiers map[string]models.TelemetryThreshold
+	// overridden marks the metrics the caller's API key overrides.
+	overridden map[string]bool
+}
+
+func (c *ChatService) baseThresholds() thresholdSet {
+	s := thresholdSet{tiers: map[string]models.TelemetryThreshold{}, overridden: map[string]bool{}}
+	for _, sp := range telemetryThresholdTable {
+		s.tiers[sp.Metric] = sp.Default
+	}
+	for _, t := range c.TelemetryThresholds {
+		if _, ok := s.tiers[t.Metric]; ok {
+			s.tiers[t.Metric] = models.TelemetryThreshold{Warning: t.Warning, Critical: t.Critical}
+		}
+	}
+	return s
+}
+
+// ownerThresholds returns the tiers in effect for ownerKey. A store failure
+// is logged and the service tiers are used.
+func (c *ChatService) ownerThresholds(ctx context.Context, ownerKey string) thresholdSet {
+	s := c.baseThresholds()
+	if c.Thresholds == nil || ownerKey == "" {
+		return s
+	}
+	overrides, err := c.Thresholds.ListTelemetryThresholds(ctx, ownerKey)
+	if err != nil {
+		log.Printf("telemetry thresholds: %v", err)
+		return s
+	}
+	for _, t := range overrides {
+		if _, ok := s.tiers[t.Metric]; ok {
+			s.tiers[t.Metric] = models.TelemetryThreshold{Warning: t.Warning, Critical: t.Critical}
+			s.overridden[t.Metric] = true
+		}
+	}
+	return s
+}
+
+// thresholds returns the tiers in effect for the request's caller.
+func (c *ChatService) thresholds(ctx context.Context) thresholdSet {
+	owner := ""
+	if r := answerRouteFrom(ctx); r != nil {
+		owner = r.owner
+	}
+	return c.ownerThresholds(ctx, owner)
+}
+
+// thresholdBreach is one reading in a warning or critical state.
+type thresholdBreach struct {
+	Metric   string
+	Label    string
+	Severity string
+	Reading  string
+	Limit    string
+	Below    bool
+	// AtLimit is set when the reading equals the tier it breaches.
+	AtLimit bool
+}
+
+// marker renders the breach as shown next to its reading, e.g. "⚠
+// temperature 78.2°C — above the 70°C warning threshold".
+func (b thresholdBreach) marker() string {
+	dir := "above"
+	if b.Below {
+		dir = "below"
+	}
+	if b.AtLimit {
+		dir = "at"
+	}
+	return fmt.Sprintf("⚠ %s %s — %s the %s %s threshold", b.Label, b.Reading, dir, b.Limit, b.Severity)
+}
+
+// check grades v against metric's tiers. label names the reading when it is
+// more specific than the metric ("chassis temperature"); ok is false while
+// v is within both tiers.
+func (s thresholdSet) check(u unitPrefs, metric, label string, v float64) (thresholdBreach, bool) {
+	sp, ok := thresholdSpecFor(metric)
+	if !ok {
+		return thresholdBreach{}, false
+	}
+	t := s.tiers[metric]
+	breaches := func(tier float64) bool {
+		if tier <= 0 {
+			return false
+		}
+		if sp.Below {
+			return v <= tier
+		}
+		return v >= tier
+	}
+	var severity string
+	var limit float64
+	switch {
+	case breaches(t.Critical):
+		severity, limit = severityCritical, t.Critical
+	case breaches(t.Warning):
+		severity, limit = severityWarning, t.Warning
+	default:
+		return thresholdBreach{}, false
+	}
+	if label == "" {
+		label = sp.Label
+	}
+	return thresholdBreach{
+		Metric:   metric,
+		Label:    label,
+		Severity: severity,
+		Reading:  sp.format(u, v, false),
+		Limit:    sp.format(u, limit, true),
+		Below:    sp.Below,
+		AtLimit:  v == limit,
+	}, true
+}
+
+// thresholdReading is one value to grade; Label is optional (see check).
+type thresholdReading struct {
+	Metric string
+	Label  string
+	Value  float64
+}
+
+// checkAll grades readings in order and returns the breaches.
+func (s thresholdSet) checkAll(u unitPrefs, readings ...thresholdReading) []thresholdBreach {
+	var out []thresholdBreach
+	for _, r := range readings {
+		if b, ok := s.check(u, r.Metric, r.Label, r.Value); ok {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+func thresholdMarkers(breaches []thresholdBreach) string {
+	markers := make([]string, 0, len(breaches))
+	for _, b := range breaches {
+		markers = append(markers, b.marker())
+	}
+	return strings.Join(markers, "; ")
+}
+
+// thresholdReport collects the breaches of one answer for the summary line
+// at its top.
+type thresholdReport struct {
+	breaches []thresholdBreach
+	// worst is each device's most severe breach.
+	worst map[string]string
+	// devices counts the devices with a breach of each metric.
+	devices map[string]map[string]bool
+}
+
+func newThresholdReport() *thresholdReport {
+	return &thresholdReport{worst: map[string]string{}, devices: map[string]map[string]bool{}}
+}
+
+// add records host's breaches and returns their markers ("" when none).
+func (r *thresholdReport) add(host string, breaches []thresholdBreach) string {
+	for _, b := range breaches {
+		r.breaches = append(r.breaches, b)
+		if r.worst[host] != severityCritical {
+			r.worst[host] = b.Severity
+		}
+		if r.devices[b.Metric] == nil {
+			r.devices[b.Metric] = map[string]bool{}
+		}
+		r.devices[b.Metric][host] = true
+	}
+	return thresholdMarkers(breaches)
+}
+
+// summary is the line put above an answer with breaches, "" when there are
+// none. One device's summary names each breached reading; several devices'
+// summary counts devices by severity and metric. total is the number of
+// devices the answer covers.
+func (r *thresholdReport) summary(total int) string {
+	if len(r.breaches) == 0 {
+		return ""
+	}
+	if len(r.worst) == 1 && total <= 1 {
+		parts := make([]string, 0, len(r.breaches))
+		for _, b := range r.breaches {
+			parts = append(parts, fmt.Sprintf("%s %s", b.Label, b.Severity))
+		}
+		return "⚠ Outside thresholds: " + strings.Join(parts, ", ") + "."
+	}
+	critical := 0
+	for _, sev := range r.worst {
+		if sev == severityCritical {
+			critical++
+		}
+	}
+	var counts []string
+	if critical > 0 {
+		counts = append(counts, fmt.Sprintf("%d critical", critical))
+	}
+	if warning := len(r.worst) - critical; warning > 0 {
+		counts = append(counts, fmt.Sprintf("%d warning", warning))
+	}
+	var metrics []string
+	for _, sp := range telemetryThresholdTable {
+		if n := len(r.devices[sp.Metric]); n > 0 {
+			metrics = append(metrics, fmt.Sprintf("%s on %d", sp.Label, n))
+		}
+	}
+	return fmt.Sprintf("⚠ %d of %d device(s) outside thresholds (%s): %s.", len(r.worst), total, strings.Join(counts, ", "), strings.Join(metrics, ", "))
+}
+
+// prepend puts the summary line above answer.
+func (r *thresholdReport) prepend(answer string, total int) string {
+	if s := r.summary(total); s != "" {
+		return s + "\n" + answer
+	}
+	return answer
+}
+
+const thresholdMetricPattern = `temperature|temp|disk|storage|memory|ram|battery|input\s+devices?(?:\s+missing)?|missing\s+input\s+devices?`
+
+var (
+	thresholdSetRe   = regexp.MustCompile(`(?i)^(?:please\s+)?set\s+(?:my\s+|the\s+)?(` + thresholdMetricPattern + `)\s+(warning|critical)\s+(?:threshold|level|limit|tier)?\s*(?:to|at)\s+(\d+(?:\.\d+)?)\s*(%|percent|°\s*[cf]|degrees(?:\s+[cf])?|[cf])?[.!]*$`)
+	thresholdShowRe  = regexp.MustCompile(`(?i)^(?:please\s+)?(?:(?:show|list)\s+(?:me\s+)?(?:my|the)\s+(?:telemetry\s+)?thresholds|what\s+are\s+(?:my|the)\s+(?:telemetry\s+)?thresholds)[.!?]*$`)
+	thresholdResetRe = regexp.MustCompile(`(?i)^(?:please\s+)?(?:reset|clear|remove)\s+(?:my\s+|the\s+)?(?:(` + thresholdMetricPattern + `)\s+)?(?:telemetry\s+)?thresholds?[.!]*$`)
+)
+
+// thresholdMetric maps the words of a threshold command to a metric.
+func thresholdMetric(word string) string {
+	word = strings.ToLower(strings.Join(strings.Fields(word), " "))
+	switch {
+	case word == "":
+		return ""
+	case strings.HasPrefix(word, "temp"):
+		return models.ThresholdTemperature
+	case word == "disk" || word == "storage":
+		return models.ThresholdDisk
+	case word == "memory" || word == "ram":
+		return models.ThresholdMemory
+	case word == "battery":
+		return models.ThresholdBattery
+	}
+	return models.ThresholdInputMissing
+}
+
+// describeThreshold renders a metric's tiers, e.g. "warning 70°C, critical
+// 85°C" or "warning below 20%".
+func describeThreshold(u unitPrefs, sp thresholdSpec, t models.TelemetryThreshold) string {
+	tier := func(name string, v float64) string {
+		if v <= 0 {
+			return name + " off"
+		}
+		if sp.Below {
+			return name + " below " + sp.format(u, v, true)
+		}
+		return name + " " + sp.format(u, v, true)
+	}
+	return tier(severityWarning, t.Warning) + ", " + tier(severityCritical, t.Critical)
+}
+
+// handleTelemetryThresholds manages the caller's threshold overrides: "set
+// my temperature warning threshold to 75", "show my thresholds" and "reset
+// my disk threshold" (or "reset my thresholds" for all of them).
+func (c *ChatService) handleTelemetryThresholds(ctx context.Context, ownerKey string, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msg := strings.TrimSpace(req.Message)
+	set := thresholdSetRe.FindStringSubmatch(msg)
+	show := thresholdShowRe.MatchString(msg)
+	reset := thresholdResetRe.FindStringSubmatch(msg)
+	if set == nil && !show && reset == nil {
+		return models.ChatResponse{}, false, nil
+	}
+	reply := func(answer string) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(answer)
+		}
+		return models.ChatResponse{Answer: answer}, true, nil
+	}
+	units := c.unitPrefs(req)
+
+	if show {
+		s := c.ownerThresholds(ctx, ownerKey)
+		lines := []string{"Telemetry thresholds for this API key:"}
+		for _, sp := range telemetryThresholdTable {
+			line := fmt.Sprintf("- %s: %s", sp.Label, describeThreshold(units, sp, s.tiers[sp.Metric]))
+			if s.overridden[sp.Metric] {
+				line += " (your override)"
+			}
+			lines = append(lines, line)
+		}
+		lines = append(lines, "Change one with \"set my temperature warning threshold to 75\"; 0 turns a tier off.")
+		return reply(strings.Join(lines, "\n"))
+	}
+	if c.Thresholds == nil {
+		return reply("Threshold overrides are not configured.")
+	}
+
+	if reset != nil {
+		metric := thresholdMetric(reset[1])
+		n, err := c.Thresholds.DeleteTelemetryThresholds(ctx, ownerKey, metric)
+		if err != nil {
+			return reply("Failed to reset the thresholds: " + err.Error())
+		}
+		if metric == "" {
+			if n == 0 {
+				return reply("You have no threshold overrides; the service defaults already apply.")
+			}
+			return reply(fmt.Sprintf("Reset %d threshold override(s); the service defaults apply again.", n))
+		}
+		sp, _ := thresholdSpecFor(metric)
+		if n == 0 {
+			return reply(fmt.Sprintf("You have no %s threshold override; the service default already applies.", sp.Label))
+		}
+		return reply(fmt.Sprintf("Reset your %s threshold; the service default (%s) applies again.", sp.Label, describeThreshold(units, sp, c.baseThresholds().tiers[metric])))
+	}
+
+	metric := thresholdMetric(set[1])
+	sp, _ := thresholdSpecFor(metric)
+	value, err := strconv.ParseFloat(set[3], 64)
+	if err != nil {
+		return reply(fmt.Sprintf("'%s' is not a number; nothing was saved.", set[3]))
+	}
+	if metric == models.ThresholdTemperature && strings.Contains(strings.ToLower(set[4]), "f") {
+		value = (value - 32) * 5 / 9
+	}
+	if sp.Max > 0 && value > sp.Max {
+		return reply(fmt.Sprintf("A %s threshold can't be above %s; nothing was saved.", sp.Label, sp.format(units, sp.Max, true)))
+	}
+	t := c.ownerThresholds(ctx, ownerKey).tiers[metric]
+	if strings.EqualFold(set[2], severityCritical) {
+		t.Critical = value
+	} else {
+		t.Warning = value
+	}
+	if t.Warning > 0 && t.Critical > 0 {
+		if !sp.Below && t.Critical < t.Warning {
+			return reply(fmt.Sprintf("The %s critical tier (%s) must not be below the warning tier (%s); nothing was saved.", sp.Label, sp.format(units, t.Critical, true), sp.format(units, t.Warning, true)))
+		}
+		if sp.Below && t.Critical > t.Warning {
+			return reply(fmt.Sprintf("The %s critical tier (%s) must not be above the warning tier (%s); nothing was saved.", sp.Label, sp.format(units, t.Critical, true), sp.format(units, t.Warning, true)))
+		}
+	}
+	t.OwnerKey, t.Metric = ownerKey, metric
+	saved, err := c.Thresholds.SetTelemetryThreshold(ctx, t)
+	if err != nil {
+		return reply("Failed to save the threshold: " + err.Error())
+	}
+	return reply(fmt.Sprintf("Saved: your %s thresholds are now %s. Telemetry answers for this API key use them from now on.", sp.Label, describeThreshold(units, sp, saved)))
+}
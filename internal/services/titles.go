@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// appendAssistant stores the assistant reply and, after the first exchange,
+// gives the conversation an automatic title. SetDefaultConversationTitle only
+// writes while the title is still empty, so user renames are kept.
+func (c *ChatService) appendAssistant(ctx context.Context, ownerKey, conversationID string, req models.ChatRequest, resp models.ChatResponse) {
+	handler := "llm"
+	if r := answerRouteFrom(ctx); r != nil && r.handler != "" {
+		handler = r.handler
+	}
+	c.attributeMemoryTurn(conversationID, handler)
+	if conversationID == "" || c.Store == nil {
+		return
+	}
+	defer stageTimerFrom(ctx).since(stageStore, time.Now())
+	_ = c.Store.AppendAssistantMessage(ctx, ownerKey, conversationID, resp.Answer, handler)
+	c.recordQuestion(ctx, conversationID, req)
+	// Claim the title under the lock so concurrent replies title it once.
+	var st conversationState
+	claimed := false
+	c.updateConversationState(conversationID, func(live *conversationState) {
+		if !live.Titled {
+			live.Titled = true
+			claimed = true
+			st = live.clone()
+		}
+	})
+	if !claimed {
+		return
+	}
+	_ = c.Store.SetDefaultConversationTitle(ctx, ownerKey, conversationID, conversationTitle(req.Message, resp.Steps, &st))
+}
+
+// stepTopics maps gateway step tools to the topic shown in titles.
+var stepTopics = map[string]string{
+	"popList":                  "plays",
+	"popStats":                 "plays",
+	"popData":                  "plays",
+	"popImpressions":           "impressions",
+	"adsCampaignImpressions":   "impressions",
+	"metricsLatest":            "telemetry",
+	"metricsHistory":           "telemetry",
+	"metricsServersStatusCity": "device status",
+	"adsCampaigns":             "campaigns",
+	"adsCampaignsSearch":       "campaigns",
+	"adsCampaignGet":           "campaign details",
+	"adsCreatives":             "creatives",
+	"adsCreativesSearch":       "creatives",
+	"adsCreativesByCampaign":   "creatives",
+	"adsCreativesUpload":       "creative upload",
+	"adsDevices":               "devices",
+	"adsDevice":                "device details",
+	"adsDevicesCountsRegions":  "device counts",
+	"adsDeviceVenues":          "venues",
+	"adsDeviceVenuesByHost":    "venues",
+	"adsVenues":                "venues",
+	"adsVenuesSearch":          "venues",
+	"adsVenueDevices":          "venue devices",
+	"adsAdvertisers":           "advertisers",
+}
+
+// conversationTitle labels a conversation from the handler's steps and the
+// entities it resolved ("Poster Lorla Studio — plays in brt"), falling back
+// to the first user message when no entity was resolved.
+func conversationTitle(message string, steps []models.Step, st *conversationState) string {
+	topic := ""
+	for _, s := range steps {
+		if t := stepTopics[s.Tool]; t != "" {
+			topic = t
+			break
+		}
+	}
+	subject, location := "", ""
+	if st != nil {
+		switch {
+		case st.PosterName != "":
+			subject = "Poster " + st.PosterName
+			location = firstNonEmpty(st.PosterCity, st.PosterRegion, st.City, st.Region)
+		case st.Host != "":
+			subject = "Device " + st.Host
+		case st.CampaignID != "":
+			subject = "Campaign " + st.CampaignID
+		case st.VenueID > 0:
+			subject = fmt.Sprintf("Venue %d", st.VenueID)
+		}
+		if location == "" && subject == "" {
+			location = firstNonEmpty(st.City, st.Region)
+		}
+	}
+	if subject != "" && topic != "" {
+		label := subject + " — " + topic
+		if location != "" {
+			label += " in " + location
+		}
+		return label
+	}
+	if subject != "" {
+		return subject
+	}
+	return truncateTitle(message, 60)
+}
+
+// truncateTitle cuts a message to at most n runes on a word boundary.
+func truncateTitle(message string, n int) string {
+	message = strings.Join(strings.Fields(message), " ")
+	r := []rune(message)
+	if len(r) <= n {
+		return message
+	}
+	cut := string(r[:n])
+	if i := strings.LastIndex(cut, " "); i > n/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:-") + "…"
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if strings.TrimSpace(v) != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
@@ -11,21 +11,42 @@ import (
 )
 
 type OpenAPISpec struct {
-	Paths map[string]map[string]any `json:"paths"`
+	Paths      map[string]map[string]any `json:"paths"`
+	Components struct {
+		Schemas map[string]any `json:"schemas"`
+	} `json:"components"`
+	// Definitions holds the schemas of Swagger 2.0 specs.
+	Definitions map[string]any `json:"definitions"`
 }
 
 type ToolCatalog struct {
 	BaseURL string
 	APIKey  string
-	HTTP    *http.Client
+	// Credentials, when set, replaces APIKey with the rotatable gateway keys.
+	Credentials *GatewayCredentials
+	HTTP        *http.Client
 
 	mu        sync.RWMutex
 	cached    OpenAPISpec
 	cachedAt  time.Time
+	cachedKey string
 	cacheTTL  time.Duration
 	lastError error
 }
 
+func (c *ToolCatalog) keys() []string {
+	if c.Credentials == nil {
+		return []string{strings.TrimSpace(c.APIKey)}
+	}
+	return c.Credentials.Keys()
+}
+
+// fresh reports whether the cached result is usable. A key rotation
+// invalidates it so a catalog fetch rejected under the old key is retried.
+func (c *ToolCatalog) fresh(key string) bool {
+	return !c.cachedAt.IsZero() && time.Since(c.cachedAt) < c.cacheTTL && c.cachedKey == key
+}
+
 func NewToolCatalog(baseURL string, httpClient *http.Client, ttl time.Duration) *ToolCatalog {
 	if ttl <= 0 {
 		ttl = 2 * time.Minute
@@ -40,8 +61,9 @@ func NewToolCatalogWithKey(baseURL, apiKey string, httpClient *http.Client, ttl
 }
 
 func (c *ToolCatalog) Fetch(ctx context.Context) (OpenAPISpec, error) {
+	keys := c.keys()
 	c.mu.RLock()
-	if !c.cachedAt.IsZero() && time.Since(c.cachedAt) < c.cacheTTL {
+	if c.fresh(keys[0]) {
 		s := c.cached
 		err := c.lastError
 		c.mu.RUnlock()
@@ -54,7 +76,7 @@ func (c *ToolCatalog) Fetch(ctx context.Context) (OpenAPISpec, error) {
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if !c.cachedAt.IsZero() && time.Since(c.cachedAt) < c.cacheTTL {
+	if c.fresh(keys[0]) {
 		if c.lastError != nil {
 			return OpenAPISpec{}, c.lastError
 		}
@@ -66,27 +88,39 @@ func (c *ToolCatalog) Fetch(ctx context.Context) (OpenAPISpec, error) {
 		hc = http.DefaultClient
 	}
 
+	c.cachedKey = keys[0]
 	url := c.BaseURL + "/openapi.json"
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		c.cachedAt = time.Now()
-		c.lastError = err
-		return OpenAPISpec{}, err
-	}
-	if strings.TrimSpace(c.APIKey) != "" {
-		req.Header.Set("X-API-Key", c.APIKey)
+	var resp *http.Response
+	for _, key := range keys {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			c.cachedAt = time.Now()
+			c.lastError = err
+			return OpenAPISpec{}, err
+		}
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		resp, err = hc.Do(req)
+		if err != nil {
+			c.cachedAt = time.Now()
+			c.lastError = err
+			return OpenAPISpec{}, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			break
+		}
+		resp.Body.Close()
 	}
+	defer resp.Body.Close()
 
-	resp, err := hc.Do(req)
-	if err != nil {
+	if resp.StatusCode == http.StatusUnauthorized {
 		c.cachedAt = time.Now()
-		c.lastError = err
-		return OpenAPISpec{}, err
+		c.lastError = ErrGatewayAuth
+		return OpenAPISpec{}, ErrGatewayAuth
 	}
-	defer resp.Body.Close()
-
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		err = errors.New("openapi fetch failed")
+		err := errors.New("openapi fetch failed")
 		c.cachedAt = time.Now()
 		c.lastError = err
 		return OpenAPISpec{}, err
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"openai-agent-service/internal/models"
+)
+
+// Termination reasons of the OpenAI tool loop.
+const (
+	toolLoopAnswer    = "answer"     // the model answered on its own
+	toolLoopToolLimit = "tool_limit" // MaxToolCalls calls were made; answer forced
+	toolLoopMaxTurns  = "max_turns"  // MaxToolCalls turns without an answer; answer forced
+	toolLoopDeadline  = "deadline"   // the loop budget ran out; answer forced
+	toolLoopError     = "error"      // an OpenAI call failed
+)
+
+// defaultSensitiveParams are redacted from traced tool calls unless
+// TOOL_LOOP_SENSITIVE_PARAMS says otherwise.
+var defaultSensitiveParams = []string{"key", "token", "secret", "password", "email", "phone"}
+
+// toolLoopTrace logs one run of the tool loop: each assistant turn's tool
+// calls, each tool result's status and size, and why the loop ended. Query
+// values and body fields whose name contains a sensitive word are logged as
+// <redacted>; payload bodies only appear with GO_LOG=debug. It travels in the
+// context like debugCapture, and all methods are safe on a nil receiver,
+// which is what untraced requests see.
+type toolLoopTrace struct {
+	requestID      string
+	conversationID string
+	sensitive      []string
+}
+
+type toolLoopTraceKey struct{}
+
+// newToolLoopTrace returns a trace for req when TOOL_LOOP_TRACE is on or the
+// request asked for debug, and nil otherwise.
+func (c *ChatService) newToolLoopTrace(req models.ChatRequest) *toolLoopTrace {
+	if !c.ToolLoopTrace && !req.Debug {
+		return nil
+	}
+	sensitive := c.ToolLoopSensitiveParams
+	if len(sensitive) == 0 {
+		sensitive = defaultSensitiveParams
+	}
+	return &toolLoopTrace{requestID: uuid.NewString(), conversationID: strings.TrimSpace(req.ConversationID), sensitive: sensitive}
+}
+
+func withToolLoopTrace(ctx context.Context, t *toolLoopTrace) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, toolLoopTraceKey{}, t)
+}
+
+func toolLoopTraceFrom(ctx context.Context) *toolLoopTrace {
+	t, _ := ctx.Value(toolLoopTraceKey{}).(*toolLoopTrace)
+	return t
+}
+
+func (t *toolLoopTrace) isSensitive(name string) bool {
+	n := strings.ToLower(name)
+	for _, s := range t.sensitive {
+		if s != "" && strings.Contains(n, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactQuery renders query parameters sorted by name, with sensitive values
+// replaced by <redacted>.
+func (t *toolLoopTrace) redactQuery(q map[string]string) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := q[k]
+		if t.isSensitive(k) {
+			v = "<redacted>"
+		}
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, "&")
+}
+
+// redactBody replaces sensitive fields at any depth, then summarizes the rest
+// like dry-run steps do.
+func (t *toolLoopTrace) redactBody(v any) any {
+	switch b := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(b))
+		for k, val := range b {
+			if t.isSensitive(k) {
+				out[k] = "<redacted>"
+				continue
+			}
+			out[k] = t.redactBody(val)
+		}
+		return summarizeMutationBody(out)
+	case []any:
+		out := make([]any, 0, len(b))
+		for _, it := range b {
+			out = append(out, t.redactBody(it))
+		}
+		return out
+	}
+	return v
+}
+
+func (t *toolLoopTrace) logf(format string, args ...any) {
+	log.Printf("tool loop request_id=%s conversation_id=%s "+format, append([]any{t.requestID, t.conversationID}, args...)...)
+}
+
+// assistantTurn logs the tool calls the model asked for in one turn. Body
+// field names are logged at info level, the redacted body only at debug.
+func (t *toolLoopTrace) assistantTurn(turn int, calls []ToolCall) {
+	if t == nil {
+		return
+	}
+	t.logf("turn=%d tool_calls=%d", turn, len(calls))
+	for _, call := range calls {
+		var args scmRequestArgs
+		if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+			t.logf("turn=%d call_id=%s function=%s args=unparsable bytes=%d", turn, call.ID, call.Function.Name, len(call.Function.Arguments))
+			continue
+		}
+		fields := make([]string, 0, len(args.Body))
+		for k := range args.Body {
+			fields = append(fields, k)
+		}
+		sort.Strings(fields)
+		t.logf("turn=%d call_id=%s function=%s method=%s path=%s query=%q body_fields=%s multipart=%t",
+			turn, call.ID, call.Function.Name, strings.ToUpper(strings.TrimSpace(args.Method)), strings.TrimSpace(args.Path),
+			t.redactQuery(args.Query), strings.Join(fields, ","), args.Multipart != nil)
+		if args.Body != nil && debugEnabled() {
+			b, _ := json.Marshal(t.redactBody(args.Body))
+			t.logf("turn=%d call_id=%s body=%s", turn, call.ID, clipString(string(b), 2000))
+		}
+	}
+}
+
+// toolResult logs the outcome of one tool call: the gateway status and body
+// size, or the error the model was sent instead. The body itself is logged
+// only at debug level.
+func (t *toolLoopTrace) toolResult(turn int, callID string, status int, body []byte, errCode string) {
+	if t == nil {
+		return
+	}
+	if errCode != "" {
+		t.logf("turn=%d call_id=%s status=%d bytes=%d error=%q", turn, callID, status, len(body), errCode)
+	} else {
+		t.logf("turn=%d call_id=%s status=%d bytes=%d", turn, callID, status, len(body))
+	}
+	if len(body) > 0 && debugEnabled() {
+		t.logf("turn=%d call_id=%s result=%s", turn, callID, clipString(string(body), 2000))
+	}
+}
+
+func (t *toolLoopTrace) end(reason string, turns, toolCalls int) {
+	if t == nil {
+		return
+	}
+	t.logf("end reason=%s turns=%d tool_calls=%d", reason, turns, toolCalls)
+}
+
+// toolLoopStats counts tool loop runs for /metrics.
+type toolLoopStats struct {
+	mu     sync.Mutex
+	runs   map[string]int64
+	turns  int64
+	forced int64
+}
+
+// ToolLoopStats is a snapshot of the tool loop counters: runs by termination
+// reason, model turns, and runs whose answer had to be forced.
+type ToolLoopStats struct {
+	Runs          map[string]int64
+	Turns         int64
+	ForcedAnswers int64
+}
+
+func (c *ChatService) recordToolLoop(reason string, turns int) {
+	s := &c.toolLoop
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.runs == nil {
+		s.runs = map[string]int64{}
+	}
+	s.runs[reason]++
+	s.turns += int64(turns)
+	switch reason {
+	case toolLoopToolLimit, toolLoopMaxTurns, toolLoopDeadline:
+		s.forced++
+	}
+}
+
+// ToolLoopStats returns the tool loop counters since start.
+func (c *ChatService) ToolLoopStats() ToolLoopStats {
+	s := &c.toolLoop
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := ToolLoopStats{Runs: make(map[string]int64, len(s.runs)), Turns: s.turns, ForcedAnswers: s.forced}
+	for k, v := range s.runs {
+		out.Runs[k] = v
+	}
+	return out
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// trendBudget bounds the comparison fetch behind a trend hint. It runs
+// alongside the answer's own fetch; a hint not ready by then is left out.
+const trendBudget = 2 * time.Second
+
+// Comparison windows ending at the same time yesterday: the status answer
+// counts devices that reported in the last 5 minutes, the metrics answer
+// reads each device's latest sample.
+const (
+	trendStatusWindow  = 5 * time.Minute
+	trendMetricsWindow = time.Hour
+)
+
+// trendSnapshot is a scope's figures at the same time yesterday, read from a
+// single page of /metrics/history.
+type trendSnapshot struct {
+	Step models.Step
+	// Reporting is the number of devices with a sample in the window.
+	Reporting int64
+	// Network is the devices' daily RX plus TX counters, which reset at
+	// midnight, so it is the traffic of yesterday up to that time.
+	Network int64
+	CPU     float64
+}
+
+// pendingTrend is a comparison fetch started by startTrend.
+type pendingTrend struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan *trendSnapshot
+}
+
+// startTrend starts reading the city's (or region's) figures for the window
+// ending 24 hours before now. It returns nil when trend hints are off.
+func (c *ChatService) startTrend(ctx context.Context, city, region string, window time.Duration, now time.Time) *pendingTrend {
+	if !c.TrendHints || c.Gateway == nil {
+		return nil
+	}
+	tctx, cancel := context.WithTimeout(ctx, trendBudget)
+	p := &pendingTrend{ctx: tctx, cancel: cancel, done: make(chan *trendSnapshot, 1)}
+	go func() {
+		defer recoverInto("trend hint", func(error) { p.done <- nil })
+		snap, err := c.fetchTrendSnapshot(tctx, city, region, now.Add(-24*time.Hour), window)
+		if err != nil {
+			debugLogf("trend hint skipped: %v", err)
+			snap = nil
+		}
+		p.done <- snap
+	}()
+	return p
+}
+
+// wait returns the comparison figures, or nil when the fetch failed or did
+// not finish within trendBudget of its start.
+func (p *pendingTrend) wait() *trendSnapshot {
+	if p == nil {
+		return nil
+	}
+	defer p.cancel()
+	select {
+	case snap := <-p.done:
+		return snap
+	case <-p.ctx.Done():
+		return nil
+	}
+}
+
+// stop abandons the fetch; answers that end before waiting defer it.
+func (p *pendingTrend) stop() {
+	if p != nil {
+		p.cancel()
+	}
+}
+
+// fetchTrendSnapshot reads one page of history for the window ending at at.
+// A window that does not fit in one page is not a comparison point: the
+// figures would be partial.
+func (c *ChatService) fetchTrendSnapshot(ctx context.Context, city, region string, at time.Time, window time.Duration) (*trendSnapshot, error) {
+	pageSize := c.limits().ListPageSize
+	from := at.Add(-window)
+	path := fmt.Sprintf("/metrics/history?page=1&page_size=%d&include_totals=false&from=%s&to=%s", pageSize, urlEscape(from.UTC().Format(time.RFC3339)), urlEscape(at.UTC().Format(time.RFC3339)))
+	if city != "" {
+		path += "&city=" + urlEscape(city)
+	}
+	if region != "" {
+		path += "&region=" + urlEscape(region)
+	}
+	status, body, err := c.Gateway.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if status < 200 || status >= 300 {
+		return nil, fmt.Errorf("status %d", status)
+	}
+	var payload struct {
+		Data []struct {
+			ServerID        string    `json:"server_id"`
+			Time            time.Time `json:"time"`
+			CPU             float64   `json:"cpu"`
+			NetDailyRxBytes int64     `json:"net_daily_rx_bytes"`
+			NetDailyTxBytes int64     `json:"net_daily_tx_bytes"`
+			City            string    `json:"city"`
+			Region          string    `json:"region"`
+		} `json:"data"`
+		Pagination gatewayPagination `json:"pagination"`
+	}
+	if json.Unmarshal(body, &payload) != nil {
+		return nil, fmt.Errorf("history response could not be parsed")
+	}
+	if !payload.Pagination.done(len(payload.Data), pageSize, int64(len(payload.Data))) {
+		return nil, fmt.Errorf("history for the window spans more than one page")
+	}
+
+	type sample struct {
+		at      time.Time
+		cpu     float64
+		network int64
+	}
+	latest := map[string]sample{}
+	for _, row := range payload.Data {
+		host := strings.ToLower(strings.TrimSpace(row.ServerID))
+		if host == "" || row.Time.Before(from) || row.Time.After(at) {
+			continue
+		}
+		// The gateway may not filter by location; the rows say where they are.
+		if city != "" && !strings.EqualFold(strings.TrimSpace(row.City), city) {
+			continue
+		}
+		if region != "" && !strings.EqualFold(strings.TrimSpace(row.Region), region) {
+			continue
+		}
+		if prev, ok := latest[host]; ok && !row.Time.After(prev.at) {
+			continue
+		}
+		latest[host] = sample{at: row.Time, cpu: row.CPU, network: row.NetDailyRxBytes + row.NetDailyTxBytes}
+	}
+	if len(latest) == 0 {
+		return nil, fmt.Errorf("no history in the window")
+	}
+	snap := &trendSnapshot{
+		Step:      models.Step{Tool: "metricsHistory", Status: status, Body: c.clipStep(strings.TrimSpace(string(body)))},
+		Reporting: int64(len(latest)),
+	}
+	var cpuSum float64
+	for _, s := range latest {
+		cpuSum += s.cpu
+		snap.Network += s.network
+	}
+	snap.CPU = cpuSum / float64(len(latest))
+	return snap, nil
+}
+
+// trendHint renders a figure with its change since yesterday, e.g.
+// "(offline: 12, ▲3 vs yesterday)". delta is the change as displayed
+// (already rounded) and magnitude its absolute value, formatted.
+func trendHint(label, value string, delta float64, magnitude string) string {
+	switch {
+	case delta > 0:
+		return fmt.Sprintf("(%s: %s, ▲%s vs yesterday)", label, value, magnitude)
+	case delta < 0:
+		return fmt.Sprintf("(%s: %s, ▼%s vs yesterday)", label, value, magnitude)
+	}
+	return fmt.Sprintf("(%s: %s, no change vs yesterday)", label, value)
+}
+
+// countTrendHint compares a device count with yesterday's.
+func countTrendHint(label string, now, then int64) string {
+	delta := now - then
+	if delta < 0 {
+		return trendHint(label, formatThousands(now), float64(delta), formatThousands(-delta))
+	}
+	return trendHint(label, formatThousands(now), float64(delta), formatThousands(delta))
+}
+
+// statusTrendHint compares a status answer's offline (or, when the question
+// is about online devices, online) count with yesterday's. The gateway keeps
+// no status history, so yesterday's online count is the devices that
+// reported in the same 5 minutes and its offline count is the rest of
+// today's total.
+func statusTrendHint(msgLower string, ds deviceStatus, snap *trendSnapshot) string {
+	if snap == nil {
+		return ""
+	}
+	if strings.Contains(msgLower, "online") && !strings.Contains(msgLower, "offline") {
+		return countTrendHint("online", ds.Online, snap.Reporting)
+	}
+	then := ds.Total - snap.Reporting
+	if then < 0 {
+		then = 0
+	}
+	return countTrendHint("offline", ds.Offline, then)
+}
+
+// metricsTrendHints compares a scope's daily network traffic and average CPU
+// with the same time yesterday.
+func metricsTrendHints(units unitPrefs, network int64, avgCPU float64, snap *trendSnapshot) string {
+	if snap == nil {
+		return ""
+	}
+	netDelta := network - snap.Network
+	netAbs := netDelta
+	if netAbs < 0 {
+		netAbs = -netAbs
+	}
+	cpuDelta := roundHalfUp(avgCPU, 1) - roundHalfUp(snap.CPU, 1)
+	cpuDelta = roundHalfUp(cpuDelta, 1)
+	cpuAbs := cpuDelta
+	if cpuAbs < 0 {
+		cpuAbs = -cpuAbs
+	}
+	return trendHint("daily network", units.bytes(network), float64(netDelta), units.bytes(netAbs)) + " " +
+		trendHint("avg CPU", formatPercent(avgCPU), cpuDelta, formatDecimal(cpuAbs, 1)+" pts")
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"openai-agent-service/internal/models"
+)
+
+// unitPrefs is how one answer renders data sizes and temperatures.
+type unitPrefs struct {
+	// Decimal selects KB/MB/GB/TB (powers of 1000) instead of
+	// KiB/MiB/GiB/TiB (powers of 1024).
+	Decimal bool
+	// Fixed is an explicitly requested size unit ("GiB", "MB", ...); sizes
+	// are chosen adaptively when empty.
+	Fixed      string
+	Fahrenheit bool
+}
+
+var (
+	binaryUnits  = []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	decimalUnits = []string{"B", "KB", "MB", "GB", "TB"}
+
+	sizeUnitRe = regexp.MustCompile(`\bin\s+(kib|mib|gib|tib|kb|mb|gb|tb)\b`)
+	tempUnitRe = regexp.MustCompile(`\bin\s+(fahrenheit|celsius|°\s*[fc])\b`)
+)
+
+// unitPrefs resolves the units for req: the service default (SizeUnits),
+// then the request's units/temperature_unit fields, then "in GiB" or
+// "in fahrenheit" in the message itself.
+func (c *ChatService) unitPrefs(req models.ChatRequest) unitPrefs {
+	p := unitPrefs{Decimal: strings.EqualFold(strings.TrimSpace(c.SizeUnits), "decimal")}
+	switch strings.ToLower(strings.TrimSpace(req.Units)) {
+	case "decimal":
+		p.Decimal = true
+	case "binary":
+		p.Decimal = false
+	}
+	p.Fahrenheit = strings.EqualFold(strings.TrimSpace(req.TemperatureUnit), "fahrenheit")
+
+	msgLower := strings.ToLower(req.Message)
+	if m := sizeUnitRe.FindStringSubmatch(msgLower); m != nil {
+		for i := range binaryUnits {
+			if strings.EqualFold(binaryUnits[i], m[1]) {
+				p.Fixed, p.Decimal = binaryUnits[i], false
+			}
+			if strings.EqualFold(decimalUnits[i], m[1]) {
+				p.Fixed, p.Decimal = decimalUnits[i], true
+			}
+		}
+	}
+	if m := tempUnitRe.FindStringSubmatch(msgLower); m != nil {
+		p.Fahrenheit = strings.Contains(m[1], "f")
+	}
+	return p
+}
+
+// bytes renders n with one decimal. Adaptive units keep the mantissa under
+// the base (1024 for binary, 1000 for decimal), so 1023 MiB stays in MiB and
+// 1025 MiB becomes 1.0 GiB.
+func (p unitPrefs) bytes(n int64) string {
+	units, base := binaryUnits, 1024.0
+	if p.Decimal {
+		units, base = decimalUnits, 1000.0
+	}
+	v := 0.0
+	if n > 0 {
+		v = float64(n)
+	}
+	i := 0
+	if p.Fixed != "" {
+		for i < len(units)-1 && units[i] != p.Fixed {
+			v /= base
+			i++
+		}
+	} else {
+		// Compare after rounding so 1023.96 KiB prints as 1.0 MiB, not 1024.0 KiB.
+		for i < len(units)-1 && v >= base-0.05 {
+			v /= base
+			i++
+		}
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", v, units[i])
+	}
+	return fmt.Sprintf("%s %s", formatDecimal(v, 1), units[i])
+}
+
+func (p unitPrefs) temp(celsius float64) string {
+	if p.Fahrenheit {
+		return fmt.Sprintf("%s°F", formatDecimal(celsius*9/5+32, 1))
+	}
+	return fmt.Sprintf("%s°C", formatDecimal(celsius, 1))
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// UsageStore records and aggregates usage events. PostgresStore implements it.
+type UsageStore interface {
+	RecordUsage(ctx context.Context, e models.UsageEvent) error
+	UsageSummary(ctx context.Context, from, to time.Time, recentLLM int) (models.UsageSummary, error)
+	DeleteUsageBefore(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// maxUsageWrites bounds the usage inserts in flight; events beyond it are
+// dropped rather than queued behind a slow database.
+const maxUsageWrites = 16
+
+// usageHashLen is the number of hex characters kept of an owner hash.
+const usageHashLen = 12
+
+// OwnerHash identifies an API key in analytics without revealing it.
+func OwnerHash(ownerKey string) string {
+	sum := sha256.Sum256([]byte(ownerKey))
+	return hex.EncodeToString(sum[:])[:usageHashLen]
+}
+
+// isClarificationAnswer reports whether an answer asks the user for missing
+// input instead of answering ("Please specify a city code ...").
+func isClarificationAnswer(answer string) bool {
+	a := strings.TrimSpace(answer)
+	return strings.HasPrefix(a, "Please specify") || strings.HasPrefix(a, "Please provide")
+}
+
+// usageEvent classifies one answered request.
+func usageEvent(ownerKey, handler string, req models.ChatRequest, resp models.ChatResponse, err error, took time.Duration) models.UsageEvent {
+	e := models.UsageEvent{
+		OwnerHash:       OwnerHash(ownerKey),
+		Handler:         handler,
+		DurationMs:      took.Milliseconds(),
+		IsError:         err != nil || resp.Error != nil || strings.HasPrefix(strings.TrimSpace(resp.Answer), "Failed to"),
+		IsClarification: isClarificationAnswer(resp.Answer),
+		CreatedAt:       time.Now().UTC(),
+	}
+	switch {
+	case e.Handler != "":
+	case e.IsClarification:
+		e.Handler = "clarification"
+	default:
+		e.Handler = "llm"
+		e.Message = clipString(strings.TrimSpace(req.Message), 200)
+	}
+	return e
+}
+
+// recordUsage stores the usage event of one request in the background. It
+// never blocks the answer: when too many writes are pending the event is
+// dropped, and store errors are only logged.
+func (c *ChatService) recordUsage(ctx context.Context, ownerKey string, req models.ChatRequest, resp models.ChatResponse, err error, took time.Duration) {
+	if c.Usage == nil {
+		return
+	}
+	handler := ""
+	if r := answerRouteFrom(ctx); r != nil {
+		handler = r.handler
+	}
+	e := usageEvent(ownerKey, handler, req, resp, err, took)
+	c.usageOnce.Do(func() { c.usageSlots = make(chan struct{}, maxUsageWrites) })
+	select {
+	case c.usageSlots <- struct{}{}:
+	default:
+		log.Printf("usage: dropped event handler=%s (writes pending)", e.Handler)
+		return
+	}
+	go func() {
+		defer func() { <-c.usageSlots }()
+		defer logPanic("usage write")
+		wctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if c.Outbox != nil {
+			if werr := enqueueOutbox(wctx, c.Outbox, OutboxUsage, e); werr != nil {
+				log.Printf("usage: enqueue failed handler=%s: %v", e.Handler, werr)
+			}
+			return
+		}
+		if werr := c.Usage.RecordUsage(wctx, e); werr != nil {
+			log.Printf("usage: record failed handler=%s: %v", e.Handler, werr)
+		}
+	}()
+}
+
+// UsageJanitor deletes usage events older than Retention on an interval.
+type UsageJanitor struct {
+	Store     UsageStore
+	Retention time.Duration
+	Interval  time.Duration
+}
+
+func (j *UsageJanitor) Run(ctx context.Context) {
+	interval := j.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	retention := j.Retention
+	if retention <= 0 {
+		retention = 30 * 24 * time.Hour
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if n, err := j.Store.DeleteUsageBefore(ctx, time.Now().Add(-retention)); err != nil {
+				log.Printf("usage janitor: %v", err)
+			} else if n > 0 {
+				log.Printf("usage janitor: deleted %d expired event(s)", n)
+			}
+		}
+	}
+}
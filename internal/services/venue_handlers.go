@@ -0,0 +1,550 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"openai-agent-service/internal/models"
+)
+
+func (c *ChatService) handleVenueSearchList(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !(strings.Contains(msgLower, "venue") || strings.Contains(msgLower, "venues")) {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+	isSearch := strings.Contains(msgLower, "search") || strings.Contains(msgLower, "find")
+	isList := strings.Contains(msgLower, "list") || strings.Contains(msgLower, "show") || strings.Contains(msgLower, "all")
+	if !(isSearch || isList) {
+		return models.ChatResponse{}, false, nil
+	}
+	query := ""
+	if isSearch {
+		query = extractAfterKeyword(msgLower, "venues")
+		if query == "" {
+			query = extractAfterKeyword(msgLower, "venue")
+		}
+		query = strings.TrimSpace(query)
+		if query == "" {
+			return models.ChatResponse{Answer: "Please provide a venue search query (for example: search venues Union Station)."}, true, nil
+		}
+	}
+
+	status, body, err := c.Gateway.Get(ctx, "/ads/venues?page=1&page_size=50")
+	step := models.Step{Tool: "adsVenues", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+	} else {
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	}
+	steps := []models.Step{step}
+	if err != nil {
+		return models.ChatResponse{Answer: "Failed to fetch venues: " + err.Error(), Steps: steps}, true, nil
+	}
+	if status < 200 || status >= 300 {
+		return models.ChatResponse{Answer: fmt.Sprintf("Failed to fetch venues (status %d).", status), Steps: steps}, true, nil
+	}
+	var parsed map[string]any
+	if json.Unmarshal(body, &parsed) != nil {
+		return models.ChatResponse{Answer: "Venues response could not be parsed.", Steps: steps}, true, nil
+	}
+	rowsAny, _ := parsed["data"].([]any)
+	if len(rowsAny) == 0 {
+		return models.ChatResponse{Answer: "No venues found.", Steps: steps}, true, nil
+	}
+
+	qLower := strings.ToLower(query)
+	rows := make([]map[string]any, 0, len(rowsAny))
+	for _, it := range rowsAny {
+		m, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		name, _ := m["name"].(string)
+		idNum := 0
+		switch v := m["id"].(type) {
+		case float64:
+			idNum = int(v)
+		case int:
+			idNum = v
+		}
+		name = strings.TrimSpace(name)
+		if name == "" || idNum <= 0 {
+			continue
+		}
+		if isSearch {
+			if !containsFolded(name, qLower) {
+				continue
+			}
+		}
+		rows = append(rows, m)
+	}
+	if len(rows) == 0 {
+		return models.ChatResponse{Answer: fmt.Sprintf("No venues found for query '%s'.", query), Steps: steps}, true, nil
+	}
+
+	limit := c.limits().DisplayTopN
+	lines := make([]string, 0, limit+1)
+	if isSearch {
+		lines = append(lines, fmt.Sprintf("Venue search results for '%s':", query))
+	} else {
+		lines = append(lines, "Venues:")
+	}
+	listed := make([]listedEntity, 0, limit)
+	for _, m := range rows {
+		if len(lines)-1 >= limit {
+			break
+		}
+		name, _ := m["name"].(string)
+		idNum := 0
+		switch v := m["id"].(type) {
+		case float64:
+			idNum = int(v)
+		case int:
+			idNum = v
+		}
+		name = strings.TrimSpace(name)
+		if name == "" || idNum <= 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("- %s (%d)", name, idNum))
+		listed = append(listed, listedEntity{ID: strconv.Itoa(idNum), Name: name})
+	}
+	c.rememberList(req.ConversationID, listKindVenue, listed)
+	answer := strings.Join(lines, "\n")
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+}
+
+func (c *ChatService) handleVenueDevices(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	// "venues for <device>" should be handled by handleDeviceVenues.
+	if strings.Contains(msgLower, "venues for") {
+		return models.ChatResponse{}, false, nil
+	}
+	if !(strings.Contains(msgLower, "venue") || strings.Contains(msgLower, "venues")) {
+		return models.ChatResponse{}, false, nil
+	}
+	if !(strings.Contains(msgLower, "device") || strings.Contains(msgLower, "devices")) {
+		return models.ChatResponse{}, false, nil
+	}
+	if !(strings.Contains(msgLower, "in") || strings.Contains(msgLower, "for") || strings.Contains(msgLower, "from") || strings.Contains(msgLower, "show")) {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+	conversationID := strings.TrimSpace(req.ConversationID)
+	venueID := extractFirstInt(req.Message)
+	var resolveStep *models.Step
+	if venueID <= 0 {
+		// Try resolving venue name.
+		lookup := extractAfterKeyword(msgLower, "venue")
+		if lookup == "" {
+			lookup = extractAfterKeyword(msgLower, "venues")
+		}
+		if lookup == "" && (strings.Contains(msgLower, "from") || strings.Contains(msgLower, "in")) {
+			if idx := strings.LastIndex(msgLower, "from"); idx >= 0 {
+				lookup = strings.TrimSpace(msgLower[idx+4:])
+			} else if idx := strings.LastIndex(msgLower, "in"); idx >= 0 {
+				lookup = strings.TrimSpace(msgLower[idx+2:])
+			}
+		}
+		if lookup != "" {
+			id, stp := c.resolveVenueIDFromName(ctx, conversationID, lookup)
+			if id > 0 {
+				venueID = id
+				resolveStep = stp
+			} else if stp != nil && (stp.Error != "" || stp.Status < 200 || stp.Status >= 300) {
+				// The lookup failed rather than found nothing; say so
+				// instead of asking for an id the user already gave.
+				var failures partialFailures
+				failures.add("venues to look up '"+lookup+"'", []models.Step{*stp}, 0, "")
+				answer := failures.note("")
+				if onToken != nil {
+					onToken(answer)
+				}
+				return models.ChatResponse{Answer: answer, Steps: []models.Step{*stp}}, true, nil
+			}
+		}
+	}
+	if venueID <= 0 && conversationID != "" {
+		if st := c.getConversationState(conversationID); st != nil {
+			venueID = st.VenueID
+		}
+	}
+	if venueID <= 0 {
+		return models.ChatResponse{Answer: "Please provide a venue id (number) or name. Example: show devices in venue Union Station."}, true, nil
+	}
+	if conversationID != "" {
+		c.updateConversationVenueID(conversationID, venueID)
+		c.clearPending(conversationID)
+	}
+
+	// Every page is read so the answer can say how many devices the venue
+	// has, not just how many it shows.
+	rowsAny, pageSteps, truncated, err := c.fetchListing(ctx, fmt.Sprintf("/ads/venues/%d/devices", venueID), "adsVenueDevices", c.limits().ListPageSize, c.limits().MetricsMaxPages)
+	steps := pageSteps
+	if resolveStep != nil {
+		steps = append([]models.Step{*resolveStep}, steps...)
+	}
+	var failures partialFailures
+	if err != nil {
+		// Devices from the pages already read are still listed.
+		if len(rowsAny) > 0 {
+			failures.add(fmt.Sprintf("venue devices page %d", len(pageSteps)), steps, len(steps)-1, "the list may be incomplete")
+		} else if n := len(pageSteps); n > 0 && pageSteps[n-1].Status != 0 {
+			return models.ChatResponse{Answer: fmt.Sprintf("Failed to fetch venue devices (status %d).", pageSteps[n-1].Status), Steps: steps}, true, nil
+		} else {
+			return models.ChatResponse{Answer: "Failed to fetch venue devices: " + err.Error(), Steps: steps}, true, nil
+		}
+	}
+	type venueDevice struct {
+		row        map[string]any
+		name, host string
+	}
+	devices := make([]venueDevice, 0, len(rowsAny))
+	for _, m := range rowsAny {
+		nm, _ := m["name"].(string)
+		hn, _ := m["host_name"].(string)
+		nm = strings.TrimSpace(nm)
+		hn = strings.TrimSpace(hn)
+		if nm == "" {
+			nm = hn
+		}
+		if nm == "" {
+			continue
+		}
+		devices = append(devices, venueDevice{row: m, name: nm, host: hn})
+	}
+	if len(devices) == 0 {
+		return models.ChatResponse{Answer: fmt.Sprintf("No devices found for venue %d.", venueID), Steps: steps}, true, nil
+	}
+	capped := c.capList(msgLower, len(devices), "devices")
+	capped.More = truncated
+	lines := []string{fmt.Sprintf("Devices in venue %d:", venueID)}
+	table := newTextTable("#", "Kiosk", "Host").alignRight(0)
+	listed := make([]listedEntity, 0, capped.Shown)
+	geo := &models.GeoFeatureCollection{}
+	for _, d := range devices[:capped.Shown] {
+		m, nm, hn := d.row, d.name, d.host
+		geo.AddPoint(floatField(m, "lat", "latitude", "kiosk_lat"), floatField(m, "lng", "lon", "long", "longitude", "kiosk_long"), map[string]any{"kiosk_name": nm, "host": strings.ToLower(hn), "venue_id": venueID})
+		if hn != "" {
+			lines = append(lines, fmt.Sprintf("- %s (%s)", nm, hn))
+			listed = append(listed, listedEntity{ID: strings.ToLower(hn), Name: nm})
+		} else {
+			lines = append(lines, "- "+nm)
+		}
+		table.add(fmt.Sprintf("%d", len(lines)-1), nm, hn)
+	}
+	// Only a list where every line has a host can be picked from by number.
+	if len(listed) == len(lines)-1 {
+		c.rememberList(conversationID, listKindDevice, listed)
+	}
+	if wantsTables(req) {
+		lines = append(lines[:1], table.lines()...)
+	}
+	if note := capped.note(); note != "" {
+		lines = append(lines, note)
+	}
+	answer := failures.note(strings.Join(lines, "\n"))
+	if onToken != nil {
+		onToken(answer)
+	}
+	resp := models.ChatResponse{Answer: answer, Steps: steps, Meta: failures.meta(nil)}
+	if len(geo.Features) > 0 {
+		resp.Data = &models.ChatData{Geo: geo}
+	}
+	return resp, true, nil
+}
+
+func (c *ChatService) handleDeviceVenues(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !(strings.Contains(msgLower, "venue") || strings.Contains(msgLower, "venues")) {
+		return models.ChatResponse{}, false, nil
+	}
+	// Allow host-only phrasing like "show venues for moco-brt-...".
+	if !(strings.Contains(msgLower, "device") || strings.Contains(msgLower, "kiosk") || strings.Contains(msgLower, "server") || len(detectHostTokens(req.Message)) > 0) {
+		return models.ChatResponse{}, false, nil
+	}
+	if !(strings.Contains(msgLower, "for") || strings.Contains(msgLower, "of") || strings.Contains(msgLower, "show") || strings.Contains(msgLower, "list")) {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+	conversationID := strings.TrimSpace(req.ConversationID)
+	deviceID := extractExplicitDeviceID(msgLower)
+	resolvedHost := ""
+	var resolveStep *models.Step
+	if deviceID <= 0 {
+		// Try host token or last host, then resolve display name.
+		if tokens := detectHostTokens(req.Message); len(tokens) > 0 {
+			candidate := strings.ToLower(strings.TrimSpace(tokens[0]))
+			parts := strings.Split(strings.ReplaceAll(candidate, "_", "-"), "-")
+			if len(parts) >= 3 {
+				resolvedHost = candidate
+			}
+		}
+		if resolvedHost == "" && conversationID != "" {
+			if st := c.getConversationState(conversationID); st != nil {
+				if strings.TrimSpace(st.Host) != "" {
+					resolvedHost = strings.ToLower(strings.TrimSpace(st.Host))
+				}
+			}
+		}
+		if resolvedHost == "" {
+			lookup := extractAfterKeyword(msgLower, "device")
+			if lookup == "" {
+				lookup = extractAfterKeyword(msgLower, "kiosk")
+			}
+			lookup = strings.TrimSpace(lookup)
+			if lookup == "" {
+				lookup = req.Message
+			}
+			if h, stp := c.resolveHostFromDeviceName(ctx, conversationID, lookup); strings.TrimSpace(h) != "" {
+				resolvedHost = strings.ToLower(strings.TrimSpace(h))
+				resolveStep = stp
+			}
+		}
+		if resolvedHost != "" {
+			// Resolve host -> device id via adsDevice.
+			statusD, bodyD, errD := c.Gateway.Get(ctx, "/ads/devices/"+url.PathEscape(resolvedHost))
+			stepD := models.Step{Tool: "adsDevice", Status: statusD}
+			if errD != nil {
+				stepD.Error = errD.Error()
+			} else {
+				stepD.Body = c.clipStep(strings.TrimSpace(string(bodyD)))
+			}
+			if resolveStep != nil {
+				// Return this as part of steps later.
+			}
+			if errD == nil && statusD >= 200 && statusD < 300 {
+				var parsed map[string]any
+				if json.Unmarshal(bodyD, &parsed) == nil {
+					obj := parsed
+					if d, ok := parsed["data"].(map[string]any); ok {
+						obj = d
+					}
+					switch v := obj["id"].(type) {
+					case float64:
+						deviceID = int(v)
+					case int:
+						deviceID = v
+					}
+				}
+			}
+			// Stash host in conversation for follow-ups.
+			if conversationID != "" && resolvedHost != "" {
+				c.updateConversationHost(conversationID, resolvedHost)
+			}
+			// If we couldn't determine device ID, surface device lookup step.
+			if deviceID <= 0 {
+				steps := []models.Step{}
+				if resolveStep != nil {
+					steps = append(steps, *resolveStep)
+				}
+				steps = append(steps, stepD)
+				return models.ChatResponse{Answer: "Couldn't resolve device id for that host. Please provide a numeric device id.", Steps: steps}, true, nil
+			}
+		}
+	}
+
+	if deviceID <= 0 {
+		return models.ChatResponse{Answer: "Please provide a numeric device id (or a host name) to list its venues."}, true, nil
+	}
+	if conversationID != "" {
+		c.clearPending(conversationID)
+	}
+
+	path := fmt.Sprintf("/ads/devices/%d/venues?page=1&page_size=20", deviceID)
+	status, body, err := c.Gateway.Get(ctx, path)
+	step := models.Step{Tool: "adsDeviceVenues", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+	} else {
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	}
+	steps := []models.Step{step}
+	if resolveStep != nil {
+		steps = append([]models.Step{*resolveStep}, steps...)
+	}
+	if err != nil {
+		return models.ChatResponse{Answer: "Failed to fetch device venues: " + err.Error(), Steps: steps}, true, nil
+	}
+	// Fallback: some deployments treat the path parameter as host_name/server_id instead of numeric device ID.
+	// If the numeric endpoint fails server-side and we have a host, try the host-based variant.
+	if status >= 500 && strings.TrimSpace(resolvedHost) != "" {
+		hostPath := "/ads/devices/" + url.PathEscape(strings.TrimSpace(resolvedHost)) + "/venues?page=1&page_size=20"
+		statusH, bodyH, errH := c.Gateway.Get(ctx, hostPath)
+		stepH := models.Step{Tool: "adsDeviceVenuesByHost", Status: statusH}
+		if errH != nil {
+			stepH.Error = errH.Error()
+		} else {
+			stepH.Body = c.clipStep(strings.TrimSpace(string(bodyH)))
+		}
+		steps = append(steps, stepH)
+		if errH == nil && statusH >= 200 && statusH < 300 {
+			// Use host-based response.
+			body = bodyH
+			status = statusH
+		}
+	}
+	if status < 200 || status >= 300 {
+		// Known backend issue: tool-gateway may return 500 due to a SQL scan mismatch.
+		if status >= 500 {
+			b := strings.TrimSpace(string(body))
+			if strings.Contains(b, "Failed to list venues by device") || strings.Contains(b, "expected") {
+				answer := "The venues-by-device endpoint is currently failing server-side (500). " +
+					"Workaround: use `list venues` to find a venue id, then `show devices in venue <id>` to see membership."
+				return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+			}
+		}
+		return models.ChatResponse{Answer: fmt.Sprintf("Failed to fetch device venues (status %d).", status), Steps: steps}, true, nil
+	}
+	var parsed map[string]any
+	if json.Unmarshal(body, &parsed) != nil {
+		return models.ChatResponse{Answer: "Device venues response could not be parsed.", Steps: steps}, true, nil
+	}
+	rowsAny, _ := parsed["data"].([]any)
+	if len(rowsAny) == 0 {
+		return models.ChatResponse{Answer: fmt.Sprintf("No venues found for device %d.", deviceID), Steps: steps}, true, nil
+	}
+	header := fmt.Sprintf("Venues for device %d:", deviceID)
+	if strings.TrimSpace(resolvedHost) != "" {
+		header = fmt.Sprintf("Venues for %s (device %d):", strings.TrimSpace(resolvedHost), deviceID)
+	}
+	lines := []string{header}
+	for _, it := range rowsAny {
+		if len(lines)-1 >= c.limits().DisplayTopN {
+			break
+		}
+		m, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		name, _ := m["name"].(string)
+		idNum := 0
+		switch v := m["id"].(type) {
+		case float64:
+			idNum = int(v)
+		case int:
+			idNum = v
+		}
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if idNum > 0 {
+			lines = append(lines, fmt.Sprintf("- %s (%d)", name, idNum))
+		} else {
+			lines = append(lines, "- "+name)
+		}
+	}
+	answer := strings.Join(lines, "\n")
+	if onToken != nil {
+		onToken(answer)
+	}
+	return models.ChatResponse{Answer: answer, Steps: steps}, true, nil
+}
+
+func (c *ChatService) resolveVenueIDFromName(ctx context.Context, conversationID string, name string) (int, *models.Step) {
+	if c.Gateway == nil {
+		return 0, nil
+	}
+
+	searchQuery := urlEscape(strings.TrimSpace(name))
+	// Try the new search endpoint first
+	searchPath := "/ads/venues/search?query=" + searchQuery + "&page=1&page_size=50"
+	status, body, err := c.Gateway.Get(ctx, searchPath)
+	step := &models.Step{Tool: "adsVenuesSearch", Status: status}
+	if err == nil && status >= 200 && status < 300 {
+		step.Body = c.clipStep(strings.TrimSpace(string(body)))
+		rows := parseRows(body)
+		if len(rows) > 0 {
+			bestID := 0
+			bestScore := 0
+			for _, it := range rows {
+				m, ok := it.(map[string]any)
+				if !ok {
+					continue
+				}
+				vID := 0
+				switch v := m["id"].(type) {
+				case float64:
+					vID = int(v)
+				case int:
+					vID = v
+				}
+				vName, _ := m["name"].(string)
+				vDesc, _ := m["description"].(string)
+
+				score := scoreDeviceNameMatch(name, vName, vDesc)
+				if score > bestScore {
+					bestScore = score
+					bestID = vID
+				}
+			}
+			if bestScore >= 30 {
+				return bestID, step
+			}
+		}
+	}
+
+	// Fallback to listing all venues if search fails or finds nothing strong
+	path := fmt.Sprintf("/ads/venues?page=1&page_size=%d", c.limits().ListPageSize)
+	status, body, err = c.Gateway.Get(ctx, path)
+	step = &models.Step{Tool: "adsVenues", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+		return 0, step
+	}
+	step.Body = c.clipStep(strings.TrimSpace(string(body)))
+
+	if status < 200 || status >= 300 {
+		return 0, step
+	}
+
+	rows := parseRows(body)
+	if len(rows) == 0 {
+		return 0, step
+	}
+
+	bestID := 0
+	bestScore := 0
+	for _, it := range rows {
+		m, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		vID := 0
+		switch v := m["id"].(type) {
+		case float64:
+			vID = int(v)
+		case int:
+			vID = v
+		}
+		vName, _ := m["name"].(string)
+		vDesc, _ := m["description"].(string)
+
+		score := scoreDeviceNameMatch(name, vName, vDesc)
+		if score > bestScore {
+			bestScore = score
+			bestID = vID
+		}
+	}
+
+	if bestScore >= 30 {
+		return bestID, step
+	}
+
+	return 0, step
+}
@@ -0,0 +1,345 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+const (
+	maxLeaderboardVenues       = 20
+	maxLeaderboardVenueDevices = 15
+)
+
+func isVenueLeaderboardIntent(msgLower string) bool {
+	if !strings.Contains(msgLower, "venue") {
+		return false
+	}
+	for _, k := range []string{"top venue", "best venue", "best performing venue", "best-performing venue", "venue leaderboard", "venue ranking", "rank venues", "rank the venues", "venues ranked"} {
+		if strings.Contains(msgLower, k) {
+			return true
+		}
+	}
+	return strings.Contains(msgLower, "which venue") && (strings.Contains(msgLower, "best") || strings.Contains(msgLower, "most plays") || strings.Contains(msgLower, "top"))
+}
+
+// venueLeaderboardWindow resolves the POP window: an explicit range, "this
+// week" (Monday in the request timezone until now), or the last 7 days.
+func venueLeaderboardWindow(msg string, now time.Time, loc *time.Location) (from, to time.Time, label string) {
+	msgLower := strings.ToLower(msg)
+	fromRFC, toRFC := extractDateRangeRFC3339(msgLower)
+	if fromRFC == "" && toRFC == "" {
+		fromRFC, toRFC = extractNaturalDateRangeRFC3339(msg)
+	}
+	f, errF := time.Parse(time.RFC3339, fromRFC)
+	t, errT := time.Parse(time.RFC3339, toRFC)
+	if errF == nil && errT == nil && t.After(f) {
+		return f, t, f.Format("Jan 2") + "–" + t.Add(-time.Second).Format("Jan 2")
+	}
+	local := now.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	if strings.Contains(msgLower, "this week") {
+		monday := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		return monday, now, "this week (since " + monday.Format("Mon Jan 2") + ")"
+	}
+	return today.AddDate(0, 0, -7), today, "the last 7 days"
+}
+
+// leaderboardVenue is one /ads/venues row and what was learned about it.
+type leaderboardVenue struct {
+	ID          int
+	Name        string
+	Hosts       []string
+	DevicesCap  bool
+	Unavailable string
+	Plays       int64
+	FailedHosts int
+}
+
+// perDevice averages plays over the devices whose POP was fetched.
+func (v *leaderboardVenue) perDevice() float64 {
+	n := len(v.Hosts) - v.FailedHosts
+	if n <= 0 {
+		return 0
+	}
+	return float64(v.Plays) / float64(n)
+}
+
+// rankVenues orders venues with data by plays per device, then total plays;
+// venues without data go last in their original order.
+func rankVenues(venues []*leaderboardVenue) {
+	sort.SliceStable(venues, func(i, j int) bool {
+		a, b := venues[i], venues[j]
+		if (a.Unavailable == "") != (b.Unavailable == "") {
+			return a.Unavailable == ""
+		}
+		if a.Unavailable != "" {
+			return false
+		}
+		if a.perDevice() != b.perDevice() {
+			return a.perDevice() > b.perDevice()
+		}
+		return a.Plays > b.Plays
+	})
+}
+
+// listLeaderboardVenues fetches the first venues in scope. capped reports
+// whether more venues existed than were returned.
+func (c *ChatService) listLeaderboardVenues(ctx context.Context, city, region string) ([]*leaderboardVenue, bool, models.Step, error) {
+	p := fmt.Sprintf("/ads/venues?page=1&page_size=%d", maxLeaderboardVenues+1)
+	if region != "" {
+		p += "&region=" + urlEscape(region)
+	} else if city != "" {
+		p += "&city=" + urlEscape(city)
+	}
+	status, body, err := c.Gateway.Get(ctx, p)
+	step := models.Step{Tool: "adsVenues", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+		return nil, false, step, err
+	}
+	step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	if status < 200 || status >= 300 {
+		return nil, false, step, fmt.Errorf("status %d", status)
+	}
+	venues := make([]*leaderboardVenue, 0, maxLeaderboardVenues)
+	capped := false
+	for _, it := range parseRows(body) {
+		m, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		// Drop rows the gateway returned outside the scope when it ignores the filter.
+		if rowCity, _ := m["city"].(string); city != "" && rowCity != "" && !strings.EqualFold(rowCity, city) {
+			continue
+		}
+		if rowRegion, _ := m["region"].(string); region != "" && rowRegion != "" && !strings.EqualFold(rowRegion, region) {
+			continue
+		}
+		id := int(floatField(m, "id"))
+		name, _ := m["name"].(string)
+		name = strings.TrimSpace(name)
+		if id <= 0 {
+			continue
+		}
+		if name == "" {
+			name = fmt.Sprintf("Venue %d", id)
+		}
+		if len(venues) == maxLeaderboardVenues {
+			capped = true
+			break
+		}
+		venues = append(venues, &leaderboardVenue{ID: id, Name: name})
+	}
+	return venues, capped, step, nil
+}
+
+// venueHosts lists the hosts of one venue, at most maxLeaderboardVenueDevices.
+func (c *ChatService) venueHosts(ctx context.Context, venueID int) ([]string, bool, models.Step, error) {
+	status, body, err := c.Gateway.Get(ctx, fmt.Sprintf("/ads/venues/%d/devices?page=1&page_size=%d", venueID, maxLeaderboardVenueDevices+1))
+	step := models.Step{Tool: "adsVenueDevices", Status: status}
+	if err != nil {
+		step.Error = err.Error()
+		return nil, false, step, err
+	}
+	step.Body = c.clipStep(strings.TrimSpace(string(body)))
+	if status < 200 || status >= 300 {
+		return nil, false, step, fmt.Errorf("status %d", status)
+	}
+	var root map[string]any
+	if json.Unmarshal(body, &root) != nil {
+		return nil, false, step, fmt.Errorf("unparseable response")
+	}
+	hosts := make([]string, 0, maxLeaderboardVenueDevices)
+	seen := map[string]struct{}{}
+	for _, it := range parseRows(body) {
+		m, ok := it.(map[string]any)
+		if !ok {
+			continue
+		}
+		h, _ := m["host_name"].(string)
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h == "" {
+			continue
+		}
+		if _, dup := seen[h]; dup {
+			continue
+		}
+		if len(hosts) == maxLeaderboardVenueDevices {
+			return hosts, true, step, nil
+		}
+		seen[h] = struct{}{}
+		hosts = append(hosts, h)
+	}
+	return hosts, false, step, nil
+}
+
+// handleVenueLeaderboard ranks the venues in scope by POP plays. It fans out
+// to one device lookup per venue and one POP listing per kiosk, so chatStream
+// gives it VenueLeaderboardTimeout instead of the usual handler deadline.
+func (c *ChatService) handleVenueLeaderboard(ctx context.Context, req models.ChatRequest, onToken func(string)) (models.ChatResponse, bool, error) {
+	msgLower := strings.ToLower(req.Message)
+	if !isVenueLeaderboardIntent(msgLower) {
+		return models.ChatResponse{}, false, nil
+	}
+	if c.Gateway == nil {
+		return models.ChatResponse{Answer: "Tool gateway is not configured."}, true, nil
+	}
+	reply := func(resp models.ChatResponse) (models.ChatResponse, bool, error) {
+		if onToken != nil {
+			onToken(resp.Answer)
+		}
+		return resp, true, nil
+	}
+	conversationID := strings.TrimSpace(req.ConversationID)
+	scope := c.resolveScope(ctx, conversationID, msgLower)
+	city, region := scope.City.Value, scope.Region.Value
+	if conversationID != "" {
+		c.updateConversationLocation(conversationID, city, region)
+	}
+	scopeLabel := "all locations"
+	if region != "" {
+		scopeLabel = "region '" + region + "'"
+	} else if city != "" {
+		scopeLabel = "city '" + city + "'"
+	}
+
+	venues, venuesCapped, venueStep, err := c.listLeaderboardVenues(ctx, city, region)
+	steps := []models.Step{venueStep}
+	if err != nil {
+		return reply(models.ChatResponse{Answer: "Failed to fetch venues: " + err.Error(), Steps: steps})
+	}
+	if len(venues) == 0 {
+		return reply(models.ChatResponse{Answer: "No venues found in " + scopeLabel + ".", Steps: steps})
+	}
+
+	// Stage 1: each venue's kiosks.
+	deviceSteps := make([]models.Step, len(venues))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 5)
+	for i, v := range venues {
+		wg.Add(1)
+		go func(i int, v *leaderboardVenue) {
+			defer wg.Done()
+			defer recoverInto("venue leaderboard devices", func(error) { v.Unavailable = "device lookup failed" })
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			hosts, capped, step, err := c.venueHosts(ctx, v.ID)
+			deviceSteps[i] = step
+			switch {
+			case err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded):
+				v.Unavailable = "timed out"
+			case err != nil:
+				v.Unavailable = "device lookup failed"
+			case len(hosts) == 0:
+				v.Unavailable = "no devices"
+			default:
+				v.Hosts, v.DevicesCap = hosts, capped
+			}
+		}(i, v)
+	}
+	wg.Wait()
+	steps = append(steps, deviceSteps...)
+
+	// Stage 2: POP plays per kiosk.
+	loc := requestLocation(req)
+	from, to, windowLabel := venueLeaderboardWindow(req.Message, time.Now(), loc)
+	dateFilter := "&from=" + urlEscape(from.UTC().Format(time.RFC3339)) + "&to=" + urlEscape(to.UTC().Format(time.RFC3339))
+	type hostFetch struct {
+		venue *leaderboardVenue
+		plays int64
+		steps []models.Step
+		pager *popPager
+		err   error
+	}
+	fetches := make([]*hostFetch, 0, len(venues)*4)
+	for _, v := range venues {
+		for range v.Hosts {
+			fetches = append(fetches, &hostFetch{venue: v})
+		}
+	}
+	i := 0
+	for _, v := range venues {
+		for _, h := range v.Hosts {
+			wg.Add(1)
+			go func(f *hostFetch, h string) {
+				defer wg.Done()
+				defer recoverInto("venue leaderboard plays", func(err error) { f.err = err })
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				rows, st, pager, err := c.fetchPopRows(ctx, "host_name="+urlEscape(h)+dateFilter)
+				f.steps, f.pager, f.err = st, pager, err
+				for _, r := range rows {
+					f.plays += r.PlayCount
+				}
+			}(fetches[i], h)
+			i++
+		}
+	}
+	wg.Wait()
+
+	merged := c.newPopPager()
+	merged.timeout(ctx)
+	for _, f := range fetches {
+		steps = append(steps, f.steps...)
+		if f.err != nil {
+			f.venue.FailedHosts++
+			continue
+		}
+		f.venue.Plays += f.plays
+		if f.pager != nil {
+			merged.Fetched += f.pager.Fetched
+			merged.Truncated = merged.Truncated || f.pager.Truncated
+			merged.TimedOut = merged.TimedOut || f.pager.TimedOut
+		}
+	}
+	for _, v := range venues {
+		if v.Unavailable == "" && v.FailedHosts == len(v.Hosts) {
+			v.Unavailable = "POP lookup failed"
+		}
+	}
+	rankVenues(venues)
+
+	lines := make([]string, 0, len(venues)+6)
+	lines = append(lines, fmt.Sprintf("Venue leaderboard for %s, %s (ranked by plays per device):", scopeLabel, windowLabel))
+	ranking := make([]models.VenueRank, 0, len(venues))
+	devicesCapped := 0
+	rank := 0
+	for _, v := range venues {
+		vr := models.VenueRank{VenueID: v.ID, Name: v.Name, Devices: len(v.Hosts)}
+		if v.DevicesCap {
+			devicesCapped++
+		}
+		if v.Unavailable != "" {
+			vr.Unavailable = true
+			ranking = append(ranking, vr)
+			lines = append(lines, fmt.Sprintf("- %s — data unavailable (%s)", v.Name, v.Unavailable))
+			continue
+		}
+		rank++
+		vr.Rank = rank
+		vr.Plays = v.Plays
+		vr.PlaysPerDevice = v.perDevice()
+		ranking = append(ranking, vr)
+		line := fmt.Sprintf("%d. %s — %s plays on %d device(s), %s per device", rank, v.Name, formatThousands(v.Plays), len(v.Hosts), formatThousands(int64(vr.PlaysPerDevice+0.5)))
+		if v.FailedHosts > 0 {
+			line += fmt.Sprintf(" (POP unavailable for %d device(s))", v.FailedHosts)
+		}
+		lines = append(lines, line)
+	}
+	if venuesCapped {
+		lines = append(lines, fmt.Sprintf("(Only the first %d venues in scope were compared.)", maxLeaderboardVenues))
+	}
+	if devicesCapped > 0 {
+		lines = append(lines, fmt.Sprintf("(%d venue(s) have more than %d devices; only the first %d were counted.)", devicesCapped, maxLeaderboardVenueDevices, maxLeaderboardVenueDevices))
+	}
+	answer := merged.note(strings.Join(lines, "\n"))
+	return reply(models.ChatResponse{Answer: answer, Steps: steps, Meta: merged.meta(), Data: &models.ChatData{VenueRanking: ranking}})
+}
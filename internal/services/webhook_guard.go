@@ -0,0 +1,165 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrWebhookBlocked is returned for a webhook whose host is not allowed or
+// resolves to a loopback, private, link-local or otherwise internal address.
+var ErrWebhookBlocked = errors.New("webhook_url must point to an allowed public host")
+
+// webhookTimeout bounds one webhook POST.
+const webhookTimeout = 30 * time.Second
+
+// WebhookGuard limits where webhooks are POSTed. Callers choose webhook
+// URLs, so without it a job or alert rule could make the service reach
+// loopback, in-cluster services or cloud metadata endpoints. A nil guard
+// allows any public address.
+type WebhookGuard struct {
+	// AllowedHosts, when set, are the only hosts webhooks may target: an
+	// entry matches that host, and one starting with "." matches its
+	// subdomains. Listed hosts may resolve to internal addresses, for
+	// receivers inside the cluster.
+	AllowedHosts []string
+	// Resolver looks hosts up when a URL is checked (net.DefaultResolver
+	// when nil).
+	Resolver *net.Resolver
+}
+
+// Check validates a webhook URL before it is accepted: an absolute http or
+// https URL whose host is allowed and, unless listed in AllowedHosts,
+// resolves only to public addresses. Delivery checks the address it dials
+// again, so a name that later resolves elsewhere is still refused.
+func (g *WebhookGuard) Check(ctx context.Context, raw string) error {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrInvalidWebhookURL
+	}
+	host := u.Hostname()
+	if g.listed(host) {
+		return nil
+	}
+	if g != nil && len(g.AllowedHosts) > 0 {
+		return fmt.Errorf("%w: %s is not in WEBHOOK_ALLOWED_HOSTS", ErrWebhookBlocked, host)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if !publicIP(ip) {
+			return fmt.Errorf("%w: %s is not a public address", ErrWebhookBlocked, host)
+		}
+		return nil
+	}
+	resolver := net.DefaultResolver
+	if g != nil && g.Resolver != nil {
+		resolver = g.Resolver
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("%w: %s could not be resolved", ErrWebhookBlocked, host)
+	}
+	for _, a := range addrs {
+		if !publicIP(a.IP) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrWebhookBlocked, host, a.IP)
+		}
+	}
+	return nil
+}
+
+// listed reports whether host matches an AllowedHosts entry.
+func (g *WebhookGuard) listed(host string) bool {
+	if g == nil {
+		return false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, h := range g.AllowedHosts {
+		h = strings.ToLower(strings.TrimSpace(h))
+		switch {
+		case h == "":
+		case strings.HasPrefix(h, "."):
+			if strings.HasSuffix(host, h) {
+				return true
+			}
+		case host == h:
+			return true
+		}
+	}
+	return false
+}
+
+// publicIP reports whether ip is a globally routable unicast address.
+func publicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		// 100.64.0.0/10 is carrier-grade NAT, often used inside clusters.
+		if ip[0] == 100 && ip[1]&0xc0 == 64 {
+			return false
+		}
+		if ip[0] == 0 {
+			return false
+		}
+	}
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// client returns the HTTP client webhooks are posted with. Unless the host
+// is listed, the address actually dialed must be public, which also covers
+// names that resolved elsewhere when the URL was checked. Redirects and
+// environment proxies are not followed, and connections are not kept since
+// each client serves one delivery.
+func (g *WebhookGuard) client(host string) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !g.listed(host) {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			h, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(h); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("%w: %s dialed %s", ErrWebhookBlocked, host, h)
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second, DisableKeepAlives: true},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Post POSTs a JSON body to a webhook and fails on any non-2xx status. A
+// URL or address the guard refuses can never be delivered, so it fails
+// with errOutboxPermanent.
+func (g *WebhookGuard) Post(ctx context.Context, raw string, body []byte) error {
+	if err := g.Check(ctx, raw); err != nil {
+		return fmt.Errorf("%w: %w", errOutboxPermanent, err)
+	}
+	u, _ := url.Parse(strings.TrimSpace(raw))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errOutboxPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := g.client(u.Hostname()).Do(req)
+	if err != nil {
+		if errors.Is(err, ErrWebhookBlocked) {
+			return fmt.Errorf("%w: %w", errOutboxPermanent, err)
+		}
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook status %d", resp.StatusCode)
+	}
+	return nil
+}
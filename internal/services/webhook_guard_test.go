@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookGuardCheck(t *testing.T) {
+	ctx := context.Background()
+	open := &WebhookGuard{}
+	listed := &WebhookGuard{AllowedHosts: []string{"hooks.example.com", ".internal.example.com", "10.0.0.7"}}
+	cases := []struct {
+		name  string
+		guard *WebhookGuard
+		url   string
+		want  error
+	}{
+		{"not http", open, "ftp://hooks.example.com/x", ErrInvalidWebhookURL},
+		{"no host", open, "https:///x", ErrInvalidWebhookURL},
+		{"loopback", open, "http://127.0.0.1:8080/x", ErrWebhookBlocked},
+		{"localhost name", open, "http://localhost/x", ErrWebhookBlocked},
+		{"ipv6 loopback", open, "http://[::1]/x", ErrWebhookBlocked},
+		{"metadata", open, "http://169.254.169.254/latest/meta-data/", ErrWebhookBlocked},
+		{"private", open, "https://10.1.2.3/x", ErrWebhookBlocked},
+		{"cgnat", open, "https://100.64.0.1/x", ErrWebhookBlocked},
+		{"unspecified", open, "https://0.0.0.0/x", ErrWebhookBlocked},
+		{"public ip", open, "https://93.184.216.34/x", nil},
+		{"nil guard public", nil, "https://93.184.216.34/x", nil},
+		{"nil guard private", nil, "https://192.168.1.1/x", ErrWebhookBlocked},
+		{"listed host", listed, "https://hooks.example.com/x", nil},
+		{"listed subdomain", listed, "https://a.internal.example.com/x", nil},
+		{"listed internal ip", listed, "http://10.0.0.7/x", nil},
+		{"unlisted host", listed, "https://93.184.216.34/x", ErrWebhookBlocked},
+		{"suffix is not subdomain", listed, "https://evilinternal.example.com/x", ErrWebhookBlocked},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.guard.Check(ctx, tc.url)
+			if tc.want == nil && err != nil {
+				t.Fatalf("Check(%s) = %v, want nil", tc.url, err)
+			}
+			if tc.want != nil && !errors.Is(err, tc.want) {
+				t.Fatalf("Check(%s) = %v, want %v", tc.url, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestWebhookGuardPost(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/x", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	// The test server listens on loopback, which is refused before any
+	// request is sent and can never succeed on retry.
+	err := (&WebhookGuard{}).Post(ctx, srv.URL+"/x", []byte(`{}`))
+	if !errors.Is(err, ErrWebhookBlocked) || !errors.Is(err, errOutboxPermanent) {
+		t.Fatalf("Post to loopback = %v, want a permanent ErrWebhookBlocked", err)
+	}
+	if hits != 0 {
+		t.Fatalf("server saw %d requests, want 0", hits)
+	}
+
+	listed := &WebhookGuard{AllowedHosts: []string{"127.0.0.1"}}
+	if err := listed.Post(ctx, srv.URL+"/x", []byte(`{}`)); err != nil {
+		t.Fatalf("Post to listed host = %v", err)
+	}
+	if err := listed.Post(ctx, srv.URL+"/redirect", []byte(`{}`)); err == nil {
+		t.Fatal("Post followed a redirect, want a non-2xx failure")
+	}
+	if hits != 2 {
+		t.Fatalf("server saw %d requests, want 2", hits)
+	}
+}
@@ -0,0 +1,368 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// migration is one versioned schema change. up runs inside the migration
+// transaction. A released migration is never edited; later changes go in a
+// new one.
+type migration struct {
+	version int
+	name    string
+	up      func(ctx context.Context, tx *sql.Tx) error
+}
+
+func (m migration) label() string {
+	return fmt.Sprintf("%03d_%s", m.version, m.name)
+}
+
+// migrations is the schema history in version order.
+var migrations = []migration{
+	{1, "initial_schema", execAll(initialSchema...)},
+	{2, "chat_feedback_created_at_idx", execAll(
+		`CREATE INDEX IF NOT EXISTS chat_feedback_created_at_idx ON chat_feedback(created_at)`,
+	)},
+	{3, "step_bodies", execAll(
+		`CREATE TABLE IF NOT EXISTS step_bodies (
+			id TEXT PRIMARY KEY,
+			owner_key TEXT NOT NULL,
+			tool TEXT NOT NULL DEFAULT '',
+			body TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS step_bodies_expires_at_idx ON step_bodies(expires_at)`,
+	)},
+	{4, "device_notes", execAll(
+		`CREATE TABLE IF NOT EXISTS device_notes (
+			id BIGSERIAL PRIMARY KEY,
+			host TEXT NOT NULL,
+			author TEXT NOT NULL DEFAULT '',
+			note TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS device_notes_host_idx ON device_notes(host, created_at)`,
+	)},
+	{5, "outbox", execAll(
+		`CREATE TABLE IF NOT EXISTS outbox (
+			id BIGSERIAL PRIMARY KEY,
+			kind TEXT NOT NULL,
+			payload JSONB NOT NULL,
+			attempts INT NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS outbox_next_attempt_at_idx ON outbox(next_attempt_at)`,
+		`CREATE TABLE IF NOT EXISTS dead_letters (
+			id BIGSERIAL PRIMARY KEY,
+			kind TEXT NOT NULL,
+			payload JSONB NOT NULL,
+			attempts INT NOT NULL,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL,
+			failed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+	)},
+	{6, "chat_jobs", execAll(
+		`CREATE TABLE IF NOT EXISTS chat_jobs (
+			id TEXT PRIMARY KEY,
+			owner_key TEXT NOT NULL,
+			role TEXT NOT NULL DEFAULT '',
+			conversation_id TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL,
+			request JSONB NOT NULL,
+			response JSONB,
+			error_code TEXT NOT NULL DEFAULT '',
+			error_message TEXT NOT NULL DEFAULT '',
+			attempts INT NOT NULL DEFAULT 0,
+			lease_until TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			started_at TIMESTAMPTZ,
+			finished_at TIMESTAMPTZ
+		)`,
+		`CREATE INDEX IF NOT EXISTS chat_jobs_status_created_at_idx ON chat_jobs(status, created_at)`,
+		`CREATE INDEX IF NOT EXISTS chat_jobs_owner_status_idx ON chat_jobs(owner_key, status)`,
+		`CREATE INDEX IF NOT EXISTS chat_jobs_conversation_idx ON chat_jobs(conversation_id, created_at) WHERE conversation_id <> ''`,
+	)},
+	{7, "entity_aliases", execAll(
+		`CREATE TABLE IF NOT EXISTS entity_aliases (
+			owner_key TEXT NOT NULL,
+			alias TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			target TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (owner_key, alias)
+		)`,
+	)},
+	{8, "extracts", execAll(
+		`CREATE TABLE IF NOT EXISTS extracts (
+			id TEXT PRIMARY KEY,
+			owner_key TEXT NOT NULL,
+			subject TEXT NOT NULL DEFAULT '',
+			filter TEXT NOT NULL,
+			row_estimate BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS extracts_expires_at_idx ON extracts(expires_at)`,
+	)},
+	{9, "telemetry_thresholds", execAll(
+		`CREATE TABLE IF NOT EXISTS telemetry_thresholds (
+			owner_key TEXT NOT NULL,
+			metric TEXT NOT NULL,
+			warning DOUBLE PRECISION NOT NULL DEFAULT 0,
+			critical DOUBLE PRECISION NOT NULL DEFAULT 0,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (owner_key, metric)
+		)`,
+	)},
+}
+
+// migrationLockID is the advisory lock held while migrations run, so
+// replicas starting together apply them once.
+const migrationLockID int64 = 7_305_145_981
+
+// initialSchema is the schema from before migrations were versioned. Every
+// statement is idempotent, so a database created by the old EnsureSchema
+// adopts it as version 1 without changes.
+var initialSchema = []string{
+	`CREATE TABLE IF NOT EXISTS chat_conversations (
+		conversation_id TEXT PRIMARY KEY,
+		owner_key TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS chat_conversations_owner_key_idx ON chat_conversations(owner_key)`,
+	`ALTER TABLE chat_conversations ADD COLUMN IF NOT EXISTS title TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS chat_messages (
+		id BIGSERIAL PRIMARY KEY,
+		conversation_id TEXT NOT NULL REFERENCES chat_conversations(conversation_id) ON DELETE CASCADE,
+		owner_key TEXT NOT NULL,
+		role TEXT NOT NULL,
+		content TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS chat_messages_conversation_id_idx ON chat_messages(conversation_id, id)`,
+	`CREATE INDEX IF NOT EXISTS chat_messages_owner_key_idx ON chat_messages(owner_key)`,
+	// ListMessages filters on owner and conversation; ListConversations
+	// sorts an owner's conversations by activity.
+	`CREATE INDEX IF NOT EXISTS chat_messages_owner_conversation_created_idx ON chat_messages(owner_key, conversation_id, created_at)`,
+	`CREATE INDEX IF NOT EXISTS chat_conversations_owner_updated_idx ON chat_conversations(owner_key, updated_at DESC)`,
+	`CREATE TABLE IF NOT EXISTS alert_rules (
+		id BIGSERIAL PRIMARY KEY,
+		owner_key TEXT NOT NULL,
+		scope_type TEXT NOT NULL,
+		scope_value TEXT NOT NULL DEFAULT '',
+		condition TEXT NOT NULL,
+		threshold DOUBLE PRECISION NOT NULL,
+		cooldown_seconds BIGINT NOT NULL DEFAULT 3600,
+		webhook_url TEXT NOT NULL DEFAULT '',
+		conversation_id TEXT NOT NULL DEFAULT '',
+		last_fired_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS alert_rules_owner_key_idx ON alert_rules(owner_key)`,
+	`CREATE TABLE IF NOT EXISTS play_targets (
+		id BIGSERIAL PRIMARY KEY,
+		owner_key TEXT NOT NULL,
+		campaign_id TEXT NOT NULL DEFAULT '',
+		poster_id TEXT NOT NULL DEFAULT '',
+		scope_type TEXT NOT NULL,
+		scope_value TEXT NOT NULL DEFAULT '',
+		hosts TEXT[] NOT NULL DEFAULT '{}',
+		target_plays BIGINT NOT NULL,
+		period TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS play_targets_owner_period_idx ON play_targets(owner_key, period)`,
+	`CREATE TABLE IF NOT EXISTS debug_bundles (
+		id TEXT PRIMARY KEY,
+		owner_key TEXT NOT NULL,
+		conversation_id TEXT NOT NULL DEFAULT '',
+		bundle JSONB NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		expires_at TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS debug_bundles_expires_at_idx ON debug_bundles(expires_at)`,
+	`CREATE TABLE IF NOT EXISTS saved_queries (
+		owner_key TEXT NOT NULL,
+		name TEXT NOT NULL,
+		message TEXT NOT NULL,
+		handler TEXT NOT NULL DEFAULT '',
+		slots JSONB NOT NULL DEFAULT '{}',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (owner_key, name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS device_command_audit (
+		id BIGSERIAL PRIMARY KEY,
+		owner_key TEXT NOT NULL,
+		conversation_id TEXT NOT NULL DEFAULT '',
+		host TEXT NOT NULL,
+		action TEXT NOT NULL,
+		path TEXT NOT NULL,
+		dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+		status INT NOT NULL DEFAULT 0,
+		outcome TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS device_command_audit_host_idx ON device_command_audit(host, created_at)`,
+	`ALTER TABLE device_command_audit ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS chat_usage (
+		id BIGSERIAL PRIMARY KEY,
+		owner_hash TEXT NOT NULL,
+		handler TEXT NOT NULL,
+		duration_ms BIGINT NOT NULL,
+		is_error BOOLEAN NOT NULL DEFAULT FALSE,
+		is_clarification BOOLEAN NOT NULL DEFAULT FALSE,
+		message TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS chat_usage_created_at_idx ON chat_usage(created_at)`,
+	`CREATE TABLE IF NOT EXISTS answer_snapshots (
+		owner_key TEXT NOT NULL,
+		question TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		rows JSONB NOT NULL DEFAULT '[]',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (owner_key, question)
+	)`,
+	`CREATE TABLE IF NOT EXISTS idempotency_keys (
+		owner_key TEXT NOT NULL,
+		key TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		token TEXT NOT NULL,
+		response JSONB,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		expires_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (owner_key, key)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys(expires_at)`,
+	// The handler that wrote each assistant message, for feedback analytics.
+	`ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS handler TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS chat_feedback (
+		id BIGSERIAL PRIMARY KEY,
+		owner_key TEXT NOT NULL,
+		conversation_id TEXT NOT NULL,
+		message_id BIGINT NOT NULL REFERENCES chat_messages(id) ON DELETE CASCADE,
+		handler TEXT NOT NULL DEFAULT '',
+		rating TEXT NOT NULL,
+		comment TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		UNIQUE (owner_key, message_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS chat_feedback_message_id_idx ON chat_feedback(message_id)`,
+	`CREATE TABLE IF NOT EXISTS campaign_device_snapshots (
+		campaign_id TEXT PRIMARY KEY,
+		hosts TEXT[] NOT NULL DEFAULT '{}',
+		taken_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+}
+
+func execAll(stmts ...string) func(context.Context, *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		for _, q := range stmts {
+			if _, err := tx.ExecContext(ctx, q); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+const createSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INT PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+)`
+
+// EnsureSchema applies the pending migrations in one transaction holding
+// an advisory lock. A replica that waited on the lock finds them recorded
+// and applies nothing; a failed migration rolls all of them back.
+func (s *PostgresStore) EnsureSchema(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, createSchemaMigrations); err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(ctx, tx)
+	if err != nil {
+		return err
+	}
+	var ran []string
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if err := m.up(ctx, tx); err != nil {
+			return fmt.Errorf("migration %s: %w", m.label(), err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.version, m.name); err != nil {
+			return err
+		}
+		ran = append(ran, m.label())
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if len(ran) > 0 {
+		log.Printf("schema: applied migrations %v", ran)
+	}
+	return nil
+}
+
+// PendingMigrations lists the migrations not yet applied, by label, without
+// changing anything.
+func (s *PostgresStore) PendingMigrations(ctx context.Context) ([]string, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return nil, err
+	}
+	applied := map[int]bool{}
+	if exists {
+		var err error
+		if applied, err = appliedMigrations(ctx, s.db); err != nil {
+			return nil, err
+		}
+	}
+	var pending []string
+	for _, m := range migrations {
+		if !applied[m.version] {
+			pending = append(pending, m.label())
+		}
+	}
+	return pending, nil
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func appliedMigrations(ctx context.Context, q queryer) (map[int]bool, error) {
+	rows, err := q.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int]bool{}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		out[v] = true
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// testDB opens TEST_DATABASE_URL with a fresh schema first on the search
+// path, dropped when the test ends. The test is skipped when the variable is
+// unset.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := strings.TrimSpace(os.Getenv("TEST_DATABASE_URL"))
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() { _, _ = admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`) })
+
+	// lib/pq sends unknown settings as run-time parameters.
+	sep := " "
+	if strings.Contains(dsn, "://") {
+		sep = "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+	}
+	db, err := sql.Open("postgres", dsn+sep+"search_path="+schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func appliedCount(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestEnsureSchemaTwice(t *testing.T) {
+	db := testDB(t)
+	s := NewPostgresStore(db)
+	ctx := context.Background()
+
+	pending, err := s.PendingMigrations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(migrations) {
+		t.Fatalf("fresh database has %d pending migrations, want %d", len(pending), len(migrations))
+	}
+	for run := 1; run <= 2; run++ {
+		if err := s.EnsureSchema(ctx); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		if n := appliedCount(t, db); n != len(migrations) {
+			t.Fatalf("run %d: %d migrations recorded, want %d", run, n, len(migrations))
+		}
+	}
+	if pending, err := s.PendingMigrations(ctx); err != nil || len(pending) != 0 {
+		t.Fatalf("pending after migrating = %v, %v", pending, err)
+	}
+}
+
+// A replica that starts while another holds the migration lock waits for it,
+// then finds the migrations recorded.
+func TestEnsureSchemaWaitsForTheLock(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	holder, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Rollback()
+	if _, err := holder.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { done <- NewPostgresStore(db).EnsureSchema(ctx) }()
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("migration ran while the lock was held: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	if err := holder.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("migration after the lock was released: %v", err)
+			}
+		case <-time.After(30 * time.Second):
+			t.Fatal("migration still waiting after the lock was released")
+		}
+	}
+	if n := appliedCount(t, db); n != len(migrations) {
+		t.Fatalf("%d migrations recorded by two concurrent runs, want %d", n, len(migrations))
+	}
+}
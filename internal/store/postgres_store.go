@@ -3,51 +3,74 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"log"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 
 	"openai-agent-service/internal/models"
 )
 
 type PostgresStore struct {
 	db *sql.DB
+	// QueryTimeout bounds each store call (default 3s); the caller's deadline
+	// still applies when it is sooner.
+	QueryTimeout time.Duration
+	// SlowQuery is the duration above which a store call is logged with its
+	// method, duration and row count (default 500ms).
+	SlowQuery time.Duration
 }
 
 func NewPostgresStore(db *sql.DB) *PostgresStore {
 	return &PostgresStore{db: db}
 }
 
-func (s *PostgresStore) EnsureSchema(ctx context.Context) error {
-	stmts := []string{
-		`CREATE TABLE IF NOT EXISTS chat_conversations (
-			conversation_id TEXT PRIMARY KEY,
-			owner_key TEXT NOT NULL,
-			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-		)`,
-		`CREATE INDEX IF NOT EXISTS chat_conversations_owner_key_idx ON chat_conversations(owner_key)`,
-		`CREATE TABLE IF NOT EXISTS chat_messages (
-			id BIGSERIAL PRIMARY KEY,
-			conversation_id TEXT NOT NULL REFERENCES chat_conversations(conversation_id) ON DELETE CASCADE,
-			owner_key TEXT NOT NULL,
-			role TEXT NOT NULL,
-			content TEXT NOT NULL,
-			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-		)`,
-		`CREATE INDEX IF NOT EXISTS chat_messages_conversation_id_idx ON chat_messages(conversation_id, id)`,
-		`CREATE INDEX IF NOT EXISTS chat_messages_owner_key_idx ON chat_messages(owner_key)`,
-	}
-	for _, q := range stmts {
-		if _, err := s.db.ExecContext(ctx, q); err != nil {
-			return err
-		}
+// storeCall is one bounded, timed store method call.
+type storeCall struct {
+	s      *PostgresStore
+	method string
+	start  time.Time
+	cancel context.CancelFunc
+	// rows is the number of rows read or affected, or -1 when not tracked.
+	rows int64
+}
+
+// begin bounds ctx by QueryTimeout for one store call; end releases it and
+// logs the call when it was slow.
+func (s *PostgresStore) begin(ctx context.Context, method string) (context.Context, *storeCall) {
+	timeout := s.QueryTimeout
+	if timeout <= 0 {
+		timeout = 3 * time.Second
 	}
-	return nil
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, &storeCall{s: s, method: method, start: time.Now(), cancel: cancel, rows: -1}
+}
+
+func (c *storeCall) end() {
+	c.cancel()
+	threshold := c.s.SlowQuery
+	if threshold <= 0 {
+		threshold = 500 * time.Millisecond
+	}
+	dur := time.Since(c.start)
+	if dur < threshold {
+		return
+	}
+	rows := "n/a"
+	if c.rows >= 0 {
+		rows = strconv.FormatInt(c.rows, 10)
+	}
+	log.Printf("store slow method=%s dur_ms=%d rows=%s", c.method, dur.Milliseconds(), rows)
 }
 
 func (s *PostgresStore) CreateConversation(ctx context.Context, ownerKey string) (models.Conversation, error) {
+	ctx, call := s.begin(ctx, "CreateConversation")
+	defer call.end()
 	id := uuid.NewString()
 	if _, err := s.db.ExecContext(ctx,
 		`INSERT INTO chat_conversations (conversation_id, owner_key) VALUES ($1, $2)`,
@@ -59,14 +82,112 @@ func (s *PostgresStore) CreateConversation(ctx context.Context, ownerKey string)
 }
 
 func (s *PostgresStore) GetConversation(ctx context.Context, ownerKey, conversationID string) (models.Conversation, error) {
+	ctx, call := s.begin(ctx, "GetConversation")
+	defer call.end()
 	var c models.Conversation
 	err := s.db.QueryRowContext(ctx,
-		`SELECT conversation_id, created_at, updated_at FROM chat_conversations WHERE owner_key = $1 AND conversation_id = $2`,
+		`SELECT conversation_id, title, created_at, updated_at FROM chat_conversations WHERE owner_key = $1 AND conversation_id = $2`,
 		ownerKey, conversationID,
-	).Scan(&c.ConversationID, &c.CreatedAt, &c.UpdatedAt)
+	).Scan(&c.ConversationID, &c.Title, &c.CreatedAt, &c.UpdatedAt)
 	return c, err
 }
 
+// ListConversations returns the owner's conversations, most recently active
+// first.
+func (s *PostgresStore) ListConversations(ctx context.Context, ownerKey string, limit int) ([]models.Conversation, error) {
+	ctx, call := s.begin(ctx, "ListConversations")
+	defer call.end()
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT conversation_id, title, created_at, updated_at
+		 FROM chat_conversations
+		 WHERE owner_key = $1
+		 ORDER BY updated_at DESC
+		 LIMIT $2`,
+		ownerKey, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.Conversation, 0)
+	for rows.Next() {
+		var c models.Conversation
+		if err := rows.Scan(&c.ConversationID, &c.Title, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, c)
+	}
+	call.rows = int64(len(items))
+	return items, rows.Err()
+}
+
+// maxTitleRunes bounds conversation titles.
+const maxTitleRunes = 120
+
+func clipTitle(title string) string {
+	title = strings.Join(strings.Fields(title), " ")
+	if r := []rune(title); len(r) > maxTitleRunes {
+		title = strings.TrimSpace(string(r[:maxTitleRunes]))
+	}
+	return title
+}
+
+// UpdateConversationTitle renames a conversation. It returns sql.ErrNoRows if
+// the conversation does not exist for ownerKey.
+func (s *PostgresStore) UpdateConversationTitle(ctx context.Context, ownerKey, conversationID, title string) error {
+	ctx, call := s.begin(ctx, "UpdateConversationTitle")
+	defer call.end()
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE chat_conversations SET title = $3 WHERE owner_key = $1 AND conversation_id = $2`,
+		ownerKey, conversationID, clipTitle(title),
+	)
+	if err != nil {
+		return err
+	}
+	aff, _ := res.RowsAffected()
+	call.rows = aff
+	if aff == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetDefaultConversationTitle sets an automatic title only while the
+// conversation is still untitled, so it never overwrites a user rename.
+func (s *PostgresStore) SetDefaultConversationTitle(ctx context.Context, ownerKey, conversationID, title string) error {
+	ctx, call := s.begin(ctx, "SetDefaultConversationTitle")
+	defer call.end()
+	title = clipTitle(title)
+	if title == "" {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE chat_conversations SET title = $3 WHERE owner_key = $1 AND conversation_id = $2 AND title = ''`,
+		ownerKey, conversationID, title,
+	)
+	return err
+}
+
+// ConversationOwner returns the owner_key of a conversation, or "" when the
+// conversation does not exist yet.
+func (s *PostgresStore) ConversationOwner(ctx context.Context, conversationID string) (string, error) {
+	ctx, call := s.begin(ctx, "ConversationOwner")
+	defer call.end()
+	var owner string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT owner_key FROM chat_conversations WHERE conversation_id = $1`,
+		conversationID,
+	).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return owner, err
+}
+
 func (s *PostgresStore) touchConversation(ctx context.Context, ownerKey, conversationID string) error {
 	res, err := s.db.ExecContext(ctx,
 		`UPDATE chat_conversations SET updated_at = NOW() WHERE owner_key = $1 AND conversation_id = $2`,
@@ -83,6 +204,20 @@ func (s *PostgresStore) touchConversation(ctx context.Context, ownerKey, convers
 }
 
 func (s *PostgresStore) AppendMessage(ctx context.Context, ownerKey, conversationID, role, content string) error {
+	ctx, call := s.begin(ctx, "AppendMessage")
+	defer call.end()
+	return s.appendMessage(ctx, ownerKey, conversationID, role, content, "")
+}
+
+// AppendAssistantMessage stores an assistant answer with the handler that
+// produced it.
+func (s *PostgresStore) AppendAssistantMessage(ctx context.Context, ownerKey, conversationID, content, handler string) error {
+	ctx, call := s.begin(ctx, "AppendAssistantMessage")
+	defer call.end()
+	return s.appendMessage(ctx, ownerKey, conversationID, "assistant", content, handler)
+}
+
+func (s *PostgresStore) appendMessage(ctx context.Context, ownerKey, conversationID, role, content, handler string) error {
 	if strings.TrimSpace(conversationID) == "" {
 		return nil
 	}
@@ -99,13 +234,15 @@ func (s *PostgresStore) AppendMessage(ctx context.Context, ownerKey, conversatio
 		}
 	}
 	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO chat_messages (conversation_id, owner_key, role, content) VALUES ($1, $2, $3, $4)`,
-		conversationID, ownerKey, role, content,
+		`INSERT INTO chat_messages (conversation_id, owner_key, role, content, handler) VALUES ($1, $2, $3, $4, $5)`,
+		conversationID, ownerKey, role, content, handler,
 	)
 	return err
 }
 
 func (s *PostgresStore) ListMessages(ctx context.Context, ownerKey, conversationID string, limit int) ([]models.Message, error) {
+	ctx, call := s.begin(ctx, "ListMessages")
+	defer call.end()
 	if strings.TrimSpace(conversationID) == "" {
 		return []models.Message{}, nil
 	}
@@ -113,7 +250,7 @@ func (s *PostgresStore) ListMessages(ctx context.Context, ownerKey, conversation
 		limit = 20
 	}
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, conversation_id, role, content, created_at
+		`SELECT id, conversation_id, role, content, handler, created_at
 		 FROM chat_messages
 		 WHERE owner_key = $1 AND conversation_id = $2
 		 ORDER BY id DESC
@@ -128,13 +265,1286 @@ func (s *PostgresStore) ListMessages(ctx context.Context, ownerKey, conversation
 	items := make([]models.Message, 0)
 	for rows.Next() {
 		var m models.Message
-		if err := rows.Scan(&m.ID, &m.ConversationID, &m.Role, &m.Content, &m.CreatedAt); err != nil {
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.Role, &m.Content, &m.Handler, &m.CreatedAt); err != nil {
 			return nil, err
 		}
 		items = append(items, m)
 	}
+	call.rows = int64(len(items))
 	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
 		items[i], items[j] = items[j], items[i]
 	}
 	return items, nil
 }
+
+// importMessageBatch is the number of messages per INSERT of an import.
+const importMessageBatch = 100
+
+// ImportConversation creates a conversation for ownerKey holding msgs with
+// their own timestamps, in one transaction: either the whole transcript is
+// stored or nothing is. Assistant messages are recorded with the handler
+// "imported".
+func (s *PostgresStore) ImportConversation(ctx context.Context, ownerKey, title string, msgs []models.ImportedMessage) (models.Conversation, error) {
+	ctx, call := s.begin(ctx, "ImportConversation")
+	defer call.end()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+	defer tx.Rollback()
+
+	if len(msgs) == 0 {
+		return models.Conversation{}, errors.New("import has no messages")
+	}
+	c := models.Conversation{
+		ConversationID: uuid.NewString(),
+		Title:          clipTitle(title),
+		CreatedAt:      msgs[0].Timestamp,
+		UpdatedAt:      msgs[len(msgs)-1].Timestamp,
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO chat_conversations (conversation_id, owner_key, title, created_at, updated_at) VALUES ($1, $2, $3, $4, $5)`,
+		c.ConversationID, ownerKey, c.Title, c.CreatedAt, c.UpdatedAt,
+	); err != nil {
+		return models.Conversation{}, err
+	}
+
+	for start := 0; start < len(msgs); start += importMessageBatch {
+		end := min(start+importMessageBatch, len(msgs))
+		var q strings.Builder
+		q.WriteString(`INSERT INTO chat_messages (conversation_id, owner_key, role, content, handler, created_at) VALUES `)
+		args := make([]any, 0, (end-start)*4+2)
+		args = append(args, c.ConversationID, ownerKey)
+		for i, m := range msgs[start:end] {
+			if i > 0 {
+				q.WriteString(", ")
+			}
+			n := len(args)
+			q.WriteString("($1, $2, $" + strconv.Itoa(n+1) + ", $" + strconv.Itoa(n+2) + ", $" + strconv.Itoa(n+3) + ", $" + strconv.Itoa(n+4) + ")")
+			handler := ""
+			if m.Role == "assistant" {
+				handler = "imported"
+			}
+			args = append(args, m.Role, m.Content, handler, m.Timestamp)
+		}
+		if _, err := tx.ExecContext(ctx, q.String(), args...); err != nil {
+			return models.Conversation{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Conversation{}, err
+	}
+	call.rows = int64(len(msgs))
+	return c, nil
+}
+
+const alertRuleColumns = `id, owner_key, scope_type, scope_value, condition, threshold, cooldown_seconds, webhook_url, conversation_id, last_fired_at, created_at`
+
+func scanAlertRule(sc interface{ Scan(...any) error }) (models.AlertRule, error) {
+	var r models.AlertRule
+	var fired sql.NullTime
+	if err := sc.Scan(&r.ID, &r.OwnerKey, &r.ScopeType, &r.ScopeValue, &r.Condition, &r.Threshold, &r.CooldownSeconds, &r.WebhookURL, &r.ConversationID, &fired, &r.CreatedAt); err != nil {
+		return models.AlertRule{}, err
+	}
+	if fired.Valid {
+		t := fired.Time
+		r.LastFiredAt = &t
+	}
+	return r, nil
+}
+
+func (s *PostgresStore) CreateAlertRule(ctx context.Context, rule models.AlertRule) (models.AlertRule, error) {
+	ctx, call := s.begin(ctx, "CreateAlertRule")
+	defer call.end()
+	row := s.db.QueryRowContext(ctx,
+		`INSERT INTO alert_rules (owner_key, scope_type, scope_value, condition, threshold, cooldown_seconds, webhook_url, conversation_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		 RETURNING `+alertRuleColumns,
+		rule.OwnerKey, rule.ScopeType, rule.ScopeValue, rule.Condition, rule.Threshold, rule.CooldownSeconds, rule.WebhookURL, rule.ConversationID,
+	)
+	return scanAlertRule(row)
+}
+
+// ListAlertRules returns the owner's rules, or every rule when ownerKey is empty
+// (used by the background evaluator).
+func (s *PostgresStore) ListAlertRules(ctx context.Context, ownerKey string) ([]models.AlertRule, error) {
+	ctx, call := s.begin(ctx, "ListAlertRules")
+	defer call.end()
+	q := `SELECT ` + alertRuleColumns + ` FROM alert_rules`
+	args := []any{}
+	if strings.TrimSpace(ownerKey) != "" {
+		q += ` WHERE owner_key = $1`
+		args = append(args, ownerKey)
+	}
+	q += ` ORDER BY id`
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.AlertRule, 0)
+	for rows.Next() {
+		r, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, r)
+	}
+	call.rows = int64(len(items))
+	return items, rows.Err()
+}
+
+func (s *PostgresStore) DeleteAlertRule(ctx context.Context, ownerKey string, id int64) error {
+	ctx, call := s.begin(ctx, "DeleteAlertRule")
+	defer call.end()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM alert_rules WHERE owner_key = $1 AND id = $2`, ownerKey, id)
+	if err != nil {
+		return err
+	}
+	aff, _ := res.RowsAffected()
+	call.rows = aff
+	if aff == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *PostgresStore) MarkAlertRuleFired(ctx context.Context, id int64, at time.Time) error {
+	ctx, call := s.begin(ctx, "MarkAlertRuleFired")
+	defer call.end()
+	_, err := s.db.ExecContext(ctx, `UPDATE alert_rules SET last_fired_at = $2 WHERE id = $1`, id, at)
+	return err
+}
+
+const playTargetColumns = `id, owner_key, campaign_id, poster_id, scope_type, scope_value, hosts, target_plays, period, created_at`
+
+func scanPlayTarget(sc interface{ Scan(...any) error }) (models.PlayTarget, error) {
+	var t models.PlayTarget
+	var hosts []string
+	if err := sc.Scan(&t.ID, &t.OwnerKey, &t.CampaignID, &t.PosterID, &t.ScopeType, &t.ScopeValue, pq.Array(&hosts), &t.TargetPlays, &t.Period, &t.CreatedAt); err != nil {
+		return models.PlayTarget{}, err
+	}
+	if len(hosts) > 0 {
+		t.Hosts = hosts
+	}
+	return t, nil
+}
+
+func (s *PostgresStore) CreatePlayTarget(ctx context.Context, target models.PlayTarget) (models.PlayTarget, error) {
+	ctx, call := s.begin(ctx, "CreatePlayTarget")
+	defer call.end()
+	hosts := target.Hosts
+	if hosts == nil {
+		hosts = []string{}
+	}
+	row := s.db.QueryRowContext(ctx,
+		`INSERT INTO play_targets (owner_key, campaign_id, poster_id, scope_type, scope_value, hosts, target_plays, period)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		 RETURNING `+playTargetColumns,
+		target.OwnerKey, target.CampaignID, target.PosterID, target.ScopeType, target.ScopeValue, pq.Array(hosts), target.TargetPlays, target.Period,
+	)
+	return scanPlayTarget(row)
+}
+
+// ListPlayTargets returns the owner's targets, limited to one period
+// ("2026-10") when period is non-empty.
+func (s *PostgresStore) ListPlayTargets(ctx context.Context, ownerKey, period string) ([]models.PlayTarget, error) {
+	ctx, call := s.begin(ctx, "ListPlayTargets")
+	defer call.end()
+	q := `SELECT ` + playTargetColumns + ` FROM play_targets WHERE owner_key = $1`
+	args := []any{ownerKey}
+	if strings.TrimSpace(period) != "" {
+		q += ` AND period = $2`
+		args = append(args, strings.TrimSpace(period))
+	}
+	q += ` ORDER BY id`
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.PlayTarget, 0)
+	for rows.Next() {
+		t, err := scanPlayTarget(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, t)
+	}
+	call.rows = int64(len(items))
+	return items, rows.Err()
+}
+
+func (s *PostgresStore) DeletePlayTarget(ctx context.Context, ownerKey string, id int64) error {
+	ctx, call := s.begin(ctx, "DeletePlayTarget")
+	defer call.end()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM play_targets WHERE owner_key = $1 AND id = $2`, ownerKey, id)
+	if err != nil {
+		return err
+	}
+	aff, _ := res.RowsAffected()
+	call.rows = aff
+	if aff == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const savedQueryColumns = `owner_key, name, message, handler, slots, created_at, updated_at`
+
+func scanSavedQuery(sc interface{ Scan(...any) error }) (models.SavedQuery, error) {
+	var q models.SavedQuery
+	var slots []byte
+	if err := sc.Scan(&q.OwnerKey, &q.Name, &q.Message, &q.Handler, &slots, &q.CreatedAt, &q.UpdatedAt); err != nil {
+		return models.SavedQuery{}, err
+	}
+	if err := json.Unmarshal(slots, &q.Slots); err != nil {
+		return models.SavedQuery{}, err
+	}
+	if len(q.Slots) == 0 {
+		q.Slots = nil
+	}
+	return q, nil
+}
+
+// PutSavedQuery creates the owner's saved query or replaces the one with the
+// same name, keeping its created_at.
+func (s *PostgresStore) PutSavedQuery(ctx context.Context, q models.SavedQuery) (models.SavedQuery, error) {
+	ctx, call := s.begin(ctx, "PutSavedQuery")
+	defer call.end()
+	slots := q.Slots
+	if slots == nil {
+		slots = map[string]string{}
+	}
+	raw, err := json.Marshal(slots)
+	if err != nil {
+		return models.SavedQuery{}, err
+	}
+	row := s.db.QueryRowContext(ctx,
+		`INSERT INTO saved_queries (owner_key, name, message, handler, slots)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (owner_key, name) DO UPDATE
+		 SET message = EXCLUDED.message, handler = EXCLUDED.handler, slots = EXCLUDED.slots, updated_at = NOW()
+		 RETURNING `+savedQueryColumns,
+		q.OwnerKey, q.Name, q.Message, q.Handler, raw,
+	)
+	return scanSavedQuery(row)
+}
+
+func (s *PostgresStore) ListSavedQueries(ctx context.Context, ownerKey string) ([]models.SavedQuery, error) {
+	ctx, call := s.begin(ctx, "ListSavedQueries")
+	defer call.end()
+	rows, err := s.db.QueryContext(ctx, `SELECT `+savedQueryColumns+` FROM saved_queries WHERE owner_key = $1 ORDER BY name`, ownerKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.SavedQuery, 0)
+	for rows.Next() {
+		q, err := scanSavedQuery(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, q)
+	}
+	call.rows = int64(len(items))
+	return items, rows.Err()
+}
+
+func (s *PostgresStore) DeleteSavedQuery(ctx context.Context, ownerKey, name string) error {
+	ctx, call := s.begin(ctx, "DeleteSavedQuery")
+	defer call.end()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM saved_queries WHERE owner_key = $1 AND name = $2`, ownerKey, name)
+	if err != nil {
+		return err
+	}
+	aff, _ := res.RowsAffected()
+	call.rows = aff
+	if aff == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SaveDebugBundle stores a captured bundle; it is readable until ExpiresAt.
+func (s *PostgresStore) SaveDebugBundle(ctx context.Context, b models.DebugBundle) error {
+	ctx, call := s.begin(ctx, "SaveDebugBundle")
+	defer call.end()
+	raw, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO debug_bundles (id, owner_key, conversation_id, bundle, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		b.ID, b.OwnerKey, b.ConversationID, raw, b.CreatedAt, b.ExpiresAt,
+	)
+	return err
+}
+
+// GetDebugBundle returns an unexpired bundle, or sql.ErrNoRows.
+func (s *PostgresStore) GetDebugBundle(ctx context.Context, id string) (models.DebugBundle, error) {
+	ctx, call := s.begin(ctx, "GetDebugBundle")
+	defer call.end()
+	var raw []byte
+	var owner string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT owner_key, bundle FROM debug_bundles WHERE id = $1 AND expires_at > NOW()`, id,
+	).Scan(&owner, &raw)
+	if err != nil {
+		return models.DebugBundle{}, err
+	}
+	var b models.DebugBundle
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return models.DebugBundle{}, err
+	}
+	b.OwnerKey = owner
+	return b, nil
+}
+
+// DeleteExpiredDebugBundles removes bundles whose retention ended before now.
+func (s *PostgresStore) DeleteExpiredDebugBundles(ctx context.Context, now time.Time) (int64, error) {
+	ctx, call := s.begin(ctx, "DeleteExpiredDebugBundles")
+	defer call.end()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM debug_bundles WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	call.rows = n
+	return n, err
+}
+
+// SaveStepBody stores the full body of a clipped step; it is readable until
+// ExpiresAt.
+func (s *PostgresStore) SaveStepBody(ctx context.Context, b models.StepBody) error {
+	ctx, call := s.begin(ctx, "SaveStepBody")
+	defer call.end()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO step_bodies (id, owner_key, tool, body, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		b.ID, b.OwnerKey, b.Tool, b.Body, b.CreatedAt, b.ExpiresAt,
+	)
+	return err
+}
+
+// GetStepBody returns an unexpired step body, or sql.ErrNoRows.
+func (s *PostgresStore) GetStepBody(ctx context.Context, id string) (models.StepBody, error) {
+	ctx, call := s.begin(ctx, "GetStepBody")
+	defer call.end()
+	var b models.StepBody
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, owner_key, tool, body, created_at, expires_at FROM step_bodies WHERE id = $1 AND expires_at > NOW()`, id,
+	).Scan(&b.ID, &b.OwnerKey, &b.Tool, &b.Body, &b.CreatedAt, &b.ExpiresAt)
+	return b, err
+}
+
+// DeleteExpiredStepBodies removes step bodies whose retention ended before
+// now.
+func (s *PostgresStore) DeleteExpiredStepBodies(ctx context.Context, now time.Time) (int64, error) {
+	ctx, call := s.begin(ctx, "DeleteExpiredStepBodies")
+	defer call.end()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM step_bodies WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	call.rows = n
+	return n, err
+}
+
+func (s *PostgresStore) AppendDeviceCommandAudit(ctx context.Context, a models.DeviceCommandAudit) error {
+	ctx, call := s.begin(ctx, "AppendDeviceCommandAudit")
+	defer call.end()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO device_command_audit (owner_key, role, conversation_id, host, action, path, dry_run, status, outcome, error)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		a.OwnerKey, a.Role, a.ConversationID, a.Host, a.Action, a.Path, a.DryRun, a.Status, a.Outcome, a.Error,
+	)
+	return err
+}
+
+const deviceNoteColumns = `id, host, author, note, created_at`
+
+func (s *PostgresStore) AddDeviceNote(ctx context.Context, n models.DeviceNote) (models.DeviceNote, error) {
+	ctx, call := s.begin(ctx, "AddDeviceNote")
+	defer call.end()
+	var out models.DeviceNote
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO device_notes (host, author, note) VALUES ($1, $2, $3) RETURNING `+deviceNoteColumns,
+		n.Host, n.Author, n.Note,
+	).Scan(&out.ID, &out.Host, &out.Author, &out.Note, &out.CreatedAt)
+	return out, err
+}
+
+// ListDeviceNotes returns the host's notes, newest first.
+func (s *PostgresStore) ListDeviceNotes(ctx context.Context, host string) ([]models.DeviceNote, error) {
+	ctx, call := s.begin(ctx, "ListDeviceNotes")
+	defer call.end()
+	rows, err := s.db.QueryContext(ctx, `SELECT `+deviceNoteColumns+` FROM device_notes WHERE host = $1 ORDER BY created_at DESC, id DESC`, host)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.DeviceNote, 0)
+	for rows.Next() {
+		var n models.DeviceNote
+		if err := rows.Scan(&n.ID, &n.Host, &n.Author, &n.Note, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, n)
+	}
+	call.rows = int64(len(items))
+	return items, rows.Err()
+}
+
+// DeleteDeviceNote deletes note id if it is on host.
+func (s *PostgresStore) DeleteDeviceNote(ctx context.Context, host string, id int64) error {
+	ctx, call := s.begin(ctx, "DeleteDeviceNote")
+	defer call.end()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM device_notes WHERE host = $1 AND id = $2`, host, id)
+	if err != nil {
+		return err
+	}
+	aff, _ := res.RowsAffected()
+	call.rows = aff
+	if aff == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const outboxColumns = `id, kind, payload, attempts, next_attempt_at, last_error, created_at`
+
+func scanOutboxEntry(sc interface{ Scan(...any) error }) (models.OutboxEntry, error) {
+	var e models.OutboxEntry
+	var payload []byte
+	if err := sc.Scan(&e.ID, &e.Kind, &payload, &e.Attempts, &e.NextAttemptAt, &e.LastError, &e.CreatedAt); err != nil {
+		return e, err
+	}
+	e.Payload = json.RawMessage(payload)
+	return e, nil
+}
+
+func (s *PostgresStore) EnqueueOutbox(ctx context.Context, kind string, payload []byte) error {
+	ctx, call := s.begin(ctx, "EnqueueOutbox")
+	defer call.end()
+	_, err := s.db.ExecContext(ctx, `INSERT INTO outbox (kind, payload) VALUES ($1, $2)`, kind, payload)
+	return err
+}
+
+// MarkAlertRuleFiredAndEnqueue records that a rule fired and queues its
+// notification in one transaction, so a fired rule always has its delivery
+// queued and a queued delivery always belongs to a fired rule.
+func (s *PostgresStore) MarkAlertRuleFiredAndEnqueue(ctx context.Context, id int64, at time.Time, kind string, payload []byte) error {
+	ctx, call := s.begin(ctx, "MarkAlertRuleFiredAndEnqueue")
+	defer call.end()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `UPDATE alert_rules SET last_fired_at = $2 WHERE id = $1`, id, at); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO outbox (kind, payload) VALUES ($1, $2)`, kind, payload); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ClaimOutbox takes up to limit due entries, counts the attempt and hides
+// them from other dispatchers for lease. SKIP LOCKED lets replicas claim
+// disjoint batches without waiting on each other.
+func (s *PostgresStore) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEntry, error) {
+	ctx, call := s.begin(ctx, "ClaimOutbox")
+	defer call.end()
+	rows, err := s.db.QueryContext(ctx,
+		`UPDATE outbox SET attempts = attempts + 1, next_attempt_at = NOW() + $2::double precision * INTERVAL '1 second'
+		 WHERE id IN (
+			SELECT id FROM outbox WHERE next_attempt_at <= NOW()
+			ORDER BY next_attempt_at, id LIMIT $1 FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+outboxColumns,
+		limit, lease.Seconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.OutboxEntry, 0, limit)
+	for rows.Next() {
+		e, err := scanOutboxEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, e)
+	}
+	call.rows = int64(len(items))
+	return items, rows.Err()
+}
+
+func (s *PostgresStore) CompleteOutbox(ctx context.Context, id int64) error {
+	ctx, call := s.begin(ctx, "CompleteOutbox")
+	defer call.end()
+	_, err := s.db.ExecContext(ctx, `DELETE FROM outbox WHERE id = $1`, id)
+	return err
+}
+
+func (s *PostgresStore) RetryOutbox(ctx context.Context, id int64, next time.Time, lastErr string) error {
+	ctx, call := s.begin(ctx, "RetryOutbox")
+	defer call.end()
+	_, err := s.db.ExecContext(ctx, `UPDATE outbox SET next_attempt_at = $2, last_error = $3 WHERE id = $1`, id, next, lastErr)
+	return err
+}
+
+// DeadLetterOutbox moves an entry from the outbox to dead_letters.
+func (s *PostgresStore) DeadLetterOutbox(ctx context.Context, id int64, lastErr string) error {
+	ctx, call := s.begin(ctx, "DeadLetterOutbox")
+	defer call.end()
+	_, err := s.db.ExecContext(ctx,
+		`WITH moved AS (DELETE FROM outbox WHERE id = $1 RETURNING kind, payload, attempts, created_at)
+		 INSERT INTO dead_letters (kind, payload, attempts, last_error, created_at)
+		 SELECT kind, payload, attempts, $2, created_at FROM moved`,
+		id, lastErr,
+	)
+	return err
+}
+
+const deadLetterColumns = `id, kind, payload, attempts, last_error, created_at, failed_at`
+
+// ListDeadLetters returns the newest dead letters first.
+func (s *PostgresStore) ListDeadLetters(ctx context.Context, limit int) ([]models.DeadLetter, error) {
+	ctx, call := s.begin(ctx, "ListDeadLetters")
+	defer call.end()
+	if limit <= 0 {
+		limit = 50
+	}
+	limit = min(limit, 500)
+	rows, err := s.db.QueryContext(ctx, `SELECT `+deadLetterColumns+` FROM dead_letters ORDER BY failed_at DESC, id DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.DeadLetter, 0)
+	for rows.Next() {
+		var d models.DeadLetter
+		var payload []byte
+		if err := rows.Scan(&d.ID, &d.Kind, &payload, &d.Attempts, &d.LastError, &d.CreatedAt, &d.FailedAt); err != nil {
+			return nil, err
+		}
+		d.Payload = json.RawMessage(payload)
+		items = append(items, d)
+	}
+	call.rows = int64(len(items))
+	return items, rows.Err()
+}
+
+// RequeueDeadLetter moves a dead letter back to the outbox with a fresh
+// attempt count. It returns sql.ErrNoRows when there is no such dead letter.
+func (s *PostgresStore) RequeueDeadLetter(ctx context.Context, id int64) (models.OutboxEntry, error) {
+	ctx, call := s.begin(ctx, "RequeueDeadLetter")
+	defer call.end()
+	return scanOutboxEntry(s.db.QueryRowContext(ctx,
+		`WITH moved AS (DELETE FROM dead_letters WHERE id = $1 RETURNING kind, payload)
+		 INSERT INTO outbox (kind, payload) SELECT kind, payload FROM moved
+		 RETURNING `+outboxColumns,
+		id,
+	))
+}
+
+const chatJobColumns = `id, owner_key, role, conversation_id, status, request, response, error_code, error_message, attempts, created_at, started_at, finished_at`
+
+func scanChatJob(sc interface{ Scan(...any) error }) (models.ChatJob, error) {
+	var j models.ChatJob
+	var request, response []byte
+	var started, finished sql.NullTime
+	if err := sc.Scan(&j.ID, &j.OwnerKey, &j.Role, &j.ConversationID, &j.Status, &request, &response, &j.Error, &j.Message, &j.Attempts, &j.CreatedAt, &started, &finished); err != nil {
+		return models.ChatJob{}, err
+	}
+	if err := json.Unmarshal(request, &j.Request); err != nil {
+		return models.ChatJob{}, err
+	}
+	if len(response) > 0 {
+		var resp models.ChatResponse
+		if err := json.Unmarshal(response, &resp); err != nil {
+			return models.ChatJob{}, err
+		}
+		j.Response = &resp
+	}
+	if started.Valid {
+		t := started.Time
+		j.StartedAt = &t
+	}
+	if finished.Valid {
+		t := finished.Time
+		j.FinishedAt = &t
+	}
+	return j, nil
+}
+
+// EnqueueChatJob queues job unless its owner already has maxUnfinished jobs
+// queued or running, in which case it reports false. The owner's advisory
+// lock makes the count and the insert atomic across replicas.
+func (s *PostgresStore) EnqueueChatJob(ctx context.Context, job models.ChatJob, maxUnfinished int) (models.ChatJob, bool, error) {
+	ctx, call := s.begin(ctx, "EnqueueChatJob")
+	defer call.end()
+	request, err := json.Marshal(job.Request)
+	if err != nil {
+		return models.ChatJob{}, false, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.ChatJob{}, false, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('chat_jobs:' || $1))`, job.OwnerKey); err != nil {
+		return models.ChatJob{}, false, err
+	}
+	var unfinished int
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM chat_jobs WHERE owner_key = $1 AND status IN ('queued', 'running')`,
+		job.OwnerKey,
+	).Scan(&unfinished); err != nil {
+		return models.ChatJob{}, false, err
+	}
+	if maxUnfinished > 0 && unfinished >= maxUnfinished {
+		return models.ChatJob{}, false, nil
+	}
+	created, err := scanChatJob(tx.QueryRowContext(ctx,
+		`INSERT INTO chat_jobs (id, owner_key, role, conversation_id, status, request)
+		 VALUES ($1, $2, $3, $4, 'queued', $5)
+		 RETURNING `+chatJobColumns,
+		job.ID, job.OwnerKey, job.Role, job.ConversationID, request,
+	))
+	if err != nil {
+		return models.ChatJob{}, false, err
+	}
+	return created, true, tx.Commit()
+}
+
+// ClaimChatJob marks the oldest runnable job running and leases it. A job
+// is runnable when it is queued, or running with an expired lease (its
+// worker died), and no earlier unfinished job or live run holds the same
+// conversation, so a conversation's jobs run one at a time and in order.
+// It returns sql.ErrNoRows when nothing is runnable.
+func (s *PostgresStore) ClaimChatJob(ctx context.Context, lease time.Duration) (models.ChatJob, error) {
+	ctx, call := s.begin(ctx, "ClaimChatJob")
+	defer call.end()
+	return scanChatJob(s.db.QueryRowContext(ctx,
+		`UPDATE chat_jobs SET status = 'running', attempts = attempts + 1, started_at = NOW(),
+			lease_until = NOW() + $1::double precision * INTERVAL '1 second'
+		 WHERE id = (
+			SELECT j.id FROM chat_jobs j
+			WHERE (j.status = 'queued' OR (j.status = 'running' AND j.lease_until < NOW()))
+			  AND NOT EXISTS (
+				SELECT 1 FROM chat_jobs p
+				WHERE j.conversation_id <> '' AND p.conversation_id = j.conversation_id AND p.id <> j.id
+				  AND ((p.status = 'running' AND p.lease_until >= NOW())
+				    OR (p.status IN ('queued', 'running') AND (p.created_at, p.id) < (j.created_at, j.id)))
+			  )
+			ORDER BY j.created_at, j.id LIMIT 1 FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+chatJobColumns,
+		lease.Seconds(),
+	))
+}
+
+// FinishChatJob records a job's outcome. A non-empty kind also queues an
+// outbox entry in the same transaction, so the job's webhook goes out
+// exactly when its result is stored.
+func (s *PostgresStore) FinishChatJob(ctx context.Context, job models.ChatJob, kind string, payload []byte) error {
+	ctx, call := s.begin(ctx, "FinishChatJob")
+	defer call.end()
+	var response []byte
+	if job.Response != nil {
+		b, err := json.Marshal(job.Response)
+		if err != nil {
+			return err
+		}
+		response = b
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE chat_jobs SET status = $2, response = $3, error_code = $4, error_message = $5, finished_at = NOW(), lease_until = NULL WHERE id = $1`,
+		job.ID, job.Status, response, job.Error, job.Message,
+	); err != nil {
+		return err
+	}
+	if kind != "" {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO outbox (kind, payload) VALUES ($1, $2)`, kind, payload); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetChatJob returns one of ownerKey's jobs; sql.ErrNoRows when it does not
+// exist or belongs to another owner.
+func (s *PostgresStore) GetChatJob(ctx context.Context, ownerKey, id string) (models.ChatJob, error) {
+	ctx, call := s.begin(ctx, "GetChatJob")
+	defer call.end()
+	return scanChatJob(s.db.QueryRowContext(ctx,
+		`SELECT `+chatJobColumns+` FROM chat_jobs WHERE id = $1 AND owner_key = $2`,
+		id, ownerKey,
+	))
+}
+
+// DeleteChatJobsBefore deletes jobs that finished before cutoff.
+func (s *PostgresStore) DeleteChatJobsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, call := s.begin(ctx, "DeleteChatJobsBefore")
+	defer call.end()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM chat_jobs WHERE finished_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	call.rows = n
+	return n, err
+}
+
+const extractColumns = `id, owner_key, subject, filter, row_estimate, created_at, expires_at`
+
+func scanExtract(sc interface{ Scan(...any) error }) (models.Extract, error) {
+	var e models.Extract
+	err := sc.Scan(&e.ID, &e.OwnerKey, &e.Subject, &e.Filter, &e.RowEstimate, &e.CreatedAt, &e.ExpiresAt)
+	return e, err
+}
+
+func (s *PostgresStore) CreateExtract(ctx context.Context, e models.Extract) (models.Extract, error) {
+	ctx, call := s.begin(ctx, "CreateExtract")
+	defer call.end()
+	return scanExtract(s.db.QueryRowContext(ctx,
+		`INSERT INTO extracts (id, owner_key, subject, filter, row_estimate, expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING `+extractColumns,
+		e.ID, e.OwnerKey, e.Subject, e.Filter, e.RowEstimate, e.ExpiresAt,
+	))
+}
+
+// GetExtract returns an extract whether or not it has expired; callers
+// check ExpiresAt and the owner.
+func (s *PostgresStore) GetExtract(ctx context.Context, id string) (models.Extract, error) {
+	ctx, call := s.begin(ctx, "GetExtract")
+	defer call.end()
+	return scanExtract(s.db.QueryRowContext(ctx, `SELECT `+extractColumns+` FROM extracts WHERE id = $1`, id))
+}
+
+func (s *PostgresStore) DeleteExpiredExtracts(ctx context.Context, now time.Time) (int64, error) {
+	ctx, call := s.begin(ctx, "DeleteExpiredExtracts")
+	defer call.end()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM extracts WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	call.rows = n
+	return n, nil
+}
+
+const entityAliasColumns = `owner_key, alias, kind, target, created_at`
+
+// SetEntityAlias creates the owner's alias, or repoints an existing one.
+func (s *PostgresStore) SetEntityAlias(ctx context.Context, a models.EntityAlias) (models.EntityAlias, error) {
+	ctx, call := s.begin(ctx, "SetEntityAlias")
+	defer call.end()
+	var out models.EntityAlias
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO entity_aliases (owner_key, alias, kind, target) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (owner_key, alias) DO UPDATE SET kind = EXCLUDED.kind, target = EXCLUDED.target, created_at = NOW()
+		 RETURNING `+entityAliasColumns,
+		a.OwnerKey, a.Alias, a.Kind, a.Target,
+	).Scan(&out.OwnerKey, &out.Alias, &out.Kind, &out.Target, &out.CreatedAt)
+	return out, err
+}
+
+// ListEntityAliases returns the owner's aliases in alphabetical order.
+func (s *PostgresStore) ListEntityAliases(ctx context.Context, ownerKey string) ([]models.EntityAlias, error) {
+	ctx, call := s.begin(ctx, "ListEntityAliases")
+	defer call.end()
+	rows, err := s.db.QueryContext(ctx, `SELECT `+entityAliasColumns+` FROM entity_aliases WHERE owner_key = $1 ORDER BY alias`, ownerKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.EntityAlias, 0)
+	for rows.Next() {
+		var a models.EntityAlias
+		if err := rows.Scan(&a.OwnerKey, &a.Alias, &a.Kind, &a.Target, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, a)
+	}
+	call.rows = int64(len(items))
+	return items, rows.Err()
+}
+
+// DeleteEntityAlias deletes the owner's alias; sql.ErrNoRows when there is
+// none.
+func (s *PostgresStore) DeleteEntityAlias(ctx context.Context, ownerKey, alias string) error {
+	ctx, call := s.begin(ctx, "DeleteEntityAlias")
+	defer call.end()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM entity_aliases WHERE owner_key = $1 AND alias = $2`, ownerKey, alias)
+	if err != nil {
+		return err
+	}
+	aff, _ := res.RowsAffected()
+	call.rows = aff
+	if aff == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const telemetryThresholdColumns = `owner_key, metric, warning, critical, updated_at`
+
+// SetTelemetryThreshold creates or replaces the owner's override of one
+// metric's tiers.
+func (s *PostgresStore) SetTelemetryThreshold(ctx context.Context, t models.TelemetryThreshold) (models.TelemetryThreshold, error) {
+	ctx, call := s.begin(ctx, "SetTelemetryThreshold")
+	defer call.end()
+	var out models.TelemetryThreshold
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO telemetry_thresholds (owner_key, metric, warning, critical) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (owner_key, metric) DO UPDATE SET warning = EXCLUDED.warning, critical = EXCLUDED.critical, updated_at = NOW()
+		 RETURNING `+telemetryThresholdColumns,
+		t.OwnerKey, t.Metric, t.Warning, t.Critical,
+	).Scan(&out.OwnerKey, &out.Metric, &out.Warning, &out.Critical, &out.UpdatedAt)
+	return out, err
+}
+
+// ListTelemetryThresholds returns the owner's overrides ordered by metric.
+func (s *PostgresStore) ListTelemetryThresholds(ctx context.Context, ownerKey string) ([]models.TelemetryThreshold, error) {
+	ctx, call := s.begin(ctx, "ListTelemetryThresholds")
+	defer call.end()
+	rows, err := s.db.QueryContext(ctx, `SELECT `+telemetryThresholdColumns+` FROM telemetry_thresholds WHERE owner_key = $1 ORDER BY metric`, ownerKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.TelemetryThreshold, 0)
+	for rows.Next() {
+		var t models.TelemetryThreshold
+		if err := rows.Scan(&t.OwnerKey, &t.Metric, &t.Warning, &t.Critical, &t.UpdatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, t)
+	}
+	call.rows = int64(len(items))
+	return items, rows.Err()
+}
+
+// DeleteTelemetryThresholds deletes the owner's override of metric, or all
+// of the owner's overrides when metric is empty, and returns how many were
+// deleted.
+func (s *PostgresStore) DeleteTelemetryThresholds(ctx context.Context, ownerKey, metric string) (int64, error) {
+	ctx, call := s.begin(ctx, "DeleteTelemetryThresholds")
+	defer call.end()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM telemetry_thresholds WHERE owner_key = $1 AND ($2 = '' OR metric = $2)`, ownerKey, metric)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	call.rows = n
+	return n, nil
+}
+
+func (s *PostgresStore) RecordUsage(ctx context.Context, e models.UsageEvent) error {
+	ctx, call := s.begin(ctx, "RecordUsage")
+	defer call.end()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO chat_usage (owner_hash, handler, duration_ms, is_error, is_clarification, message, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		e.OwnerHash, e.Handler, e.DurationMs, e.IsError, e.IsClarification, e.Message, e.CreatedAt,
+	)
+	return err
+}
+
+// usageByHandlerSQL aggregates chat_usage per handler over [$1, $2).
+const usageByHandlerSQL = `SELECT handler,
+	COUNT(*),
+	COUNT(*) FILTER (WHERE is_clarification),
+	COUNT(*) FILTER (WHERE is_error),
+	percentile_cont(0.5) WITHIN GROUP (ORDER BY duration_ms),
+	percentile_cont(0.95) WITHIN GROUP (ORDER BY duration_ms)
+	FROM chat_usage
+	WHERE created_at >= $1 AND created_at < $2
+	GROUP BY handler
+	ORDER BY COUNT(*) DESC, handler`
+
+// UsageSummary aggregates usage between from and to, with the newest
+// recentLLM messages that fell through to the model.
+func (s *PostgresStore) UsageSummary(ctx context.Context, from, to time.Time, recentLLM int) (models.UsageSummary, error) {
+	ctx, call := s.begin(ctx, "UsageSummary")
+	defer call.end()
+	out := models.UsageSummary{From: from, To: to, Handlers: make([]models.HandlerUsage, 0), RecentLLM: make([]models.UnansweredQuestion, 0)}
+	rows, err := s.db.QueryContext(ctx, usageByHandlerSQL, from, to)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+	var clarifications, errs int64
+	for rows.Next() {
+		var h models.HandlerUsage
+		if err := rows.Scan(&h.Handler, &h.Requests, &h.Clarifications, &h.Errors, &h.P50Ms, &h.P95Ms); err != nil {
+			return out, err
+		}
+		out.Requests += h.Requests
+		clarifications += h.Clarifications
+		errs += h.Errors
+		out.Handlers = append(out.Handlers, h)
+	}
+	if err := rows.Err(); err != nil {
+		return out, err
+	}
+	if out.Requests > 0 {
+		out.ClarificationRate = float64(clarifications) / float64(out.Requests)
+		out.ErrorRate = float64(errs) / float64(out.Requests)
+	}
+
+	recent, err := s.db.QueryContext(ctx,
+		`SELECT message, owner_hash, created_at FROM chat_usage
+		 WHERE handler = 'llm' AND created_at >= $1 AND created_at < $2
+		 ORDER BY created_at DESC, id DESC LIMIT $3`,
+		from, to, recentLLM,
+	)
+	if err != nil {
+		return out, err
+	}
+	defer recent.Close()
+	for recent.Next() {
+		var q models.UnansweredQuestion
+		if err := recent.Scan(&q.Message, &q.OwnerHash, &q.CreatedAt); err != nil {
+			return out, err
+		}
+		out.RecentLLM = append(out.RecentLLM, q)
+	}
+	call.rows = out.Requests
+	return out, recent.Err()
+}
+
+// DeleteUsageBefore removes usage events recorded before cutoff.
+func (s *PostgresStore) DeleteUsageBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, call := s.begin(ctx, "DeleteUsageBefore")
+	defer call.end()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM chat_usage WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	call.rows = n
+	return n, err
+}
+
+// RecordFeedback rates an assistant message of the owner's conversation,
+// replacing an earlier rating of the same message; an empty comment keeps the
+// earlier one. It returns sql.ErrNoRows if there is no such message.
+func (s *PostgresStore) RecordFeedback(ctx context.Context, f models.Feedback) error {
+	ctx, call := s.begin(ctx, "RecordFeedback")
+	defer call.end()
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO chat_feedback (owner_key, conversation_id, message_id, handler, rating, comment)
+		 SELECT m.owner_key, m.conversation_id, m.id, m.handler, $4, $5
+		 FROM chat_messages m
+		 WHERE m.id = $3 AND m.owner_key = $1 AND m.conversation_id = $2 AND m.role = 'assistant'
+		 ON CONFLICT (owner_key, message_id) DO UPDATE SET
+			rating = EXCLUDED.rating,
+			comment = CASE WHEN EXCLUDED.comment <> '' THEN EXCLUDED.comment ELSE chat_feedback.comment END,
+			created_at = NOW()`,
+		f.OwnerKey, f.ConversationID, f.MessageID, f.Rating, f.Comment,
+	)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	call.rows = n
+	return nil
+}
+
+// feedbackByHandlerSQL counts the assistant messages written in [$1, $2) and
+// their ratings per handler.
+const feedbackByHandlerSQL = `SELECT m.handler,
+	COUNT(*),
+	COUNT(f.id) FILTER (WHERE f.rating = 'up'),
+	COUNT(f.id) FILTER (WHERE f.rating = 'down')
+	FROM chat_messages m
+	LEFT JOIN chat_feedback f ON f.message_id = m.id
+	WHERE m.role = 'assistant' AND m.created_at >= $1 AND m.created_at < $2
+	GROUP BY m.handler
+	ORDER BY COUNT(f.id) FILTER (WHERE f.rating = 'down') DESC, COUNT(*) DESC, m.handler`
+
+// FeedbackSummary aggregates ratings of the answers given between from and
+// to, with the newest recentDown down-votes.
+func (s *PostgresStore) FeedbackSummary(ctx context.Context, from, to time.Time, recentDown int) (models.FeedbackSummary, error) {
+	ctx, call := s.begin(ctx, "FeedbackSummary")
+	defer call.end()
+	out := models.FeedbackSummary{From: from, To: to, Handlers: make([]models.HandlerFeedback, 0), RecentDown: make([]models.FeedbackComment, 0)}
+	rows, err := s.db.QueryContext(ctx, feedbackByHandlerSQL, from, to)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var h models.HandlerFeedback
+		if err := rows.Scan(&h.Handler, &h.Answers, &h.Up, &h.Down); err != nil {
+			return out, err
+		}
+		if h.Handler == "" {
+			h.Handler = "unrecorded"
+		}
+		if h.Answers > 0 {
+			h.DownRate = float64(h.Down) / float64(h.Answers)
+		}
+		out.Answers += h.Answers
+		out.Up += h.Up
+		out.Down += h.Down
+		out.Handlers = append(out.Handlers, h)
+	}
+	if err := rows.Err(); err != nil {
+		return out, err
+	}
+	if out.Answers > 0 {
+		out.DownRate = float64(out.Down) / float64(out.Answers)
+	}
+
+	recent, err := s.db.QueryContext(ctx,
+		`SELECT f.message_id, f.handler, LEFT(m.content, 300), f.comment, f.created_at
+		 FROM chat_feedback f
+		 JOIN chat_messages m ON m.id = f.message_id
+		 WHERE f.rating = 'down' AND m.created_at >= $1 AND m.created_at < $2
+		 ORDER BY f.created_at DESC, f.id DESC LIMIT $3`,
+		from, to, recentDown,
+	)
+	if err != nil {
+		return out, err
+	}
+	defer recent.Close()
+	for recent.Next() {
+		var c models.FeedbackComment
+		if err := recent.Scan(&c.MessageID, &c.Handler, &c.Answer, &c.Comment, &c.CreatedAt); err != nil {
+			return out, err
+		}
+		if c.Handler == "" {
+			c.Handler = "unrecorded"
+		}
+		out.RecentDown = append(out.RecentDown, c)
+	}
+	call.rows = out.Answers
+	return out, recent.Err()
+}
+
+// LatestAnswerSnapshot returns the owner's last stored result for a
+// normalized question; found is false when there is none.
+func (s *PostgresStore) LatestAnswerSnapshot(ctx context.Context, ownerKey, question string) (models.AnswerSnapshot, bool, error) {
+	ctx, call := s.begin(ctx, "LatestAnswerSnapshot")
+	defer call.end()
+	snap := models.AnswerSnapshot{OwnerKey: ownerKey, Question: question}
+	var raw []byte
+	err := s.db.QueryRowContext(ctx,
+		`SELECT kind, rows, created_at FROM answer_snapshots WHERE owner_key = $1 AND question = $2`,
+		ownerKey, question,
+	).Scan(&snap.Kind, &raw, &snap.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.AnswerSnapshot{}, false, nil
+	}
+	if err != nil {
+		return models.AnswerSnapshot{}, false, err
+	}
+	if err := json.Unmarshal(raw, &snap.Rows); err != nil {
+		return models.AnswerSnapshot{}, false, err
+	}
+	call.rows = 1
+	return snap, true, nil
+}
+
+// PutAnswerSnapshot replaces the owner's stored result for a question.
+func (s *PostgresStore) PutAnswerSnapshot(ctx context.Context, snap models.AnswerSnapshot) error {
+	ctx, call := s.begin(ctx, "PutAnswerSnapshot")
+	defer call.end()
+	rows := snap.Rows
+	if rows == nil {
+		rows = []models.AnswerRow{}
+	}
+	raw, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO answer_snapshots (owner_key, question, kind, rows)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (owner_key, question) DO UPDATE
+		 SET kind = EXCLUDED.kind, rows = EXCLUDED.rows, created_at = NOW()`,
+		snap.OwnerKey, snap.Question, snap.Kind, raw,
+	)
+	return err
+}
+
+// LatestCampaignDeviceSnapshot returns the stored device assignment of a
+// campaign; found is false when none was recorded yet.
+func (s *PostgresStore) LatestCampaignDeviceSnapshot(ctx context.Context, campaignID string) (models.CampaignDeviceSnapshot, bool, error) {
+	ctx, call := s.begin(ctx, "LatestCampaignDeviceSnapshot")
+	defer call.end()
+	snap := models.CampaignDeviceSnapshot{CampaignID: campaignID}
+	var hosts []string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT hosts, taken_at FROM campaign_device_snapshots WHERE campaign_id = $1`,
+		campaignID,
+	).Scan(pq.Array(&hosts), &snap.TakenAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.CampaignDeviceSnapshot{}, false, nil
+	}
+	if err != nil {
+		return models.CampaignDeviceSnapshot{}, false, err
+	}
+	snap.Hosts = hosts
+	call.rows = 1
+	return snap, true, nil
+}
+
+// PutCampaignDeviceSnapshot replaces the stored device assignment of a
+// campaign.
+func (s *PostgresStore) PutCampaignDeviceSnapshot(ctx context.Context, snap models.CampaignDeviceSnapshot) error {
+	ctx, call := s.begin(ctx, "PutCampaignDeviceSnapshot")
+	defer call.end()
+	hosts := snap.Hosts
+	if hosts == nil {
+		hosts = []string{}
+	}
+	takenAt := snap.TakenAt
+	if takenAt.IsZero() {
+		takenAt = time.Now()
+	}
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO campaign_device_snapshots (campaign_id, hosts, taken_at)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (campaign_id) DO UPDATE
+		 SET hosts = EXCLUDED.hosts, taken_at = EXCLUDED.taken_at`,
+		snap.CampaignID, pq.Array(hosts), takenAt,
+	)
+	return err
+}
+
+// ReserveIdempotencyKey claims r's key for r.Token until r.ExpiresAt. A key
+// that is held or completed and not expired is left alone and returned with
+// reserved false; an expired one is taken over.
+func (s *PostgresStore) ReserveIdempotencyKey(ctx context.Context, r models.IdempotencyRecord) (models.IdempotencyRecord, bool, error) {
+	ctx, call := s.begin(ctx, "ReserveIdempotencyKey")
+	defer call.end()
+	var token string
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO idempotency_keys (owner_key, key, request_hash, token, expires_at)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (owner_key, key) DO UPDATE
+		 SET request_hash = EXCLUDED.request_hash, token = EXCLUDED.token, response = NULL,
+		     created_at = NOW(), expires_at = EXCLUDED.expires_at
+		 WHERE idempotency_keys.expires_at <= NOW()
+		 RETURNING token`,
+		r.OwnerKey, r.Key, r.RequestHash, r.Token, r.ExpiresAt,
+	).Scan(&token)
+	if err == nil {
+		call.rows = 1
+		return r, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return models.IdempotencyRecord{}, false, err
+	}
+	out := models.IdempotencyRecord{OwnerKey: r.OwnerKey, Key: r.Key}
+	var raw []byte
+	err = s.db.QueryRowContext(ctx,
+		`SELECT request_hash, token, response, expires_at FROM idempotency_keys WHERE owner_key = $1 AND key = $2`,
+		r.OwnerKey, r.Key,
+	).Scan(&out.RequestHash, &out.Token, &raw, &out.ExpiresAt)
+	if err != nil {
+		return models.IdempotencyRecord{}, false, err
+	}
+	if raw != nil {
+		var resp models.ChatResponse
+		if err := json.Unmarshal(raw, &resp); err != nil {
+			return models.IdempotencyRecord{}, false, err
+		}
+		out.Response = &resp
+	}
+	call.rows = 1
+	return out, false, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request holding token
+// and keeps it until expiresAt.
+func (s *PostgresStore) CompleteIdempotencyKey(ctx context.Context, ownerKey, key, token string, resp models.ChatResponse, expiresAt time.Time) error {
+	ctx, call := s.begin(ctx, "CompleteIdempotencyKey")
+	defer call.end()
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE idempotency_keys SET response = $4, expires_at = $5 WHERE owner_key = $1 AND key = $2 AND token = $3`,
+		ownerKey, key, token, raw, expiresAt,
+	)
+	if err != nil {
+		return err
+	}
+	call.rows, _ = res.RowsAffected()
+	return nil
+}
+
+// ReleaseIdempotencyKey drops a claim whose request failed, so a retry runs
+// again.
+func (s *PostgresStore) ReleaseIdempotencyKey(ctx context.Context, ownerKey, key, token string) error {
+	ctx, call := s.begin(ctx, "ReleaseIdempotencyKey")
+	defer call.end()
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE owner_key = $1 AND key = $2 AND token = $3 AND response IS NULL`,
+		ownerKey, key, token,
+	)
+	if err != nil {
+		return err
+	}
+	call.rows, _ = res.RowsAffected()
+	return nil
+}
+
+// IdempotencyKeyHeld reports whether token still holds an unexpired,
+// uncompleted claim on the key.
+func (s *PostgresStore) IdempotencyKeyHeld(ctx context.Context, ownerKey, key, token string) (bool, error) {
+	ctx, call := s.begin(ctx, "IdempotencyKeyHeld")
+	defer call.end()
+	var one int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT 1 FROM idempotency_keys
+		 WHERE owner_key = $1 AND key = $2 AND token = $3 AND response IS NULL AND expires_at > NOW()`,
+		ownerKey, key, token,
+	).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	call.rows = 1
+	return true, nil
+}
+
+// DeleteExpiredIdempotencyKeys removes keys whose retention ended before now.
+func (s *PostgresStore) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	ctx, call := s.begin(ctx, "DeleteExpiredIdempotencyKeys")
+	defer call.end()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	call.rows = n
+	return n, err
+}
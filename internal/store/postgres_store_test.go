@@ -0,0 +1,431 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// testStore is a PostgresStore over a fresh, migrated schema of
+// TEST_DATABASE_URL; the test is skipped when the variable is unset.
+func testStore(t *testing.T) *PostgresStore {
+	t.Helper()
+	s := NewPostgresStore(testDB(t))
+	if err := s.EnsureSchema(context.Background()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return s
+}
+
+func TestPlayTargets(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+
+	created := map[string]models.PlayTarget{}
+	for name, tgt := range map[string]models.PlayTarget{
+		"hosts":  {OwnerKey: "owner-a", CampaignID: "camp-1", ScopeType: "hosts", Hosts: []string{"moco-brt-1", "moco-brt-2"}, TargetPlays: 300, Period: "2024-10"},
+		"region": {OwnerKey: "owner-a", PosterID: "poster-1", ScopeType: "region", ScopeValue: "brt", TargetPlays: 50, Period: "2024-10"},
+		"later":  {OwnerKey: "owner-a", CampaignID: "camp-1", ScopeType: "city", ScopeValue: "moco", TargetPlays: 10, Period: "2024-11"},
+		"other":  {OwnerKey: "owner-b", CampaignID: "camp-1", ScopeType: "city", ScopeValue: "moco", TargetPlays: 10, Period: "2024-10"},
+	} {
+		got, err := s.CreatePlayTarget(ctx, tgt)
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		if got.ID == 0 || got.CreatedAt.IsZero() || got.ScopeType != tgt.ScopeType || len(got.Hosts) != len(tgt.Hosts) {
+			t.Errorf("create %s returned %+v", name, got)
+		}
+		created[name] = got
+	}
+
+	cases := []struct {
+		owner, period string
+		want          []string
+	}{
+		{"owner-a", "2024-10", []string{"hosts", "region"}},
+		{"owner-a", "2024-11", []string{"later"}},
+		{"owner-a", "", []string{"hosts", "region", "later"}},
+		{"owner-b", "2024-10", []string{"other"}},
+		{"owner-c", "", nil},
+	}
+	for _, tc := range cases {
+		got, err := s.ListPlayTargets(ctx, tc.owner, tc.period)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := map[int64]bool{}
+		for _, g := range got {
+			ids[g.ID] = true
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("ListPlayTargets(%s, %q) = %d targets, want %v", tc.owner, tc.period, len(got), tc.want)
+			continue
+		}
+		for _, name := range tc.want {
+			if !ids[created[name].ID] {
+				t.Errorf("ListPlayTargets(%s, %q) lacks %s", tc.owner, tc.period, name)
+			}
+		}
+	}
+	if got, _ := s.ListPlayTargets(ctx, "owner-a", "2024-10"); len(got) > 0 && (got[0].Hosts[0] != "moco-brt-1" || got[1].Hosts != nil) {
+		t.Errorf("hosts did not round-trip: %+v", got)
+	}
+
+	if err := s.DeletePlayTarget(ctx, "owner-b", created["hosts"].ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleting another owner's target: %v, want sql.ErrNoRows", err)
+	}
+	if err := s.DeletePlayTarget(ctx, "owner-a", created["hosts"].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.ListPlayTargets(ctx, "owner-a", "2024-10"); len(got) != 1 {
+		t.Errorf("%d targets after the delete, want 1", len(got))
+	}
+}
+
+func TestDebugBundles(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for id, expires := range map[string]time.Time{"live": now.Add(time.Hour), "expired": now.Add(-time.Minute)} {
+		b := models.DebugBundle{ID: id, OwnerKey: "owner-a", Message: "q", Calls: []models.DebugCall{{Method: "GET", Path: "/pop", Status: 200, Body: `{"items":[]}`}}, CreatedAt: now, ExpiresAt: expires}
+		if err := s.SaveDebugBundle(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := s.GetDebugBundle(ctx, "live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.OwnerKey != "owner-a" || len(b.Calls) != 1 || b.Calls[0].Body != `{"items":[]}` {
+		t.Errorf("bundle did not round-trip: %+v", b)
+	}
+	for _, id := range []string{"expired", "missing"} {
+		if _, err := s.GetDebugBundle(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetDebugBundle(%s) = %v, want sql.ErrNoRows", id, err)
+		}
+	}
+	if n, err := s.DeleteExpiredDebugBundles(ctx, now); err != nil || n != 1 {
+		t.Errorf("DeleteExpiredDebugBundles = %d, %v; want 1", n, err)
+	}
+	if _, err := s.GetDebugBundle(ctx, "live"); err != nil {
+		t.Errorf("live bundle gone after cleanup: %v", err)
+	}
+}
+
+func TestStoreCallDeadline(t *testing.T) {
+	cases := []struct {
+		name    string
+		timeout time.Duration
+		caller  time.Duration
+		want    time.Duration
+	}{
+		{name: "default", want: 3 * time.Second},
+		{name: "configured", timeout: time.Second, want: time.Second},
+		{name: "sooner caller deadline", timeout: time.Second, caller: 100 * time.Millisecond, want: 100 * time.Millisecond},
+		{name: "later caller deadline", timeout: time.Second, caller: time.Minute, want: time.Second},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &PostgresStore{QueryTimeout: tc.timeout}
+			ctx := context.Background()
+			if tc.caller > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.caller)
+				defer cancel()
+			}
+			start := time.Now()
+			ctx, call := s.begin(ctx, "Test")
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				t.Fatal("no deadline")
+			}
+			if got := deadline.Sub(start); got < tc.want-50*time.Millisecond || got > tc.want+50*time.Millisecond {
+				t.Errorf("deadline in %v, want %v", got, tc.want)
+			}
+			call.end()
+			if ctx.Err() == nil {
+				t.Error("end did not release the context")
+			}
+		})
+	}
+}
+
+func TestStoreSlowCallLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s := &PostgresStore{SlowQuery: 10 * time.Millisecond}
+	_, fast := s.begin(context.Background(), "Fast")
+	fast.end()
+	_, slow := s.begin(context.Background(), "ListMessages")
+	slow.rows = 7
+	time.Sleep(20 * time.Millisecond)
+	slow.end()
+
+	out := buf.String()
+	if strings.Contains(out, "method=Fast") {
+		t.Errorf("logged a fast call: %s", out)
+	}
+	if !strings.Contains(out, "store slow method=ListMessages") || !strings.Contains(out, "rows=7") {
+		t.Errorf("slow call log = %q, want the method and row count", out)
+	}
+}
+
+// The store's deadline cancels the query itself, not just the wait for it.
+func TestStoreQueryTimeout(t *testing.T) {
+	s := testStore(t)
+	s.QueryTimeout = 100 * time.Millisecond
+	ctx, call := s.begin(context.Background(), "Sleep")
+	start := time.Now()
+	_, err := s.db.ExecContext(ctx, `SELECT pg_sleep(5)`)
+	call.end()
+	if err == nil {
+		t.Fatal("pg_sleep(5) finished under a 100ms timeout")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("query ran %v past its deadline", d)
+	}
+	// The connection is usable afterwards: the server cancelled the query.
+	if _, err := s.ListMessages(context.Background(), "owner-a", "conv-1", 10); err != nil {
+		t.Errorf("store call after a cancelled query: %v", err)
+	}
+
+	var n int
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM pg_indexes WHERE schemaname = current_schema() AND indexname = 'chat_messages_owner_conversation_created_idx'`).Scan(&n)
+	if err != nil || n != 1 {
+		t.Errorf("owner/conversation/created_at index: %d, %v", n, err)
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	rec := func(token string, expires time.Duration) models.IdempotencyRecord {
+		return models.IdempotencyRecord{OwnerKey: "owner-a", Key: "k", RequestHash: "h", Token: token, ExpiresAt: time.Now().Add(expires)}
+	}
+
+	if _, ok, err := s.ReserveIdempotencyKey(ctx, rec("t1", time.Minute)); err != nil || !ok {
+		t.Fatalf("first reserve: %v, %v", ok, err)
+	}
+	held, ok, err := s.ReserveIdempotencyKey(ctx, rec("t2", time.Minute))
+	if err != nil || ok || held.Token != "t1" || held.Response != nil {
+		t.Fatalf("reserve of a running key = %+v, %v, %v; want t1's claim", held, ok, err)
+	}
+	if h, _ := s.IdempotencyKeyHeld(ctx, "owner-a", "k", "t2"); h {
+		t.Error("t2 holds a key it never reserved")
+	}
+	// Another owner's key of the same name is separate.
+	if _, ok, _ := s.ReserveIdempotencyKey(ctx, models.IdempotencyRecord{OwnerKey: "owner-b", Key: "k", RequestHash: "h", Token: "b", ExpiresAt: time.Now().Add(time.Minute)}); !ok {
+		t.Error("owner-b could not reserve its own key")
+	}
+
+	if err := s.CompleteIdempotencyKey(ctx, "owner-a", "k", "t1", models.ChatResponse{Answer: "42"}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// A completed key is not released by its failed token.
+	if err := s.ReleaseIdempotencyKey(ctx, "owner-a", "k", "t1"); err != nil {
+		t.Fatal(err)
+	}
+	held, ok, err = s.ReserveIdempotencyKey(ctx, rec("t3", time.Minute))
+	if err != nil || ok || held.Response == nil || held.Response.Answer != "42" {
+		t.Fatalf("reserve of a completed key = %+v, %v, %v; want the stored response", held, ok, err)
+	}
+
+	// Expired keys can be taken again, and cleanup removes them.
+	if _, err := s.db.Exec(`UPDATE idempotency_keys SET expires_at = NOW() - INTERVAL '1 second'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := s.ReserveIdempotencyKey(ctx, rec("t4", time.Minute)); err != nil || !ok {
+		t.Fatalf("reserve of an expired key: %v, %v", ok, err)
+	}
+	if h, _ := s.IdempotencyKeyHeld(ctx, "owner-a", "k", "t1"); h {
+		t.Error("t1 still holds the key after t4 took it over")
+	}
+	if n, err := s.DeleteExpiredIdempotencyKeys(ctx, time.Now()); err != nil || n != 1 {
+		t.Errorf("DeleteExpiredIdempotencyKeys = %d, %v; want owner-b's expired key", n, err)
+	}
+}
+
+func TestStepBodies(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for id, expires := range map[string]time.Time{"live": now.Add(time.Hour), "expired": now.Add(-time.Minute)} {
+		if err := s.SaveStepBody(ctx, models.StepBody{ID: id, OwnerKey: "owner-a", Tool: "GET /pop", Body: strings.Repeat("x", 5000), CreatedAt: now, ExpiresAt: expires}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := s.GetStepBody(ctx, "live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.OwnerKey != "owner-a" || b.Tool != "GET /pop" || len(b.Body) != 5000 {
+		t.Errorf("step body did not round-trip: owner=%q tool=%q len=%d", b.OwnerKey, b.Tool, len(b.Body))
+	}
+	if _, err := s.GetStepBody(ctx, "expired"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expired body: %v, want sql.ErrNoRows", err)
+	}
+	if n, err := s.DeleteExpiredStepBodies(ctx, now); err != nil || n != 1 {
+		t.Errorf("DeleteExpiredStepBodies = %d, %v; want 1", n, err)
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	for _, kind := range []string{"webhook", "usage"} {
+		if err := s.EnqueueOutbox(ctx, kind, []byte(`{"n":1}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A row locked by another replica is skipped, not waited for.
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	var locked int64
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM outbox WHERE kind = 'webhook' FOR UPDATE`).Scan(&locked); err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := s.ClaimOutbox(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].Kind != "usage" || claimed[0].Attempts != 1 {
+		t.Fatalf("claim beside a locked row = %+v, want the usage entry on its first attempt", claimed)
+	}
+	_ = tx.Rollback()
+
+	// The claimed entry is leased; only the webhook is due.
+	claimed, err = s.ClaimOutbox(ctx, 10, time.Minute)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != locked {
+		t.Fatalf("second claim = %+v, %v; want the webhook only", claimed, err)
+	}
+	if err := s.RetryOutbox(ctx, locked, time.Now().Add(-time.Second), "webhook status 500"); err != nil {
+		t.Fatal(err)
+	}
+	claimed, _ = s.ClaimOutbox(ctx, 10, time.Minute)
+	if len(claimed) != 1 || claimed[0].Attempts != 2 || claimed[0].LastError != "webhook status 500" {
+		t.Fatalf("claim after a retry = %+v, want attempt 2 with the last error", claimed)
+	}
+
+	if err := s.DeadLetterOutbox(ctx, locked, "webhook status 500"); err != nil {
+		t.Fatal(err)
+	}
+	letters, err := s.ListDeadLetters(ctx, 50)
+	if err != nil || len(letters) != 1 || letters[0].Kind != "webhook" || letters[0].Attempts != 2 || string(letters[0].Payload) != `{"n": 1}` {
+		t.Fatalf("dead letters = %+v, %v", letters, err)
+	}
+	e, err := s.RequeueDeadLetter(ctx, letters[0].ID)
+	if err != nil || e.Kind != "webhook" || e.Attempts != 0 {
+		t.Fatalf("re-queue = %+v, %v; want a fresh webhook entry", e, err)
+	}
+	if _, err := s.RequeueDeadLetter(ctx, letters[0].ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("second re-queue: %v, want sql.ErrNoRows", err)
+	}
+	if letters, _ := s.ListDeadLetters(ctx, 50); len(letters) != 0 {
+		t.Errorf("%d dead letters after the re-queue", len(letters))
+	}
+}
+
+func TestChatJobClaim(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	enqueue := func(id, conv string) {
+		t.Helper()
+		job := models.ChatJob{ID: id, OwnerKey: "owner-a", ConversationID: conv, Request: models.ChatRequest{ConversationID: conv, Message: id}}
+		if _, ok, err := s.EnqueueChatJob(ctx, job, 10); err != nil || !ok {
+			t.Fatalf("enqueue %s: %v, %v", id, ok, err)
+		}
+	}
+	claim := func(lease time.Duration) string {
+		t.Helper()
+		j, err := s.ClaimChatJob(ctx, lease)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ""
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return j.ID
+	}
+	finish := func(id string) {
+		t.Helper()
+		if err := s.FinishChatJob(ctx, models.ChatJob{ID: id, Status: models.ChatJobDone, Response: &models.ChatResponse{Answer: id}}, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enqueue("a1", "conv-a")
+	enqueue("a2", "conv-a")
+	enqueue("b1", "conv-b")
+	enqueue("c1", "")
+
+	// A row another replica has locked is skipped, not waited for.
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT id FROM chat_jobs WHERE id = 'a1' FOR UPDATE`); err != nil {
+		t.Fatal(err)
+	}
+	// a2 waits behind the locked but unfinished a1.
+	if got := claim(time.Minute); got != "b1" {
+		t.Fatalf("claim beside a locked job = %q, want b1", got)
+	}
+	_ = tx.Rollback()
+
+	// Conversation jobs run one at a time, in order.
+	for _, want := range []string{"a1", "c1", ""} {
+		if got := claim(time.Minute); got != want {
+			t.Fatalf("claim = %q, want %q", got, want)
+		}
+	}
+	finish("a1")
+	if got := claim(time.Minute); got != "a2" {
+		t.Fatalf("claim after a1 finished = %q, want a2", got)
+	}
+
+	// A run whose lease ended, its worker gone, is claimed again.
+	finish("a2")
+	finish("c1")
+	enqueue("a3", "conv-a")
+	if got := claim(-time.Second); got != "a3" {
+		t.Fatalf("claim = %q, want a3", got)
+	}
+	j, err := s.ClaimChatJob(ctx, time.Minute)
+	if err != nil || j.ID != "a3" || j.Attempts != 2 {
+		t.Fatalf("reclaim = %+v, %v; want a3 on its second attempt", j, err)
+	}
+
+	done, err := s.GetChatJob(ctx, "owner-a", "a1")
+	if err != nil || done.Status != models.ChatJobDone || done.Response == nil || done.Response.Answer != "a1" || done.FinishedAt == nil {
+		t.Errorf("GetChatJob = %+v, %v", done, err)
+	}
+	if _, err := s.GetChatJob(ctx, "owner-b", "a1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("another owner's job: %v, want sql.ErrNoRows", err)
+	}
+
+	// b1 and a3 are unfinished: a cap of 2 is reached, a cap of 3 is not.
+	if _, ok, _ := s.EnqueueChatJob(ctx, models.ChatJob{ID: "a4", OwnerKey: "owner-a"}, 2); ok {
+		t.Error("enqueued past the cap")
+	}
+	if _, ok, _ := s.EnqueueChatJob(ctx, models.ChatJob{ID: "a4", OwnerKey: "owner-a"}, 3); !ok {
+		t.Error("refused under the cap")
+	}
+	if n, err := s.DeleteChatJobsBefore(ctx, time.Now().Add(time.Minute)); err != nil || n != 3 {
+		t.Errorf("DeleteChatJobsBefore = %d, %v; want the 3 finished jobs", n, err)
+	}
+}
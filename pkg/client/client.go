@@ -0,0 +1,328 @@
+// Package client is a typed Go client for the agent service API. Its wire
+// types are the service's own models, so a field added to the API is
+// available here in the same change.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"openai-agent-service/internal/models"
+)
+
+// Wire types shared with the service.
+type (
+	ChatRequest     = models.ChatRequest
+	ChatResponse    = models.ChatResponse
+	ChatAttachment  = models.ChatAttachment
+	Conversation    = models.Conversation
+	Message         = models.Message
+	FeedbackRequest = models.FeedbackRequest
+)
+
+// Client calls the agent service. BaseURL is the service root (for example
+// "https://agent.example.com") and APIKey is sent as X-API-Key.
+type Client struct {
+	BaseURL string
+	APIKey  string
+	// HTTP defaults to http.DefaultClient. Its Timeout also cuts off
+	// streams, so prefer Timeout below for bounding calls.
+	HTTP *http.Client
+	// Timeout, when positive, bounds each call including a whole stream.
+	Timeout time.Duration
+}
+
+// New returns a client for baseURL authenticating with apiKey.
+func New(baseURL, apiKey string) *Client {
+	return &Client{BaseURL: baseURL, APIKey: apiKey}
+}
+
+// Error is a failure reported by the service: an error status with its
+// machine-readable code, an error event on a stream, or a chat answer that
+// carries a ResponseError.
+type Error struct {
+	// Status is the HTTP status; 200 for an answer that carries an error.
+	Status    int
+	Code      string
+	Message   string
+	Retryable bool
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = strings.ReplaceAll(e.Code, "_", " ")
+	}
+	if e.Status != 0 {
+		return fmt.Sprintf("agent service: %s (status %d): %s", e.Code, e.Status, msg)
+	}
+	return fmt.Sprintf("agent service: %s: %s", e.Code, msg)
+}
+
+// errorBody is the error body every endpoint writes.
+type errorBody struct {
+	Error     string `json:"error"`
+	Message   string `json:"message"`
+	Detail    string `json:"detail"`
+	Retryable bool   `json:"retryable"`
+	Status    int    `json:"status"`
+}
+
+func (b errorBody) toError(status int) *Error {
+	msg := b.Message
+	if msg == "" {
+		msg = b.Detail
+	}
+	if b.Status != 0 {
+		status = b.Status
+	}
+	return &Error{Status: status, Code: b.Error, Message: msg, Retryable: b.Retryable}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.Timeout > 0 {
+		return context.WithTimeout(ctx, c.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body any) (*http.Request, error) {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.BaseURL, "/")+path, rd)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-API-Key", c.APIKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// do sends a JSON request and decodes a 2xx body into out; other statuses
+// become an *Error.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	raw, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var eb errorBody
+		if json.Unmarshal(raw, &eb) != nil || eb.Error == "" {
+			return &Error{Status: res.StatusCode, Code: "http_error", Message: strings.TrimSpace(string(raw))}
+		}
+		return eb.toError(res.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("agent service: decode %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// answerError is the typed error of an answer that carries a ResponseError.
+func answerError(resp ChatResponse) error {
+	if resp.Error == nil {
+		return nil
+	}
+	return &Error{Status: http.StatusOK, Code: resp.Error.Code, Message: resp.Answer, Retryable: resp.Error.Retryable}
+}
+
+// Chat asks a question. When the answer carries a ResponseError (for
+// example gateway_unavailable noted under a partial answer) the response is
+// returned together with an *Error, so callers can still show the answer.
+func (c *Client) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	var resp ChatResponse
+	if err := c.do(ctx, http.MethodPost, "/chat", req, &resp); err != nil {
+		return ChatResponse{}, err
+	}
+	return resp, answerError(resp)
+}
+
+// Event is one server-sent event of a chat stream: a "token" with Text, or
+// the "final" event with the full Response.
+type Event struct {
+	Type     string
+	Text     string
+	Response *ChatResponse
+}
+
+// ChatStream asks a question over /chat/stream and calls fn for each token
+// and for the final answer. An error event ends the stream with an *Error;
+// heartbeats are skipped. fn runs on the calling goroutine.
+func (c *Client) ChatStream(ctx context.Context, req ChatRequest, fn func(Event)) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	hreq, err := c.newRequest(ctx, http.MethodPost, "/chat/stream", req)
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Accept", "text/event-stream")
+	res, err := c.httpClient().Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		raw, _ := io.ReadAll(res.Body)
+		var eb errorBody
+		if json.Unmarshal(raw, &eb) != nil || eb.Error == "" {
+			return &Error{Status: res.StatusCode, Code: "http_error", Message: strings.TrimSpace(string(raw))}
+		}
+		return eb.toError(res.StatusCode)
+	}
+
+	sc := bufio.NewScanner(res.Body)
+	// A final event carries the whole answer with its steps.
+	sc.Buffer(make([]byte, 0, 64<<10), 16<<20)
+	event := ""
+	var data []string
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if len(data) > 0 {
+				done, err := dispatchEvent(event, strings.Join(data, "\n"), fn)
+				if done || err != nil {
+					return err
+				}
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, ":"):
+			// Comment line (heartbeat).
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("agent service: stream ended without a final event")
+}
+
+// dispatchEvent handles one event; done is true after the final or an error
+// event.
+func dispatchEvent(event, data string, fn func(Event)) (bool, error) {
+	switch event {
+	case "token":
+		var tok struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal([]byte(data), &tok); err != nil {
+			return true, fmt.Errorf("agent service: decode token event: %w", err)
+		}
+		if fn != nil {
+			fn(Event{Type: event, Text: tok.Text})
+		}
+	case "final":
+		var resp ChatResponse
+		if err := json.Unmarshal([]byte(data), &resp); err != nil {
+			return true, fmt.Errorf("agent service: decode final event: %w", err)
+		}
+		if fn != nil {
+			fn(Event{Type: event, Response: &resp})
+		}
+		return true, answerError(resp)
+	case "error":
+		var eb errorBody
+		if err := json.Unmarshal([]byte(data), &eb); err != nil {
+			return true, fmt.Errorf("agent service: decode error event: %w", err)
+		}
+		return true, eb.toError(0)
+	}
+	return false, nil
+}
+
+// CreateConversation starts a conversation owned by the client's API key.
+func (c *Client) CreateConversation(ctx context.Context) (Conversation, error) {
+	var out struct {
+		Data Conversation `json:"data"`
+	}
+	err := c.do(ctx, http.MethodPost, "/conversations", nil, &out)
+	return out.Data, err
+}
+
+// ListConversations returns the newest conversations, at most limit (the
+// service default when limit is 0).
+func (c *Client) ListConversations(ctx context.Context, limit int) ([]Conversation, error) {
+	path := "/conversations"
+	if limit > 0 {
+		path += "?limit=" + strconv.Itoa(limit)
+	}
+	var out struct {
+		Data []Conversation `json:"data"`
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &out)
+	return out.Data, err
+}
+
+// GetConversation returns one conversation; a missing one is an *Error with
+// code not_found.
+func (c *Client) GetConversation(ctx context.Context, id string) (Conversation, error) {
+	var out struct {
+		Data Conversation `json:"data"`
+	}
+	err := c.do(ctx, http.MethodGet, "/conversations/"+url.PathEscape(id), nil, &out)
+	return out.Data, err
+}
+
+// ListMessages returns the last messages of a conversation, at most limit
+// (the service default when limit is 0).
+func (c *Client) ListMessages(ctx context.Context, conversationID string, limit int) ([]Message, error) {
+	path := "/conversations/" + url.PathEscape(conversationID) + "/messages"
+	if limit > 0 {
+		path += "?limit=" + strconv.Itoa(limit)
+	}
+	var out struct {
+		Data []Message `json:"data"`
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &out)
+	return out.Data, err
+}
+
+// SendFeedback rates an assistant message ("up" or "down"). The service
+// stores the rating in the background, so a nil error means it was accepted,
+// not that messageID was an assistant message of the conversation.
+func (c *Client) SendFeedback(ctx context.Context, conversationID string, messageID int64, req FeedbackRequest) error {
+	path := "/conversations/" + url.PathEscape(conversationID) + "/messages/" + strconv.FormatInt(messageID, 10) + "/feedback"
+	return c.do(ctx, http.MethodPost, path, req, nil)
+}
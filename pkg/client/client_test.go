@@ -0,0 +1,232 @@
+package client_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"openai-agent-service/internal/config"
+	"openai-agent-service/internal/handlers"
+	"openai-agent-service/internal/models"
+	"openai-agent-service/internal/routes"
+	"openai-agent-service/internal/services"
+	"openai-agent-service/internal/store"
+	"openai-agent-service/pkg/client"
+)
+
+const testKey = "client-test-key"
+
+// venuesGateway answers every tool gateway call with one venue, so "list
+// venues" is answered without the model.
+func venuesGateway(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":[{"id":12,"name":"Briggs Hall","city":"moco","region":"brt"}],"pagination":{"has_more":false,"total":1}}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newService serves the real router over chat, with conversations kept in
+// pg when it is non-nil.
+func newService(t *testing.T, chat *services.ChatService, pg *store.PostgresStore) *httptest.Server {
+	t.Helper()
+	cfg := config.Config{AgentAPIKeys: map[string]string{testKey: models.KeyRoleAdmin}}
+	if pg != nil {
+		chat.Store = pg
+	}
+	h := routes.NewRouter(cfg,
+		&handlers.ChatHandlers{Chat: chat},
+		&handlers.StreamHandlers{Chat: chat, Heartbeat: 5 * time.Millisecond},
+		&handlers.ConversationHandlers{Store: pg, Chat: chat},
+		&handlers.AdminHandlers{Chat: chat},
+		&handlers.AlertHandlers{}, &handlers.HealthHandlers{Chat: chat}, &handlers.TargetHandlers{},
+		&handlers.DocsHandlers{}, &handlers.SavedQueryHandlers{}, &handlers.QueryHandlers{Chat: chat})
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestChatRoundTrip(t *testing.T) {
+	srv := newService(t, &services.ChatService{Gateway: &services.GatewayClient{BaseURL: venuesGateway(t).URL}}, nil)
+	c := client.New(srv.URL+"/", testKey)
+	c.Timeout = 10 * time.Second
+
+	resp, err := c.Chat(context.Background(), client.ChatRequest{Message: "list venues"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(resp.Answer, "Briggs Hall (12)") {
+		t.Errorf("answer %q lacks the venue", resp.Answer)
+	}
+}
+
+func TestChatStreamRoundTrip(t *testing.T) {
+	srv := newService(t, &services.ChatService{Gateway: &services.GatewayClient{BaseURL: venuesGateway(t).URL}}, nil)
+	c := client.New(srv.URL, testKey)
+
+	var tokens []string
+	var final *client.ChatResponse
+	err := c.ChatStream(context.Background(), client.ChatRequest{Message: "list venues"}, func(ev client.Event) {
+		switch ev.Type {
+		case "token":
+			if final != nil {
+				t.Errorf("token %q after the final event", ev.Text)
+			}
+			tokens = append(tokens, ev.Text)
+		case "final":
+			final = ev.Response
+		default:
+			t.Errorf("unexpected event %+v", ev)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if final == nil {
+		t.Fatal("no final event")
+	}
+	if len(tokens) == 0 {
+		t.Error("no token events")
+	}
+	if got := strings.Join(tokens, ""); got != final.Answer {
+		t.Errorf("tokens %q do not add up to the final answer %q", got, final.Answer)
+	}
+	if !strings.Contains(final.Answer, "Briggs Hall (12)") {
+		t.Errorf("final answer %q lacks the venue", final.Answer)
+	}
+}
+
+// Failures reach callers as *client.Error with the service's code and
+// status, from plain responses and from stream error events alike.
+func TestClientErrors(t *testing.T) {
+	unauthorized := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(unauthorized.Close)
+	srv := newService(t, &services.ChatService{Gateway: &services.GatewayClient{BaseURL: unauthorized.URL}}, nil)
+
+	cases := []struct {
+		name   string
+		key    string
+		msg    string
+		stream bool
+		code   string
+		status int
+	}{
+		{name: "no key", msg: "list venues", code: "missing_x_api_key", status: http.StatusUnauthorized},
+		{name: "unknown key", key: "nope", msg: "list venues", code: "invalid_x_api_key", status: http.StatusForbidden},
+		{name: "empty message", key: testKey, code: "message_required", status: http.StatusBadRequest},
+		// The answer explains the failure and carries its code.
+		{name: "gateway refuses", key: testKey, msg: "list venues", code: "gateway_auth_failed", status: http.StatusOK},
+		{name: "stream without key", msg: "list venues", stream: true, code: "missing_x_api_key", status: http.StatusUnauthorized},
+		{name: "stream empty message", key: testKey, stream: true, code: "message_required"},
+		{name: "stream gateway refuses", key: testKey, msg: "list venues", stream: true, code: "gateway_auth_failed", status: http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := client.New(srv.URL, tc.key)
+			req := client.ChatRequest{Message: tc.msg}
+			var err error
+			if tc.stream {
+				err = c.ChatStream(context.Background(), req, nil)
+			} else {
+				_, err = c.Chat(context.Background(), req)
+			}
+			var ce *client.Error
+			if !errors.As(err, &ce) {
+				t.Fatalf("error %v (%T), want *client.Error", err, err)
+			}
+			if ce.Code != tc.code || ce.Status != tc.status {
+				t.Errorf("error code=%s status=%d, want code=%s status=%d", ce.Code, ce.Status, tc.code, tc.status)
+			}
+		})
+	}
+}
+
+// testStore opens TEST_DATABASE_URL with a fresh, migrated schema first on
+// the search path. The test is skipped when the variable is unset.
+func testStore(t *testing.T) *store.PostgresStore {
+	t.Helper()
+	dsn := strings.TrimSpace(os.Getenv("TEST_DATABASE_URL"))
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	schema := fmt.Sprintf("client_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() { _, _ = admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`) })
+
+	sep := " "
+	if strings.Contains(dsn, "://") {
+		sep = "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+	}
+	db, err := sql.Open("postgres", dsn+sep+"search_path="+schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	pg := store.NewPostgresStore(db)
+	if err := pg.EnsureSchema(context.Background()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return pg
+}
+
+func TestConversationRoundTrip(t *testing.T) {
+	pg := testStore(t)
+	srv := newService(t, &services.ChatService{Gateway: &services.GatewayClient{BaseURL: venuesGateway(t).URL}}, pg)
+	c := client.New(srv.URL, testKey)
+	ctx := context.Background()
+
+	conv, err := c.CreateConversation(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Chat(ctx, client.ChatRequest{ConversationID: conv.ConversationID, Message: "list venues"}); err != nil {
+		t.Fatal(err)
+	}
+
+	convs, err := c.ListConversations(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(convs) != 1 || convs[0].ConversationID != conv.ConversationID {
+		t.Errorf("conversations %+v, want just %s", convs, conv.ConversationID)
+	}
+	got, err := c.GetConversation(ctx, conv.ConversationID)
+	if err != nil || got.ConversationID != conv.ConversationID {
+		t.Fatalf("GetConversation = %+v, %v", got, err)
+	}
+	msgs, err := c.ListMessages(ctx, conv.ConversationID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].Role != "user" || msgs[1].Role != "assistant" || !strings.Contains(msgs[1].Content, "Briggs Hall") {
+		t.Errorf("messages %+v, want the question and its answer", msgs)
+	}
+
+	var ce *client.Error
+	if _, err := c.GetConversation(ctx, "00000000-0000-0000-0000-000000000000"); !errors.As(err, &ce) || ce.Code != "not_found" || ce.Status != http.StatusNotFound {
+		t.Errorf("unknown conversation: %v, want a not_found *client.Error", err)
+	}
+}
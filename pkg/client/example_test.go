@@ -0,0 +1,63 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"openai-agent-service/pkg/client"
+)
+
+func ExampleClient_Chat() {
+	c := client.New("https://agent.example.com", os.Getenv("AGENT_API_KEY"))
+	c.Timeout = 30 * time.Second
+
+	resp, err := c.Chat(context.Background(), client.ChatRequest{Message: "how many kiosks in moco"})
+	var apiErr *client.Error
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Retryable:
+		log.Printf("try again later: %v", apiErr)
+	case err != nil:
+		log.Fatal(err)
+	}
+	fmt.Println(resp.Answer)
+}
+
+func ExampleClient_ChatStream() {
+	c := client.New("https://agent.example.com", os.Getenv("AGENT_API_KEY"))
+
+	err := c.ChatStream(context.Background(), client.ChatRequest{Message: "top posters in moco"}, func(ev client.Event) {
+		switch ev.Type {
+		case "token":
+			fmt.Print(ev.Text)
+		case "final":
+			fmt.Println()
+			for _, s := range ev.Response.Steps {
+				fmt.Println("step:", s.Tool)
+			}
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func ExampleClient_ListMessages() {
+	c := client.New("https://agent.example.com", os.Getenv("AGENT_API_KEY"))
+	ctx := context.Background()
+
+	convs, err := c.ListConversations(ctx, 5)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, conv := range convs {
+		msgs, err := c.ListMessages(ctx, conv.ConversationID, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s: %d messages\n", conv.Title, len(msgs))
+	}
+}